@@ -3,6 +3,7 @@ package ApiTypes
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"io"
 	"io/fs"
 	"net/http"
@@ -75,6 +76,9 @@ type CommonConfigDef struct {
 	Auth            struct {
 		JWTSecret            string `mapstructure:"jwt_secret"`
 		SessionDurationHours int    `mapstructure:"session_duration_hours"`
+		// Hosts (host or host:port) that login, verify and reset flows
+		// may redirect to besides the home URL
+		AllowedRedirectHosts []string `mapstructure:"allowed_redirect_hosts"`
 	} `mapstructure:"auth"`
 }
 
@@ -117,9 +121,54 @@ type LibConfigDef struct {
 	IDIncValue         int  `mapstructure:"id_inc_value"`
 	AllowDynamicTables bool `mapstructure:"allow_dynamic_tables"`
 
-	SystemTableNames SystemTableNames  `mapstructure:"system_table_names"`
-	SystemIDs        SystemIDs         `mapstructure:"system_ids"`
-	IconServiceConf  IconServiceConfig `mapstructure:"icon_service"`
+	// DefaultTimeZone is the zone timestamps without an offset are read
+	// in, e.g. "America/New_York". Empty means UTC.
+	DefaultTimeZone string `mapstructure:"default_time_zone"`
+
+	// MaxJoins is the most joins a query may have; 0 means the default (4)
+	MaxJoins int `mapstructure:"max_joins"`
+
+	// MaxConditions is the most condition nodes (atomic, and, or, not,
+	// exists, counted through the nesting) a request may have, and
+	// MaxSelectedFields the most fields a query may select; 0 means the
+	// default (200)
+	MaxConditions     int `mapstructure:"max_conditions"`
+	MaxSelectedFields int `mapstructure:"max_selected_fields"`
+
+	// InsertBatchSize is the most records one INSERT statement takes; 0
+	// means as many as the bind parameter limit allows, up to 1000. It is
+	// lowered for wide tables so that the statement stays in the limit.
+	InsertBatchSize int `mapstructure:"insert_batch_size"`
+
+	// InsertCopyThreshold is the number of records above which an insert
+	// into PostgreSQL without ON CONFLICT uses COPY; 0 means the default
+	// (5000) and a negative value turns COPY off
+	InsertCopyThreshold int `mapstructure:"insert_copy_threshold"`
+
+	// SessionIdleTimeoutMinutes ends a login session without a request
+	// for that long, before it expires; 0 means sessions only expire
+	SessionIdleTimeoutMinutes int `mapstructure:"session_idle_timeout_minutes"`
+
+	// EmailSendIntervalSeconds is the least time between two verification
+	// or password reset emails to one address, and EmailSendMaxPerDay the
+	// most of them per address in 24 hours; 0 means the default (60
+	// seconds, 10 emails) and a negative value turns the limit off
+	EmailSendIntervalSeconds int `mapstructure:"email_send_interval_seconds"`
+	EmailSendMaxPerDay       int `mapstructure:"email_send_max_per_day"`
+
+	// AllowUnverifiedLogin lets users who have not verified their email
+	// log in with the right password, as they could before email login
+	// checked it. By default such a login is refused with
+	// CustomHttpStatus_EmailNotVerified.
+	AllowUnverifiedLogin bool `mapstructure:"allow_unverified_login"`
+
+	SystemTableNames SystemTableNames   `mapstructure:"system_table_names"`
+	SystemIDs        SystemIDs          `mapstructure:"system_ids"`
+	IconServiceConf  IconServiceConfig  `mapstructure:"icon_service"`
+	OutlookConf      OutlookConfig      `mapstructure:"outlook"`
+	AttachmentsConf  AttachmentsConfig  `mapstructure:"attachments"`
+	CaptchaConf      CaptchaConfig      `mapstructure:"captcha"`
+	WelcomeEmailConf WelcomeEmailConfig `mapstructure:"welcome_email"`
 }
 
 type SystemTableNames struct {
@@ -148,6 +197,49 @@ type IconServiceConfig struct {
 	IconDataDir       string `mapstructure:"icon_data_dir"`
 }
 
+// AttachmentsConfig configures the attachments module. S3 credentials
+// are read from the ATTACHMENTS_S3_* environment variables only.
+type AttachmentsConfig struct {
+	EnableAttachments string   `mapstructure:"enable_attachments"`
+	Backend           string   `mapstructure:"backend"`
+	DataDir           string   `mapstructure:"data_dir"`
+	MaxSizeMB         int      `mapstructure:"max_size_mb"`
+	AllowedMimeTypes  []string `mapstructure:"allowed_mime_types"`
+}
+
+// CaptchaConfig configures the CAPTCHA required on email signup and
+// password reset. The secret is read from CAPTCHA_SECRET only.
+type CaptchaConfig struct {
+	EnableCaptcha string `mapstructure:"enable_captcha"`
+	// Provider is "hcaptcha" or "turnstile"
+	Provider string `mapstructure:"provider"`
+}
+
+// WelcomeEmailConfig configures the welcome email sent when a user
+// verifies their email for the first time. It is off unless
+// EnableWelcomeEmail is "enabled".
+type WelcomeEmailConfig struct {
+	EnableWelcomeEmail string `mapstructure:"enable_welcome_email"`
+	AppName            string `mapstructure:"app_name"`
+	// GettingStartedURL is linked from the email; empty means APP_BASE_URL
+	GettingStartedURL string `mapstructure:"getting_started_url"`
+	// Subject, TextTemplate and HTMLTemplate override the built-in email.
+	// They are Go templates (text/template, html/template) of
+	// auth.WelcomeEmailData.
+	Subject      string `mapstructure:"subject"`
+	TextTemplate string `mapstructure:"text_template"`
+	HTMLTemplate string `mapstructure:"html_template"`
+}
+
+// OutlookConfig configures the Outlook (Microsoft Graph) mail integration.
+// The client secret is read from OUTLOOK_CLIENT_SECRET only.
+type OutlookConfig struct {
+	EnableWorker string `mapstructure:"enable_worker"`
+	TenantID     string `mapstructure:"tenant_id"`
+	ClientID     string `mapstructure:"client_id"`
+	Scopes       string `mapstructure:"scopes"`
+}
+
 const (
 	UserContextKey  ContextKey = "user_name"
 	TokenContextKey ContextKey = "token"
@@ -190,29 +282,126 @@ type ActivityLogDef struct {
 
 // Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::FieldDef
 type FieldDef struct {
-	FieldName   string `json:"field_name"`
-	DataType    string `json:"data_type"`
-	Required    bool   `json:"required"`
-	ReadOnly    bool   `json:"read_only"`
+	FieldName string `json:"field_name"`
+	DataType  string `json:"data_type"`
+	Required  bool   `json:"required"`
+	ReadOnly  bool   `json:"read_only"`
+
+	// ElementType is the element type of "array" fields: "string",
+	// "int32" or "int64". PostgreSQL stores arrays natively (text[],
+	// int[], bigint[]). MySQL has no arrays, so they are stored in a JSON
+	// column as a JSON array (e.g. ["a","b"] or [1,2]) and decoded back
+	// to arrays by queries.
 	ElementType string `json:"element_type,omitempty"`
 	Desc        string `json:"desc,omitempty"`
+
+	// TimestampKind tells timestamp fields with (TimestampKindTZ) and
+	// without (TimestampKindNaive) a time zone apart. If empty, it
+	// follows DataType: "timestamptz" is TZ, "timestamp" and "datetime"
+	// are naive.
+	TimestampKind string `json:"timestamp_kind,omitempty"`
+
+	// TimestampFormat is the format query results of timestamp and date
+	// fields are returned in: "rfc3339" (the default), "unix", "unix_ms"
+	// or a Go layout such as "2006-01-02 15:04".
+	TimestampFormat string `json:"timestamp_format,omitempty"`
+}
+
+const (
+	TimestampKindTZ    = "tz"
+	TimestampKindNaive = "naive"
+)
+
+// FieldDataType returns the data type values of 'f' are converted for,
+// with TimestampKind applied: timestamp fields become "timestamptz" or
+// "timestamp".
+func FieldDataType(f FieldDef) string {
+	switch f.DataType {
+	case "timestamp", "timestamptz", "datetime":
+		switch f.TimestampKind {
+		case TimestampKindTZ:
+			return "timestamptz"
+		case TimestampKindNaive:
+			return "timestamp"
+		}
+	}
+	return f.DataType
+}
+
+// FieldProblem is one validation failure of a record against its FieldDefs,
+// or a unique or foreign key violation the database reported. RecordIndex
+// is -1 if the database did not tell which record of a batch failed.
+// Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::FieldProblem
+type FieldProblem struct {
+	RecordIndex int    `json:"record_index"`
+	Field       string `json:"field"`
+	Problem     string `json:"problem"`
+}
+
+// QuotaBreach is the Results of a Jimo request rejected with 429 because
+// a quota is used up. ErrorCode is always "quota_exceeded".
+// Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::QuotaBreach
+type QuotaBreach struct {
+	ErrorCode     string `json:"error_code"`
+	ScopeType     string `json:"scope_type"`
+	ScopeName     string `json:"scope_name"`
+	Metric        string `json:"metric"`
+	Limit         int64  `json:"limit"`
+	Used          int64  `json:"used"`
+	RetryAfterSec int    `json:"retry_after_sec"`
+}
+
+// QuotaUsage is the consumption of one quota metric against its limit.
+// A Limit of 0 means unlimited.
+// Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::QuotaUsage
+type QuotaUsage struct {
+	ScopeType string `json:"scope_type"`
+	ScopeName string `json:"scope_name"`
+	Metric    string `json:"metric"`
+	Window    string `json:"window"`
+	Used      int64  `json:"used"`
+	Limit     int64  `json:"limit"`
 }
 
 type JimoRequest struct {
-	RequestType string `json:"request_type"`
+	RequestType  string `json:"request_type"`
+	TableName    string `json:"table_name,omitempty"`
+	ResourceName string `json:"resource_name,omitempty"`
 }
 
 // Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::CondDef
 type CondDef struct {
 	// Atomic condition fields (used if this is an atomic condition)
-	Type      ConditionType `json:"type"` // "atomic", "and", "or", "null"
+	Type      ConditionType `json:"type"` // "atomic", "and", "or", "not", "exists", "not_exists", "null"
 	FieldName string        `json:"field_name,omitempty"`
 	DataType  string        `json:"data_type,omitempty"`
 	Opr       string        `json:"opr,omitempty"`
 	Value     interface{}   `json:"value,omitempty"`
 
+	// FieldNames are the fields the multi_contain operator searches;
+	// FieldName is not used by it
+	FieldNames []string `json:"field_names,omitempty"`
+
 	// Group condition fields (only used if this is a group condition)
 	Conditions []CondDef `json:"conditions,omitempty"` // Nested conditions for groups
+
+	// SubQuery of an exists or not_exists condition
+	SubQuery *SubQueryDef `json:"sub_query,omitempty"`
+}
+
+// SubQueryDef is the sub-query of an exists or not_exists condition:
+//
+//	EXISTS (SELECT 1 FROM <TableName> WHERE <OnClause> AND <Condition>)
+//
+// In OnClause, SourceFieldName is a field of the queried table and
+// JoinedFieldName a field of TableName. Condition is on the fields in
+// FieldDefs and may not have exists conditions itself.
+// Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::SubQueryDef
+type SubQueryDef struct {
+	TableName string        `json:"table_name"`
+	OnClause  []OnClauseDef `json:"on_clause"`
+	FieldDefs []FieldDef    `json:"field_defs"`
+	Condition *CondDef      `json:"condition,omitempty"`
 }
 
 type ConditionType string
@@ -221,7 +410,12 @@ const (
 	ConditionTypeAtomic ConditionType = "atomic"
 	ConditionTypeAnd    ConditionType = "and"
 	ConditionTypeOr     ConditionType = "or"
+	ConditionTypeNot    ConditionType = "not" // Negates its one sub-condition
 	ConditionTypeNull   ConditionType = "null"
+
+	// A row of SubQuery correlates (not_exists: none does) with the row
+	ConditionTypeExists    ConditionType = "exists"
+	ConditionTypeNotExists ConditionType = "not_exists"
 )
 
 const (
@@ -269,6 +463,14 @@ type JoinDef struct {
 	JoinedFieldDefs []FieldDef    `json:"joined_field_defs"`
 	ReadOnly        bool          `json:"read_only"`
 	EmbedName       string        `json:"embed_name"`
+
+	// EmbedAsArray, with EmbedName, returns the joined rows of a
+	// one-to-many join as an array under EmbedName, one result per parent
+	// row, rather than one result per joined row. GroupBy names the
+	// selected fields that identify a parent row (e.g. users.id); the
+	// other selected fields of the parent are grouped on as well.
+	EmbedAsArray bool     `json:"embed_as_array,omitempty"`
+	GroupBy      []string `json:"group_by,omitempty"`
 }
 
 // Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::JimoRequest
@@ -289,30 +491,87 @@ type ResourceRequest struct {
 	Loc                  string                 `json:"loc"`
 }
 
+// PageSizeNoLimit as the PageSize of a QueryRequest returns all the
+// matching rows, without LIMIT. It is honored for trusted server-side
+// callers only (RequestHandlers.WithInternalCaller); from an HTTP request
+// it is an invalid page size like any other negative one.
+const PageSizeNoLimit = -1
+
 // Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::QueryRequest
 type QueryRequest struct {
-	RequestType string       `json:"request_type"`
-	DBName      string       `json:"db_name"`
-	TableName   string       `json:"table_name"`
-	Condition   CondDef      `json:"condition"`
-	JoinDefs    []JoinDef    `json:"join_def"`
-	FieldDefs   []FieldDef   `json:"field_defs"`
-	FieldNames  []string     `json:"field_names"`
-	OrderbyDef  []OrderbyDef `json:"orderby_def"`
-	Start       int          `json:"start"`
-	PageSize    int          `json:"page_size"`
-	Loc         string       `json:"loc"`
+	RequestType  string       `json:"request_type"`
+	ResourceName string       `json:"resource_name,omitempty"`
+	DBName       string       `json:"db_name"`
+	TableName    string       `json:"table_name"`
+	Condition    CondDef      `json:"condition"`
+	JoinDefs     []JoinDef    `json:"join_def"`
+	FieldDefs    []FieldDef   `json:"field_defs"`
+	FieldNames   []string     `json:"field_names"`
+	OrderbyDef   []OrderbyDef `json:"orderby_def"`
+	Start        int          `json:"start"`
+	PageSize     int          `json:"page_size"` // PageSizeNoLimit: all the rows (internal callers only)
+	Sample       int          `json:"sample,omitempty"`
+	WithTotal    bool         `json:"with_total,omitempty"` // Also return the total matching count
+	TimeZone     string       `json:"time_zone,omitempty"`
+	Loc          string       `json:"loc"`
+
+	// IsolationLevel of the transaction of a query WithTotal (one of
+	// IsolationLevel_*; default repeatable_read). It must keep the count
+	// and the page in one snapshot: repeatable_read or serializable.
+	IsolationLevel string `json:"isolation_level,omitempty"`
+
+	// An embed (see JoinDef.EmbedName) whose fields are all null, e.g. of
+	// a LEFT JOIN without a match, is returned as null, or as {} if set
+	EmptyEmbedAsObject bool `json:"empty_embed_as_object,omitempty"`
+
+	// TimeBucket, if set, returns per time bucket aggregates of the
+	// matching rows instead of the rows
+	TimeBucket *TimeBucketDef `json:"time_bucket,omitempty"`
+
+	// Explain, if set, returns the plan of the query instead of running
+	// it (EXPLAIN in JSON format). Admins only.
+	Explain bool `json:"explain,omitempty"`
+}
+
+// TimeBucketDef groups the rows of a query by the start of the interval
+// their FieldName falls in, in the request's time zone (UTC by default).
+// Each bucket has the count of its rows, or the sum of their ValueField.
+// Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::TimeBucketDef
+type TimeBucketDef struct {
+	FieldName  string `json:"field_name"`            // A timestamp field
+	Interval   string `json:"interval"`              // minute, hour, day, week (from Monday) or month
+	Agg        string `json:"agg,omitempty"`         // count (the default) or sum
+	ValueField string `json:"value_field,omitempty"` // The numeric field summed
+	FillGaps   bool   `json:"fill_gaps,omitempty"`   // Add empty buckets between the first and the last
+}
+
+const (
+	TimeBucketAgg_Count = "count"
+	TimeBucketAgg_Sum   = "sum"
+)
+
+// ExportCSVRequest is a QueryRequest whose results are exported as CSV.
+// Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::ExportCSVRequest
+type ExportCSVRequest struct {
+	QueryRequest
+	Delimiter string `json:"delimiter,omitempty"` // One character, default ","
+	NoHeader  bool   `json:"no_header,omitempty"` // Omit the header row
 }
 
 // Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::InsertRequest
 type InsertRequest struct {
 	RequestType          string                   `json:"request_type"`
+	ResourceName         string                   `json:"resource_name,omitempty"`
 	DBName               string                   `json:"db_name"`
 	TableName            string                   `json:"table_name"`
 	Records              []map[string]interface{} `json:"records,omitempty"`
 	FieldDefs            []FieldDef               `json:"field_defs"`
 	OnConflictCols       []string                 `json:"on_conflict_cols"`
 	OnConflictUpdateCols []string                 `json:"on_conflict_update_cols"`
+	StrictFields         bool                     `json:"strict_fields,omitempty"`
+	TimeZone             string                   `json:"time_zone,omitempty"`
+	IdempotencyKey       string                   `json:"idempotency_key,omitempty"`
+	IsolationLevel       string                   `json:"isolation_level,omitempty"` // Of the insert's transaction (IsolationLevel_*)
 	Loc                  string                   `json:"loc"`
 }
 
@@ -323,22 +582,44 @@ type UpdateRequest struct {
 	TableName            string                 `json:"table_name"`
 	Condition            CondDef                `json:"condition"`
 	Record               map[string]interface{} `json:"record"`
-	UpdateEntries        []UpdateDef            `json:"update_def,omitempty"`
+	UpdateEntries        []UpdateDef            `json:"update_entries,omitempty"`
 	FieldDefs            []FieldDef             `json:"field_defs"`
 	OnConflictCols       []string               `json:"on_conflict_cols"`
 	OnConflictUpdateCols []string               `json:"on_conflict_update_cols"`
 	NeedRecord           bool                   `json:"need_record"`
+	StrictFields         bool                   `json:"strict_fields,omitempty"`
+	TimeZone             string                 `json:"time_zone,omitempty"`
 	Loc                  string                 `json:"loc"`
 }
 
 // Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::DeleteRequest
 type DeleteRequest struct {
-	RequestType string     `json:"request_type"`
-	DBName      string     `json:"db_name"`
-	TableName   string     `json:"table_name"`
-	Condition   CondDef    `json:"condition"`
+	RequestType string       `json:"request_type"`
+	DBName      string       `json:"db_name"`
+	TableName   string       `json:"table_name"`
+	Condition   CondDef      `json:"condition"`
+	FieldDefs   []FieldDef   `json:"field_defs"`
+	Cascade     []CascadeDef `json:"cascade,omitempty"`
+	Loc         string       `json:"loc"`
+
+	// ExpectedMaxRows, if set, refuses the delete, returning the number
+	// of matching rows, if it matches more rows than that
+	ExpectedMaxRows int `json:"expected_max_rows,omitempty"`
+
+	// ConfirmDeleteAll deletes all the rows of the table. It takes no
+	// condition; without it, a delete must have one.
+	ConfirmDeleteAll bool `json:"confirm_delete_all,omitempty"`
+}
+
+// CascadeDef is a child table whose rows are deleted, before the parent
+// rows, when their FKField matches the ParentField of a parent row being
+// deleted. FieldDefs are the child's fields; FKField must be one of them.
+// Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::CascadeDef
+type CascadeDef struct {
+	Table       string     `json:"table"`
+	FKField     string     `json:"fk_field"`
+	ParentField string     `json:"parent_field"`
 	FieldDefs   []FieldDef `json:"field_defs"`
-	Loc         string     `json:"loc"`
 }
 
 func IsValidDBType(db_type string) bool {
@@ -353,16 +634,23 @@ type AddPromptResponse struct {
 
 // Make sure it syncs with svelte/src/lib/types/CommonTypes.ts::JimoResponse
 type JimoResponse struct {
-	Status     bool        `json:"status"`
-	ErrorMsg   string      `json:"error_msg"`
-	ReqID      string      `json:"req_id"`
-	ResultType string      `json:"result_type"`
-	NumRecords int         `json:"num_records"`
-	TableName  string      `json:"table_name"`
-	BaseURL    string      `json:"base_url,omitempty"`
-	Results    interface{} `json:"results"`
-	ErrorCode  int         `json:"error_code"`
-	Loc        string      `json:"loc,omitempty"`
+	Status       bool                   `json:"status"`
+	ErrorMsg     string                 `json:"error_msg"`
+	ErrorKind    ErrorKind              `json:"error_kind,omitempty"` // Set on failures; stable, unlike ErrorMsg
+	ReqID        string                 `json:"req_id"`
+	ResultType   string                 `json:"result_type"`
+	NumRecords   int                    `json:"num_records"`
+	TotalRecords *int64                 `json:"total_records,omitempty"` // Matching rows regardless of paging (with_total queries)
+	Page         int                    `json:"page,omitempty"`          // Page of an offset-paginated query, from 1
+	PageSize     int                    `json:"page_size,omitempty"`     // Page size of an offset-paginated query
+	TotalPages   *int64                 `json:"total_pages,omitempty"`   // Pages of TotalRecords (with_total queries)
+	HasMore      *bool                  `json:"has_more,omitempty"`      // Whether there is a next page
+	TableName    string                 `json:"table_name"`
+	BaseURL      string                 `json:"base_url,omitempty"`
+	Results      interface{}            `json:"results"`
+	ErrorCode    int                    `json:"error_code"`
+	Meta         map[string]interface{} `json:"meta,omitempty"`
+	Loc          string                 `json:"loc,omitempty"`
 }
 
 type ResourceDef struct {
@@ -380,10 +668,16 @@ type ResourceDef struct {
 	QueryCondsJSON  map[string]interface{} `json:"query_conds"`
 }
 
+// ResourceStoreDef is the parsed, cached form of a ResourceDef.
+// FieldDefs, SelectedFields and the on-conflict columns come from the
+// "field_defs", "selected_fields", "on_conflict_cols" and
+// "on_conflict_update_cols" attributes of ResourceDef.ResourceJSON.
 type ResourceStoreDef struct {
-	ResourceDef    ResourceDef
-	FieldDefs      []FieldDef
-	SelectedFields []FieldDef
+	ResourceDef          ResourceDef
+	FieldDefs            []FieldDef
+	SelectedFields       []FieldDef
+	OnConflictCols       []string
+	OnConflictUpdateCols []string
 }
 
 // Event Related types
@@ -430,33 +724,31 @@ type UserAccount struct {
 // Make sure this struct syncs with Shared/svelte/src/lib/types/CommonTypes.ts::UserInfo
 // SECURITY: Sensitive fields use json:"-" to prevent exposure in API responses
 type UserInfo struct {
-	UserId                string    `json:"id"`
-	UserName              string    `json:"name"`
-	Password              string    `json:"-"` // SECURITY: Never expose password hash in API responses
-	UserIdType            string    `json:"user_id_type"`
-	FirstName             string    `json:"first_name"`
-	LastName              string    `json:"last_name"`
-	Email                 string    `json:"email"`
-	UserMobile            string    `json:"user_mobile,omitempty"`
-	UserAddress           string    `json:"user_address"`
-	Verified              bool      `json:"verified"`
-	Admin                 bool      `json:"admin"`
-	IsOwner               bool      `json:"is_owner"`
-	Roles                 []string  `json:"roles,omitempty"`
-	EmailVisibility       bool      `json:"email_visibility"`
-	AuthType              string    `json:"auth_type"`
-	UserStatus            string    `json:"user_status"`
-	Avatar                string    `json:"avatar"`
-	Locale                string    `json:"locale"`
-	OutlookRefreshToken   string    `json:"outlook_refresh_token"` // SECURITY: Never expose OAuth tokens in API responses
-	OutlookAccessToken    string    `json:"outlook_access_token"`  // SECURITY: Never expose OAuth tokens in API responses
-	OutlookTokenExpiresAt time.Time `json:"outlook_token_expires_at"`
-	OutlookSubID          string    `json:"outlook_sub_id"`
-	OutlookSubExpiresAt   time.Time `json:"outlook_sub_expires_at"`
-	VToken                string    `json:"-"` // SECURITY: Never expose verification tokens in API responses
-	VTokenExpiresAt       time.Time `json:"v_token_expires_at"`
-	Created               time.Time `json:"created"`
-	Updated               time.Time `json:"updated"`
+	UserId            string     `json:"id"`
+	UserName          string     `json:"name"`
+	Password          string     `json:"-"` // SECURITY: Never expose password hash in API responses
+	UserIdType        string     `json:"user_id_type"`
+	FirstName         string     `json:"first_name"`
+	LastName          string     `json:"last_name"`
+	Email             string     `json:"email"`
+	UserMobile        string     `json:"user_mobile,omitempty"`
+	UserAddress       string     `json:"user_address"`
+	Verified          bool       `json:"verified"`
+	Admin             bool       `json:"admin"`
+	IsOwner           bool       `json:"is_owner"`
+	Roles             []string   `json:"roles,omitempty"`
+	EmailVisibility   bool       `json:"email_visibility"`
+	AuthType          string     `json:"auth_type"`
+	UserStatus        string     `json:"user_status"`
+	Avatar            string     `json:"avatar"`
+	Locale            string     `json:"locale"`
+	VToken            string     `json:"-"` // SECURITY: Never expose verification tokens in API responses
+	VTokenExpiresAt   time.Time  `json:"v_token_expires_at"`
+	LastLoginAt       *time.Time `json:"last_login_at,omitempty"`       // Last login or email verification
+	LastActivityAt    *time.Time `json:"last_activity_at,omitempty"`    // Last request of any of the user's sessions (last seen)
+	PasswordExpiresAt *time.Time `json:"password_expires_at,omitempty"` // When a temporary password stops working
+	Created           time.Time  `json:"created"`
+	Updated           time.Time  `json:"updated"`
 }
 
 // Make sure this struct syncs with tax/web/src/lib/pocketbase-types.ts::UsersRecord
@@ -487,6 +779,11 @@ type JimoLogger interface {
 	Close()
 }
 
+// ErrTokenConflict is returned by RequestContext.UpsertUser and
+// UpdateTokenByEmail when the new v_token is already another user's.
+// Tokens are random, so the caller retries with a new one.
+var ErrTokenConflict = errors.New("token already in use")
+
 // RequestContext is a framework-agnostic wrapper for request-scoped data
 type RequestContext interface {
 	// Context returns the underlying Go context (for deadlines, cancellation, values)
@@ -510,15 +807,23 @@ type RequestContext interface {
 	GetRequest() *http.Request
 	Bind(v interface{}) error
 	QueryParam(key string) string
+	// PathParam returns the named path parameter (e.g. 'id' in /items/:id)
+	PathParam(name string) string
 	GetUserInfoByEmail(email string) (*UserInfo, bool)
 	GetUserInfoByToken(token string) (*UserInfo, bool)
 	GetUserInfoByAppToken(token_name string, token string) (*UserInfo, bool)
 	GetUserInfoByUserID(user_id string) (*UserInfo, bool)
-	MarkUserVerified(email string) error
+	GetUserInfoByUserName(user_name string) (*UserInfo, bool)
+	// MarkUserVerified verifies the user and reports whether this call
+	// did, false if the user was verified already
+	MarkUserVerified(email string) (bool, error)
 	UpdateTokenByEmail(email string, token string) error
 	UpdateAppTokenByEmail(email string, token_name string, token string) error
 	VerifyUserPassword(userInfo *UserInfo, plaintextPassword string) (bool, int, string)
 	UpdatePassword(email string, plaintextPassword string) (bool, int, string)
+	// SetTemporaryPassword sets a password, like UpdatePassword, that
+	// stops working at 'expires_at', until UpdatePassword sets a new one
+	SetTemporaryPassword(email string, plaintextPassword string, expires_at time.Time) (bool, int, string)
 	SendHTMLResp(html_str string) error
 	SendJSONResp(status_code int, json_resp map[string]interface{}) error
 	JSON(status_code int, json_resp map[string]interface{}) error
@@ -0,0 +1,47 @@
+package ApiTypes
+
+import "time"
+
+// AttachmentDef is a row of the attachments table: a user-uploaded file
+// attached to the record owner_id of owner_table.
+// Make sure it syncs with shared/svelte/src/lib/types/AttachmentTypes.ts::AttachmentDef
+type AttachmentDef struct {
+	ID             string    `json:"id"`
+	OwnerTable     string    `json:"owner_table"`
+	OwnerID        string    `json:"owner_id"`
+	FileName       string    `json:"file_name"`
+	MimeType       string    `json:"mime_type"`
+	Size           int64     `json:"size"`
+	StorageBackend string    `json:"storage_backend"`
+	StorageKey     string    `json:"-"`
+	SHA256         string    `json:"sha256"`
+	Creator        string    `json:"creator"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Access kinds passed to AttachmentAccessFunc
+const (
+	AttachmentAccess_Read   = "read"
+	AttachmentAccess_Write  = "write"
+	AttachmentAccess_Delete = "delete"
+)
+
+// AttachmentAccessFunc decides whether 'user' may read, write (upload to)
+// or delete the attachments of the record owner_id of owner_table. The
+// host app sets it with attachments.SetAccessChecker.
+type AttachmentAccessFunc func(
+	rc RequestContext,
+	user *UserInfo,
+	owner_table string,
+	owner_id string,
+	access string) bool
+
+// AttachmentSweepResult is the outcome of an orphan sweep
+// Make sure it syncs with shared/svelte/src/lib/types/AttachmentTypes.ts::AttachmentSweepResult
+type AttachmentSweepResult struct {
+	// ObjectsDeleted: storage objects without a metadata row
+	ObjectsDeleted int `json:"objects_deleted"`
+	// RowsDeleted: metadata rows whose storage object is missing
+	RowsDeleted int  `json:"rows_deleted"`
+	DryRun      bool `json:"dry_run"`
+}
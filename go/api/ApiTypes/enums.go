@@ -1,27 +1,38 @@
 package ApiTypes
 
 const (
+	ActivityType_AdminResetPassword    string = "admin_reset_password"
+	ActivityType_AdminVerifyUser       string = "admin_verify_user"
 	ActivityType_AuthSuccess           string = "auth_success"
 	ActivityType_AuthFailure           string = "auth_failure"
 	ActivityType_BadRequest            string = "bad_request"
 	ActivityType_ConfigError           string = "config_error"
 	ActivityType_BadEmail              string = "bad_email"
 	ActivityType_DatabaseError         string = "db_error"
+	ActivityType_EmailThrottled        string = "email_throttled"
 	ActivityType_Failed                string = "failed"
 	ActivityType_GitHubAuth            string = "github_auth"
 	ActivityType_InvalidPassword       string = "invalid_password"
 	ActivityType_InvalidToken          string = "invalid_token"
 	ActivityType_InvalidEmail          string = "invalid_email"
 	ActivityType_InternalError         string = "internal_error"
+	ActivityType_IntegrationDisabled   string = "integration_disabled"
+	ActivityType_IntegrationFailure    string = "integration_failure"
 	ActivityType_MissHomeURL           string = "miss_home_url"
 	ActivityType_RequestSuccess        string = "request_success"
 	ActivityType_Redirect              string = "redirect"
 	ActivityType_SetCookie             string = "set_cookie"
 	ActivityType_SentEmail             string = "sent_email"
 	ActivityType_SignupSuccess         string = "signup_success"
+	ActivityType_TwoFactorEnabled      string = "two_factor_enabled"
+	ActivityType_TwoFactorFailure      string = "two_factor_failure"
+	ActivityType_TwoFactorReset        string = "two_factor_reset"
 	ActivityType_Success               string = "success"
 	ActivityType_UnverifiedEmail       string = "unverified_email"
 	ActivityType_UserCreated           string = "user_created"
+	ActivityType_UserDeactivated       string = "user_deactivated"
+	ActivityType_UserReactivated       string = "user_reactivated"
+	ActivityType_UserPurged            string = "user_purged"
 	ActivityType_UserLoginSuccess      string = "user_login_success"
 	ActivityType_UserNotAuthed         string = "user_not_authed"
 	ActivityType_UserNotFound          string = "user_not_found"
@@ -31,6 +42,7 @@ const (
 	ActivityType_UserPending           string = "user_pending"
 	ActivityType_VerifyEmailSuccess    string = "verify_email_success"
 	ActivityType_PasswordUpdateFailure string = "password_update_failure"
+	ActivityType_QuotaExceeded         string = "quota_exceeded"
 	ActivityType_WeakPassword          string = "weak_password"
 )
 
@@ -40,6 +52,8 @@ const (
 	ActivityName_JimoRequest       string = "jimo_request"
 	ActivityName_Query             string = "query"
 	ActivityName_LoadResourceStore string = "load_resource_store"
+	ActivityName_SaveResource      string = "save_resource"
+	ActivityName_Outlook           string = "outlook"
 )
 
 const (
@@ -47,6 +61,7 @@ const (
 	AppName_SysDataStore   string = "sys_data_store"
 	AppName_RequestHandler string = "request_handler"
 	AppName_Stores         string = "stores"
+	AppName_Outlook        string = "outlook"
 )
 
 const (
@@ -58,6 +73,9 @@ const (
 	ModuleName_PromptStore    string = "prompt_store"
 	ModuleName_RequestHandler string = "request_handler"
 	ModuleName_ResourceStore  string = "resource_store"
+	ModuleName_Users          string = "users"
+	ModuleName_TwoFactor      string = "two_factor"
+	ModuleName_OutlookWorker  string = "outlook_worker"
 )
 
 const (
@@ -71,6 +89,16 @@ const (
 	ReqAction_Delete string = "delete"
 )
 
+// Transaction isolation levels a request may ask for. PostgreSQL runs
+// read_uncommitted as read_committed, so only MySQL accepts it.
+// Make sure sync the changes to src/lib/types/CommonTypes.ts
+const (
+	IsolationLevel_ReadUncommitted string = "read_uncommitted"
+	IsolationLevel_ReadCommitted   string = "read_committed"
+	IsolationLevel_RepeatableRead  string = "repeatable_read"
+	IsolationLevel_Serializable    string = "serializable"
+)
+
 const (
 	MysqlName = "mysql" // ✅ exported
 	PgName    = "pg"    // ✅ exported
@@ -80,6 +108,18 @@ const (
 	ResourceType_Table string = "table"
 )
 
+// Values of users.user_status
+const (
+	UserStatus_Active   string = "active"
+	UserStatus_Disabled string = "disabled"
+)
+
+const (
+	ResourceStatus_Active    string = "active"
+	ResourceStatus_Deleted   string = "deleted"
+	ResourceStatus_Suspended string = "suspended"
+)
+
 // Make sure sync the changes to src/lib/types/CommonTypes.ts
 const (
 	CustomHttpStatus_Success           int = 550
@@ -91,6 +131,26 @@ const (
 	CustomHttpStatus_KeyNotUnique      int = 556
 	CustomHttpStatus_NotLoggedIn       int = 557
 	CustomHttpStatus_PasswordNotSet    int = 558
+	CustomHttpStatus_TwoFactorRequired int = 559
+	CustomHttpStatus_EmailNotVerified  int = 560
+	CustomHttpStatus_AccountDisabled   int = 561
+)
+
+// ErrorKind classifies a failed JimoResponse so clients can branch on it
+// rather than on ErrorMsg, which is for humans and may change.
+// Make sure sync the changes to src/lib/types/CommonTypes.ts
+type ErrorKind string
+
+const (
+	ErrorKind_InvalidRequest   ErrorKind = "invalid_request"   // Malformed or unsupported request
+	ErrorKind_NotAuthenticated ErrorKind = "not_authenticated" // No or expired session
+	ErrorKind_NotFound         ErrorKind = "not_found"         // Unknown resource
+	ErrorKind_ValidationFailed ErrorKind = "validation_failed" // Records or field values rejected
+	ErrorKind_QuotaExceeded    ErrorKind = "quota_exceeded"
+	ErrorKind_DBError          ErrorKind = "db_error"
+	ErrorKind_Timeout          ErrorKind = "timeout"  // The database query timed out
+	ErrorKind_Conflict         ErrorKind = "conflict" // Idempotency key in use or reused for another request
+	ErrorKind_InternalError    ErrorKind = "internal_error"
 )
 
 // Resource Operators
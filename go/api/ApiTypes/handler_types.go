@@ -0,0 +1,105 @@
+package ApiTypes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// ResponseKind tells the framework wrappers how to serialize a ResponsePayload
+type ResponseKind string
+
+const (
+	ResponseKind_JSON     ResponseKind = "json"
+	ResponseKind_Text     ResponseKind = "text"
+	ResponseKind_HTML     ResponseKind = "html"
+	ResponseKind_Redirect ResponseKind = "redirect"
+	ResponseKind_Stream   ResponseKind = "stream"
+)
+
+// ResponsePayload is the framework-agnostic result of a HandlerFunc.
+// Body is used for JSON; Text holds the text/HTML body or the redirect URL;
+// Stream is copied to the client, then closed (nil sends no body).
+// Header holds extra response headers, for any kind.
+type ResponsePayload struct {
+	Kind   ResponseKind
+	Body   interface{}
+	Text   string
+	Stream io.ReadCloser
+	Header http.Header
+}
+
+// HandlerFunc is the framework-agnostic handler signature. Handlers written in
+// this form are adapted to Echo (EchoFactory.WrapEcho) or to net/http-based
+// routers such as Pocketbase (EchoFactory.WrapHTTP), so that each handler
+// exists exactly once.
+type HandlerFunc func(rc RequestContext) (int, ResponsePayload)
+
+func JSONPayload(body interface{}) ResponsePayload {
+	return ResponsePayload{Kind: ResponseKind_JSON, Body: body}
+}
+
+func TextPayload(text string) ResponsePayload {
+	return ResponsePayload{Kind: ResponseKind_Text, Text: text}
+}
+
+func HTMLPayload(html string) ResponsePayload {
+	return ResponsePayload{Kind: ResponseKind_HTML, Text: html}
+}
+
+func RedirectPayload(url string) ResponsePayload {
+	return ResponsePayload{Kind: ResponseKind_Redirect, Text: url}
+}
+
+func StreamPayload(header http.Header, stream io.ReadCloser) ResponsePayload {
+	return ResponsePayload{Kind: ResponseKind_Stream, Stream: stream, Header: header}
+}
+
+// WriteHTTP serializes a payload onto a plain net/http response.
+// For redirects, 'status_code' must be a 3xx code.
+func WriteHTTP(
+	w http.ResponseWriter,
+	r *http.Request,
+	status_code int,
+	payload ResponsePayload) error {
+	for key, values := range payload.Header {
+		w.Header()[key] = values
+	}
+
+	switch payload.Kind {
+	case ResponseKind_Redirect:
+		http.Redirect(w, r, payload.Text, status_code)
+		return nil
+
+	case ResponseKind_Stream:
+		w.WriteHeader(status_code)
+		return copyStream(w, payload.Stream)
+
+	case ResponseKind_HTML:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status_code)
+		_, err := w.Write([]byte(payload.Text))
+		return err
+
+	case ResponseKind_Text:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status_code)
+		_, err := w.Write([]byte(payload.Text))
+		return err
+
+	default:
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status_code)
+		return json.NewEncoder(w).Encode(payload.Body)
+	}
+}
+
+// copyStream sends a ResponseKind_Stream body and closes it
+func copyStream(w io.Writer, stream io.ReadCloser) error {
+	if stream == nil {
+		return nil
+	}
+	defer stream.Close()
+	_, err := io.Copy(w, stream)
+	return err
+}
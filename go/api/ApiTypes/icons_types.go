@@ -105,8 +105,23 @@ type IconService interface {
 
 	// DeleteIconFileByPath deletes icon file using service
 	DeleteIconFile(rc RequestContext, category string, fileName string) error
+
+	// GenerateThumbnail writes a resized copy of the icon (longest side <= maxDim)
+	// next to the original and returns its relative path and size in bytes.
+	// Each maxDim has its own file. An existing thumbnail newer than the
+	// original is reused unless force is true.
+	GenerateThumbnail(rc RequestContext, icon *IconDef, maxDim int, force bool) (string, int64, error)
+
+	// DeleteThumbnails removes the thumbnails of an icon, of every size
+	DeleteThumbnails(rc RequestContext, icon *IconDef) error
 }
 
+// Default and upper bound for the longest side of generated icon thumbnails
+const (
+	DefaultIconThumbnailDim = 64
+	MaxIconThumbnailDim     = 512
+)
+
 // DefaultIconService is the singleton instance (set during initialization)
 var DefaultIconService IconService
 
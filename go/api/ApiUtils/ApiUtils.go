@@ -38,6 +38,7 @@ func GenerateSecureToken(length int) string {
 const (
 	EmailTypeGeneric      = "generic"      // Default, wrapped in basic layout
 	EmailTypeVerification = "verification" // Email verification with CTA button
+	EmailTypeWelcome      = "welcome"      // Welcome email after the first verification
 )
 
 // EmailSenderFunc is the signature for custom email sender functions.
@@ -653,6 +654,15 @@ func LoadLibConfig(loc string) {
 			slog.Error("unable to decode config (SHD_LMG_064)", "error", err)
 			os.Exit(1)
 		}
+
+		if zone := ApiTypes.LibConfig.DefaultTimeZone; zone != "" {
+			time_zone, err := LoadTimeZone(zone)
+			if err != nil {
+				slog.Error("invalid default_time_zone (SHD_LMG_066)", "error", err)
+				os.Exit(1)
+			}
+			SetDefaultTimeZone(time_zone)
+		}
 		slog.Info("Loading config success (SHD_LMG_564)")
 	})
 }
@@ -0,0 +1,176 @@
+package ApiUtils
+
+// Password hashing. Passwords are stored as bcrypt hashes; the cost is
+// configurable so it can be raised as hardware gets faster. With a pepper
+// set, the password is first HMAC-SHA256'd with it. The pepper is a
+// secret held outside the database (environment or secret store), so a
+// leaked users table alone is not enough to brute-force the hashes.
+//
+// Peppered hashes carry the pepperedHashPrefix, so hashes made before the
+// pepper was set, or with another cost, keep verifying. VerifyPassword
+// reports them as needing a rehash; the login that proved the password
+// replaces them.
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bounds of the bcrypt cost. Below 10 hashes are too cheap to brute-force;
+// above 15 a hash takes seconds, and so does every login.
+const (
+	MinBcryptCost = bcrypt.DefaultCost
+	MaxBcryptCost = 15
+)
+
+// pepperedHashPrefix marks the hash of a peppered password
+const pepperedHashPrefix = "$hmac$"
+
+// ErrPasswordMismatch is returned by VerifyPassword for a wrong password
+var ErrPasswordMismatch = bcrypt.ErrMismatchedHashAndPassword
+
+// PasswordHashConfig configures HashPassword and VerifyPassword
+type PasswordHashConfig struct {
+	// Cost is the bcrypt cost of new hashes
+	Cost int
+	// Pepper is the HMAC key applied to passwords before hashing. Empty
+	// means no pepper.
+	Pepper string
+}
+
+// Validate checks the cost is within MinBcryptCost and MaxBcryptCost
+func (cfg PasswordHashConfig) Validate() error {
+	if cfg.Cost < MinBcryptCost || cfg.Cost > MaxBcryptCost {
+		return fmt.Errorf("bcrypt cost %d out of range %d-%d (SHD_PWH_056)",
+			cfg.Cost, MinBcryptCost, MaxBcryptCost)
+	}
+	return nil
+}
+
+// LoadPasswordHashConfig builds the config from the environment:
+//
+//   - PASSWORD_BCRYPT_COST: the bcrypt cost, default bcrypt.DefaultCost
+//   - PASSWORD_PEPPER: the pepper, default none
+func LoadPasswordHashConfig() (PasswordHashConfig, error) {
+	cfg := PasswordHashConfig{
+		Cost:   bcrypt.DefaultCost,
+		Pepper: os.Getenv("PASSWORD_PEPPER"),
+	}
+	if value := strings.TrimSpace(os.Getenv("PASSWORD_BCRYPT_COST")); value != "" {
+		cost, err := strconv.Atoi(value)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid PASSWORD_BCRYPT_COST %q (SHD_PWH_073)", value)
+		}
+		cfg.Cost = cost
+	}
+	return cfg, cfg.Validate()
+}
+
+var (
+	passwordHashMu      sync.RWMutex
+	passwordHashConfig  *PasswordHashConfig
+	passwordHashLoadErr error
+)
+
+// SetPasswordHashConfig replaces the password hash configuration. Without
+// it, LoadPasswordHashConfig is used on first use.
+func SetPasswordHashConfig(cfg PasswordHashConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	passwordHashMu.Lock()
+	defer passwordHashMu.Unlock()
+	passwordHashConfig = &cfg
+	passwordHashLoadErr = nil
+	return nil
+}
+
+func getPasswordHashConfig() (PasswordHashConfig, error) {
+	passwordHashMu.RLock()
+	if passwordHashConfig != nil {
+		defer passwordHashMu.RUnlock()
+		return *passwordHashConfig, passwordHashLoadErr
+	}
+	passwordHashMu.RUnlock()
+
+	passwordHashMu.Lock()
+	defer passwordHashMu.Unlock()
+	if passwordHashConfig == nil {
+		cfg, err := LoadPasswordHashConfig()
+		passwordHashConfig = &cfg
+		passwordHashLoadErr = err
+	}
+	return *passwordHashConfig, passwordHashLoadErr
+}
+
+// pepperPassword returns the HMAC of 'password' keyed with 'pepper',
+// base64 encoded: 44 bytes, within bcrypt's 72-byte limit.
+func pepperPassword(pepper string, password string) []byte {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(password))
+	sum := mac.Sum(nil)
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(sum)))
+	base64.StdEncoding.Encode(encoded, sum)
+	return encoded
+}
+
+// HashPassword returns the hash of 'password' to store, made with the
+// configured cost and pepper.
+func HashPassword(password string) (string, error) {
+	cfg, err := getPasswordHashConfig()
+	if err != nil {
+		return "", err
+	}
+
+	if cfg.Pepper == "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), cfg.Cost)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash password (SHD_PWH_139): %w", err)
+		}
+		return string(hash), nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(pepperPassword(cfg.Pepper, password), cfg.Cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password (SHD_PWH_146): %w", err)
+	}
+	return pepperedHashPrefix + string(hash), nil
+}
+
+// VerifyPassword checks 'password' against the stored 'hash'. It returns
+// nil if it matches, ErrPasswordMismatch if not. needs_rehash is true if
+// the password matches but 'hash' was not made with the configured cost
+// and pepper; the caller should store HashPassword(password) instead.
+func VerifyPassword(hash string, password string) (needs_rehash bool, err error) {
+	cfg, err := getPasswordHashConfig()
+	if err != nil {
+		return false, err
+	}
+
+	bcrypt_hash, peppered := strings.CutPrefix(hash, pepperedHashPrefix)
+	input := []byte(password)
+	if peppered {
+		if cfg.Pepper == "" {
+			return false, errors.New("password hash is peppered but no pepper is configured (SHD_PWH_165)")
+		}
+		input = pepperPassword(cfg.Pepper, password)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(bcrypt_hash), input); err != nil {
+		return false, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(bcrypt_hash))
+	if err != nil {
+		return false, err
+	}
+	return peppered != (cfg.Pepper != "") || cost != cfg.Cost, nil
+}
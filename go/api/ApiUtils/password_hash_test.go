@@ -0,0 +1,113 @@
+package ApiUtils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// setPasswordHashConfig sets 'cfg' for the test, restoring the previous
+// config after it
+func setPasswordHashConfig(t *testing.T, cfg PasswordHashConfig) {
+	t.Helper()
+	passwordHashMu.RLock()
+	saved, savedErr := passwordHashConfig, passwordHashLoadErr
+	passwordHashMu.RUnlock()
+	t.Cleanup(func() {
+		passwordHashMu.Lock()
+		passwordHashConfig, passwordHashLoadErr = saved, savedErr
+		passwordHashMu.Unlock()
+	})
+	if err := SetPasswordHashConfig(cfg); err != nil {
+		t.Fatalf("SetPasswordHashConfig: %v", err)
+	}
+}
+
+func mustHashPassword(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	return hash
+}
+
+func TestPasswordHash(t *testing.T) {
+	const password = "correct horse battery staple"
+
+	t.Run("no pepper", func(t *testing.T) {
+		setPasswordHashConfig(t, PasswordHashConfig{Cost: MinBcryptCost})
+		hash := mustHashPassword(t, password)
+		if strings.HasPrefix(hash, pepperedHashPrefix) {
+			t.Errorf("hash %q is marked peppered", hash)
+		}
+		if rehash, err := VerifyPassword(hash, password); err != nil || rehash {
+			t.Errorf("VerifyPassword = %v, %v; want false, nil", rehash, err)
+		}
+		if _, err := VerifyPassword(hash, "wrong"); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("VerifyPassword(wrong) = %v, want ErrPasswordMismatch", err)
+		}
+	})
+
+	t.Run("pepper", func(t *testing.T) {
+		setPasswordHashConfig(t, PasswordHashConfig{Cost: MinBcryptCost})
+		old_hash := mustHashPassword(t, password)
+
+		setPasswordHashConfig(t, PasswordHashConfig{Cost: MinBcryptCost, Pepper: "secret"})
+		hash := mustHashPassword(t, password)
+		if !strings.HasPrefix(hash, pepperedHashPrefix) {
+			t.Errorf("hash %q is not marked peppered", hash)
+		}
+		if rehash, err := VerifyPassword(hash, password); err != nil || rehash {
+			t.Errorf("VerifyPassword = %v, %v; want false, nil", rehash, err)
+		}
+
+		// Hashes made before the pepper was set still verify, and are
+		// to be rehashed
+		if rehash, err := VerifyPassword(old_hash, password); err != nil || !rehash {
+			t.Errorf("VerifyPassword(old hash) = %v, %v; want true, nil", rehash, err)
+		}
+
+		setPasswordHashConfig(t, PasswordHashConfig{Cost: MinBcryptCost, Pepper: "other"})
+		if _, err := VerifyPassword(hash, password); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("VerifyPassword(other pepper) = %v, want ErrPasswordMismatch", err)
+		}
+
+		setPasswordHashConfig(t, PasswordHashConfig{Cost: MinBcryptCost})
+		if _, err := VerifyPassword(hash, password); err == nil || errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("VerifyPassword(no pepper) = %v, want a config error", err)
+		}
+	})
+
+	t.Run("cost", func(t *testing.T) {
+		setPasswordHashConfig(t, PasswordHashConfig{Cost: MinBcryptCost})
+		hash := mustHashPassword(t, password)
+
+		setPasswordHashConfig(t, PasswordHashConfig{Cost: MinBcryptCost + 1})
+		if rehash, err := VerifyPassword(hash, password); err != nil || !rehash {
+			t.Errorf("VerifyPassword(lower cost) = %v, %v; want true, nil", rehash, err)
+		}
+
+		for _, cost := range []int{MinBcryptCost - 1, MaxBcryptCost + 1} {
+			if err := SetPasswordHashConfig(PasswordHashConfig{Cost: cost}); err == nil {
+				t.Errorf("SetPasswordHashConfig(cost %d) succeeded, want an error", cost)
+			}
+		}
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv("PASSWORD_PEPPER", "secret")
+		t.Setenv("PASSWORD_BCRYPT_COST", "12")
+		cfg, err := LoadPasswordHashConfig()
+		if err != nil || cfg.Cost != 12 || cfg.Pepper != "secret" {
+			t.Errorf("LoadPasswordHashConfig = %+v, %v", cfg, err)
+		}
+
+		for _, value := range []string{"high", "4", "31"} {
+			t.Setenv("PASSWORD_BCRYPT_COST", value)
+			if _, err := LoadPasswordHashConfig(); err == nil {
+				t.Errorf("LoadPasswordHashConfig(cost %q) succeeded, want an error", value)
+			}
+		}
+	})
+}
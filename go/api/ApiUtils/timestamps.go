@@ -0,0 +1,160 @@
+package ApiUtils
+
+// Timestamps are exchanged as RFC3339. Clients should always send an
+// offset; strings without one (the legacy formats below) are read in a
+// zone: the request's 'time_zone' if it has one, otherwise the default
+// zone (libconfig 'default_time_zone', UTC if not set). Values read from
+// the database are returned as RFC3339, in UTC unless the request asks
+// for another zone.
+//
+// Naive timestamp columns (TIMESTAMP WITHOUT TIME ZONE, MySQL DATETIME)
+// hold the UTC wall clock.
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timestampLayoutsWithOffset are the formats, besides RFC3339, that carry
+// their own offset (PG's text output among them).
+var timestampLayoutsWithOffset = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999-07",
+}
+
+// legacyTimestampLayouts carry no offset; they are read in a zone
+var legacyTimestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var (
+	time_zone_mu      sync.RWMutex
+	default_time_zone = time.UTC
+)
+
+// SetDefaultTimeZone sets the zone legacy timestamps are read in when the
+// request does not name one. nil means UTC.
+func SetDefaultTimeZone(loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	time_zone_mu.Lock()
+	defer time_zone_mu.Unlock()
+	default_time_zone = loc
+}
+
+// DefaultTimeZone returns the zone set by SetDefaultTimeZone
+func DefaultTimeZone() *time.Location {
+	time_zone_mu.RLock()
+	defer time_zone_mu.RUnlock()
+	return default_time_zone
+}
+
+// LoadTimeZone returns the zone named 'name' (an IANA name such as
+// "America/New_York", or "UTC"). An empty name is the default zone.
+func LoadTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		return DefaultTimeZone(), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone:%s, err:%w (SHD_UTZ_068)", name, err)
+	}
+	return loc, nil
+}
+
+// ParseTimestampInZone parses 's' as RFC3339 or one of the formats with
+// an offset. Failing that, it parses a legacy format in 'loc' (the
+// default zone if nil). A wall clock skipped by a DST change is an
+// error; one repeated by a DST change is one of its two instants, so
+// clients that care must send an offset.
+func ParseTimestampInZone(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, fmt.Errorf("empty timestamp (SHD_UTZ_079)")
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range timestampLayoutsWithOffset {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	if loc == nil {
+		loc = DefaultTimeZone()
+	}
+	for _, layout := range legacyTimestampLayouts {
+		t, err := time.ParseInLocation(layout, s, loc)
+		if err != nil {
+			continue
+		}
+		// time.ParseInLocation shifts a skipped wall clock silently
+		wall, _ := time.Parse(layout, s)
+		const wall_layout = "2006-01-02 15:04:05.999999999"
+		if t.Format(wall_layout) != wall.Format(wall_layout) {
+			return time.Time{}, fmt.Errorf("timestamp '%s' does not exist in time zone %s "+
+				"(skipped by a DST change) (SHD_UTZ_104)", s, loc)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot convert string '%s' to timestamp, "+
+		"expecting RFC3339 such as 2006-01-02T15:04:05Z07:00 (SHD_UTZ_114)", s)
+}
+
+// FormatTimestamp returns 't' as RFC3339 in 'loc' (UTC if nil)
+func FormatTimestamp(t time.Time, loc *time.Location) string {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format(time.RFC3339Nano)
+}
+
+// Output formats of timestamp fields (FieldDef.TimestampFormat). Any
+// other non-empty format is a Go layout such as "2006-01-02 15:04".
+const (
+	TimestampFormatRFC3339 = "rfc3339"
+	TimestampFormatUnix    = "unix"
+	TimestampFormatUnixMs  = "unix_ms"
+)
+
+// CheckTimestampFormat returns an error if 'format' is neither empty, a
+// named format, nor a Go layout.
+func CheckTimestampFormat(format string) error {
+	switch format {
+	case "", TimestampFormatRFC3339, TimestampFormatUnix, TimestampFormatUnixMs:
+		return nil
+	}
+	// A layout without any element formats as itself
+	if time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(format) == format {
+		return fmt.Errorf("invalid timestamp format:%s, expecting %s, %s, %s or a Go layout "+
+			"such as 2006-01-02 15:04 (SHD_UTZ_140)",
+			format, TimestampFormatRFC3339, TimestampFormatUnix, TimestampFormatUnixMs)
+	}
+	return nil
+}
+
+// FormatTimestampAs returns 't' in 'format': the unix formats as an
+// int64, the others as a string in 'loc' (UTC if nil). An empty format
+// is RFC3339.
+func FormatTimestampAs(t time.Time, loc *time.Location, format string) interface{} {
+	switch format {
+	case "", TimestampFormatRFC3339:
+		return FormatTimestamp(t, loc)
+	case TimestampFormatUnix:
+		return t.Unix()
+	case TimestampFormatUnixMs:
+		return t.UnixMilli()
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format(format)
+}
@@ -24,7 +24,6 @@ import (
 	"github.com/chendingplano/shared/go/api/loggerutil"
 	"github.com/chendingplano/shared/go/api/sysdatastores"
 	"github.com/labstack/echo/v4"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // AuthenticatorFunc is a function type for authentication.
@@ -95,6 +94,8 @@ type echoContext struct {
 	is_admin     bool
 }
 
+var _ ApiTypes.RequestContext = (*echoContext)(nil)
+
 func NewRCAsAdmin(loc string) ApiTypes.RequestContext {
 	// This RC is used internally!
 	ctx := context.Background()
@@ -127,6 +128,10 @@ func NewFromEcho(c echo.Context, loc string) ApiTypes.RequestContext {
 }
 
 func (e *echoContext) Context() context.Context {
+	if e.c == nil {
+		// Internal RCs (NewRCAsAdmin) have no request
+		return e.ctx
+	}
 	return e.c.Request().Context()
 }
 
@@ -175,6 +180,15 @@ func (e *echoContext) QueryParam(key string) string {
 	return e.c.QueryParam(key)
 }
 
+// PathParam reads Echo's route parameters, then the net/http ones, which
+// routers built on http.ServeMux set (see WrapHTTP)
+func (e *echoContext) PathParam(name string) string {
+	if value := e.c.Param(name); value != "" {
+		return value
+	}
+	return e.c.Request().PathValue(name)
+}
+
 // func (e *echoContext) SetCookie(cookie *http.Cookie) {
 func (e *echoContext) SetCookie(session_id string) {
 	is_secure := ApiUtils.IsSecure()
@@ -259,7 +273,7 @@ func (e *echoContext) UpdatePassword(
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), bcrypt.DefaultCost)
+	hashedPassword, err := ApiUtils.HashPassword(plaintextPassword)
 	if err != nil {
 		error_msg := fmt.Sprintf("failed to hash password, email:%s, err:%v", email, err)
 		e.logger.Error("failed to hash password", "email", email, "error", err)
@@ -275,7 +289,7 @@ func (e *echoContext) UpdatePassword(
 		return false, http.StatusInternalServerError, error_msg
 	}
 
-	err = sysdatastores.UpdatePasswordByEmail(e, email, string(hashedPassword))
+	err = sysdatastores.UpdatePasswordByEmail(e, email, hashedPassword)
 	if err != nil {
 		error_msg := fmt.Sprintf("failed to update password in database, email:%s, err:%v", email, err)
 		e.logger.Error("failed to update password", "email", email, "error", err)
@@ -294,6 +308,31 @@ func (e *echoContext) UpdatePassword(
 	return true, 0, ""
 }
 
+func (e *echoContext) SetTemporaryPassword(
+	email string,
+	plaintextPassword string,
+	expires_at time.Time) (bool, int, string) {
+	// With Kratos, passwords are managed by Kratos
+	if os.Getenv("AUTH_USE_KRATOS") == "true" {
+		return false, http.StatusNotImplemented, "SetTemporaryPassword not supported with Kratos (SHD_EFC_302)"
+	}
+
+	hashedPassword, err := ApiUtils.HashPassword(plaintextPassword)
+	if err != nil {
+		e.logger.Error("failed to hash password", "email", email, "error", err)
+		return false, http.StatusInternalServerError,
+			fmt.Sprintf("failed to hash password, email:%s, err:%v (SHD_EFC_307)", email, err)
+	}
+
+	err = sysdatastores.SetTemporaryPasswordByEmail(e, email, hashedPassword, expires_at)
+	if err != nil {
+		e.logger.Error("failed to set temporary password", "email", email, "error", err)
+		return false, http.StatusInternalServerError,
+			fmt.Sprintf("failed to set temporary password in database, email:%s, err:%v", email, err)
+	}
+	return true, 0, ""
+}
+
 func (e *echoContext) VerifyUserPassword(
 	userInfo *ApiTypes.UserInfo,
 	password string) (bool, int, string) {
@@ -340,8 +379,12 @@ func (e *echoContext) VerifyUserPassword(
 		return false, ApiTypes.CustomHttpStatus_PasswordNotSet, msg
 	}
 
-	// Hash password
-	err := bcrypt.CompareHashAndPassword([]byte(userInfo.Password), []byte(password))
+	needs_rehash, err := ApiUtils.VerifyPassword(userInfo.Password, password)
+	if err != nil && !errors.Is(err, ApiUtils.ErrPasswordMismatch) {
+		error_msg := fmt.Sprintf("failed to verify password, email:%s, err:%v", userInfo.Email, err)
+		logger.Error("failed to verify password", "error", err, "email", userInfo.Email)
+		return false, http.StatusInternalServerError, error_msg
+	}
 	if err != nil {
 		error_msg := fmt.Sprintf("invalid password, email:%s", userInfo.Email)
 		logger.Warn("password mismatch", "error", err, "email", userInfo.Email)
@@ -357,10 +400,44 @@ func (e *echoContext) VerifyUserPassword(
 		return false, http.StatusUnauthorized, error_msg
 	}
 
+	// Hashes made before the pepper or the current cost was set are
+	// replaced now that the password is known
+	if needs_rehash {
+		e.rehashPassword(userInfo, password)
+	}
+
+	// Users with TOTP 2FA enabled must also pass /auth/2fa/verify. Fail
+	// closed if the 2FA state cannot be read.
+	two_factor_enabled, err := sysdatastores.IsTwoFactorEnabled(e, userInfo.UserId)
+	if err != nil {
+		logger.Error("failed to read two-factor state", "error", err, "email", userInfo.Email)
+		return false, http.StatusInternalServerError, "failed to check two-factor status (SHD_EFC_365)"
+	}
+
+	if two_factor_enabled {
+		logger.Info("password verified, two-factor required", "email", userInfo.Email)
+		return false, ApiTypes.CustomHttpStatus_TwoFactorRequired, "two-factor authentication required"
+	}
+
 	logger.Info("verify user password success", "email", userInfo.Email)
 	return true, 0, ""
 }
 
+// rehashPassword replaces the stored hash of 'userInfo' with one made
+// with the current cost and pepper. A failure is only logged: the stored
+// hash still verifies, and the next login tries again.
+func (e *echoContext) rehashPassword(userInfo *ApiTypes.UserInfo, password string) {
+	new_hash, err := ApiUtils.HashPassword(password)
+	if err == nil {
+		err = sysdatastores.RehashPasswordByEmail(e, userInfo.Email, userInfo.Password, new_hash)
+	}
+	if err != nil {
+		e.logger.Error("failed to rehash password", "error", err, "email", userInfo.Email)
+		return
+	}
+	userInfo.Password = new_hash
+}
+
 func (e *echoContext) GetUserInfoByToken(token string) (*ApiTypes.UserInfo, bool) {
 	if e.user_info != nil {
 		return e.user_info, true
@@ -500,6 +577,30 @@ func (e *echoContext) GetUserInfoByUserID(user_id string) (*ApiTypes.UserInfo, b
 	return user_info, true
 }
 
+// GetUserInfoByUserName looks the user up by user name. Kratos identities
+// have no user name, so with AUTH_USE_KRATOS no user is found. Shared user
+// names are treated as not found.
+func (e *echoContext) GetUserInfoByUserName(user_name string) (*ApiTypes.UserInfo, bool) {
+	if os.Getenv("AUTH_USE_KRATOS") == "true" {
+		e.logger.Warn("lookup by user name not supported with Kratos", "user_name", user_name)
+		return nil, false
+	}
+
+	user_info, err := sysdatastores.GetUserInfoByUserName(e, user_name)
+	if err != nil {
+		e.logger.Error("failed get user by user name", "user_name", user_name, "error", err)
+		return nil, false
+	}
+
+	if user_info == nil {
+		e.logger.Warn("No user found", "user_name", user_name)
+		return nil, false
+	}
+	return user_info, true
+}
+
+// SaveSession saves a login session through sysdatastores.SaveSession,
+// which takes the same arguments after the request context
 func (e *echoContext) SaveSession(
 	login_method string,
 	session_id string,
@@ -525,11 +626,12 @@ func (e *echoContext) SaveSession(
 		user_email, expiry, need_update_user)
 }
 
-func (e *echoContext) MarkUserVerified(email string) error {
+func (e *echoContext) MarkUserVerified(email string) (bool, error) {
 	// With Kratos, email verification is managed by Kratos flows.
 	// However, for admin override or manual verification, we can update the identity state.
+	// Kratos doesn't tell whether the identity was active already.
 	if os.Getenv("AUTH_USE_KRATOS") == "true" {
-		return KratosMarkUserVerifiedFunc(e.logger, email)
+		return true, KratosMarkUserVerifiedFunc(e.logger, email)
 	}
 
 	return sysdatastores.MarkUserVerified(e, email)
@@ -705,8 +807,11 @@ func (e *echoContext) UpsertUser(
 		if !found {
 			logger.Error("user not found", "email", user_info.Email)
 			if plain_password != "" {
-				hashedPwd, _ := bcrypt.GenerateFromPassword([]byte(plain_password), bcrypt.DefaultCost)
-				user_info.Password = string(hashedPwd)
+				hashedPwd, err := ApiUtils.HashPassword(plain_password)
+				if err != nil {
+					return nil, err
+				}
+				user_info.Password = hashedPwd
 				is_dirty = true
 			}
 		} else {
@@ -752,8 +857,11 @@ func (e *echoContext) UpsertUser(
 			}
 
 			if plain_password != "" {
-				hashedPwd, _ := bcrypt.GenerateFromPassword([]byte(plain_password), bcrypt.DefaultCost)
-				user_info.Password = string(hashedPwd)
+				hashedPwd, err := ApiUtils.HashPassword(plain_password)
+				if err != nil {
+					return nil, err
+				}
+				user_info.Password = hashedPwd
 				is_dirty = true
 			}
 
@@ -787,36 +895,6 @@ func (e *echoContext) UpsertUser(
 				is_dirty = true
 			}
 
-			if user_info.OutlookAccessToken == "" {
-				user_info.OutlookAccessToken = user_info_found.OutlookAccessToken
-			} else if user_info.OutlookAccessToken != user_info_found.OutlookAccessToken {
-				is_dirty = true
-			}
-
-			if user_info.OutlookRefreshToken == "" {
-				user_info.OutlookRefreshToken = user_info_found.OutlookRefreshToken
-			} else if user_info.OutlookRefreshToken != user_info_found.OutlookRefreshToken {
-				is_dirty = true
-			}
-
-			if user_info.OutlookTokenExpiresAt.IsZero() {
-				user_info.OutlookTokenExpiresAt = user_info_found.OutlookTokenExpiresAt
-			} else if user_info.OutlookTokenExpiresAt != user_info_found.OutlookTokenExpiresAt {
-				is_dirty = true
-			}
-
-			if user_info.OutlookSubExpiresAt.IsZero() {
-				user_info.OutlookSubExpiresAt = user_info_found.OutlookSubExpiresAt
-			} else if user_info.OutlookSubExpiresAt != user_info_found.OutlookSubExpiresAt {
-				is_dirty = true
-			}
-
-			if user_info.OutlookSubID == "" {
-				user_info.OutlookSubID = user_info_found.OutlookSubID
-			} else if user_info.OutlookSubID != user_info_found.OutlookSubID {
-				is_dirty = true
-			}
-
 			if user_info_found.Verified != verified {
 				user_info.Verified = verified
 			} else if user_info.Verified != user_info_found.Verified {
@@ -844,8 +922,11 @@ func (e *echoContext) UpsertUser(
 		}
 	} else {
 		if plain_password != "" {
-			hashedPwd, _ := bcrypt.GenerateFromPassword([]byte(plain_password), bcrypt.DefaultCost)
-			user_info.Password = string(hashedPwd)
+			hashedPwd, err := ApiUtils.HashPassword(plain_password)
+			if err != nil {
+				return nil, err
+			}
+			user_info.Password = hashedPwd
 		}
 		is_dirty = true
 	}
@@ -859,6 +940,10 @@ func (e *echoContext) UpsertUser(
 	}
 
 	err := sysdatastores.UpsertUser(e, user_info)
+	if errors.Is(err, ApiTypes.ErrTokenConflict) {
+		// The caller retries with a new token
+		return user_info, err
+	}
 	if err != nil {
 		log_id := sysdatastores.NextActivityLogID()
 		error_msg := fmt.Sprintf("Failed creating user, user_name:%s, email:%s, err:%s, log_id:%d",
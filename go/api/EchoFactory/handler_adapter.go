@@ -0,0 +1,71 @@
+package EchoFactory
+
+import (
+	"net/http"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/labstack/echo/v4"
+)
+
+// stdEcho is only used to build echo.Context values for net/http requests
+// (see WrapHTTP). No routes are registered on it.
+var stdEcho = echo.New()
+
+// WrapEcho adapts a framework-agnostic handler to an Echo handler.
+func WrapEcho(h ApiTypes.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		rc := NewFromEcho(c, "SHD_EFC_WRP_016")
+		defer rc.Close()
+		status_code, payload := h(rc)
+		return WriteEcho(c, status_code, payload)
+	}
+}
+
+// WrapHTTP adapts a framework-agnostic handler to a net/http handler.
+// Pocketbase apps register it with apis.WrapStdHandler(EchoFactory.WrapHTTP(h));
+// this module does not depend on Pocketbase, so the Pocketbase-typed wrapper
+// lives in the applications that do.
+func WrapHTTP(h ApiTypes.HandlerFunc) http.HandlerFunc {
+	echoHandler := WrapEcho(h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := stdEcho.NewContext(r, w)
+		if err := echoHandler(c); err != nil {
+			stdEcho.HTTPErrorHandler(err, c)
+		}
+	}
+}
+
+// WriteEcho serializes a ResponsePayload using Echo's response helpers.
+func WriteEcho(c echo.Context, status_code int, payload ApiTypes.ResponsePayload) error {
+	for key, values := range payload.Header {
+		c.Response().Header()[key] = values
+	}
+
+	switch payload.Kind {
+	case ApiTypes.ResponseKind_Redirect:
+		return c.Redirect(status_code, payload.Text)
+
+	case ApiTypes.ResponseKind_Stream:
+		// Same as WriteHTTP, so the body is sent the same way through both
+		return ApiTypes.WriteHTTP(c.Response(), c.Request(), status_code, payload)
+
+	case ApiTypes.ResponseKind_HTML:
+		return c.HTML(status_code, payload.Text)
+
+	case ApiTypes.ResponseKind_Text:
+		return c.String(status_code, payload.Text)
+
+	default:
+		return c.JSON(status_code, payload.Body)
+	}
+}
+
+// RegisterRoute registers a framework-agnostic handler on an Echo router.
+func RegisterRoute(
+	e *echo.Echo,
+	method string,
+	path string,
+	h ApiTypes.HandlerFunc,
+	m ...echo.MiddlewareFunc) *echo.Route {
+	return e.Add(method, path, WrapEcho(h), m...)
+}
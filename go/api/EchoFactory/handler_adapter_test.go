@@ -0,0 +1,88 @@
+package EchoFactory
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/labstack/echo/v4"
+)
+
+func TestWriteEchoRedirectSetsLocation(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+
+	if err := WriteEcho(c, http.StatusSeeOther, ApiTypes.RedirectPayload("/dashboard")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status: got %d want %d", rec.Code, http.StatusSeeOther)
+	}
+	if got := rec.Header().Get("Location"); got != "/dashboard" {
+		t.Fatalf("unexpected location: got %q", got)
+	}
+}
+
+func TestWriteEchoMatchesWriteHTTPForJSON(t *testing.T) {
+	payload := ApiTypes.JSONPayload(map[string]string{"status": "ok"})
+
+	echoRec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), echoRec)
+	if err := WriteEcho(c, http.StatusOK, payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	httpRec := httptest.NewRecorder()
+	if err := ApiTypes.WriteHTTP(httpRec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if echoRec.Body.String() != httpRec.Body.String() {
+		t.Fatalf("bodies differ: echo %q, http %q", echoRec.Body.String(), httpRec.Body.String())
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestWriteEchoStream(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "text/csv")
+	header.Set("Content-Range", "bytes 0-4/10")
+	stream := &closeRecorder{Reader: strings.NewReader("a,b,c")}
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	if err := WriteEcho(c, http.StatusPartialContent, ApiTypes.StreamPayload(header, stream)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "a,b,c" {
+		t.Fatalf("unexpected response: %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "text/csv" || rec.Header().Get("Content-Range") != "bytes 0-4/10" {
+		t.Fatalf("unexpected headers: %v", rec.Header())
+	}
+	if !stream.closed {
+		t.Fatal("stream not closed")
+	}
+
+	// Without a stream only the status and headers are sent
+	rec = httptest.NewRecorder()
+	c = echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	if err := WriteEcho(c, http.StatusRequestedRangeNotSatisfiable, ApiTypes.StreamPayload(header, nil)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable || rec.Body.Len() != 0 {
+		t.Fatalf("unexpected response: %d %q", rec.Code, rec.Body.String())
+	}
+}
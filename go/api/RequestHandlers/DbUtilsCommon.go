@@ -8,7 +8,10 @@ import (
 	"regexp"
 	"strings"
 
+	sq "github.com/Masterminds/squirrel"
+
 	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
 )
 
 // validIdentifierRegex validates SQL identifiers (table names, column names)
@@ -23,11 +26,159 @@ func isValidSQLIdentifier(name string) bool {
 	return validIdentifierRegex.MatchString(name)
 }
 
+// sqlReservedWords are the keywords PostgreSQL or MySQL reserve, which
+// can't be used unquoted as a table or column name (e.g. order, user).
+var sqlReservedWords = toWordSet(`
+	accessible add all alter analyse analyze and any array as asc asensitive
+	asymmetric authorization before between bigint binary blob both by call
+	cascade case cast change char character check collate collation column
+	concurrently condition constraint continue convert create cross cube
+	cume_dist current_catalog current_date current_role current_schema
+	current_time current_timestamp current_user cursor database databases
+	day_hour day_microsecond day_minute day_second dec decimal declare default
+	deferrable delayed delete dense_rank desc describe deterministic distinct
+	distinctrow div do double drop dual each else elseif empty enclosed end
+	escaped except exists exit explain false fetch first_value float float4
+	float8 for force foreign freeze from full fulltext function generated get
+	grant group grouping groups having high_priority hour_microsecond
+	hour_minute hour_second if ignore ilike in index infile initially inner
+	inout insensitive insert int int1 int2 int3 int4 int8 integer intersect
+	interval into is isnull iterate join json_table key keys kill lag lateral
+	last_value lead leading leave left like limit linear lines load localtime
+	localtimestamp lock long longblob longtext loop low_priority match
+	maxvalue mediumblob mediumint mediumtext middleint minute_microsecond
+	minute_second mod modifies natural not notnull no_write_to_binlog
+	nth_value ntile null numeric of offset on only optimize option
+	optionally or order out outer outfile over overlaps partition
+	percent_rank placing precision primary procedure purge range rank read
+	reads read_write real recursive references regexp release rename repeat
+	replace require resignal restrict return returning revoke right rlike
+	row row_number rows schema schemas second_microsecond select sensitive
+	separator session_user set show signal similar smallint some spatial
+	specific sql sqlexception sqlstate sqlwarning sql_big_result
+	sql_calc_found_rows sql_small_result ssl starting stored straight_join
+	symmetric system system_user table tablesample terminated then tinyblob
+	tinyint tinytext to trailing trigger true undo union unique unlock
+	unsigned update usage use user using utc_date utc_time utc_timestamp
+	values varbinary varchar varcharacter variadic varying verbose virtual
+	when where while window with write xor year_month zerofill`)
+
+func toWordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// quoteIdent returns 'name', a valid SQL identifier, as it goes into the
+// SQL of the backend (ApiTypes.DBType): a reserved word is quoted, "order"
+// on PostgreSQL and `order` on MySQL, and other names are left as they
+// are. PostgreSQL folds unquoted names to lower case but not quoted ones,
+// so a quoted name is lower-cased there to name the same column.
+func quoteIdent(name string) string {
+	if !sqlReservedWords[strings.ToLower(name)] {
+		return name
+	}
+	if ApiTypes.DBType == ApiTypes.MysqlName {
+		return "`" + name + "`"
+	}
+	return `"` + strings.ToLower(name) + `"`
+}
+
+// isQualifiedIdentifier checks that 'name' is an identifier or a
+// qualified one (tablename.fieldname)
+func isQualifiedIdentifier(name string) bool {
+	for _, part := range strings.Split(name, ".") {
+		if !isValidSQLIdentifier(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteQualified quotes the parts of a name that may be qualified
+// (tablename.fieldname) with quoteIdent. Anything that is not a
+// (qualified) identifier is returned as it is.
+func quoteQualified(name string) string {
+	if !isQualifiedIdentifier(name) {
+		return name
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quoteIdent(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// placeholderFormat returns the squirrel placeholders of the backend
+// (ApiTypes.DBType): $1, $2, ... on PostgreSQL and ? on MySQL.
+func placeholderFormat() sq.PlaceholderFormat {
+	if ApiTypes.DBType == ApiTypes.MysqlName {
+		return sq.Question
+	}
+	return sq.Dollar
+}
+
+const (
+	// maxInsertParams is the most bind parameters a statement may have,
+	// in PostgreSQL and in MySQL
+	maxInsertParams = 65535
+
+	// maxInsertBatchSize is the most records an INSERT statement takes
+	// when LibConfig.InsertBatchSize is not set
+	maxInsertBatchSize = 1000
+
+	// defaultInsertCopyThreshold is the number of records above which a
+	// PostgreSQL insert uses COPY when LibConfig.InsertCopyThreshold is
+	// not set
+	defaultInsertCopyThreshold = 5000
+)
+
+// insertBatchSize returns the number of records per INSERT statement for
+// a table of 'num_columns' columns. 'batch_size', or
+// LibConfig.InsertBatchSize if it is 0, is lowered so that the statement
+// has at most maxInsertParams bind parameters. It returns 0 if even one
+// record has too many columns.
+func insertBatchSize(batch_size int, num_columns int) int {
+	if batch_size <= 0 {
+		batch_size = ApiTypes.LibConfig.InsertBatchSize
+	}
+	if batch_size <= 0 {
+		batch_size = maxInsertBatchSize
+	}
+	if num_columns > 0 {
+		batch_size = min(batch_size, maxInsertParams/num_columns)
+	}
+	return batch_size
+}
+
+// useInsertCopy tells whether InsertBatch loads 'num_records' records
+// with COPY instead of INSERT statements. COPY can't upsert, so inserts
+// with ON CONFLICT columns always use INSERT.
+func useInsertCopy(db_type string, resource_request ApiTypes.InsertRequest, num_records int) bool {
+	if db_type != ApiTypes.PgName || len(resource_request.OnConflictCols) > 0 {
+		return false
+	}
+	threshold := ApiTypes.LibConfig.InsertCopyThreshold
+	if threshold < 0 {
+		return false
+	}
+	if threshold == 0 {
+		threshold = defaultInsertCopyThreshold
+	}
+	return num_records > threshold
+}
+
 // InsertBatch inserts multiple records into the specified table
 // and returns the auto-generated prompt_id values. It works for both
 // single and batch inserts. The tableName and columns must be valid
 // and sanitized to prevent SQL injection, as they are interpolated
 // directly into the SQL string.
+//
+// 'batchSize' is the number of records per statement; 0 means
+// LibConfig.InsertBatchSize. See insertBatchSize. Into PostgreSQL, more
+// records than LibConfig.InsertCopyThreshold are loaded with COPY.
 func InsertBatch(
 	ctx context.Context,
 	user_name string,
@@ -42,19 +193,18 @@ func InsertBatch(
 	reqID := ctx.Value(ApiTypes.RequestIDKey).(string)
 
 	// SECURITY: Validate table name to prevent SQL injection
-	// Table names are interpolated directly into SQL, so they MUST be validated
-	if !isValidSQLIdentifier(tableName) {
+	// Table names are interpolated directly into SQL, so they MUST be validated.
+	// A schema-qualified name (schema.table) is allowed.
+	if !isQualifiedIdentifier(tableName) {
 		error_msg := fmt.Sprintf("invalid table name (SQL injection prevention): %s", tableName)
 		log.Printf("***** SECURITY ALERT:[req=%s] %s (SHD_UCM_SEC_001)", reqID, error_msg)
 		return fmt.Errorf("%s", error_msg)
 	}
 
-	// This function inserts records in batch. It supports MySQL and PostgreSQL only now.
-	// In the future, it may support more databases.
-	if batchSize <= 0 {
-		batchSize = 30
-	}
-
+	// The column list and the value groups are both built from
+	// insert_defs, so each value lands in its own column whatever the
+	// order of fieldDefs.
+	insert_defs := []ApiTypes.FieldDef{}
 	columns := []string{}
 	for _, f := range fieldDefs {
 		switch f.DataType {
@@ -71,11 +221,32 @@ func InsertBatch(
 				log.Printf("***** SECURITY ALERT:[req=%s] %s (SHD_UCM_SEC_002)", reqID, error_msg)
 				return fmt.Errorf("%s", error_msg)
 			}
+			insert_defs = append(insert_defs, f)
 			columns = append(columns, f.FieldName)
 		}
 	}
 
-	tx, err := db.Begin()
+	// This function inserts records in batch. It supports MySQL and PostgreSQL only now.
+	// In the future, it may support more databases.
+	batchSize = insertBatchSize(batchSize, len(columns))
+	if batchSize <= 0 {
+		error_msg := fmt.Sprintf("too many columns, table_name:%s, columns:%d, max:%d",
+			tableName, len(columns), maxInsertParams)
+		log.Printf("***** Alarm:[req=%s] %s (%s->SHD_UCM_168)", reqID, error_msg, call_flow)
+		return fmt.Errorf("%s (SHD_UCM_168)", error_msg)
+	}
+
+	loc, err := ApiUtils.LoadTimeZone(resource_request.TimeZone)
+	if err != nil {
+		return err
+	}
+
+	isolation, err := txIsolation(resource_request.IsolationLevel, db_type, sql.LevelDefault)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: isolation})
 	if err != nil {
 		return err
 	}
@@ -83,6 +254,22 @@ func InsertBatch(
 
 	total := len(records)
 	conflict_suffix := ""
+	quoted_columns := make([]string, len(columns))
+	for i, column := range columns {
+		quoted_columns[i] = quoteIdent(column)
+	}
+
+	var copy_in *pgCopyIn
+	if useInsertCopy(db_type, resource_request, total) {
+		copy_in, err = newPgCopyIn(tx, tableName, columns)
+		if err != nil {
+			new_call_flow := fmt.Sprintf("%s->SHD_UCM_186", call_flow)
+			log.Printf("[req=%s] failed to start copy, table_name:%s, error:%v, loc:%s",
+				reqID, tableName, err, new_call_flow)
+			return fmt.Errorf("failed to start copy, loc:%s: %w", new_call_flow, err)
+		}
+		defer copy_in.close()
+	}
 
 	for start := 0; start < total; start += batchSize {
 		end := start + batchSize
@@ -97,7 +284,7 @@ func InsertBatch(
 		switch db_type {
 		case ApiTypes.MysqlName:
 			var err1 error
-			valueGroups, args, err1 = CreateValueGroupsMySQL(user_name, fieldDefs, chunk)
+			valueGroups, args, err1 = CreateValueGroupsMySQL(user_name, insert_defs, chunk, loc)
 			if err1 != nil {
 				log.Printf("[req=%s] CreateValueGroupsMySQL failed, %d:%d (SHD_UCM_077)",
 					reqID, len(valueGroups), len(args))
@@ -108,7 +295,7 @@ func InsertBatch(
 
 		case ApiTypes.PgName:
 			var err1 error
-			valueGroups, args, err1 = CreateValueGroupsPG(user_name, fieldDefs, chunk)
+			valueGroups, args, err1 = CreateValueGroupsPG(user_name, insert_defs, chunk, loc)
 			if err1 != nil {
 				log.Printf("[req=%s] CreateValueGroupsPG failed, %d:%d (SHD_UCM_087)",
 					reqID, len(valueGroups), len(args))
@@ -133,10 +320,28 @@ func InsertBatch(
 			return fmt.Errorf("%s", error_msg)
 		}
 
+		if len(args) != len(columns)*len(chunk) {
+			error_msg := fmt.Sprintf("values do not match columns, table_name:%s, columns:%d, records:%d, values:%d",
+				tableName, len(columns), len(chunk), len(args))
+			new_call_flow := fmt.Sprintf("%s->SHD_UCM_130", call_flow)
+			log.Printf("***** Alarm:[req=%s] %s (%s)", reqID, error_msg, new_call_flow)
+			return fmt.Errorf("%s", error_msg)
+		}
+
+		if copy_in != nil {
+			if err := copy_in.add(args); err != nil {
+				new_call_flow := fmt.Sprintf("%s->SHD_UCM_272", call_flow)
+				log.Printf("[req=%s] failed copy, table_name:%s, error:%v, loc:%s",
+					reqID, tableName, err, new_call_flow)
+				return fmt.Errorf("failed copy, loc:%s: %w", new_call_flow, err)
+			}
+			continue
+		}
+
 		sqlStr := fmt.Sprintf(
 			"INSERT INTO %s (%s) VALUES %s",
-			tableName,
-			strings.Join(columns, ","),
+			quoteQualified(tableName),
+			strings.Join(quoted_columns, ","),
 			strings.Join(valueGroups, ","),
 		)
 
@@ -150,7 +355,16 @@ func InsertBatch(
 			error_msg := fmt.Sprintf("failed run statement, error:%v, stmt:%s, values:%v, loc:%s",
 				err, sqlStr, args, new_call_flow)
 			log.Printf("[req%s] %s", reqID, error_msg)
-			return fmt.Errorf("%s", error_msg)
+			return fmt.Errorf("failed run statement, loc:%s: %w", new_call_flow, err)
+		}
+	}
+
+	if copy_in != nil {
+		if err := copy_in.finish(); err != nil {
+			new_call_flow := fmt.Sprintf("%s->SHD_UCM_305", call_flow)
+			log.Printf("[req=%s] failed copy, table_name:%s, records:%d, error:%v, loc:%s",
+				reqID, tableName, total, err, new_call_flow)
+			return fmt.Errorf("failed copy, loc:%s: %w", new_call_flow, err)
 		}
 	}
 
@@ -193,8 +407,9 @@ func InsertAutoColumns(
 	}
 
 	// 2. Build batch insert using ? placeholders
+	batchSize = insertBatchSize(batchSize, len(columns))
 	if batchSize <= 0 {
-		batchSize = 30
+		return fmt.Errorf("too many columns:%d, max:%d (SHD_UCM_349)", len(columns), maxInsertParams)
 	}
 
 	tx, err := db.Begin()
@@ -0,0 +1,289 @@
+package RequestHandlers
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+// TestInsertBatchColumnOrder checks that each value is bound to its own
+// column whatever the order of the field defs, and that skipped fields
+// take neither a column nor a value.
+func TestInsertBatchColumnOrder(t *testing.T) {
+	records := []map[string]interface{}{
+		{"id": 7, "name": "dave", "email": "dave@example.com", "note": "not stored"},
+	}
+	defs := map[string]ApiTypes.FieldDef{
+		"id":    {FieldName: "id", DataType: "_auto_inc"},
+		"name":  {FieldName: "name", DataType: "string", Required: true},
+		"email": {FieldName: "email", DataType: "string"},
+		"note":  {FieldName: "note", DataType: "_ignore"},
+	}
+
+	tests := []struct {
+		name    string
+		db_type string
+		order   []string
+		wantSQL string
+		args    []driver.Value
+	}{
+		{"pg", ApiTypes.PgName, []string{"id", "name", "email", "note"},
+			"INSERT INTO users (name,email) VALUES ($1,$2)", []driver.Value{"dave", "dave@example.com"}},
+		{"pg reordered", ApiTypes.PgName, []string{"note", "email", "id", "name"},
+			"INSERT INTO users (email,name) VALUES ($1,$2)", []driver.Value{"dave@example.com", "dave"}},
+		{"mysql", ApiTypes.MysqlName, []string{"id", "name", "email", "note"},
+			"INSERT INTO users (name,email) VALUES (?,?)", []driver.Value{"dave", "dave@example.com"}},
+		{"mysql reordered", ApiTypes.MysqlName, []string{"note", "email", "id", "name"},
+			"INSERT INTO users (email,name) VALUES (?,?)", []driver.Value{"dave@example.com", "dave"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field_defs := make([]ApiTypes.FieldDef, 0, len(tt.order))
+			for _, name := range tt.order {
+				field_defs = append(field_defs, defs[name])
+			}
+
+			tdb := testharness.NewMockDB(t)
+			tdb.Mock.ExpectBegin()
+			tdb.Mock.ExpectExec(tt.wantSQL).WithArgs(tt.args...).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			tdb.Mock.ExpectCommit()
+
+			req := ApiTypes.InsertRequest{TableName: "users", FieldDefs: field_defs, Records: records}
+			if err := InsertBatch(testCtx(), "tester", tdb.DB, "users", req, field_defs, records, 0, tt.db_type); err != nil {
+				t.Fatalf("InsertBatch: %v", err)
+			}
+		})
+	}
+}
+
+func TestInsertBatchQuotesReservedNames(t *testing.T) {
+	field_defs := []ApiTypes.FieldDef{
+		{FieldName: "user", DataType: "string"},
+		{FieldName: "limit", DataType: "int"},
+	}
+	records := []map[string]interface{}{{"user": "dave", "limit": 3}}
+
+	tests := []struct {
+		db_type    string
+		table_name string
+		wantSQL    string
+	}{
+		{ApiTypes.PgName, "order", `INSERT INTO "order" ("user","limit") VALUES ($1,$2)`},
+		{ApiTypes.MysqlName, "order", "INSERT INTO `order` (`user`,`limit`) VALUES (?,?)"},
+		{ApiTypes.PgName, "app.order", `INSERT INTO app."order" ("user","limit") VALUES ($1,$2)`},
+		{ApiTypes.MysqlName, "app.order", "INSERT INTO app.`order` (`user`,`limit`) VALUES (?,?)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.db_type+"/"+tt.table_name, func(t *testing.T) {
+			saved := ApiTypes.DBType
+			ApiTypes.DBType = tt.db_type
+			t.Cleanup(func() { ApiTypes.DBType = saved })
+
+			tdb := testharness.NewMockDB(t)
+			tdb.Mock.ExpectBegin()
+			tdb.Mock.ExpectExec(tt.wantSQL).WithArgs("dave", int64(3)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			tdb.Mock.ExpectCommit()
+
+			req := ApiTypes.InsertRequest{TableName: tt.table_name, FieldDefs: field_defs, Records: records}
+			if err := InsertBatch(testCtx(), "tester", tdb.DB, tt.table_name, req, field_defs, records, 0, tt.db_type); err != nil {
+				t.Fatalf("InsertBatch: %v", err)
+			}
+		})
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	tests := []struct {
+		name      string
+		wantPG    string
+		wantMySQL string
+	}{
+		{name: "users", wantPG: "users", wantMySQL: "users"},
+		{name: "order", wantPG: `"order"`, wantMySQL: "`order`"},
+		{name: "Group", wantPG: `"group"`, wantMySQL: "`Group`"},
+		{name: "user.order", wantPG: `"user"."order"`, wantMySQL: "`user`.`order`"},
+		{name: "orders.amount", wantPG: "orders.amount", wantMySQL: "orders.amount"},
+		{name: "COUNT(*)", wantPG: "COUNT(*)", wantMySQL: "COUNT(*)"},
+		{name: "order; DROP", wantPG: "order; DROP", wantMySQL: "order; DROP"},
+	}
+
+	saved := ApiTypes.DBType
+	defer func() { ApiTypes.DBType = saved }()
+	for _, tt := range tests {
+		ApiTypes.DBType = ApiTypes.PgName
+		if got := quoteQualified(tt.name); got != tt.wantPG {
+			t.Errorf("pg: quoteQualified(%q) = %s, want %s", tt.name, got, tt.wantPG)
+		}
+		ApiTypes.DBType = ApiTypes.MysqlName
+		if got := quoteQualified(tt.name); got != tt.wantMySQL {
+			t.Errorf("mysql: quoteQualified(%q) = %s, want %s", tt.name, got, tt.wantMySQL)
+		}
+	}
+}
+
+func TestInsertBatchSize(t *testing.T) {
+	saved := ApiTypes.LibConfig.InsertBatchSize
+	defer func() { ApiTypes.LibConfig.InsertBatchSize = saved }()
+
+	tests := []struct {
+		name       string
+		config     int
+		batch_size int
+		columns    int
+		want       int
+	}{
+		{name: "narrow", columns: 3, want: maxInsertBatchSize},
+		{name: "wide", columns: 300, want: 218},
+		{name: "caller size", batch_size: 30, columns: 3, want: 30},
+		{name: "caller size too big", batch_size: 1000, columns: 300, want: 218},
+		{name: "config", config: 50, columns: 3, want: 50},
+		{name: "config too big", config: 5000, columns: 20, want: 3276},
+		{name: "too many columns", columns: maxInsertParams + 1, want: 0},
+	}
+	for _, tt := range tests {
+		ApiTypes.LibConfig.InsertBatchSize = tt.config
+		got := insertBatchSize(tt.batch_size, tt.columns)
+		if got != tt.want {
+			t.Errorf("%s: insertBatchSize = %d, want %d", tt.name, got, tt.want)
+		}
+		if got*tt.columns > maxInsertParams {
+			t.Errorf("%s: %d records of %d columns exceed the parameter limit", tt.name, got, tt.columns)
+		}
+	}
+}
+
+// wideTable returns the field defs and records of a table with
+// 'num_columns' string columns
+func wideTable(num_columns int, num_records int) ([]ApiTypes.FieldDef, []map[string]interface{}) {
+	defs := make([]ApiTypes.FieldDef, num_columns)
+	for i := range defs {
+		defs[i] = ApiTypes.FieldDef{FieldName: fmt.Sprintf("c%d", i), DataType: "string"}
+	}
+	records := make([]map[string]interface{}, num_records)
+	for r := range records {
+		records[r] = map[string]interface{}{}
+		for i := range defs {
+			records[r][defs[i].FieldName] = fmt.Sprintf("%d-%d", r, i)
+		}
+	}
+	return defs, records
+}
+
+// wideInsertSQL returns the INSERT statement of 'num_records' records of
+// 'num_columns' columns into 'wide'
+func wideInsertSQL(db_type string, num_columns int, num_records int) string {
+	columns := make([]string, num_columns)
+	for i := range columns {
+		columns[i] = fmt.Sprintf("c%d", i)
+	}
+	groups := make([]string, num_records)
+	param := 1
+	for r := range groups {
+		placeholders := make([]string, num_columns)
+		for i := range placeholders {
+			if db_type == ApiTypes.MysqlName {
+				placeholders[i] = "?"
+			} else {
+				placeholders[i] = fmt.Sprintf("$%d", param)
+			}
+			param++
+		}
+		groups[r] = "(" + strings.Join(placeholders, ",") + ")"
+	}
+	return fmt.Sprintf("INSERT INTO wide (%s) VALUES %s", strings.Join(columns, ","), strings.Join(groups, ","))
+}
+
+// TestInsertBatchWideTable checks that the records of a wide table are
+// split so that no statement has more than maxInsertParams parameters.
+// 500 records of 300 columns would need 150000.
+func TestInsertBatchWideTable(t *testing.T) {
+	const num_columns, num_records = 300, 500
+	defs, records := wideTable(num_columns, num_records)
+
+	for _, db_type := range []string{ApiTypes.PgName, ApiTypes.MysqlName} {
+		t.Run(db_type, func(t *testing.T) {
+			tdb := testharness.NewMockDB(t)
+			tdb.Mock.ExpectBegin()
+			for _, n := range []int{218, 218, 64} {
+				tdb.Mock.ExpectExec(wideInsertSQL(db_type, num_columns, n)).
+					WillReturnResult(sqlmock.NewResult(0, int64(n)))
+			}
+			tdb.Mock.ExpectCommit()
+
+			req := ApiTypes.InsertRequest{TableName: "wide", FieldDefs: defs, Records: records}
+			if err := InsertBatch(testCtx(), "tester", tdb.DB, "wide", req, defs, records, 0, db_type); err != nil {
+				t.Fatalf("InsertBatch: %v", err)
+			}
+		})
+	}
+}
+
+func TestInsertBatchCopy(t *testing.T) {
+	saved := ApiTypes.LibConfig.InsertCopyThreshold
+	ApiTypes.LibConfig.InsertCopyThreshold = 2
+	defer func() { ApiTypes.LibConfig.InsertCopyThreshold = saved }()
+
+	defs, records := wideTable(2, 3)
+	req := ApiTypes.InsertRequest{TableName: "Wide", FieldDefs: defs, Records: records}
+
+	tdb := testharness.NewMockDB(t)
+	tdb.Mock.ExpectBegin()
+	copy_stmt := tdb.Mock.ExpectPrepare(`COPY "wide" ("c0", "c1") FROM STDIN`)
+	for r := range records {
+		copy_stmt.ExpectExec().WithArgs(fmt.Sprintf("%d-0", r), fmt.Sprintf("%d-1", r)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	copy_stmt.ExpectExec().WithoutArgs().WillReturnResult(sqlmock.NewResult(0, 3))
+	tdb.Mock.ExpectCommit()
+	if err := InsertBatch(testCtx(), "tester", tdb.DB, "Wide", req, defs, records, 0, ApiTypes.PgName); err != nil {
+		t.Fatalf("InsertBatch: %v", err)
+	}
+
+	// A schema-qualified name quotes the schema and the table separately
+	tdb = testharness.NewMockDB(t)
+	tdb.Mock.ExpectBegin()
+	copy_stmt = tdb.Mock.ExpectPrepare(`COPY "app"."wide" ("c0", "c1") FROM STDIN`)
+	for r := range records {
+		copy_stmt.ExpectExec().WithArgs(fmt.Sprintf("%d-0", r), fmt.Sprintf("%d-1", r)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	copy_stmt.ExpectExec().WithoutArgs().WillReturnResult(sqlmock.NewResult(0, 3))
+	tdb.Mock.ExpectCommit()
+	if err := InsertBatch(testCtx(), "tester", tdb.DB, "app.Wide", req, defs, records, 0, ApiTypes.PgName); err != nil {
+		t.Fatalf("InsertBatch schema-qualified: %v", err)
+	}
+
+	// Upserts, MySQL and small inserts use INSERT
+	upsert := req
+	upsert.OnConflictCols = []string{"c0"}
+	upsert.OnConflictUpdateCols = []string{"c1"}
+	tests := []struct {
+		name     string
+		db_type  string
+		req      ApiTypes.InsertRequest
+		records  int
+		wantCopy bool
+	}{
+		{"pg", ApiTypes.PgName, req, 3, true},
+		{"pg small", ApiTypes.PgName, req, 2, false},
+		{"pg upsert", ApiTypes.PgName, upsert, 3, false},
+		{"mysql", ApiTypes.MysqlName, req, 3, false},
+	}
+	for _, tt := range tests {
+		if got := useInsertCopy(tt.db_type, tt.req, tt.records); got != tt.wantCopy {
+			t.Errorf("%s: useInsertCopy = %v, want %v", tt.name, got, tt.wantCopy)
+		}
+	}
+
+	ApiTypes.LibConfig.InsertCopyThreshold = -1
+	if useInsertCopy(ApiTypes.PgName, req, 10000) {
+		t.Error("COPY used when turned off")
+	}
+}
@@ -1,22 +1,36 @@
 package RequestHandlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/chendingplano/shared/go/api/ApiTypes"
 )
 
+// CreateValueGroupsMySQL returns the placeholder groups and args of 'chunk'.
+// Timestamps without an offset are read in 'loc' (the default zone if nil).
 func CreateValueGroupsMySQL(
 			user_name string,
 			fieldDefs []ApiTypes.FieldDef,
-			chunk []map[string]interface{}) ([]string, []interface{}, error) {
+			chunk []map[string]interface{},
+			loc *time.Location) ([]string, []interface{}, error) {
 	valueGroups := []string{}
 	args := []interface{}{}
 	for _, rec := range chunk {
 		placeholders := []string{}
 		for _, f := range fieldDefs {
 			val, ok := rec[f.FieldName]
+			if f.DataType == "array" {
+				encoded, err := encodeJSONArray(f, val, ok)
+				if err != nil {
+					return valueGroups, args, fmt.Errorf("invalid value for field %s: %w (SHD_DUM_027)", f.FieldName, err)
+				}
+				args = append(args, encoded)
+				placeholders = append(placeholders, "?")
+				continue
+			}
 			if f.Required && !ok {
 				switch f.ElementType {
 				case "creator":
@@ -27,6 +41,18 @@ func CreateValueGroupsMySQL(
 					 return valueGroups, args, fmt.Errorf("missing required field (SHD_DUM_020): %s", f.FieldName)
 				}
 			}
+			// The driver binds time.Time in its own zone (UTC by
+			// default), which matches the naive DATETIME convention.
+			if str, is_str := val.(string); is_str {
+				switch data_type := ApiTypes.FieldDataType(f); data_type {
+				case "timestamp", "timestamptz", "datetime":
+					converted, err := convertFieldValue(data_type, str, loc)
+					if err != nil {
+						return valueGroups, args, fmt.Errorf("invalid value for field %s: %w (SHD_DUM_035)", f.FieldName, err)
+					}
+					val = converted
+				}
+			}
 			args = append(args, val)
 			placeholders = append(placeholders, "?")
 		}
@@ -36,6 +62,44 @@ func CreateValueGroupsMySQL(
 	return valueGroups, args, nil
 }
 
+// encodeJSONArray encodes the value of the array field 'f' for a MySQL
+// JSON column, the way CreateValueGroupsPG binds it to a PG array: a
+// missing or null optional array is NULL and a missing required one is
+// empty. Elements are converted to the field's ElementType.
+func encodeJSONArray(f ApiTypes.FieldDef, val interface{}, ok bool) (interface{}, error) {
+	if !f.Required && val == nil {
+		return nil, nil
+	}
+	if !ok {
+		return "[]", nil
+	}
+
+	var elements interface{}
+	var err error
+	switch f.ElementType {
+	case "string":
+		elements = convertStrArray(val)
+	case "int32":
+		elements, err = convertInt32Array(val)
+	case "int64":
+		elements, err = convertInt64Array(val)
+	default:
+		err = fmt.Errorf("array element data type not supported:%s", f.ElementType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(elements)
+	if err != nil {
+		return nil, err
+	}
+	if string(encoded) == "null" {
+		return "[]", nil
+	}
+	return string(encoded), nil
+}
+
 func CreateOnConflictMySQL(resource_request ApiTypes.InsertRequest) (string, error) {
 
 	updateCols := resource_request.OnConflictUpdateCols
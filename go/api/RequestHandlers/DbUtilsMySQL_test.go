@@ -0,0 +1,49 @@
+package RequestHandlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+func TestCreateValueGroupsMySQLArrays(t *testing.T) {
+	field_defs := []ApiTypes.FieldDef{
+		{FieldName: "name", DataType: "string", Required: true},
+		{FieldName: "tags", DataType: "array", ElementType: "string", Required: true},
+		{FieldName: "ids", DataType: "array", ElementType: "int64"},
+	}
+
+	records := []map[string]interface{}{
+		{"name": "alice", "tags": []interface{}{"a", "b"}, "ids": []interface{}{float64(3), "4"}},
+		{"name": "bob", "tags": "solo", "ids": nil},
+		{"name": "carol"},
+	}
+
+	groups, args, err := CreateValueGroupsMySQL("tester", field_defs, records, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantGroups := []string{"(?,?,?)", "(?,?,?)", "(?,?,?)"}
+	if !reflect.DeepEqual(groups, wantGroups) {
+		t.Errorf("value groups = %q, want %q", groups, wantGroups)
+	}
+
+	// Arrays are bound as JSON text, the same shapes as the PG arrays
+	wantArgs := []interface{}{
+		"alice", `["a","b"]`, `[3,4]`,
+		"bob", `["solo"]`, nil,
+		"carol", `[]`, nil,
+	}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %#v, want %#v", args, wantArgs)
+	}
+
+	_, _, err = CreateValueGroupsMySQL("tester", field_defs,
+		[]map[string]interface{}{{"name": "dave", "tags": []interface{}{}, "ids": []interface{}{"many"}}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid value for field ids") {
+		t.Errorf("error = %v, want an invalid value for field ids", err)
+	}
+}
@@ -2,6 +2,7 @@ package RequestHandlers
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -11,14 +12,18 @@ import (
 	"time"
 
 	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
 	"github.com/jackc/pgx/v5"
 	"github.com/lib/pq"
 )
 
+// CreateValueGroupsPG returns the placeholder groups and args of 'chunk'.
+// Timestamps without an offset are read in 'loc' (the default zone if nil).
 func CreateValueGroupsPG(
 	user_name string,
 	fieldDefs []ApiTypes.FieldDef,
-	chunk []map[string]interface{}) ([]string, []interface{}, error) {
+	chunk []map[string]interface{},
+	loc *time.Location) ([]string, []interface{}, error) {
 	paramCounter := 1
 	valueGroups := []string{}
 	args := []interface{}{}
@@ -56,13 +61,16 @@ func CreateValueGroupsPG(
 
 			case "array":
 				log.Printf("processing array-string field:%s (SHD_DUP_095)", f.FieldName)
-				if f.Required && !ok {
+				if !f.Required && val == nil {
+					// Missing or null optional array: bind NULL, not an empty array
+					appendNull(&args, &placeholders, &paramCounter)
+				} else if !ok {
 					// Handle missing array field - could be nil or empty array depending on your needs
 					args = append(args, pq.Array([]string{})) // or nil if allowed
 					placeholders = append(placeholders, fmt.Sprintf("$%d", paramCounter))
 					paramCounter++
-				} else {
-					handleArrayValue(f, val, &args, &placeholders, &paramCounter)
+				} else if err := handleArrayValue(f, val, &args, &placeholders, &paramCounter); err != nil {
+					return valueGroups, args, fmt.Errorf("invalid value for field %s: %w", f.FieldName, err)
 				}
 
 			default:
@@ -70,8 +78,16 @@ func CreateValueGroupsPG(
 					log.Printf("missing required field:%s, field_type:%s (SHD_DUP_088)", f.FieldName, f.DataType)
 					return valueGroups, args, fmt.Errorf("missing required field: %s", f.FieldName)
 				}
+				if !ok {
+					// Records in a chunk may have different fields. A missing
+					// optional field still takes its slot so the columns line up.
+					appendNull(&args, &placeholders, &paramCounter)
+					continue
+				}
 				log.Printf("FieldDef:%v (SHD_DUP_073)", f)
-				handleValue(f.DataType, val, &args, &placeholders, &paramCounter)
+				if err := handleValue(ApiTypes.FieldDataType(f), val, loc, &args, &placeholders, &paramCounter); err != nil {
+					return valueGroups, args, fmt.Errorf("invalid value for field %s: %w", f.FieldName, err)
+				}
 			}
 		}
 		valueGroups = append(valueGroups, "("+strings.Join(placeholders, ",")+")")
@@ -80,278 +96,224 @@ func CreateValueGroupsPG(
 	return valueGroups, args, nil
 }
 
+// appendNull binds NULL for the next placeholder.
+func appendNull(args *[]interface{}, placeholders *[]string, paramCount *int) {
+	*args = append(*args, nil)
+	*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
+	*paramCount++
+}
+
 func handleValue(
 	db_field_data_type string,
 	value interface{},
+	loc *time.Location,
 	args *[]interface{},
 	placeholders *[]string,
 	paramCount *int) error {
 	// This function appends 'value' to 'args', add a placeholder to 'placeholder' and
 	// increment 'paramCount'. It must match the data type of 'value' with the database field
 	// data type 'db_field_data_type'.
+	converted, err := convertFieldValue(db_field_data_type, value, loc)
+	if err != nil {
+		return err
+	}
+	*args = append(*args, converted)
+	*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
+	*paramCount++
+	return nil
+}
+
+// convertFieldValue converts 'value' to what is bound for a database field of
+// type 'db_field_data_type'. It has no side effects, so it can also be used to
+// validate records before any SQL is built (see ValidateRecords).
+//
+// Timestamp strings without an offset are read in 'loc' (the default zone
+// if nil). Naive timestamps are bound as the UTC wall clock.
+func convertFieldValue(db_field_data_type string, value interface{}, loc *time.Location) (interface{}, error) {
+	if db_field_data_type == "json" || db_field_data_type == "jsonb" {
+		return convertJSONValue(value)
+	}
 
 	switch val := value.(type) {
 	case string:
 		switch db_field_data_type {
 		case "text", "varchar", "char", "string":
-			*args = append(*args, val)
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return val, nil
 
 		case "integer", "int", "int4":
 			if num, err := strconv.Atoi(val); err == nil {
-				*args = append(*args, num)
-				*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-				*paramCount++
-				return nil
+				return num, nil
 			}
-			return fmt.Errorf("cannot convert string '%s' to integer", val)
+			return nil, fmt.Errorf("cannot convert string '%s' to integer", val)
 
 		case "bigint", "int8":
 			if num, err := strconv.ParseInt(val, 10, 64); err == nil {
-				*args = append(*args, num)
-				*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-				*paramCount++
-				return nil
+				return num, nil
 			}
-			return fmt.Errorf("cannot convert string '%s' to bigint", val)
+			return nil, fmt.Errorf("cannot convert string '%s' to bigint", val)
 
 		case "smallint", "int2":
 			if num, err := strconv.ParseInt(val, 10, 16); err == nil {
-				*args = append(*args, int16(num))
-				*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-				*paramCount++
-				return nil
+				return int16(num), nil
 			}
-			return fmt.Errorf("cannot convert string '%s' to smallint", val)
+			return nil, fmt.Errorf("cannot convert string '%s' to smallint", val)
 
 		case "real", "float4":
 			if num, err := strconv.ParseFloat(val, 32); err == nil {
-				*args = append(*args, float32(num))
-				*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-				*paramCount++
-				return nil
+				return float32(num), nil
 			}
-			return fmt.Errorf("cannot convert string '%s' to real", val)
+			return nil, fmt.Errorf("cannot convert string '%s' to real", val)
 
 		case "double precision", "float8":
 			if num, err := strconv.ParseFloat(val, 64); err == nil {
-				*args = append(*args, num)
-				*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-				*paramCount++
-				return nil
+				return num, nil
 			}
-			return fmt.Errorf("cannot convert string '%s' to double precision", val)
+			return nil, fmt.Errorf("cannot convert string '%s' to double precision", val)
 
 		case "boolean", "bool":
 			if lower := strings.ToLower(val); lower == "true" || lower == "1" || lower == "t" {
-				*args = append(*args, true)
-				*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-				*paramCount++
-				return nil
+				return true, nil
 			} else if lower == "false" || lower == "0" || lower == "f" {
-				*args = append(*args, false)
-				*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-				*paramCount++
-				return nil
+				return false, nil
 			}
-			return fmt.Errorf("cannot convert string '%s' to boolean", val)
+			return nil, fmt.Errorf("cannot convert string '%s' to boolean", val)
 
-		case "date", "timestamp", "timestamptz":
+		case "date":
 			if parsed, err := time.Parse("2006-01-02", val); err == nil {
-				*args = append(*args, parsed)
-				*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-				*paramCount++
-				return nil
-			} else if parsed, err := time.Parse("2006-01-02 15:04:05", val); err == nil {
-				*args = append(*args, parsed)
-				*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-				*paramCount++
-				return nil
-			} else if parsed, err := time.Parse(time.RFC3339, val); err == nil {
-				*args = append(*args, parsed)
-				*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-				*paramCount++
-				return nil
+				return parsed, nil
+			}
+			return ApiUtils.ParseTimestampInZone(val, loc)
+
+		case "timestamp", "timestamptz", "datetime":
+			parsed, err := ApiUtils.ParseTimestampInZone(val, loc)
+			if err != nil {
+				return nil, err
 			}
-			return fmt.Errorf("cannot convert string '%s' to timestamp", val)
+			return bindTimestamp(db_field_data_type, parsed), nil
 
 		case "text[]", "varchar[]", "string[]":
 			// If the string represents a JSON array like '["item1", "item2"]'
 			var stringArray []string
 			if err := json.Unmarshal([]byte(val), &stringArray); err == nil {
-				*args = append(*args, pq.Array(stringArray))
-			} else {
-				// If it's not a JSON array, treat as single-element array
-				*args = append(*args, pq.Array([]string{val}))
+				return pq.Array(stringArray), nil
 			}
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			// If it's not a JSON array, treat as single-element array
+			return pq.Array([]string{val}), nil
 
 		default:
-			return fmt.Errorf("unsupported database field type '%s' for string value", db_field_data_type)
+			return nil, fmt.Errorf("unsupported database field type '%s' for string value", db_field_data_type)
 		}
 
 	case int:
 		switch db_field_data_type {
 		case "integer", "int", "int4":
-			*args = append(*args, val)
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return val, nil
 
 		case "bigint", "int8":
-			*args = append(*args, int64(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return int64(val), nil
 
 		case "smallint", "int2":
 			if val < math.MinInt16 || val > math.MaxInt16 {
-				return fmt.Errorf("integer value %d out of range for smallint", val)
+				return nil, fmt.Errorf("integer value %d out of range for smallint", val)
 			}
-			*args = append(*args, int16(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return int16(val), nil
 
 		case "real", "float4":
-			*args = append(*args, float32(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return float32(val), nil
 
 		case "double precision", "float8":
-			*args = append(*args, float64(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return float64(val), nil
 
 		case "text", "varchar", "char", "string":
-			*args = append(*args, strconv.Itoa(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return strconv.Itoa(val), nil
 
 		default:
-			return fmt.Errorf("unsupported database field type '%s' for int value", db_field_data_type)
+			return nil, fmt.Errorf("unsupported database field type '%s' for int value", db_field_data_type)
 		}
 
 	case int64:
 		switch db_field_data_type {
 		case "bigint", "int8":
-			*args = append(*args, val)
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return val, nil
 
 		case "integer", "int", "int4":
 			if val < math.MinInt32 || val > math.MaxInt32 {
-				return fmt.Errorf("bigint value %d out of range for integer", val)
+				return nil, fmt.Errorf("bigint value %d out of range for integer", val)
 			}
-			*args = append(*args, int32(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return int32(val), nil
 
 		case "smallint", "int2":
 			if val < math.MinInt16 || val > math.MaxInt16 {
-				return fmt.Errorf("bigint value %d out of range for smallint", val)
+				return nil, fmt.Errorf("bigint value %d out of range for smallint", val)
 			}
-			*args = append(*args, int16(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return int16(val), nil
 
 		case "real", "float4":
-			*args = append(*args, float32(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return float32(val), nil
 
 		case "double precision", "float8":
-			*args = append(*args, float64(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return float64(val), nil
 
 		case "text", "varchar", "char", "string":
-			*args = append(*args, strconv.FormatInt(val, 10))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return strconv.FormatInt(val, 10), nil
 
 		default:
-			return fmt.Errorf("unsupported database field type '%s' for int64 value", db_field_data_type)
+			return nil, fmt.Errorf("unsupported database field type '%s' for int64 value", db_field_data_type)
 		}
 
 	case float64: // JSON numbers are typically float64
 		switch db_field_data_type {
 		case "double precision", "float8":
-			*args = append(*args, val)
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return val, nil
 
 		case "real", "float4":
-			*args = append(*args, float32(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return float32(val), nil
 
 		case "integer", "int", "int4":
 			if val < math.MinInt32 || val > math.MaxInt32 {
-				return fmt.Errorf("float value %f out of range for integer", val)
+				return nil, fmt.Errorf("float value %f out of range for integer", val)
 			}
-			*args = append(*args, int32(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return int32(val), nil
 
 		case "bigint", "int8":
 			if val < math.MinInt64 || val > math.MaxInt64 {
-				return fmt.Errorf("float value %f out of range for bigint", val)
+				return nil, fmt.Errorf("float value %f out of range for bigint", val)
 			}
-			*args = append(*args, int64(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return int64(val), nil
 
 		case "text", "varchar", "char", "string":
-			*args = append(*args, strconv.FormatFloat(val, 'g', -1, 64))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return strconv.FormatFloat(val, 'g', -1, 64), nil
 
 		default:
-			return fmt.Errorf("unsupported database field type '%s' for float64 value", db_field_data_type)
+			return nil, fmt.Errorf("unsupported database field type '%s' for float64 value", db_field_data_type)
 		}
 
 	case bool:
 		switch db_field_data_type {
 		case "boolean", "bool":
-			*args = append(*args, val)
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return val, nil
 
 		case "text", "varchar", "char", "string":
-			*args = append(*args, strconv.FormatBool(val))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return strconv.FormatBool(val), nil
 
 		default:
-			return fmt.Errorf("unsupported database field type '%s' for bool value", db_field_data_type)
+			return nil, fmt.Errorf("unsupported database field type '%s' for bool value", db_field_data_type)
+		}
+
+	case time.Time:
+		switch db_field_data_type {
+		case "timestamp", "timestamptz", "datetime":
+			return bindTimestamp(db_field_data_type, val), nil
+
+		case "date":
+			return val, nil
+
+		default:
+			return nil, fmt.Errorf("unsupported database field type '%s' for time value", db_field_data_type)
 		}
 
 	case nil:
-		*args = append(*args, nil)
-		*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-		*paramCount++
-		return nil
+		return nil, nil
 
 	case []interface{}:
 		switch db_field_data_type {
@@ -369,10 +331,7 @@ func handleValue(
 			// Join array elements with a delimiter (comma, pipe, etc.)
 			// You can customize the delimiter based on your needs
 			resultString := strings.Join(stringParts, ",")
-			*args = append(*args, resultString)
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return resultString, nil
 
 		case "text[]", "varchar[]", "string[]":
 			stringArray := make([]string, len(val))
@@ -383,10 +342,7 @@ func handleValue(
 					stringArray[i] = ""
 				}
 			}
-			*args = append(*args, pq.Array(stringArray))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return pq.Array(stringArray), nil
 
 		case "integer[]", "int[]", "int4[]":
 			intArray := make([]int, len(val))
@@ -400,21 +356,18 @@ func handleValue(
 					if num, err := strconv.Atoi(v); err == nil {
 						intArray[i] = num
 					} else {
-						return fmt.Errorf("cannot convert array element '%s' to integer", v)
+						return nil, fmt.Errorf("cannot convert array element '%s' to integer", v)
 					}
 				case nil:
 					intArray[i] = 0
 				default:
-					return fmt.Errorf("unsupported type %T for integer array element", v)
+					return nil, fmt.Errorf("unsupported type %T for integer array element", v)
 				}
 			}
-			*args = append(*args, pq.Array(intArray))
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return pq.Array(intArray), nil
 
 		default:
-			return fmt.Errorf("unsupported database field type '%s' for array value", db_field_data_type)
+			return nil, fmt.Errorf("unsupported database field type '%s' for array value", db_field_data_type)
 		}
 
 	default:
@@ -422,45 +375,83 @@ func handleValue(
 		strVal := fmt.Sprintf("%v", val)
 		switch db_field_data_type {
 		case "text", "varchar", "char", "string":
-			*args = append(*args, strVal)
-			*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-			*paramCount++
-			return nil
+			return strVal, nil
 
 		default:
-			return fmt.Errorf("unsupported database field type '%s' for value type %T", db_field_data_type, val)
+			return nil, fmt.Errorf("unsupported database field type '%s' for value type %T", db_field_data_type, val)
 		}
 	}
 }
 
+// bindTimestamp returns what is bound for 't' in a field of type
+// 'db_field_data_type'. A timestamptz keeps its instant. A naive timestamp
+// has no zone to convert to, so it gets the UTC wall clock.
+func bindTimestamp(db_field_data_type string, t time.Time) time.Time {
+	if db_field_data_type == "timestamptz" {
+		return t
+	}
+	return t.UTC()
+}
+
+// convertJSONValue encodes 'value' for a json/jsonb field. A string that is
+// already valid JSON is stored as is; any other value is marshaled.
+func convertJSONValue(value interface{}) (interface{}, error) {
+	switch val := value.(type) {
+	case nil:
+		return nil, nil
+
+	case string:
+		if json.Valid([]byte(val)) {
+			return val, nil
+		}
+	}
+
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert %T to json: %w", value, err)
+	}
+	return string(bytes), nil
+}
+
 func handleArrayValue(
 	fieldDef ApiTypes.FieldDef,
 	value interface{},
 	args *[]interface{},
 	placeholders *[]string,
 	paramCount *int) error {
+	converted, err := convertArrayValue(fieldDef, value)
+	if err != nil {
+		return err
+	}
+	*args = append(*args, converted)
+	*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
+	*paramCount++
+	return nil
+}
+
+// convertArrayValue converts 'value' to a pq array of the field's
+// ElementType. Like convertFieldValue, it has no side effects.
+func convertArrayValue(fieldDef ApiTypes.FieldDef, value interface{}) (interface{}, error) {
 	switch fieldDef.ElementType {
 	case "string":
-		return handleStrArray(value, args, placeholders, paramCount)
+		return pq.Array(convertStrArray(value)), nil
 
 	case "int32":
-		return handleInt32Array(value, args, placeholders, paramCount)
+		intArray, err := convertInt32Array(value)
+		return pq.Array(intArray), err
 
 	case "int64":
-		return handleInt64Array(value, args, placeholders, paramCount)
+		intArray, err := convertInt64Array(value)
+		return pq.Array(intArray), err
 
 	default:
-		error_msg := fmt.Sprintf("array element data type not supported:%s", fieldDef.DataType)
+		error_msg := fmt.Sprintf("array element data type not supported:%s", fieldDef.ElementType)
 		log.Printf("***** Alarm:%s (SHD_DUP_096)", error_msg)
-		return fmt.Errorf("%s", error_msg)
+		return nil, fmt.Errorf("%s", error_msg)
 	}
 }
 
-func handleStrArray(
-	value interface{},
-	args *[]interface{},
-	placeholders *[]string,
-	paramCount *int) error {
+func convertStrArray(value interface{}) []string {
 	// Convert the value to string array
 	var stringArray []string
 
@@ -497,17 +488,10 @@ func handleStrArray(
 		stringArray = []string{fmt.Sprintf("%v", v)}
 	}
 
-	*args = append(*args, pq.Array(stringArray))
-	*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-	*paramCount++
-	return nil
+	return stringArray
 }
 
-func handleInt32Array(
-	value interface{},
-	args *[]interface{},
-	placeholders *[]string,
-	paramCount *int) error {
+func convertInt32Array(value interface{}) ([]int32, error) {
 	// Convert the value to integer array
 	var intArray []int32
 
@@ -519,7 +503,7 @@ func handleInt32Array(
 	case []int:
 		intArray = make([]int32, len(v))
 		for i, item := range v {
-			if item < math.MinInt || item > math.MaxInt {
+			if item < math.MinInt32 || item > math.MaxInt32 {
 				error_msg += fmt.Sprintf("Value out of bound, idx:%d, value:%d (01). ", i, item)
 			}
 			intArray[i] = int32(item)
@@ -533,13 +517,13 @@ func handleInt32Array(
 				// Convert to int - you might want to handle conversion errors
 				switch val := item.(type) {
 				case int:
-					if val < math.MinInt || val > math.MaxInt {
+					if val < math.MinInt32 || val > math.MaxInt32 {
 						error_msg += fmt.Sprintf("Value out of bound, idx:%d, value:%d (02). ", i, val)
 					}
 					intArray[i] = int32(val)
 
 				case int64:
-					if val < math.MinInt || val > math.MaxInt {
+					if val < math.MinInt32 || val > math.MaxInt32 {
 						error_msg += fmt.Sprintf("Value out of bound, idx:%d, value:%d (03). ", i, val)
 					}
 					intArray[i] = int32(val)
@@ -548,7 +532,7 @@ func handleInt32Array(
 					intArray[i] = val
 
 				case float64: // JSON numbers are often float64
-					if val < math.MinInt || val > math.MaxInt {
+					if val < math.MinInt32 || val > math.MaxInt32 {
 						error_msg += fmt.Sprintf("Value out of bound, idx:%d, value:%v (04). ", i, val)
 					}
 					intArray[i] = int32(val)
@@ -556,7 +540,7 @@ func handleInt32Array(
 				case string:
 					// Convert string to int if possible. 'num' is int64.
 					if num, err := strconv.Atoi(val); err == nil {
-						if num < math.MinInt || num > math.MaxInt {
+						if num < math.MinInt32 || num > math.MaxInt32 {
 							error_msg += fmt.Sprintf("Value out of bound, idx:%d, value:%s (05). ", i, val)
 						}
 						intArray[i] = int32(num)
@@ -575,14 +559,14 @@ func handleInt32Array(
 
 	case int:
 		// If it's a single int, treat it as single-element array
-		if v < math.MinInt || v > math.MaxInt {
+		if v < math.MinInt32 || v > math.MaxInt32 {
 			error_msg += fmt.Sprintf("Value out of bound:%d (08). ", v)
 		}
 		intArray = []int32{int32(v)}
 
 	case int64:
 		// If it's a single int64, treat it as single-element array
-		if v < math.MinInt || v > math.MaxInt {
+		if v < math.MinInt32 || v > math.MaxInt32 {
 			error_msg += fmt.Sprintf("Value out of bound:%d (08). ", v)
 		}
 		intArray = []int32{int32(v)}
@@ -590,7 +574,7 @@ func handleInt32Array(
 	case string:
 		// If it's a string, try to convert to int
 		if num, err := strconv.Atoi(v); err == nil {
-			if num < math.MinInt || num > math.MaxInt {
+			if num < math.MinInt32 || num > math.MaxInt32 {
 				error_msg += fmt.Sprintf("Value out of bound:%s (09). ", v)
 			}
 			intArray = []int32{int32(num)}
@@ -607,13 +591,13 @@ func handleInt32Array(
 		var num int32
 		switch val := v.(type) {
 		case int:
-			if val < math.MinInt || val > math.MaxInt {
+			if val < math.MinInt32 || val > math.MaxInt32 {
 				error_msg += fmt.Sprintf("Value out of bound:%d (11). ", val)
 			}
 			num = int32(val)
 
 		case int64:
-			if val < math.MinInt || val > math.MaxInt {
+			if val < math.MinInt32 || val > math.MaxInt32 {
 				error_msg += fmt.Sprintf("Value out of bound:%d (12). ", val)
 			}
 			num = int32(val)
@@ -622,7 +606,7 @@ func handleInt32Array(
 			num = val
 
 		case float64:
-			if val < math.MinInt || val > math.MaxInt {
+			if val < math.MinInt32 || val > math.MaxInt32 {
 				error_msg += fmt.Sprintf("Value out of bound:%f (12). ", val)
 			}
 			num = int32(val)
@@ -633,17 +617,13 @@ func handleInt32Array(
 		intArray = []int32{num}
 	}
 
-	*args = append(*args, pq.Array(intArray))
-	*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-	*paramCount++
-	return nil
+	if error_msg != "" {
+		return intArray, fmt.Errorf("%s", strings.TrimSpace(error_msg))
+	}
+	return intArray, nil
 }
 
-func handleInt64Array(
-	value interface{},
-	args *[]interface{},
-	placeholders *[]string,
-	paramCount *int) error {
+func convertInt64Array(value interface{}) ([]int64, error) {
 	// Convert the value to integer array
 	var intArray []int64
 
@@ -740,10 +720,10 @@ func handleInt64Array(
 		intArray = []int64{num}
 	}
 
-	*args = append(*args, pq.Array(intArray))
-	*placeholders = append(*placeholders, fmt.Sprintf("$%d", *paramCount))
-	*paramCount++
-	return nil
+	if error_msg != "" {
+		return intArray, fmt.Errorf("%s", strings.TrimSpace(error_msg))
+	}
+	return intArray, nil
 }
 
 func CreateOnConflictPG(resource_request ApiTypes.InsertRequest) (string, error) {
@@ -807,3 +787,52 @@ func PgCopy(
 	)
 	return err
 }
+
+// pgCopyIn loads rows into a table with COPY FROM STDIN in a transaction.
+// It is the database/sql counterpart of PgCopy, for the lib/pq handles
+// the project DB is opened with.
+type pgCopyIn struct {
+	stmt        *sql.Stmt
+	num_columns int
+}
+
+// newPgCopyIn starts a COPY into 'tableName'. pq.CopyIn quotes the names,
+// so they are lowercased the way PostgreSQL folds them in INSERT. A
+// schema-qualified name is split so each part is quoted on its own.
+func newPgCopyIn(tx *sql.Tx, tableName string, columns []string) (*pgCopyIn, error) {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = strings.ToLower(col)
+	}
+	copy_sql := pq.CopyIn(strings.ToLower(tableName), names...)
+	if schema, table, ok := strings.Cut(strings.ToLower(tableName), "."); ok {
+		copy_sql = pq.CopyInSchema(schema, table, names...)
+	}
+	stmt, err := tx.Prepare(copy_sql)
+	if err != nil {
+		return nil, err
+	}
+	return &pgCopyIn{stmt: stmt, num_columns: len(columns)}, nil
+}
+
+// add copies the rows in 'args', num_columns values per row
+func (c *pgCopyIn) add(args []interface{}) error {
+	for start := 0; start+c.num_columns <= len(args); start += c.num_columns {
+		if _, err := c.stmt.Exec(args[start : start+c.num_columns]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish sends the buffered rows and ends the COPY
+func (c *pgCopyIn) finish() error {
+	if _, err := c.stmt.Exec(); err != nil {
+		return err
+	}
+	return c.close()
+}
+
+func (c *pgCopyIn) close() error {
+	return c.stmt.Close()
+}
@@ -0,0 +1,222 @@
+package RequestHandlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/lib/pq"
+)
+
+func TestCreateValueGroupsPG(t *testing.T) {
+	field_defs := []ApiTypes.FieldDef{
+		{FieldName: "id", DataType: "_auto_inc"},
+		{FieldName: "name", DataType: "string", Required: true},
+		{FieldName: "score", DataType: "int"},
+		{FieldName: "tags", DataType: "array", ElementType: "string", Required: true},
+		{FieldName: "created_by", DataType: "_creator"},
+		{FieldName: "updated_by", DataType: "_updater"},
+		{FieldName: "note", DataType: "_ignore"},
+	}
+
+	tests := []struct {
+		name       string
+		records    []map[string]interface{}
+		wantGroups []string
+		wantArgs   []interface{}
+		wantErr    string
+	}{
+		{
+			name: "placeholders continue across records",
+			records: []map[string]interface{}{
+				{"name": "alice", "score": float64(7), "tags": []interface{}{"a", "b"}, "note": "skipped"},
+				{"name": "bob", "score": "8"},
+			},
+			wantGroups: []string{"($1,$2,$3,$4,$5)", "($6,$7,$8,$9,$10)"},
+			wantArgs: []interface{}{
+				"alice", int32(7), pq.Array([]string{"a", "b"}), "tester", "tester",
+				"bob", 8, pq.Array([]string{}), "tester", "tester",
+			},
+		},
+		{
+			name: "optional field left out",
+			records: []map[string]interface{}{
+				{"name": "carol", "tags": "solo"},
+			},
+			wantGroups: []string{"($1,$2,$3,$4,$5)"},
+			wantArgs: []interface{}{
+				"carol", nil, pq.Array([]string{"solo"}), "tester", "tester",
+			},
+		},
+		{
+			name:    "missing required field",
+			records: []map[string]interface{}{{"score": 1}},
+			wantErr: "missing required field: name",
+		},
+		{
+			name:    "value of the wrong type",
+			records: []map[string]interface{}{{"name": "dave", "score": "many"}},
+			wantErr: "invalid value for field score",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, args, err := CreateValueGroupsPG("tester", field_defs, tt.records, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(groups, tt.wantGroups) {
+				t.Errorf("value groups = %q, want %q", groups, tt.wantGroups)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCreateValueGroupsPGSparseRecords(t *testing.T) {
+	field_defs := []ApiTypes.FieldDef{
+		{FieldName: "name", DataType: "string", Required: true},
+		{FieldName: "email", DataType: "string"},
+		{FieldName: "meta", DataType: "jsonb"},
+		{FieldName: "labels", DataType: "array", ElementType: "string"},
+		{FieldName: "ids", DataType: "array", ElementType: "int64"},
+		{FieldName: "created_by", DataType: "_creator"},
+	}
+
+	records := []map[string]interface{}{
+		{"name": "alice", "email": "a@x.com", "labels": []interface{}{"x"}},
+		{"name": "bob", "meta": map[string]interface{}{"k": 1}, "ids": []interface{}{float64(3)}},
+		{"name": "carol", "email": nil, "labels": nil},
+	}
+
+	groups, args, err := CreateValueGroupsPG("tester", field_defs, records, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantGroups := []string{"($1,$2,$3,$4,$5,$6)", "($7,$8,$9,$10,$11,$12)", "($13,$14,$15,$16,$17,$18)"}
+	if !reflect.DeepEqual(groups, wantGroups) {
+		t.Errorf("value groups = %q, want %q", groups, wantGroups)
+	}
+
+	// Missing and null optional fields are bound as NULL in their own slot
+	wantArgs := []interface{}{
+		"alice", "a@x.com", nil, pq.Array([]string{"x"}), nil, "tester",
+		"bob", nil, `{"k":1}`, nil, pq.Array([]int64{3}), "tester",
+		"carol", nil, nil, nil, nil, "tester",
+	}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestHandleValue(t *testing.T) {
+	new_york := mustLoadLocation(t, "America/New_York")
+	kolkata := mustLoadLocation(t, "Asia/Kolkata")
+
+	tests := []struct {
+		name      string
+		data_type string
+		value     interface{}
+		loc       *time.Location
+		want      interface{}
+		wantErr   string
+	}{
+		{name: "text", data_type: "text", value: "hello", want: "hello"},
+		{name: "int from string", data_type: "int", value: "42", want: 42},
+		{name: "bigint from json number", data_type: "bigint", value: float64(7), want: int64(7)},
+		{name: "integer from json number", data_type: "integer", value: float64(7), want: int32(7)},
+		{name: "smallint from int", data_type: "smallint", value: 12, want: int16(12)},
+		{name: "double from int64", data_type: "float8", value: int64(3), want: float64(3)},
+		{name: "string from int", data_type: "string", value: 5, want: "5"},
+		{name: "bool from string", data_type: "boolean", value: "t", want: true},
+		{name: "bool", data_type: "bool", value: false, want: false},
+		{name: "date", data_type: "date", value: "2024-01-02", want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{name: "timestamp", data_type: "timestamp", value: "2024-01-02 03:04:05", want: fixtureCreatedAt},
+		{name: "naive timestamp in zone", data_type: "timestamp", value: "2024-01-01 22:04:05",
+			loc: new_york, want: fixtureCreatedAt},
+		{name: "naive timestamp from offset", data_type: "timestamp", value: "2024-01-02T08:34:05+05:30",
+			loc: new_york, want: fixtureCreatedAt},
+		{name: "timestamptz offset wins over zone", data_type: "timestamptz", value: "2024-01-02T03:04:05Z",
+			loc: kolkata, want: fixtureCreatedAt},
+		{name: "timestamptz in zone during DST", data_type: "timestamptz", value: "2024-07-01 09:00:00",
+			loc: new_york, want: time.Date(2024, 7, 1, 13, 0, 0, 0, time.UTC)},
+		{name: "datetime from time value", data_type: "datetime",
+			value: fixtureCreatedAt.In(kolkata), want: fixtureCreatedAt},
+		{name: "timestamp skipped by DST", data_type: "timestamptz", value: "2024-03-10 02:30:00",
+			loc: new_york, wantErr: "does not exist in time zone America/New_York"},
+		{name: "bad timestamp", data_type: "timestamp", value: "01/02/2024", wantErr: "expecting RFC3339"},
+		{name: "json from map", data_type: "jsonb", value: map[string]interface{}{"a": 1}, want: `{"a":1}`},
+		{name: "json string kept", data_type: "json", value: `[1,2]`, want: `[1,2]`},
+		{name: "text array from json string", data_type: "text[]", value: `["a","b"]`, want: pq.Array([]string{"a", "b"})},
+		{name: "int array", data_type: "int[]", value: []interface{}{float64(1), "2"}, want: pq.Array([]int{1, 2})},
+		{name: "nil", data_type: "int", value: nil, want: nil},
+		{name: "smallint out of range", data_type: "smallint", value: 40000, wantErr: "out of range for smallint"},
+		{name: "bad integer string", data_type: "int", value: "x1", wantErr: "cannot convert string 'x1' to integer"},
+		{name: "bad boolean", data_type: "bool", value: "maybe", wantErr: "cannot convert string 'maybe' to boolean"},
+		{name: "unsupported type", data_type: "uuid", value: "abc", wantErr: "unsupported database field type 'uuid'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := []interface{}{"first"}
+			placeholders := []string{"$1"}
+			param_count := 2
+
+			err := handleValue(tt.data_type, tt.value, tt.loc, &args, &placeholders, &param_count)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				// A failed value must leave the statement untouched
+				if len(args) != 1 || len(placeholders) != 1 || param_count != 2 {
+					t.Errorf("state changed on error: args=%v placeholders=%v count=%d",
+						args, placeholders, param_count)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if want, ok := tt.want.(time.Time); ok {
+				// Zones are compared by instant; naive timestamps must be UTC
+				got, _ := args[1].(time.Time)
+				if !got.Equal(want) {
+					t.Errorf("time = %v, want %v", got, want)
+				}
+				if tt.data_type != "timestamptz" && got.Location() != time.UTC {
+					t.Errorf("time zone = %v, want UTC", got.Location())
+				}
+			} else if !reflect.DeepEqual(args, []interface{}{"first", tt.want}) {
+				t.Errorf("args = %#v, want [first %#v]", args, tt.want)
+			}
+			if !reflect.DeepEqual(placeholders, []string{"$1", "$2"}) {
+				t.Errorf("placeholders = %q, want [$1 $2]", placeholders)
+			}
+			if param_count != 3 {
+				t.Errorf("param count = %d, want 3", param_count)
+			}
+		})
+	}
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("load location %s: %v", name, err)
+	}
+	return loc
+}
@@ -73,6 +73,38 @@ to construct the conditions.
 	    },
 	}
 
+// Example 4: NOT condition: NOT (status = 'x' OR status = 'y'). A NOT
+// condition must have exactly one sub-condition.
+
+	notCondition := ApiTypes.Condition{
+	    Type: ApiTypes.ConditionTypeNot,
+	    Conditions: []ApiTypes.Condition{
+	        {
+	            Type: ApiTypes.ConditionTypeOr,
+	            Conditions: []ApiTypes.Condition{
+	                {Type: ApiTypes.ConditionTypeAtomic, FieldName: "status", Opr: "equal", Value: "x", DataType: "string"},
+	                {Type: ApiTypes.ConditionTypeAtomic, FieldName: "status", Opr: "equal", Value: "y", DataType: "string"},
+	            },
+	        },
+	    },
+	}
+
+// Example 5: EXISTS condition: the users with at least one order over 100.
+// The sub-query is correlated by its on_clause; a not_exists condition
+// keeps the users without one. Sub-queries cannot be nested.
+
+	existsCondition := ApiTypes.Condition{
+	    Type: ApiTypes.ConditionTypeExists,
+	    SubQuery: &ApiTypes.SubQueryDef{
+	        TableName: "orders",
+	        OnClause:  []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+	        FieldDefs: ordersFieldDefs,
+	        Condition: &ApiTypes.Condition{
+	            Type: ApiTypes.ConditionTypeAtomic, FieldName: "amount", Opr: "greater_than", Value: 100, DataType: "number",
+	        },
+	    },
+	}
+
 **********************************************************
 */
 package RequestHandlers
@@ -81,16 +113,23 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
 
 	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
 	"github.com/chendingplano/shared/go/api/EchoFactory"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+	"github.com/chendingplano/shared/go/api/stores"
 	"github.com/chendingplano/shared/go/api/sysdatastores"
 	"github.com/labstack/echo/v4"
 )
@@ -108,6 +147,10 @@ const (
 	NotEqual     Operator = "<>"
 	Contain      Operator = "contain"
 	Prefix       Operator = "prefix"
+	Suffix       Operator = "suffix"
+	IContain     Operator = "icontain"
+	IPrefix      Operator = "iprefix"
+	MultiContain Operator = "multi_contain"
 )
 
 func HandleJimoRequestEcho(c echo.Context) error {
@@ -126,6 +169,9 @@ func HandleJimoRequestEcho(c echo.Context) error {
 
 	status_code, resp := handleJimoRequestPriv(new_ctx, rc, body)
 	defer c.Request().Body.Close()
+	if breach, ok := resp.Results.(*ApiTypes.QuotaBreach); ok {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(breach.RetryAfterSec))
+	}
 	c.JSON(status_code, resp)
 	return nil
 }
@@ -140,26 +186,7 @@ func handleJimoRequestPriv(
 	user_info := rc.IsAuthenticated()
 	new_ctx := context.WithValue(ctx, ApiTypes.CallFlowKey, fmt.Sprintf("%s->SHD_RHD_135", call_flow))
 	if user_info == nil {
-		log_id := sysdatastores.NextActivityLogID()
-		error_msg := fmt.Sprintf("auth failed, log_id:%d", log_id)
-		new_call_flow := fmt.Sprintf("%s->SHD_RHD_139", call_flow)
-		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
-			LogID:        log_id,
-			ActivityName: ApiTypes.ActivityName_JimoRequest,
-			ActivityType: ApiTypes.ActivityType_AuthFailure,
-			AppName:      ApiTypes.AppName_RequestHandler,
-			ModuleName:   ApiTypes.ModuleName_RequestHandler,
-			ActivityMsg:  &error_msg,
-			CallerLoc:    new_call_flow})
-
-		logger.Error("HandleJimoRequest", "error_msg", error_msg)
-		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
-		}
-		return ApiTypes.CustomHttpStatus_NotLoggedIn, resp
+		return notAuthenticatedResponse(rc, fmt.Sprintf("%s->SHD_RHD_139", call_flow))
 	}
 
 	logger.Info("HandleJimoRequest", "email", user_info.Email)
@@ -181,22 +208,42 @@ func handleJimoRequestPriv(
 
 		logger.Error("HandleJimoRequest", "error", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
 
-	// Step 3: Decode the full request based on request_type
+	// Step 3: Check the quotas. Requests by resource are counted against
+	// the resource name, as the table is only known once it is resolved.
+	// A name that can't be a table is counted against the user only.
 	var user_name = user_info.UserName
+	var table_name = genericReq.TableName
+	if table_name == "" {
+		table_name = genericReq.ResourceName
+	}
+	switch genericReq.RequestType {
+	case ApiTypes.ReqAction_Insert, ApiTypes.ReqAction_Query,
+		ApiTypes.ReqAction_Update, ApiTypes.ReqAction_Delete:
+		if breach := quotas.Acquire(user_name, table_name, genericReq.RequestType); breach != nil {
+			return quotaExceededResponse(rc, breach, call_flow)
+		}
+	}
+
+	// Step 4: Decode the full request based on request_type
 	switch genericReq.RequestType {
 	case ApiTypes.ReqAction_Insert:
 		return HandleDBInsert(new_ctx, rc, body, user_name)
 
 	case ApiTypes.ReqAction_Query:
-		return HandleDBQuery(new_ctx, rc, body, user_name)
+		status_code, resp := HandleDBQuery(new_ctx, rc, body, user_name)
+		if resp.Status {
+			quotas.RecordRows(user_name, table_name, int64(resp.NumRecords))
+		}
+		return status_code, resp
 
 	case ApiTypes.ReqAction_Update:
 		return HandleDBUpdate(new_ctx, rc, body, user_name)
@@ -219,16 +266,42 @@ func handleJimoRequestPriv(
 			CallerLoc:    new_call_flow})
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
 }
 
+// notAuthenticatedResponse logs and returns the response to a request
+// without a logged-in user
+func notAuthenticatedResponse(rc ApiTypes.RequestContext, call_flow string) (int, ApiTypes.JimoResponse) {
+	log_id := sysdatastores.NextActivityLogID()
+	error_msg := fmt.Sprintf("auth failed, log_id:%d", log_id)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		LogID:        log_id,
+		ActivityName: ApiTypes.ActivityName_JimoRequest,
+		ActivityType: ApiTypes.ActivityType_AuthFailure,
+		AppName:      ApiTypes.AppName_RequestHandler,
+		ModuleName:   ApiTypes.ModuleName_RequestHandler,
+		ActivityMsg:  &error_msg,
+		CallerLoc:    call_flow})
+
+	rc.GetLogger().Error("HandleJimoRequest", "error_msg", error_msg)
+	resp := ApiTypes.JimoResponse{
+		Status:    false,
+		ReqID:     rc.ReqID(),
+		ErrorMsg:  error_msg,
+		ErrorKind: ApiTypes.ErrorKind_NotAuthenticated,
+		Loc:       call_flow,
+	}
+	return ApiTypes.CustomHttpStatus_NotLoggedIn, resp
+}
+
 func HandleDBQuery(
 	ctx context.Context,
 	rc ApiTypes.RequestContext,
@@ -245,69 +318,8 @@ func HandleDBQuery(
 	//	- Get the conditions
 	//	- Construct the query statement
 	//	- Run the query statement
-	/*
-			resource_name := req.ResourceName
-			resource_def, err := stores.GetResourceDef(resource_name, "query")
-			if err != nil {
-				error_msg := fmt.Sprintf("resource not found, error:%v, resource_name:%s, loc:%s",
-						err, resource_name, req.Loc)
-				logger.Info("+++++ WARNING:%s (SHD_RHD_165)", error_msg)
-
-				resp := ApiTypes.JimoResponse {
-					Status: false,
-					ReqID:	  reqID,
-					ErrorMsg: error_msg,
-					Loc: "SHD_RHD_134",
-				}
-				c.JSON(ApiTypes.CustomHttpStatus_BadRequest, resp)
-				return fmt.Errorf("%s", error_msg)
-			}
-
-			if resource_def.ResourceDef.ResourceType != ApiTypes.ResourceType_Table {
-				error_msg := fmt.Sprintf("incorrect resource type, expecting:%s, actual:%s",
-						ApiTypes.ResourceType_Table, resource_def.ResourceDef.ResourceType)
-				logger.Info("***** Alarm:%s (SHD_RHD_193)", error_msg)
-
-				resp := ApiTypes.JimoResponse {
-					Status: false,
-					ReqID:	  reqID,
-					ErrorMsg: error_msg,
-					Loc: "SHD_RHD_148",
-				}
-				c.JSON(http.StatusBadRequest, resp)
-				return fmt.Errorf("%s", error_msg)
-			}
-
-			resource_json := resource_def.ResourceDef.ResourceJSON
-			if resource_json == nil {
-			    log_id := sysdatastores.NextActivityLogID()
-				error_msg := fmt.Sprintf("missing resource def, resource_name:%s, log_id:%d", resource_name, log_id)
-				logger.Info("***** Alarm:%s (SHD_RHD_208)", error_msg)
-
-				sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
-		            LogID:              log_id,
-					ActivityName: 		ApiTypes.ActivityName_JimoRequest,
-					ActivityType: 		ApiTypes.ActivityType_InternalError,
-					AppName: 			ApiTypes.AppName_RequestHandler,
-					ModuleName: 		ApiTypes.ModuleName_RequestHandler,
-					ActivityMsg: 		&error_msg,
-					CallerLoc: 			"SHD_RHD_217"})
-
-				resp := ApiTypes.JimoResponse {
-					Status: false,
-					ReqID:	  reqID,
-					ErrorMsg: error_msg,
-					Loc: "SHD_RHD_222",
-				}
-				c.JSON(http.StatusBadRequest, resp)
-				return fmt.Errorf("%s", error_msg)
-			}
-
-			db_name := resource_def.ResourceDef.DBName
-			table_name := resource_def.ResourceDef.TableName
-	*/
 	var req ApiTypes.QueryRequest
-	if err := json.Unmarshal(body, &req); err != nil {
+	if err := decodeJimoRequest(body, &req); err != nil {
 		log_id := sysdatastores.NextActivityLogID()
 		error_msg := fmt.Sprintf("failed parse request_type:%v, log_id:%d", err, log_id)
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_305", call_flow)
@@ -326,25 +338,97 @@ func HandleDBQuery(
 			ReqID:     reqID,
 			TableName: req.TableName,
 			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
 			Loc:       new_call_flow,
 		}
 
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
 
-	query, args, selected_fields, aliases, field_def_map, err := buildQuery(rc, new_ctx, req)
+	if status_code, resp := prepareQueryRequest(new_ctx, rc, &req, call_flow); resp != nil {
+		return status_code, *resp
+	}
+
+	if err := validateSample(req); err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_328", call_flow)
+		logger.Error("HandleJimoRequest", "error_msg", err.Error())
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			TableName: req.TableName,
+			ErrorMsg:  err.Error(),
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:       new_call_flow,
+		}
+		return ApiTypes.CustomHttpStatus_BadRequest, resp
+	}
+
+	if _, err := queryIsolation(req, ApiTypes.DBType); err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_1651", call_flow)
+		logger.Error("HandleJimoRequest", "error_msg", err.Error())
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			TableName: req.TableName,
+			ErrorMsg:  err.Error(),
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:       new_call_flow,
+		}
+		return ApiTypes.CustomHttpStatus_BadRequest, resp
+	}
+
+	if status_code, resp := checkExplain(rc, req, call_flow); resp != nil {
+		return status_code, *resp
+	}
+
+	if req.TimeBucket != nil {
+		return handleTimeBucketQuery(new_ctx, rc, req, call_flow)
+	}
+
+	from_clause := quoteQualified(req.TableName)
+	var sample_plan samplePlan
+	if req.Sample > 0 {
+		sample_plan = planSample(new_ctx, rc, ApiTypes.ProjectDBHandle, req)
+		from_clause = sample_plan.fromClause(from_clause)
+	}
+
+	query, args, selected_fields, aliases, field_def_map, err := buildQueryFrom(rc, new_ctx, req, from_clause)
 	table_name := req.TableName
 	if err != nil {
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_330", call_flow)
+		status_code := ApiTypes.CustomHttpStatus_InternalError
+		if errors.Is(err, errBadJoinPlan) || errors.Is(err, errAliasCollision) ||
+			errors.Is(err, errBadAlias) || errors.Is(err, errBadConditionValue) ||
+			errors.Is(err, errBadSubQuery) || errors.Is(err, errQueryTooLarge) {
+			status_code = ApiTypes.CustomHttpStatus_BadRequest
+		}
 		resp := ApiTypes.JimoResponse{
 			Status:    false,
 			ReqID:     reqID,
 			TableName: req.TableName,
 			ErrorMsg:  err.Error(),
-			ErrorCode: ApiTypes.CustomHttpStatus_InternalError,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest, // buildQueryFrom only fails on the request
+			ErrorCode: status_code,
 			Loc:       new_call_flow,
 		}
-		return ApiTypes.CustomHttpStatus_InternalError, resp
+		return status_code, resp
+	}
+
+	if err := checkHiddenFieldUse(rc.IsAuthenticated(), req, selected_fields, aliases); err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_1731", call_flow)
+		logger.Warn("HandleJimoRequest", "error", err, "table_name", table_name, "loc", new_call_flow)
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			TableName: req.TableName,
+			ErrorMsg:  err.Error(),
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:       new_call_flow,
+		}
+		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
 
 	db_type := ApiTypes.DBType
@@ -358,6 +442,7 @@ func HandleDBQuery(
 			Status:    false,
 			ReqID:     reqID,
 			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InternalError,
 			TableName: req.TableName,
 			ErrorCode: ApiTypes.CustomHttpStatus_InternalError,
 			Loc:       new_call_flow,
@@ -365,26 +450,45 @@ func HandleDBQuery(
 		return ApiTypes.CustomHttpStatus_InternalError, resp
 	}
 
-	var orderby_defs = req.OrderbyDef
-	if len(orderby_defs) > 0 {
-		var orderby_str = ""
-		for i, orderby_def := range orderby_defs {
-			var direction = "DESC"
-			if orderby_def.IsAsc {
-				direction = "ASC"
-			}
-			var bb = fmt.Sprintf("%s %s", orderby_def.FieldName, direction)
-			if i == 0 {
-				orderby_str = "ORDER BY " + bb
-			} else {
-				orderby_str += ", " + bb
+	// The total counts the rows matching the WHERE/JOIN, so it is built
+	// before ORDER BY and LIMIT are added
+	count_query := ""
+	if req.WithTotal {
+		count_query = countQuery(query)
+	}
+
+	if len(req.OrderbyDef) > 0 {
+		orderby_str, err := orderByClause(req.OrderbyDef, selected_fields, aliases, field_def_map)
+		if err == nil {
+			err = checkArrayEmbedOrderBy(req.JoinDefs, req.OrderbyDef, selected_fields, aliases, field_def_map)
+		}
+		if err != nil {
+			new_call_flow := fmt.Sprintf("%s->SHD_RHD_1596", call_flow)
+			logger.Warn("HandleJimoRequest", "error", err, "table_name", table_name, "loc", new_call_flow)
+			resp := ApiTypes.JimoResponse{
+				Status:    false,
+				ReqID:     reqID,
+				TableName: req.TableName,
+				ErrorMsg:  err.Error(),
+				ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+				ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+				Loc:       new_call_flow,
 			}
+			return ApiTypes.CustomHttpStatus_BadRequest, resp
 		}
-
 		query += " " + orderby_str
 	}
 
-	if req.PageSize <= 0 || req.Start < 0 {
+	// Trusted internal callers may fetch all the rows. From an HTTP
+	// request, PageSizeNoLimit is an invalid page size.
+	no_limit := req.PageSize == ApiTypes.PageSizeNoLimit && isInternalCaller(ctx)
+	if req.Sample > 0 {
+		query += sample_plan.orderLimit(req.Sample)
+	} else if no_limit && req.Start >= 0 {
+		if req.Start > 0 {
+			query += fmt.Sprintf(" OFFSET %d", req.Start)
+		}
+	} else if req.PageSize <= 0 || req.Start < 0 {
 		var error_msg = fmt.Sprintf("invalid limit clause (SHD_RHD_382), page_size:%d, start:%d",
 			req.PageSize, req.Start)
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_389", call_flow)
@@ -393,16 +497,35 @@ func HandleDBQuery(
 			ReqID:     reqID,
 			TableName: req.TableName,
 			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
 			ErrorCode: ApiTypes.CustomHttpStatus_InternalError,
 			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_InternalError, resp
+	} else {
+		// Without a total, one row more than the page tells whether
+		// there is a next page
+		limit := req.PageSize
+		if !req.WithTotal {
+			limit++
+		}
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, req.Start)
 	}
 
-	query += fmt.Sprintf(" LIMIT %d OFFSET %d", req.PageSize, req.Start)
+	if req.Explain {
+		return handleExplainQuery(new_ctx, rc, req, db, query, args, call_flow)
+	}
 
-	json_data, num_records, err := RunQuery(new_ctx, rc, req, db, query,
-		args, selected_fields, aliases, field_def_map)
+	var json_data []map[string]interface{}
+	var num_records int
+	var total_records int64
+	if req.WithTotal {
+		json_data, num_records, total_records, err = RunQueryWithTotal(new_ctx, rc, req, db, query,
+			count_query, args, selected_fields, aliases, field_def_map)
+	} else {
+		json_data, num_records, err = RunQuery(new_ctx, rc, req, db, query,
+			args, selected_fields, aliases, field_def_map)
+	}
 	if err != nil {
 		log_id := sysdatastores.NextActivityLogID()
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_410", call_flow)
@@ -426,10 +549,24 @@ func HandleDBQuery(
 			ReqID:     reqID,
 			TableName: req.TableName,
 			ErrorMsg:  error_msg,
+			ErrorKind: dbErrorKind(err),
 			ErrorCode: ApiTypes.CustomHttpStatus_InternalError,
 			Loc:       "SHD_RHD_313",
 		}
-		return ApiTypes.CustomHttpStatus_InternalError, resp
+		return dbErrorStatus(err, ApiTypes.CustomHttpStatus_InternalError), resp
+	}
+
+	// Drop the extra row fetched to tell whether there is a next page
+	has_more := false
+	if req.Sample <= 0 && !no_limit && !req.WithTotal && len(json_data) > req.PageSize {
+		json_data = json_data[:req.PageSize]
+		num_records = len(json_data)
+		has_more = true
+	}
+
+	// Hide the fields the user may not see
+	if len(getFieldPolicies()) > 0 {
+		applyFieldPolicies(rc.IsAuthenticated(), json_data, selected_fields, aliases)
 	}
 
 	new_call_flow := fmt.Sprintf("%s->SHD_RHD_437", call_flow)
@@ -443,6 +580,15 @@ func HandleDBQuery(
 		Results:    json_data,
 		Loc:        new_call_flow,
 	}
+	if req.Sample > 0 {
+		resp.Meta = sample_plan.meta(req.Sample)
+	}
+	if req.WithTotal {
+		resp.TotalRecords = &total_records
+	}
+	if req.Sample <= 0 && !no_limit {
+		setPagination(&resp, req, has_more)
+	}
 
 	msg := fmt.Sprintf("query success, query:%s, num_records:%d, table:%s, loc:%s",
 		query, num_records, req.TableName, req.Loc)
@@ -458,6 +604,225 @@ func HandleDBQuery(
 	return http.StatusOK, resp
 }
 
+// internalCallerKey marks the context of a trusted server-side caller
+type internalCallerKey struct{}
+
+// WithInternalCaller returns a copy of 'ctx' marking its requests as made
+// by trusted server-side code, e.g. a data-processing job calling
+// HandleDBQuery, which may then query all the rows with
+// ApiTypes.PageSizeNoLimit. The HTTP handlers never set it, and an HTTP
+// request can't: its context values are set by the server only.
+func WithInternalCaller(ctx context.Context) context.Context {
+	return context.WithValue(ctx, internalCallerKey{}, true)
+}
+
+// isInternalCaller tells whether 'ctx' was marked by WithInternalCaller
+func isInternalCaller(ctx context.Context) bool {
+	internal, _ := ctx.Value(internalCallerKey{}).(bool)
+	return internal
+}
+
+// setPagination sets the page, page size, total pages and has-more
+// fields of the response to the offset-paginated query 'req'. Pages are
+// numbered from 1; a start that is not a multiple of the page size is in
+// the page it falls in. With a total, there is more if the rows before
+// and on this page are fewer than the total; without one, 'has_more'
+// tells it.
+func setPagination(resp *ApiTypes.JimoResponse, req ApiTypes.QueryRequest, has_more bool) {
+	resp.Page = req.Start/req.PageSize + 1
+	resp.PageSize = req.PageSize
+	if resp.TotalRecords != nil {
+		total := *resp.TotalRecords
+		total_pages := (total + int64(req.PageSize) - 1) / int64(req.PageSize)
+		resp.TotalPages = &total_pages
+		has_more = int64(req.Start+resp.NumRecords) < total
+	}
+	resp.HasMore = &has_more
+}
+
+// prepareQueryRequest resolves the resource 'req' names, if any, into
+// its table and fields, and checks its time zone and timestamp formats.
+// It returns a response if the request is refused.
+func prepareQueryRequest(
+	ctx context.Context,
+	rc ApiTypes.RequestContext,
+	req *ApiTypes.QueryRequest,
+	call_flow string) (int, *ApiTypes.JimoResponse) {
+	logger := rc.GetLogger()
+	reqID := rc.ReqID()
+
+	// If the request names a resource, the server-side definition wins
+	// over whatever the client sent inline.
+	if req.ResourceName != "" {
+		resource_def, status_code, resp := resolveResourceDef(ctx, rc, req.ResourceName, ApiTypes.ReqAction_Query, req.Loc)
+		if resp != nil {
+			return status_code, resp
+		}
+
+		req.DBName = resource_def.ResourceDef.DBName
+		req.TableName = resource_def.ResourceDef.TableName
+		if len(resource_def.FieldDefs) > 0 {
+			req.FieldDefs = resource_def.FieldDefs
+		}
+		if len(resource_def.SelectedFields) > 0 {
+			field_names := make([]string, len(resource_def.SelectedFields))
+			for i, field_def := range resource_def.SelectedFields {
+				field_names[i] = req.TableName + "." + field_def.FieldName
+			}
+			req.FieldNames = field_names
+		}
+	}
+
+	if req.TimeZone != "" {
+		if _, err := ApiUtils.LoadTimeZone(req.TimeZone); err != nil {
+			new_call_flow := fmt.Sprintf("%s->SHD_RHD_327", call_flow)
+			logger.Error("HandleJimoRequest", "error_msg", err.Error())
+			resp := ApiTypes.JimoResponse{
+				Status:    false,
+				ReqID:     reqID,
+				TableName: req.TableName,
+				ErrorMsg:  err.Error(),
+				ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+				ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+				Loc:       new_call_flow,
+			}
+			return ApiTypes.CustomHttpStatus_BadRequest, &resp
+		}
+	}
+
+	if err := checkTimestampFormats(*req); err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_356", call_flow)
+		logger.Error("HandleJimoRequest", "error_msg", err.Error())
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			TableName: req.TableName,
+			ErrorMsg:  err.Error(),
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:       new_call_flow,
+		}
+		return ApiTypes.CustomHttpStatus_BadRequest, &resp
+	}
+
+	return 0, nil
+}
+
+// errBadOrderBy is returned when an order-by field is not a field of the
+// query the user may use. The query is answered with BadRequest.
+var errBadOrderBy = errors.New("invalid order-by field")
+
+// orderByClause returns the ORDER BY clause of 'orderby_defs'. The field
+// names are interpolated, so each must be a field name, qualified or not;
+// reserved words are quoted.
+//
+// 'fields' and 'aliases' are the selected fields of the query and their
+// aliases, as returned by buildQuery, and 'field_def_map' its field defs
+// by table. A name that is the alias of a selected field sorts on that
+// field: aliases are not part of the SQL (RunQuery names the columns), so
+// e.g. "customer_name" for customers.name:customer_name is sorted as
+// customers.name. An alias other than the field's own name is ambiguous,
+// and rejected, if it is also the name of a column of the query's tables;
+// the qualified name must be used then. Other names, e.g. a qualified
+// joined field, are used as they are, once checked to be fields of the
+// query's tables. checkHiddenFieldUse rejects the fields hidden from the
+// user.
+func orderByClause(
+	orderby_defs []ApiTypes.OrderbyDef,
+	fields []string,
+	aliases []string,
+	field_def_map map[string][]ApiTypes.FieldDef) (string, error) {
+	var orderby_str = ""
+	for i, orderby_def := range orderby_defs {
+		if !isQualifiedIdentifier(orderby_def.FieldName) {
+			return "", fmt.Errorf("%w %q (SHD_RHD_1595)", errBadOrderBy, orderby_def.FieldName)
+		}
+		field, err := resolveOrderByField(orderby_def.FieldName, fields, aliases, field_def_map)
+		if err != nil {
+			return "", err
+		}
+		var direction = "DESC"
+		if orderby_def.IsAsc {
+			direction = "ASC"
+		}
+		var bb = fmt.Sprintf("%s %s", quoteQualified(field), direction)
+		if i == 0 {
+			orderby_str = "ORDER BY " + bb
+		} else {
+			orderby_str += ", " + bb
+		}
+	}
+	return orderby_str, nil
+}
+
+// resolveOrderByField returns the field an order-by 'name' sorts on (see
+// orderByClause).
+func resolveOrderByField(
+	name string,
+	fields []string,
+	aliases []string,
+	field_def_map map[string][]ApiTypes.FieldDef) (string, error) {
+	if strings.Contains(name, ".") {
+		return name, checkOrderByField(name, field_def_map)
+	}
+	idx := slices.Index(aliases, name)
+	if idx < 0 {
+		return name, checkOrderByField(name, field_def_map)
+	}
+
+	// checkAliasCollisions made the alias unique among the selected fields.
+	// A default alias is the column name, which SQL resolves itself.
+	field := fields[idx]
+	if field == name || strings.HasSuffix(field, "."+name) {
+		return name, nil
+	}
+	for table_name, field_defs := range field_def_map {
+		for _, fd := range field_defs {
+			if fd.FieldName == name {
+				return "", fmt.Errorf("%w %q: ambiguous, it is the alias of %s and a column of %s, "+
+					"use the qualified name (SHD_RHD_1644)", errBadOrderBy, name, field, table_name)
+			}
+		}
+	}
+	return field, nil
+}
+
+// checkOrderByField checks that the order-by field 'name', which is not
+// an alias, is a field of the query's tables in 'field_def_map': a
+// qualified name a field of its table, an unqualified one a field of
+// exactly one table. Without field defs there is nothing to check.
+func checkOrderByField(name string, field_def_map map[string][]ApiTypes.FieldDef) error {
+	if len(field_def_map) == 0 {
+		return nil
+	}
+	hasField := func(field_defs []ApiTypes.FieldDef, field_name string) bool {
+		return slices.ContainsFunc(field_defs, func(fd ApiTypes.FieldDef) bool { return fd.FieldName == field_name })
+	}
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		field_defs, ok := field_def_map[name[:dot]]
+		if !ok || !hasField(field_defs, name[dot+1:]) {
+			return fmt.Errorf("%w %q: not a field of the query's tables (SHD_RHD_1734)", errBadOrderBy, name)
+		}
+		return nil
+	}
+
+	var tables []string
+	for table_name, field_defs := range field_def_map {
+		if hasField(field_defs, name) {
+			tables = append(tables, table_name)
+		}
+	}
+	switch len(tables) {
+	case 0:
+		return fmt.Errorf("%w %q: not a field of the query's tables (SHD_RHD_1735)", errBadOrderBy, name)
+	case 1:
+		return nil
+	}
+	slices.Sort(tables)
+	return fmt.Errorf("%w %q: ambiguous, it is a column of %s, use the qualified name (SHD_RHD_1736)",
+		errBadOrderBy, name, strings.Join(tables, " and "))
+}
+
 // buildJoinClauses handles the join clause. A query with joins are
 //
 //		SELECT <selected_field_list>
@@ -483,6 +848,8 @@ func HandleDBQuery(
 //
 //	<tablename>.<fieldname>[:<alias>]
 //
+// or ["*"] for all the fields in JoinedFieldDefs (see expandSelectedFields).
+//
 // If EmbedName is not empty, all the selected field names
 // are prepended with "<EmbedName>____" (four '_'!!!) For instance, if
 // EmbedName = "question" and SelectedFields is ["field1", "field2"],
@@ -495,9 +862,9 @@ func HandleDBQuery(
 //   - aliases
 func buildJoinClauses(
 	join_def []ApiTypes.JoinDef,
-	field_def_map map[string][]ApiTypes.FieldDef) ([]string, []string, []string, []string) {
+	field_def_map map[string][]ApiTypes.FieldDef) ([]string, []string, []string, []string, error) {
 	if len(join_def) == 0 {
-		return []string{}, []string{}, []string{}, []string{}
+		return []string{}, []string{}, []string{}, []string{}, nil
 	}
 
 	var joinClauses []string
@@ -531,12 +898,24 @@ func buildJoinClauses(
 				joinOpr = "="
 			}
 
+			// The operator and field names are interpolated into the
+			// ON clause, so they must be whitelisted like WHERE fields
+			if !allowedJoinOprs[joinOpr] {
+				return nil, nil, nil, nil, fmt.Errorf("invalid join operator:%q (SHD_RHD_560)", joinOpr)
+			}
+			if !isValidSQLIdentifier(on.SourceFieldName) {
+				return nil, nil, nil, nil, fmt.Errorf("invalid join source field:%q (SHD_RHD_561)", on.SourceFieldName)
+			}
+			if !isValidSQLIdentifier(on.JoinedFieldName) {
+				return nil, nil, nil, nil, fmt.Errorf("invalid join field:%q (SHD_RHD_562)", on.JoinedFieldName)
+			}
+
 			// IMPORTANT: field names in Join On-Clause are not
 			// qualified names!
 			onCondition := fmt.Sprintf("%s.%s %s %s.%s",
-				jd.FromTableName, on.SourceFieldName,
+				quoteQualified(jd.FromTableName), quoteIdent(on.SourceFieldName),
 				joinOpr,
-				jd.JoinedTableName, on.JoinedFieldName)
+				quoteQualified(jd.JoinedTableName), quoteIdent(on.JoinedFieldName))
 			onConditions = append(onConditions, onCondition)
 		}
 
@@ -544,7 +923,7 @@ func buildJoinClauses(
 
 		// Build JOIN clause (without join type - that's stored separately)
 		joinClause := fmt.Sprintf("%s ON %s",
-			jd.JoinedTableName,
+			quoteQualified(jd.JoinedTableName),
 			onClauseStr)
 		joinClauses = append(joinClauses, joinClause)
 		joinTypes = append(joinTypes, jd.JoinType)
@@ -555,7 +934,12 @@ func buildJoinClauses(
 		// If 'jd.EmbedName' is defined, the selected field name is
 		// prepended with "jd.EmbedName" + "____". During scanning,
 		// it should put these fields into a sub-doc named jd.EmbedName.
-		new_selected, new_aliases := getAliases(jd.SelectedFields)
+		joined_selected, err := expandSelectedFields(jd.SelectedFields,
+			jd.JoinedTableName, field_def_map[jd.JoinedTableName])
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		new_selected, new_aliases := getAliases(joined_selected)
 		if jd.EmbedName != "" {
 			for i, field := range new_selected {
 				new_aliase := fmt.Sprintf("%s____%s", jd.EmbedName, new_aliases[i])
@@ -569,7 +953,261 @@ func buildJoinClauses(
 	}
 
 	// Return the JOIN clauses and the additional selected fields
-	return joinClauses, joinTypes, selectFields, aliases
+	return joinClauses, joinTypes, selectFields, aliases, nil
+}
+
+// defaultMaxJoins is the join limit when LibConfig.MaxJoins is not set
+const defaultMaxJoins = 4
+
+// errBadJoinPlan is returned when the joins of a query are rejected by
+// validateJoins. The query is answered with BadRequest.
+var errBadJoinPlan = errors.New("join plan rejected")
+
+// validateJoins checks the joins of a query on 'table_name' before any SQL
+// is built, so requests cannot fan a query out into large cross products.
+// There may be at most LibConfig.MaxJoins joins (joins without an ON clause
+// are skipped by buildJoinClauses and not counted). Joined tables are not
+// aliased, so a table may be joined only once and not to itself. Joins
+// embedded as arrays are checked by checkArrayEmbeds.
+func validateJoins(table_name string, join_defs []ApiTypes.JoinDef) error {
+	max_joins := ApiTypes.LibConfig.MaxJoins
+	if max_joins <= 0 {
+		max_joins = defaultMaxJoins
+	}
+
+	num_joins := 0
+	joined := map[string]bool{table_name: true}
+	for i, jd := range join_defs {
+		if len(jd.OnClause) == 0 {
+			continue
+		}
+		num_joins++
+		if num_joins > max_joins {
+			return fmt.Errorf("%w: more than %d joins (SHD_RHD_571)", errBadJoinPlan, max_joins)
+		}
+		if joined[jd.JoinedTableName] {
+			return fmt.Errorf("%w: table %s is joined more than once, join:%d (SHD_RHD_574)",
+				errBadJoinPlan, jd.JoinedTableName, i)
+		}
+		joined[jd.JoinedTableName] = true
+	}
+	return checkArrayEmbeds(join_defs)
+}
+
+// conditionFieldMap maps the field names a query condition may use to
+// their field defs: the local names of the fields of 'table_name', and,
+// when the query has joins, the qualified names (tablename.fieldname) of
+// the fields of 'table_name' and of every joined table with
+// JoinedFieldDefs. A condition can then filter on a joined column, alone
+// or OR'ed with base columns.
+// Joins without an ON clause are skipped by buildJoinClauses, so their
+// fields are not added, nor are names that are not SQL identifiers.
+func conditionFieldMap(
+	table_name string,
+	field_defs []ApiTypes.FieldDef,
+	join_defs []ApiTypes.JoinDef) map[string]ApiTypes.FieldDef {
+	field_map := make(map[string]ApiTypes.FieldDef)
+	for _, fd := range field_defs {
+		field_map[fd.FieldName] = fd
+	}
+	if len(join_defs) == 0 {
+		return field_map
+	}
+
+	for _, fd := range field_defs {
+		field_map[table_name+"."+fd.FieldName] = fd
+	}
+	for _, jd := range join_defs {
+		if len(jd.OnClause) == 0 || !isValidSQLIdentifier(jd.JoinedTableName) {
+			continue
+		}
+		for _, fd := range jd.JoinedFieldDefs {
+			// Joined field defs come with the request; their names end
+			// up in the WHERE clause as is
+			if isValidSQLIdentifier(fd.FieldName) {
+				field_map[jd.JoinedTableName+"."+fd.FieldName] = fd
+			}
+		}
+	}
+	return field_map
+}
+
+// errBadAlias is returned when a result field alias is not a plain
+// identifier. The query is answered with BadRequest.
+var errBadAlias = errors.New("invalid result field alias")
+
+// checkAliases checks that every alias, given after ':' in a selected
+// field, defaulted from the field name or prefixed with a join's embed
+// name, is a plain SQL identifier. Aliases come from the request and may
+// end up in an "AS <alias>" clause, so anything with spaces, quotes or
+// parentheses is rejected rather than quoted.
+func checkAliases(fields []string, aliases []string) error {
+	for i, alias := range aliases {
+		if !isValidSQLIdentifier(alias) {
+			return fmt.Errorf("%w %q of %s: use letters, digits and '_', e.g. %s:<alias> (SHD_RHD_1593)",
+				errBadAlias, alias, fields[i], fields[i])
+		}
+	}
+	return nil
+}
+
+// errAliasCollision is returned when two selected fields would be stored
+// under the same key of a result row. The query is answered with
+// BadRequest.
+var errAliasCollision = errors.New("duplicate result field")
+
+// checkAliasCollisions checks that every selected field has its own key in
+// the result rows built by RunQuery. An alias defaults to the field name
+// without its table, so e.g. users.name and customers.name collide and one
+// would silently overwrite the other. An alias <embed>____<name> goes to
+// the sub-document <embed>, which must not collide with a top-level alias
+// either.
+func checkAliasCollisions(fields []string, aliases []string) error {
+	owners := make(map[string]string, len(aliases))
+	embeds := make(map[string]string)
+	for i, alias := range aliases {
+		if other, ok := owners[alias]; ok {
+			return fmt.Errorf("%w %q: selected by %s and %s, give one of them an alias, "+
+				"e.g. %s:<alias> (SHD_RHD_1591)", errAliasCollision, alias, other, fields[i], fields[i])
+		}
+		owners[alias] = fields[i]
+
+		if parts := strings.Split(alias, "____"); len(parts) == 2 {
+			embeds[parts[0]] = fields[i]
+		}
+	}
+
+	for i, alias := range aliases {
+		if embedded, ok := embeds[alias]; ok {
+			return fmt.Errorf("%w %q: %s and the embedded %s, rename the embed or alias %s "+
+				"(SHD_RHD_1592)", errAliasCollision, alias, fields[i], embedded, fields[i])
+		}
+	}
+	return nil
+}
+
+// joinPlan describes 'join_defs' for logging, e.g.
+// ["users LEFT JOIN orders", "orders JOIN items"].
+func joinPlan(join_defs []ApiTypes.JoinDef) []string {
+	plan := make([]string, len(join_defs))
+	for i, jd := range join_defs {
+		plan[i] = fmt.Sprintf("%s %s %s", jd.FromTableName, jd.JoinType, jd.JoinedTableName)
+	}
+	return plan
+}
+
+// selectAllFields is the selected field name that stands for all the fields
+// in the table's FieldDefs
+const selectAllFields = "*"
+
+// expandSelectedFields expands a selected field list of just "*" into the
+// qualified names of all the fields in 'field_defs'. The columns are listed
+// rather than selected with "SELECT *" so that results are converted and
+// named by their field defs. "*" mixed with other fields is ambiguous and
+// rejected. Lists without "*" are returned as they are.
+func expandSelectedFields(
+	selected_fields []string,
+	table_name string,
+	field_defs []ApiTypes.FieldDef) ([]string, error) {
+	if !slices.Contains(selected_fields, selectAllFields) {
+		return selected_fields, nil
+	}
+	if len(selected_fields) > 1 {
+		return nil, fmt.Errorf("\"*\" cannot be combined with other selected fields, table:%s (SHD_RHD_564)", table_name)
+	}
+	if len(field_defs) == 0 {
+		return nil, fmt.Errorf("\"*\" needs field_defs, table:%s (SHD_RHD_565)", table_name)
+	}
+
+	fields := make([]string, len(field_defs))
+	for i, fd := range field_defs {
+		// The names are interpolated into the select list
+		if !isValidSQLIdentifier(fd.FieldName) {
+			return nil, fmt.Errorf("invalid field name:%q, table:%s (SHD_RHD_566)", fd.FieldName, table_name)
+		}
+		fields[i] = table_name + "." + fd.FieldName
+	}
+	return fields, nil
+}
+
+// allowedJoinOprs are the comparison operators allowed in join ON clauses
+var allowedJoinOprs = map[string]bool{
+	"=":  true,
+	"<>": true,
+	"<":  true,
+	"<=": true,
+	">":  true,
+	">=": true,
+}
+
+// resolveResourceDef looks up the resource definition for a Jimo request.
+// On failure it returns the status code and the response to send.
+func resolveResourceDef(
+	ctx context.Context,
+	rc ApiTypes.RequestContext,
+	resource_name string,
+	resource_opr string,
+	loc string) (ApiTypes.ResourceStoreDef, int, *ApiTypes.JimoResponse) {
+	logger := rc.GetLogger()
+	call_flow := ctx.Value(ApiTypes.CallFlowKey).(string)
+	resource_def, err := stores.GetResourceDef(resource_name, resource_opr)
+	if err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_581", call_flow)
+		error_msg := fmt.Sprintf("resource not found, error:%v, resource_name:%s, loc:%s",
+			err, resource_name, loc)
+		logger.Warn("resource not found", "r_name", resource_name, "opr", resource_opr, "loc", loc)
+		return resource_def, ApiTypes.CustomHttpStatus_ResourceNotFound, &ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     rc.ReqID(),
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_NotFound,
+			ErrorCode: ApiTypes.CustomHttpStatus_ResourceNotFound,
+			Loc:       new_call_flow,
+		}
+	}
+
+	if resource_def.ResourceDef.ResourceType != ApiTypes.ResourceType_Table {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_596", call_flow)
+		error_msg := fmt.Sprintf("incorrect resource type, expecting:%s, actual:%s, resource_name:%s",
+			ApiTypes.ResourceType_Table, resource_def.ResourceDef.ResourceType, resource_name)
+		logger.Error("incorrect resource type", "r_name", resource_name, "type", resource_def.ResourceDef.ResourceType)
+		return resource_def, ApiTypes.CustomHttpStatus_BadRequest, &ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     rc.ReqID(),
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:       new_call_flow,
+		}
+	}
+
+	if resource_def.ResourceDef.TableName == "" || resource_def.ResourceDef.ResourceJSON == nil {
+		log_id := sysdatastores.NextActivityLogID()
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_611", call_flow)
+		error_msg := fmt.Sprintf("incomplete resource def, resource_name:%s, log_id:%d", resource_name, log_id)
+		logger.Error("incomplete resource def", "r_name", resource_name, "log_id", log_id)
+		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+			LogID:        log_id,
+			ActivityName: ApiTypes.ActivityName_JimoRequest,
+			ActivityType: ApiTypes.ActivityType_InternalError,
+			AppName:      ApiTypes.AppName_RequestHandler,
+			ModuleName:   ApiTypes.ModuleName_RequestHandler,
+			ActivityMsg:  &error_msg,
+			CallerLoc:    new_call_flow})
+
+		return resource_def, ApiTypes.CustomHttpStatus_InternalError, &ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     rc.ReqID(),
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InternalError,
+			ErrorCode: ApiTypes.CustomHttpStatus_InternalError,
+			Loc:       new_call_flow,
+		}
+	}
+
+	logger.Info("resolved resource", "r_name", resource_name, "opr", resource_opr,
+		"table_name", resource_def.ResourceDef.TableName)
+	return resource_def, http.StatusOK, nil
 }
 
 // HandleDBInsert retrieves the request from the context.
@@ -591,7 +1229,7 @@ func HandleDBInsert(
 	// This function handles the 'insert' request.
 	// The data to be inserted is in req.records
 	var req ApiTypes.InsertRequest
-	if err := json.Unmarshal(body, &req); err != nil {
+	if err := decodeJimoRequest(body, &req); err != nil {
 		log_id := sysdatastores.NextActivityLogID()
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_597", call_flow)
 		error_msg := fmt.Sprintf("failed parse request_type:%v, log_id:%d", err, log_id)
@@ -606,60 +1244,40 @@ func HandleDBInsert(
 
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
 
+	// If the request names a resource, the server-side definition wins
+	// over whatever the client sent inline, including the on-conflict columns.
+	if req.ResourceName != "" {
+		resource_def, status_code, resp := resolveResourceDef(new_ctx, rc, req.ResourceName, ApiTypes.ReqAction_Insert, req.Loc)
+		if resp != nil {
+			return status_code, *resp
+		}
+
+		req.DBName = resource_def.ResourceDef.DBName
+		req.TableName = resource_def.ResourceDef.TableName
+		if len(resource_def.FieldDefs) > 0 {
+			req.FieldDefs = resource_def.FieldDefs
+		}
+		if len(resource_def.OnConflictCols) > 0 {
+			req.OnConflictCols = resource_def.OnConflictCols
+			req.OnConflictUpdateCols = resource_def.OnConflictUpdateCols
+		}
+	}
+
 	db_name := req.DBName
 	table_name := req.TableName
 	field_defs := req.FieldDefs
 	logger.Info("handleDBInsert", "dbname", db_name, "tablename", table_name)
 	logger.Info("FieldDefs", "len", len(field_defs))
-	/*
-		if field_defs == nil {
-			resource_def, err 	:= stores.GetResouroceDef(resource_name, resource_opr)
-			if err != nil {
-				error_msg := fmt.Sprintf("%v", err)
-				logger.Info("+++++ WARNING:%s (SHD_RHD_329)", error_msg)
-
-				resp := ApiTypes.JimoResponse {
-					Status: false,
-					ReqID:	  reqID,
-					ErrorMsg: error_msg,
-					Loc: "SHD_RHD_334",
-				}
-				c.JSON(ApiTypes.CustomHttpStatus_ResourceNotFound, resp)
-				return fmt.Errorf("%s", error_msg)
-			}
-
-			if ff, exist := resource_def.
-			field_defs = resource_def.ResourceDef.ResourceJSON
-		}
-
-		// The resource type must be 'table'
-		if resource_def.ResourceDef.ResourceType != ApiTypes.ResourceType_Table {
-			error_msg := fmt.Sprintf("incorrect resource type, expecting:%s, actual:%s",
-					ApiTypes.ResourceType_Table, resource_def.ResourceDef.ResourceType)
-			logger.Info("***** Alarm:%s (SHD_RHD_343)", error_msg)
-
-			resp := ApiTypes.JimoResponse {
-				Status: false,
-				ReqID:	  reqID,
-				ErrorMsg: error_msg,
-				Loc: "SHD_RHD_348",
-			}
-			c.JSON(ApiTypes.CustomHttpStatus_BadRequest, resp)
-			return fmt.Errorf("%s", error_msg)
-		}
-
-		db_name := resource_def.ResourceDef.DBName
-		table_name := resource_def.ResourceDef.TableName
-	*/
 
 	if table_name == "" {
 		error_msg := "failed get table name."
@@ -667,10 +1285,11 @@ func HandleDBInsert(
 
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_669", call_flow)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
@@ -682,10 +1301,60 @@ func HandleDBInsert(
 
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_684", call_flow)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
+		}
+		return ApiTypes.CustomHttpStatus_BadRequest, resp
+	}
+
+	time_zone, err := ApiUtils.LoadTimeZone(req.TimeZone)
+	if err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_711", call_flow)
+		logger.Error("HandleJimoRequest", "error_msg", err.Error())
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  err.Error(),
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			TableName: table_name,
+			ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:       new_call_flow,
+		}
+		return ApiTypes.CustomHttpStatus_BadRequest, resp
+	}
+
+	if problems := ValidateRecords(field_defs, records, req.StrictFields, time_zone); len(problems) > 0 {
+		error_msg := fieldProblemsMsg(problems)
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_696", call_flow)
+		logger.Warn("invalid records", "table_name", table_name, "num_problems", len(problems))
+		resp := ApiTypes.JimoResponse{
+			Status:     false,
+			ReqID:      reqID,
+			ErrorMsg:   error_msg,
+			ErrorKind:  ApiTypes.ErrorKind_ValidationFailed,
+			ResultType: "json",
+			NumRecords: len(problems),
+			Results:    problems,
+			ErrorCode:  ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:        new_call_flow,
+		}
+		return ApiTypes.CustomHttpStatus_BadRequest, resp
+	}
+
+	if _, err := txIsolation(req.IsolationLevel, ApiTypes.DBType, sql.LevelDefault); err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_1652", call_flow)
+		logger.Error("HandleJimoRequest", "error_msg", err.Error())
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  err.Error(),
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			TableName: table_name,
+			ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
@@ -697,26 +1366,48 @@ func HandleDBInsert(
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_669", call_flow)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InternalError,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
 
-	err := InsertBatch(new_ctx, user_name, db, table_name, req, field_defs, records, 30, db_type)
+	// With an idempotency key, a retry of a request gets the outcome of
+	// the first one. See idempotency.go.
+	idem_key := idempotencyKey(rc, &req)
+	if idem_key != "" {
+		status_code, resp, done := reserveIdempotentInsert(rc, req, idem_key, user_name, call_flow)
+		if done {
+			return status_code, resp
+		}
+	}
+
+	err = databaseutil.WithWriteRetry(new_ctx, db, func(ctx context.Context) error {
+		return InsertBatch(ctx, user_name, db, table_name, req, field_defs, records, 0, db_type)
+	})
 	if err != nil {
 		error_msg := fmt.Sprintf("failed insert to db:%v", err)
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_721", call_flow)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: dbErrorKind(err),
+			Loc:       new_call_flow,
 		}
-		return ApiTypes.CustomHttpStatus_BadRequest, resp
+		status_code := dbErrorStatus(err, ApiTypes.CustomHttpStatus_BadRequest)
+		if v := classifyConstraintError(err); v != nil {
+			status_code, resp = constraintViolationResponse(v, reqID, table_name, field_defs, records,
+				fmt.Sprintf("%s->SHD_RHD_1645", call_flow))
+		}
+		if idem_key != "" {
+			finishIdempotentInsert(rc, idem_key, user_name, status_code, resp)
+		}
+		return status_code, resp
 	}
 
 	new_call_flow := fmt.Sprintf("%s->SHD_RHD_732", call_flow)
@@ -727,6 +1418,9 @@ func HandleDBInsert(
 		ResultType: "none",
 		Loc:        new_call_flow,
 	}
+	if idem_key != "" {
+		finishIdempotentInsert(rc, idem_key, user_name, http.StatusOK, resp)
+	}
 	return http.StatusOK, resp
 }
 
@@ -749,7 +1443,7 @@ func HandleDBUpdate(
 	new_ctx := context.WithValue(ctx, ApiTypes.CallFlowKey, fmt.Sprintf("%s->SHD_RHD_233", call_flow))
 
 	var req ApiTypes.UpdateRequest
-	if err := json.Unmarshal(body, &req); err != nil {
+	if err := decodeJimoRequest(body, &req); err != nil {
 		log_id := sysdatastores.NextActivityLogID()
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_763", call_flow)
 		error_msg := fmt.Sprintf("failed parse request_type:%v, log_id:%d", err, log_id)
@@ -764,10 +1458,11 @@ func HandleDBUpdate(
 
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
@@ -783,10 +1478,11 @@ func HandleDBUpdate(
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_799", call_flow)
 		logger.Error("db_type not supported", "db_type", db_type)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: "db_type not supported (SHD_0208051700)",
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  "db_type not supported (SHD_0208051700)",
+			ErrorKind: ApiTypes.ErrorKind_InternalError,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
@@ -800,10 +1496,11 @@ func HandleDBUpdate(
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
@@ -815,30 +1512,70 @@ func HandleDBUpdate(
 		error_msg := "no records provided for update"
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      "SHD_RHD_772",
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       "SHD_RHD_772",
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
 
-	field_map := make(map[string]bool)
+	time_zone, err := ApiUtils.LoadTimeZone(req.TimeZone)
+	if err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_849", call_flow)
+		logger.Error("HandleJimoRequest", "error_msg", err.Error())
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  err.Error(),
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			TableName: table_name,
+			ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:       new_call_flow,
+		}
+		return ApiTypes.CustomHttpStatus_BadRequest, resp
+	}
+
+	if problems := ValidateUpdateRecord(field_defs, update_record, req.StrictFields, time_zone); len(problems) > 0 {
+		error_msg := fieldProblemsMsg(problems)
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_842", call_flow)
+		logger.Warn("invalid update record", "table_name", table_name, "num_problems", len(problems))
+		resp := ApiTypes.JimoResponse{
+			Status:     false,
+			ReqID:      reqID,
+			ErrorMsg:   error_msg,
+			ErrorKind:  ApiTypes.ErrorKind_ValidationFailed,
+			ResultType: "json",
+			NumRecords: len(problems),
+			Results:    problems,
+			ErrorCode:  ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:        new_call_flow,
+		}
+		return ApiTypes.CustomHttpStatus_BadRequest, resp
+	}
+
+	timestamp_types := make(map[string]string)
 	for _, fd := range field_defs {
-		field_map[fd.FieldName] = true
+		switch data_type := ApiTypes.FieldDataType(fd); data_type {
+		case "timestamp", "timestamptz", "datetime":
+			timestamp_types[fd.FieldName] = data_type
+		}
 	}
 
 	cond_def := req.Condition
-	expr, err := buildConditionExpr(new_ctx, table_name, cond_def, field_map)
+	field_map := conditionFieldMap(table_name, field_defs, nil)
+	expr, err := buildConditionExpr(new_ctx, table_name, cond_def, field_map, time_zone)
 	if err != nil {
 		error_msg := fmt.Sprintf("failed building conditions, err:%v", err)
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_854", call_flow)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
@@ -848,16 +1585,17 @@ func HandleDBUpdate(
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_867", call_flow)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
 
 	// Build the UPDATE query using Squirrel
-	query := sq.Update(table_name).PlaceholderFormat(sq.Dollar)
+	query := sq.Update(quoteQualified(table_name)).PlaceholderFormat(sq.Dollar)
 
 	// Add SET clauses for each field in the update data
 	for field, value := range update_record {
@@ -867,15 +1605,37 @@ func HandleDBUpdate(
 			new_call_flow := fmt.Sprintf("%s->SHD_RHD_886", call_flow)
 			logger.Error("invalid field name", "field", field)
 			resp := ApiTypes.JimoResponse{
-				Status:   false,
-				ReqID:    reqID,
-				ErrorMsg: error_msg,
-				Loc:      new_call_flow,
+				Status:    false,
+				ReqID:     reqID,
+				ErrorMsg:  error_msg,
+				ErrorKind: ApiTypes.ErrorKind_ValidationFailed,
+				Loc:       new_call_flow,
 			}
 			return ApiTypes.CustomHttpStatus_BadRequest, resp
 		}
 
-		query = query.Set(field, value)
+		// Timestamp strings are parsed here so the request's zone applies
+		if data_type, ok := timestamp_types[field]; ok {
+			if str, is_str := value.(string); is_str {
+				converted, err := convertFieldValue(data_type, str, time_zone)
+				if err != nil {
+					error_msg := fmt.Sprintf("invalid value for field %s: %v (SHD_RHD_1007)", field, err)
+					new_call_flow := fmt.Sprintf("%s->SHD_RHD_1008", call_flow)
+					logger.Error("HandleJimoRequest", "error_msg", error_msg)
+					resp := ApiTypes.JimoResponse{
+						Status:    false,
+						ReqID:     reqID,
+						ErrorMsg:  error_msg,
+						ErrorKind: ApiTypes.ErrorKind_ValidationFailed,
+						Loc:       new_call_flow,
+					}
+					return ApiTypes.CustomHttpStatus_BadRequest, resp
+				}
+				value = converted
+			}
+		}
+
+		query = query.Set(quoteIdent(field), value)
 	}
 
 	// Add WHERE clause
@@ -887,10 +1647,11 @@ func HandleDBUpdate(
 		error_msg := fmt.Sprintf("failed to build SQL query: %v", err)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      "SHD_RHD_793",
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       "SHD_RHD_793",
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
@@ -898,18 +1659,23 @@ func HandleDBUpdate(
 	// Execute the update query
 	// Assuming you have a database connection variable called 'db'
 	// Replace 'db' with your actual database connection variable
-	result, err := db.Exec(sql, args...)
+	result, err := databaseutil.ExecWithRetry(new_ctx, db, sql, args...)
 	if err != nil {
 		error_msg := fmt.Sprintf("failed to execute update query: %v", err)
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_924", call_flow)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
+		if v := classifyConstraintError(err); v != nil {
+			return constraintViolationResponse(v, reqID, table_name, field_defs,
+				[]map[string]interface{}{update_record}, fmt.Sprintf("%s->SHD_RHD_1646", call_flow))
+		}
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: dbErrorKind(err),
+			Loc:       new_call_flow,
 		}
-		return ApiTypes.CustomHttpStatus_InternalError, resp
+		return dbErrorStatus(err, ApiTypes.CustomHttpStatus_InternalError), resp
 	}
 
 	// Get the number of affected rows
@@ -919,10 +1685,11 @@ func HandleDBUpdate(
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_932", call_flow)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_DBError,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_InternalError, resp
 	}
@@ -934,11 +1701,8 @@ func HandleDBUpdate(
 		ReqID:      reqID,
 		ResultType: "json",
 		NumRecords: 1,
-		Results: map[string]interface{}{
-			"rows_affected": rowsAffected,
-			"sql":           sql, // Include SQL for debugging (remove in production)
-		},
-		Loc: new_call_flow,
+		Results:    affectedResults(rowsAffected, sql),
+		Loc:        new_call_flow,
 	}
 
 	return ApiTypes.CustomHttpStatus_Success, resp
@@ -964,7 +1728,7 @@ func HandleDBDelete(
 	new_ctx := context.WithValue(ctx, ApiTypes.CallFlowKey, fmt.Sprintf("%s->SHD_RHD_983", call_flow))
 
 	var req ApiTypes.DeleteRequest
-	if err := json.Unmarshal(body, &req); err != nil {
+	if err := decodeJimoRequest(body, &req); err != nil {
 		log_id := sysdatastores.NextActivityLogID()
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_988", call_flow)
 		error_msg := fmt.Sprintf("failed parse request_type:%v, log_id:%d", err, log_id)
@@ -979,10 +1743,11 @@ func HandleDBDelete(
 
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
@@ -998,10 +1763,11 @@ func HandleDBDelete(
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_024", call_flow)
 		logger.Error("db_type not supported", "db_type", db_type)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: "db_type not supported (SHD_0208051800)",
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  "db_type not supported (SHD_0208051800)",
+			ErrorKind: ApiTypes.ErrorKind_InternalError,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
@@ -1015,10 +1781,11 @@ func HandleDBDelete(
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
@@ -1031,38 +1798,88 @@ func HandleDBDelete(
 	}
 
 	cond_def := req.Condition
-	expr, err := buildConditionExpr(new_ctx, table_name, cond_def, field_map)
+	expr, err := buildConditionExpr(new_ctx, table_name, cond_def,
+		conditionFieldMap(table_name, field_defs, nil), nil)
 	if err != nil {
 		error_msg := fmt.Sprintf("failed building conditions, err:%v", err)
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_064", call_flow)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
 
-	if expr == nil {
-		error_msg := fmt.Sprintf("missing conditions, loc:%s", req.Loc)
+	// A condition matching every row is only caught by expected_max_rows;
+	// no condition at all must be confirmed
+	if err := checkDeleteIntent(req, expr); err != nil {
+		error_msg := err.Error()
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_077", call_flow)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
+	if req.ConfirmDeleteAll {
+		logger.Warn("HandleJimoRequest: deleting all the rows", "table_name", table_name,
+			"user_name", user_name, "loc", req.Loc)
+	}
+
+	// Children first, then the parents, in one transaction
+	if len(req.Cascade) > 0 {
+		return handleCascadeDelete(new_ctx, rc, req, field_map, expr, db, user_name)
+	}
+
+	// Counted and deleted in one transaction, refused if over the
+	// caller's expectation
+	if req.ExpectedMaxRows > 0 {
+		rowsAffected, sql, err := runGuardedDelete(new_ctx, db, table_name, expr, req.ExpectedMaxRows)
+		if err != nil {
+			new_call_flow := fmt.Sprintf("%s->SHD_RHD_1727", call_flow)
+			var too_many *tooManyDeletesError
+			if errors.As(err, &too_many) {
+				logger.Warn("HandleJimoRequest", "error", err, "table_name", table_name, "loc", new_call_flow)
+				return tooManyDeletesResponse(reqID, table_name, too_many, new_call_flow)
+			}
+			error_msg := fmt.Sprintf("failed to execute delete query: %v", err)
+			logger.Error("HandleJimoRequest", "error_msg", error_msg)
+			resp := ApiTypes.JimoResponse{
+				Status:    false,
+				ReqID:     reqID,
+				ErrorMsg:  error_msg,
+				ErrorKind: dbErrorKind(err),
+				Loc:       new_call_flow,
+			}
+			return dbErrorStatus(err, ApiTypes.CustomHttpStatus_InternalError), resp
+		}
+
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_1728", call_flow)
+		return ApiTypes.CustomHttpStatus_Success, ApiTypes.JimoResponse{
+			Status:     true,
+			ReqID:      reqID,
+			ResultType: "json",
+			NumRecords: 1,
+			Results:    affectedResults(rowsAffected, sql),
+			Loc:        new_call_flow,
+		}
+	}
 
 	// Build the UPDATE query using Squirrel
-	query := sq.Delete(table_name).PlaceholderFormat(sq.Dollar)
+	query := sq.Delete(quoteQualified(table_name)).PlaceholderFormat(sq.Dollar)
 
-	// Add WHERE clause
-	query = query.Where(expr)
+	// Add WHERE clause, if not deleting all the rows
+	if expr != nil {
+		query = query.Where(expr)
+	}
 
 	// Generate the SQL and arguments
 	sql, args, err := query.ToSql()
@@ -1071,10 +1888,11 @@ func HandleDBDelete(
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_098", call_flow)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_BadRequest, resp
 	}
@@ -1082,18 +1900,19 @@ func HandleDBDelete(
 	// Execute the update query
 	// Assuming you have a database connection variable called 'db'
 	// Replace 'db' with your actual database connection variable
-	result, err := db.Exec(sql, args...)
+	result, err := databaseutil.ExecWithRetry(new_ctx, db, sql, args...)
 	if err != nil {
 		error_msg := fmt.Sprintf("failed to execute update query: %v", err)
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_115", call_flow)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: dbErrorKind(err),
+			Loc:       new_call_flow,
 		}
-		return ApiTypes.CustomHttpStatus_InternalError, resp
+		return dbErrorStatus(err, ApiTypes.CustomHttpStatus_InternalError), resp
 	}
 
 	// Get the number of affected rows
@@ -1103,10 +1922,11 @@ func HandleDBDelete(
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_130", call_flow)
 		logger.Error("HandleJimoRequest", "error_msg", error_msg)
 		resp := ApiTypes.JimoResponse{
-			Status:   false,
-			ReqID:    reqID,
-			ErrorMsg: error_msg,
-			Loc:      new_call_flow,
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_DBError,
+			Loc:       new_call_flow,
 		}
 		return ApiTypes.CustomHttpStatus_InternalError, resp
 	}
@@ -1118,16 +1938,26 @@ func HandleDBDelete(
 		ReqID:      reqID,
 		ResultType: "json",
 		NumRecords: 1,
-		Results: map[string]interface{}{
-			"rows_affected": rowsAffected,
-			"sql":           sql, // Include SQL for debugging (remove in production)
-		},
-		Loc: new_call_flow,
+		Results:    affectedResults(rowsAffected, sql),
+		Loc:        new_call_flow,
 	}
 
 	return ApiTypes.CustomHttpStatus_Success, resp
 }
 
+// affectedResults builds the results of an update or delete. The SQL
+// statement is included only when the server runs in debug mode
+// (app_info.debug) so production responses don't reveal the schema.
+func affectedResults(rowsAffected int64, sql string) map[string]interface{} {
+	results := map[string]interface{}{
+		"rows_affected": rowsAffected,
+	}
+	if ApiTypes.CommonConfig.AppInfo.Debug {
+		results["sql"] = sql
+	}
+	return results
+}
+
 // Condition represents a single condition in the WHERE clause
 type Condition struct {
 	FieldName string
@@ -1143,6 +1973,45 @@ const (
 	LogicOR  LogicOperator = "OR"
 )
 
+// dbErrorStatus returns 503 if err came from an open database circuit
+// breaker, otherwise 'status_code'.
+func dbErrorStatus(err error, status_code int) int {
+	if databaseutil.IsCircuitOpen(err) {
+		return http.StatusServiceUnavailable
+	}
+	return status_code
+}
+
+// dbErrorKind is the ErrorKind of a failed database call: timeout when the
+// context deadline passed or PostgreSQL cancelled the statement
+// (statement_timeout, 57014), db_error otherwise.
+func dbErrorKind(err error) ApiTypes.ErrorKind {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ApiTypes.ErrorKind_Timeout
+	}
+	var sql_state interface{ SQLState() string }
+	if errors.As(err, &sql_state) && sql_state.SQLState() == "57014" {
+		return ApiTypes.ErrorKind_Timeout
+	}
+	return ApiTypes.ErrorKind_DBError
+}
+
+// checkTimestampFormats checks the timestamp formats of the field defs of
+// 'req', including those of its joins.
+func checkTimestampFormats(req ApiTypes.QueryRequest) error {
+	field_defs := append([]ApiTypes.FieldDef{}, req.FieldDefs...)
+	for _, jd := range req.JoinDefs {
+		field_defs = append(field_defs, jd.FromFieldDefs...)
+		field_defs = append(field_defs, jd.JoinedFieldDefs...)
+	}
+	for _, f := range field_defs {
+		if err := ApiUtils.CheckTimestampFormat(f.TimestampFormat); err != nil {
+			return fmt.Errorf("field %s: %w", f.FieldName, err)
+		}
+	}
+	return nil
+}
+
 // RunQuery executes the given query and returns the results as JSON string
 func RunQuery(
 	ctx context.Context,
@@ -1155,21 +2024,119 @@ func RunQuery(
 	aliases []string,
 	field_def_map map[string][]ApiTypes.FieldDef) ([]map[string]interface{}, int, error) {
 	logger := rc.GetLogger()
-	call_flow := ctx.Value(ApiTypes.CallFlowKey).(string)
-	rows, err := db.Query(query, args...)
+	rows, err := databaseutil.QueryWithRetry(ctx, db, query, args...)
 	if err != nil {
 		logger.Error("RunQuery", "error", err)
 		return nil, 0, err
 	}
 	defer rows.Close()
 
-	var data_types = make(map[string]string)
 	logger.Info("RunQuery", "query", query, "args", args, "req.TableName", req.TableName)
-	for table_name, field_defs := range field_def_map {
-		for i := range field_defs {
-			full_name := fmt.Sprintf("%s.%s", table_name, field_defs[i].FieldName)
-			data_types[full_name] = field_defs[i].DataType
+	return scanQueryRows(ctx, rc, req, rows, selected_fields, aliases, field_def_map)
+}
+
+// RunQueryWithTotal is RunQuery that also returns the number of rows
+// 'count_query' counts (the rows matching the query without its LIMIT).
+// Both run in one read-only transaction, repeatable read unless
+// req.IsolationLevel asks for serializable, so the total and the page
+// come from the same snapshot.
+func RunQueryWithTotal(
+	ctx context.Context,
+	rc ApiTypes.RequestContext,
+	req ApiTypes.QueryRequest,
+	db *sql.DB,
+	query string,
+	count_query string,
+	args []interface{},
+	selected_fields []string,
+	aliases []string,
+	field_def_map map[string][]ApiTypes.FieldDef) ([]map[string]interface{}, int, int64, error) {
+	logger := rc.GetLogger()
+	logger.Info("RunQueryWithTotal", "query", query, "count_query", count_query,
+		"args", args, "req.TableName", req.TableName)
+
+	isolation, err := queryIsolation(req, ApiTypes.DBType)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var results []map[string]interface{}
+	var count int
+	var total int64
+	err = databaseutil.WithRetry(ctx, db, func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: isolation, ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := tx.QueryRowContext(ctx, count_query, args...).Scan(&total); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results, count, err = scanQueryRows(ctx, rc, req, rows, selected_fields, aliases, field_def_map)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		logger.Error("RunQueryWithTotal", "error", err)
+		return nil, 0, 0, err
+	}
+	return results, count, total, nil
+}
+
+// countQuery returns the query counting the rows of 'query', a query
+// built by buildQueryFrom without ORDER BY or LIMIT
+func countQuery(query string) string {
+	return "SELECT COUNT(*) FROM (" + query + ") AS jimo_total"
+}
+
+// scanQueryRows converts the rows of a query into maps keyed by the
+// aliases of the selected fields
+func scanQueryRows(
+	ctx context.Context,
+	rc ApiTypes.RequestContext,
+	req ApiTypes.QueryRequest,
+	rows *sql.Rows,
+	selected_fields []string,
+	aliases []string,
+	field_def_map map[string][]ApiTypes.FieldDef) ([]map[string]interface{}, int, error) {
+	logger := rc.GetLogger()
+	call_flow := ctx.Value(ApiTypes.CallFlowKey).(string)
+	var err error
+
+	converter, err := newValueConverter(req, field_def_map)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The fields of a join embedded as an array are selected as one JSON
+	// array of objects, in the column of the first of them (see
+	// arrayEmbedColumn). 'columns' are the indexes in 'selected_fields' of
+	// the columns of a row; 'array_names' maps the keys of the objects to
+	// their full field names.
+	array_join, has_array := arrayEmbedJoin(req.JoinDefs)
+	array_prefix := array_join.EmbedName + "____"
+	array_col := -1
+	array_names := make(map[string]string)
+	var columns []int
+	for i, field_name := range selected_fields {
+		if has_array && strings.HasPrefix(aliases[i], array_prefix) {
+			array_names[strings.TrimPrefix(aliases[i], array_prefix)] = field_name
+			if array_col >= 0 {
+				continue
+			}
+			array_col = i
 		}
+		columns = append(columns, i)
 	}
 
 	var results []map[string]interface{}
@@ -1177,15 +2144,15 @@ func RunQuery(
 	var count int = 0
 	for rows.Next() {
 		// Create a slice of interface{} to hold the values
-		values := make([]interface{}, len(selected_fields))
-		valuePtrs := make([]interface{}, len(selected_fields))
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
 		count++
 
 		for i := range values {
 			valuePtrs[i] = &values[i]
 		}
 
-		logger.Info("Handle record", "count", count, "args", args)
+		logger.Info("Handle record", "count", count)
 		// Scan the row into the value pointers
 		if err := rows.Scan(valuePtrs...); err != nil {
 			logger.Error("HandleJimoRequest", "error", err)
@@ -1196,15 +2163,26 @@ func RunQuery(
 		rowMap := make(map[string]interface{})
 		objMap := make(map[string]map[string]interface{})
 
-		for i, field_name := range selected_fields {
-			value := values[i]
+		for col, i := range columns {
+			value := values[col]
+			field_name := selected_fields[i]
 			field_aliase := aliases[i]
 
+			if i == array_col {
+				objs, err := converter.convertArrayEmbed(array_names, value)
+				if err != nil {
+					new_call_flow := fmt.Sprintf("%s->SHD_RHD_1661", call_flow)
+					logger.Error("HandleJimoRequest", "error", err, "loc", new_call_flow)
+					return nil, 0, err
+				}
+				rowMap[array_join.EmbedName] = objs
+				continue
+			}
+
 			// Convert the value based on its data type
 			// 'data_types' is a map of full field names!!!
 			// rowMap is a map of alises!!!
-			if data_type, exists := data_types[field_name]; exists {
-				convertedValue := convertValueByType(value, data_type)
+			if convertedValue, exists := converter.convert(field_name, value); exists {
 
 				// Process <embed_name>____<alias_name>
 				embed_index := strings.LastIndex(field_aliase, "____")
@@ -1226,14 +2204,22 @@ func RunQuery(
 			} else {
 				new_call_flow := fmt.Sprintf("%s->SHD_RHD_254", call_flow)
 				error_msg := fmt.Sprintf("field not found (%s):%s, selected:%v, data_types:%v",
-					new_call_flow, field_name, selected_fields, data_types)
+					new_call_flow, field_name, selected_fields, converter.data_types)
 				logger.Error("HandleJimoRequest", "error_msg", error_msg)
 				return nil, 0, fmt.Errorf("%s", error_msg)
 			}
 		}
 
+		// An embed with all fields null is null (or {}); one with some
+		// fields set keeps its null fields
 		for embed_name, subobj := range objMap {
-			rowMap[embed_name] = subobj
+			if !allNull(subobj) {
+				rowMap[embed_name] = subobj
+			} else if req.EmptyEmbedAsObject {
+				rowMap[embed_name] = map[string]interface{}{}
+			} else {
+				rowMap[embed_name] = nil
+			}
 		}
 
 		results = append(results, rowMap)
@@ -1251,84 +2237,61 @@ func RunQuery(
 	return results, count, nil
 }
 
-// Helper function to convert database values to appropriate Go types based on field_data_types
-func convertValueByType(value interface{}, dataType string) interface{} {
-	if value == nil {
-		return nil
-	}
-
-	switch dataType {
-	case "string", "varchar", "text", "char", "longtext", "mediumtext":
-		if val, ok := value.(string); ok {
-			return val
-		}
-		return fmt.Sprintf("%v", value)
-
-	case "int", "integer", "bigint", "smallint", "tinyint":
-		if val, ok := value.([]byte); ok {
-			intVal, err := strconv.Atoi(string(val))
-			if err == nil {
-				return intVal
-			}
-		}
-		if val, ok := value.(int64); ok {
-			return int(val)
-		}
-		if val, ok := value.(int32); ok {
-			return int(val)
-		}
-		if val, ok := value.(int); ok {
-			return val
-		}
-		return value
+// valueConverter converts the values of the selected fields of a query
+// to their result types (see databaseutil.ConvertValueWithFormat)
+type valueConverter struct {
+	data_types        map[string]string // By full field name
+	timestamp_formats map[string]string // By full field name
+	time_zone         *time.Location
+}
 
-	case "float", "double", "decimal", "numeric":
-		if val, ok := value.([]byte); ok {
-			floatVal, err := strconv.ParseFloat(string(val), 64)
-			if err == nil {
-				return floatVal
+func newValueConverter(
+	req ApiTypes.QueryRequest,
+	field_def_map map[string][]ApiTypes.FieldDef) (*valueConverter, error) {
+	converter := &valueConverter{
+		data_types:        make(map[string]string),
+		timestamp_formats: make(map[string]string),
+	}
+	for table_name, field_defs := range field_def_map {
+		for i := range field_defs {
+			full_name := fmt.Sprintf("%s.%s", table_name, field_defs[i].FieldName)
+			converter.data_types[full_name] = ApiTypes.FieldDataType(field_defs[i])
+			if field_defs[i].TimestampFormat != "" {
+				converter.timestamp_formats[full_name] = field_defs[i].TimestampFormat
 			}
 		}
-		if val, ok := value.(float64); ok {
-			return val
-		}
-		if val, ok := value.(float32); ok {
-			return float64(val)
-		}
-		return value
+	}
 
-	case "bool", "boolean":
-		if val, ok := value.([]byte); ok {
-			str := string(val)
-			boolVal, err := strconv.ParseBool(str)
-			if err == nil {
-				return boolVal
-			}
-			// Handle common boolean representations
-			return str == "1" || str == "true" || str == "TRUE" || str == "True"
-		}
-		if val, ok := value.(bool); ok {
-			return val
+	// Timestamps are returned in UTC unless the request names a zone
+	if req.TimeZone != "" {
+		time_zone, err := ApiUtils.LoadTimeZone(req.TimeZone)
+		if err != nil {
+			return nil, err
 		}
-		return value
+		converter.time_zone = time_zone
+	}
+	return converter, nil
+}
 
-	case "datetime", "timestamp", "date", "time":
-		// For datetime types, return as string
-		if val, ok := value.(string); ok {
-			return val
-		}
-		if val, ok := value.([]byte); ok {
-			return string(val)
-		}
-		return fmt.Sprintf("%v", value)
+// convert returns 'value' of the field 'full_name' converted, and false
+// if the field has no field def
+func (c *valueConverter) convert(full_name string, value interface{}) (interface{}, bool) {
+	data_type, exists := c.data_types[full_name]
+	if !exists {
+		return nil, false
+	}
+	return databaseutil.ConvertValueWithFormat(value, data_type,
+		c.time_zone, c.timestamp_formats[full_name]), true
+}
 
-	default:
-		// For unknown types or JSON, return as string or the original value
-		if val, ok := value.([]byte); ok {
-			return string(val)
+// allNull returns true if every value of 'obj' is nil
+func allNull(obj map[string]interface{}) bool {
+	for _, value := range obj {
+		if value != nil {
+			return false
 		}
-		return value
 	}
+	return true
 }
 
 func GetFieldStrValue(
@@ -1466,14 +2429,97 @@ func GetSelectedFields(json_array []map[string]interface{}, resource_name string
 	return fieldDefs, nil
 }
 
+// errBadConditionValue is returned when the value of a comparison does
+// not convert to the data type of its field. The request is answered
+// with BadRequest.
+var errBadConditionValue = errors.New("invalid condition value")
+
+// Default limits on the size of a request, when LibConfig.MaxConditions
+// and LibConfig.MaxSelectedFields are not set
+const (
+	defaultMaxConditions     = 200
+	defaultMaxSelectedFields = 200
+)
+
+// errQueryTooLarge is returned when a request has more conditions or
+// selected fields than allowed. The request is answered with BadRequest.
+var errQueryTooLarge = errors.New("request too large")
+
+// countConditions returns the number of condition nodes of 'condition',
+// through and/or/not groups and exists sub-queries (null conditions are
+// not counted). It stops counting once past 'limit'.
+func countConditions(condition ApiTypes.CondDef, limit int) int {
+	count := 0
+	if condition.Type != ApiTypes.ConditionTypeNull {
+		count = 1
+	}
+	for _, sub := range condition.Conditions {
+		if count > limit {
+			return count
+		}
+		count += countConditions(sub, limit-count)
+	}
+	if condition.SubQuery != nil && condition.SubQuery.Condition != nil && count <= limit {
+		count += countConditions(*condition.SubQuery.Condition, limit-count)
+	}
+	return count
+}
+
+// checkConditionCount fails if 'condition' has more than
+// LibConfig.MaxConditions condition nodes
+func checkConditionCount(condition ApiTypes.CondDef) error {
+	max_conditions := ApiTypes.LibConfig.MaxConditions
+	if max_conditions <= 0 {
+		max_conditions = defaultMaxConditions
+	}
+	if countConditions(condition, max_conditions) > max_conditions {
+		return fmt.Errorf("%w: more than %d conditions (SHD_RHD_1641)", errQueryTooLarge, max_conditions)
+	}
+	return nil
+}
+
+// checkSelectedFieldCount fails if more than LibConfig.MaxSelectedFields
+// fields are selected
+func checkSelectedFieldCount(num_fields int) error {
+	max_fields := ApiTypes.LibConfig.MaxSelectedFields
+	if max_fields <= 0 {
+		max_fields = defaultMaxSelectedFields
+	}
+	if num_fields > max_fields {
+		return fmt.Errorf("%w: %d selected fields, at most %d allowed (SHD_RHD_1642)",
+			errQueryTooLarge, num_fields, max_fields)
+	}
+	return nil
+}
+
 // buildConditionExpr builds conditions defined by 'condition'.
-// Field names in the condition are all local field names.
-// 'field_map' is also local field name map!
+// Field names in the condition must be in 'field_map': local field names,
+// plus qualified names of joined tables for queries with joins (see
+// conditionFieldMap). Values of comparisons are converted to the data
+// type of their field def (see coerceConditionValue); timestamp strings
+// without an offset are read in 'time_zone' (the default zone if nil).
+// A condition with more than LibConfig.MaxConditions nodes is rejected
+// before anything is built.
 func buildConditionExpr(
 	ctx context.Context,
 	table_name string,
 	condition ApiTypes.CondDef,
-	field_map map[string]bool) (sq.Sqlizer, error) {
+	field_map map[string]ApiTypes.FieldDef,
+	time_zone *time.Location) (sq.Sqlizer, error) {
+	if err := checkConditionCount(condition); err != nil {
+		return nil, fmt.Errorf("%w, table_name:%s", err, table_name)
+	}
+	return buildConditionNode(ctx, table_name, condition, field_map, time_zone)
+}
+
+// buildConditionNode builds 'condition' and its sub-conditions for
+// buildConditionExpr
+func buildConditionNode(
+	ctx context.Context,
+	table_name string,
+	condition ApiTypes.CondDef,
+	field_map map[string]ApiTypes.FieldDef,
+	time_zone *time.Location) (sq.Sqlizer, error) {
 	call_flow := ctx.Value(ApiTypes.CallFlowKey).(string)
 	new_ctx := context.WithValue(ctx, ApiTypes.CallFlowKey, fmt.Sprintf("%s->SHD_RHD_233", call_flow))
 
@@ -1487,54 +2533,58 @@ func buildConditionExpr(
 		dataType := condition.DataType
 		rawValue := condition.Value
 
+		// multi_contain names its fields in FieldNames
+		if Operator(condition.Opr) == MultiContain {
+			return buildMultiContainExpr(table_name, condition, field_map, call_flow)
+		}
+
 		// Validate field name (security critical!)
-		if !field_map[field] {
+		field_def, ok := field_map[field]
+		if !ok {
 			new_call_flow := fmt.Sprintf("%s->SHD_RHD_527", call_flow)
 			return nil, fmt.Errorf("invalid field name: %s, field_map:%v in table:%s, loc:%s",
 				field, field_map, table_name, new_call_flow)
 		}
 
-		// Use rawValue directly for parameterized queries - Squirrel handles type conversion
+		// MySQL stores array fields as JSON arrays (see FieldDef.ElementType),
+		// which the scalar and LIKE operators below would compare as text
+		if dataType == "array" && ApiTypes.DBType == ApiTypes.MysqlName {
+			new_call_flow := fmt.Sprintf("%s->SHD_RHD_1580", call_flow)
+			return nil, fmt.Errorf("array operators are not supported on MySQL (SHD_RHD_1581): field %s is "+
+				"stored as a JSON array, query it with JSON_CONTAINS(%s, ?) instead, table_name:%s, loc:%s",
+				field, field, table_name, new_call_flow)
+		}
+
+		// Comparisons bind the value converted to the field's type, so that
+		// e.g. "18" compares as an integer on every driver
+		switch Operator(condition.Opr) {
+		case Equal, GreaterThan, GreaterEqual, LessThan, LessEqual, NotEqual:
+			value, err := coerceConditionValue(field_def, rawValue, time_zone)
+			if err != nil {
+				new_call_flow := fmt.Sprintf("%s->SHD_RHD_1605", call_flow)
+				return nil, fmt.Errorf("%w for field %s (SHD_RHD_1606): %v, table_name:%s, loc:%s",
+					errBadConditionValue, field, err, table_name, new_call_flow)
+			}
+			rawValue = value
+		}
+
+		column := quoteQualified(field)
 		var expr sq.Sqlizer
 		switch Operator(condition.Opr) {
 		case Equal:
-			expr = sq.Eq{field: rawValue}
+			expr = sq.Eq{column: rawValue}
 		case GreaterThan:
-			expr = sq.Gt{field: rawValue}
+			expr = sq.Gt{column: rawValue}
 		case GreaterEqual:
-			expr = sq.GtOrEq{field: rawValue}
+			expr = sq.GtOrEq{column: rawValue}
 		case LessThan:
-			expr = sq.Lt{field: rawValue}
+			expr = sq.Lt{column: rawValue}
 		case LessEqual:
-			expr = sq.LtOrEq{field: rawValue}
+			expr = sq.LtOrEq{column: rawValue}
 		case NotEqual:
-			expr = sq.NotEq{field: rawValue}
-		case Contain:
-			if dataType == "string" {
-				strVal, ok := rawValue.(string)
-				if !ok {
-					new_call_flow := fmt.Sprintf("%s->SHD_RHD_524", call_flow)
-					return nil, fmt.Errorf("CONTAIN operator requires string value, got %T, table_name:%s, loc:%s",
-						rawValue, table_name, new_call_flow)
-				}
-				expr = sq.Like{field: "%" + strVal + "%"}
-			} else {
-				new_call_flow := fmt.Sprintf("%s->SHD_RHD_529", call_flow)
-				return nil, fmt.Errorf("CONTAIN operator only supported for string type, got %s, table_name:%s, loc:%s",
-					dataType, table_name, new_call_flow)
-			}
-		case Prefix:
-			if dataType == "string" {
-				strVal, ok := rawValue.(string)
-				if !ok {
-					new_call_flow := fmt.Sprintf("%s->SHD_RHD_536", call_flow)
-					return nil, fmt.Errorf("PREFIX operator requires string value, got %T, table_name:%s, loc:%s", rawValue, table_name, new_call_flow)
-				}
-				expr = sq.Like{field: strVal + "%"}
-			} else {
-				new_call_flow := fmt.Sprintf("%s->SHD_RHD_541", call_flow)
-				return nil, fmt.Errorf("PREFIX operator only supported for string type, got %s, table_name:%s, loc:%s", dataType, table_name, new_call_flow)
-			}
+			expr = sq.NotEq{column: rawValue}
+		case Contain, Prefix, Suffix, IContain, IPrefix:
+			return buildLikeExpr(table_name, field, Operator(condition.Opr), dataType, rawValue, call_flow)
 		default:
 			new_call_flow := fmt.Sprintf("%s->SHD_RHD_545", call_flow)
 			return nil, fmt.Errorf("unsupported operator (SHD_RHD_319): %s, table_name:%s, loc:%s", condition.Opr, table_name, new_call_flow)
@@ -1550,7 +2600,7 @@ func buildConditionExpr(
 
 		var subExprs []sq.Sqlizer
 		for _, subCond := range condition.Conditions {
-			expr, err := buildConditionExpr(new_ctx, table_name, subCond, field_map)
+			expr, err := buildConditionNode(new_ctx, table_name, subCond, field_map, time_zone)
 			if err != nil {
 				return nil, err
 			}
@@ -1570,7 +2620,7 @@ func buildConditionExpr(
 
 		var subExprs []sq.Sqlizer
 		for _, subCond := range condition.Conditions {
-			expr, err := buildConditionExpr(new_ctx, table_name, subCond, field_map)
+			expr, err := buildConditionNode(new_ctx, table_name, subCond, field_map, time_zone)
 			if err != nil {
 				return nil, err
 			}
@@ -1581,6 +2631,30 @@ func buildConditionExpr(
 		}
 		return sq.Or(subExprs), nil
 
+	case ApiTypes.ConditionTypeNot:
+		// Negate exactly one sub-condition. The sub-condition keeps its "?"
+		// placeholders, which are numbered when the statement is built.
+		if len(condition.Conditions) != 1 {
+			new_call_flow := fmt.Sprintf("%s->SHD_RHD_1562", call_flow)
+			return nil, fmt.Errorf("NOT condition must have exactly one sub-condition, got %d, table_name:%s, loc:%s",
+				len(condition.Conditions), table_name, new_call_flow)
+		}
+
+		expr, err := buildConditionNode(new_ctx, table_name, condition.Conditions[0], field_map, time_zone)
+		if err != nil {
+			return nil, err
+		}
+
+		if expr == nil {
+			new_call_flow := fmt.Sprintf("%s->SHD_RHD_1574", call_flow)
+			return nil, fmt.Errorf("NOT condition cannot negate a null condition, table_name:%s, loc:%s",
+				table_name, new_call_flow)
+		}
+		return sq.Expr("NOT (?)", expr), nil
+
+	case ApiTypes.ConditionTypeExists, ApiTypes.ConditionTypeNotExists:
+		return buildExistsExpr(new_ctx, table_name, condition, field_map, time_zone)
+
 	default:
 		new_call_flow := fmt.Sprintf("%s->SHD_RHD_591", call_flow)
 		return nil, fmt.Errorf("unknown condition type: %s, table_name:%s, loc:%s",
@@ -1588,6 +2662,266 @@ func buildConditionExpr(
 	}
 }
 
+// errBadSubQuery is returned when the sub-query of an exists or
+// not_exists condition is rejected. The request is answered with
+// BadRequest.
+var errBadSubQuery = errors.New("invalid sub-query")
+
+// buildExistsExpr builds an exists or not_exists condition, e.g. the
+// users with at least one order:
+//
+//	EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id AND <condition>)
+//
+// The ON clause correlates the sub-query with 'table_name': its source
+// fields must be in 'field_map', its joined fields and the fields of the
+// inner condition in the sub-query's FieldDefs. The sub-query table is
+// not aliased, so it can't be 'table_name', and only one level is
+// allowed: the inner condition can't have exists conditions.
+func buildExistsExpr(
+	ctx context.Context,
+	table_name string,
+	condition ApiTypes.CondDef,
+	field_map map[string]ApiTypes.FieldDef,
+	time_zone *time.Location) (sq.Sqlizer, error) {
+	call_flow := ctx.Value(ApiTypes.CallFlowKey).(string)
+	new_ctx := context.WithValue(ctx, ApiTypes.CallFlowKey, fmt.Sprintf("%s->SHD_RHD_1631", call_flow))
+
+	sub := condition.SubQuery
+	if sub == nil {
+		return nil, fmt.Errorf("%w: %s condition requires sub_query (SHD_RHD_1632), table_name:%s, loc:%s",
+			errBadSubQuery, condition.Type, table_name, call_flow)
+	}
+	if !isValidSQLIdentifier(sub.TableName) || sub.TableName == table_name {
+		return nil, fmt.Errorf("%w: invalid sub-query table:%q, it must differ from the queried table "+
+			"(SHD_RHD_1633), table_name:%s, loc:%s", errBadSubQuery, sub.TableName, table_name, call_flow)
+	}
+	if len(sub.OnClause) == 0 || len(sub.FieldDefs) == 0 {
+		return nil, fmt.Errorf("%w: sub-query on %s requires on_clause and field_defs (SHD_RHD_1634), "+
+			"table_name:%s, loc:%s", errBadSubQuery, sub.TableName, table_name, call_flow)
+	}
+
+	// Like the queried table's, the inner fields are referred to by their
+	// local or qualified names
+	sub_field_map := make(map[string]ApiTypes.FieldDef, 2*len(sub.FieldDefs))
+	for _, fd := range sub.FieldDefs {
+		if !isValidSQLIdentifier(fd.FieldName) {
+			return nil, fmt.Errorf("%w: invalid field name:%q in sub-query on %s (SHD_RHD_1635), loc:%s",
+				errBadSubQuery, fd.FieldName, sub.TableName, call_flow)
+		}
+		sub_field_map[fd.FieldName] = fd
+		sub_field_map[sub.TableName+"."+fd.FieldName] = fd
+	}
+
+	sub_table := quoteQualified(sub.TableName)
+	on_conditions := make([]string, 0, len(sub.OnClause))
+	for _, on := range sub.OnClause {
+		join_opr := on.JoinOpr
+		if join_opr == "" {
+			join_opr = "="
+		}
+		if !allowedJoinOprs[join_opr] {
+			return nil, fmt.Errorf("%w: invalid join operator:%q in sub-query on %s (SHD_RHD_1636), loc:%s",
+				errBadSubQuery, join_opr, sub.TableName, call_flow)
+		}
+		if _, ok := sub_field_map[on.JoinedFieldName]; !ok || strings.Contains(on.JoinedFieldName, ".") {
+			return nil, fmt.Errorf("%w: %s is not a field of sub-query table %s (SHD_RHD_1637), loc:%s",
+				errBadSubQuery, on.JoinedFieldName, sub.TableName, call_flow)
+		}
+
+		// The source field is of the outer query, qualified so that it
+		// does not resolve to a field of the sub-query table
+		source := on.SourceFieldName
+		if _, ok := field_map[source]; !ok {
+			return nil, fmt.Errorf("%w: invalid source field:%q in sub-query on %s (SHD_RHD_1638), "+
+				"table_name:%s, loc:%s", errBadSubQuery, source, sub.TableName, table_name, call_flow)
+		}
+		if !strings.Contains(source, ".") {
+			source = table_name + "." + source
+		} else if strings.HasPrefix(source, sub.TableName+".") {
+			return nil, fmt.Errorf("%w: source field %s is hidden by the sub-query table (SHD_RHD_1639), "+
+				"loc:%s", errBadSubQuery, source, call_flow)
+		}
+		on_conditions = append(on_conditions, fmt.Sprintf("%s.%s %s %s",
+			sub_table, quoteIdent(on.JoinedFieldName), join_opr, quoteQualified(source)))
+	}
+
+	sub_query := sq.Select("1").From(sub_table).Where(strings.Join(on_conditions, " AND "))
+	if sub.Condition != nil {
+		if hasExistsCondition(*sub.Condition) {
+			return nil, fmt.Errorf("%w: sub-query on %s cannot have exists conditions (SHD_RHD_1640), loc:%s",
+				errBadSubQuery, sub.TableName, call_flow)
+		}
+		inner, err := buildConditionNode(new_ctx, sub.TableName, *sub.Condition, sub_field_map, time_zone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: condition of sub-query on %s (SHD_RHD_1733): %w",
+				errBadSubQuery, sub.TableName, err)
+		}
+		if inner != nil {
+			sub_query = sub_query.Where(inner)
+		}
+	}
+
+	if condition.Type == ApiTypes.ConditionTypeNotExists {
+		return sq.Expr("NOT EXISTS (?)", sub_query), nil
+	}
+	return sq.Expr("EXISTS (?)", sub_query), nil
+}
+
+// hasExistsCondition reports whether 'condition' is or has an exists or
+// not_exists condition
+func hasExistsCondition(condition ApiTypes.CondDef) bool {
+	if condition.Type == ApiTypes.ConditionTypeExists || condition.Type == ApiTypes.ConditionTypeNotExists {
+		return true
+	}
+	for _, sub := range condition.Conditions {
+		if hasExistsCondition(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionCoercedTypes are the data types coerceConditionValue converts
+// values to. Values of other fields (json, arrays, uuid, ...) are bound
+// as sent.
+var conditionCoercedTypes = map[string]bool{
+	"text": true, "varchar": true, "char": true, "string": true,
+	"integer": true, "int": true, "int4": true,
+	"bigint": true, "int8": true,
+	"smallint": true, "int2": true,
+	"real": true, "float4": true,
+	"double precision": true, "float8": true,
+	"boolean": true, "bool": true,
+	"date": true, "timestamp": true, "timestamptz": true, "datetime": true,
+}
+
+// coerceConditionValue converts the value of a comparison with field 'fd'
+// the way convertFieldValue converts inserted values. A list (equal and
+// not_equal build IN and NOT IN from one) is converted item by item, and
+// null is kept, so equal still builds IS NULL. Numbers with a fraction
+// are not truncated to integers.
+func coerceConditionValue(fd ApiTypes.FieldDef, value interface{}, time_zone *time.Location) (interface{}, error) {
+	data_type := ApiTypes.FieldDataType(fd)
+	if !conditionCoercedTypes[data_type] {
+		return value, nil
+	}
+
+	convert := func(item interface{}) (interface{}, error) {
+		if num, ok := item.(float64); ok && num != math.Trunc(num) {
+			switch data_type {
+			case "integer", "int", "int4", "bigint", "int8", "smallint", "int2":
+				return nil, fmt.Errorf("cannot convert %v to %s", num, data_type)
+			}
+		}
+		return convertFieldValue(data_type, item, time_zone)
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		return convert(value)
+	}
+	converted := make([]interface{}, len(items))
+	for i, item := range items {
+		var err error
+		if converted[i], err = convert(item); err != nil {
+			return nil, err
+		}
+	}
+	return converted, nil
+}
+
+// likeEscaper escapes the LIKE wildcards in a user value so they match
+// literally. Backslash is the default LIKE escape character on PG and MySQL.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// buildLikeExpr builds the pattern-matching operators (contain, prefix,
+// suffix, and the case-insensitive icontain and iprefix). They apply to
+// string fields only.
+func buildLikeExpr(
+	table_name string,
+	field string,
+	opr Operator,
+	dataType string,
+	rawValue interface{},
+	call_flow string) (sq.Sqlizer, error) {
+	if dataType != "string" {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_529", call_flow)
+		return nil, fmt.Errorf("%s operator only supported for string type, got %s, table_name:%s, loc:%s",
+			strings.ToUpper(string(opr)), dataType, table_name, new_call_flow)
+	}
+	strVal, ok := rawValue.(string)
+	if !ok {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_524", call_flow)
+		return nil, fmt.Errorf("%s operator requires string value, got %T, table_name:%s, loc:%s",
+			strings.ToUpper(string(opr)), rawValue, table_name, new_call_flow)
+	}
+
+	escaped := likeEscaper.Replace(strVal)
+	var pattern string
+	switch opr {
+	case Contain, IContain:
+		pattern = "%" + escaped + "%"
+	case Prefix, IPrefix:
+		pattern = escaped + "%"
+	case Suffix:
+		pattern = "%" + escaped
+	}
+
+	column := quoteQualified(field)
+	if opr != IContain && opr != IPrefix {
+		return sq.Like{column: pattern}, nil
+	}
+	if ApiTypes.DBType == ApiTypes.MysqlName {
+		// 'field' was checked against the field defs by the caller
+		return sq.Expr(column+" LIKE ? COLLATE utf8mb4_general_ci", pattern), nil
+	}
+	return sq.ILike{column: pattern}, nil
+}
+
+// buildMultiContainExpr builds the multi_contain operator, a search box
+// over several string fields: (f1 ILIKE %v% OR f2 ILIKE %v% ...). Each
+// field must be in 'field_map'; wildcards in the value match literally.
+func buildMultiContainExpr(
+	table_name string,
+	condition ApiTypes.CondDef,
+	field_map map[string]ApiTypes.FieldDef,
+	call_flow string) (sq.Sqlizer, error) {
+	if len(condition.FieldNames) == 0 {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_1600", call_flow)
+		return nil, fmt.Errorf("MULTI_CONTAIN operator requires field_names (SHD_RHD_1601), table_name:%s, loc:%s",
+			table_name, new_call_flow)
+	}
+
+	// Checked here too so that errors name this operator, not icontain
+	if condition.DataType != "string" {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_1603", call_flow)
+		return nil, fmt.Errorf("MULTI_CONTAIN operator only supported for string type, got %s, table_name:%s, loc:%s",
+			condition.DataType, table_name, new_call_flow)
+	}
+	if _, ok := condition.Value.(string); !ok {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_1604", call_flow)
+		return nil, fmt.Errorf("MULTI_CONTAIN operator requires string value, got %T, table_name:%s, loc:%s",
+			condition.Value, table_name, new_call_flow)
+	}
+
+	var exprs sq.Or
+	for _, field := range condition.FieldNames {
+		// Validate field name (security critical!)
+		if _, ok := field_map[field]; !ok {
+			new_call_flow := fmt.Sprintf("%s->SHD_RHD_1602", call_flow)
+			return nil, fmt.Errorf("invalid field name: %s, field_map:%v in table:%s, loc:%s",
+				field, field_map, table_name, new_call_flow)
+		}
+
+		expr, err := buildLikeExpr(table_name, field, IContain, condition.DataType, condition.Value, call_flow)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
+
 // buildQuery builds a query. It returns:
 //   - Query (the statement)
 //   - args
@@ -1604,6 +2938,16 @@ func buildQuery(
 	rc ApiTypes.RequestContext,
 	ctx context.Context,
 	req ApiTypes.QueryRequest) (string, []interface{}, []string, []string, map[string][]ApiTypes.FieldDef, error) {
+	return buildQueryFrom(rc, ctx, req, quoteQualified(req.TableName))
+}
+
+// buildQueryFrom is buildQuery with the FROM clause given, e.g. the table
+// (quoted with quoteQualified) with a TABLESAMPLE clause.
+func buildQueryFrom(
+	rc ApiTypes.RequestContext,
+	ctx context.Context,
+	req ApiTypes.QueryRequest,
+	from_clause string) (string, []interface{}, []string, []string, map[string][]ApiTypes.FieldDef, error) {
 	call_flow := ctx.Value(ApiTypes.CallFlowKey).(string)
 	logger := rc.GetLogger()
 	new_ctx := context.WithValue(ctx, ApiTypes.CallFlowKey, fmt.Sprintf("%s->SHD_RHD_644", call_flow))
@@ -1642,26 +2986,39 @@ func buildQuery(
 		return "", nil, nil, nil, nil, fmt.Errorf("%s", error_msg)
 	}
 
+	selected_fields, err := expandSelectedFields(selected_fields, table_name, field_defs)
+	if err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_567", call_flow)
+		logger.Error("HandleJimoRequest", "error", err, "loc", new_call_flow)
+		return "", nil, nil, nil, nil, err
+	}
+
 	query_cond := req.Condition
 	logger.Info("HandleJimoRequest", "table_name", table_name, "selected_fields", selected_fields, "condition", query_cond, "loc", req.Loc)
 
-	// Note: the field map assumes field names are not full names (i.e.,
-	// tablename.fieldname). This is okey for table conditions. Join
-	// conditions should be defined in Joins.
-	field_map := make(map[string]bool)
-	for _, fd := range field_defs {
-		// full_name := fmt.Sprintf("%s.%s", table_name, fd.FieldName)
-		field_map[fd.FieldName] = true
+	join_defs := req.JoinDefs
+	if err := validateJoins(table_name, join_defs); err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_569", call_flow)
+		logger.Warn("HandleJimoRequest", "error", err,
+			"table_name", table_name, "join_plan", joinPlan(join_defs), "loc", new_call_flow)
+		return "", nil, nil, nil, nil, err
 	}
 
-	expr, err := buildConditionExpr(new_ctx, table_name, query_cond, field_map)
+	// The zone was checked by the handler
+	time_zone, _ := ApiUtils.LoadTimeZone(req.TimeZone)
+	field_map := conditionFieldMap(table_name, field_defs, join_defs)
+	expr, err := buildConditionExpr(new_ctx, table_name, query_cond, field_map, time_zone)
 	if err != nil {
 		return "", nil, nil, nil, nil, err
 	}
 
-	join_defs := req.JoinDefs
-	joinClauses, joinTypes, additionalSelectedFields, additional_aliases :=
+	joinClauses, joinTypes, additionalSelectedFields, additional_aliases, err :=
 		buildJoinClauses(join_defs, fieldDefMap)
+	if err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_563", call_flow)
+		logger.Error("HandleJimoRequest", "error", err, "loc", new_call_flow)
+		return "", nil, nil, nil, nil, err
+	}
 
 	// Combine selected fields
 	var allSelectedFields []string
@@ -1675,8 +3032,58 @@ func buildQuery(
 		allAliases = append(allAliases, additional_aliases...)
 	}
 
-	// Build the base query
-	query := sq.Select(allSelectedFields...).From(table_name).PlaceholderFormat(sq.Dollar)
+	if err := checkSelectedFieldCount(len(allSelectedFields)); err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_1643", call_flow)
+		logger.Warn("HandleJimoRequest", "error", err, "table_name", table_name, "loc", new_call_flow)
+		return "", nil, nil, nil, nil, err
+	}
+
+	if err := checkAliases(allSelectedFields, allAliases); err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_1594", call_flow)
+		logger.Warn("HandleJimoRequest", "error", err, "table_name", table_name, "loc", new_call_flow)
+		return "", nil, nil, nil, nil, err
+	}
+
+	if err := checkAliasCollisions(allSelectedFields, allAliases); err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_RHD_1590", call_flow)
+		logger.Warn("HandleJimoRequest", "error", err, "table_name", table_name, "loc", new_call_flow)
+		return "", nil, nil, nil, nil, err
+	}
+
+	// A join embedded as an array is aggregated per parent row, grouped
+	// on the other selected fields
+	var group_by []string
+	array_join, has_array := arrayEmbedJoin(join_defs)
+	if has_array {
+		group_by, err = arrayEmbedGroupBy(array_join, allSelectedFields, allAliases)
+		if err != nil {
+			new_call_flow := fmt.Sprintf("%s->SHD_RHD_1660", call_flow)
+			logger.Warn("HandleJimoRequest", "error", err, "table_name", table_name, "loc", new_call_flow)
+			return "", nil, nil, nil, nil, err
+		}
+	}
+
+	// Build the base query. Reserved words in the names are quoted.
+	// The fields of an array embed are selected as one column, where the
+	// first of them is (see scanQueryRows).
+	var quoted_fields, embed_keys, embed_fields []string
+	embed_pos := -1
+	for i, field := range allSelectedFields {
+		if has_array && strings.HasPrefix(allAliases[i], array_join.EmbedName+"____") {
+			if embed_pos < 0 {
+				embed_pos = len(quoted_fields)
+				quoted_fields = append(quoted_fields, "")
+			}
+			embed_keys = append(embed_keys, strings.TrimPrefix(allAliases[i], array_join.EmbedName+"____"))
+			embed_fields = append(embed_fields, quoteQualified(field))
+			continue
+		}
+		quoted_fields = append(quoted_fields, quoteQualified(field))
+	}
+	if embed_pos >= 0 {
+		quoted_fields[embed_pos] = arrayEmbedColumn(embed_keys, embed_fields, array_join)
+	}
+	query := sq.Select(quoted_fields...).From(from_clause).PlaceholderFormat(sq.Dollar)
 
 	// Add JOIN clauses
 	if len(joinClauses) > 0 {
@@ -1712,6 +3119,14 @@ func buildQuery(
 		query = query.Where(expr)
 	}
 
+	if len(group_by) > 0 {
+		quoted_group_by := make([]string, len(group_by))
+		for i, field := range group_by {
+			quoted_group_by[i] = quoteQualified(field)
+		}
+		query = query.GroupBy(quoted_group_by...)
+	}
+
 	// var start = req.Start
 	// var page_size = req.PageSize
 	// query.Limit(uint64(page_size)).Offset(uint64(start))
@@ -1745,7 +3160,8 @@ func getAliases(selected_field_names []string) ([]string, []string) {
 	// If ":<alias>" is not present, it defaults to <fieldname>
 	// This function returns two arrays of strings. The first one
 	// is all the selected fields without ":<alias>" and the
-	// second one is the aliases.
+	// second one is the aliases. The aliases are taken as they are;
+	// buildQueryFrom rejects the unsafe ones with checkAliases.
 	fields := make([]string, len(selected_field_names))
 	aliases := make([]string, len(selected_field_names))
 
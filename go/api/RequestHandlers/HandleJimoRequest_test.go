@@ -0,0 +1,1340 @@
+package RequestHandlers
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+func TestBuildConditionExpr(t *testing.T) {
+	field_map := map[string]ApiTypes.FieldDef{
+		"id":     {FieldName: "id", DataType: "int"},
+		"name":   {FieldName: "name", DataType: "string"},
+		"email":  {FieldName: "email", DataType: "string"},
+		"active": {FieldName: "active", DataType: "bool"},
+	}
+
+	tests := []struct {
+		name     string
+		db_type  string
+		cond     ApiTypes.CondDef
+		wantSQL  string
+		wantArgs []interface{}
+		wantNil  bool
+		wantErr  string
+	}{
+		{
+			name:    "null condition",
+			cond:    ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+			wantNil: true,
+		},
+		{
+			name:     "equal",
+			cond:     atomicCond("name", "string", Equal, "alice"),
+			wantSQL:  "name = ?",
+			wantArgs: []interface{}{"alice"},
+		},
+		{
+			name:     "greater than",
+			cond:     atomicCond("id", "int", GreaterThan, 2),
+			wantSQL:  "id > ?",
+			wantArgs: []interface{}{2},
+		},
+		{
+			name:     "greater equal",
+			cond:     atomicCond("id", "int", GreaterEqual, 2),
+			wantSQL:  "id >= ?",
+			wantArgs: []interface{}{2},
+		},
+		{
+			name:     "less than",
+			cond:     atomicCond("id", "int", LessThan, 2),
+			wantSQL:  "id < ?",
+			wantArgs: []interface{}{2},
+		},
+		{
+			name:     "less equal",
+			cond:     atomicCond("id", "int", LessEqual, 2),
+			wantSQL:  "id <= ?",
+			wantArgs: []interface{}{2},
+		},
+		{
+			name:     "string value coerced to int field",
+			cond:     atomicCond("id", "int", GreaterThan, "18"),
+			wantSQL:  "id > ?",
+			wantArgs: []interface{}{18},
+		},
+		{
+			name:     "json number coerced to int field",
+			cond:     atomicCond("id", "int", Equal, float64(7)),
+			wantSQL:  "id = ?",
+			wantArgs: []interface{}{int32(7)},
+		},
+		{
+			name:     "list coerced item by item",
+			cond:     atomicCond("id", "int", Equal, []interface{}{"1", float64(2)}),
+			wantSQL:  "id IN (?,?)",
+			wantArgs: []interface{}{1, int32(2)},
+		},
+		{
+			name:     "bool field",
+			cond:     atomicCond("active", "bool", Equal, "true"),
+			wantSQL:  "active = ?",
+			wantArgs: []interface{}{true},
+		},
+		{
+			name:    "null value kept",
+			cond:    atomicCond("id", "int", Equal, nil),
+			wantSQL: "id IS NULL",
+		},
+		{
+			name:    "value not an int",
+			cond:    atomicCond("id", "int", Equal, "abc"),
+			wantErr: "invalid condition value for field id",
+		},
+		{
+			name:    "fraction not truncated",
+			cond:    atomicCond("id", "int", LessThan, 2.5),
+			wantErr: "cannot convert 2.5 to int",
+		},
+		{
+			name:    "value not a bool",
+			cond:    atomicCond("active", "bool", Equal, "maybe"),
+			wantErr: "cannot convert string 'maybe' to boolean",
+		},
+		{
+			name:     "not equal",
+			cond:     atomicCond("name", "string", NotEqual, "bob"),
+			wantSQL:  "name <> ?",
+			wantArgs: []interface{}{"bob"},
+		},
+		{
+			name:     "contain escapes wildcards",
+			cond:     atomicCond("email", "string", Contain, "50%_off"),
+			wantSQL:  "email LIKE ?",
+			wantArgs: []interface{}{`%50\%\_off%`},
+		},
+		{
+			name:     "prefix",
+			cond:     atomicCond("name", "string", Prefix, "al"),
+			wantSQL:  "name LIKE ?",
+			wantArgs: []interface{}{"al%"},
+		},
+		{
+			name:     "suffix",
+			cond:     atomicCond("email", "string", Suffix, "@example.com"),
+			wantSQL:  "email LIKE ?",
+			wantArgs: []interface{}{"%@example.com"},
+		},
+		{
+			name:     "icontain on postgres",
+			db_type:  ApiTypes.PgName,
+			cond:     atomicCond("name", "string", IContain, "LI"),
+			wantSQL:  "name ILIKE ?",
+			wantArgs: []interface{}{"%LI%"},
+		},
+		{
+			name:     "iprefix on mysql",
+			db_type:  ApiTypes.MysqlName,
+			cond:     atomicCond("name", "string", IPrefix, "AL"),
+			wantSQL:  "name LIKE ? COLLATE utf8mb4_general_ci",
+			wantArgs: []interface{}{"AL%"},
+		},
+		{
+			name:     "multi_contain on postgres",
+			db_type:  ApiTypes.PgName,
+			cond:     multiContainCond("string", "50%", "name", "email"),
+			wantSQL:  "(name ILIKE ? OR email ILIKE ?)",
+			wantArgs: []interface{}{`%50\%%`, `%50\%%`},
+		},
+		{
+			name:     "multi_contain on mysql",
+			db_type:  ApiTypes.MysqlName,
+			cond:     multiContainCond("string", "al", "name", "email"),
+			wantSQL:  "(name LIKE ? COLLATE utf8mb4_general_ci OR email LIKE ? COLLATE utf8mb4_general_ci)",
+			wantArgs: []interface{}{"%al%", "%al%"},
+		},
+		{
+			name: "and",
+			cond: ApiTypes.CondDef{
+				Type: ApiTypes.ConditionTypeAnd,
+				Conditions: []ApiTypes.CondDef{
+					atomicCond("name", "string", Equal, "alice"),
+					atomicCond("id", "int", GreaterThan, 0),
+				},
+			},
+			wantSQL:  "(name = ? AND id > ?)",
+			wantArgs: []interface{}{"alice", 0},
+		},
+		{
+			name: "or with nested and",
+			cond: ApiTypes.CondDef{
+				Type: ApiTypes.ConditionTypeOr,
+				Conditions: []ApiTypes.CondDef{
+					atomicCond("name", "string", Equal, "alice"),
+					{
+						Type: ApiTypes.ConditionTypeAnd,
+						Conditions: []ApiTypes.CondDef{
+							atomicCond("id", "int", GreaterEqual, 2),
+							atomicCond("id", "int", LessEqual, 3),
+						},
+					},
+				},
+			},
+			wantSQL:  "(name = ? OR (id >= ? AND id <= ?))",
+			wantArgs: []interface{}{"alice", 2, 3},
+		},
+		{
+			name: "null sub-conditions are dropped",
+			cond: ApiTypes.CondDef{
+				Type: ApiTypes.ConditionTypeAnd,
+				Conditions: []ApiTypes.CondDef{
+					{Type: ApiTypes.ConditionTypeNull},
+					atomicCond("id", "int", Equal, 1),
+				},
+			},
+			wantSQL:  "(id = ?)",
+			wantArgs: []interface{}{1},
+		},
+		{
+			name: "not",
+			cond: ApiTypes.CondDef{
+				Type:       ApiTypes.ConditionTypeNot,
+				Conditions: []ApiTypes.CondDef{atomicCond("name", "string", Equal, "bob")},
+			},
+			wantSQL:  "NOT (name = ?)",
+			wantArgs: []interface{}{"bob"},
+		},
+		{
+			name:    "unknown field",
+			cond:    atomicCond("password", "string", Equal, "x"),
+			wantErr: "invalid field name: password",
+		},
+		{
+			name:    "unsupported operator",
+			cond:    atomicCond("id", "int", Operator("~"), 1),
+			wantErr: "unsupported operator",
+		},
+		{
+			name:    "like on a non-string field",
+			cond:    atomicCond("id", "int", Contain, "1"),
+			wantErr: "CONTAIN operator only supported for string type",
+		},
+		{
+			name:    "like with a non-string value",
+			cond:    atomicCond("name", "string", Prefix, 1),
+			wantErr: "PREFIX operator requires string value",
+		},
+		{
+			name:     "array field on postgres",
+			db_type:  ApiTypes.PgName,
+			cond:     atomicCond("name", "array", Equal, "{a}"),
+			wantSQL:  "name = ?",
+			wantArgs: []interface{}{"{a}"},
+		},
+		{
+			name:    "array field on mysql",
+			db_type: ApiTypes.MysqlName,
+			cond:    atomicCond("name", "array", Equal, "a"),
+			wantErr: "array operators are not supported on MySQL (SHD_RHD_1581): field name is stored as a JSON array, query it with JSON_CONTAINS(name, ?)",
+		},
+		{
+			name:    "multi_contain with an unknown field",
+			cond:    multiContainCond("string", "al", "name", "password"),
+			wantErr: "invalid field name: password",
+		},
+		{
+			name:    "multi_contain without fields",
+			cond:    multiContainCond("string", "al"),
+			wantErr: "MULTI_CONTAIN operator requires field_names (SHD_RHD_1601)",
+		},
+		{
+			name:    "multi_contain on a non-string type",
+			cond:    multiContainCond("int", "1", "id", "name"),
+			wantErr: "MULTI_CONTAIN operator only supported for string type, got int",
+		},
+		{
+			name:    "multi_contain with a non-string value",
+			cond:    multiContainCond("string", 1, "name", "email"),
+			wantErr: "MULTI_CONTAIN operator requires string value, got int",
+		},
+		{
+			name:    "empty and",
+			cond:    ApiTypes.CondDef{Type: ApiTypes.ConditionTypeAnd},
+			wantErr: "AND condition must have at least one sub-condition",
+		},
+		{
+			name:    "empty or",
+			cond:    ApiTypes.CondDef{Type: ApiTypes.ConditionTypeOr},
+			wantErr: "OR condition must have at least one sub-condition",
+		},
+		{
+			name: "not with two sub-conditions",
+			cond: ApiTypes.CondDef{
+				Type: ApiTypes.ConditionTypeNot,
+				Conditions: []ApiTypes.CondDef{
+					atomicCond("id", "int", Equal, 1),
+					atomicCond("id", "int", Equal, 2),
+				},
+			},
+			wantErr: "NOT condition must have exactly one sub-condition, got 2",
+		},
+		{
+			name: "not of a null condition",
+			cond: ApiTypes.CondDef{
+				Type:       ApiTypes.ConditionTypeNot,
+				Conditions: []ApiTypes.CondDef{{Type: ApiTypes.ConditionTypeNull}},
+			},
+			wantErr: "NOT condition cannot negate a null condition",
+		},
+		{
+			name: "error in a sub-condition",
+			cond: ApiTypes.CondDef{
+				Type:       ApiTypes.ConditionTypeOr,
+				Conditions: []ApiTypes.CondDef{atomicCond("secret", "string", Equal, "x")},
+			},
+			wantErr: "invalid field name: secret",
+		},
+		{
+			name:    "unknown condition type",
+			cond:    ApiTypes.CondDef{Type: "xor"},
+			wantErr: "unknown condition type: xor",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := ApiTypes.DBType
+			ApiTypes.DBType = tt.db_type
+			defer func() { ApiTypes.DBType = saved }()
+
+			expr, err := buildConditionExpr(testCtx(), "users", tt.cond, field_map, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantNil {
+				if expr != nil {
+					t.Fatalf("expr = %#v, want nil", expr)
+				}
+				return
+			}
+
+			sql, args, err := expr.ToSql()
+			if err != nil {
+				t.Fatalf("ToSql: %v", err)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("sql = %q, want %q", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// ordersExists is an exists condition on the orders of a user, with the
+// inner condition if given
+func ordersExists(cond_type ApiTypes.ConditionType, inner ...ApiTypes.CondDef) ApiTypes.CondDef {
+	cond := ApiTypes.CondDef{
+		Type: cond_type,
+		SubQuery: &ApiTypes.SubQueryDef{
+			TableName: "orders",
+			OnClause:  []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+			FieldDefs: ordersFieldDefs,
+		},
+	}
+	if len(inner) > 0 {
+		cond.SubQuery.Condition = &inner[0]
+	}
+	return cond
+}
+
+func TestBuildExistsExpr(t *testing.T) {
+	field_map := conditionFieldMap("users", usersFieldDefs, nil)
+	big_order := atomicCond("amount", "int", GreaterThan, 100)
+
+	tests := []struct {
+		name     string
+		cond     ApiTypes.CondDef
+		wantSQL  string
+		wantArgs []interface{}
+		wantErr  string
+	}{
+		{
+			name:    "exists",
+			cond:    ordersExists(ApiTypes.ConditionTypeExists),
+			wantSQL: "EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id)",
+		},
+		{
+			name:     "not exists with an inner condition",
+			cond:     ordersExists(ApiTypes.ConditionTypeNotExists, big_order),
+			wantSQL:  "NOT EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id AND amount > ?)",
+			wantArgs: []interface{}{100},
+		},
+		{
+			name: "or'ed with an outer condition",
+			cond: ApiTypes.CondDef{
+				Type: ApiTypes.ConditionTypeOr,
+				Conditions: []ApiTypes.CondDef{
+					atomicCond("name", "string", Equal, "alice"),
+					ordersExists(ApiTypes.ConditionTypeExists, big_order),
+				},
+			},
+			wantSQL:  "(name = ? OR EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id AND amount > ?))",
+			wantArgs: []interface{}{"alice", 100},
+		},
+		{
+			name:    "no sub-query",
+			cond:    ApiTypes.CondDef{Type: ApiTypes.ConditionTypeExists},
+			wantErr: "exists condition requires sub_query",
+		},
+		{
+			name: "sub-query on the queried table",
+			cond: func() ApiTypes.CondDef {
+				c := ordersExists(ApiTypes.ConditionTypeExists)
+				c.SubQuery.TableName = "users"
+				return c
+			}(),
+			wantErr: "it must differ from the queried table",
+		},
+		{
+			name:    "unknown sub-query field",
+			cond:    ordersExists(ApiTypes.ConditionTypeExists, atomicCond("secret", "string", Equal, "x")),
+			wantErr: "invalid field name: secret",
+		},
+		{
+			name: "unknown joined field",
+			cond: func() ApiTypes.CondDef {
+				c := ordersExists(ApiTypes.ConditionTypeExists)
+				c.SubQuery.OnClause[0].JoinedFieldName = "customer_id"
+				return c
+			}(),
+			wantErr: "customer_id is not a field of sub-query table orders",
+		},
+		{
+			name: "unknown source field",
+			cond: func() ApiTypes.CondDef {
+				c := ordersExists(ApiTypes.ConditionTypeExists)
+				c.SubQuery.OnClause[0].SourceFieldName = "amount"
+				return c
+			}(),
+			wantErr: "invalid source field:\"amount\"",
+		},
+		{
+			name: "bad operator",
+			cond: func() ApiTypes.CondDef {
+				c := ordersExists(ApiTypes.ConditionTypeExists)
+				c.SubQuery.OnClause[0].JoinOpr = "; DROP"
+				return c
+			}(),
+			wantErr: "invalid join operator",
+		},
+		{
+			name: "nested exists",
+			cond: ordersExists(ApiTypes.ConditionTypeExists, ApiTypes.CondDef{
+				Type:       ApiTypes.ConditionTypeNot,
+				Conditions: []ApiTypes.CondDef{ordersExists(ApiTypes.ConditionTypeExists)},
+			}),
+			wantErr: "cannot have exists conditions",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := buildConditionExpr(testCtx(), "users", tt.cond, field_map, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				if !errors.Is(err, errBadSubQuery) {
+					t.Errorf("error = %v, want errBadSubQuery", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			sql, args, err := expr.ToSql()
+			if err != nil {
+				t.Fatalf("ToSql: %v", err)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("sql = %q, want %q", sql, tt.wantSQL)
+			}
+			if len(args) != len(tt.wantArgs) || (len(args) > 0 && !reflect.DeepEqual(args, tt.wantArgs)) {
+				t.Errorf("args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestRequestSizeLimits(t *testing.T) {
+	saved := ApiTypes.LibConfig
+	defer func() { ApiTypes.LibConfig = saved }()
+	ApiTypes.LibConfig.MaxConditions = 5
+	ApiTypes.LibConfig.MaxSelectedFields = 2
+
+	// and(a, or(b, not(c)), exists(orders: d)): 8 nodes
+	cond := ApiTypes.CondDef{
+		Type: ApiTypes.ConditionTypeAnd,
+		Conditions: []ApiTypes.CondDef{
+			atomicCond("id", "int", GreaterThan, 1),
+			{Type: ApiTypes.ConditionTypeOr, Conditions: []ApiTypes.CondDef{
+				atomicCond("name", "string", Equal, "bob"),
+				{Type: ApiTypes.ConditionTypeNot, Conditions: []ApiTypes.CondDef{atomicCond("id", "int", Equal, 3)}},
+			}},
+			ordersExists(ApiTypes.ConditionTypeExists, atomicCond("amount", "int", GreaterThan, 100)),
+		},
+	}
+	if n := countConditions(cond, 100); n != 8 {
+		t.Errorf("countConditions = %d, want 8", n)
+	}
+	if n := countConditions(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull}, 100); n != 0 {
+		t.Errorf("null condition counted %d", n)
+	}
+
+	field_map := conditionFieldMap("users", usersFieldDefs, nil)
+	if _, err := buildConditionExpr(testCtx(), "users", cond, field_map, nil); !errors.Is(err, errQueryTooLarge) {
+		t.Errorf("too many conditions: error = %v, want errQueryTooLarge", err)
+	}
+	if _, err := buildConditionExpr(testCtx(), "users", cond.Conditions[1], field_map, nil); err != nil {
+		t.Errorf("within the limit: error = %v", err)
+	}
+
+	req := ApiTypes.QueryRequest{
+		TableName:  "users",
+		FieldDefs:  usersFieldDefs,
+		FieldNames: []string{"*"},
+		Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+	}
+	rc := testharness.NewFakeRequestContext(t, testUser())
+	if _, _, _, _, _, err := buildQuery(rc, testCtx(), req); !errors.Is(err, errQueryTooLarge) {
+		t.Errorf("too many selected fields: error = %v, want errQueryTooLarge", err)
+	}
+	req.FieldNames = []string{"users.id", "users.name"}
+	if _, _, _, _, _, err := buildQuery(rc, testCtx(), req); err != nil {
+		t.Errorf("selected fields within the limit: error = %v", err)
+	}
+}
+
+func TestBuildJoinClauses(t *testing.T) {
+	tests := []struct {
+		name         string
+		joins        []ApiTypes.JoinDef
+		wantClauses  []string
+		wantTypes    []string
+		wantFields   []string
+		wantAliases  []string
+		wantDefTable string
+	}{
+		{
+			name:        "no joins",
+			wantClauses: []string{},
+			wantTypes:   []string{},
+			wantFields:  []string{},
+			wantAliases: []string{},
+		},
+		{
+			name: "default operator and alias",
+			joins: []ApiTypes.JoinDef{{
+				FromTableName:   "users",
+				JoinedTableName: "orders",
+				OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+				JoinType:        ApiTypes.JoinTypeLeftJoin,
+				SelectedFields:  []string{"orders.amount", "orders.id:order_id"},
+				JoinedFieldDefs: ordersFieldDefs,
+			}},
+			wantClauses:  []string{"orders ON users.id = orders.user_id"},
+			wantTypes:    []string{ApiTypes.JoinTypeLeftJoin},
+			wantFields:   []string{"orders.amount", "orders.id"},
+			wantAliases:  []string{"amount", "order_id"},
+			wantDefTable: "orders",
+		},
+		{
+			name: "several on conditions and an embed name",
+			joins: []ApiTypes.JoinDef{{
+				FromTableName:   "users",
+				JoinedTableName: "orders",
+				OnClause: []ApiTypes.OnClauseDef{
+					{SourceFieldName: "id", JoinedFieldName: "user_id"},
+					{SourceFieldName: "id", JoinedFieldName: "id", JoinOpr: "<>"},
+				},
+				JoinType:       ApiTypes.JoinTypeInnerJoin,
+				SelectedFields: []string{"orders.amount"},
+				EmbedName:      "order",
+			}},
+			wantClauses: []string{"orders ON users.id = orders.user_id AND users.id <> orders.id"},
+			wantTypes:   []string{ApiTypes.JoinTypeInnerJoin},
+			wantFields:  []string{"orders.amount"},
+			wantAliases: []string{"order____amount"},
+		},
+		{
+			name: "join without on clause is skipped",
+			joins: []ApiTypes.JoinDef{
+				{FromTableName: "users", JoinedTableName: "orders", JoinType: ApiTypes.JoinTypeJoin},
+				{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id", JoinOpr: "="}},
+					JoinType:        ApiTypes.JoinTypeJoin,
+				},
+			},
+			wantClauses: []string{"orders ON users.id = orders.user_id"},
+			wantTypes:   []string{ApiTypes.JoinTypeJoin},
+			wantFields:  []string{},
+			wantAliases: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field_def_map := map[string][]ApiTypes.FieldDef{"users": usersFieldDefs}
+			clauses, types, fields, aliases, err := buildJoinClauses(tt.joins, field_def_map)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Compare with nil and empty treated alike
+			check := func(what string, got []string, want []string) {
+				t.Helper()
+				if len(got) != len(want) || (len(got) > 0 && !reflect.DeepEqual(got, want)) {
+					t.Errorf("%s = %q, want %q", what, got, want)
+				}
+			}
+			check("clauses", clauses, tt.wantClauses)
+			check("join types", types, tt.wantTypes)
+			check("selected fields", fields, tt.wantFields)
+			check("aliases", aliases, tt.wantAliases)
+
+			if tt.wantDefTable != "" {
+				if _, ok := field_def_map[tt.wantDefTable]; !ok {
+					t.Errorf("field defs of %s were not added", tt.wantDefTable)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         ApiTypes.QueryRequest
+		wantSQL     string
+		wantArgs    []interface{}
+		wantFields  []string
+		wantAliases []string
+		wantErr     string
+	}{
+		{
+			name: "select with condition and alias",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id", "users.name:user_name"},
+				Condition:  atomicCond("name", "string", Equal, "alice"),
+			},
+			wantSQL:     "SELECT users.id, users.name FROM users WHERE name = $1",
+			wantArgs:    []interface{}{"alice"},
+			wantFields:  []string{"users.id", "users.name"},
+			wantAliases: []string{"id", "user_name"},
+		},
+		{
+			name: "no condition",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+			},
+			wantSQL:     "SELECT users.id FROM users",
+			wantFields:  []string{"users.id"},
+			wantAliases: []string{"id"},
+		},
+		{
+			name: "placeholders are numbered across groups",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition: ApiTypes.CondDef{
+					Type: ApiTypes.ConditionTypeAnd,
+					Conditions: []ApiTypes.CondDef{
+						atomicCond("id", "int", GreaterThan, 1),
+						{
+							Type:       ApiTypes.ConditionTypeNot,
+							Conditions: []ApiTypes.CondDef{atomicCond("name", "string", Prefix, "c")},
+						},
+					},
+				},
+			},
+			wantSQL:     "SELECT users.id FROM users WHERE (id > $1 AND NOT (name LIKE $2))",
+			wantArgs:    []interface{}{1, "c%"},
+			wantFields:  []string{"users.id"},
+			wantAliases: []string{"id"},
+		},
+		{
+			name: "exists is numbered with the outer placeholders",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition: ApiTypes.CondDef{
+					Type: ApiTypes.ConditionTypeAnd,
+					Conditions: []ApiTypes.CondDef{
+						ordersExists(ApiTypes.ConditionTypeExists, atomicCond("amount", "int", GreaterThan, 100)),
+						atomicCond("id", "int", GreaterThan, 1),
+					},
+				},
+			},
+			wantSQL: "SELECT users.id FROM users WHERE (EXISTS (SELECT 1 FROM orders " +
+				"WHERE orders.user_id = users.id AND amount > $1) AND id > $2)",
+			wantArgs:    []interface{}{100, 1},
+			wantFields:  []string{"users.id"},
+			wantAliases: []string{"id"},
+		},
+		{
+			name: "join with embedded fields",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.name"},
+				Condition:  atomicCond("id", "int", Equal, 1),
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+					JoinType:        ApiTypes.JoinTypeLeftJoin,
+					SelectedFields:  []string{"orders.amount"},
+					JoinedFieldDefs: ordersFieldDefs,
+					EmbedName:       "order",
+				}},
+			},
+			wantSQL:     "SELECT users.name, orders.amount FROM users LEFT JOIN orders ON users.id = orders.user_id WHERE id = $1",
+			wantArgs:    []interface{}{1},
+			wantFields:  []string{"users.name", "orders.amount"},
+			wantAliases: []string{"name", "order____amount"},
+		},
+		{
+			name: "condition on joined column OR'ed with base column",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.name"},
+				Condition: ApiTypes.CondDef{
+					Type: ApiTypes.ConditionTypeOr,
+					Conditions: []ApiTypes.CondDef{
+						atomicCond("users.name", "string", Equal, "alice"),
+						atomicCond("orders.amount", "int", GreaterThan, 100),
+					},
+				},
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+					JoinType:        ApiTypes.JoinTypeJoin,
+					SelectedFields:  []string{"orders.amount"},
+					JoinedFieldDefs: ordersFieldDefs,
+					EmbedName:       "order",
+				}},
+			},
+			wantSQL: "SELECT users.name, orders.amount FROM users JOIN orders ON users.id = orders.user_id " +
+				"WHERE (users.name = $1 OR orders.amount > $2)",
+			wantArgs:    []interface{}{"alice", 100},
+			wantFields:  []string{"users.name", "orders.amount"},
+			wantAliases: []string{"name", "order____amount"},
+		},
+		{
+			name: "condition on joined column not in its field defs",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.name"},
+				Condition:  atomicCond("orders.status", "string", Equal, "paid"),
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+					JoinType:        ApiTypes.JoinTypeJoin,
+					JoinedFieldDefs: ordersFieldDefs,
+				}},
+			},
+			wantErr: "invalid field name: orders.status",
+		},
+		{
+			name: "qualified condition field without joins",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.name"},
+				Condition:  atomicCond("orders.amount", "int", Equal, 1),
+			},
+			wantErr: "invalid field name: orders.amount",
+		},
+		{
+			name: "joined field with the alias of a base field",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id", "users.name"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+					JoinType:        ApiTypes.JoinTypeJoin,
+					SelectedFields:  []string{"orders.id", "orders.amount"},
+					JoinedFieldDefs: ordersFieldDefs,
+				}},
+			},
+			wantErr: `duplicate result field "id": selected by users.id and orders.id, give one of them an alias`,
+		},
+		{
+			name: "joined field aliased explicitly",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id", "users.name"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+					JoinType:        ApiTypes.JoinTypeJoin,
+					SelectedFields:  []string{"orders.id:order_id", "orders.amount"},
+					JoinedFieldDefs: ordersFieldDefs,
+				}},
+			},
+			wantSQL:     "SELECT users.id, users.name, orders.id, orders.amount FROM users JOIN orders ON users.id = orders.user_id",
+			wantFields:  []string{"users.id", "users.name", "orders.id", "orders.amount"},
+			wantAliases: []string{"id", "name", "order_id", "amount"},
+		},
+		{
+			name: "embed name of a base field alias",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id", "users.name"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+					JoinType:        ApiTypes.JoinTypeJoin,
+					SelectedFields:  []string{"orders.amount"},
+					JoinedFieldDefs: ordersFieldDefs,
+					EmbedName:       "name",
+				}},
+			},
+			wantErr: `duplicate result field "name": users.name and the embedded orders.amount`,
+		},
+		{
+			name: "wildcard with joined wildcard",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"*"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+					JoinType:        ApiTypes.JoinTypeJoin,
+					SelectedFields:  []string{"*"},
+					JoinedFieldDefs: ordersFieldDefs,
+					EmbedName:       "order",
+				}},
+			},
+			wantSQL: "SELECT users.id, users.name, users.email, users.created_at, orders.id, orders.user_id, orders.amount " +
+				"FROM users JOIN orders ON users.id = orders.user_id",
+			wantFields: []string{"users.id", "users.name", "users.email", "users.created_at",
+				"orders.id", "orders.user_id", "orders.amount"},
+			wantAliases: []string{"id", "name", "email", "created_at",
+				"order____id", "order____user_id", "order____amount"},
+		},
+		{
+			name: "wildcard mixed with fields",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"*", "users.id:user_id"},
+			},
+			wantErr: "cannot be combined with other selected fields",
+		},
+		{
+			name: "joined wildcard without field defs",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+					JoinType:        ApiTypes.JoinTypeJoin,
+					SelectedFields:  []string{"*"},
+				}},
+			},
+			wantErr: "needs field_defs, table:orders",
+		},
+		{
+			name: "alias with a quote and a comment",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id", "users.name:x\" FROM users; --"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+			},
+			wantErr: "invalid result field alias",
+		},
+		{
+			name: "alias with spaces",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id", "users.name:user name"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+			},
+			wantErr: "invalid result field alias",
+		},
+		{
+			name: "alias with parentheses",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id", "users.name:pg_sleep(10)"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+			},
+			wantErr: "invalid result field alias",
+		},
+		{
+			name: "empty alias",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id", "users.name:"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+			},
+			wantErr: "invalid result field alias",
+		},
+		{
+			name: "default alias of an expression",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id", "lower(users.name)"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+			},
+			wantErr: "invalid result field alias",
+		},
+		{
+			name: "embed name with quotes",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+					JoinType:        ApiTypes.JoinTypeJoin,
+					SelectedFields:  []string{"orders.amount"},
+					JoinedFieldDefs: ordersFieldDefs,
+					EmbedName:       "o\" --",
+				}},
+			},
+			wantErr: "invalid result field alias",
+		},
+		{
+			name: "wildcard with an invalid field def",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  []ApiTypes.FieldDef{{FieldName: "id, password", DataType: "string"}},
+				FieldNames: []string{"*"},
+			},
+			wantErr: "invalid field name",
+		},
+		{
+			name: "missing table name",
+			req: ApiTypes.QueryRequest{
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+			},
+			wantErr: "missing table name",
+		},
+		{
+			name: "missing selected fields",
+			req: ApiTypes.QueryRequest{
+				TableName: "users",
+				FieldDefs: usersFieldDefs,
+			},
+			wantErr: "missing selected fields",
+		},
+		{
+			name: "missing field defs",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldNames: []string{"users.id"},
+			},
+			wantErr: "missing field_defs",
+		},
+		{
+			name: "condition on an unknown field",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  atomicCond("password", "string", Equal, "x"),
+			},
+			wantErr: "invalid field name: password",
+		},
+		{
+			name: "invalid join type",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+					JoinType:        "cross_join",
+				}},
+			},
+			wantErr: "invalid join type",
+		},
+		{
+			name: "injected join operator",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause: []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id",
+						JoinOpr: "= orders.user_id OR 1=1 --"}},
+					JoinType: ApiTypes.JoinTypeJoin,
+				}},
+			},
+			wantErr: "invalid join operator",
+		},
+		{
+			name: "injected join field",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "orders",
+					OnClause: []ApiTypes.OnClauseDef{{SourceFieldName: "id",
+						JoinedFieldName: "user_id; DROP TABLE users"}},
+					JoinType: ApiTypes.JoinTypeJoin,
+				}},
+			},
+			wantErr: "invalid join field",
+		},
+		{
+			name: "too many joins",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: func() []ApiTypes.JoinDef {
+					var joins []ApiTypes.JoinDef
+					for i := 0; i <= defaultMaxJoins; i++ {
+						joins = append(joins, ApiTypes.JoinDef{
+							FromTableName:   "users",
+							JoinedTableName: fmt.Sprintf("t%d", i),
+							OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+							JoinType:        ApiTypes.JoinTypeLeftJoin,
+						})
+					}
+					return joins
+				}(),
+			},
+			wantErr: "join plan rejected: more than 4 joins",
+		},
+		{
+			name: "self join",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: []ApiTypes.JoinDef{{
+					FromTableName:   "users",
+					JoinedTableName: "users",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "id"}},
+					JoinType:        ApiTypes.JoinTypeJoin,
+				}},
+			},
+			wantErr: "table users is joined more than once",
+		},
+		{
+			name: "embed as array",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.name", "users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs:   []ApiTypes.JoinDef{arrayEmbedJoinDef("users.id")},
+			},
+			wantSQL: "SELECT users.name, users.id, " +
+				"COALESCE(json_agg(json_build_object('id', orders.id, 'amount', orders.amount)) " +
+				"FILTER (WHERE orders.user_id IS NOT NULL), '[]') " +
+				"FROM users LEFT JOIN orders ON users.id = orders.user_id GROUP BY users.id, users.name",
+			wantFields:  []string{"users.name", "users.id", "orders.id", "orders.amount"},
+			wantAliases: []string{"name", "id", "orders____id", "orders____amount"},
+		},
+		{
+			name: "embed as array without group by",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs:   []ApiTypes.JoinDef{arrayEmbedJoinDef()},
+			},
+			wantErr: "embed_as_array needs group_by",
+		},
+		{
+			name: "group by a field not selected",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.name"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs:   []ApiTypes.JoinDef{arrayEmbedJoinDef("users.id")},
+			},
+			wantErr: `group_by "users.id" is not a selected field of the parent rows`,
+		},
+		{
+			name: "group by an embedded field",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs:   []ApiTypes.JoinDef{arrayEmbedJoinDef("orders.id")},
+			},
+			wantErr: `group_by "orders.id" is not a selected field of the parent rows`,
+		},
+		{
+			name: "join from an array embed",
+			req: ApiTypes.QueryRequest{
+				TableName:  "users",
+				FieldDefs:  usersFieldDefs,
+				FieldNames: []string{"users.id"},
+				Condition:  ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull},
+				JoinDefs: []ApiTypes.JoinDef{arrayEmbedJoinDef("users.id"), {
+					FromTableName:   "orders",
+					JoinedTableName: "items",
+					OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "order_id"}},
+					JoinType:        ApiTypes.JoinTypeLeftJoin,
+				}},
+			},
+			wantErr: "table orders is embedded as an array and can't be joined from",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := testharness.NewFakeRequestContext(t, testUser())
+			sql, args, fields, aliases, field_def_map, err := buildQuery(rc, testCtx(), tt.req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if sql != tt.wantSQL {
+				t.Errorf("sql = %q, want %q", sql, tt.wantSQL)
+			}
+			if len(args) != len(tt.wantArgs) || (len(args) > 0 && !reflect.DeepEqual(args, tt.wantArgs)) {
+				t.Errorf("args = %#v, want %#v", args, tt.wantArgs)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("selected fields = %q, want %q", fields, tt.wantFields)
+			}
+			if !reflect.DeepEqual(aliases, tt.wantAliases) {
+				t.Errorf("aliases = %q, want %q", aliases, tt.wantAliases)
+			}
+			if _, ok := field_def_map[tt.req.TableName]; !ok {
+				t.Errorf("field def map misses %s", tt.req.TableName)
+			}
+		})
+	}
+}
+
+// arrayEmbedJoinDef returns a LEFT JOIN of the orders of users embedded
+// as an array, grouped by 'group_by'
+func arrayEmbedJoinDef(group_by ...string) ApiTypes.JoinDef {
+	return ApiTypes.JoinDef{
+		FromTableName:   "users",
+		JoinedTableName: "orders",
+		OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+		JoinType:        ApiTypes.JoinTypeLeftJoin,
+		SelectedFields:  []string{"orders.id", "orders.amount"},
+		JoinedFieldDefs: ordersFieldDefs,
+		EmbedName:       "orders",
+		EmbedAsArray:    true,
+		GroupBy:         group_by,
+	}
+}
+
+// TestBuildQueryReservedWords checks that table and field names that are
+// reserved words are quoted the way each backend expects.
+func TestBuildQueryReservedWords(t *testing.T) {
+	userDefs := []ApiTypes.FieldDef{
+		{FieldName: "id", DataType: "int"},
+		{FieldName: "order", DataType: "int"},
+		{FieldName: "group", DataType: "string"},
+	}
+	req := ApiTypes.QueryRequest{
+		TableName:  "user",
+		FieldDefs:  userDefs,
+		FieldNames: []string{"user.id", "user.order"},
+		Condition:  atomicCond("order", "int", Equal, 5),
+		OrderbyDef: []ApiTypes.OrderbyDef{{FieldName: "user.group", IsAsc: true}},
+		JoinDefs: []ApiTypes.JoinDef{{
+			FromTableName:   "user",
+			JoinedTableName: "orders",
+			OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user"}},
+			JoinType:        ApiTypes.JoinTypeJoin,
+			SelectedFields:  []string{"orders.amount"},
+			JoinedFieldDefs: ordersFieldDefs,
+		}},
+	}
+
+	tests := []struct {
+		db_type string
+		wantSQL string
+	}{
+		{
+			db_type: ApiTypes.PgName,
+			wantSQL: `SELECT "user".id, "user"."order", orders.amount FROM "user" ` +
+				`JOIN orders ON "user".id = orders."user" WHERE "order" = $1`,
+		},
+		{
+			db_type: ApiTypes.MysqlName,
+			wantSQL: "SELECT `user`.id, `user`.`order`, orders.amount FROM `user` " +
+				"JOIN orders ON `user`.id = orders.`user` WHERE `order` = $1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.db_type, func(t *testing.T) {
+			saved := ApiTypes.DBType
+			ApiTypes.DBType = tt.db_type
+			defer func() { ApiTypes.DBType = saved }()
+
+			rc := testharness.NewFakeRequestContext(t, testUser())
+			sql, args, fields, aliases, field_def_map, err := buildQuery(rc, testCtx(), req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("sql = %q, want %q", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, []interface{}{5}) {
+				t.Errorf("args = %#v, want [5]", args)
+			}
+			wantFields := []string{"user.id", "user.order", "orders.amount"}
+			if !reflect.DeepEqual(fields, wantFields) {
+				t.Errorf("selected fields = %q, want %q", fields, wantFields)
+			}
+
+			orderby, err := orderByClause(req.OrderbyDef, fields, aliases, field_def_map)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "ORDER BY " + quoteQualified("user.group") + " ASC"; orderby != want {
+				t.Errorf("order by = %q, want %q", orderby, want)
+			}
+		})
+	}
+}
+
+func TestOrderByClause(t *testing.T) {
+	got, err := orderByClause([]ApiTypes.OrderbyDef{{FieldName: "name", IsAsc: true}, {FieldName: "users.id"}}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ORDER BY name ASC, users.id DESC"; got != want {
+		t.Errorf("order by = %q, want %q", got, want)
+	}
+
+	for _, field := range []string{"name; DROP TABLE users", "lower(name)", "users.", ""} {
+		if _, err := orderByClause([]ApiTypes.OrderbyDef{{FieldName: field}}, nil, nil, nil); !errors.Is(err, errBadOrderBy) {
+			t.Errorf("order by %q: error = %v, want errBadOrderBy", field, err)
+		}
+	}
+
+	// Aliases sort on their field, unless they are also another column
+	fields := []string{"users.id", "users.name", "orders.amount", "orders.id"}
+	aliases := []string{"id", "name", "amount", "email"}
+	field_def_map := map[string][]ApiTypes.FieldDef{"users": usersFieldDefs, "orders": ordersFieldDefs}
+	got, err = orderByClause([]ApiTypes.OrderbyDef{{FieldName: "amount"}, {FieldName: "name", IsAsc: true},
+		{FieldName: "orders.user_id"}}, fields, aliases, field_def_map)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ORDER BY amount DESC, name ASC, orders.user_id DESC"; got != want {
+		t.Errorf("order by = %q, want %q", got, want)
+	}
+	for _, field := range []string{"id", "email"} {
+		_, err := orderByClause([]ApiTypes.OrderbyDef{{FieldName: field}}, fields, aliases, field_def_map)
+		if field == "id" && err != nil {
+			t.Errorf("order by id: %v", err)
+		}
+		if field == "email" && (!errors.Is(err, errBadOrderBy) || !strings.Contains(err.Error(), "ambiguous")) {
+			t.Errorf("order by email: error = %v, want ambiguous", err)
+		}
+	}
+
+	// Other names must be fields of the query's tables, and an unqualified
+	// one of a single table
+	for field, want := range map[string]string{
+		"orders.secret":  "not a field",
+		"payments.id":    "not a field",
+		"secret":         "not a field",
+		"created_at":     "",
+		"users.email":    "",
+		"orders.user_id": "",
+	} {
+		_, err := orderByClause([]ApiTypes.OrderbyDef{{FieldName: field}}, fields, aliases, field_def_map)
+		if want == "" && err != nil {
+			t.Errorf("order by %s: %v", field, err)
+		}
+		if want != "" && (!errors.Is(err, errBadOrderBy) || !strings.Contains(err.Error(), want)) {
+			t.Errorf("order by %s: error = %v, want %q", field, err, want)
+		}
+	}
+	_, err = orderByClause([]ApiTypes.OrderbyDef{{FieldName: "id"}}, nil, nil, field_def_map)
+	if !errors.Is(err, errBadOrderBy) || !strings.Contains(err.Error(), "ambiguous, it is a column of orders and users") {
+		t.Errorf("order by id without alias: error = %v, want ambiguous", err)
+	}
+}
@@ -0,0 +1,367 @@
+package RequestHandlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/attachments"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+// ListAttachments handles
+// GET /shared_api/v1/attachments?owner_table=<table>&owner_id=<id>
+func ListAttachments(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	log := rc.GetLogger()
+
+	userInfo := rc.IsAuthenticated()
+	if userInfo == nil {
+		return http.StatusUnauthorized, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Authentication required",
+			Loc:      "SHD_ATH_029",
+		})
+	}
+
+	owner_table := rc.QueryParam("owner_table")
+	owner_id := rc.QueryParam("owner_id")
+	if owner_table == "" || owner_id == "" {
+		return http.StatusBadRequest, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Query parameters 'owner_table' and 'owner_id' are required",
+			Loc:      "SHD_ATH_038",
+		})
+	}
+
+	if !attachments.CanAccess(rc, userInfo, owner_table, owner_id, ApiTypes.AttachmentAccess_Read) {
+		return http.StatusForbidden, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Access denied",
+			Loc:      "SHD_ATH_046",
+		})
+	}
+
+	list, err := sysdatastores.ListAttachmentsByOwner(rc, owner_table, owner_id)
+	if err != nil {
+		log.Error("failed to list attachments", "error", err,
+			"owner_table", owner_table, "owner_id", owner_id)
+		return http.StatusInternalServerError, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Failed to list attachments",
+			Loc:      "SHD_ATH_056",
+		})
+	}
+
+	return http.StatusOK, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+		Status:     true,
+		ResultType: "json_array",
+		NumRecords: len(list),
+		Results:    list,
+		Loc:        "SHD_ATH_064",
+	})
+}
+
+// UploadAttachment handles POST /shared_api/v1/attachments
+// (multipart/form-data with owner_table, owner_id and file)
+func UploadAttachment(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	log := rc.GetLogger()
+
+	userInfo := rc.IsAuthenticated()
+	if userInfo == nil {
+		return http.StatusUnauthorized, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Authentication required",
+			Loc:      "SHD_ATH_080",
+		})
+	}
+
+	svc := attachments.GetService()
+	if svc == nil {
+		return http.StatusServiceUnavailable, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Attachment service not enabled",
+			Loc:      "SHD_ATH_089",
+		})
+	}
+
+	// The service enforces the exact limit; this only bounds the body
+	// (plus room for the multipart envelope).
+	req := rc.GetRequest()
+	req.Body = http.MaxBytesReader(nil, req.Body, svc.MaxSize()+(1<<20))
+	if err := req.ParseMultipartForm(8 << 20); err != nil {
+		var max_bytes_err *http.MaxBytesError
+		if errors.As(err, &max_bytes_err) {
+			return http.StatusRequestEntityTooLarge, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+				Status:   false,
+				ErrorMsg: "Attachment too large",
+				Loc:      "SHD_ATH_102",
+			})
+		}
+		log.Error("failed to parse multipart form", "error", err)
+		return http.StatusBadRequest, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Failed to parse form data",
+			Loc:      "SHD_ATH_108",
+		})
+	}
+	if req.MultipartForm != nil {
+		defer req.MultipartForm.RemoveAll()
+	}
+
+	owner_table := rc.FormValue("owner_table")
+	owner_id := rc.FormValue("owner_id")
+	if owner_table == "" || owner_id == "" {
+		return http.StatusBadRequest, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "'owner_table' and 'owner_id' are required",
+			Loc:      "SHD_ATH_120",
+		})
+	}
+
+	if !attachments.CanAccess(rc, userInfo, owner_table, owner_id, ApiTypes.AttachmentAccess_Write) {
+		return http.StatusForbidden, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Access denied",
+			Loc:      "SHD_ATH_128",
+		})
+	}
+
+	file, header, err := req.FormFile("file")
+	if err != nil {
+		return http.StatusBadRequest, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "File is required",
+			Loc:      "SHD_ATH_136",
+		})
+	}
+	defer file.Close()
+
+	def, err := svc.Upload(rc, owner_table, owner_id, header.Filename,
+		header.Header.Get("Content-Type"), file, userInfo.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, attachments.ErrTooLarge):
+			return http.StatusRequestEntityTooLarge, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+				Status:   false,
+				ErrorMsg: err.Error(),
+				Loc:      "SHD_ATH_148",
+			})
+		case errors.Is(err, attachments.ErrMimeNotAllowed):
+			return http.StatusUnsupportedMediaType, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+				Status:   false,
+				ErrorMsg: err.Error(),
+				Loc:      "SHD_ATH_154",
+			})
+		}
+		log.Error("failed to upload attachment", "error", err,
+			"owner_table", owner_table, "owner_id", owner_id)
+		return http.StatusInternalServerError, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Failed to save attachment",
+			Loc:      "SHD_ATH_162",
+		})
+	}
+
+	return http.StatusOK, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+		Status:     true,
+		ResultType: "json",
+		NumRecords: 1,
+		Results:    def,
+		Loc:        "SHD_ATH_171",
+	})
+}
+
+// getAttachmentForRequest loads the attachment :id and checks that the
+// caller has 'access' to it. On failure it returns nil and the status code
+// and response to send.
+func getAttachmentForRequest(
+	rc ApiTypes.RequestContext,
+	access string) (*ApiTypes.AttachmentDef, int, ApiTypes.ResponsePayload) {
+	userInfo := rc.IsAuthenticated()
+	if userInfo == nil {
+		return nil, http.StatusUnauthorized, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Authentication required",
+			Loc:      "SHD_ATH_186",
+		})
+	}
+
+	id := rc.PathParam("id")
+	def, err := sysdatastores.GetAttachmentByID(rc, id)
+	if err != nil {
+		rc.GetLogger().Error("failed to get attachment", "error", err, "id", id)
+		return nil, http.StatusInternalServerError, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Failed to get attachment",
+			Loc:      "SHD_ATH_196",
+		})
+	}
+
+	// Report a missing attachment and a forbidden one the same way, so
+	// ids of other records cannot be probed.
+	if def == nil || !attachments.CanAccess(rc, userInfo, def.OwnerTable, def.OwnerID, access) {
+		return nil, http.StatusNotFound, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Attachment not found",
+			Loc:      "SHD_ATH_206",
+		})
+	}
+	return def, http.StatusOK, ApiTypes.ResponsePayload{}
+}
+
+// GetAttachment handles GET /shared_api/v1/attachments/:id
+func GetAttachment(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	def, status_code, resp := getAttachmentForRequest(rc, ApiTypes.AttachmentAccess_Read)
+	if def == nil {
+		return status_code, resp
+	}
+
+	return http.StatusOK, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+		Status:     true,
+		ResultType: "json",
+		NumRecords: 1,
+		Results:    def,
+		Loc:        "SHD_ATH_227",
+	})
+}
+
+// DownloadAttachment handles GET /shared_api/v1/attachments/:id/download
+// It supports single byte ranges (Range: bytes=a-b).
+func DownloadAttachment(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	log := rc.GetLogger()
+
+	def, status_code, resp := getAttachmentForRequest(rc, ApiTypes.AttachmentAccess_Read)
+	if def == nil {
+		return status_code, resp
+	}
+
+	svc := attachments.GetService()
+	if svc == nil {
+		return http.StatusServiceUnavailable, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Attachment service not enabled",
+			Loc:      "SHD_ATH_248",
+		})
+	}
+
+	h := http.Header{}
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("ETag", `"`+def.SHA256+`"`)
+
+	offset, length, partial, err := attachments.ParseRange(rc.GetRequest().Header.Get("Range"), def.Size)
+	if err != nil {
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", def.Size))
+		return http.StatusRequestedRangeNotSatisfiable, ApiTypes.StreamPayload(h, nil)
+	}
+
+	read_length := int64(-1)
+	if partial {
+		read_length = length
+	}
+	reader, err := svc.Open(rc, def, offset, read_length)
+	if err != nil {
+		log.Error("failed to open attachment", "error", err, "id", def.ID)
+		return http.StatusInternalServerError, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Failed to read attachment",
+			Loc:      "SHD_ATH_268",
+		})
+	}
+
+	h.Set("Content-Type", def.MimeType)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": def.FileName}))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	status := http.StatusOK
+	if partial {
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, def.Size))
+		status = http.StatusPartialContent
+	}
+	return status, ApiTypes.StreamPayload(h, reader)
+}
+
+// DeleteAttachment handles DELETE /shared_api/v1/attachments/:id
+func DeleteAttachment(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	log := rc.GetLogger()
+
+	def, status_code, resp := getAttachmentForRequest(rc, ApiTypes.AttachmentAccess_Delete)
+	if def == nil {
+		return status_code, resp
+	}
+
+	svc := attachments.GetService()
+	if svc == nil {
+		return http.StatusServiceUnavailable, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Attachment service not enabled",
+			Loc:      "SHD_ATH_304",
+		})
+	}
+
+	if err := svc.Delete(rc, def); err != nil {
+		log.Error("failed to delete attachment", "error", err, "id", def.ID)
+		return http.StatusInternalServerError, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Failed to delete attachment",
+			Loc:      "SHD_ATH_313",
+		})
+	}
+
+	return http.StatusOK, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+		Status:     true,
+		ResultType: "json",
+		Results:    map[string]string{"deleted_id": def.ID},
+		Loc:        "SHD_ATH_321",
+	})
+}
+
+// SweepAttachments handles POST /shared_api/v1/attachments/sweep?dry_run=true
+// It runs the orphan sweep now (admin only).
+func SweepAttachments(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	log := rc.GetLogger()
+
+	userInfo := rc.IsAuthenticated()
+	if userInfo == nil {
+		return http.StatusUnauthorized, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Authentication required",
+			Loc:      "SHD_ATH_337",
+		})
+	}
+	if !userInfo.Admin {
+		return http.StatusForbidden, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Admin access required",
+			Loc:      "SHD_ATH_344",
+		})
+	}
+
+	dry_run := rc.QueryParam("dry_run") == "true"
+	result, err := attachments.RunSweep(rc, dry_run)
+	if err != nil {
+		log.Error("attachment sweep failed", "error", err)
+		return http.StatusInternalServerError, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Attachment sweep failed: " + err.Error(),
+			Loc:      "SHD_ATH_355",
+		})
+	}
+	if result == nil {
+		return http.StatusConflict, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "A sweep is already running",
+			Loc:      "SHD_ATH_362",
+		})
+	}
+
+	return http.StatusOK, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+		Status:     true,
+		ResultType: "json",
+		NumRecords: 1,
+		Results:    result,
+		Loc:        "SHD_ATH_371",
+	})
+}
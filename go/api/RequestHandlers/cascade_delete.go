@@ -0,0 +1,360 @@
+package RequestHandlers
+
+// Cascading deletes remove, in one transaction, the child rows of the
+// parent rows a Jimo delete selects, then the parents themselves. The
+// parent keys are selected (and locked) first; each child is deleted with
+// 'fk_field IN (...)' in batches, in the order the request lists them.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+// CascadeConfig configures the cascading deletes of HandleDBDelete
+type CascadeConfig struct {
+	// MaxAffectedRows caps the rows one cascading delete may remove,
+	// children and parents together. Going over it rolls the whole
+	// delete back. 0 means unlimited.
+	MaxAffectedRows int64
+	// BatchSize is the number of parent keys per child DELETE
+	BatchSize int
+}
+
+// DefaultCascadeConfig returns the cascade config used unless
+// SetCascadeConfig is called.
+func DefaultCascadeConfig() CascadeConfig {
+	return CascadeConfig{
+		MaxAffectedRows: 10000,
+		BatchSize:       500,
+	}
+}
+
+var (
+	cascade_mu     sync.RWMutex
+	cascade_config = DefaultCascadeConfig()
+)
+
+// SetCascadeConfig replaces the cascade config of the Jimo delete handler.
+func SetCascadeConfig(config CascadeConfig) {
+	cascade_mu.Lock()
+	defer cascade_mu.Unlock()
+	cascade_config = config
+}
+
+func getCascadeConfig() CascadeConfig {
+	cascade_mu.RLock()
+	defer cascade_mu.RUnlock()
+	config := cascade_config
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultCascadeConfig().BatchSize
+	}
+	return config
+}
+
+// errCascadeLimit is returned when a cascading delete would remove more
+// rows than CascadeConfig.MaxAffectedRows.
+var errCascadeLimit = errors.New("cascade delete row limit exceeded")
+
+// validateCascade checks the cascade of a delete on 'table_name'. Table
+// and field names are interpolated into the statements, so each must be
+// a safe identifier and a field of the supplied field defs.
+func validateCascade(
+	table_name string,
+	field_map map[string]bool,
+	cascade []ApiTypes.CascadeDef) error {
+	if !isValidSQLIdentifier(table_name) {
+		return fmt.Errorf("invalid table name:%s (SHD_CSD_075)", table_name)
+	}
+
+	for i, cd := range cascade {
+		if !isValidSQLIdentifier(cd.Table) {
+			return fmt.Errorf("cascade[%d]: invalid table name:%s (SHD_CSD_080)", i, cd.Table)
+		}
+		if cd.Table == table_name {
+			return fmt.Errorf("cascade[%d]: child table cannot be the parent table:%s (SHD_CSD_083)", i, cd.Table)
+		}
+		if !isValidSQLIdentifier(cd.ParentField) || !field_map[cd.ParentField] {
+			return fmt.Errorf("cascade[%d]: parent_field %s is not a field of %s (SHD_CSD_086)",
+				i, cd.ParentField, table_name)
+		}
+		if len(cd.FieldDefs) == 0 {
+			return fmt.Errorf("cascade[%d]: missing field_defs of %s (SHD_CSD_090)", i, cd.Table)
+		}
+
+		found := false
+		for _, fd := range cd.FieldDefs {
+			if fd.FieldName == cd.FKField {
+				found = true
+				break
+			}
+		}
+		if !isValidSQLIdentifier(cd.FKField) || !found {
+			return fmt.Errorf("cascade[%d]: fk_field %s is not a field of %s (SHD_CSD_101)",
+				i, cd.FKField, cd.Table)
+		}
+	}
+	return nil
+}
+
+// runCascadeDelete deletes the children listed in 'cascade' and then the
+// rows of 'table_name' matching 'expr' (all of them if nil), all in one
+// transaction. If 'expected_max' is not 0, more parent rows than that
+// roll it back (see checkDeleteCount). It returns the rows deleted per
+// table.
+func runCascadeDelete(
+	ctx context.Context,
+	db *sql.DB,
+	table_name string,
+	expr sq.Sqlizer,
+	cascade []ApiTypes.CascadeDef,
+	expected_max int) (map[string]int64, error) {
+	config := getCascadeConfig()
+
+	var deleted map[string]int64
+	err := databaseutil.WithWriteRetry(ctx, db, func(ctx context.Context) error {
+		deleted = make(map[string]int64)
+		var total int64
+		count := func(table string, n int64) error {
+			deleted[table] += n
+			total += n
+			if config.MaxAffectedRows > 0 && total > config.MaxAffectedRows {
+				return fmt.Errorf("%w: more than %d rows, table:%s (SHD_CSD_129)",
+					errCascadeLimit, config.MaxAffectedRows, table)
+			}
+			return nil
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := checkDeleteCount(ctx, tx, table_name, expr, expected_max); err != nil {
+			return err
+		}
+
+		parent_keys, err := selectParentKeys(ctx, tx, table_name, expr, cascade)
+		if err != nil {
+			return err
+		}
+
+		for _, cd := range cascade {
+			keys := parent_keys[cd.ParentField]
+			for start := 0; start < len(keys); start += config.BatchSize {
+				end := start + config.BatchSize
+				if end > len(keys) {
+					end = len(keys)
+				}
+
+				stmt, args, err := sq.Delete(quoteIdent(cd.Table)).
+					Where(sq.Eq{quoteIdent(cd.FKField): keys[start:end]}).
+					PlaceholderFormat(placeholderFormat()).
+					ToSql()
+				if err != nil {
+					return fmt.Errorf("failed building delete of %s: %w (SHD_CSD_156)", cd.Table, err)
+				}
+
+				result, err := tx.ExecContext(ctx, stmt, args...)
+				if err != nil {
+					return fmt.Errorf("failed deleting from %s: %w (SHD_CSD_161)", cd.Table, err)
+				}
+				n, _ := result.RowsAffected()
+				if err := count(cd.Table, n); err != nil {
+					return err
+				}
+			}
+		}
+
+		parent_delete := sq.Delete(quoteIdent(table_name)).PlaceholderFormat(placeholderFormat())
+		if expr != nil {
+			parent_delete = parent_delete.Where(expr)
+		}
+		stmt, args, err := parent_delete.ToSql()
+		if err != nil {
+			return fmt.Errorf("failed building delete of %s: %w (SHD_CSD_172)", table_name, err)
+		}
+		result, err := tx.ExecContext(ctx, stmt, args...)
+		if err != nil {
+			return fmt.Errorf("failed deleting from %s: %w (SHD_CSD_176)", table_name, err)
+		}
+		n, _ := result.RowsAffected()
+		if err := count(table_name, n); err != nil {
+			return err
+		}
+		if err := checkDeletedRows(n, expected_max); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// selectParentKeys returns, per parent field named in 'cascade', the
+// distinct non-null values of the rows matching 'expr'. The rows are
+// locked so no child can be added to them before the commit.
+func selectParentKeys(
+	ctx context.Context,
+	tx *sql.Tx,
+	table_name string,
+	expr sq.Sqlizer,
+	cascade []ApiTypes.CascadeDef) (map[string][]interface{}, error) {
+	var fields []string
+	seen_field := make(map[string]bool)
+	for _, cd := range cascade {
+		if !seen_field[cd.ParentField] {
+			seen_field[cd.ParentField] = true
+			fields = append(fields, cd.ParentField)
+		}
+	}
+
+	quoted_fields := make([]string, len(fields))
+	for i, field := range fields {
+		quoted_fields[i] = quoteIdent(field)
+	}
+	stmt, args, err := sq.Select(quoted_fields...).
+		From(quoteIdent(table_name)).
+		Where(expr).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(placeholderFormat()).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed building parent select: %w (SHD_CSD_216)", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed selecting parent keys of %s: %w (SHD_CSD_221)", table_name, err)
+	}
+	defer rows.Close()
+
+	// The columns come back in the order of 'fields'; their names may
+	// differ in case (PG folds unquoted names)
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading parent key columns: %w (SHD_CSD_233)", err)
+	}
+	// Without column types, text columns (scanned as []byte, which is
+	// neither comparable nor bound back as text) come back as strings
+	records, err := databaseutil.ScanRows(rows, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed scanning parent keys: %w (SHD_CSD_238)", err)
+	}
+
+	keys := make(map[string][]interface{}, len(fields))
+	seen_key := make([]map[interface{}]bool, len(fields))
+	for i := range fields {
+		seen_key[i] = make(map[interface{}]bool)
+	}
+	for _, record := range records {
+		for i, field := range fields {
+			value := record[columns[i]]
+			if value == nil {
+				continue
+			}
+			if !seen_key[i][value] {
+				seen_key[i][value] = true
+				keys[field] = append(keys[field], value)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// handleCascadeDelete runs a delete that has a cascade. 'expr' is the
+// parent condition, already built and checked by HandleDBDelete.
+func handleCascadeDelete(
+	ctx context.Context,
+	rc ApiTypes.RequestContext,
+	req ApiTypes.DeleteRequest,
+	field_map map[string]bool,
+	expr sq.Sqlizer,
+	db *sql.DB,
+	user_name string) (int, ApiTypes.JimoResponse) {
+	logger := rc.GetLogger()
+	call_flow := ctx.Value(ApiTypes.CallFlowKey).(string)
+	reqID := rc.ReqID()
+	table_name := req.TableName
+
+	if err := validateCascade(table_name, field_map, req.Cascade); err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_CSD_276", call_flow)
+		logger.Error("invalid cascade", "table_name", table_name, "error", err)
+		return ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  err.Error(),
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			TableName: table_name,
+			ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:       new_call_flow,
+		}
+	}
+
+	// The parent table was counted by handleJimoRequestPriv; every child
+	// table is a delete on that table too.
+	counted := map[string]bool{table_name: true}
+	for _, cd := range req.Cascade {
+		if counted[cd.Table] {
+			continue
+		}
+		counted[cd.Table] = true
+		if breach := quotas.Acquire(user_name, cd.Table, ApiTypes.ReqAction_Delete); breach != nil {
+			return quotaExceededResponse(rc, breach, call_flow)
+		}
+	}
+
+	deleted, err := runCascadeDelete(ctx, db, table_name, expr, req.Cascade, req.ExpectedMaxRows)
+	if err != nil {
+		new_call_flow := fmt.Sprintf("%s->SHD_CSD_302", call_flow)
+		var too_many *tooManyDeletesError
+		if errors.As(err, &too_many) {
+			logger.Warn("HandleJimoRequest", "error", err, "table_name", table_name, "loc", new_call_flow)
+			return tooManyDeletesResponse(reqID, table_name, too_many, new_call_flow)
+		}
+		error_msg := fmt.Sprintf("cascade delete failed, rolled back: %v", err)
+		logger.Error("HandleJimoRequest", "error_msg", error_msg)
+
+		status_code := dbErrorStatus(err, ApiTypes.CustomHttpStatus_InternalError)
+		error_kind := dbErrorKind(err)
+		if errors.Is(err, errCascadeLimit) {
+			status_code = ApiTypes.CustomHttpStatus_BadRequest
+			error_kind = ApiTypes.ErrorKind_InvalidRequest
+		}
+		return status_code, ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: error_kind,
+			TableName: table_name,
+			ErrorCode: status_code,
+			Loc:       new_call_flow,
+		}
+	}
+
+	logger.Info("cascade delete", "table_name", table_name, "deleted", deleted)
+	new_call_flow := fmt.Sprintf("%s->SHD_CSD_321", call_flow)
+	return ApiTypes.CustomHttpStatus_Success, ApiTypes.JimoResponse{
+		Status:     true,
+		ReqID:      reqID,
+		ResultType: "json",
+		NumRecords: 1,
+		TableName:  table_name,
+		Results: map[string]interface{}{
+			"rows_affected": deleted[table_name],
+			"deleted":       deleted,
+		},
+		Loc: new_call_flow,
+	}
+}
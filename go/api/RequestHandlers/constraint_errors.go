@@ -0,0 +1,156 @@
+package RequestHandlers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// constraintViolation is a unique or foreign key violation of an insert
+// or update, as far as the driver error tells.
+type constraintViolation struct {
+	unique     bool   // true for a unique violation, false for a foreign key one
+	column     string // the conflicting column(s), or the constraint if unknown
+	value      string // the conflicting value, if known
+	ref_table  string // the referenced table of a foreign key, if known
+	constraint string
+}
+
+var (
+	// PG details: `Key (email)=(a@b.com) already exists.` and
+	// `Key (dept_id)=(5) is not present in table "depts".`
+	pgKeyDetail = regexp.MustCompile(`^Key \((.+?)\)=\((.*)\) (?:already exists|is not present in table "([^"]+)")`)
+
+	// MySQL 1062: `Duplicate entry 'a@b.com' for key 'users.idx_email'`
+	mysqlDuplicate = regexp.MustCompile(`^Duplicate entry '(.*)' for key '([^']+)'`)
+
+	// MySQL 1452: `... FOREIGN KEY (`dept_id`) REFERENCES `depts` (`id`))`
+	mysqlForeignKey = regexp.MustCompile("FOREIGN KEY \\(`([^`]+)`\\) REFERENCES `([^`]+)`")
+)
+
+// classifyConstraintError returns the unique or foreign key violation that
+// 'err' reports (PG 23505/23503, MySQL 1062/1452), or nil for any other
+// error.
+func classifyConstraintError(err error) *constraintViolation {
+	var pq_err *pq.Error
+	if errors.As(err, &pq_err) {
+		if pq_err.Code != "23505" && pq_err.Code != "23503" {
+			return nil
+		}
+		v := &constraintViolation{
+			unique:     pq_err.Code == "23505",
+			column:     pq_err.Column,
+			constraint: pq_err.Constraint,
+		}
+		if m := pgKeyDetail.FindStringSubmatch(pq_err.Detail); m != nil {
+			v.column, v.value, v.ref_table = m[1], m[2], m[3]
+		}
+		if v.column == "" {
+			v.column = v.constraint
+		}
+		return v
+	}
+
+	var mysql_err *mysql.MySQLError
+	if errors.As(err, &mysql_err) {
+		switch mysql_err.Number {
+		case 1062:
+			v := &constraintViolation{unique: true}
+			if m := mysqlDuplicate.FindStringSubmatch(mysql_err.Message); m != nil {
+				// MySQL 8 qualifies the key with the table name
+				v.value, v.constraint = m[1], m[2][strings.LastIndex(m[2], ".")+1:]
+				v.column = v.constraint
+			}
+			return v
+
+		case 1452:
+			v := &constraintViolation{}
+			if m := mysqlForeignKey.FindStringSubmatch(mysql_err.Message); m != nil {
+				v.column, v.ref_table = m[1], m[2]
+			}
+			return v
+		}
+	}
+	return nil
+}
+
+// fieldProblem returns the problem of the violation 'v' for the response.
+// MySQL names the key rather than the column of a unique violation: if
+// the key is on one of 'field_defs', that field is named instead.
+// 'records' are the inserted records, to find which one holds the
+// conflicting value; the index is -1 if none or several do.
+func (v *constraintViolation) fieldProblem(
+	field_defs []ApiTypes.FieldDef,
+	records []map[string]interface{}) ApiTypes.FieldProblem {
+	column := v.column
+	for _, f := range field_defs {
+		if column == f.FieldName || strings.TrimPrefix(column, "idx_") == f.FieldName {
+			column = f.FieldName
+			break
+		}
+	}
+
+	problem := "references a row that does not exist"
+	if v.ref_table != "" {
+		problem = fmt.Sprintf("references a row that does not exist in %s", v.ref_table)
+	}
+	if v.unique {
+		problem = "value already exists"
+		if v.value != "" {
+			problem = fmt.Sprintf("value %s already exists", v.value)
+		}
+	}
+
+	record_index := -1
+	if v.value != "" {
+		for idx, record := range records {
+			if val, ok := record[column]; ok && fmt.Sprint(val) == v.value {
+				if record_index >= 0 {
+					record_index = -1
+					break
+				}
+				record_index = idx
+			}
+		}
+	}
+	return ApiTypes.FieldProblem{RecordIndex: record_index, Field: column, Problem: problem}
+}
+
+// constraintViolationResponse is the response of an insert or update that
+// the database rejected with the violation 'v'. A unique violation is
+// returned with CustomHttpStatus_KeyNotUnique, a foreign key one with
+// CustomHttpStatus_BadRequest; both are validation_failed with the
+// problem in Results, like those of ValidateRecords. The driver error,
+// which shows the statement's values and constraint names, is not
+// included; callers log it.
+func constraintViolationResponse(
+	v *constraintViolation,
+	reqID string,
+	table_name string,
+	field_defs []ApiTypes.FieldDef,
+	records []map[string]interface{},
+	loc string) (int, ApiTypes.JimoResponse) {
+	problem := v.fieldProblem(field_defs, records)
+	status_code := ApiTypes.CustomHttpStatus_BadRequest
+	error_msg := fmt.Sprintf("%s: %s", problem.Field, problem.Problem)
+	if v.unique {
+		status_code = ApiTypes.CustomHttpStatus_KeyNotUnique
+	}
+	return status_code, ApiTypes.JimoResponse{
+		Status:     false,
+		ReqID:      reqID,
+		ErrorMsg:   error_msg,
+		ErrorKind:  ApiTypes.ErrorKind_ValidationFailed,
+		TableName:  table_name,
+		ResultType: "json",
+		NumRecords: 1,
+		Results:    []ApiTypes.FieldProblem{problem},
+		ErrorCode:  status_code,
+		Loc:        loc,
+	}
+}
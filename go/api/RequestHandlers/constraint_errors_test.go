@@ -0,0 +1,87 @@
+package RequestHandlers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestClassifyConstraintError(t *testing.T) {
+	field_defs := []ApiTypes.FieldDef{{FieldName: "email"}, {FieldName: "dept_id"}}
+	records := []map[string]interface{}{
+		{"email": "a@example.com", "dept_id": 5},
+		{"email": "b@example.com", "dept_id": 7},
+	}
+
+	tests := []struct {
+		name   string
+		err    error
+		unique bool
+		want   ApiTypes.FieldProblem
+	}{
+		{
+			name: "pg unique",
+			err: fmt.Errorf("failed run statement: %w", &pq.Error{Code: "23505",
+				Constraint: "users_email_key", Detail: "Key (email)=(b@example.com) already exists."}),
+			unique: true,
+			want:   ApiTypes.FieldProblem{RecordIndex: 1, Field: "email", Problem: "value b@example.com already exists"},
+		},
+		{
+			name: "pg foreign key",
+			err: &pq.Error{Code: "23503", Constraint: "users_dept_id_fkey",
+				Detail: `Key (dept_id)=(5) is not present in table "depts".`},
+			want: ApiTypes.FieldProblem{RecordIndex: 0, Field: "dept_id",
+				Problem: "references a row that does not exist in depts"},
+		},
+		{
+			name:   "pg unique without detail",
+			err:    &pq.Error{Code: "23505", Constraint: "users_email_key"},
+			unique: true,
+			want: ApiTypes.FieldProblem{RecordIndex: -1, Field: "users_email_key",
+				Problem: "value already exists"},
+		},
+		{
+			name: "mysql unique",
+			err: &mysql.MySQLError{Number: 1062,
+				Message: "Duplicate entry 'a@example.com' for key 'users.email'"},
+			unique: true,
+			want:   ApiTypes.FieldProblem{RecordIndex: 0, Field: "email", Problem: "value a@example.com already exists"},
+		},
+		{
+			name: "mysql foreign key",
+			err: &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: " +
+				"a foreign key constraint fails (`db`.`users`, CONSTRAINT `fk_dept` " +
+				"FOREIGN KEY (`dept_id`) REFERENCES `depts` (`id`))"},
+			want: ApiTypes.FieldProblem{RecordIndex: -1, Field: "dept_id",
+				Problem: "references a row that does not exist in depts"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := classifyConstraintError(tt.err)
+			if v == nil {
+				t.Fatal("not classified")
+			}
+			if v.unique != tt.unique {
+				t.Errorf("unique = %v, want %v", v.unique, tt.unique)
+			}
+			if got := v.fieldProblem(field_defs, records); got != tt.want {
+				t.Errorf("problem = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, err := range []error{
+		errors.New("connection refused"),
+		&pq.Error{Code: "42P01"},
+		&mysql.MySQLError{Number: 1146},
+	} {
+		if v := classifyConstraintError(err); v != nil {
+			t.Errorf("classifyConstraintError(%v) = %+v, want nil", err, v)
+		}
+	}
+}
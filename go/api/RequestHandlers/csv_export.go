@@ -0,0 +1,402 @@
+package RequestHandlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/EchoFactory"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+	"github.com/labstack/echo/v4"
+)
+
+// CSV exports run a Jimo query (see HandleDBQuery) and stream its rows as
+// text/csv instead of a JSON array, without holding the results in
+// memory. The header row is the aliases of the selected fields, with
+// embedded fields as <embed>.<alias>. Values are converted as for JSON
+// results, and the field policies apply.
+//
+// An export has no page size by default: all the matching rows are
+// exported, or page_size rows from start if page_size is set. Once the
+// first row is sent, the status can no longer change; an export cut short
+// by an error ends early, and the error is in the X-Jimo-Export-Error
+// trailer. X-Jimo-Export-Rows is the number of rows sent.
+
+// csvFlushRows is how many rows are buffered before they are flushed to
+// the client
+const csvFlushRows = 500
+
+const (
+	csvTrailerRows  = "X-Jimo-Export-Rows"
+	csvTrailerError = "X-Jimo-Export-Error"
+)
+
+// HandleDBExportCSVEcho handles POST /shared_api/v1/jimo_export_csv. The
+// body is an ApiTypes.ExportCSVRequest.
+func HandleDBExportCSVEcho(c echo.Context) error {
+	rc := EchoFactory.NewFromEcho(c, "SHD_RHD_1610")
+	defer rc.Close()
+
+	ctx := c.Request().Context()
+	call_flow := ctx.Value(ApiTypes.CallFlowKey)
+	body, _ := io.ReadAll(c.Request().Body)
+	defer c.Request().Body.Close()
+	new_ctx := context.WithValue(ctx, ApiTypes.CallFlowKey, fmt.Sprintf("%s->SHD_RHD_1611", call_flow))
+
+	status_code, resp := HandleDBExportCSV(new_ctx, rc, c.Response(), body)
+	if resp == nil {
+		// The CSV is sent
+		return nil
+	}
+	if breach, ok := resp.Results.(*ApiTypes.QuotaBreach); ok {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(breach.RetryAfterSec))
+	}
+	return c.JSON(status_code, resp)
+}
+
+// HandleDBExportCSV runs the query of the ExportCSVRequest 'body' and
+// streams its results to 'w' as CSV. It returns the response to send
+// instead if the export is refused or fails before any row is sent, and
+// nil once the CSV is sent.
+func HandleDBExportCSV(
+	ctx context.Context,
+	rc ApiTypes.RequestContext,
+	w http.ResponseWriter,
+	body []byte) (int, *ApiTypes.JimoResponse) {
+	logger := rc.GetLogger()
+	call_flow := ctx.Value(ApiTypes.CallFlowKey).(string)
+	reqID := rc.ReqID()
+	new_ctx := context.WithValue(ctx, ApiTypes.CallFlowKey, fmt.Sprintf("%s->SHD_RHD_1612", call_flow))
+
+	user_info := rc.IsAuthenticated()
+	if user_info == nil {
+		status_code, resp := notAuthenticatedResponse(rc, fmt.Sprintf("%s->SHD_RHD_1613", call_flow))
+		return status_code, &resp
+	}
+
+	fail := func(status_code int, kind ApiTypes.ErrorKind, table_name string, error_msg string, loc string) (int, *ApiTypes.JimoResponse) {
+		logger.Error("HandleDBExportCSV", "error_msg", error_msg)
+		return status_code, &ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			TableName: table_name,
+			ErrorMsg:  error_msg,
+			ErrorKind: kind,
+			ErrorCode: status_code,
+			Loc:       fmt.Sprintf("%s->%s", call_flow, loc),
+		}
+	}
+
+	var req ApiTypes.ExportCSVRequest
+	if err := decodeJimoRequest(body, &req); err != nil {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "",
+			fmt.Sprintf("failed parse export request:%v", err), "SHD_RHD_1614")
+	}
+
+	table_name := req.TableName
+	if table_name == "" {
+		table_name = req.ResourceName
+	}
+	if breach := quotas.Acquire(user_info.UserName, table_name, ApiTypes.ReqAction_Query); breach != nil {
+		status_code, resp := quotaExceededResponse(rc, breach, call_flow)
+		return status_code, &resp
+	}
+
+	if status_code, resp := prepareQueryRequest(new_ctx, rc, &req.QueryRequest, call_flow); resp != nil {
+		return status_code, resp
+	}
+
+	delimiter, err := csvDelimiter(req.Delimiter)
+	if err != nil {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, req.TableName,
+			err.Error(), "SHD_RHD_1615")
+	}
+	if req.Sample > 0 {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, req.TableName,
+			"sample is not supported by CSV exports (SHD_RHD_1616)", "SHD_RHD_1616")
+	}
+	if req.TimeBucket != nil {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, req.TableName,
+			"time_bucket is not supported by CSV exports (SHD_RHD_1710)", "SHD_RHD_1710")
+	}
+	if _, ok := arrayEmbedJoin(req.JoinDefs); ok {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, req.TableName,
+			"embed_as_array is not supported by CSV exports (SHD_RHD_1662)", "SHD_RHD_1662")
+	}
+	if req.PageSize < 0 || req.Start < 0 {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, req.TableName,
+			fmt.Sprintf("invalid limit clause (SHD_RHD_1617), page_size:%d, start:%d", req.PageSize, req.Start),
+			"SHD_RHD_1617")
+	}
+
+	query, args, selected_fields, aliases, field_def_map, err := buildQuery(rc, new_ctx, req.QueryRequest)
+	if err != nil {
+		status_code := ApiTypes.CustomHttpStatus_InternalError
+		if errors.Is(err, errBadJoinPlan) || errors.Is(err, errAliasCollision) ||
+			errors.Is(err, errBadAlias) || errors.Is(err, errBadConditionValue) ||
+			errors.Is(err, errBadSubQuery) || errors.Is(err, errQueryTooLarge) {
+			status_code = ApiTypes.CustomHttpStatus_BadRequest
+		}
+		return fail(status_code, ApiTypes.ErrorKind_InvalidRequest, req.TableName, err.Error(), "SHD_RHD_1618")
+	}
+
+	if err := checkHiddenFieldUse(user_info, req.QueryRequest, selected_fields, aliases); err != nil {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, req.TableName,
+			err.Error(), "SHD_RHD_1732")
+	}
+
+	var db *sql.DB = ApiTypes.ProjectDBHandle
+	if db == nil {
+		return fail(ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_InternalError, req.TableName,
+			fmt.Sprintf("invalid db type:%s, table_name:%s, loc:%s (SHD_RHD_1619)",
+				ApiTypes.DBType, req.TableName, req.Loc), "SHD_RHD_1619")
+	}
+
+	if len(req.OrderbyDef) > 0 {
+		orderby_str, err := orderByClause(req.OrderbyDef, selected_fields, aliases, field_def_map)
+		if err != nil {
+			return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, req.TableName,
+				err.Error(), "SHD_RHD_1597")
+		}
+		query += " " + orderby_str
+	}
+	if req.PageSize > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", req.PageSize, req.Start)
+	}
+
+	converter, err := newValueConverter(req.QueryRequest, field_def_map)
+	if err != nil {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, req.TableName,
+			err.Error(), "SHD_RHD_1620")
+	}
+	columns := csvColumns(user_info, selected_fields, aliases)
+
+	logger.Info("HandleDBExportCSV", "query", query, "args", args, "table_name", req.TableName)
+	rows, err := databaseutil.QueryWithRetry(new_ctx, db, query, args...)
+	if err != nil {
+		log_id := sysdatastores.NextActivityLogID()
+		error_msg := fmt.Sprintf("run query failed, err:%v, logid:%d, table:%s, loc:%s",
+			err, log_id, req.TableName, req.Loc)
+		error_msg1 := fmt.Sprintf("run query failed, err:%v, query:%s, "+
+			"table_name:%s, loc:%s", err, query, req.TableName, req.Loc)
+		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+			LogID:        log_id,
+			ActivityName: ApiTypes.ActivityName_Query,
+			ActivityType: ApiTypes.ActivityType_DatabaseError,
+			AppName:      ApiTypes.AppName_RequestHandler,
+			ModuleName:   ApiTypes.ModuleName_RequestHandler,
+			ActivityMsg:  &error_msg1,
+			CallerLoc:    fmt.Sprintf("%s->SHD_RHD_1621", call_flow)})
+		return fail(dbErrorStatus(err, ApiTypes.CustomHttpStatus_InternalError), dbErrorKind(err),
+			req.TableName, error_msg, "SHD_RHD_1621")
+	}
+	defer rows.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/csv; charset=utf-8")
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": req.TableName + ".csv"}))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Trailer", csvTrailerRows+", "+csvTrailerError)
+	w.WriteHeader(http.StatusOK)
+
+	num_records, err := writeCSVRows(w, rows, delimiter, !req.NoHeader, columns, converter)
+	h.Set(csvTrailerRows, strconv.Itoa(num_records))
+	quotas.RecordRows(user_info.UserName, table_name, int64(num_records))
+	if err != nil {
+		log_id := sysdatastores.NextActivityLogID()
+		error_msg := fmt.Sprintf("CSV export cut short after %d rows, err:%v, logid:%d, table:%s, loc:%s",
+			num_records, err, log_id, req.TableName, req.Loc)
+		logger.Error("HandleDBExportCSV", "error_msg", error_msg)
+		h.Set(csvTrailerError, fmt.Sprintf("export failed, log_id:%d", log_id))
+		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+			LogID:        log_id,
+			ActivityName: ApiTypes.ActivityName_Query,
+			ActivityType: ApiTypes.ActivityType_DatabaseError,
+			AppName:      ApiTypes.AppName_RequestHandler,
+			ModuleName:   ApiTypes.ModuleName_RequestHandler,
+			ActivityMsg:  &error_msg,
+			CallerLoc:    fmt.Sprintf("%s->SHD_RHD_1622", call_flow)})
+		return http.StatusOK, nil
+	}
+
+	msg := fmt.Sprintf("CSV export success, query:%s, num_records:%d, table:%s, loc:%s",
+		query, num_records, req.TableName, req.Loc)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Query,
+		ActivityType: ApiTypes.ActivityType_RequestSuccess,
+		AppName:      ApiTypes.AppName_RequestHandler,
+		ModuleName:   ApiTypes.ModuleName_RequestHandler,
+		ActivityMsg:  &msg,
+		CallerLoc:    fmt.Sprintf("%s->SHD_RHD_1623", call_flow)})
+	return http.StatusOK, nil
+}
+
+// csvDelimiter returns the delimiter 'delimiter' names: one character
+// other than a quote or a line break, or ',' if empty
+func csvDelimiter(delimiter string) (rune, error) {
+	if delimiter == "" {
+		return ',', nil
+	}
+	r, size := utf8.DecodeRuneInString(delimiter)
+	if size != len(delimiter) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid CSV delimiter:%q (SHD_RHD_1624)", delimiter)
+	}
+	return r, nil
+}
+
+// csvColumn is a column of a CSV export
+type csvColumn struct {
+	index     int    // Of the selected field
+	full_name string // <table>.<field>
+	header    string
+	mask      bool
+}
+
+// csvColumns returns the columns of the selected fields 'user_info' may
+// see, in order. Masked fields are kept.
+func csvColumns(user_info *ApiTypes.UserInfo, selected_fields []string, aliases []string) []csvColumn {
+	policies := getFieldPolicies()
+	columns := make([]csvColumn, 0, len(selected_fields))
+	for i, full_name := range selected_fields {
+		column := csvColumn{
+			index:     i,
+			full_name: full_name,
+			header:    strings.Replace(aliases[i], "____", ".", 1),
+		}
+		if policy, hidden := hiddenField(policies, user_info, full_name); hidden {
+			if policy.Action != FieldAction_Mask {
+				continue
+			}
+			column.mask = true
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// writeCSVRows writes 'rows' to 'w' as CSV, flushing every csvFlushRows
+// rows, and returns the number of rows written
+func writeCSVRows(
+	w io.Writer,
+	rows *sql.Rows,
+	delimiter rune,
+	with_header bool,
+	columns []csvColumn,
+	converter *valueConverter) (int, error) {
+	flusher, _ := w.(http.Flusher)
+	cw := csv.NewWriter(w)
+	cw.Comma = delimiter
+	flush := func() error {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	record := make([]string, len(columns))
+	if with_header {
+		for i, column := range columns {
+			record[i] = column.header
+		}
+		if err := cw.Write(record); err != nil {
+			return 0, err
+		}
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	values := make([]interface{}, len(cols))
+	value_ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		value_ptrs[i] = &values[i]
+	}
+
+	count := 0
+	for rows.Next() {
+		if err := rows.Scan(value_ptrs...); err != nil {
+			return count, fmt.Errorf("scan error:%v (SHD_RHD_1625)", err)
+		}
+		for i, column := range columns {
+			value, exists := converter.convert(column.full_name, values[column.index])
+			if !exists {
+				return count, fmt.Errorf("field not found:%s (SHD_RHD_1626)", column.full_name)
+			}
+			if column.mask && value != nil {
+				value = MaskedFieldValue
+			}
+			record[i] = csvValue(value)
+		}
+		if err := cw.Write(record); err != nil {
+			return count, err
+		}
+		count++
+		if count%csvFlushRows == 0 {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		flush()
+		return count, fmt.Errorf("rows error:%v (SHD_RHD_1627)", err)
+	}
+	return count, flush()
+}
+
+// csvValue formats a converted value (see valueConverter) for CSV. NULL is
+// the empty string. Text is passed through csvText; numbers are not, so
+// that negative numbers stay numbers.
+func csvValue(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return csvText(val)
+	case []byte:
+		return csvText(string(val))
+	case bool:
+		return strconv.FormatBool(val)
+	case int:
+		return strconv.Itoa(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case map[string]interface{}, []interface{}:
+		if data, err := json.Marshal(val); err == nil {
+			return string(data)
+		}
+	}
+	return csvText(fmt.Sprintf("%v", value))
+}
+
+// csvText prefixes text that a spreadsheet would run as a formula (it
+// starts with '=', '+', '-', '@', a tab or a carriage return) with a
+// quote, so that an exported cell can't run anything when opened.
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
@@ -0,0 +1,110 @@
+package RequestHandlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+// runExportCSV sends 'req' through HandleDBExportCSV as testUser()
+func runExportCSV(t *testing.T, req ApiTypes.ExportCSVRequest) (*httptest.ResponseRecorder, int, *ApiTypes.JimoResponse) {
+	t.Helper()
+	rc := testharness.NewFakeRequestContext(t, testUser())
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	w := httptest.NewRecorder()
+	status, resp := HandleDBExportCSV(rc.Context(), rc, w, body)
+	return w, status, resp
+}
+
+func TestHandleDBExportCSV(t *testing.T) {
+	SetFieldPolicies(FieldPolicies{"users": {"email": {Action: FieldAction_Mask}}})
+	t.Cleanup(func() { SetFieldPolicies(nil) })
+
+	query := func() ApiTypes.QueryRequest {
+		req := usersQuery(atomicCond("id", "int", GreaterEqual, 2))
+		req.PageSize = 0
+		return req
+	}
+	const usersSQL = "SELECT users.id, users.name, users.email FROM users WHERE id >= $1 ORDER BY id ASC"
+
+	tests := []struct {
+		name string
+		req  ApiTypes.ExportCSVRequest
+		want string
+	}{
+		{"default", ApiTypes.ExportCSVRequest{QueryRequest: query()},
+			"id,name,email\n2,bob,****\n3,carol,****\n"},
+		{"delimiter, no header", ApiTypes.ExportCSVRequest{QueryRequest: query(), Delimiter: ";", NoHeader: true},
+			"2;bob;****\n3;carol;****\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tdb := installUsers(t)
+			if tdb.IsMock() {
+				tdb.Mock.ExpectQuery(usersSQL).WithArgs(int64(2)).
+					WillReturnRows(usersFixture.MockRows([]string{"id", "name", "email"}, func(row map[string]interface{}) bool {
+						return row["id"].(int) >= 2
+					}))
+			}
+
+			w, status, resp := runExportCSV(t, tt.req)
+			if resp != nil || status != http.StatusOK {
+				t.Fatalf("status = %d, resp = %+v", status, resp)
+			}
+			if got := w.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+				t.Errorf("content type = %q", got)
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("csv = %q, want %q", got, tt.want)
+			}
+			if got := w.Header().Get(csvTrailerRows); got != "2" {
+				t.Errorf("rows trailer = %q, want 2", got)
+			}
+		})
+	}
+
+	t.Run("bad delimiter", func(t *testing.T) {
+		installMock(t)
+		w, status, resp := runExportCSV(t, ApiTypes.ExportCSVRequest{QueryRequest: query(), Delimiter: `"`})
+		if resp == nil {
+			t.Fatalf("exported %q", w.Body.String())
+		}
+		expectFailure(t, status, *resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "invalid CSV delimiter")
+		if w.Body.Len() != 0 {
+			t.Errorf("body = %q, want nothing written", w.Body.String())
+		}
+	})
+}
+
+func TestCSVValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+1+2", "'+1+2"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{"\rcmd", "'\rcmd"},
+		{[]byte("=1"), "'=1"},
+		{"a=b", "a=b"},
+		{"", ""},
+		{nil, ""},
+		{-5, "-5"},
+		{int64(-5), "-5"},
+		{-1.5, "-1.5"},
+	}
+	for _, tt := range tests {
+		if got := csvValue(tt.value); got != tt.want {
+			t.Errorf("csvValue(%#v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package RequestHandlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+// errDeleteAllCondition is returned when a delete has both a condition and
+// confirm_delete_all, so that it is clear whether all the rows go
+var errDeleteAllCondition = errors.New("confirm_delete_all deletes all the rows and takes no condition (SHD_RHD_1720)")
+
+// tooManyDeletesError is returned when a delete matches more rows than
+// the ExpectedMaxRows of its request. Nothing is deleted then.
+type tooManyDeletesError struct {
+	matched      int64
+	expected_max int
+}
+
+func (e *tooManyDeletesError) Error() string {
+	return fmt.Sprintf("delete matches %d rows, more than expected_max_rows %d: narrow the condition "+
+		"or raise expected_max_rows (SHD_RHD_1721)", e.matched, e.expected_max)
+}
+
+// checkDeleteIntent checks the condition 'expr' of the delete 'req'. A
+// delete without a condition needs req.ConfirmDeleteAll, which in turn
+// takes no condition, and ExpectedMaxRows can't be negative.
+func checkDeleteIntent(req ApiTypes.DeleteRequest, expr sq.Sqlizer) error {
+	if req.ExpectedMaxRows < 0 {
+		return fmt.Errorf("invalid expected_max_rows %d (SHD_RHD_1722)", req.ExpectedMaxRows)
+	}
+	if expr == nil && !req.ConfirmDeleteAll {
+		return fmt.Errorf("missing conditions, set confirm_delete_all to delete all the rows, "+
+			"loc:%s (SHD_RHD_1723)", req.Loc)
+	}
+	if expr != nil && req.ConfirmDeleteAll {
+		return errDeleteAllCondition
+	}
+	return nil
+}
+
+// checkDeleteCount counts the rows of 'table_name' matching 'expr' (all of
+// them if nil) in 'tx', and returns a *tooManyDeletesError if there are
+// more than 'expected_max'. 0 means no expectation.
+func checkDeleteCount(
+	ctx context.Context,
+	tx *sql.Tx,
+	table_name string,
+	expr sq.Sqlizer,
+	expected_max int) error {
+	if expected_max <= 0 {
+		return nil
+	}
+	stmt, args, err := sq.Select("COUNT(*)").
+		From(quoteQualified(table_name)).
+		Where(expr).
+		PlaceholderFormat(placeholderFormat()).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed building count of %s: %w (SHD_RHD_1724)", table_name, err)
+	}
+	var matched int64
+	if err := tx.QueryRowContext(ctx, stmt, args...).Scan(&matched); err != nil {
+		return fmt.Errorf("failed counting rows of %s: %w (SHD_RHD_1725)", table_name, err)
+	}
+	return checkDeletedRows(matched, expected_max)
+}
+
+// checkDeletedRows returns a *tooManyDeletesError if 'n' rows are more than
+// 'expected_max' (0: no expectation). The count of checkDeleteCount may
+// miss rows added before the delete runs, so the rows the delete affected
+// are checked again before the commit.
+func checkDeletedRows(n int64, expected_max int) error {
+	if expected_max > 0 && n > int64(expected_max) {
+		return &tooManyDeletesError{matched: n, expected_max: expected_max}
+	}
+	return nil
+}
+
+// runGuardedDelete deletes the rows of 'table_name' matching 'expr' (all
+// of them if nil) in a transaction, after checking that they are not more
+// than 'expected_max'. It returns the rows deleted and the statement.
+func runGuardedDelete(
+	ctx context.Context,
+	db *sql.DB,
+	table_name string,
+	expr sq.Sqlizer,
+	expected_max int) (int64, string, error) {
+	query := sq.Delete(quoteQualified(table_name)).PlaceholderFormat(placeholderFormat())
+	if expr != nil {
+		query = query.Where(expr)
+	}
+	stmt, args, err := query.ToSql()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to build SQL query: %w (SHD_RHD_1726)", err)
+	}
+
+	var deleted int64
+	err = databaseutil.WithWriteRetry(ctx, db, func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := checkDeleteCount(ctx, tx, table_name, expr, expected_max); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, stmt, args...)
+		if err != nil {
+			return err
+		}
+		if deleted, err = result.RowsAffected(); err != nil {
+			return err
+		}
+		if err := checkDeletedRows(deleted, expected_max); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	return deleted, stmt, err
+}
+
+// tooManyDeletesResponse is the response of a delete refused by its
+// expected_max_rows. The number of matching rows is in Results.
+func tooManyDeletesResponse(
+	reqID string,
+	table_name string,
+	err *tooManyDeletesError,
+	loc string) (int, ApiTypes.JimoResponse) {
+	return ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.JimoResponse{
+		Status:     false,
+		ReqID:      reqID,
+		ErrorMsg:   err.Error(),
+		ErrorKind:  ApiTypes.ErrorKind_InvalidRequest,
+		TableName:  table_name,
+		ResultType: "json",
+		NumRecords: 1,
+		Results: map[string]interface{}{
+			"matched_rows":      err.matched,
+			"expected_max_rows": err.expected_max,
+		},
+		ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+		Loc:       loc,
+	}
+}
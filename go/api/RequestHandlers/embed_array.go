@@ -0,0 +1,198 @@
+package RequestHandlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+// checkArrayEmbeds checks the joins of 'join_defs' embedded as arrays
+// (JoinDef.EmbedAsArray). A query may have one only: the joined rows of
+// two would multiply each other's. Its table may not be joined from
+// either, as such a join would select a field per joined row where the
+// query returns a row per parent row.
+func checkArrayEmbeds(join_defs []ApiTypes.JoinDef) error {
+	array_table := ""
+	for i, jd := range join_defs {
+		if len(jd.OnClause) == 0 || !jd.EmbedAsArray {
+			continue
+		}
+		if jd.EmbedName == "" {
+			return fmt.Errorf("%w: embed_as_array needs an embed_name, join:%d (SHD_RHD_1653)",
+				errBadJoinPlan, i)
+		}
+		if array_table != "" {
+			return fmt.Errorf("%w: only one join may be embedded as an array, join:%d (SHD_RHD_1654)",
+				errBadJoinPlan, i)
+		}
+		if len(jd.GroupBy) == 0 {
+			return fmt.Errorf("%w: embed_as_array needs group_by, the fields identifying a parent row, "+
+				"join:%d (SHD_RHD_1655)", errBadJoinPlan, i)
+		}
+		array_table = jd.JoinedTableName
+	}
+	if array_table == "" {
+		return nil
+	}
+
+	for i, jd := range join_defs {
+		if len(jd.OnClause) > 0 && jd.FromTableName == array_table {
+			return fmt.Errorf("%w: table %s is embedded as an array and can't be joined from, "+
+				"join:%d (SHD_RHD_1656)", errBadJoinPlan, array_table, i)
+		}
+	}
+	return nil
+}
+
+// arrayEmbedJoin returns the join of 'join_defs' embedded as an array, if
+// any (checkArrayEmbeds allows one)
+func arrayEmbedJoin(join_defs []ApiTypes.JoinDef) (ApiTypes.JoinDef, bool) {
+	for _, jd := range join_defs {
+		if len(jd.OnClause) > 0 && jd.EmbedAsArray && jd.EmbedName != "" {
+			return jd, true
+		}
+	}
+	return ApiTypes.JoinDef{}, false
+}
+
+// arrayEmbedGroupBy returns the GROUP BY fields of a query whose join 'jd'
+// is embedded as an array. 'fields' and 'aliases' are the selected fields
+// of the query and their aliases. The keys in jd.GroupBy come first; each
+// must be a selected field outside of the embed, or distinct parent rows
+// could be merged. The other selected fields outside of the embed follow,
+// as SQL needs every selected field grouped on or aggregated.
+func arrayEmbedGroupBy(jd ApiTypes.JoinDef, fields []string, aliases []string) ([]string, error) {
+	prefix := jd.EmbedName + "____"
+	grouped := make(map[string]bool)
+	var group_by []string
+	for _, key := range jd.GroupBy {
+		idx := slices.Index(fields, key)
+		if !isQualifiedIdentifier(key) || idx < 0 || strings.HasPrefix(aliases[idx], prefix) {
+			return nil, fmt.Errorf("%w: group_by %q is not a selected field of the parent rows, "+
+				"embed:%s (SHD_RHD_1657)", errBadJoinPlan, key, jd.EmbedName)
+		}
+		if !grouped[key] {
+			grouped[key] = true
+			group_by = append(group_by, key)
+		}
+	}
+	for i, field := range fields {
+		if !strings.HasPrefix(aliases[i], prefix) && !grouped[field] {
+			grouped[field] = true
+			group_by = append(group_by, field)
+		}
+	}
+	return group_by, nil
+}
+
+// arrayEmbedColumn returns the SQL selecting the join 'jd' embedded as an
+// array: one JSON array with an object per joined row of a parent row,
+// keyed by 'keys' with the values of 'fields' (quoted). Aggregating the
+// objects, rather than an array per field, keeps the values of a joined
+// row together whatever order the database aggregates the rows in. A
+// parent without joined rows (LEFT JOIN), told by a null joined field of
+// the first ON clause, gets an empty array.
+func arrayEmbedColumn(keys []string, fields []string, jd ApiTypes.JoinDef) string {
+	pairs := make([]string, len(fields))
+	for i, field := range fields {
+		// checkAliases allows identifier characters only in the keys
+		pairs[i] = "'" + keys[i] + "', " + field
+	}
+	matched := quoteQualified(jd.JoinedTableName) + "." + quoteIdent(jd.OnClause[0].JoinedFieldName)
+	if ApiTypes.DBType == ApiTypes.MysqlName {
+		return fmt.Sprintf("CASE WHEN COUNT(%s) = 0 THEN JSON_ARRAY() ELSE JSON_ARRAYAGG(JSON_OBJECT(%s)) END",
+			matched, strings.Join(pairs, ", "))
+	}
+	return fmt.Sprintf("COALESCE(json_agg(json_build_object(%s)) FILTER (WHERE %s IS NOT NULL), '[]')",
+		strings.Join(pairs, ", "), matched)
+}
+
+// convertArrayEmbed decodes 'value', the JSON array of objects selected
+// by arrayEmbedColumn, and converts the values of each object like the
+// values of a plain column. 'full_names' maps the keys of the objects to
+// their full field names. JSON numbers are converted from their text, as
+// drivers return numbers.
+func (c *valueConverter) convertArrayEmbed(
+	full_names map[string]string,
+	value interface{}) ([]map[string]interface{}, error) {
+	for _, full_name := range full_names {
+		if _, exists := c.data_types[full_name]; !exists {
+			return nil, fmt.Errorf("field not found:%s (SHD_RHD_1658)", full_name)
+		}
+	}
+
+	var data []byte
+	switch val := value.(type) {
+	case nil:
+		return []map[string]interface{}{}, nil
+	case []byte:
+		data = val
+	case string:
+		data = []byte(val)
+	default:
+		return nil, fmt.Errorf("unexpected %T for an array embed (SHD_RHD_1659)", value)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var objs []map[string]interface{}
+	if err := decoder.Decode(&objs); err != nil {
+		return nil, fmt.Errorf("invalid array embed: %v (SHD_RHD_1659)", err)
+	}
+	if objs == nil {
+		objs = []map[string]interface{}{}
+	}
+	for _, obj := range objs {
+		for key, full_name := range full_names {
+			element := obj[key]
+			if num, ok := element.(json.Number); ok {
+				element = []byte(num.String())
+			}
+			obj[key], _ = c.convert(full_name, element)
+		}
+		for key := range obj {
+			if _, ok := full_names[key]; !ok {
+				delete(obj, key)
+			}
+		}
+	}
+	return objs, nil
+}
+
+// checkArrayEmbedOrderBy checks that no order-by field of 'orderby_defs'
+// is a field of the join of 'join_defs' embedded as an array: such a
+// field has a value per joined row, not per returned row. 'fields',
+// 'aliases' and 'field_def_map' are those of orderByClause.
+func checkArrayEmbedOrderBy(
+	join_defs []ApiTypes.JoinDef,
+	orderby_defs []ApiTypes.OrderbyDef,
+	fields []string,
+	aliases []string,
+	field_def_map map[string][]ApiTypes.FieldDef) error {
+	jd, ok := arrayEmbedJoin(join_defs)
+	if !ok {
+		return nil
+	}
+	for _, orderby_def := range orderby_defs {
+		field, err := resolveOrderByField(orderby_def.FieldName, fields, aliases, field_def_map)
+		if err != nil {
+			return err
+		}
+		embedded := false
+		if dot := strings.LastIndex(field, "."); dot >= 0 {
+			embedded = field[:dot] == jd.JoinedTableName
+		} else {
+			embedded = slices.ContainsFunc(field_def_map[jd.JoinedTableName],
+				func(fd ApiTypes.FieldDef) bool { return fd.FieldName == field })
+		}
+		if embedded {
+			return fmt.Errorf("%w %q: a field of %s, which is embedded as an array (SHD_RHD_1737)",
+				errBadOrderBy, orderby_def.FieldName, jd.EmbedName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package RequestHandlers
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+func TestArrayEmbedColumn(t *testing.T) {
+	saved := ApiTypes.DBType
+	t.Cleanup(func() { ApiTypes.DBType = saved })
+	jd := arrayEmbedJoinDef("users.id")
+	keys := []string{"id", "amount"}
+	fields := []string{"orders.id", "orders.amount"}
+
+	ApiTypes.DBType = ApiTypes.PgName
+	if got, want := arrayEmbedColumn(keys, fields, jd),
+		"COALESCE(json_agg(json_build_object('id', orders.id, 'amount', orders.amount)) "+
+			"FILTER (WHERE orders.user_id IS NOT NULL), '[]')"; got != want {
+		t.Errorf("pg = %q, want %q", got, want)
+	}
+	ApiTypes.DBType = ApiTypes.MysqlName
+	if got, want := arrayEmbedColumn(keys, fields, jd),
+		"CASE WHEN COUNT(orders.user_id) = 0 THEN JSON_ARRAY() "+
+			"ELSE JSON_ARRAYAGG(JSON_OBJECT('id', orders.id, 'amount', orders.amount)) END"; got != want {
+		t.Errorf("mysql = %q, want %q", got, want)
+	}
+}
+
+func TestConvertArrayEmbed(t *testing.T) {
+	converter, err := newValueConverter(ApiTypes.QueryRequest{}, map[string][]ApiTypes.FieldDef{
+		"orders": {
+			{FieldName: "amount", DataType: "float"},
+			{FieldName: "paid", DataType: "bool"},
+			{FieldName: "created_at", DataType: "timestamptz"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]string{"amount": "orders.amount", "paid": "orders.paid", "at": "orders.created_at"}
+
+	tests := []struct {
+		name    string
+		names   map[string]string
+		value   interface{}
+		want    []map[string]interface{}
+		wantErr string
+	}{
+		{name: "objects", names: names,
+			value: []byte(`[{"amount": 1.5, "paid": true, "at": "2026-01-02T03:04:05+01:00"}, ` +
+				`{"amount": 2, "paid": false, "at": null}]`),
+			want: []map[string]interface{}{
+				{"amount": 1.5, "paid": true, "at": "2026-01-02T02:04:05Z"},
+				{"amount": 2.0, "paid": false, "at": nil},
+			}},
+		{name: "mysql booleans", names: map[string]string{"paid": "orders.paid"}, value: `[{"paid": 1}, {"paid": 0}]`,
+			want: []map[string]interface{}{{"paid": true}, {"paid": false}}},
+		{name: "missing and unknown keys", names: map[string]string{"amount": "orders.amount"},
+			value: []byte(`[{"note": "x"}]`),
+			want:  []map[string]interface{}{{"amount": nil}}},
+		{name: "empty", names: names, value: []byte("[]"), want: []map[string]interface{}{}},
+		{name: "null", names: names, value: nil, want: []map[string]interface{}{}},
+		{name: "unknown field", names: map[string]string{"note": "orders.note"}, value: []byte("[]"),
+			wantErr: "field not found"},
+		{name: "not an array of objects", names: names, value: []byte(`[1, 2]`), wantErr: "invalid array embed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := converter.convertArrayEmbed(tt.names, tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("objects = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckArrayEmbedOrderBy(t *testing.T) {
+	join_defs := []ApiTypes.JoinDef{arrayEmbedJoinDef("users.id")}
+	fields := []string{"users.id", "users.name", "orders.id", "orders.amount"}
+	aliases := []string{"id", "name", "orders____id", "orders____amount"}
+	field_def_map := map[string][]ApiTypes.FieldDef{"users": usersFieldDefs, "orders": ordersFieldDefs}
+
+	for _, field := range []string{"users.id", "name"} {
+		if err := checkArrayEmbedOrderBy(join_defs, []ApiTypes.OrderbyDef{{FieldName: field}},
+			fields, aliases, field_def_map); err != nil {
+			t.Errorf("order by %s: %v", field, err)
+		}
+	}
+	for _, field := range []string{"orders.amount", "orders____amount", "amount"} {
+		err := checkArrayEmbedOrderBy(join_defs, []ApiTypes.OrderbyDef{{FieldName: field}},
+			fields, aliases, field_def_map)
+		if !errors.Is(err, errBadOrderBy) || !strings.Contains(err.Error(), "embedded as an array") {
+			t.Errorf("order by %s: error = %v, want an embedded field error", field, err)
+		}
+	}
+	if err := checkArrayEmbedOrderBy(nil, []ApiTypes.OrderbyDef{{FieldName: "orders.amount"}},
+		fields, aliases, field_def_map); err != nil {
+		t.Errorf("without an array embed: %v", err)
+	}
+}
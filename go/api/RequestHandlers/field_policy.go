@@ -0,0 +1,245 @@
+package RequestHandlers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+// Field policies hide fields of query results from users without the
+// right role, e.g. users.email from everyone but admins. A hidden field
+// may not be used in conditions, ORDER BY or join keys either, or its
+// values could be recovered, e.g. with "email LIKE 'a%'" one prefix at
+// a time. No policies are configured by default.
+
+const (
+	// FieldAction_Drop removes the field from the results
+	FieldAction_Drop = "drop"
+	// FieldAction_Mask replaces a non-null value with MaskedFieldValue
+	FieldAction_Mask = "mask"
+)
+
+// MaskedFieldValue is the value of a masked field
+const MaskedFieldValue = "****"
+
+// FieldPolicy controls who sees one field of query results
+type FieldPolicy struct {
+	// Roles that see the field (see UserInfo.Roles). Admins always do.
+	Roles []string
+	// Action is FieldAction_Drop (the default) or FieldAction_Mask
+	Action string
+}
+
+// FieldPolicies maps table names to the policies of their fields
+type FieldPolicies map[string]map[string]FieldPolicy
+
+var (
+	field_policy_mu sync.RWMutex
+	field_policies  FieldPolicies
+)
+
+// SetFieldPolicies replaces the field policies of the Jimo query
+// handler. nil removes them all.
+func SetFieldPolicies(policies FieldPolicies) {
+	field_policy_mu.Lock()
+	defer field_policy_mu.Unlock()
+	field_policies = policies
+}
+
+func getFieldPolicies() FieldPolicies {
+	field_policy_mu.RLock()
+	defer field_policy_mu.RUnlock()
+	return field_policies
+}
+
+// canSeeField returns true if 'user_info' may see a field under 'policy'
+func canSeeField(user_info *ApiTypes.UserInfo, policy FieldPolicy) bool {
+	if user_info == nil {
+		return false
+	}
+	if user_info.Admin {
+		return true
+	}
+	for _, role := range policy.Roles {
+		for _, user_role := range user_info.Roles {
+			if role == user_role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hiddenField returns the policy of the field 'full_name'
+// (<table>.<field>) and true if 'user_info' may not see it
+func hiddenField(
+	policies FieldPolicies,
+	user_info *ApiTypes.UserInfo,
+	full_name string) (FieldPolicy, bool) {
+	dot := strings.LastIndex(full_name, ".")
+	if dot < 0 {
+		return FieldPolicy{}, false
+	}
+	policy, ok := policies[full_name[:dot]][full_name[dot+1:]]
+	if !ok || canSeeField(user_info, policy) {
+		return FieldPolicy{}, false
+	}
+	return policy, true
+}
+
+// applyFieldPolicies drops or masks, in place, the fields of 'results'
+// that 'user_info' may not see. 'selected_fields' are the full names
+// (<table>.<field>) of the columns and 'aliases' their keys in the
+// results, with embedded fields as <embed>____<alias>. An embed is an
+// object, or an array of objects if the join is embedded as an array.
+func applyFieldPolicies(
+	user_info *ApiTypes.UserInfo,
+	results []map[string]interface{},
+	selected_fields []string,
+	aliases []string) {
+	policies := getFieldPolicies()
+	if len(policies) == 0 {
+		return
+	}
+
+	for i, full_name := range selected_fields {
+		policy, hidden := hiddenField(policies, user_info, full_name)
+		if !hidden {
+			continue
+		}
+
+		embed_name, alias := "", aliases[i]
+		if parts := strings.Split(alias, "____"); len(parts) == 2 {
+			embed_name, alias = parts[0], parts[1]
+		}
+		for _, row := range results {
+			targets := []map[string]interface{}{row}
+			if embed_name != "" {
+				// A null embed has nothing to hide
+				switch sub := row[embed_name].(type) {
+				case map[string]interface{}:
+					targets = []map[string]interface{}{sub}
+				case []map[string]interface{}:
+					targets = sub
+				default:
+					continue
+				}
+			}
+			for _, target := range targets {
+				value, exists := target[alias]
+				if !exists {
+					continue
+				}
+				if policy.Action == FieldAction_Mask {
+					if value != nil {
+						target[alias] = MaskedFieldValue
+					}
+				} else {
+					delete(target, alias)
+				}
+			}
+		}
+	}
+}
+
+// errHiddenFieldUse is returned when a query filters, sorts or joins on a
+// field hidden from the user. The query is answered with BadRequest.
+var errHiddenFieldUse = errors.New("no access to field")
+
+// checkHiddenFieldUse checks that the conditions, the ORDER BY and the
+// join keys of 'req' use no field hidden from 'user_info'. Unqualified
+// names are fields of req.TableName. 'fields' and 'aliases' are the
+// selected fields of the query and their aliases, by which ORDER BY may
+// name a field.
+func checkHiddenFieldUse(
+	user_info *ApiTypes.UserInfo,
+	req ApiTypes.QueryRequest,
+	fields []string,
+	aliases []string) error {
+	policies := getFieldPolicies()
+	if len(policies) == 0 {
+		return nil
+	}
+	check := func(name string, usage string) error {
+		full_name := name
+		if !strings.Contains(name, ".") {
+			full_name = req.TableName + "." + name
+		}
+		if _, hidden := hiddenField(policies, user_info, full_name); hidden {
+			return fmt.Errorf("%w %s: it can't be used in %s (SHD_RHD_1730)", errHiddenFieldUse, full_name, usage)
+		}
+		return nil
+	}
+
+	if err := conditionFields(req.Condition, req.TableName, func(full_name string) error {
+		return check(full_name, "conditions")
+	}); err != nil {
+		return err
+	}
+	for _, orderby_def := range req.OrderbyDef {
+		name := orderby_def.FieldName
+		if idx := slices.Index(aliases, name); idx >= 0 {
+			name = fields[idx]
+		}
+		if err := check(name, "order by"); err != nil {
+			return fmt.Errorf("%w: %w", errBadOrderBy, err)
+		}
+	}
+	for _, jd := range req.JoinDefs {
+		for _, on := range jd.OnClause {
+			if err := check(jd.FromTableName+"."+on.SourceFieldName, "joins"); err != nil {
+				return err
+			}
+			if err := check(jd.JoinedTableName+"."+on.JoinedFieldName, "joins"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// conditionFields calls 'fn' with the full name of each field 'condition'
+// uses, until it returns an error. Unqualified names are fields of
+// 'table_name'. The fields of an exists sub-query, in its on_clause and
+// condition, are fields of its table.
+func conditionFields(condition ApiTypes.CondDef, table_name string, fn func(full_name string) error) error {
+	qualified := func(table_name string, name string) string {
+		if strings.Contains(name, ".") {
+			return name
+		}
+		return table_name + "." + name
+	}
+	if condition.FieldName != "" {
+		if err := fn(qualified(table_name, condition.FieldName)); err != nil {
+			return err
+		}
+	}
+	for _, name := range condition.FieldNames {
+		if err := fn(qualified(table_name, name)); err != nil {
+			return err
+		}
+	}
+	for _, sub := range condition.Conditions {
+		if err := conditionFields(sub, table_name, fn); err != nil {
+			return err
+		}
+	}
+	if sub := condition.SubQuery; sub != nil {
+		for _, on := range sub.OnClause {
+			if err := fn(qualified(table_name, on.SourceFieldName)); err != nil {
+				return err
+			}
+			if err := fn(qualified(sub.TableName, on.JoinedFieldName)); err != nil {
+				return err
+			}
+		}
+		if sub.Condition != nil {
+			return conditionFields(*sub.Condition, sub.TableName, fn)
+		}
+	}
+	return nil
+}
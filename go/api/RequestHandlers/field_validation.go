@@ -0,0 +1,143 @@
+package RequestHandlers
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+// MaxFieldProblems caps the number of problems ValidateRecords reports, so a
+// large import with a systematic error does not produce a huge response.
+const MaxFieldProblems = 50
+
+// ValidateRecords checks 'records' against 'field_defs' before any SQL is
+// built. For each record it checks that:
+//   - required fields are present and not null,
+//   - every key matches a FieldDef (only if 'strict'; otherwise unknown keys
+//     are ignored, as the insert itself does), and
+//   - values convert to the declared data type (the same conversion the
+//     insert uses, in the request's time zone 'time_zone').
+//
+// It returns at most MaxFieldProblems problems; nil means the records are
+// valid. If 'field_defs' is empty there is nothing to validate against.
+func ValidateRecords(
+	field_defs []ApiTypes.FieldDef,
+	records []map[string]interface{},
+	strict bool,
+	time_zone *time.Location) []ApiTypes.FieldProblem {
+	if len(field_defs) == 0 {
+		return nil
+	}
+
+	var problems []ApiTypes.FieldProblem
+	for idx, record := range records {
+		problems = validateRecord(problems, field_defs, idx, record, strict, true, time_zone)
+		if len(problems) >= MaxFieldProblems {
+			return problems[:MaxFieldProblems]
+		}
+	}
+	return problems
+}
+
+// ValidateUpdateRecord checks the SET values of an update. It is the same as
+// ValidateRecords except that required fields may be absent, since an
+// update only sets the fields it names.
+func ValidateUpdateRecord(
+	field_defs []ApiTypes.FieldDef,
+	record map[string]interface{},
+	strict bool,
+	time_zone *time.Location) []ApiTypes.FieldProblem {
+	if len(field_defs) == 0 {
+		return nil
+	}
+
+	problems := validateRecord(nil, field_defs, 0, record, strict, false, time_zone)
+	if len(problems) > MaxFieldProblems {
+		return problems[:MaxFieldProblems]
+	}
+	return problems
+}
+
+func validateRecord(
+	problems []ApiTypes.FieldProblem,
+	field_defs []ApiTypes.FieldDef,
+	record_index int,
+	record map[string]interface{},
+	strict bool,
+	check_required bool,
+	time_zone *time.Location) []ApiTypes.FieldProblem {
+	add := func(field string, format string, args ...interface{}) {
+		problems = append(problems, ApiTypes.FieldProblem{
+			RecordIndex: record_index,
+			Field:       field,
+			Problem:     fmt.Sprintf(format, args...),
+		})
+	}
+
+	known := make(map[string]bool, len(field_defs))
+	for _, f := range field_defs {
+		known[f.FieldName] = true
+		val, ok := record[f.FieldName]
+
+		switch f.DataType {
+		case "_creator", "_updater", "_ignore", "_auto_inc":
+			// Set by the server or skipped; whatever the client sent is not used.
+			continue
+
+		case "array":
+			// A missing required array is inserted as an empty array; a
+			// missing or null optional one as NULL
+			if !ok || (val == nil && !f.Required) {
+				continue
+			}
+			if _, err := convertArrayValue(f, val); err != nil {
+				add(f.FieldName, "invalid %s array: %v", f.ElementType, err)
+			}
+
+		default:
+			if !ok {
+				if check_required && f.Required {
+					add(f.FieldName, "missing required field")
+				}
+				continue
+			}
+			if val == nil {
+				if f.Required {
+					add(f.FieldName, "required field is null")
+				}
+				continue
+			}
+			if _, err := convertFieldValue(ApiTypes.FieldDataType(f), val, time_zone); err != nil {
+				add(f.FieldName, "%v", err)
+			}
+		}
+	}
+
+	if strict {
+		// Sort so the problems are reported in a stable order
+		var unknown []string
+		for key := range record {
+			if !known[key] {
+				unknown = append(unknown, key)
+			}
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			add(key, "unknown field")
+		}
+	}
+	return problems
+}
+
+// fieldProblemsMsg summarizes 'problems' for JimoResponse.ErrorMsg; the
+// full list goes in Results.
+func fieldProblemsMsg(problems []ApiTypes.FieldProblem) string {
+	first := problems[0]
+	msg := fmt.Sprintf("record %d, field %s: %s", first.RecordIndex, first.Field, first.Problem)
+	if len(problems) == 1 {
+		return "invalid record (" + msg + ")"
+	}
+	return fmt.Sprintf("%d validation problems, first: %s", len(problems), msg)
+}
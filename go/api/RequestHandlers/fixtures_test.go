@@ -0,0 +1,95 @@
+package RequestHandlers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+// Fixed timestamps keep the fixtures, and so the query results, stable
+var fixtureCreatedAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+var usersFieldDefs = []ApiTypes.FieldDef{
+	{FieldName: "id", DataType: "int", Required: true},
+	{FieldName: "name", DataType: "string", Required: true},
+	{FieldName: "email", DataType: "string"},
+	{FieldName: "created_at", DataType: "timestamp"},
+}
+
+var ordersFieldDefs = []ApiTypes.FieldDef{
+	{FieldName: "id", DataType: "int", Required: true},
+	{FieldName: "user_id", DataType: "int", Required: true},
+	{FieldName: "amount", DataType: "int"},
+}
+
+var usersFixture = testharness.Fixture{
+	Table:     "users",
+	FieldDefs: usersFieldDefs,
+	Rows: []map[string]interface{}{
+		{"id": 1, "name": "alice", "email": "alice@example.com", "created_at": fixtureCreatedAt},
+		{"id": 2, "name": "bob", "email": "bob@example.com", "created_at": fixtureCreatedAt.Add(time.Hour)},
+		{"id": 3, "name": "carol", "email": "carol@example.com", "created_at": fixtureCreatedAt.Add(2 * time.Hour)},
+	},
+}
+
+var ordersFixture = testharness.Fixture{
+	Table:     "orders",
+	FieldDefs: ordersFieldDefs,
+	Rows: []map[string]interface{}{
+		{"id": 10, "user_id": 1, "amount": 250},
+		{"id": 11, "user_id": 1, "amount": 75},
+		{"id": 12, "user_id": 2, "amount": 120},
+		{"id": 13, "user_id": 2, "amount": nil},
+	},
+}
+
+func testUser() *ApiTypes.UserInfo {
+	return &ApiTypes.UserInfo{
+		UserId:   "user-1",
+		UserName: "tester",
+		Email:    "tester@example.com",
+	}
+}
+
+// testCtx returns a context with the values the handlers expect
+func testCtx() context.Context {
+	ctx := context.WithValue(context.Background(), ApiTypes.RequestIDKey, "test-req")
+	return context.WithValue(ctx, ApiTypes.CallFlowKey, "TEST")
+}
+
+// runJimo sends 'req' through handleJimoRequestPriv as 'user' (nil for
+// an anonymous request).
+func runJimo(t *testing.T, user *ApiTypes.UserInfo, req interface{}) (int, ApiTypes.JimoResponse) {
+	t.Helper()
+	rc := testharness.NewFakeRequestContext(t, user)
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	return handleJimoRequestPriv(rc.Context(), rc, body)
+}
+
+func atomicCond(field string, data_type string, opr Operator, value interface{}) ApiTypes.CondDef {
+	return ApiTypes.CondDef{
+		Type:      ApiTypes.ConditionTypeAtomic,
+		FieldName: field,
+		DataType:  data_type,
+		Opr:       string(opr),
+		Value:     value,
+	}
+}
+
+// multiContainCond is a multi_contain condition searching 'fields'
+func multiContainCond(data_type string, value interface{}, fields ...string) ApiTypes.CondDef {
+	return ApiTypes.CondDef{
+		Type:       ApiTypes.ConditionTypeAtomic,
+		DataType:   data_type,
+		Opr:        string(MultiContain),
+		Value:      value,
+		FieldNames: fields,
+	}
+}
@@ -354,6 +354,10 @@ func HandleDeleteIcon(c echo.Context) error {
 		log.Warn("failed to delete icon file", "error", err, "path", icon.FilePath)
 	}
 
+	if err := ApiTypes.DefaultIconService.DeleteThumbnails(rc, icon); err != nil {
+		log.Warn("failed to delete icon thumbnails", "error", err, "id", id)
+	}
+
 	log.Info("Icon deleted", "id", id, "name", icon.Name)
 
 	return c.JSON(http.StatusOK, ApiTypes.JimoResponse{
@@ -451,3 +455,91 @@ func HandleServeIconFile(c echo.Context) error {
 
 	return c.File(filePath)
 }
+
+// HandleIconThumbnail handles GET /shared_api/v1/icons/:id/thumbnail?max_dim=64
+// It serves a cached resized copy of raster icons, generating it on first use.
+// SVG icons and icons already within max_dim are served as the original file.
+func HandleIconThumbnail(c echo.Context) error {
+	rc := EchoFactory.NewFromEcho(c, "SHD_ICH_461")
+	defer rc.Close()
+	log := rc.GetLogger()
+
+	// Check authentication
+	userInfo := rc.IsAuthenticated()
+	if userInfo == nil {
+		return c.JSON(http.StatusUnauthorized, ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Authentication required",
+			Loc:      "SHD_ICH_470",
+		})
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Icon ID is required",
+			Loc:      "SHD_ICH_479",
+		})
+	}
+
+	maxDim := ApiTypes.DefaultIconThumbnailDim
+	if maxDimStr := c.QueryParam("max_dim"); maxDimStr != "" {
+		d, err := strconv.Atoi(maxDimStr)
+		if err != nil || d <= 0 || d > ApiTypes.MaxIconThumbnailDim {
+			return c.JSON(http.StatusBadRequest, ApiTypes.JimoResponse{
+				Status:   false,
+				ErrorMsg: "Invalid max_dim",
+				Loc:      "SHD_ICH_490",
+			})
+		}
+		maxDim = d
+	}
+
+	if ApiTypes.DefaultIconService == nil {
+		log.Error("icon service not initialized")
+		return c.JSON(http.StatusInternalServerError, ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Icon service not initialized",
+			Loc:      "SHD_ICH_500",
+		})
+	}
+
+	icon, thumbName, err := sysdatastores.GenerateThumbnail(rc, id, maxDim)
+	if err != nil {
+		log.Error("failed to generate icon thumbnail", "error", err, "id", id)
+		return c.JSON(http.StatusInternalServerError, ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Failed to generate thumbnail",
+			Loc:      "SHD_ICH_509",
+		})
+	}
+
+	if icon == nil {
+		return c.JSON(http.StatusNotFound, ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Icon not found",
+			Loc:      "SHD_ICH_517",
+		})
+	}
+
+	fileName := icon.FileName
+	if thumbName != "" {
+		fileName = thumbName
+	}
+
+	filePath, err := ApiTypes.DefaultIconService.GetIconFilePath(icon.Category, fileName)
+	if err != nil {
+		log.Warn("icon file not found", "category", icon.Category, "filename", fileName)
+		return c.JSON(http.StatusNotFound, ApiTypes.JimoResponse{
+			Status:   false,
+			ErrorMsg: "Icon not found",
+			Loc:      "SHD_ICH_531",
+		})
+	}
+
+	// Set cache headers for better performance
+	c.Response().Header().Set("Cache-Control", "public, max-age=86400")
+
+	return c.File(filePath)
+}
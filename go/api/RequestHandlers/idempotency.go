@@ -0,0 +1,239 @@
+package RequestHandlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+// An insert request may carry an idempotency key, in its idempotency_key
+// field or the Idempotency-Key header. The first request with a key
+// records its outcome in jimo_idempotency_keys; a retry with the same key
+// within the TTL gets that outcome back instead of inserting again. Keys
+// are scoped per user. Failed inserts release their key so they can be
+// retried. While the request runs, its key only has a short lease: if the
+// outcome is never recorded, e.g. because the process died, a retry may
+// reclaim the key once the lease is over.
+
+// IdempotencyKeyHeader is the header that carries the idempotency key
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLen is the size of the idem_key column
+const maxIdempotencyKeyLen = 255
+
+var (
+	idempotency_mu         sync.RWMutex
+	idempotency_ttl        = 24 * time.Hour
+	idempotency_lease      = 5 * time.Minute
+	idempotency_last_purge time.Time
+)
+
+// SetIdempotencyTTL sets how long the outcome of a request with an
+// idempotency key is kept. The default is 24 hours.
+func SetIdempotencyTTL(ttl time.Duration) {
+	idempotency_mu.Lock()
+	defer idempotency_mu.Unlock()
+	idempotency_ttl = ttl
+}
+
+func getIdempotencyTTL() time.Duration {
+	idempotency_mu.RLock()
+	defer idempotency_mu.RUnlock()
+	return idempotency_ttl
+}
+
+// SetIdempotencyLease sets how long the key of a running request is held
+// before a retry may reclaim it. It must exceed the longest insert. The
+// default is 5 minutes.
+func SetIdempotencyLease(lease time.Duration) {
+	idempotency_mu.Lock()
+	defer idempotency_mu.Unlock()
+	idempotency_lease = lease
+}
+
+func getIdempotencyLease() time.Duration {
+	idempotency_mu.RLock()
+	defer idempotency_mu.RUnlock()
+	return idempotency_lease
+}
+
+// detachedRC is a request context whose Context() isn't cancelled with
+// the request, to record the outcome of a request whose client is gone
+type detachedRC struct {
+	ApiTypes.RequestContext
+	ctx context.Context
+}
+
+func (rc detachedRC) Context() context.Context { return rc.ctx }
+
+// idempotencyKey returns the idempotency key of 'req', from the request
+// field or else the header. It is "" if there is none.
+func idempotencyKey(rc ApiTypes.RequestContext, req *ApiTypes.InsertRequest) string {
+	if key := strings.TrimSpace(req.IdempotencyKey); key != "" {
+		return key
+	}
+	if r := rc.GetRequest(); r != nil {
+		return strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader))
+	}
+	return ""
+}
+
+// insertRequestHash identifies the content of an insert request, so that
+// a key reused for another request can be told from a retry
+func insertRequestHash(req ApiTypes.InsertRequest) string {
+	req.IdempotencyKey = ""
+	req.Loc = ""
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// purgeIdempotencyKeys deletes expired keys, at most once an hour
+func purgeIdempotencyKeys(rc ApiTypes.RequestContext, now time.Time) {
+	idempotency_mu.Lock()
+	purge := now.Sub(idempotency_last_purge) >= time.Hour
+	if purge {
+		idempotency_last_purge = now
+	}
+	idempotency_mu.Unlock()
+
+	if purge {
+		if _, err := sysdatastores.PurgeIdempotencyKeys(rc, now); err != nil {
+			rc.GetLogger().Error("failed to purge idempotency keys", "error", err)
+		}
+	}
+}
+
+// reserveIdempotentInsert reserves 'idem_key' for 'req'. It returns
+// done = true with the response to send if the request must not run:
+// the replayed outcome of an earlier request with the key, or an error.
+func reserveIdempotentInsert(
+	rc ApiTypes.RequestContext,
+	req ApiTypes.InsertRequest,
+	idem_key string,
+	user_name string,
+	call_flow string) (int, ApiTypes.JimoResponse, bool) {
+	logger := rc.GetLogger()
+	reqID := rc.ReqID()
+	if len(idem_key) > maxIdempotencyKeyLen {
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  fmt.Sprintf("idempotency key longer than %d characters", maxIdempotencyKeyLen),
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			Loc:       fmt.Sprintf("%s->SHD_RHD_1630", call_flow),
+		}
+		return ApiTypes.CustomHttpStatus_BadRequest, resp, true
+	}
+
+	now := time.Now().UTC()
+	purgeIdempotencyKeys(rc, now)
+
+	request_hash := insertRequestHash(req)
+	reserved, existing, err := sysdatastores.ReserveIdempotencyKey(rc, sysdatastores.IdempotencyKeyDef{
+		UserName:    user_name,
+		IdemKey:     idem_key,
+		RequestHash: request_hash,
+		TableName:   req.TableName,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(getIdempotencyLease()),
+	})
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to check idempotency key:%v", err)
+		logger.Error("HandleJimoRequest", "error_msg", error_msg)
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: dbErrorKind(err),
+			Loc:       fmt.Sprintf("%s->SHD_RHD_1649", call_flow),
+		}
+		return dbErrorStatus(err, ApiTypes.CustomHttpStatus_InternalError), resp, true
+	}
+	if reserved {
+		return 0, ApiTypes.JimoResponse{}, false
+	}
+
+	if existing.RequestHash != request_hash {
+		logger.Warn("idempotency key reused", "user_name", user_name, "idem_key", idem_key)
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  "idempotency key already used for a different request",
+			ErrorKind: ApiTypes.ErrorKind_Conflict,
+			Loc:       fmt.Sprintf("%s->SHD_RHD_1664", call_flow),
+		}
+		return ApiTypes.CustomHttpStatus_BadRequest, resp, true
+	}
+
+	if existing.StatusCode == 0 {
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  "a request with this idempotency key is in progress",
+			ErrorKind: ApiTypes.ErrorKind_Conflict,
+			Loc:       fmt.Sprintf("%s->SHD_RHD_1675", call_flow),
+		}
+		return http.StatusConflict, resp, true
+	}
+
+	var resp ApiTypes.JimoResponse
+	if err := json.Unmarshal([]byte(existing.Response), &resp); err != nil {
+		error_msg := fmt.Sprintf("failed to read recorded response:%v", err)
+		logger.Error("HandleJimoRequest", "error_msg", error_msg)
+		resp := ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			ErrorMsg:  error_msg,
+			ErrorKind: ApiTypes.ErrorKind_InternalError,
+			Loc:       fmt.Sprintf("%s->SHD_RHD_1688", call_flow),
+		}
+		return ApiTypes.CustomHttpStatus_InternalError, resp, true
+	}
+
+	logger.Info("replay idempotent insert", "user_name", user_name, "idem_key", idem_key)
+	resp.ReqID = reqID
+	if resp.Meta == nil {
+		resp.Meta = map[string]interface{}{}
+	}
+	resp.Meta["idempotent_replay"] = true
+	return existing.StatusCode, resp, true
+}
+
+// finishIdempotentInsert records the outcome of the request that reserved
+// 'idem_key', to be kept for the TTL. A failed request releases the key
+// instead. It runs even if the client is gone, as the insert is done.
+// Errors are only logged: the key is then reclaimed after its lease.
+func finishIdempotentInsert(
+	rc ApiTypes.RequestContext,
+	idem_key string,
+	user_name string,
+	status_code int,
+	resp ApiTypes.JimoResponse) {
+	logger := rc.GetLogger()
+	rc = detachedRC{RequestContext: rc, ctx: context.WithoutCancel(rc.Context())}
+	if !resp.Status {
+		if err := sysdatastores.ReleaseIdempotencyKey(rc, user_name, idem_key); err != nil {
+			logger.Error("failed to release idempotency key", "error", err, "idem_key", idem_key)
+		}
+		return
+	}
+
+	data, err := json.Marshal(resp)
+	if err == nil {
+		err = sysdatastores.CompleteIdempotencyKey(rc, user_name, idem_key, status_code, string(data),
+			time.Now().UTC().Add(getIdempotencyTTL()))
+	}
+	if err != nil {
+		logger.Error("failed to record idempotency key", "error", err, "idem_key", idem_key)
+	}
+}
@@ -0,0 +1,171 @@
+package RequestHandlers
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+// expiresIn matches an expires_at argument about 'd' from now
+type expiresIn time.Duration
+
+func (d expiresIn) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Sub(time.Now().Add(time.Duration(d))).Abs() < time.Minute
+}
+
+func TestHandleDBInsertIdempotencyKey(t *testing.T) {
+	saved_purge := idempotency_last_purge
+	idempotency_last_purge = time.Now()
+	t.Cleanup(func() { idempotency_last_purge = saved_purge })
+
+	insertReq := func(name string) ApiTypes.InsertRequest {
+		return ApiTypes.InsertRequest{
+			RequestType:    ApiTypes.ReqAction_Insert,
+			TableName:      "users",
+			FieldDefs:      usersFieldDefs,
+			Records:        []map[string]interface{}{{"id": 4, "name": name}},
+			IdempotencyKey: "key-1",
+		}
+	}
+	// requestHash is the hash of 'req' as the handler sees it
+	requestHash := func(req ApiTypes.InsertRequest) string {
+		body, _ := json.Marshal(req)
+		var parsed ApiTypes.InsertRequest
+		json.Unmarshal(body, &parsed)
+		return insertRequestHash(parsed)
+	}
+
+	const (
+		deleteExpiredSQL = "DELETE FROM jimo_idempotency_keys WHERE user_name = $1 AND idem_key = $2 AND expires_at <= $3"
+		reserveSQL       = "INSERT INTO jimo_idempotency_keys (user_name, idem_key, request_hash, table_name, status_code, created_at, expires_at) " +
+			"VALUES ($1, $2, $3, $4, 0, $5, $6) ON CONFLICT (user_name, idem_key) DO NOTHING"
+		selectSQL = "SELECT user_name, idem_key, request_hash, table_name, status_code, response, created_at, expires_at " +
+			"FROM jimo_idempotency_keys WHERE user_name = $1 AND idem_key = $2"
+		completeSQL = "UPDATE jimo_idempotency_keys SET status_code = $1, response = $2, expires_at = $3 " +
+			"WHERE user_name = $4 AND idem_key = $5"
+		releaseSQL  = "DELETE FROM jimo_idempotency_keys WHERE user_name = $1 AND idem_key = $2"
+		insertSQL   = "INSERT INTO users (id,name,email,created_at) VALUES ($1,$2,$3,$4)"
+	)
+	install := func(t *testing.T) sqlmock.Sqlmock {
+		tdb := installMock(t)
+		tdb.InstallShared(t)
+		return tdb.Mock
+	}
+	expectExisting := func(mock sqlmock.Sqlmock, hash string, status_code int, response interface{}) {
+		mock.ExpectExec(deleteExpiredSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(reserveSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+		now := time.Now()
+		mock.ExpectQuery(selectSQL).WithArgs("tester", "key-1").WillReturnRows(
+			sqlmock.NewRows([]string{"user_name", "idem_key", "request_hash", "table_name",
+				"status_code", "response", "created_at", "expires_at"}).
+				AddRow("tester", "key-1", hash, "users", status_code, response, now, now.Add(time.Hour)))
+	}
+
+	// The running request holds the key for its lease only; its outcome is
+	// then kept for the TTL
+	t.Run("first request", func(t *testing.T) {
+		mock := install(t)
+		req := insertReq("dave")
+		mock.ExpectExec(deleteExpiredSQL).
+			WithArgs("tester", "key-1", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(reserveSQL).
+			WithArgs("tester", "key-1", requestHash(req), "users", sqlmock.AnyArg(), expiresIn(getIdempotencyLease())).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectBegin()
+		mock.ExpectExec(insertSQL).
+			WithArgs(int32(4), "dave", nil, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec(completeSQL).
+			WithArgs(http.StatusOK, sqlmock.AnyArg(), expiresIn(getIdempotencyTTL()), "tester", "key-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		status, resp := runJimo(t, testUser(), req)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if resp.Meta["idempotent_replay"] != nil {
+			t.Errorf("meta = %v, want no replay", resp.Meta)
+		}
+	})
+
+	t.Run("failed insert releases key", func(t *testing.T) {
+		mock := install(t)
+		mock.ExpectExec(deleteExpiredSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(reserveSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectBegin()
+		mock.ExpectExec(insertSQL).
+			WithArgs(int32(4), "dave", nil, nil).
+			WillReturnError(errNoRelation)
+		mock.ExpectRollback()
+		mock.ExpectExec(releaseSQL).
+			WithArgs("tester", "key-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		status, resp := runJimo(t, testUser(), insertReq("dave"))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_DBError, "does not exist")
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		mock := install(t)
+		req := insertReq("dave")
+		expectExisting(mock, requestHash(req), http.StatusOK,
+			`{"status":true,"req_id":"first-req","result_type":"none"}`)
+
+		status, resp := runJimo(t, testUser(), req)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if resp.Meta["idempotent_replay"] != true {
+			t.Errorf("meta = %v, want idempotent_replay", resp.Meta)
+		}
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		mock := install(t)
+		req := insertReq("dave")
+		expectExisting(mock, requestHash(req), 0, nil)
+
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, http.StatusConflict, ApiTypes.ErrorKind_Conflict, "in progress")
+	})
+
+	t.Run("key reused for another request", func(t *testing.T) {
+		mock := install(t)
+		expectExisting(mock, requestHash(insertReq("erin")), http.StatusOK, `{"status":true}`)
+
+		status, resp := runJimo(t, testUser(), insertReq("dave"))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_Conflict, "different request")
+	})
+
+	// The outcome is recorded, or the key released, even if the client
+	// disconnected and its request context was cancelled
+	t.Run("client gone", func(t *testing.T) {
+		mock := install(t)
+		mock.ExpectExec(completeSQL).
+			WithArgs(http.StatusOK, sqlmock.AnyArg(), sqlmock.AnyArg(), "tester", "key-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(releaseSQL).
+			WithArgs("tester", "key-2").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		rc := testharness.NewFakeRequestContext(t, testUser())
+		ctx, cancel := context.WithCancel(rc.Ctx)
+		cancel()
+		rc.Ctx = ctx
+		finishIdempotentInsert(rc, "key-1", "tester", http.StatusOK, ApiTypes.JimoResponse{Status: true})
+		finishIdempotentInsert(rc, "key-2", "tester", ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.JimoResponse{})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package RequestHandlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+var isolationLevels = map[string]sql.IsolationLevel{
+	ApiTypes.IsolationLevel_ReadUncommitted: sql.LevelReadUncommitted,
+	ApiTypes.IsolationLevel_ReadCommitted:   sql.LevelReadCommitted,
+	ApiTypes.IsolationLevel_RepeatableRead:  sql.LevelRepeatableRead,
+	ApiTypes.IsolationLevel_Serializable:    sql.LevelSerializable,
+}
+
+// txIsolation returns the isolation level 'level' (one of
+// ApiTypes.IsolationLevel_*) of a transaction on 'db_type', or
+// 'default_level' if 'level' is empty. PostgreSQL has no read uncommitted
+// (it runs it as read committed), so it is rejected there rather than
+// silently upgraded.
+func txIsolation(level string, db_type string, default_level sql.IsolationLevel) (sql.IsolationLevel, error) {
+	if level == "" {
+		return default_level, nil
+	}
+	isolation, ok := isolationLevels[level]
+	if !ok {
+		return 0, fmt.Errorf("unsupported isolation_level %q, use read_committed, repeatable_read "+
+			"or serializable (SHD_RHD_1647)", level)
+	}
+	if isolation == sql.LevelReadUncommitted && db_type != ApiTypes.MysqlName {
+		return 0, fmt.Errorf("isolation_level read_uncommitted is only supported on MySQL (SHD_RHD_1648)")
+	}
+	return isolation, nil
+}
+
+// queryIsolation returns the isolation level of the transaction of a
+// query WithTotal. Only repeatable read and serializable read the count
+// and the page from one snapshot, and a query without a total runs one
+// statement outside of a transaction, so other combinations are rejected.
+func queryIsolation(req ApiTypes.QueryRequest, db_type string) (sql.IsolationLevel, error) {
+	if req.IsolationLevel == "" {
+		return sql.LevelRepeatableRead, nil
+	}
+	if !req.WithTotal {
+		return 0, fmt.Errorf("isolation_level needs with_total: a query without a total is a single " +
+			"statement (SHD_RHD_1649)")
+	}
+	isolation, err := txIsolation(req.IsolationLevel, db_type, sql.LevelRepeatableRead)
+	if err != nil {
+		return 0, err
+	}
+	if isolation != sql.LevelRepeatableRead && isolation != sql.LevelSerializable {
+		return 0, fmt.Errorf("isolation_level %s would read the total and the page from different "+
+			"snapshots, use repeatable_read or serializable (SHD_RHD_1650)", req.IsolationLevel)
+	}
+	return isolation, nil
+}
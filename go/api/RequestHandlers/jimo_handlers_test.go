@@ -0,0 +1,1563 @@
+package RequestHandlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/testharness"
+	"github.com/lib/pq"
+)
+
+// errNoRelation is not retryable, so the handlers fail on the first attempt
+var errNoRelation = errors.New(`relation "users" does not exist`)
+
+// installUsers installs a test database seeded with the fixtures
+func installUsers(t *testing.T) *testharness.TestDB {
+	t.Helper()
+	tdb := testharness.NewTestDB(t)
+	testharness.LoadFixtures(t, tdb, usersFixture, ordersFixture)
+	tdb.Install(t)
+	return tdb
+}
+
+// installMock installs a sqlmock database, for the DB-error paths and the
+// paths that must not reach the database.
+func installMock(t *testing.T) *testharness.TestDB {
+	t.Helper()
+	tdb := testharness.NewMockDB(t)
+	tdb.Install(t)
+	return tdb
+}
+
+// countUsers returns the number of users matching 'where' (real databases
+// only).
+func countUsers(t *testing.T, tdb *testharness.TestDB, where string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := tdb.DB.QueryRow("SELECT COUNT(*) FROM users WHERE "+where, args...).Scan(&n); err != nil {
+		t.Fatalf("count users: %v", err)
+	}
+	return n
+}
+
+func expectFailure(t *testing.T, status int, resp ApiTypes.JimoResponse, wantStatus int,
+	wantKind ApiTypes.ErrorKind, wantMsg string) {
+	t.Helper()
+	if status != wantStatus {
+		t.Errorf("status = %d, want %d (error_msg: %s)", status, wantStatus, resp.ErrorMsg)
+	}
+	if resp.Status {
+		t.Errorf("response status = true, want false")
+	}
+	if resp.ErrorKind != wantKind {
+		t.Errorf("error_kind = %q, want %q (error_msg: %s)", resp.ErrorKind, wantKind, resp.ErrorMsg)
+	}
+	if !strings.Contains(resp.ErrorMsg, wantMsg) {
+		t.Errorf("error_msg = %q, want it to contain %q", resp.ErrorMsg, wantMsg)
+	}
+}
+
+func usersQuery(cond ApiTypes.CondDef) ApiTypes.QueryRequest {
+	return ApiTypes.QueryRequest{
+		RequestType: ApiTypes.ReqAction_Query,
+		TableName:   "users",
+		FieldDefs:   usersFieldDefs,
+		FieldNames:  []string{"users.id", "users.name", "users.email"},
+		Condition:   cond,
+		OrderbyDef:  []ApiTypes.OrderbyDef{{FieldName: "id", IsAsc: true}},
+		PageSize:    10,
+	}
+}
+
+func TestJimoRequest_Rejected(t *testing.T) {
+	installMock(t)
+	rc := testharness.NewFakeRequestContext(t, testUser())
+
+	t.Run("not logged in", func(t *testing.T) {
+		status, resp := runJimo(t, nil, usersQuery(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_NotLoggedIn, ApiTypes.ErrorKind_NotAuthenticated, "auth failed")
+	})
+
+	t.Run("auth function denies", func(t *testing.T) {
+		rc.AuthFunc = func() *ApiTypes.UserInfo { return nil }
+		status, resp := handleJimoRequestPriv(rc.Context(), rc, []byte(`{"request_type":"query"}`))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_NotLoggedIn, ApiTypes.ErrorKind_NotAuthenticated, "auth failed")
+		rc.AuthFunc = nil
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		status, resp := handleJimoRequestPriv(rc.Context(), rc, []byte(`{"request_type":`))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "failed parse request_type")
+	})
+
+	t.Run("unknown request type", func(t *testing.T) {
+		status, resp := runJimo(t, testUser(), ApiTypes.JimoRequest{RequestType: "upsert", TableName: "users"})
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "unrecognized request_type:upsert")
+	})
+}
+
+func TestHandleDBQuery(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectQuery("SELECT users.id, users.name, users.email FROM users WHERE id >= $1 ORDER BY id ASC LIMIT 11 OFFSET 0").
+				WithArgs(int64(2)).
+				WillReturnRows(usersFixture.MockRows([]string{"id", "name", "email"}, func(row map[string]interface{}) bool {
+					return row["id"].(int) >= 2
+				}))
+		}
+
+		status, resp := runJimo(t, testUser(), usersQuery(atomicCond("id", "int", GreaterEqual, 2)))
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		want := []map[string]interface{}{
+			{"id": 2, "name": "bob", "email": "bob@example.com"},
+			{"id": 3, "name": "carol", "email": "carol@example.com"},
+		}
+		if resp.NumRecords != len(want) {
+			t.Errorf("num_records = %d, want %d", resp.NumRecords, len(want))
+		}
+		if !reflect.DeepEqual(resp.Results, want) {
+			t.Errorf("results = %#v, want %#v", resp.Results, want)
+		}
+	})
+
+	t.Run("with total", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectBegin()
+			tdb.Mock.ExpectQuery("SELECT COUNT(*) FROM (SELECT users.id, users.name, users.email FROM users " +
+				"WHERE id >= $1) AS jimo_total").
+				WithArgs(int64(2)).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(2)))
+			tdb.Mock.ExpectQuery("SELECT users.id, users.name, users.email FROM users WHERE id >= $1 ORDER BY id ASC LIMIT 1 OFFSET 0").
+				WithArgs(int64(2)).
+				WillReturnRows(usersFixture.MockRows([]string{"id", "name", "email"}, func(row map[string]interface{}) bool {
+					return row["id"].(int) == 2
+				}))
+			tdb.Mock.ExpectCommit()
+		}
+
+		req := usersQuery(atomicCond("id", "int", GreaterEqual, 2))
+		req.PageSize = 1
+		req.WithTotal = true
+		status, resp := runJimo(t, testUser(), req)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if resp.NumRecords != 1 {
+			t.Errorf("num_records = %d, want 1", resp.NumRecords)
+		}
+		if resp.TotalRecords == nil || *resp.TotalRecords != 2 {
+			t.Errorf("total_records = %v, want 2", resp.TotalRecords)
+		}
+		if resp.Page != 1 || resp.PageSize != 1 || resp.TotalPages == nil || *resp.TotalPages != 2 ||
+			resp.HasMore == nil || !*resp.HasMore {
+			t.Errorf("page = %d, page_size = %d, total_pages = %v, has_more = %v, want 1, 1, 2, true",
+				resp.Page, resp.PageSize, resp.TotalPages, resp.HasMore)
+		}
+	})
+
+	t.Run("has more", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			// One row more than the page size
+			tdb.Mock.ExpectQuery("SELECT users.id, users.name, users.email FROM users WHERE id >= $1 ORDER BY id ASC LIMIT 2 OFFSET 1").
+				WithArgs(int64(1)).
+				WillReturnRows(usersFixture.MockRows([]string{"id", "name", "email"}, func(row map[string]interface{}) bool {
+					return row["id"].(int) >= 2
+				}))
+			tdb.Mock.ExpectQuery("SELECT users.id, users.name, users.email FROM users WHERE id >= $1 ORDER BY id ASC LIMIT 2 OFFSET 2").
+				WithArgs(int64(1)).
+				WillReturnRows(usersFixture.MockRows([]string{"id", "name", "email"}, func(row map[string]interface{}) bool {
+					return row["id"].(int) >= 3
+				}))
+		}
+
+		req := usersQuery(atomicCond("id", "int", GreaterEqual, 1))
+		req.PageSize = 1
+		req.Start = 1
+		status, resp := runJimo(t, testUser(), req)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		want := []map[string]interface{}{{"id": 2, "name": "bob", "email": "bob@example.com"}}
+		if resp.NumRecords != 1 || !reflect.DeepEqual(resp.Results, want) {
+			t.Errorf("num_records = %d, results = %#v, want %#v", resp.NumRecords, resp.Results, want)
+		}
+		if resp.Page != 2 || resp.PageSize != 1 || resp.TotalPages != nil || resp.HasMore == nil || !*resp.HasMore {
+			t.Errorf("page = %d, page_size = %d, total_pages = %v, has_more = %v, want 2, 1, nil, true",
+				resp.Page, resp.PageSize, resp.TotalPages, resp.HasMore)
+		}
+
+		// The last page
+		req.Start = 2
+		status, resp = runJimo(t, testUser(), req)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if resp.NumRecords != 1 || resp.Page != 3 || resp.HasMore == nil || *resp.HasMore {
+			t.Errorf("last page: num_records = %d, page = %d, has_more = %v, want 1, 3, false",
+				resp.NumRecords, resp.Page, resp.HasMore)
+		}
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		installMock(t)
+
+		req := usersQuery(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull})
+		req.TableName = ""
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_InvalidRequest, "missing table name")
+
+		req = usersQuery(atomicCond("password", "string", Equal, "x"))
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_InvalidRequest, "invalid field name: password")
+
+		req = usersQuery(atomicCond("id", "int", Equal, "abc"))
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "invalid condition value for field id")
+
+		req = usersQuery(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull})
+		req.PageSize = 0
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_InvalidRequest, "invalid limit clause")
+
+		req = usersQuery(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull})
+		req.OrderbyDef = nil
+		req.Sample = 2
+		req.WithTotal = true
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "cannot be combined with with_total")
+
+		req = usersQuery(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull})
+		order_join := ApiTypes.JoinDef{
+			FromTableName:   "users",
+			JoinedTableName: "orders",
+			OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+			JoinType:        ApiTypes.JoinTypeJoin,
+		}
+		req.JoinDefs = []ApiTypes.JoinDef{order_join, order_join}
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "table orders is joined more than once")
+
+		// users.id and orders.id would both be returned as "id"
+		order_join.SelectedFields = []string{"orders.id"}
+		order_join.JoinedFieldDefs = ordersFieldDefs
+		req.JoinDefs = []ApiTypes.JoinDef{order_join}
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest,
+			`duplicate result field "id": selected by users.id and orders.id`)
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectQuery("SELECT users.id, users.name, users.email FROM users ORDER BY id ASC LIMIT 11 OFFSET 0").
+			WillReturnError(errNoRelation)
+
+		status, resp := runJimo(t, testUser(), usersQuery(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_DBError, "does not exist")
+	})
+
+	t.Run("statement timeout", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectQuery("SELECT users.id, users.name, users.email FROM users ORDER BY id ASC LIMIT 11 OFFSET 0").
+			WillReturnError(&pq.Error{Code: "57014", Message: "canceling statement due to statement timeout"})
+
+		status, resp := runJimo(t, testUser(), usersQuery(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_Timeout, "statement timeout")
+	})
+}
+
+func TestHandleDBQueryEmbeds(t *testing.T) {
+	const query = "SELECT users.id, users.name, orders.id, orders.amount FROM users " +
+		"LEFT JOIN orders ON users.id = orders.user_id WHERE users.id >= $1 ORDER BY users.id ASC, orders.id ASC LIMIT 11 OFFSET 0"
+	embedQuery := func() ApiTypes.QueryRequest {
+		req := usersQuery(atomicCond("users.id", "int", GreaterEqual, 2))
+		req.FieldNames = []string{"users.id", "users.name"}
+		req.OrderbyDef = []ApiTypes.OrderbyDef{{FieldName: "users.id", IsAsc: true}, {FieldName: "orders.id", IsAsc: true}}
+		req.JoinDefs = []ApiTypes.JoinDef{{
+			FromTableName:   "users",
+			JoinedTableName: "orders",
+			OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+			JoinType:        ApiTypes.JoinTypeLeftJoin,
+			SelectedFields:  []string{"orders.id", "orders.amount"},
+			JoinedFieldDefs: ordersFieldDefs,
+			EmbedName:       "order",
+		}}
+		return req
+	}
+	run := func(t *testing.T, req ApiTypes.QueryRequest) []map[string]interface{} {
+		t.Helper()
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectQuery(query).
+				WithArgs(int64(2)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "id", "amount"}).
+					AddRow(2, "bob", 12, 120).
+					AddRow(2, "bob", 13, nil).
+					AddRow(3, "carol", nil, nil))
+		}
+		status, resp := runJimo(t, testUser(), req)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		results, _ := resp.Results.([]map[string]interface{})
+		return results
+	}
+
+	// bob's orders match, one without an amount; carol has none
+	t.Run("no match is null", func(t *testing.T) {
+		want := []map[string]interface{}{
+			{"id": 2, "name": "bob", "order": map[string]interface{}{"id": 12, "amount": 120}},
+			{"id": 2, "name": "bob", "order": map[string]interface{}{"id": 13, "amount": nil}},
+			{"id": 3, "name": "carol", "order": nil},
+		}
+		if got := run(t, embedQuery()); !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("no match as empty object", func(t *testing.T) {
+		req := embedQuery()
+		req.EmptyEmbedAsObject = true
+		got := run(t, req)
+		if len(got) != 3 {
+			t.Fatalf("results = %#v, want 3 rows", got)
+		}
+		if want := map[string]interface{}{}; !reflect.DeepEqual(got[2]["order"], want) {
+			t.Errorf("carol's order = %#v, want %#v", got[2]["order"], want)
+		}
+		if want := map[string]interface{}{"id": 13, "amount": nil}; !reflect.DeepEqual(got[1]["order"], want) {
+			t.Errorf("partial order = %#v, want %#v", got[1]["order"], want)
+		}
+	})
+}
+
+// sortEmbedByID sorts the objects of the array embed 'embed' of 'rows' by
+// id: a database aggregates them in no particular order
+func sortEmbedByID(rows []map[string]interface{}, embed string) {
+	for _, row := range rows {
+		if objs, ok := row[embed].([]map[string]interface{}); ok {
+			sort.Slice(objs, func(i, j int) bool { return objs[i]["id"].(int) < objs[j]["id"].(int) })
+		}
+	}
+}
+
+func TestHandleDBQueryArrayEmbed(t *testing.T) {
+	const query = "SELECT users.id, users.name, " +
+		"COALESCE(json_agg(json_build_object('id', orders.id, 'amount', orders.amount)) " +
+		"FILTER (WHERE orders.user_id IS NOT NULL), '[]') " +
+		"FROM users LEFT JOIN orders ON users.id = orders.user_id WHERE users.id >= $1 " +
+		"GROUP BY users.id, users.name ORDER BY users.id ASC LIMIT 11 OFFSET 0"
+	req := usersQuery(atomicCond("users.id", "int", GreaterEqual, 2))
+	req.FieldNames = []string{"users.id", "users.name"}
+	req.OrderbyDef = []ApiTypes.OrderbyDef{{FieldName: "users.id", IsAsc: true}}
+	req.JoinDefs = []ApiTypes.JoinDef{arrayEmbedJoinDef("users.id")}
+
+	tdb := installUsers(t)
+	if tdb.IsMock() {
+		tdb.Mock.ExpectQuery(query).
+			WithArgs(int64(2)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "orders"}).
+				AddRow(2, "bob", []byte(`[{"id": 12, "amount": 120}, {"id": 13, "amount": null}]`)).
+				AddRow(3, "carol", []byte("[]")))
+	}
+	status, resp := runJimo(t, testUser(), req)
+	if status != http.StatusOK || !resp.Status {
+		t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+	}
+
+	// One row per user, with bob's orders nested and carol's empty
+	got, _ := resp.Results.([]map[string]interface{})
+	sortEmbedByID(got, "orders")
+	want := []map[string]interface{}{
+		{"id": 2, "name": "bob", "orders": []map[string]interface{}{
+			{"id": 12, "amount": 120},
+			{"id": 13, "amount": nil},
+		}},
+		{"id": 3, "name": "carol", "orders": []map[string]interface{}{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %#v, want %#v", got, want)
+	}
+
+	t.Run("missing group by", func(t *testing.T) {
+		installMock(t)
+		bad := req
+		bad.JoinDefs = []ApiTypes.JoinDef{arrayEmbedJoinDef()}
+		status, resp := runJimo(t, testUser(), bad)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest,
+			ApiTypes.ErrorKind_InvalidRequest, "embed_as_array needs group_by")
+	})
+
+	// An embedded field has a value per joined row, not per user
+	t.Run("order by an embedded field", func(t *testing.T) {
+		installMock(t)
+		for _, field := range []string{"orders.amount", "orders____amount"} {
+			bad := req
+			bad.OrderbyDef = []ApiTypes.OrderbyDef{{FieldName: field}}
+			status, resp := runJimo(t, testUser(), bad)
+			expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest,
+				ApiTypes.ErrorKind_InvalidRequest, "embedded as an array")
+		}
+	})
+}
+
+func TestHandleDBQueryOrderByJoinedField(t *testing.T) {
+	joinedQuery := func(order_by string) ApiTypes.QueryRequest {
+		req := usersQuery(atomicCond("orders.amount", "int", GreaterEqual, 75))
+		req.FieldNames = []string{"users.id", "users.name"}
+		req.OrderbyDef = []ApiTypes.OrderbyDef{{FieldName: order_by}}
+		req.JoinDefs = []ApiTypes.JoinDef{{
+			FromTableName:   "users",
+			JoinedTableName: "orders",
+			OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+			JoinType:        ApiTypes.JoinTypeJoin,
+			SelectedFields:  []string{"orders.amount:order_amount"},
+			JoinedFieldDefs: ordersFieldDefs,
+		}}
+		return req
+	}
+	want := []map[string]interface{}{
+		{"id": 1, "name": "alice", "order_amount": 250},
+		{"id": 2, "name": "bob", "order_amount": 120},
+		{"id": 1, "name": "alice", "order_amount": 75},
+	}
+
+	// By the alias of the joined column, or by its qualified name
+	for _, order_by := range []string{"order_amount", "orders.amount"} {
+		t.Run(order_by, func(t *testing.T) {
+			tdb := installUsers(t)
+			if tdb.IsMock() {
+				tdb.Mock.ExpectQuery("SELECT users.id, users.name, orders.amount FROM users " +
+					"JOIN orders ON users.id = orders.user_id WHERE orders.amount >= $1 " +
+					"ORDER BY orders.amount DESC LIMIT 11 OFFSET 0").
+					WithArgs(int64(75)).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}).
+						AddRow(1, "alice", 250).
+						AddRow(2, "bob", 120).
+						AddRow(1, "alice", 75))
+			}
+			status, resp := runJimo(t, testUser(), joinedQuery(order_by))
+			if status != http.StatusOK || !resp.Status {
+				t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+			}
+			if !reflect.DeepEqual(resp.Results, want) {
+				t.Errorf("results = %#v, want %#v", resp.Results, want)
+			}
+		})
+	}
+
+	// "email" would be the alias of orders.amount and the column users.email
+	t.Run("ambiguous alias", func(t *testing.T) {
+		installMock(t)
+		req := joinedQuery("email")
+		req.JoinDefs[0].SelectedFields = []string{"orders.amount:email"}
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest,
+			`invalid order-by field "email": ambiguous`)
+	})
+}
+
+func TestHandleDBQueryNoLimit(t *testing.T) {
+	req := usersQuery(atomicCond("id", "int", GreaterEqual, 1))
+	req.PageSize = ApiTypes.PageSizeNoLimit
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+
+	t.Run("internal caller", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectQuery("SELECT users.id, users.name, users.email FROM users WHERE id >= $1 ORDER BY id ASC").
+				WithArgs(int64(1)).
+				WillReturnRows(usersFixture.MockRows([]string{"id", "name", "email"}, nil))
+		}
+		rc := testharness.NewFakeRequestContext(t, testUser())
+		status, resp := handleJimoRequestPriv(WithInternalCaller(rc.Context()), rc, body)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if resp.NumRecords != 3 || resp.HasMore != nil || resp.PageSize != 0 {
+			t.Errorf("num_records = %d, has_more = %v, page_size = %d, want 3, nil, 0",
+				resp.NumRecords, resp.HasMore, resp.PageSize)
+		}
+	})
+
+	// Not from an HTTP request
+	t.Run("http request", func(t *testing.T) {
+		installMock(t)
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_InvalidRequest,
+			"invalid limit clause")
+	})
+}
+
+func TestHandleDBQueryIsolationLevel(t *testing.T) {
+	t.Run("serializable", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectBegin()
+			tdb.Mock.ExpectQuery("SELECT COUNT(*) FROM (SELECT users.id, users.name, users.email FROM users " +
+				"WHERE id >= $1) AS jimo_total").
+				WithArgs(int64(2)).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(2)))
+			tdb.Mock.ExpectQuery("SELECT users.id, users.name, users.email FROM users WHERE id >= $1 ORDER BY id ASC LIMIT 10 OFFSET 0").
+				WithArgs(int64(2)).
+				WillReturnRows(usersFixture.MockRows([]string{"id", "name", "email"}, func(row map[string]interface{}) bool {
+					return row["id"].(int) >= 2
+				}))
+			tdb.Mock.ExpectCommit()
+		}
+
+		req := usersQuery(atomicCond("id", "int", GreaterEqual, 2))
+		req.WithTotal = true
+		req.IsolationLevel = ApiTypes.IsolationLevel_Serializable
+		status, resp := runJimo(t, testUser(), req)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if resp.NumRecords != 2 || resp.TotalRecords == nil || *resp.TotalRecords != 2 {
+			t.Errorf("num_records = %d, total_records = %v, want 2, 2", resp.NumRecords, resp.TotalRecords)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		installMock(t)
+		for _, tt := range []struct {
+			level      string
+			with_total bool
+			want       string
+		}{
+			{ApiTypes.IsolationLevel_RepeatableRead, false, "needs with_total"},
+			{ApiTypes.IsolationLevel_ReadCommitted, true, "different snapshots"},
+			{ApiTypes.IsolationLevel_ReadUncommitted, true, "only supported on MySQL"},
+			{"snapshot", true, "unsupported isolation_level"},
+		} {
+			req := usersQuery(atomicCond("id", "int", GreaterEqual, 2))
+			req.WithTotal = tt.with_total
+			req.IsolationLevel = tt.level
+			status, resp := runJimo(t, testUser(), req)
+			expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, tt.want)
+		}
+	})
+}
+
+func TestHandleDBQueryFieldPolicies(t *testing.T) {
+	SetFieldPolicies(FieldPolicies{
+		"users":  {"email": {Roles: []string{"support"}}},
+		"orders": {"amount": {Action: FieldAction_Mask}},
+	})
+	t.Cleanup(func() { SetFieldPolicies(nil) })
+
+	run := func(t *testing.T, user *ApiTypes.UserInfo, req ApiTypes.QueryRequest,
+		query string, min_id int64, rows *sqlmock.Rows) []map[string]interface{} {
+		t.Helper()
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectQuery(query).WithArgs(min_id).WillReturnRows(rows)
+		}
+		status, resp := runJimo(t, user, req)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		results, _ := resp.Results.([]map[string]interface{})
+		return results
+	}
+
+	const usersSQL = "SELECT users.id, users.name, users.email FROM users WHERE id >= $1 ORDER BY id ASC LIMIT 11 OFFSET 0"
+	usersRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(3, "carol", "carol@example.com")
+	}
+	admin := testUser()
+	admin.Admin = true
+	support := testUser()
+	support.Roles = []string{"support"}
+	tests := []struct {
+		name string
+		user *ApiTypes.UserInfo
+		want map[string]interface{}
+	}{
+		{"dropped for users", testUser(), map[string]interface{}{"id": 3, "name": "carol"}},
+		{"seen by admins", admin, map[string]interface{}{"id": 3, "name": "carol", "email": "carol@example.com"}},
+		{"seen by role", support, map[string]interface{}{"id": 3, "name": "carol", "email": "carol@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := run(t, tt.user, usersQuery(atomicCond("id", "int", GreaterEqual, 3)), usersSQL, 3, usersRows())
+			if want := []map[string]interface{}{tt.want}; !reflect.DeepEqual(got, want) {
+				t.Errorf("results = %#v, want %#v", got, want)
+			}
+		})
+	}
+
+	// Embedded fields are masked inside their embed; nulls stay null
+	t.Run("masked in embed", func(t *testing.T) {
+		const query = "SELECT users.id, orders.id, orders.amount FROM users " +
+			"LEFT JOIN orders ON users.id = orders.user_id WHERE users.id >= $1 ORDER BY users.id ASC, orders.id ASC LIMIT 11 OFFSET 0"
+		req := usersQuery(atomicCond("users.id", "int", GreaterEqual, 2))
+		req.FieldNames = []string{"users.id"}
+		req.OrderbyDef = []ApiTypes.OrderbyDef{{FieldName: "users.id", IsAsc: true}, {FieldName: "orders.id", IsAsc: true}}
+		req.JoinDefs = []ApiTypes.JoinDef{{
+			FromTableName:   "users",
+			JoinedTableName: "orders",
+			OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "user_id"}},
+			JoinType:        ApiTypes.JoinTypeLeftJoin,
+			SelectedFields:  []string{"orders.id", "orders.amount"},
+			JoinedFieldDefs: ordersFieldDefs,
+			EmbedName:       "order",
+		}}
+		got := run(t, testUser(), req, query, 2, sqlmock.NewRows([]string{"id", "id", "amount"}).
+			AddRow(2, 12, 120).
+			AddRow(2, 13, nil).
+			AddRow(3, nil, nil))
+		want := []map[string]interface{}{
+			{"id": 2, "order": map[string]interface{}{"id": 12, "amount": MaskedFieldValue}},
+			{"id": 2, "order": map[string]interface{}{"id": 13, "amount": nil}},
+			{"id": 3, "order": nil},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("masked in array embed", func(t *testing.T) {
+		const query = "SELECT users.id, " +
+			"COALESCE(json_agg(json_build_object('id', orders.id, 'amount', orders.amount)) " +
+			"FILTER (WHERE orders.user_id IS NOT NULL), '[]') " +
+			"FROM users LEFT JOIN orders ON users.id = orders.user_id WHERE users.id >= $1 " +
+			"GROUP BY users.id ORDER BY users.id ASC LIMIT 11 OFFSET 0"
+		req := usersQuery(atomicCond("users.id", "int", GreaterEqual, 2))
+		req.FieldNames = []string{"users.id"}
+		req.OrderbyDef = []ApiTypes.OrderbyDef{{FieldName: "users.id", IsAsc: true}}
+		req.JoinDefs = []ApiTypes.JoinDef{arrayEmbedJoinDef("users.id")}
+		got := run(t, testUser(), req, query, 2, sqlmock.NewRows([]string{"id", "orders"}).
+			AddRow(2, []byte(`[{"id": 12, "amount": 120}, {"id": 13, "amount": null}]`)).
+			AddRow(3, []byte("[]")))
+		sortEmbedByID(got, "orders")
+		want := []map[string]interface{}{
+			{"id": 2, "orders": []map[string]interface{}{
+				{"id": 12, "amount": MaskedFieldValue},
+				{"id": 13, "amount": nil},
+			}},
+			{"id": 3, "orders": []map[string]interface{}{}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %#v, want %#v", got, want)
+		}
+	})
+
+	// Hidden fields can't be filtered, sorted or joined on, or their
+	// values could be recovered one prefix at a time
+	t.Run("hidden fields in conditions", func(t *testing.T) {
+		byEmail := usersQuery(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeAnd, Conditions: []ApiTypes.CondDef{
+			atomicCond("id", "int", GreaterEqual, 1),
+			atomicCond("email", "string", Prefix, "a"),
+		}})
+		byEmailAlias := usersQuery(atomicCond("id", "int", GreaterEqual, 1))
+		byEmailAlias.OrderbyDef = []ApiTypes.OrderbyDef{{FieldName: "email", IsAsc: true}}
+		byAmount := usersQuery(atomicCond("users.id", "int", GreaterEqual, 2))
+		byAmount.FieldNames = []string{"users.id"}
+		byAmount.JoinDefs = []ApiTypes.JoinDef{{
+			FromTableName:   "users",
+			JoinedTableName: "orders",
+			OnClause:        []ApiTypes.OnClauseDef{{SourceFieldName: "id", JoinedFieldName: "amount"}},
+			JoinType:        ApiTypes.JoinTypeLeftJoin,
+			SelectedFields:  []string{"orders.id"},
+			JoinedFieldDefs: ordersFieldDefs,
+			EmbedName:       "order",
+		}}
+		byAmount.OrderbyDef = []ApiTypes.OrderbyDef{{FieldName: "users.id", IsAsc: true}}
+		withBigOrder := usersQuery(ordersExists(ApiTypes.ConditionTypeExists,
+			atomicCond("amount", "int", GreaterThan, 100)))
+		ownsOrderByAmount := usersQuery(ordersExists(ApiTypes.ConditionTypeExists))
+		ownsOrderByAmount.Condition.SubQuery.OnClause[0].JoinedFieldName = "amount"
+		for name, tc := range map[string]struct {
+			req     ApiTypes.QueryRequest
+			wantMsg string
+		}{
+			"condition":           {byEmail, "no access to field users.email: it can't be used in conditions"},
+			"order by":            {byEmailAlias, "no access to field users.email: it can't be used in order by"},
+			"join":                {byAmount, "no access to field orders.amount: it can't be used in joins"},
+			"sub-query condition": {withBigOrder, "no access to field orders.amount: it can't be used in conditions"},
+			"sub-query on_clause": {ownsOrderByAmount, "no access to field orders.amount: it can't be used in conditions"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				installUsers(t)
+				status, resp := runJimo(t, testUser(), tc.req)
+				expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest,
+					ApiTypes.ErrorKind_InvalidRequest, tc.wantMsg)
+			})
+		}
+
+		// Users who can see the field may filter on it
+		const query = "SELECT users.id, users.name, users.email FROM users " +
+			"WHERE (id >= $1 AND email LIKE $2) ORDER BY id ASC LIMIT 11 OFFSET 0"
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectQuery(query).WithArgs(1, "a%").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).AddRow(1, "alice", "alice@example.com"))
+		}
+		status, resp := runJimo(t, support, byEmail)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if resp.NumRecords != 1 {
+			t.Errorf("num_records = %d, want 1", resp.NumRecords)
+		}
+	})
+}
+
+func TestHandleDBQuerySample(t *testing.T) {
+	sampleQuery := func(sample int) ApiTypes.QueryRequest {
+		req := usersQuery(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull})
+		req.OrderbyDef = nil
+		req.PageSize = 0
+		req.Sample = sample
+		return req
+	}
+	const estimateSQL = "SELECT reltuples::bigint FROM pg_class WHERE oid = to_regclass($1)"
+	allRows := func(map[string]interface{}) bool { return true }
+
+	t.Run("small table sorts by random", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectQuery(estimateSQL).
+				WithArgs("users").
+				WillReturnRows(sqlmock.NewRows([]string{"reltuples"}).AddRow(int64(3)))
+			tdb.Mock.ExpectQuery("SELECT users.id, users.name, users.email FROM users ORDER BY random() LIMIT 2").
+				WillReturnRows(usersFixture.MockRows([]string{"id", "name", "email"}, func(row map[string]interface{}) bool {
+					return row["id"].(int) != 2
+				}))
+		}
+
+		status, resp := runJimo(t, testUser(), sampleQuery(2))
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if resp.NumRecords != 2 {
+			t.Errorf("num_records = %d, want 2", resp.NumRecords)
+		}
+		if resp.Meta["sample_method"] != SampleMethodOrderByRandom {
+			t.Errorf("sample_method = %v, want %s", resp.Meta["sample_method"], SampleMethodOrderByRandom)
+		}
+		if resp.Meta["sample_note"] == nil {
+			t.Errorf("missing sample_note in meta: %v", resp.Meta)
+		}
+	})
+
+	t.Run("large table uses tablesample", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectQuery(estimateSQL).
+			WithArgs("users").
+			WillReturnRows(sqlmock.NewRows([]string{"reltuples"}).AddRow(int64(1000000)))
+		tdb.Mock.ExpectQuery("SELECT users.id, users.name, users.email FROM users TABLESAMPLE SYSTEM (0.0020) " +
+			"ORDER BY random() LIMIT 2").
+			WillReturnRows(usersFixture.MockRows([]string{"id", "name", "email"}, allRows))
+
+		status, resp := runJimo(t, testUser(), sampleQuery(2))
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if resp.Meta["sample_method"] != SampleMethodTablesample {
+			t.Errorf("sample_method = %v, want %s", resp.Meta["sample_method"], SampleMethodTablesample)
+		}
+		if resp.Meta["estimated_rows"] != int64(1000000) {
+			t.Errorf("estimated_rows = %v, want 1000000", resp.Meta["estimated_rows"])
+		}
+	})
+
+	t.Run("mysql sorts by rand", func(t *testing.T) {
+		tdb := installMock(t)
+		saved := ApiTypes.DBType
+		ApiTypes.DBType = ApiTypes.MysqlName
+		t.Cleanup(func() { ApiTypes.DBType = saved })
+		tdb.Mock.ExpectQuery("SELECT users.id, users.name, users.email FROM users ORDER BY RAND() LIMIT 2").
+			WillReturnRows(usersFixture.MockRows([]string{"id", "name", "email"}, allRows))
+
+		status, resp := runJimo(t, testUser(), sampleQuery(2))
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if resp.Meta["sample_method"] != SampleMethodOrderByRand {
+			t.Errorf("sample_method = %v, want %s", resp.Meta["sample_method"], SampleMethodOrderByRand)
+		}
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		installMock(t)
+
+		req := sampleQuery(2)
+		req.Start = 10
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "cannot be combined with pagination")
+
+		req = sampleQuery(2)
+		req.OrderbyDef = []ApiTypes.OrderbyDef{{FieldName: "id", IsAsc: true}}
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "cannot be combined with orderby_def")
+
+		status, resp = runJimo(t, testUser(), sampleQuery(maxSampleSize+1))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "exceeds the maximum")
+
+		status, resp = runJimo(t, testUser(), sampleQuery(-1))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "invalid sample")
+	})
+}
+
+func TestHandleDBQueryExplain(t *testing.T) {
+	admin := testUser()
+	admin.Admin = true
+	explainQuery := func() ApiTypes.QueryRequest {
+		req := usersQuery(atomicCond("id", "int", GreaterEqual, 2))
+		req.Explain = true
+		return req
+	}
+
+	t.Run("pg", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectQuery("EXPLAIN (FORMAT JSON) SELECT users.id, users.name, users.email FROM users " +
+			"WHERE id >= $1 ORDER BY id ASC LIMIT 11 OFFSET 0").
+			WithArgs(int64(2)).
+			WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`[{"Plan": {"Node Type": "Seq Scan"}}]`))
+
+		status, resp := runJimo(t, admin, explainQuery())
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		want := []interface{}{map[string]interface{}{"Plan": map[string]interface{}{"Node Type": "Seq Scan"}}}
+		if !reflect.DeepEqual(resp.Results, want) {
+			t.Errorf("results = %#v, want %#v", resp.Results, want)
+		}
+		if resp.Meta["explain"] != true || !strings.HasPrefix(resp.Meta["query"].(string), "SELECT users.id") {
+			t.Errorf("meta = %v", resp.Meta)
+		}
+	})
+
+	t.Run("mysql", func(t *testing.T) {
+		tdb := installMock(t)
+		saved := ApiTypes.DBType
+		ApiTypes.DBType = ApiTypes.MysqlName
+		t.Cleanup(func() { ApiTypes.DBType = saved })
+		tdb.Mock.ExpectQuery("EXPLAIN FORMAT=JSON SELECT users.id, users.name, users.email FROM users " +
+			"WHERE id >= $1 ORDER BY id ASC LIMIT 11 OFFSET 0").
+			WithArgs(int64(2)).
+			WillReturnRows(sqlmock.NewRows([]string{"EXPLAIN"}).AddRow(`{"query_block": {"select_id": 1}}`))
+
+		status, resp := runJimo(t, admin, explainQuery())
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if plan, _ := resp.Results.(map[string]interface{}); plan["query_block"] == nil {
+			t.Errorf("results = %#v", resp.Results)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		installMock(t)
+
+		status, resp := runJimo(t, testUser(), explainQuery())
+		expectFailure(t, status, resp, http.StatusForbidden, ApiTypes.ErrorKind_InvalidRequest, "Admin access required")
+
+		req := explainQuery()
+		req.TimeBucket = &ApiTypes.TimeBucketDef{FieldName: "created_at", Interval: "day"}
+		status, resp = runJimo(t, admin, req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "cannot be combined with time_bucket")
+	})
+}
+
+func TestHandleDBQueryTimeBucket(t *testing.T) {
+	bucketQuery := func(tb ApiTypes.TimeBucketDef) ApiTypes.QueryRequest {
+		req := usersQuery(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull})
+		req.OrderbyDef = nil
+		req.PageSize = 0
+		req.TimeBucket = &tb
+		return req
+	}
+	bucketRows := func(rows ...interface{}) *sqlmock.Rows {
+		mock_rows := sqlmock.NewRows([]string{"bucket", "value"})
+		for i := 0; i < len(rows); i += 2 {
+			mock_rows.AddRow(rows[i], rows[i+1])
+		}
+		return mock_rows
+	}
+	expectBuckets := func(t *testing.T, resp ApiTypes.JimoResponse, want []map[string]interface{}) {
+		t.Helper()
+		if got, _ := resp.Results.([]map[string]interface{}); !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %v, want %v", resp.Results, want)
+		}
+	}
+
+	t.Run("count per hour", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectQuery("SELECT date_trunc('hour', created_at AT TIME ZONE 'UTC' AT TIME ZONE $1) AS bucket, " +
+				"COUNT(*) AS value FROM users GROUP BY 1 ORDER BY 1 LIMIT 10001").
+				WithArgs("UTC").
+				WillReturnRows(bucketRows(
+					fixtureCreatedAt.Truncate(time.Hour), int64(1),
+					fixtureCreatedAt.Truncate(time.Hour).Add(time.Hour), int64(1),
+					fixtureCreatedAt.Truncate(time.Hour).Add(2*time.Hour), int64(1)))
+		}
+
+		status, resp := runJimo(t, testUser(), bucketQuery(ApiTypes.TimeBucketDef{FieldName: "created_at", Interval: "hour"}))
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		expectBuckets(t, resp, []map[string]interface{}{
+			{"bucket": "2024-01-02T03:00:00Z", "value": int64(1)},
+			{"bucket": "2024-01-02T04:00:00Z", "value": int64(1)},
+			{"bucket": "2024-01-02T05:00:00Z", "value": int64(1)},
+		})
+		if resp.Meta["time_bucket"] != "hour" || resp.Meta["agg"] != ApiTypes.TimeBucketAgg_Count {
+			t.Errorf("meta = %v", resp.Meta)
+		}
+	})
+
+	t.Run("fill gaps in time zone", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectQuery("SELECT date_trunc('day', created_at AT TIME ZONE 'UTC' AT TIME ZONE $1) AS bucket, "+
+			"COUNT(*) AS value FROM users WHERE id > $2 GROUP BY 1 ORDER BY 1 LIMIT 10001").
+			WithArgs("Asia/Tokyo", int64(0)).
+			WillReturnRows(bucketRows(
+				time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), int64(2),
+				time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC), int64(5)))
+
+		req := bucketQuery(ApiTypes.TimeBucketDef{FieldName: "created_at", Interval: "day", FillGaps: true})
+		req.Condition = atomicCond("id", "int", GreaterThan, 0)
+		req.TimeZone = "Asia/Tokyo"
+		status, resp := runJimo(t, testUser(), req)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		expectBuckets(t, resp, []map[string]interface{}{
+			{"bucket": "2024-01-01T00:00:00+09:00", "value": int64(2)},
+			{"bucket": "2024-01-02T00:00:00+09:00", "value": int64(0)},
+			{"bucket": "2024-01-03T00:00:00+09:00", "value": int64(0)},
+			{"bucket": "2024-01-04T00:00:00+09:00", "value": int64(5)},
+		})
+	})
+
+	t.Run("mysql sum per week", func(t *testing.T) {
+		tdb := installMock(t)
+		saved := ApiTypes.DBType
+		ApiTypes.DBType = ApiTypes.MysqlName
+		t.Cleanup(func() { ApiTypes.DBType = saved })
+		tdb.Mock.ExpectQuery("SELECT DATE_FORMAT(DATE_SUB(created_at, INTERVAL WEEKDAY(created_at) DAY), '%Y-%m-%d 00:00:00') AS bucket, " +
+			"COALESCE(SUM(amount), 0) AS value FROM orders GROUP BY 1 ORDER BY 1 LIMIT 10001").
+			WillReturnRows(bucketRows([]byte("2024-01-01 00:00:00"), float64(325)))
+
+		req := bucketQuery(ApiTypes.TimeBucketDef{
+			FieldName: "created_at", Interval: "week", Agg: ApiTypes.TimeBucketAgg_Sum, ValueField: "amount"})
+		req.TableName = "orders"
+		req.FieldDefs = append(ordersFieldDefs, ApiTypes.FieldDef{FieldName: "created_at", DataType: "datetime"})
+		status, resp := runJimo(t, testUser(), req)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		expectBuckets(t, resp, []map[string]interface{}{
+			{"bucket": "2024-01-01T00:00:00Z", "value": float64(325)},
+		})
+	})
+
+	t.Run("reserved names", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectQuery(`SELECT date_trunc('day', "end" AT TIME ZONE 'UTC' AT TIME ZONE $1) AS bucket, ` +
+			`COALESCE(SUM("range"), 0) AS value FROM "order" GROUP BY 1 ORDER BY 1 LIMIT 10001`).
+			WithArgs("UTC").
+			WillReturnRows(bucketRows(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), float64(3)))
+
+		req := bucketQuery(ApiTypes.TimeBucketDef{
+			FieldName: "end", Interval: "day", Agg: ApiTypes.TimeBucketAgg_Sum, ValueField: "range"})
+		req.TableName = "order"
+		req.FieldDefs = []ApiTypes.FieldDef{{FieldName: "end", DataType: "timestamp"}, {FieldName: "range", DataType: "int"}}
+		status, resp := runJimo(t, testUser(), req)
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		expectBuckets(t, resp, []map[string]interface{}{
+			{"bucket": "2024-01-01T00:00:00Z", "value": float64(3)},
+		})
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		installMock(t)
+
+		status, resp := runJimo(t, testUser(), bucketQuery(ApiTypes.TimeBucketDef{FieldName: "created_at", Interval: "year"}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "invalid time bucket interval")
+
+		status, resp = runJimo(t, testUser(), bucketQuery(ApiTypes.TimeBucketDef{FieldName: "name", Interval: "day"}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "is not a timestamp")
+
+		status, resp = runJimo(t, testUser(), bucketQuery(ApiTypes.TimeBucketDef{
+			FieldName: "created_at", Interval: "day", Agg: ApiTypes.TimeBucketAgg_Sum, ValueField: "name"}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "is not numeric")
+
+		req := bucketQuery(ApiTypes.TimeBucketDef{FieldName: "created_at", Interval: "day"})
+		req.OrderbyDef = []ApiTypes.OrderbyDef{{FieldName: "id", IsAsc: true}}
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "cannot be combined with orderby_def")
+	})
+	t.Run("hidden field in condition", func(t *testing.T) {
+		SetFieldPolicies(FieldPolicies{"users": {"email": {Roles: []string{"support"}}}})
+		t.Cleanup(func() { SetFieldPolicies(nil) })
+		installMock(t)
+
+		req := bucketQuery(ApiTypes.TimeBucketDef{FieldName: "created_at", Interval: "day"})
+		req.Condition = atomicCond("email", "string", Prefix, "a")
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest,
+			"no access to field users.email: it can't be used in conditions")
+	})
+}
+
+func TestHandleDBInsert(t *testing.T) {
+	insertReq := func(records ...map[string]interface{}) ApiTypes.InsertRequest {
+		return ApiTypes.InsertRequest{
+			RequestType: ApiTypes.ReqAction_Insert,
+			TableName:   "users",
+			FieldDefs:   usersFieldDefs,
+			Records:     records,
+		}
+	}
+	const insertSQL = "INSERT INTO users (id,name,email,created_at) VALUES ($1,$2,$3,$4)"
+
+	t.Run("success", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectBegin()
+			tdb.Mock.ExpectExec(insertSQL).
+				WithArgs(int32(4), "dave", "dave@example.com", nil).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			tdb.Mock.ExpectCommit()
+		}
+
+		status, resp := runJimo(t, testUser(), insertReq(
+			map[string]interface{}{"id": 4, "name": "dave", "email": "dave@example.com"}))
+		if status != http.StatusOK || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		if !tdb.IsMock() {
+			if n := countUsers(t, tdb, "name = $1", "dave"); n != 1 {
+				t.Errorf("inserted rows = %d, want 1", n)
+			}
+		}
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		installMock(t)
+
+		req := insertReq(map[string]interface{}{"id": 4, "name": "dave"})
+		req.TableName = ""
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "failed get table name")
+
+		status, resp = runJimo(t, testUser(), insertReq())
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "missing records to insert")
+
+		status, resp = runJimo(t, testUser(), insertReq(map[string]interface{}{"id": 4}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_ValidationFailed, "name")
+		if problems, ok := resp.Results.([]ApiTypes.FieldProblem); !ok || len(problems) == 0 {
+			t.Errorf("results = %#v, want field problems", resp.Results)
+		}
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectBegin()
+		tdb.Mock.ExpectExec(insertSQL).
+			WithArgs(int32(4), "dave", nil, nil).
+			WillReturnError(errNoRelation)
+		tdb.Mock.ExpectRollback()
+
+		status, resp := runJimo(t, testUser(), insertReq(map[string]interface{}{"id": 4, "name": "dave"}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_DBError, "does not exist")
+	})
+
+	t.Run("isolation level", func(t *testing.T) {
+		installMock(t)
+		req := insertReq(map[string]interface{}{"id": 4, "name": "dave"})
+		req.IsolationLevel = "snapshot"
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest,
+			"unsupported isolation_level")
+	})
+
+	t.Run("duplicate key", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectBegin()
+			tdb.Mock.ExpectExec(insertSQL).
+				WithArgs(int32(1), "alice2", nil, nil).
+				WillReturnError(&pq.Error{Code: "23505", Constraint: "users_pkey",
+					Detail: "Key (id)=(1) already exists."})
+			tdb.Mock.ExpectRollback()
+		}
+
+		status, resp := runJimo(t, testUser(), insertReq(map[string]interface{}{"id": 1, "name": "alice2"}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_KeyNotUnique, ApiTypes.ErrorKind_ValidationFailed, "id")
+		want := []ApiTypes.FieldProblem{{RecordIndex: 0, Field: "id", Problem: "value 1 already exists"}}
+		if !reflect.DeepEqual(resp.Results, want) {
+			t.Errorf("results = %#v, want %#v", resp.Results, want)
+		}
+		if strings.Contains(resp.ErrorMsg, "users_pkey") {
+			t.Errorf("error_msg shows the driver error: %s", resp.ErrorMsg)
+		}
+	})
+}
+
+func TestHandleDBUpdate(t *testing.T) {
+	updateReq := func(record map[string]interface{}, cond ApiTypes.CondDef) ApiTypes.UpdateRequest {
+		return ApiTypes.UpdateRequest{
+			RequestType: ApiTypes.ReqAction_Update,
+			TableName:   "users",
+			FieldDefs:   usersFieldDefs,
+			Record:      record,
+			Condition:   cond,
+		}
+	}
+	const updateSQL = "UPDATE users SET email = $1 WHERE id = $2"
+
+	t.Run("success", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectExec(updateSQL).
+				WithArgs("alice@new.example.com", int64(1)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+
+		status, resp := runJimo(t, testUser(), updateReq(
+			map[string]interface{}{"email": "alice@new.example.com"},
+			atomicCond("id", "int", Equal, 1)))
+		if status != ApiTypes.CustomHttpStatus_Success || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		results, _ := resp.Results.(map[string]interface{})
+		if results["rows_affected"] != int64(1) {
+			t.Errorf("rows_affected = %v, want 1", results["rows_affected"])
+		}
+		if _, ok := results["sql"]; ok {
+			t.Errorf("sql included outside debug mode: %v", results["sql"])
+		}
+		if !tdb.IsMock() {
+			if n := countUsers(t, tdb, "email = $1", "alice@new.example.com"); n != 1 {
+				t.Errorf("updated rows = %d, want 1", n)
+			}
+		}
+	})
+
+	t.Run("sql in debug mode", func(t *testing.T) {
+		saved := ApiTypes.CommonConfig.AppInfo.Debug
+		ApiTypes.CommonConfig.AppInfo.Debug = true
+		t.Cleanup(func() { ApiTypes.CommonConfig.AppInfo.Debug = saved })
+
+		tdb := installMock(t)
+		tdb.Mock.ExpectExec(updateSQL).
+			WithArgs("alice@new.example.com", int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		status, resp := runJimo(t, testUser(), updateReq(
+			map[string]interface{}{"email": "alice@new.example.com"},
+			atomicCond("id", "int", Equal, 1)))
+		if status != ApiTypes.CustomHttpStatus_Success || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		results, _ := resp.Results.(map[string]interface{})
+		if results["sql"] != updateSQL {
+			t.Errorf("sql = %v, want %q", results["sql"], updateSQL)
+		}
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		installMock(t)
+		by_id := atomicCond("id", "int", Equal, 1)
+
+		req := updateReq(map[string]interface{}{"email": "x@example.com"}, by_id)
+		req.TableName = ""
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "failed get table name")
+
+		status, resp = runJimo(t, testUser(), updateReq(nil, by_id))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "no records provided for update")
+
+		status, resp = runJimo(t, testUser(), updateReq(
+			map[string]interface{}{"email": "x@example.com"},
+			ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "missing conditions")
+
+		status, resp = runJimo(t, testUser(), updateReq(
+			map[string]interface{}{"email": "x@example.com"},
+			atomicCond("password", "string", Equal, "x")))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "failed building conditions")
+
+		// 'id' is a known field but may not be set by an update
+		status, resp = runJimo(t, testUser(), updateReq(map[string]interface{}{"id": 9}, by_id))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_ValidationFailed, "invalid field name")
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectExec(updateSQL).
+			WithArgs("alice@new.example.com", int64(1)).
+			WillReturnError(errNoRelation)
+
+		status, resp := runJimo(t, testUser(), updateReq(
+			map[string]interface{}{"email": "alice@new.example.com"},
+			atomicCond("id", "int", Equal, 1)))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_DBError, "does not exist")
+	})
+}
+
+func TestHandleDBDelete(t *testing.T) {
+	deleteReq := func(cond ApiTypes.CondDef) ApiTypes.DeleteRequest {
+		return ApiTypes.DeleteRequest{
+			RequestType: ApiTypes.ReqAction_Delete,
+			TableName:   "users",
+			FieldDefs:   usersFieldDefs,
+			Condition:   cond,
+		}
+	}
+	const deleteSQL = "DELETE FROM users WHERE id = $1"
+
+	t.Run("success", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectExec(deleteSQL).
+				WithArgs(int64(3)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+
+		status, resp := runJimo(t, testUser(), deleteReq(atomicCond("id", "int", Equal, 3)))
+		if status != ApiTypes.CustomHttpStatus_Success || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		results, _ := resp.Results.(map[string]interface{})
+		if results["rows_affected"] != int64(1) {
+			t.Errorf("rows_affected = %v, want 1", results["rows_affected"])
+		}
+		if !tdb.IsMock() {
+			if n := countUsers(t, tdb, "id = $1", 3); n != 0 {
+				t.Errorf("remaining rows = %d, want 0", n)
+			}
+		}
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		installMock(t)
+
+		req := deleteReq(atomicCond("id", "int", Equal, 3))
+		req.TableName = ""
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "failed get table name")
+
+		// A delete without conditions would empty the table
+		status, resp = runJimo(t, testUser(), deleteReq(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "missing conditions")
+
+		status, resp = runJimo(t, testUser(), deleteReq(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeAnd}))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "failed building conditions")
+
+		req = deleteReq(atomicCond("id", "int", Equal, 3))
+		req.ConfirmDeleteAll = true
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "takes no condition")
+
+		req = deleteReq(atomicCond("id", "int", Equal, 3))
+		req.ExpectedMaxRows = -1
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "invalid expected_max_rows")
+	})
+
+	t.Run("more than expected max rows", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectBegin()
+			tdb.Mock.ExpectQuery("SELECT COUNT(*) FROM users WHERE id >= $1").
+				WithArgs(int64(1)).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(3)))
+			tdb.Mock.ExpectRollback()
+		}
+
+		req := deleteReq(atomicCond("id", "int", GreaterEqual, 1))
+		req.ExpectedMaxRows = 2
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest,
+			"delete matches 3 rows, more than expected_max_rows 2")
+		results, _ := resp.Results.(map[string]interface{})
+		if results["matched_rows"] != int64(3) {
+			t.Errorf("matched_rows = %v, want 3", results["matched_rows"])
+		}
+		if !tdb.IsMock() {
+			if n := countUsers(t, tdb, "id >= $1", 1); n != 3 {
+				t.Errorf("remaining rows = %d, want 3", n)
+			}
+		}
+	})
+
+	t.Run("within expected max rows", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectBegin()
+			tdb.Mock.ExpectQuery("SELECT COUNT(*) FROM users WHERE id = $1").
+				WithArgs(int64(3)).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(1)))
+			tdb.Mock.ExpectExec(deleteSQL).
+				WithArgs(int64(3)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			tdb.Mock.ExpectCommit()
+		}
+
+		req := deleteReq(atomicCond("id", "int", Equal, 3))
+		req.ExpectedMaxRows = 1
+		status, resp := runJimo(t, testUser(), req)
+		if status != ApiTypes.CustomHttpStatus_Success || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		results, _ := resp.Results.(map[string]interface{})
+		if results["rows_affected"] != int64(1) {
+			t.Errorf("rows_affected = %v, want 1", results["rows_affected"])
+		}
+	})
+
+	t.Run("mysql within expected max rows", func(t *testing.T) {
+		tdb := installMock(t)
+		saved := ApiTypes.DBType
+		ApiTypes.DBType = ApiTypes.MysqlName
+		t.Cleanup(func() { ApiTypes.DBType = saved })
+		tdb.Mock.ExpectBegin()
+		tdb.Mock.ExpectQuery("SELECT COUNT(*) FROM users WHERE id = ?").
+			WithArgs(int64(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(1)))
+		tdb.Mock.ExpectExec("DELETE FROM users WHERE id = ?").
+			WithArgs(int64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		tdb.Mock.ExpectCommit()
+
+		req := deleteReq(atomicCond("id", "int", Equal, 3))
+		req.ExpectedMaxRows = 1
+		status, resp := runJimo(t, testUser(), req)
+		if status != ApiTypes.CustomHttpStatus_Success || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+	})
+
+	// Rows added after the count are caught by the rows the delete affects
+	t.Run("more rows deleted than counted", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectBegin()
+		tdb.Mock.ExpectQuery("SELECT COUNT(*) FROM users WHERE id = $1").
+			WithArgs(int64(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(1)))
+		tdb.Mock.ExpectExec(deleteSQL).
+			WithArgs(int64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		tdb.Mock.ExpectRollback()
+
+		req := deleteReq(atomicCond("id", "int", Equal, 3))
+		req.ExpectedMaxRows = 1
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest,
+			"delete matches 2 rows")
+	})
+
+	t.Run("confirm delete all", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectExec("DELETE FROM users").
+			WillReturnResult(sqlmock.NewResult(0, 3))
+
+		req := deleteReq(ApiTypes.CondDef{Type: ApiTypes.ConditionTypeNull})
+		req.ConfirmDeleteAll = true
+		status, resp := runJimo(t, testUser(), req)
+		if status != ApiTypes.CustomHttpStatus_Success || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		results, _ := resp.Results.(map[string]interface{})
+		if results["rows_affected"] != int64(3) {
+			t.Errorf("rows_affected = %v, want 3", results["rows_affected"])
+		}
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectExec(deleteSQL).
+			WithArgs(int64(3)).
+			WillReturnError(errNoRelation)
+
+		status, resp := runJimo(t, testUser(), deleteReq(atomicCond("id", "int", Equal, 3)))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_DBError, "does not exist")
+	})
+}
+
+func TestHandleDBDeleteCascade(t *testing.T) {
+	cascadeReq := func(cond ApiTypes.CondDef) ApiTypes.DeleteRequest {
+		return ApiTypes.DeleteRequest{
+			RequestType: ApiTypes.ReqAction_Delete,
+			TableName:   "users",
+			FieldDefs:   usersFieldDefs,
+			Condition:   cond,
+			Cascade: []ApiTypes.CascadeDef{{
+				Table:       "orders",
+				FKField:     "user_id",
+				ParentField: "id",
+				FieldDefs:   ordersFieldDefs,
+			}},
+		}
+	}
+	const (
+		selectSQL      = "SELECT id FROM users WHERE id = $1 FOR UPDATE"
+		deleteChildSQL = "DELETE FROM orders WHERE user_id IN ($1)"
+		deleteSQL      = "DELETE FROM users WHERE id = $1"
+	)
+	setLimit := func(t *testing.T, limit int64) {
+		SetCascadeConfig(CascadeConfig{MaxAffectedRows: limit, BatchSize: 500})
+		t.Cleanup(func() { SetCascadeConfig(DefaultCascadeConfig()) })
+	}
+
+	t.Run("success", func(t *testing.T) {
+		tdb := installUsers(t)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectBegin()
+			tdb.Mock.ExpectQuery(selectSQL).
+				WithArgs(int64(1)).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(1)))
+			tdb.Mock.ExpectExec(deleteChildSQL).
+				WithArgs(int64(1)).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			tdb.Mock.ExpectExec(deleteSQL).
+				WithArgs(int64(1)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			tdb.Mock.ExpectCommit()
+		}
+
+		status, resp := runJimo(t, testUser(), cascadeReq(atomicCond("id", "int", Equal, 1)))
+		if status != ApiTypes.CustomHttpStatus_Success || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		results, _ := resp.Results.(map[string]interface{})
+		if results["rows_affected"] != int64(1) {
+			t.Errorf("rows_affected = %v, want 1", results["rows_affected"])
+		}
+		want := map[string]int64{"orders": 2, "users": 1}
+		if !reflect.DeepEqual(results["deleted"], want) {
+			t.Errorf("deleted = %v, want %v", results["deleted"], want)
+		}
+	})
+
+	t.Run("mysql", func(t *testing.T) {
+		tdb := installMock(t)
+		saved := ApiTypes.DBType
+		ApiTypes.DBType = ApiTypes.MysqlName
+		t.Cleanup(func() { ApiTypes.DBType = saved })
+		tdb.Mock.ExpectBegin()
+		tdb.Mock.ExpectQuery("SELECT COUNT(*) FROM users WHERE id = ?").
+			WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(1)))
+		tdb.Mock.ExpectQuery("SELECT id FROM users WHERE id = ? FOR UPDATE").
+			WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(1)))
+		tdb.Mock.ExpectExec("DELETE FROM orders WHERE user_id IN (?)").
+			WithArgs(int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		tdb.Mock.ExpectExec("DELETE FROM users WHERE id = ?").
+			WithArgs(int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		tdb.Mock.ExpectCommit()
+
+		req := cascadeReq(atomicCond("id", "int", Equal, 1))
+		req.ExpectedMaxRows = 1
+		status, resp := runJimo(t, testUser(), req)
+		if status != ApiTypes.CustomHttpStatus_Success || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+		results, _ := resp.Results.(map[string]interface{})
+		want := map[string]int64{"orders": 2, "users": 1}
+		if !reflect.DeepEqual(results["deleted"], want) {
+			t.Errorf("deleted = %v, want %v", results["deleted"], want)
+		}
+	})
+
+	t.Run("limit exceeded rolls back", func(t *testing.T) {
+		tdb := installUsers(t)
+		setLimit(t, 1)
+		if tdb.IsMock() {
+			tdb.Mock.ExpectBegin()
+			tdb.Mock.ExpectQuery(selectSQL).
+				WithArgs(int64(1)).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(1)))
+			tdb.Mock.ExpectExec(deleteChildSQL).
+				WithArgs(int64(1)).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			tdb.Mock.ExpectRollback()
+		}
+
+		status, resp := runJimo(t, testUser(), cascadeReq(atomicCond("id", "int", Equal, 1)))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "row limit exceeded")
+		if !tdb.IsMock() {
+			if n := countUsers(t, tdb, "id = $1", 1); n != 1 {
+				t.Errorf("remaining rows = %d, want 1", n)
+			}
+		}
+	})
+
+	t.Run("more than expected max rows", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectBegin()
+		tdb.Mock.ExpectQuery("SELECT COUNT(*) FROM users WHERE id = $1").
+			WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(2)))
+		tdb.Mock.ExpectRollback()
+
+		req := cascadeReq(atomicCond("id", "int", Equal, 1))
+		req.ExpectedMaxRows = 1
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest,
+			"delete matches 2 rows, more than expected_max_rows 1")
+	})
+
+	t.Run("invalid cascade", func(t *testing.T) {
+		installMock(t)
+
+		req := cascadeReq(atomicCond("id", "int", Equal, 1))
+		req.Cascade[0].FKField = "customer_id"
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "fk_field customer_id")
+
+		req = cascadeReq(atomicCond("id", "int", Equal, 1))
+		req.Cascade[0].ParentField = "uid"
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "parent_field uid")
+
+		req = cascadeReq(atomicCond("id", "int", Equal, 1))
+		req.Cascade[0].Table = "orders; DROP TABLE users"
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "invalid table name")
+
+		req = cascadeReq(atomicCond("id", "int", Equal, 1))
+		req.Cascade[0].Table = "users"
+		status, resp = runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "cannot be the parent table")
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectBegin()
+		tdb.Mock.ExpectQuery(selectSQL).
+			WithArgs(int64(1)).
+			WillReturnError(errNoRelation)
+		tdb.Mock.ExpectRollback()
+
+		status, resp := runJimo(t, testUser(), cascadeReq(atomicCond("id", "int", Equal, 1)))
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_DBError, "does not exist")
+	})
+}
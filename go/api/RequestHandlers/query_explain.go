@@ -0,0 +1,141 @@
+package RequestHandlers
+
+// EXPLAIN for Jimo queries ('explain' in QueryRequest), so that admins can
+// see how a slow generic query runs and which index it misses. The query
+// is built as usual, ORDER BY and LIMIT included, and its plan is returned
+// in 'results' instead of its rows; the query itself is not run:
+//
+//	PG:    EXPLAIN (FORMAT JSON) <query>, an array with one plan
+//	MySQL: EXPLAIN FORMAT=JSON <query>, an object
+//
+// With with_total the plan is that of the page query, not of the count.
+// Explain is admin-only: plans show table sizes and indexes.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+// checkExplain returns a response if 'req' asks for a plan it may not
+// get: the caller must be an admin, and time bucket queries can't be
+// explained.
+func checkExplain(rc ApiTypes.RequestContext, req ApiTypes.QueryRequest, call_flow string) (int, *ApiTypes.JimoResponse) {
+	if !req.Explain {
+		return 0, nil
+	}
+	if _, status_code, resp := requireAdmin(rc, fmt.Sprintf("%s->SHD_QEX_037", call_flow)); resp != nil {
+		resp.TableName = req.TableName
+		resp.ErrorKind = ApiTypes.ErrorKind_InvalidRequest
+		resp.ErrorCode = status_code
+		return status_code, resp
+	}
+	if req.TimeBucket != nil {
+		return ApiTypes.CustomHttpStatus_BadRequest, &ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     rc.ReqID(),
+			TableName: req.TableName,
+			ErrorMsg:  "explain cannot be combined with time_bucket",
+			ErrorKind: ApiTypes.ErrorKind_InvalidRequest,
+			ErrorCode: ApiTypes.CustomHttpStatus_BadRequest,
+			Loc:       fmt.Sprintf("%s->SHD_QEX_051", call_flow),
+		}
+	}
+	return 0, nil
+}
+
+// explainStmt returns the EXPLAIN statement giving the JSON plan of 'query'
+func explainStmt(db_type string, query string) (string, error) {
+	switch db_type {
+	case ApiTypes.PgName:
+		return "EXPLAIN (FORMAT JSON) " + query, nil
+	case ApiTypes.MysqlName:
+		return "EXPLAIN FORMAT=JSON " + query, nil
+	}
+	return "", fmt.Errorf("explain not supported for db type:%s (SHD_QEX_063)", db_type)
+}
+
+// explainQuery returns the plan of 'query', decoded from its JSON
+func explainQuery(ctx context.Context, db *sql.DB, query string, args []interface{}) (interface{}, error) {
+	stmt, err := explainStmt(ApiTypes.DBType, query)
+	if err != nil {
+		return nil, err
+	}
+	var plan_json []byte
+	if err := databaseutil.QueryRowWithRetry(ctx, db, stmt, args, &plan_json); err != nil {
+		return nil, err
+	}
+	var plan interface{}
+	if err := json.Unmarshal(plan_json, &plan); err != nil {
+		return nil, fmt.Errorf("failed to decode plan:%v (SHD_QEX_077)", err)
+	}
+	return plan, nil
+}
+
+// handleExplainQuery answers the explain request 'req' with the plan of
+// 'query', the query HandleDBQuery would have run
+func handleExplainQuery(
+	ctx context.Context,
+	rc ApiTypes.RequestContext,
+	req ApiTypes.QueryRequest,
+	db *sql.DB,
+	query string,
+	args []interface{},
+	call_flow string) (int, ApiTypes.JimoResponse) {
+	logger := rc.GetLogger()
+	reqID := rc.ReqID()
+
+	plan, err := explainQuery(ctx, db, query, args)
+	if err != nil {
+		log_id := sysdatastores.NextActivityLogID()
+		error_msg := fmt.Sprintf("explain query failed, err:%v, logid:%d, table:%s, loc:%s",
+			err, log_id, req.TableName, req.Loc)
+		error_msg1 := fmt.Sprintf("explain query failed, err:%v, query:%s, table_name:%s, loc:%s",
+			err, query, req.TableName, req.Loc)
+		logger.Error("HandleJimoRequest", "error_msg", error_msg)
+		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+			LogID:        log_id,
+			ActivityName: ApiTypes.ActivityName_Query,
+			ActivityType: ApiTypes.ActivityType_DatabaseError,
+			AppName:      ApiTypes.AppName_RequestHandler,
+			ModuleName:   ApiTypes.ModuleName_RequestHandler,
+			ActivityMsg:  &error_msg1,
+			CallerLoc:    fmt.Sprintf("%s->SHD_QEX_110", call_flow)})
+		return dbErrorStatus(err, ApiTypes.CustomHttpStatus_InternalError), ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			TableName: req.TableName,
+			ErrorMsg:  error_msg,
+			ErrorKind: dbErrorKind(err),
+			ErrorCode: ApiTypes.CustomHttpStatus_InternalError,
+			Loc:       fmt.Sprintf("%s->SHD_QEX_118", call_flow),
+		}
+	}
+
+	new_call_flow := fmt.Sprintf("%s->SHD_QEX_122", call_flow)
+	msg := fmt.Sprintf("explain success, query:%s, table:%s, loc:%s", query, req.TableName, req.Loc)
+	logger.Info("HandleJimoRequest", "explain", query, "args_count", len(args))
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Query,
+		ActivityType: ApiTypes.ActivityType_RequestSuccess,
+		AppName:      ApiTypes.AppName_RequestHandler,
+		ModuleName:   ApiTypes.ModuleName_RequestHandler,
+		ActivityMsg:  &msg,
+		CallerLoc:    new_call_flow})
+
+	return http.StatusOK, ApiTypes.JimoResponse{
+		Status:     true,
+		ReqID:      reqID,
+		ResultType: "json",
+		TableName:  req.TableName,
+		Results:    plan,
+		Meta:       map[string]interface{}{"explain": true, "query": query},
+		Loc:        new_call_flow,
+	}
+}
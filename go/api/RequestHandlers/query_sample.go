@@ -0,0 +1,152 @@
+package RequestHandlers
+
+// Random sampling for Jimo queries ('sample' in QueryRequest). Sampled
+// queries return up to 'sample' random rows instead of a page. The way
+// the rows are picked depends on the database and the table size; the
+// response 'meta' says which way was used and what it costs.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+const (
+	// maxSampleSize caps 'sample'
+	maxSampleSize = 10000
+
+	// TABLESAMPLE is only worth it on tables of at least this many rows
+	// (by the planner's estimate); below it a full scan is cheap.
+	sampleTablesampleMinRows = 100000
+
+	// sampleOversample is how many times 'sample' rows TABLESAMPLE aims
+	// for, so that the WHERE clause and page clustering still leave
+	// enough rows.
+	sampleOversample = 10
+
+	// Above this percentage TABLESAMPLE reads most of the table anyway
+	sampleMaxPercent = 10.0
+)
+
+const (
+	SampleMethodOrderByRandom = "order_by_random"
+	SampleMethodTablesample   = "tablesample_system"
+	SampleMethodOrderByRand   = "order_by_rand"
+)
+
+// samplePlan is how a sampled query picks its rows
+type samplePlan struct {
+	Method string
+
+	// Percent of the table's pages TABLESAMPLE reads
+	Percent float64
+
+	// The planner's row estimate of the table; -1 if unknown
+	EstimatedRows int64
+}
+
+// validateSample checks the sampling options of 'req'. A sample is one
+// random draw, so it cannot be paged or ordered.
+func validateSample(req ApiTypes.QueryRequest) error {
+	if req.Sample < 0 {
+		return fmt.Errorf("invalid sample:%d (SHD_QSP_058)", req.Sample)
+	}
+	if req.Sample > maxSampleSize {
+		return fmt.Errorf("sample:%d exceeds the maximum of %d (SHD_QSP_061)", req.Sample, maxSampleSize)
+	}
+	if req.Sample > 0 && req.Start > 0 {
+		return fmt.Errorf("sample cannot be combined with pagination, start:%d (SHD_QSP_064)", req.Start)
+	}
+	if req.Sample > 0 && len(req.OrderbyDef) > 0 {
+		return fmt.Errorf("sample cannot be combined with orderby_def (SHD_QSP_067)")
+	}
+	if req.Sample > 0 && req.WithTotal {
+		return fmt.Errorf("sample cannot be combined with with_total (SHD_QSP_069)")
+	}
+	return nil
+}
+
+// planSample picks how to sample 'req'. On PG, large tables without
+// joins use TABLESAMPLE SYSTEM so only a fraction of the pages is read;
+// everything else is sorted by random(). MySQL always uses RAND().
+func planSample(
+	ctx context.Context,
+	rc ApiTypes.RequestContext,
+	db *sql.DB,
+	req ApiTypes.QueryRequest) samplePlan {
+	if ApiTypes.DBType == ApiTypes.MysqlName {
+		return samplePlan{Method: SampleMethodOrderByRand, EstimatedRows: -1}
+	}
+
+	plan := samplePlan{Method: SampleMethodOrderByRandom, EstimatedRows: -1}
+	if db == nil || len(req.JoinDefs) > 0 {
+		return plan
+	}
+
+	// reltuples is -1 (PG 14+) or 0 for tables never analyzed
+	var estimate int64
+	err := databaseutil.QueryRowWithRetry(ctx, db,
+		"SELECT reltuples::bigint FROM pg_class WHERE oid = to_regclass($1)",
+		[]interface{}{req.TableName}, &estimate)
+	if err != nil {
+		rc.GetLogger().Warn("failed estimating table size, sampling with random()",
+			"table_name", req.TableName, "error", err, "loc", "SHD_QSP_095")
+		return plan
+	}
+	plan.EstimatedRows = estimate
+	if estimate < sampleTablesampleMinRows {
+		return plan
+	}
+
+	percent := float64(req.Sample) * sampleOversample * 100 / float64(estimate)
+	if percent > sampleMaxPercent {
+		return plan
+	}
+	plan.Method = SampleMethodTablesample
+	plan.Percent = percent
+	return plan
+}
+
+// fromClause returns the FROM clause of the sampled query
+func (p samplePlan) fromClause(table_name string) string {
+	if p.Method != SampleMethodTablesample {
+		return table_name
+	}
+	return fmt.Sprintf("%s TABLESAMPLE SYSTEM (%s)",
+		table_name, strconv.FormatFloat(p.Percent, 'f', 4, 64))
+}
+
+// orderLimit returns the clause that shuffles the rows and keeps 'sample'
+func (p samplePlan) orderLimit(sample int) string {
+	if p.Method == SampleMethodOrderByRand {
+		return fmt.Sprintf(" ORDER BY RAND() LIMIT %d", sample)
+	}
+	return fmt.Sprintf(" ORDER BY random() LIMIT %d", sample)
+}
+
+// meta describes the plan and its tradeoff for the response
+func (p samplePlan) meta(sample int) map[string]interface{} {
+	meta := map[string]interface{}{
+		"sample":        sample,
+		"sample_method": p.Method,
+	}
+	if p.EstimatedRows >= 0 {
+		meta["estimated_rows"] = p.EstimatedRows
+	}
+
+	switch p.Method {
+	case SampleMethodTablesample:
+		meta["sample_percent"] = p.Percent
+		meta["sample_note"] = "reads only a fraction of the table's pages, so it is fast on " +
+			"large tables; rows on the same page are picked together and a selective " +
+			"condition may return fewer rows than requested"
+	default:
+		meta["sample_note"] = "uniformly random, but scans and sorts every matching row; " +
+			"cost grows with the table size"
+	}
+	return meta
+}
@@ -0,0 +1,384 @@
+package RequestHandlers
+
+// Time bucketing for Jimo queries ('time_bucket' in QueryRequest), for
+// dashboards that chart counts or sums per minute, hour, day, week or
+// month. The rows matching the condition are grouped by the start of the
+// bucket their timestamp falls in, in the request's time zone (UTC by
+// default), and one result is returned per bucket, oldest first:
+//
+//	{"bucket": "2026-10-01T00:00:00+02:00", "value": 42}
+//
+// PG truncates with date_trunc, MySQL formats the bucket start with
+// DATE_FORMAT. Naive timestamps are UTC wall clock, as inserts store them.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+// maxTimeBuckets caps the buckets of one query, gaps filled included
+const maxTimeBuckets = 10000
+
+// timeBucketIntervals are the allowed intervals and their MySQL
+// DATE_FORMAT of the bucket start. A week starts on Monday, as in PG.
+var timeBucketIntervals = map[string]string{
+	"minute": "%Y-%m-%d %H:%i:00",
+	"hour":   "%Y-%m-%d %H:00:00",
+	"day":    "%Y-%m-%d 00:00:00",
+	"week":   "%Y-%m-%d 00:00:00",
+	"month":  "%Y-%m-01 00:00:00",
+}
+
+var timeBucketNumericTypes = map[string]bool{
+	"integer": true, "int": true, "int4": true,
+	"bigint": true, "int8": true,
+	"smallint": true, "int2": true,
+	"real": true, "float4": true,
+	"double precision": true, "float8": true,
+	"numeric": true, "decimal": true, "float": true,
+}
+
+// timeBucketFieldDef returns the field def of 'field_name' in 'req'
+func timeBucketFieldDef(req ApiTypes.QueryRequest, field_name string) (ApiTypes.FieldDef, bool) {
+	for _, field_def := range req.FieldDefs {
+		if field_def.FieldName == field_name {
+			return field_def, true
+		}
+	}
+	return ApiTypes.FieldDef{}, false
+}
+
+// validateTimeBucket checks the time bucket of 'req'. Buckets are a
+// single ordered series, so they cannot be paged, ordered, sampled or
+// joined.
+func validateTimeBucket(user_info *ApiTypes.UserInfo, req ApiTypes.QueryRequest) error {
+	tb := req.TimeBucket
+	if _, ok := timeBucketIntervals[tb.Interval]; !ok {
+		return fmt.Errorf("invalid time bucket interval:%s, expecting minute, hour, day, week or month (SHD_QTB_066)",
+			tb.Interval)
+	}
+	switch {
+	case req.Sample > 0:
+		return fmt.Errorf("time_bucket cannot be combined with sample (SHD_QTB_070)")
+	case req.Start > 0:
+		return fmt.Errorf("time_bucket cannot be combined with pagination, start:%d (SHD_QTB_072)", req.Start)
+	case len(req.OrderbyDef) > 0:
+		return fmt.Errorf("time_bucket cannot be combined with orderby_def (SHD_QTB_074)")
+	case req.WithTotal:
+		return fmt.Errorf("time_bucket cannot be combined with with_total (SHD_QTB_076)")
+	case len(req.JoinDefs) > 0:
+		return fmt.Errorf("time_bucket cannot be combined with join_def (SHD_QTB_078)")
+	}
+
+	field_def, ok := timeBucketFieldDef(req, tb.FieldName)
+	if !ok || !isValidSQLIdentifier(tb.FieldName) {
+		return fmt.Errorf("unknown time bucket field:%s (SHD_QTB_083)", tb.FieldName)
+	}
+	switch field_def.DataType {
+	case "date", "timestamp", "timestamptz", "datetime":
+	default:
+		return fmt.Errorf("time bucket field %s is not a timestamp, data_type:%s (SHD_QTB_088)",
+			tb.FieldName, field_def.DataType)
+	}
+	fields := []string{tb.FieldName}
+
+	switch tb.Agg {
+	case "", ApiTypes.TimeBucketAgg_Count:
+	case ApiTypes.TimeBucketAgg_Sum:
+		value_def, ok := timeBucketFieldDef(req, tb.ValueField)
+		if !ok || !isValidSQLIdentifier(tb.ValueField) {
+			return fmt.Errorf("unknown time bucket value field:%s (SHD_QTB_098)", tb.ValueField)
+		}
+		if !timeBucketNumericTypes[value_def.DataType] {
+			return fmt.Errorf("time bucket value field %s is not numeric, data_type:%s (SHD_QTB_101)",
+				tb.ValueField, value_def.DataType)
+		}
+		fields = append(fields, tb.ValueField)
+	default:
+		return fmt.Errorf("invalid time bucket agg:%s, expecting count or sum (SHD_QTB_106)", tb.Agg)
+	}
+
+	// Aggregates would leak what field policies hide
+	policies := getFieldPolicies()
+	for _, field_name := range fields {
+		if _, hidden := hiddenField(policies, user_info, req.TableName+"."+field_name); hidden {
+			return fmt.Errorf("no access to field %s.%s (SHD_QTB_113)", req.TableName, field_name)
+		}
+	}
+	return checkHiddenFieldUse(user_info, req, nil, nil)
+}
+
+// timeBucketExpr returns the SQL of the bucket start of 'field_def' in
+// 'loc', and its arguments.
+func timeBucketExpr(field_def ApiTypes.FieldDef, interval string, loc *time.Location) (string, []interface{}) {
+	field_name := quoteIdent(field_def.FieldName)
+	data_type := ApiTypes.FieldDataType(field_def)
+	zone := loc.String()
+
+	if ApiTypes.DBType == ApiTypes.MysqlName {
+		value, args := field_name, []interface{}{}
+		if data_type != "date" && zone != "UTC" {
+			value, args = fmt.Sprintf("CONVERT_TZ(%s, '+00:00', ?)", field_name), []interface{}{zone}
+		}
+		if interval == "week" {
+			value = fmt.Sprintf("DATE_SUB(%s, INTERVAL WEEKDAY(%s) DAY)", value, value)
+			args = append(args, args...)
+		}
+		return fmt.Sprintf("DATE_FORMAT(%s, '%s')", value, timeBucketIntervals[interval]), args
+	}
+
+	switch data_type {
+	case "date":
+		return fmt.Sprintf("date_trunc('%s', %s::timestamp)", interval, field_name), nil
+	case "timestamptz":
+		return fmt.Sprintf("date_trunc('%s', %s AT TIME ZONE ?)", interval, field_name), []interface{}{zone}
+	default:
+		return fmt.Sprintf("date_trunc('%s', %s AT TIME ZONE 'UTC' AT TIME ZONE ?)", interval, field_name),
+			[]interface{}{zone}
+	}
+}
+
+// nextTimeBucket returns the start of the bucket after 't'
+func nextTimeBucket(t time.Time, interval string) time.Time {
+	switch interval {
+	case "minute":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+	case "week":
+		return time.Date(t.Year(), t.Month(), t.Day()+7, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// scanBucketStart converts the bucket start a row returned (a naive
+// timestamp on PG, a string on MySQL) to a time in 'loc'
+func scanBucketStart(value interface{}, loc *time.Location) (time.Time, error) {
+	var wall time.Time
+	switch v := value.(type) {
+	case time.Time:
+		wall = v
+	case []byte:
+		return time.ParseInLocation("2006-01-02 15:04:05", string(v), loc)
+	case string:
+		return time.ParseInLocation("2006-01-02 15:04:05", v, loc)
+	default:
+		return time.Time{}, fmt.Errorf("unexpected bucket value %T (SHD_QTB_181)", value)
+	}
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(),
+		wall.Second(), 0, loc), nil
+}
+
+// timeBucketRow is one bucket of the results
+type timeBucketRow struct {
+	start time.Time
+	value interface{}
+}
+
+// fillTimeBuckets adds the empty buckets between those of 'rows'
+func fillTimeBuckets(rows []timeBucketRow, interval string, zero interface{}) ([]timeBucketRow, error) {
+	if len(rows) == 0 {
+		return rows, nil
+	}
+	filled := make([]timeBucketRow, 0, len(rows))
+	next := rows[0].start
+	for _, row := range rows {
+		for next.Before(row.start) {
+			if len(filled) >= maxTimeBuckets {
+				return nil, fmt.Errorf("more than %d time buckets, narrow the condition or use a larger interval (SHD_QTB_203)",
+					maxTimeBuckets)
+			}
+			filled = append(filled, timeBucketRow{start: next, value: zero})
+			next = nextTimeBucket(next, interval)
+		}
+		filled = append(filled, row)
+		next = nextTimeBucket(row.start, interval)
+	}
+	if len(filled) > maxTimeBuckets {
+		return nil, fmt.Errorf("more than %d time buckets, narrow the condition or use a larger interval (SHD_QTB_212)",
+			maxTimeBuckets)
+	}
+	return filled, nil
+}
+
+// handleTimeBucketQuery runs the time bucket query 'req'
+func handleTimeBucketQuery(
+	ctx context.Context,
+	rc ApiTypes.RequestContext,
+	req ApiTypes.QueryRequest,
+	call_flow string) (int, ApiTypes.JimoResponse) {
+	logger := rc.GetLogger()
+	reqID := rc.ReqID()
+	fail := func(status_code int, kind ApiTypes.ErrorKind, error_msg string, loc string) (int, ApiTypes.JimoResponse) {
+		logger.Error("HandleJimoRequest", "error_msg", error_msg)
+		return status_code, ApiTypes.JimoResponse{
+			Status:    false,
+			ReqID:     reqID,
+			TableName: req.TableName,
+			ErrorMsg:  error_msg,
+			ErrorKind: kind,
+			ErrorCode: status_code,
+			Loc:       fmt.Sprintf("%s->%s", call_flow, loc),
+		}
+	}
+
+	if err := validateTimeBucket(rc.IsAuthenticated(), req); err != nil {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, err.Error(), "SHD_QTB_239")
+	}
+	if req.TableName == "" || !isValidSQLIdentifier(req.TableName) {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest,
+			fmt.Sprintf("invalid table name:%s", req.TableName), "SHD_QTB_243")
+	}
+	db := ApiTypes.ProjectDBHandle
+	if db == nil {
+		return fail(ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_InternalError,
+			fmt.Sprintf("invalid db type:%s, table_name:%s", ApiTypes.DBType, req.TableName), "SHD_QTB_248")
+	}
+
+	// The zone was checked by prepareQueryRequest
+	loc, _ := ApiUtils.LoadTimeZone(req.TimeZone)
+	if req.TimeZone == "" {
+		loc = time.UTC
+	}
+	tb := req.TimeBucket
+	field_def, _ := timeBucketFieldDef(req, tb.FieldName)
+	bucket_expr, bucket_args := timeBucketExpr(field_def, tb.Interval, loc)
+
+	agg_expr := "COUNT(*)"
+	var zero interface{} = int64(0)
+	if tb.Agg == ApiTypes.TimeBucketAgg_Sum {
+		agg_expr = fmt.Sprintf("COALESCE(SUM(%s), 0)", quoteIdent(tb.ValueField))
+		zero = float64(0)
+	}
+
+	field_map := conditionFieldMap(req.TableName, req.FieldDefs, nil)
+	expr, err := buildConditionExpr(ctx, req.TableName, req.Condition, field_map, loc)
+	if err != nil {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, err.Error(), "SHD_QTB_270")
+	}
+
+	query := sq.Select().
+		Column(sq.Expr(bucket_expr+" AS bucket", bucket_args...)).
+		Column(agg_expr + " AS value").
+		From(quoteQualified(req.TableName)).
+		GroupBy("1").
+		OrderBy("1").
+		Limit(maxTimeBuckets + 1).
+		PlaceholderFormat(placeholderFormat())
+	if expr != nil {
+		query = query.Where(expr)
+	}
+	stmt, args, err := query.ToSql()
+	if err != nil {
+		return fail(ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_InternalError,
+			fmt.Sprintf("failed building query:%v", err), "SHD_QTB_290")
+	}
+	logger.Info("HandleJimoRequest", "sql", stmt, "args_count", len(args))
+
+	rows, err := databaseutil.QueryWithRetry(ctx, db, stmt, args...)
+	if err != nil {
+		log_id := sysdatastores.NextActivityLogID()
+		error_msg := fmt.Sprintf("run query failed, err:%v, logid:%d, table:%s, loc:%s",
+			err, log_id, req.TableName, req.Loc)
+		error_msg1 := fmt.Sprintf("run query failed, err:%v, query:%s, table_name:%s, loc:%s",
+			err, stmt, req.TableName, req.Loc)
+		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+			LogID:        log_id,
+			ActivityName: ApiTypes.ActivityName_Query,
+			ActivityType: ApiTypes.ActivityType_DatabaseError,
+			AppName:      ApiTypes.AppName_RequestHandler,
+			ModuleName:   ApiTypes.ModuleName_RequestHandler,
+			ActivityMsg:  &error_msg1,
+			CallerLoc:    fmt.Sprintf("%s->SHD_QTB_307", call_flow)})
+		return fail(dbErrorStatus(err, ApiTypes.CustomHttpStatus_InternalError), dbErrorKind(err), error_msg, "SHD_QTB_308")
+	}
+	defer rows.Close()
+
+	var buckets []timeBucketRow
+	for rows.Next() {
+		var start interface{}
+		var row timeBucketRow
+		var err error
+		if tb.Agg == ApiTypes.TimeBucketAgg_Sum {
+			var sum float64
+			err = rows.Scan(&start, &sum)
+			row.value = sum
+		} else {
+			var count int64
+			err = rows.Scan(&start, &count)
+			row.value = count
+		}
+		if err == nil {
+			row.start, err = scanBucketStart(start, loc)
+		}
+		if err != nil {
+			return fail(ApiTypes.CustomHttpStatus_InternalError, ApiTypes.ErrorKind_DBError,
+				fmt.Sprintf("failed reading time buckets:%v", err), "SHD_QTB_331")
+		}
+		buckets = append(buckets, row)
+	}
+	if err := rows.Err(); err != nil {
+		return fail(dbErrorStatus(err, ApiTypes.CustomHttpStatus_InternalError), dbErrorKind(err),
+			fmt.Sprintf("failed reading time buckets:%v", err), "SHD_QTB_337")
+	}
+
+	if len(buckets) > maxTimeBuckets {
+		return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest,
+			fmt.Sprintf("more than %d time buckets, narrow the condition or use a larger interval", maxTimeBuckets),
+			"SHD_QTB_343")
+	}
+	if tb.FillGaps {
+		if buckets, err = fillTimeBuckets(buckets, tb.Interval, zero); err != nil {
+			return fail(ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, err.Error(), "SHD_QTB_347")
+		}
+	}
+
+	results := make([]map[string]interface{}, len(buckets))
+	for i, bucket := range buckets {
+		results[i] = map[string]interface{}{
+			"bucket": bucket.start.Format(time.RFC3339),
+			"value":  bucket.value,
+		}
+	}
+
+	agg := tb.Agg
+	if agg == "" {
+		agg = ApiTypes.TimeBucketAgg_Count
+	}
+	msg := fmt.Sprintf("time bucket query success, query:%s, num_buckets:%d, table:%s, loc:%s",
+		stmt, len(results), req.TableName, req.Loc)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Query,
+		ActivityType: ApiTypes.ActivityType_RequestSuccess,
+		AppName:      ApiTypes.AppName_RequestHandler,
+		ModuleName:   ApiTypes.ModuleName_RequestHandler,
+		ActivityMsg:  &msg,
+		CallerLoc:    fmt.Sprintf("%s->SHD_QTB_371", call_flow)})
+
+	return http.StatusOK, ApiTypes.JimoResponse{
+		Status:     true,
+		ReqID:      reqID,
+		ResultType: "json_array",
+		NumRecords: len(results),
+		TableName:  req.TableName,
+		Results:    results,
+		Meta: map[string]interface{}{
+			"time_bucket": tb.Interval,
+			"agg":         agg,
+			"time_zone":   loc.String(),
+		},
+		Loc: fmt.Sprintf("%s->SHD_QTB_385", call_flow),
+	}
+}
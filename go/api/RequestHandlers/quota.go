@@ -0,0 +1,559 @@
+package RequestHandlers
+
+// Quotas limit how much each user, and each table, may use the Jimo
+// handlers. Usage is counted in per-minute buckets in memory and
+// synchronized lazily with jimo_quota_usage, so that instances sharing
+// a database roughly agree on the totals. Limits come from jimo_quotas,
+// falling back to QuotaConfig.
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/EchoFactory"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+const (
+	QuotaMetric_Queries = "queries"
+	QuotaMetric_Rows    = "rows"
+	QuotaMetric_Writes  = "writes"
+
+	// quotaRetentionMinutes is how long usage buckets are kept in the
+	// DB, enough for the 24h usage report.
+	quotaRetentionMinutes = 48 * 60
+)
+
+// quotaWindows is the sliding window, in minutes, of each metric.
+var quotaWindows = map[string]int64{
+	QuotaMetric_Queries: 1,
+	QuotaMetric_Rows:    60,
+	QuotaMetric_Writes:  1,
+}
+
+// QuotaLimits are the limits of one user or table. 0 means unlimited.
+type QuotaLimits struct {
+	QueriesPerMinute int64
+	RowsPerHour      int64
+	WritesPerMinute  int64
+}
+
+func (l QuotaLimits) limitOf(metric string) int64 {
+	switch metric {
+	case QuotaMetric_Queries:
+		return l.QueriesPerMinute
+	case QuotaMetric_Rows:
+		return l.RowsPerHour
+	case QuotaMetric_Writes:
+		return l.WritesPerMinute
+	}
+	return 0
+}
+
+// QuotaConfig configures the quotas of the Jimo handlers
+type QuotaConfig struct {
+	// Enabled turns quota enforcement on. Usage is counted either way.
+	Enabled bool
+	// UserLimits apply to users without a row in jimo_quotas
+	UserLimits QuotaLimits
+	// TableLimits apply to tables without a row in jimo_quotas
+	TableLimits QuotaLimits
+	// SyncInterval is how often usage is written to and re-read from the DB
+	SyncInterval time.Duration
+}
+
+// DefaultQuotaConfig returns limits generous enough for interactive use
+// that still stop a runaway client.
+func DefaultQuotaConfig() QuotaConfig {
+	return QuotaConfig{
+		Enabled: true,
+		UserLimits: QuotaLimits{
+			QueriesPerMinute: 600,
+			RowsPerHour:      1000000,
+			WritesPerMinute:  300,
+		},
+		TableLimits: QuotaLimits{
+			QueriesPerMinute: 6000,
+			RowsPerHour:      10000000,
+			WritesPerMinute:  3000,
+		},
+		SyncInterval: 15 * time.Second,
+	}
+}
+
+type quotaScope struct {
+	scope_type string
+	scope_name string
+}
+
+type quotaKey struct {
+	quotaScope
+	metric string
+}
+
+// quotaCounter holds the per-minute buckets of one scope and metric.
+// 'synced' is the total of all instances as last read from the DB;
+// 'pending' is what this instance counted since and has not written yet.
+type quotaCounter struct {
+	synced  map[int64]int64
+	pending map[int64]int64
+}
+
+func (c *quotaCounter) count(bucket int64) int64 {
+	return c.synced[bucket] + c.pending[bucket]
+}
+
+// used returns the sliding-window usage at 'now': the buckets inside the
+// window plus the part of the bucket before it that the window still
+// overlaps.
+func (c *quotaCounter) used(now time.Time, window int64) int64 {
+	cur := now.Unix() / 60
+	var total float64
+	for bucket := cur - window + 1; bucket <= cur; bucket++ {
+		total += float64(c.count(bucket))
+	}
+	overlap := 1 - float64(now.UnixNano()%int64(time.Minute))/float64(time.Minute)
+	total += float64(c.count(cur-window)) * overlap
+	return int64(total)
+}
+
+// QuotaManager counts and enforces the quotas
+type QuotaManager struct {
+	mu         sync.Mutex
+	sync_mu    sync.Mutex
+	config     QuotaConfig
+	counters   map[quotaKey]*quotaCounter
+	overrides  map[quotaScope]QuotaLimits
+	last_sync  time.Time
+	last_purge time.Time
+	syncing    bool
+}
+
+// NewQuotaManager creates a quota manager with the given config
+func NewQuotaManager(config QuotaConfig) *QuotaManager {
+	return &QuotaManager{
+		config:    config,
+		counters:  make(map[quotaKey]*quotaCounter),
+		overrides: make(map[quotaScope]QuotaLimits),
+	}
+}
+
+var quotas = NewQuotaManager(DefaultQuotaConfig())
+
+// SetQuotaConfig replaces the quota config of the Jimo handlers.
+func SetQuotaConfig(config QuotaConfig) {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+	quotas.config = config
+}
+
+// limitsOf returns the limits of 'scope': its own jimo_quotas row, else
+// the '*' row of its scope type, else the config.
+func (m *QuotaManager) limitsOf(scope quotaScope) QuotaLimits {
+	if limits, ok := m.overrides[scope]; ok {
+		return limits
+	}
+	if limits, ok := m.overrides[quotaScope{scope.scope_type, sysdatastores.QuotaScopeDefault}]; ok {
+		return limits
+	}
+	if scope.scope_type == sysdatastores.QuotaScope_Table {
+		return m.config.TableLimits
+	}
+	return m.config.UserLimits
+}
+
+func (m *QuotaManager) counter(key quotaKey) *quotaCounter {
+	c, ok := m.counters[key]
+	if !ok {
+		c = &quotaCounter{synced: make(map[int64]int64), pending: make(map[int64]int64)}
+		m.counters[key] = c
+	}
+	return c
+}
+
+// maxQuotaScopeName is the size of jimo_quota_usage.scope_name
+const maxQuotaScopeName = 255
+
+// quotaScopes returns the scopes a request counts against. 'table_name'
+// is not resolved yet, so a name that can't be a table is counted
+// against the user only: it would add a counter per name, and one longer
+// than scope_name would fail every sync.
+func quotaScopes(user_name string, table_name string) []quotaScope {
+	scopes := []quotaScope{{sysdatastores.QuotaScope_User, user_name}}
+	if len(table_name) <= maxQuotaScopeName && isQualifiedIdentifier(table_name) {
+		scopes = append(scopes, quotaScope{sysdatastores.QuotaScope_Table, table_name})
+	}
+	return scopes
+}
+
+// Acquire counts one Jimo request of 'request_type' by 'user_name' on
+// 'table_name'. If that would exceed a quota, nothing is counted and the
+// breach is returned. Queries are refused once the rows quota is used
+// up; the rows themselves are counted by RecordRows.
+func (m *QuotaManager) Acquire(
+	user_name string,
+	table_name string,
+	request_type string) *ApiTypes.QuotaBreach {
+	metric := QuotaMetric_Writes
+	checks := []string{QuotaMetric_Writes}
+	if request_type == ApiTypes.ReqAction_Query {
+		metric = QuotaMetric_Queries
+		checks = []string{QuotaMetric_Queries, QuotaMetric_Rows}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.maybeSync(now)
+
+	scopes := quotaScopes(user_name, table_name)
+	if m.config.Enabled {
+		for _, scope := range scopes {
+			limits := m.limitsOf(scope)
+			for _, check := range checks {
+				limit := limits.limitOf(check)
+				if limit <= 0 {
+					continue
+				}
+				c := m.counter(quotaKey{scope, check})
+				window := quotaWindows[check]
+				if used := c.used(now, window); used+1 > limit {
+					return &ApiTypes.QuotaBreach{
+						ErrorCode:     "quota_exceeded",
+						ScopeType:     scope.scope_type,
+						ScopeName:     scope.scope_name,
+						Metric:        check,
+						Limit:         limit,
+						Used:          used,
+						RetryAfterSec: retryAfter(c, now, window, limit),
+					}
+				}
+			}
+		}
+	}
+
+	for _, scope := range scopes {
+		m.add(quotaKey{scope, metric}, now, 1)
+	}
+	return nil
+}
+
+// RecordRows counts 'num_rows' rows returned to 'user_name' from 'table_name'.
+func (m *QuotaManager) RecordRows(user_name string, table_name string, num_rows int64) {
+	if num_rows <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, scope := range quotaScopes(user_name, table_name) {
+		m.add(quotaKey{scope, QuotaMetric_Rows}, now, num_rows)
+	}
+}
+
+func (m *QuotaManager) add(key quotaKey, now time.Time, n int64) {
+	m.counter(key).pending[now.Unix()/60] += n
+}
+
+// retryAfter returns the seconds until one more unit of the metric fits
+// in 'limit'.
+func retryAfter(c *quotaCounter, now time.Time, window int64, limit int64) int {
+	step := time.Duration(window) * time.Second
+	for wait := step; wait <= time.Duration(window)*time.Minute; wait += step {
+		if c.used(now.Add(wait), window)+1 <= limit {
+			return int(wait / time.Second)
+		}
+	}
+	return int(window * 60)
+}
+
+// usage returns the current usage of each metric of 'scope' against its limits.
+func (m *QuotaManager) usage(scope quotaScope) []ApiTypes.QuotaUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	limits := m.limitsOf(scope)
+	usage := []ApiTypes.QuotaUsage{}
+	for _, metric := range []string{QuotaMetric_Queries, QuotaMetric_Rows, QuotaMetric_Writes} {
+		window := quotaWindows[metric]
+		var used int64
+		if c, ok := m.counters[quotaKey{scope, metric}]; ok {
+			used = c.used(now, window)
+		}
+		limit := limits.limitOf(metric)
+		if !m.config.Enabled {
+			limit = 0
+		}
+		usage = append(usage, ApiTypes.QuotaUsage{
+			ScopeType: scope.scope_type,
+			ScopeName: scope.scope_name,
+			Metric:    metric,
+			Window:    fmt.Sprintf("%dm", window),
+			Used:      used,
+			Limit:     limit,
+		})
+	}
+	return usage
+}
+
+// maybeSync starts a background Sync if the last one is older than the
+// sync interval. Without a shared DB it only drops expired buckets.
+// The caller must hold m.mu.
+func (m *QuotaManager) maybeSync(now time.Time) {
+	if m.syncing || now.Sub(m.last_sync) < m.config.SyncInterval {
+		return
+	}
+	m.last_sync = now
+
+	if ApiTypes.SharedDBHandle == nil {
+		m.dropExpired(now.Unix()/60 - quotaWindows[QuotaMetric_Rows])
+		return
+	}
+
+	m.syncing = true
+	go func() {
+		rc := EchoFactory.NewRCAsAdmin("SHD_QTA_308")
+		defer rc.Close()
+		if err := m.Sync(rc); err != nil {
+			rc.GetLogger().Error("quota sync failed", "error", err)
+		}
+
+		m.mu.Lock()
+		m.syncing = false
+		m.mu.Unlock()
+	}()
+}
+
+// dropExpired deletes the buckets before 'oldest' and counters left empty.
+// The caller must hold m.mu.
+func (m *QuotaManager) dropExpired(oldest int64) {
+	for key, c := range m.counters {
+		for bucket := range c.synced {
+			if bucket < oldest {
+				delete(c.synced, bucket)
+			}
+		}
+		for bucket := range c.pending {
+			if bucket < oldest {
+				delete(c.pending, bucket)
+			}
+		}
+		if len(c.synced) == 0 && len(c.pending) == 0 {
+			delete(m.counters, key)
+		}
+	}
+}
+
+// Sync writes the usage counted by this instance to jimo_quota_usage,
+// then re-reads the totals of all instances and the limits. Once an
+// hour it also purges usage older than the retention.
+func (m *QuotaManager) Sync(rc ApiTypes.RequestContext) error {
+	if ApiTypes.SharedDBHandle == nil {
+		return fmt.Errorf("shared database not configured (SHD_QTA_347)")
+	}
+
+	m.sync_mu.Lock()
+	defer m.sync_mu.Unlock()
+
+	m.mu.Lock()
+	flushed := make(map[quotaKey]map[int64]int64)
+	var usage []sysdatastores.QuotaUsageDef
+	for key, c := range m.counters {
+		if len(c.pending) == 0 {
+			continue
+		}
+		buckets := make(map[int64]int64, len(c.pending))
+		for bucket, n := range c.pending {
+			buckets[bucket] = n
+			usage = append(usage, sysdatastores.QuotaUsageDef{
+				ScopeType: key.scope_type,
+				ScopeName: key.scope_name,
+				Metric:    key.metric,
+				Bucket:    bucket,
+				Count:     n,
+			})
+		}
+		flushed[key] = buckets
+	}
+	m.mu.Unlock()
+
+	now := time.Now()
+	cur := now.Unix() / 60
+	oldest := cur - quotaWindows[QuotaMetric_Rows]
+	if err := sysdatastores.AddQuotaUsage(rc, usage); err != nil {
+		// Still drop what is out of the window, so that usage that can't
+		// be written doesn't pile up until the DB is back.
+		m.mu.Lock()
+		m.dropExpired(oldest)
+		m.mu.Unlock()
+		return fmt.Errorf("failed to write quota usage (SHD_QTA_373): %w", err)
+	}
+
+	totals, read_err := sysdatastores.GetQuotaUsageSince(rc, oldest)
+	limits, limits_err := sysdatastores.ListQuotaLimits(rc)
+
+	m.mu.Lock()
+	// What was flushed is now in the DB totals. If they could not be
+	// read, keep it counted as synced until the next sync.
+	for key, buckets := range flushed {
+		c := m.counter(key)
+		for bucket, n := range buckets {
+			c.pending[bucket] -= n
+			if c.pending[bucket] <= 0 {
+				delete(c.pending, bucket)
+			}
+			if read_err != nil {
+				c.synced[bucket] += n
+			}
+		}
+	}
+
+	if read_err == nil {
+		for _, c := range m.counters {
+			c.synced = make(map[int64]int64)
+		}
+		for _, u := range totals {
+			key := quotaKey{quotaScope{u.ScopeType, u.ScopeName}, u.Metric}
+			m.counter(key).synced[u.Bucket] = u.Count
+		}
+	}
+	m.dropExpired(oldest)
+
+	if limits_err == nil {
+		overrides := make(map[quotaScope]QuotaLimits, len(limits))
+		for _, l := range limits {
+			overrides[quotaScope{l.ScopeType, l.ScopeName}] = QuotaLimits{
+				QueriesPerMinute: l.QueriesPerMinute,
+				RowsPerHour:      l.RowsPerHour,
+				WritesPerMinute:  l.WritesPerMinute,
+			}
+		}
+		m.overrides = overrides
+	}
+
+	purge := now.Sub(m.last_purge) >= time.Hour
+	if purge {
+		m.last_purge = now
+	}
+	m.mu.Unlock()
+
+	if purge {
+		if _, err := sysdatastores.PurgeQuotaUsage(rc, cur-quotaRetentionMinutes); err != nil {
+			rc.GetLogger().Error("failed to purge quota usage", "error", err)
+		}
+	}
+
+	return errors.Join(read_err, limits_err)
+}
+
+// quotaExceededResponse is the response of a Jimo request refused by
+// 'breach'. HandleJimoRequestEcho turns RetryAfterSec into the
+// Retry-After header.
+func quotaExceededResponse(
+	rc ApiTypes.RequestContext,
+	breach *ApiTypes.QuotaBreach,
+	call_flow string) (int, ApiTypes.JimoResponse) {
+	new_call_flow := fmt.Sprintf("%s->SHD_QTA_446", call_flow)
+	error_msg := fmt.Sprintf("quota_exceeded: %s %s used %d of %d %s, retry after %ds",
+		breach.ScopeType, breach.ScopeName, breach.Used, breach.Limit, breach.Metric, breach.RetryAfterSec)
+	rc.GetLogger().Warn("HandleJimoRequest", "error_msg", error_msg)
+
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_JimoRequest,
+		ActivityType: ApiTypes.ActivityType_QuotaExceeded,
+		AppName:      ApiTypes.AppName_RequestHandler,
+		ModuleName:   ApiTypes.ModuleName_RequestHandler,
+		ActivityMsg:  &error_msg,
+		CallerLoc:    new_call_flow})
+
+	return http.StatusTooManyRequests, ApiTypes.JimoResponse{
+		Status:     false,
+		ReqID:      rc.ReqID(),
+		ErrorMsg:   error_msg,
+		ErrorKind:  ApiTypes.ErrorKind_QuotaExceeded,
+		ErrorCode:  http.StatusTooManyRequests,
+		ResultType: "json",
+		Results:    breach,
+		Loc:        new_call_flow,
+	}
+}
+
+// GetMyUsage handles GET /shared_api/v1/usage/me
+//
+// Returns the caller's current usage of each quota against its limit.
+func GetMyUsage(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	user_info := rc.IsAuthenticated()
+	if user_info == nil {
+		return http.StatusUnauthorized, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: "Authentication required",
+			Loc:      "SHD_QTA_481",
+		})
+	}
+
+	usage := quotas.usage(quotaScope{sysdatastores.QuotaScope_User, user_info.UserName})
+	return http.StatusOK, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+		Status:     true,
+		ReqID:      rc.ReqID(),
+		ResultType: "json_array",
+		NumRecords: len(usage),
+		Results:    usage,
+		Loc:        "SHD_QTA_492",
+	})
+}
+
+// GetUsageReport handles GET /shared_api/v1/usage/report?hours=24
+//
+// Returns the usage of every user and table over the last 'hours' hours
+// (default 24, at most 48), highest first. Admin access is required.
+func GetUsageReport(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	if _, status_code, resp := requireAdmin(rc, "SHD_QTA_501"); resp != nil {
+		return status_code, ApiTypes.JSONPayload(resp)
+	}
+
+	hours := int64(24)
+	if hours_str := rc.QueryParam("hours"); hours_str != "" {
+		h, err := strconv.ParseInt(hours_str, 10, 64)
+		if err != nil || h < 1 || h > quotaRetentionMinutes/60 {
+			return http.StatusBadRequest, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+				Status:   false,
+				ReqID:    rc.ReqID(),
+				ErrorMsg: fmt.Sprintf("invalid hours:%s, must be 1 to %d", hours_str, quotaRetentionMinutes/60),
+				Loc:      "SHD_QTA_512",
+			})
+		}
+		hours = h
+	}
+
+	// Include what this instance has not written yet
+	if err := quotas.Sync(rc); err != nil {
+		rc.GetLogger().Error("quota sync failed", "error", err)
+	}
+
+	since := time.Now().Unix()/60 - hours*60 + 1
+	report, err := sysdatastores.QuotaUsageReport(rc, since)
+	if err != nil {
+		return http.StatusInternalServerError, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: err.Error(),
+			Loc:      "SHD_QTA_529",
+		})
+	}
+
+	return http.StatusOK, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+		Status:     true,
+		ReqID:      rc.ReqID(),
+		ResultType: "json_array",
+		NumRecords: len(report),
+		Results:    report,
+		Loc:        "SHD_QTA_538",
+	})
+}
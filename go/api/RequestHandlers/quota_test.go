@@ -0,0 +1,74 @@
+package RequestHandlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+func TestQuotaScopes(t *testing.T) {
+	tests := []struct {
+		name       string
+		table_name string
+		want_table bool
+	}{
+		{"table", "orders", true},
+		{"qualified table", "sales.orders", true},
+		{"no table", "", false},
+		{"not a table", "orders; drop table users", false},
+		{"too long", strings.Repeat("a.", 127) + "ab", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scopes := quotaScopes("tester", tt.table_name)
+			want := 1
+			if tt.want_table {
+				want = 2
+			}
+			if len(scopes) != want {
+				t.Fatalf("quotaScopes = %v, want %d scopes", scopes, want)
+			}
+			if scopes[0] != (quotaScope{sysdatastores.QuotaScope_User, "tester"}) {
+				t.Errorf("user scope = %v", scopes[0])
+			}
+		})
+	}
+
+	// A name that can't be a table adds no counter
+	m := NewQuotaManager(DefaultQuotaConfig())
+	m.Acquire("tester", strings.Repeat("x", 300), ApiTypes.ReqAction_Delete)
+	if len(m.counters) != 1 {
+		t.Errorf("%d counters, want the user's only", len(m.counters))
+	}
+}
+
+// A sync that can't write the usage still drops the buckets out of the
+// window, or the usage would grow until the DB is back
+func TestQuotaSyncDropsExpiredOnWriteFailure(t *testing.T) {
+	tdb := testharness.NewMockDB(t)
+	tdb.InstallShared(t)
+	tdb.Mock.ExpectBegin().WillReturnError(errors.New("connection refused"))
+
+	m := NewQuotaManager(DefaultQuotaConfig())
+	cur := time.Now().Unix() / 60
+	expired := quotaKey{quotaScope{sysdatastores.QuotaScope_User, "old"}, QuotaMetric_Writes}
+	live := quotaKey{quotaScope{sysdatastores.QuotaScope_User, "tester"}, QuotaMetric_Writes}
+	m.counter(expired).pending[cur-quotaWindows[QuotaMetric_Rows]-1] = 3
+	m.counter(live).pending[cur] = 1
+
+	err := m.Sync(testharness.NewFakeRequestContext(t, testUser()))
+	if err == nil || !strings.Contains(err.Error(), "SHD_QTA_373") {
+		t.Fatalf("Sync = %v, want a write failure", err)
+	}
+	if _, ok := m.counters[expired]; ok {
+		t.Error("expired counter kept")
+	}
+	if m.counters[live].pending[cur] != 1 {
+		t.Errorf("live counter = %v, want 1 pending", m.counters[live].pending)
+	}
+}
@@ -0,0 +1,102 @@
+package RequestHandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Jimo requests are decoded strictly: a top-level field the request type
+// does not have, such as a misspelled "conditon", fails the request
+// instead of being dropped. A delete or update whose condition is dropped
+// would otherwise run on the whole table. Nested objects (conditions,
+// field defs, ...) are not checked.
+
+var (
+	decode_mu            sync.RWMutex
+	allow_unknown_fields = false
+
+	// json field names of each request type, by type
+	request_field_names sync.Map
+)
+
+// SetAllowUnknownRequestFields makes the Jimo handlers ignore unknown
+// top-level request fields, e.g. to accept requests of newer clients.
+// They are rejected by default.
+func SetAllowUnknownRequestFields(allow bool) {
+	decode_mu.Lock()
+	defer decode_mu.Unlock()
+	allow_unknown_fields = allow
+}
+
+func getAllowUnknownRequestFields() bool {
+	decode_mu.RLock()
+	defer decode_mu.RUnlock()
+	return allow_unknown_fields
+}
+
+// decodeJimoRequest decodes 'body' into the request struct 'req' points to.
+// Unless SetAllowUnknownRequestFields(true) was called, it fails if the
+// body has top-level fields 'req' does not have.
+func decodeJimoRequest(body []byte, req interface{}) error {
+	if err := json.Unmarshal(body, req); err != nil {
+		return err
+	}
+	if getAllowUnknownRequestFields() {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return err
+	}
+	known := jsonFieldNames(reflect.TypeOf(req).Elem())
+	var unknown []string
+	for name := range fields {
+		if !known[strings.ToLower(name)] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown request fields: %s (SHD_RHD_1701)", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// jsonFieldNames returns the json names of the fields of struct type 't',
+// including those of its embedded structs, lower-cased as encoding/json
+// matches them case-insensitively.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	if names, ok := request_field_names.Load(t); ok {
+		return names.(map[string]bool)
+	}
+
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for embedded := range jsonFieldNames(f.Type) {
+				names[embedded] = true
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names[strings.ToLower(name)] = true
+	}
+	request_field_names.Store(t, names)
+	return names
+}
@@ -0,0 +1,77 @@
+package RequestHandlers
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+func TestUnknownRequestFields(t *testing.T) {
+	// deleteBody is a delete of user 3, plus 'extra' fields
+	deleteBody := func(extra map[string]interface{}) map[string]interface{} {
+		body := map[string]interface{}{
+			"request_type": ApiTypes.ReqAction_Delete,
+			"table_name":   "users",
+			"field_defs":   usersFieldDefs,
+			"condition":    atomicCond("id", "int", Equal, 3),
+		}
+		for name, value := range extra {
+			body[name] = value
+		}
+		return body
+	}
+	const deleteSQL = "DELETE FROM users WHERE id = $1"
+
+	t.Run("rejected", func(t *testing.T) {
+		installMock(t)
+
+		// The typo'd condition must not turn into a delete of the whole table
+		body := deleteBody(nil)
+		delete(body, "condition")
+		body["conditon"] = atomicCond("id", "int", Equal, 3)
+		status, resp := runJimo(t, testUser(), body)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest,
+			"unknown request fields: conditon")
+
+		status, resp = runJimo(t, testUser(), map[string]interface{}{
+			"request_type": ApiTypes.ReqAction_Update,
+			"table_name":   "users",
+			"field_defs":   usersFieldDefs,
+			"condition":    atomicCond("id", "int", Equal, 3),
+			"record":       map[string]interface{}{"name": "carl"},
+			"needs_record": true,
+			"recrod":       map[string]interface{}{},
+		})
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest,
+			"unknown request fields: needs_record, recrod")
+	})
+
+	t.Run("known fields", func(t *testing.T) {
+		tdb := installMock(t)
+		tdb.Mock.ExpectExec(deleteSQL).
+			WithArgs(int64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		// Field names match case-insensitively, as in encoding/json
+		body := deleteBody(map[string]interface{}{"Loc": "TEST"})
+		status, resp := runJimo(t, testUser(), body)
+		if status != ApiTypes.CustomHttpStatus_Success || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		SetAllowUnknownRequestFields(true)
+		t.Cleanup(func() { SetAllowUnknownRequestFields(false) })
+		tdb := installMock(t)
+		tdb.Mock.ExpectExec(deleteSQL).
+			WithArgs(int64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		status, resp := runJimo(t, testUser(), deleteBody(map[string]interface{}{"new_option": true}))
+		if status != ApiTypes.CustomHttpStatus_Success || !resp.Status {
+			t.Fatalf("status = %d, error_msg = %s", status, resp.ErrorMsg)
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package RequestHandlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/stores"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+// requireAdmin returns nil if the caller is an admin. Otherwise it returns
+// the status code and response to send.
+func requireAdmin(rc ApiTypes.RequestContext, loc string) (*ApiTypes.UserInfo, int, *ApiTypes.JimoResponse) {
+	userInfo := rc.IsAuthenticated()
+	if userInfo == nil {
+		return nil, http.StatusUnauthorized, &ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: "Authentication required",
+			Loc:      loc,
+		}
+	}
+
+	if !userInfo.Admin {
+		return nil, http.StatusForbidden, &ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: "Admin access required",
+			Loc:      loc,
+		}
+	}
+	return userInfo, http.StatusOK, nil
+}
+
+// ListResources handles GET /shared_api/v1/resources
+//
+// Returns all Jimo resource definitions. Admin access is required.
+func ListResources(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	if _, status_code, resp := requireAdmin(rc, "SHD_RSH_041"); resp != nil {
+		return status_code, ApiTypes.JSONPayload(resp)
+	}
+
+	resources, err := sysdatastores.ListResources(rc)
+	if err != nil {
+		return http.StatusInternalServerError, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: err.Error(),
+			Loc:      "SHD_RSH_050",
+		})
+	}
+
+	return http.StatusOK, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+		Status:     true,
+		ReqID:      rc.ReqID(),
+		ResultType: "json_array",
+		NumRecords: len(resources),
+		Results:    resources,
+		Loc:        "SHD_RSH_060",
+	})
+}
+
+// SaveResource handles POST /shared_api/v1/resources
+//
+// Creates or updates a resource definition, identified by
+// (resource_name, resource_opr). The definition is checked against the
+// live table schema first; if it does not match, nothing is saved and the
+// mismatches are returned in 'results'. Admin access is required.
+func SaveResource(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	logger := rc.GetLogger()
+	userInfo, status_code, resp := requireAdmin(rc, "SHD_RSH_071")
+	if resp != nil {
+		return status_code, ApiTypes.JSONPayload(resp)
+	}
+
+	var resource_info ApiTypes.ResourceDef
+	if err := rc.Bind(&resource_info); err != nil {
+		return http.StatusBadRequest, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: fmt.Sprintf("invalid request body:%v", err),
+			Loc:      "SHD_RSH_081",
+		})
+	}
+
+	switch resource_info.ResourceOpr {
+	case ApiTypes.ReqAction_Query, ApiTypes.ReqAction_Insert,
+		ApiTypes.ReqAction_Update, ApiTypes.ReqAction_Delete:
+	default:
+		return http.StatusBadRequest, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: fmt.Sprintf("invalid resource_opr:%s", resource_info.ResourceOpr),
+			Loc:      "SHD_RSH_093",
+		})
+	}
+
+	if resource_info.ResourceName == "" || resource_info.ResourceType == "" {
+		return http.StatusBadRequest, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: "resource_name and resource_type are required",
+			Loc:      "SHD_RSH_102",
+		})
+	}
+
+	if resource_info.ResourceStatus == "" {
+		resource_info.ResourceStatus = ApiTypes.ResourceStatus_Active
+	}
+
+	resource_def, err := stores.BuildResourceStoreDef(logger, resource_info)
+	if err != nil {
+		return http.StatusBadRequest, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: err.Error(),
+			Loc:      "SHD_RSH_115",
+		})
+	}
+
+	mismatches, err := stores.ValidateResourceSchema(rc, resource_def)
+	if err != nil {
+		return http.StatusInternalServerError, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: err.Error(),
+			Loc:      "SHD_RSH_125",
+		})
+	}
+
+	if len(mismatches) > 0 {
+		logger.Warn("resource def does not match table schema",
+			"r_name", resource_info.ResourceName,
+			"opr", resource_info.ResourceOpr,
+			"mismatches", mismatches)
+		return http.StatusBadRequest, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:     false,
+			ReqID:      rc.ReqID(),
+			ErrorMsg:   "resource definition does not match the table schema",
+			ResultType: "json_array",
+			NumRecords: len(mismatches),
+			Results:    mismatches,
+			Loc:        "SHD_RSH_140",
+		})
+	}
+
+	if _, err := sysdatastores.SaveResource(rc, &resource_info, userInfo.UserName); err != nil {
+		return http.StatusInternalServerError, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: err.Error(),
+			Loc:      "SHD_RSH_149",
+		})
+	}
+
+	if err := stores.ReloadResourceStore(); err != nil {
+		logger.Error("failed to reload resource store", "error", err)
+	}
+
+	msg := fmt.Sprintf("resource saved, resource:%s:%s, by:%s",
+		resource_info.ResourceName, resource_info.ResourceOpr, userInfo.UserName)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_SaveResource,
+		ActivityType: ApiTypes.ActivityType_Success,
+		AppName:      ApiTypes.AppName_RequestHandler,
+		ModuleName:   ApiTypes.ModuleName_ResourceStore,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_RSH_164"})
+
+	return http.StatusOK, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+		Status:     true,
+		ReqID:      rc.ReqID(),
+		ResultType: "json",
+		NumRecords: 1,
+		Results:    resource_info,
+		Loc:        "SHD_RSH_172",
+	})
+}
+
+// DeleteResource handles
+// DELETE /shared_api/v1/resources?resource_name=<name>&resource_opr=<opr>
+//
+// Admin access is required.
+func DeleteResource(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	logger := rc.GetLogger()
+	userInfo, status_code, resp := requireAdmin(rc, "SHD_RSH_181")
+	if resp != nil {
+		return status_code, ApiTypes.JSONPayload(resp)
+	}
+
+	resource_name := rc.QueryParam("resource_name")
+	resource_opr := rc.QueryParam("resource_opr")
+	if resource_name == "" || resource_opr == "" {
+		return http.StatusBadRequest, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: "Query parameters 'resource_name' and 'resource_opr' are required",
+			Loc:      "SHD_RSH_193",
+		})
+	}
+
+	found, err := sysdatastores.DeleteResource(rc, resource_name, resource_opr)
+	if err != nil {
+		return http.StatusInternalServerError, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: err.Error(),
+			Loc:      "SHD_RSH_202",
+		})
+	}
+
+	if !found {
+		return http.StatusNotFound, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+			Status:   false,
+			ReqID:    rc.ReqID(),
+			ErrorMsg: "Resource not found",
+			Loc:      "SHD_RSH_211",
+		})
+	}
+
+	if err := stores.ReloadResourceStore(); err != nil {
+		logger.Error("failed to reload resource store", "error", err)
+	}
+
+	msg := fmt.Sprintf("resource deleted, resource:%s:%s, by:%s",
+		resource_name, resource_opr, userInfo.UserName)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_SaveResource,
+		ActivityType: ApiTypes.ActivityType_Success,
+		AppName:      ApiTypes.AppName_RequestHandler,
+		ModuleName:   ApiTypes.ModuleName_ResourceStore,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_RSH_226"})
+
+	return http.StatusOK, ApiTypes.JSONPayload(ApiTypes.JimoResponse{
+		Status:  true,
+		ReqID:   rc.ReqID(),
+		Results: map[string]string{"message": "Resource deleted"},
+		Loc:     "SHD_RSH_233",
+	})
+}
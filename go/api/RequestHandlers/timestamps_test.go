@@ -0,0 +1,207 @@
+package RequestHandlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+// pgScan returns what lib/pq scans back for 'bound' stored in a column of
+// 'data_type'. A timestamptz comes back in the session zone; a naive
+// timestamp keeps the wall clock it was sent with.
+func pgScan(data_type string, bound time.Time) interface{} {
+	if data_type == "timestamptz" {
+		return bound.In(time.FixedZone("", -3*3600))
+	}
+	return time.Date(bound.Year(), bound.Month(), bound.Day(),
+		bound.Hour(), bound.Minute(), bound.Second(), bound.Nanosecond(), time.FixedZone("", 0))
+}
+
+// mysqlScan returns what the MySQL driver (without parseTime) scans back
+// for 'bound'. The driver sends time.Time in UTC, its default zone.
+func mysqlScan(_ string, bound time.Time) interface{} {
+	return []byte(bound.UTC().Format("2006-01-02 15:04:05.999999"))
+}
+
+func TestTimestampRoundTrip(t *testing.T) {
+	zones := map[string]*time.Location{}
+	for _, name := range []string{"UTC", "America/New_York", "Europe/London", "Asia/Kolkata", "Australia/Lord_Howe"} {
+		zones[name] = mustLoadLocation(t, name)
+	}
+
+	tests := []struct {
+		name    string
+		zone    string
+		value   string
+		wantUTC string
+		// For a wall clock repeated by DST, which instant is picked is
+		// not defined; only the wall clock in 'zone' is checked.
+		wantWall string
+	}{
+		{name: "rfc3339 offset ignores zone", zone: "Asia/Kolkata",
+			value: "2024-06-01T12:00:00-07:00", wantUTC: "2024-06-01T19:00:00Z"},
+		{name: "rfc3339 fraction", zone: "UTC",
+			value: "2024-06-01T12:00:00.123456Z", wantUTC: "2024-06-01T12:00:00.123456Z"},
+		{name: "legacy in winter", zone: "America/New_York",
+			value: "2024-01-15 08:00:00", wantUTC: "2024-01-15T13:00:00Z"},
+		{name: "legacy in summer", zone: "America/New_York",
+			value: "2024-07-15 08:00:00", wantUTC: "2024-07-15T12:00:00Z"},
+		{name: "after spring forward", zone: "America/New_York",
+			value: "2024-03-10 03:30:00", wantUTC: "2024-03-10T07:30:00Z"},
+		{name: "before spring forward", zone: "America/New_York",
+			value: "2024-03-10 01:59:59", wantUTC: "2024-03-10T06:59:59Z"},
+		{name: "repeated by fall back", zone: "America/New_York",
+			value: "2024-11-03 01:30:00", wantWall: "2024-11-03T01:30:00"},
+		{name: "london before bst", zone: "Europe/London",
+			value: "2024-03-31 00:59:59", wantUTC: "2024-03-31T00:59:59Z"},
+		{name: "london after bst", zone: "Europe/London",
+			value: "2024-03-31 02:00:00", wantUTC: "2024-03-31T01:00:00Z"},
+		{name: "half hour offset", zone: "Asia/Kolkata",
+			value: "2024-03-10 02:30", wantUTC: "2024-03-09T21:00:00Z"},
+		{name: "half hour dst", zone: "Australia/Lord_Howe",
+			value: "2024-10-06 02:30:00", wantUTC: "2024-10-05T15:30:00Z"},
+	}
+
+	drivers := map[string]func(string, time.Time) interface{}{
+		ApiTypes.PgName:    pgScan,
+		ApiTypes.MysqlName: mysqlScan,
+	}
+
+	for _, tt := range tests {
+		zone := zones[tt.zone]
+		for _, data_type := range []string{"timestamp", "timestamptz"} {
+			for db_name, scan := range drivers {
+				t.Run(tt.name+"/"+data_type+"/"+db_name, func(t *testing.T) {
+					bound, err := convertFieldValue(data_type, tt.value, zone)
+					if err != nil {
+						t.Fatalf("convert: %v", err)
+					}
+					scanned := scan(data_type, bound.(time.Time))
+
+					got := databaseutil.ConvertValueInZone(scanned, data_type, nil)
+					if tt.wantUTC != "" && got != tt.wantUTC {
+						t.Errorf("utc = %v, want %s", got, tt.wantUTC)
+					}
+
+					// Read back in the zone it was written in
+					got_zoned := databaseutil.ConvertValueInZone(scanned, data_type, zone).(string)
+					if tt.wantWall != "" && !strings.HasPrefix(got_zoned, tt.wantWall) {
+						t.Errorf("zoned = %s, want wall clock %s", got_zoned, tt.wantWall)
+					}
+					if tt.wantUTC != "" {
+						want, _ := time.Parse(time.RFC3339Nano, tt.wantUTC)
+						if want_zoned := want.In(zone).Format(time.RFC3339Nano); got_zoned != want_zoned {
+							t.Errorf("zoned = %s, want %s", got_zoned, want_zoned)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestTimestampDefaultZone(t *testing.T) {
+	t.Cleanup(func() { ApiUtils.SetDefaultTimeZone(nil) })
+	ApiUtils.SetDefaultTimeZone(mustLoadLocation(t, "Asia/Kolkata"))
+
+	// Without a request zone, legacy values are read in the default zone
+	bound, err := convertFieldValue("timestamp", "2024-01-02 08:34:05", nil)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if !bound.(time.Time).Equal(fixtureCreatedAt) {
+		t.Errorf("bound = %v, want %v", bound, fixtureCreatedAt)
+	}
+}
+
+func TestJimoTimestampZones(t *testing.T) {
+	tdb := installUsers(t)
+	bound := time.Date(2024, 7, 1, 13, 0, 0, 0, time.UTC)
+
+	if tdb.IsMock() {
+		tdb.Mock.ExpectBegin()
+		tdb.Mock.ExpectExec("INSERT INTO users (id,name,email,created_at) VALUES ($1,$2,$3,$4)").
+			WithArgs(4, "dave", "dave@example.com", bound).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		tdb.Mock.ExpectCommit()
+		tdb.Mock.ExpectQuery("SELECT users.id, users.created_at FROM users WHERE id = $1 ORDER BY id ASC LIMIT 11 OFFSET 0").
+			WithArgs(int64(4)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(int64(4), bound))
+	}
+
+	status, resp := runJimo(t, testUser(), ApiTypes.InsertRequest{
+		RequestType: ApiTypes.ReqAction_Insert,
+		TableName:   "users",
+		FieldDefs:   usersFieldDefs,
+		Records: []map[string]interface{}{
+			{"id": 4, "name": "dave", "email": "dave@example.com", "created_at": "2024-07-01 09:00:00"},
+		},
+		TimeZone: "America/New_York",
+	})
+	if status != http.StatusOK || !resp.Status {
+		t.Fatalf("insert status = %d, error_msg = %s", status, resp.ErrorMsg)
+	}
+
+	req := usersQuery(atomicCond("id", "int", Equal, 4))
+	req.FieldNames = []string{"users.id", "users.created_at"}
+	req.TimeZone = "Europe/London"
+	status, resp = runJimo(t, testUser(), req)
+	if status != http.StatusOK || !resp.Status {
+		t.Fatalf("query status = %d, error_msg = %s", status, resp.ErrorMsg)
+	}
+	rows, _ := resp.Results.([]map[string]interface{})
+	if len(rows) != 1 || rows[0]["created_at"] != "2024-07-01T14:00:00+01:00" {
+		t.Errorf("results = %v, want created_at 2024-07-01T14:00:00+01:00", resp.Results)
+	}
+
+	t.Run("invalid zone", func(t *testing.T) {
+		req.TimeZone = "Mars/Olympus_Mons"
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "invalid time zone")
+	})
+}
+
+func TestJimoTimestampFormat(t *testing.T) {
+	tdb := installUsers(t)
+	if tdb.IsMock() {
+		tdb.Mock.ExpectQuery("SELECT users.id, users.created_at FROM users WHERE id = $1 ORDER BY id ASC LIMIT 11 OFFSET 0").
+			WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(int64(1), fixtureCreatedAt))
+	}
+
+	field_defs := make([]ApiTypes.FieldDef, len(usersFieldDefs))
+	copy(field_defs, usersFieldDefs)
+	for i := range field_defs {
+		if field_defs[i].FieldName == "created_at" {
+			field_defs[i].TimestampFormat = "unix"
+		}
+	}
+
+	req := usersQuery(atomicCond("id", "int", Equal, 1))
+	req.FieldNames = []string{"users.id", "users.created_at"}
+	req.FieldDefs = field_defs
+	status, resp := runJimo(t, testUser(), req)
+	if status != http.StatusOK || !resp.Status {
+		t.Fatalf("query status = %d, error_msg = %s", status, resp.ErrorMsg)
+	}
+	rows, _ := resp.Results.([]map[string]interface{})
+	if len(rows) != 1 || rows[0]["created_at"] != fixtureCreatedAt.Unix() {
+		t.Errorf("results = %v, want created_at %d", resp.Results, fixtureCreatedAt.Unix())
+	}
+
+	t.Run("invalid format", func(t *testing.T) {
+		for i := range field_defs {
+			if field_defs[i].FieldName == "created_at" {
+				field_defs[i].TimestampFormat = "iso"
+			}
+		}
+		status, resp := runJimo(t, testUser(), req)
+		expectFailure(t, status, resp, ApiTypes.CustomHttpStatus_BadRequest, ApiTypes.ErrorKind_InvalidRequest, "invalid timestamp format:iso")
+	})
+}
@@ -0,0 +1,124 @@
+package attachments
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/objstore"
+)
+
+// DefaultAllowedMimeTypes are accepted when no allow-list is configured
+var DefaultAllowedMimeTypes = []string{
+	"application/pdf",
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"text/plain",
+	"text/csv",
+	"application/zip",
+	"application/msword",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"application/vnd.ms-excel",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// Config configures the attachments service
+type Config struct {
+	// Backend is objstore.BackendLocal or objstore.BackendS3
+	Backend  string
+	LocalDir string
+	S3       objstore.S3Config
+
+	MaxSize          int64
+	AllowedMimeTypes map[string]bool
+
+	// SweepInterval: how often the orphan sweep runs (0 disables it)
+	SweepInterval time.Duration
+	// SweepGrace: objects and rows younger than this are never swept, so
+	// uploads in progress are not mistaken for orphans
+	SweepGrace time.Duration
+}
+
+// LoadConfig builds the config from the [attachments] section of
+// LibConfig and the environment:
+//
+//   - DATA_HOME_DIR: the local backend stores files in DATA_HOME_DIR/<data_dir>
+//   - ATTACHMENTS_S3_ENDPOINT, _REGION, _BUCKET, _ACCESS_KEY, _SECRET_KEY,
+//     _PATH_STYLE, _PREFIX: the S3 backend
+//   - ATTACHMENTS_SWEEP_INTERVAL (default 24h), ATTACHMENTS_SWEEP_GRACE (default 1h)
+func LoadConfig() (Config, error) {
+	conf := ApiTypes.LibConfig.AttachmentsConf
+	cfg := Config{
+		Backend:          conf.Backend,
+		MaxSize:          25 << 20,
+		AllowedMimeTypes: map[string]bool{},
+		SweepInterval:    24 * time.Hour,
+		SweepGrace:       time.Hour,
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = objstore.BackendLocal
+	}
+	if conf.MaxSizeMB > 0 {
+		cfg.MaxSize = int64(conf.MaxSizeMB) << 20
+	}
+
+	mime_types := conf.AllowedMimeTypes
+	if len(mime_types) == 0 {
+		mime_types = DefaultAllowedMimeTypes
+	}
+	for _, mime_type := range mime_types {
+		cfg.AllowedMimeTypes[strings.ToLower(strings.TrimSpace(mime_type))] = true
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("ATTACHMENTS_SWEEP_INTERVAL")); err == nil && d >= 0 {
+		cfg.SweepInterval = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("ATTACHMENTS_SWEEP_GRACE")); err == nil && d > 0 {
+		cfg.SweepGrace = d
+	}
+
+	switch cfg.Backend {
+	case objstore.BackendLocal:
+		data_home_dir := os.Getenv("DATA_HOME_DIR")
+		if data_home_dir == "" {
+			return cfg, fmt.Errorf("Missing DATA_HOME_DIR env variable (SHD_ATS_085)")
+		}
+		data_dir := conf.DataDir
+		if data_dir == "" {
+			data_dir = "attachments"
+		}
+		cfg.LocalDir = filepath.Join(data_home_dir, data_dir)
+
+	case objstore.BackendS3:
+		cfg.S3 = objstore.S3ConfigFromEnv("ATTACHMENTS_")
+
+	default:
+		return cfg, fmt.Errorf("unknown attachments backend %q (SHD_ATS_097)", cfg.Backend)
+	}
+	return cfg, nil
+}
+
+// allowedMimeTypesString lists the allowed MIME types for error messages
+func (c Config) allowedMimeTypesString() string {
+	types := make([]string, 0, len(c.AllowedMimeTypes))
+	for t := range c.AllowedMimeTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ", ")
+}
+
+// maxSizeString formats MaxSize for error messages
+func (c Config) maxSizeString() string {
+	if c.MaxSize%(1<<20) == 0 {
+		return strconv.FormatInt(c.MaxSize>>20, 10) + "MB"
+	}
+	return strconv.FormatInt(c.MaxSize, 10) + " bytes"
+}
@@ -0,0 +1,65 @@
+package attachments
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrRangeNotSatisfiable: the requested range starts beyond the end
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// ParseRange parses a Range header for a resource of 'size' bytes and
+// returns the offset and length to serve. It returns ok=false when the
+// whole resource should be served: no header, a malformed header, or
+// several ranges (serving the full body is allowed for those).
+func ParseRange(header string, size int64) (offset int64, length int64, ok bool, err error) {
+	if header == "" || !strings.HasPrefix(header, "bytes=") {
+		return 0, size, false, nil
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	if spec == "" || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	start_str, end_str, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, size, false, nil
+	}
+	start_str = strings.TrimSpace(start_str)
+	end_str = strings.TrimSpace(end_str)
+
+	// Suffix range: the last N bytes
+	if start_str == "" {
+		n, err := strconv.ParseInt(end_str, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size, false, nil
+		}
+		if n == 0 {
+			return 0, 0, false, ErrRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	start, err := strconv.ParseInt(start_str, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, false, nil
+	}
+	if start >= size {
+		return 0, 0, false, ErrRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if end_str != "" {
+		end, err = strconv.ParseInt(end_str, 10, 64)
+		if err != nil || end < start {
+			return 0, size, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true, nil
+}
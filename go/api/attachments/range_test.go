@@ -0,0 +1,40 @@
+package attachments
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		header string
+		offset int64
+		length int64
+		ok     bool
+		err    error
+	}{
+		{"", 0, 100, false, nil},
+		{"bytes=0-9", 0, 10, true, nil},
+		{"bytes=90-", 90, 10, true, nil},
+		{"bytes=90-200", 90, 10, true, nil},
+		{"bytes=-20", 80, 20, true, nil},
+		{"bytes=-500", 0, 100, true, nil},
+		{"bytes=0-1,5-6", 0, 100, false, nil},
+		{"bytes=9-3", 0, 100, false, nil},
+		{"items=0-1", 0, 100, false, nil},
+		{"bytes=100-", 0, 0, false, ErrRangeNotSatisfiable},
+		{"bytes=-0", 0, 0, false, ErrRangeNotSatisfiable},
+	}
+
+	for _, c := range cases {
+		offset, length, ok, err := ParseRange(c.header, 100)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%q: got error %v, want %v", c.header, err, c.err)
+			continue
+		}
+		if err == nil && (offset != c.offset || length != c.length || ok != c.ok) {
+			t.Errorf("%q: got (%d, %d, %v), want (%d, %d, %v)",
+				c.header, offset, length, ok, c.offset, c.length, c.ok)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Package attachments stores user-uploaded files attached to records of
+// the host app. Metadata lives in the attachments table; the contents
+// live in an objstore backend (local disk or S3). Access is decided by
+// the host app through an ApiTypes.AttachmentAccessFunc.
+package attachments
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/objstore"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrTooLarge: the upload exceeds Config.MaxSize
+	ErrTooLarge = errors.New("attachment too large")
+	// ErrMimeNotAllowed: the MIME type is not in the allow-list
+	ErrMimeNotAllowed = errors.New("attachment type not allowed")
+	// ErrNotInitialized: InitAttachmentService has not run
+	ErrNotInitialized = errors.New("attachment service not initialized")
+)
+
+// Service uploads, serves and deletes attachments
+type Service struct {
+	cfg   Config
+	store objstore.Store
+}
+
+// NewService creates a service on 'store'
+func NewService(cfg Config, store objstore.Store) *Service {
+	return &Service{cfg: cfg, store: store}
+}
+
+var (
+	defaultService *Service
+
+	accessMu      sync.RWMutex
+	accessChecker ApiTypes.AttachmentAccessFunc
+)
+
+// InitAttachmentService creates the default service from LoadConfig and
+// starts the orphan sweeper. Call it during application startup.
+func InitAttachmentService(rc ApiTypes.RequestContext) error {
+	logger := rc.GetLogger()
+	if ApiTypes.LibConfig.AttachmentsConf.EnableAttachments != "enabled" {
+		logger.Info("attachment service is disabled")
+		return nil
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		logger.Error("invalid attachments config", "error", err)
+		return err
+	}
+
+	var store objstore.Store
+	switch cfg.Backend {
+	case objstore.BackendS3:
+		store, err = objstore.NewS3Store(cfg.S3)
+	default:
+		store, err = objstore.NewLocalStore(cfg.LocalDir)
+	}
+	if err != nil {
+		logger.Error("failed to create attachments store", "error", err, "backend", cfg.Backend)
+		return fmt.Errorf("failed to create attachments store (SHD_ATS_079): %w", err)
+	}
+
+	SetService(NewService(cfg, store))
+	if cfg.SweepInterval > 0 {
+		StartSweeper(logger, cfg.SweepInterval)
+	}
+	logger.Info("Attachment service initialized",
+		"backend", cfg.Backend,
+		"max_size", cfg.maxSizeString())
+	return nil
+}
+
+// SetService sets the default service (e.g. in tests)
+func SetService(svc *Service) {
+	defaultService = svc
+}
+
+// GetService returns the default service, or nil before initialization
+func GetService() *Service {
+	return defaultService
+}
+
+// MaxSize returns the largest accepted upload, in bytes
+func (s *Service) MaxSize() int64 {
+	return s.cfg.MaxSize
+}
+
+// SetAccessChecker sets the callback that decides who may read, upload
+// to and delete the attachments of a record. Without one, only admins
+// have access.
+func SetAccessChecker(fn ApiTypes.AttachmentAccessFunc) {
+	accessMu.Lock()
+	defer accessMu.Unlock()
+	accessChecker = fn
+}
+
+// CanAccess reports whether 'user' has 'access' to the attachments of
+// owner_table/owner_id.
+func CanAccess(
+	rc ApiTypes.RequestContext,
+	user *ApiTypes.UserInfo,
+	owner_table string,
+	owner_id string,
+	access string) bool {
+	if user == nil {
+		return false
+	}
+	accessMu.RLock()
+	fn := accessChecker
+	accessMu.RUnlock()
+	if fn == nil {
+		return user.Admin
+	}
+	return fn(rc, user, owner_table, owner_id, access)
+}
+
+// normalizeMimeType strips parameters ("text/plain; charset=utf-8") and
+// lower-cases the type.
+func normalizeMimeType(mime_type string) string {
+	media_type, _, err := mime.ParseMediaType(mime_type)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(mime_type))
+	}
+	return media_type
+}
+
+// Upload stores the contents of r and inserts the metadata row. The
+// declared MIME type is used unless it is empty or
+// application/octet-stream, in which case the type is sniffed. r is
+// spooled to a temporary file to enforce MaxSize and compute the SHA-256
+// before anything reaches the backend.
+func (s *Service) Upload(
+	rc ApiTypes.RequestContext,
+	owner_table string,
+	owner_id string,
+	file_name string,
+	mime_type string,
+	r io.Reader,
+	creator string) (*ApiTypes.AttachmentDef, error) {
+	logger := rc.GetLogger()
+
+	tmp, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file (SHD_ATS_166): %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(r, s.cfg.MaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload (SHD_ATS_174): %w", err)
+	}
+	if size > s.cfg.MaxSize {
+		return nil, fmt.Errorf("%w: limit is %s (SHD_ATS_177)", ErrTooLarge, s.cfg.maxSizeString())
+	}
+
+	mime_type = normalizeMimeType(mime_type)
+	if mime_type == "" || mime_type == "application/octet-stream" {
+		head := make([]byte, 512)
+		n, _ := tmp.ReadAt(head, 0)
+		mime_type = normalizeMimeType(http.DetectContentType(head[:n]))
+	}
+	if !s.cfg.AllowedMimeTypes[mime_type] {
+		return nil, fmt.Errorf("%w: %s, allowed: %s (SHD_ATS_187)",
+			ErrMimeNotAllowed, mime_type, s.cfg.allowedMimeTypesString())
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind upload (SHD_ATS_192): %w", err)
+	}
+
+	now := time.Now().UTC()
+	id := uuid.New().String()
+	def := &ApiTypes.AttachmentDef{
+		ID:             id,
+		OwnerTable:     owner_table,
+		OwnerID:        owner_id,
+		FileName:       filepath.Base(strings.ReplaceAll(file_name, "\\", "/")),
+		MimeType:       mime_type,
+		Size:           size,
+		StorageBackend: s.store.Backend(),
+		StorageKey:     now.Format("2006/01/") + id,
+		SHA256:         hex.EncodeToString(hash.Sum(nil)),
+		Creator:        creator,
+		CreatedAt:      now,
+	}
+
+	if err := s.store.Put(rc.Context(), def.StorageKey, tmp, size, mime_type); err != nil {
+		logger.Error("failed to store attachment", "error", err, "key", def.StorageKey)
+		return nil, fmt.Errorf("failed to store attachment (SHD_ATS_212): %w", err)
+	}
+
+	if err := sysdatastores.InsertAttachment(rc, def); err != nil {
+		// The sweep would catch it too, but clean up right away
+		if del_err := s.store.Delete(rc.Context(), def.StorageKey); del_err != nil {
+			logger.Warn("failed to delete stored attachment", "error", del_err, "key", def.StorageKey)
+		}
+		return nil, err
+	}
+
+	logger.Info("Attachment uploaded",
+		"id", def.ID,
+		"owner_table", owner_table,
+		"owner_id", owner_id,
+		"size", size)
+	return def, nil
+}
+
+// Open returns the contents of the attachment from byte 'offset' on;
+// 'length' < 0 reads to the end.
+func (s *Service) Open(
+	rc ApiTypes.RequestContext,
+	def *ApiTypes.AttachmentDef,
+	offset int64,
+	length int64) (io.ReadCloser, error) {
+	if def.StorageBackend != s.store.Backend() {
+		return nil, fmt.Errorf("attachment %s is stored on %s, the service uses %s (SHD_ATS_239)",
+			def.ID, def.StorageBackend, s.store.Backend())
+	}
+	return s.store.Get(rc.Context(), def.StorageKey, offset, length)
+}
+
+// Delete deletes the metadata row, then the stored object. If deleting
+// the object fails, the sweep removes it later.
+func (s *Service) Delete(rc ApiTypes.RequestContext, def *ApiTypes.AttachmentDef) error {
+	if err := sysdatastores.DeleteAttachment(rc, def.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if def.StorageBackend == s.store.Backend() {
+		if err := s.store.Delete(rc.Context(), def.StorageKey); err != nil {
+			rc.GetLogger().Warn("failed to delete stored attachment, left to the sweep",
+				"error", err, "key", def.StorageKey)
+		}
+	}
+	rc.GetLogger().Info("Attachment deleted", "id", def.ID)
+	return nil
+}
+
+// Sweep removes storage objects whose metadata row is gone, and rows
+// whose storage object is gone. Anything younger than SweepGrace is left
+// alone. With dry_run it only counts.
+func (s *Service) Sweep(rc ApiTypes.RequestContext, dry_run bool) (*ApiTypes.AttachmentSweepResult, error) {
+	logger := rc.GetLogger()
+	ctx := rc.Context()
+	cutoff := time.Now().Add(-s.cfg.SweepGrace)
+	result := &ApiTypes.AttachmentSweepResult{DryRun: dry_run}
+
+	rows := map[string]*ApiTypes.AttachmentDef{}
+	err := sysdatastores.ListAttachmentsByBackend(rc, s.store.Backend(), func(def *ApiTypes.AttachmentDef) error {
+		rows[def.StorageKey] = def
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. Objects without a row
+	objects := map[string]bool{}
+	err = s.store.List(ctx, "", func(info objstore.ObjectInfo) error {
+		objects[info.Key] = true
+		if _, ok := rows[info.Key]; ok || info.ModTime.After(cutoff) {
+			return nil
+		}
+		result.ObjectsDeleted++
+		if dry_run {
+			return nil
+		}
+		if err := s.store.Delete(ctx, info.Key); err != nil {
+			logger.Warn("failed to delete orphan object", "error", err, "key", info.Key)
+			result.ObjectsDeleted--
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored attachments (SHD_ATS_298): %w", err)
+	}
+
+	// 2. Rows without an object
+	for key, def := range rows {
+		if objects[key] || def.CreatedAt.After(cutoff) {
+			continue
+		}
+		result.RowsDeleted++
+		if dry_run {
+			continue
+		}
+		if err := sysdatastores.DeleteAttachment(rc, def.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			logger.Warn("failed to delete orphan attachment row", "error", err, "id", def.ID)
+			result.RowsDeleted--
+		}
+	}
+
+	logger.Info("Attachment sweep complete",
+		"objects_deleted", result.ObjectsDeleted,
+		"rows_deleted", result.RowsDeleted,
+		"dry_run", dry_run)
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package attachments
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/EchoFactory"
+)
+
+var (
+	sweeperCancel context.CancelFunc
+	sweeping      atomic.Bool
+)
+
+// StartSweeper runs the orphan sweep of the default service every
+// 'interval' until StopSweeper is called.
+func StartSweeper(logger ApiTypes.JimoLogger, interval time.Duration) {
+	if sweeperCancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sweeperCancel = cancel
+	go sweepLoop(ctx, logger, interval)
+}
+
+// StopSweeper stops the background sweep
+func StopSweeper() {
+	if sweeperCancel != nil {
+		sweeperCancel()
+		sweeperCancel = nil
+	}
+}
+
+func sweepLoop(ctx context.Context, logger ApiTypes.JimoLogger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rc := EchoFactory.NewRCAsAdmin("SHD_ATS_043")
+			if _, err := RunSweep(rc, false); err != nil {
+				logger.Warn("attachments: periodic sweep failed", "error", err)
+			}
+			rc.Close()
+		}
+	}
+}
+
+// RunSweep sweeps the default service. Concurrent calls beyond the first
+// are no-ops and return a nil result.
+func RunSweep(rc ApiTypes.RequestContext, dry_run bool) (*ApiTypes.AttachmentSweepResult, error) {
+	svc := GetService()
+	if svc == nil {
+		return nil, ErrNotInitialized
+	}
+	if !sweeping.CompareAndSwap(false, true) {
+		rc.GetLogger().Info("attachments: sweep already in progress, skipping")
+		return nil, nil
+	}
+	defer sweeping.Store(false)
+	return svc.Sweep(rc, dry_run)
+}
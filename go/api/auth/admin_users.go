@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/EchoFactory"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+	"github.com/labstack/echo/v4"
+)
+
+// Account overrides for support staff:
+//
+//   - POST /auth/admin/verify-user     (admin) marks a user's email verified
+//   - POST /auth/admin/reset-password  (admin) sets a temporary password or
+//     issues a reset token
+//
+// Both take {"user_id": "..."} or {"email": "..."}. Every action is saved
+// in the activity log with the acting admin, and the response returns the
+// audit record so that support tools can show it.
+
+const (
+	// AdminResetModeTemporary sets a random password that the admin
+	// passes on to the user. It is returned once and not stored, and
+	// stops working after temporaryPasswordTTL unless the user sets a
+	// new one.
+	AdminResetModeTemporary = "temporary_password"
+
+	// AdminResetModeToken issues a reset token, like the forgot password
+	// flow, and returns the reset link instead of sending it.
+	AdminResetModeToken = "reset_token"
+)
+
+// temporaryPasswordLength is long enough to pass the default password
+// requirements. The alphabet leaves out look-alike characters because the
+// password is often read out or typed by hand.
+const (
+	temporaryPasswordLength   = 16
+	temporaryPasswordAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz23456789"
+	temporaryPasswordTTL      = 24 * time.Hour
+)
+
+type AdminUserRequest struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	// Mode is AdminResetModeTemporary (default) or AdminResetModeToken.
+	// Only used by AdminResetPassword.
+	Mode string `json:"mode,omitempty"`
+}
+
+// AdminAuditRecord describes an admin action on a user account. It
+// matches the activity log entry LogID.
+type AdminAuditRecord struct {
+	LogID      int64     `json:"log_id"`
+	Action     string    `json:"action"`
+	UserID     string    `json:"user_id"`
+	Email      string    `json:"email"`
+	AdminID    string    `json:"admin_id"`
+	AdminEmail string    `json:"admin_email"`
+	Time       time.Time `json:"time"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+func HandleAdminVerifyUser(c echo.Context) error {
+	return EchoFactory.WrapEcho(AdminVerifyUser)(c)
+}
+
+func HandleAdminResetPassword(c echo.Context) error {
+	return EchoFactory.WrapEcho(AdminResetPassword)(c)
+}
+
+func adminUserError(status_code int, message string, loc string) (int, ApiTypes.ResponsePayload) {
+	return status_code, ApiTypes.JSONPayload(map[string]string{
+		"status":  "error",
+		"message": message,
+		"loc":     loc,
+	})
+}
+
+// adminTargetUser checks that the caller is an admin and finds the user
+// the request is about. On failure it returns the response to send.
+func adminTargetUser(
+	rc ApiTypes.RequestContext,
+	req *AdminUserRequest) (*ApiTypes.UserInfo, *ApiTypes.UserInfo, int, ApiTypes.ResponsePayload) {
+	admin_info := rc.IsAuthenticated()
+	if admin_info == nil {
+		status_code, resp := adminUserError(http.StatusUnauthorized, "Authentication required", "SHD_AAU_081")
+		return nil, nil, status_code, resp
+	}
+	if !admin_info.Admin {
+		rc.GetLogger().Warn("non-admin called an admin account endpoint", "email", admin_info.Email)
+		status_code, resp := adminUserError(http.StatusForbidden, "Admin access required", "SHD_AAU_086")
+		return nil, nil, status_code, resp
+	}
+
+	if err := rc.Bind(req); err != nil || (req.UserID == "" && req.Email == "") {
+		status_code, resp := adminUserError(http.StatusBadRequest, "user_id or email is required", "SHD_AAU_091")
+		return nil, nil, status_code, resp
+	}
+
+	var user_info *ApiTypes.UserInfo
+	var exist bool
+	if req.UserID != "" {
+		user_info, exist = rc.GetUserInfoByUserID(req.UserID)
+	} else {
+		user_info, exist = rc.GetUserInfoByEmail(req.Email)
+	}
+	if !exist || user_info == nil {
+		status_code, resp := adminUserError(http.StatusNotFound, "User not found", "SHD_AAU_102")
+		return nil, nil, status_code, resp
+	}
+	return admin_info, user_info, 0, ApiTypes.ResponsePayload{}
+}
+
+// logAdminAction saves the action in the activity log and returns its
+// audit record.
+func logAdminAction(
+	admin_info *ApiTypes.UserInfo,
+	user_info *ApiTypes.UserInfo,
+	activity_type string,
+	detail string,
+	loc string) AdminAuditRecord {
+	record := AdminAuditRecord{
+		LogID:      sysdatastores.NextActivityLogID(),
+		Action:     activity_type,
+		UserID:     user_info.UserId,
+		Email:      user_info.Email,
+		AdminID:    admin_info.UserId,
+		AdminEmail: admin_info.Email,
+		Time:       time.Now().UTC(),
+		Detail:     detail,
+	}
+	msg := fmt.Sprintf("%s, user_id:%s, email:%s, by:%s (%s), %s",
+		activity_type, record.UserID, record.Email, record.AdminEmail, record.AdminID, detail)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		LogID:        record.LogID,
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: activity_type,
+		AppName:      ApiTypes.AppName_Auth,
+		ModuleName:   ApiTypes.ModuleName_EmailAuth,
+		ActivityMsg:  &msg,
+		CallerLoc:    loc})
+	return record
+}
+
+// AdminVerifyUser handles POST /auth/admin/verify-user. It marks the
+// user's email verified without the verification link, e.g. when the
+// email never arrives. No welcome email is sent.
+func AdminVerifyUser(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	logger := rc.GetLogger()
+	var req AdminUserRequest
+	admin_info, user_info, status_code, resp := adminTargetUser(rc, &req)
+	if user_info == nil {
+		return status_code, resp
+	}
+
+	detail := "verified"
+	if user_info.Verified {
+		detail = "already verified"
+	} else if _, err := rc.MarkUserVerified(user_info.Email); err != nil {
+		logger.Error("admin failed to mark user verified",
+			"error", err,
+			"email", user_info.Email,
+			"admin", admin_info.Email)
+		return adminUserError(http.StatusInternalServerError, "failed to mark user verified", "SHD_AAU_159")
+	} else {
+		publishAuthEvent(AuthEventVerified, user_info)
+	}
+
+	audit := logAdminAction(admin_info, user_info, ApiTypes.ActivityType_AdminVerifyUser, detail, "SHD_AAU_164")
+	logger.Info("admin verified user",
+		"email", user_info.Email,
+		"admin", admin_info.Email,
+		"log_id", audit.LogID)
+
+	return http.StatusOK, ApiTypes.JSONPayload(map[string]interface{}{
+		"status": "ok",
+		"audit":  audit,
+		"loc":    "SHD_AAU_173",
+	})
+}
+
+// AdminResetPassword handles POST /auth/admin/reset-password. With
+// AdminResetModeTemporary it sets a random password and returns it as
+// "temporary_password", with the time it stops working as "expires_at";
+// the user must set a new one before then. With AdminResetModeToken it
+// issues a reset token and returns the reset link as "reset_url", which
+// needs APP_BASE_URL. Both need the users table, so the routes are not
+// registered with Kratos.
+func AdminResetPassword(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	logger := rc.GetLogger()
+	var req AdminUserRequest
+	admin_info, user_info, status_code, resp := adminTargetUser(rc, &req)
+	if user_info == nil {
+		return status_code, resp
+	}
+
+	switch req.Mode {
+	case "", AdminResetModeTemporary:
+		password, err := temporaryPassword()
+		if err != nil {
+			logger.Error("failed to generate temporary password", "error", err)
+			return adminUserError(http.StatusInternalServerError, "failed to generate password", "SHD_AAU_194")
+		}
+		expires_at := time.Now().Add(temporaryPasswordTTL)
+		if ok, status_code, msg := rc.SetTemporaryPassword(user_info.Email, password, expires_at); !ok {
+			logger.Error("admin failed to set temporary password",
+				"email", user_info.Email,
+				"admin", admin_info.Email,
+				"error", msg)
+			return adminUserError(status_code, "failed to set password", "SHD_AAU_200")
+		}
+		publishAuthEvent(AuthEventPasswordReset, user_info)
+
+		audit := logAdminAction(admin_info, user_info, ApiTypes.ActivityType_AdminResetPassword,
+			"temporary password set", "SHD_AAU_205")
+		logger.Info("admin set temporary password",
+			"email", user_info.Email,
+			"admin", admin_info.Email,
+			"log_id", audit.LogID)
+
+		return http.StatusOK, ApiTypes.JSONPayload(map[string]interface{}{
+			"status":             "ok",
+			"temporary_password": password,
+			"expires_at":         expires_at,
+			"audit":              audit,
+			"loc":                "SHD_AAU_215",
+		})
+
+	case AdminResetModeToken:
+		base_url := os.Getenv("APP_BASE_URL")
+		if base_url == "" {
+			logger.Error("APP_BASE_URL not set, can't build the reset link", "admin", admin_info.Email)
+			return adminUserError(http.StatusInternalServerError, "APP_BASE_URL not configured", "SHD_AAU_223")
+		}
+		token, err := issueToken(rc, func(token string) error {
+			return rc.UpdateTokenByEmail(user_info.Email, token)
+		})
+		if err != nil {
+			logger.Error("admin failed to issue reset token",
+				"email", user_info.Email,
+				"admin", admin_info.Email,
+				"error", err)
+			return adminUserError(http.StatusInternalServerError, "failed to issue reset token", "SHD_AAU_225")
+		}
+
+		audit := logAdminAction(admin_info, user_info, ApiTypes.ActivityType_AdminResetPassword,
+			"reset token issued", "SHD_AAU_229")
+		logger.Info("admin issued reset token",
+			"email", user_info.Email,
+			"admin", admin_info.Email,
+			"log_id", audit.LogID)
+
+		return http.StatusOK, ApiTypes.JSONPayload(map[string]interface{}{
+			"status":    "ok",
+			"reset_url": fmt.Sprintf("%s/reset-password?token=%s", base_url, token),
+			"audit":     audit,
+			"loc":       "SHD_AAU_239",
+		})
+
+	default:
+		return adminUserError(http.StatusBadRequest,
+			fmt.Sprintf("invalid mode:%s, expecting %s or %s", req.Mode, AdminResetModeTemporary, AdminResetModeToken),
+			"SHD_AAU_245")
+	}
+}
+
+// temporaryPassword returns a random password that meets the default
+// password requirements
+func temporaryPassword() (string, error) {
+	max := big.NewInt(int64(len(temporaryPasswordAlphabet)))
+	buf := make([]byte, temporaryPasswordLength)
+	for {
+		for i := range buf {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			buf[i] = temporaryPasswordAlphabet[n.Int64()]
+		}
+		if password := string(buf); ValidatePasswordDefault(password).Valid {
+			return password, nil
+		}
+	}
+}
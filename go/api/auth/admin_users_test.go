@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+func newAdminRC(t *testing.T, caller *ApiTypes.UserInfo) *testharness.FakeRequestContext {
+	rc := testharness.NewFakeRequestContext(t, caller)
+	rc.Users["bob@example.com"] = &ApiTypes.UserInfo{
+		UserId: "u2", UserName: "bob", Email: "bob@example.com", UserStatus: "active"}
+	return rc
+}
+
+var testAdmin = &ApiTypes.UserInfo{UserId: "a1", Email: "admin@example.com", Admin: true}
+
+func TestAdminUserEndpointsRequireAdmin(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller *ApiTypes.UserInfo
+		want   int
+	}{
+		{name: "anonymous", want: http.StatusUnauthorized},
+		{name: "not admin", caller: &ApiTypes.UserInfo{UserId: "u3", Email: "carol@example.com"}, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		for _, handler := range []func(ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload){
+			AdminVerifyUser, AdminResetPassword} {
+			rc := newAdminRC(t, tt.caller)
+			rc.SetJSONBody(t, map[string]string{"user_id": "u2"})
+			if status, _ := handler(rc); status != tt.want {
+				t.Errorf("%s: status = %d, want %d", tt.name, status, tt.want)
+			}
+			if rc.Users["bob@example.com"].Verified || rc.Passwords["bob@example.com"] != "" {
+				t.Errorf("%s: user was changed", tt.name)
+			}
+		}
+	}
+}
+
+func TestAdminVerifyUser(t *testing.T) {
+	events := NewChannelEventPublisher(4)
+	useEventPublisher(t, events)
+
+	rc := newAdminRC(t, testAdmin)
+	rc.SetJSONBody(t, map[string]string{"email": "bob@example.com"})
+	status, resp := AdminVerifyUser(rc)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d (resp %v)", status, resp.Body)
+	}
+	if !rc.Users["bob@example.com"].Verified {
+		t.Error("user not verified")
+	}
+	audit := resp.Body.(map[string]interface{})["audit"].(AdminAuditRecord)
+	if audit.Action != ApiTypes.ActivityType_AdminVerifyUser || audit.UserID != "u2" ||
+		audit.AdminEmail != "admin@example.com" || audit.Detail != "verified" {
+		t.Errorf("audit = %+v", audit)
+	}
+	if len(events.C) != 1 || (<-events.C).Type != AuthEventVerified {
+		t.Error("no verified event")
+	}
+
+	// Verifying again is recorded but changes nothing
+	status, resp = AdminVerifyUser(rc)
+	if status != http.StatusOK {
+		t.Fatalf("again: status = %d", status)
+	}
+	if audit := resp.Body.(map[string]interface{})["audit"].(AdminAuditRecord); audit.Detail != "already verified" {
+		t.Errorf("again: audit = %+v", audit)
+	}
+	if len(events.C) != 0 {
+		t.Error("verified event published twice")
+	}
+
+	rc.SetJSONBody(t, map[string]string{"user_id": "nobody"})
+	if status, _ := AdminVerifyUser(rc); status != http.StatusNotFound {
+		t.Errorf("unknown user: status = %d", status)
+	}
+	rc.SetJSONBody(t, map[string]string{})
+	if status, _ := AdminVerifyUser(rc); status != http.StatusBadRequest {
+		t.Errorf("no user: status = %d", status)
+	}
+}
+
+func TestAdminResetPassword(t *testing.T) {
+	rc := newAdminRC(t, testAdmin)
+	rc.SetJSONBody(t, map[string]string{"user_id": "u2"})
+	status, resp := AdminResetPassword(rc)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d (resp %v)", status, resp.Body)
+	}
+	body := resp.Body.(map[string]interface{})
+	password, _ := body["temporary_password"].(string)
+	if password == "" || rc.Passwords["bob@example.com"] != password {
+		t.Errorf("temporary password %q not set", password)
+	}
+	expires_at, _ := body["expires_at"].(time.Time)
+	if got := rc.Users["bob@example.com"].PasswordExpiresAt; got == nil || !got.Equal(expires_at) ||
+		time.Until(expires_at).Round(time.Hour) != temporaryPasswordTTL {
+		t.Errorf("password expires at %v, response %v", got, expires_at)
+	}
+	if audit := body["audit"].(AdminAuditRecord); audit.Action != ApiTypes.ActivityType_AdminResetPassword ||
+		audit.AdminID != "a1" || strings.Contains(audit.Detail, password) {
+		t.Errorf("audit = %+v", audit)
+	}
+
+	// The reset link needs APP_BASE_URL
+	t.Setenv("APP_BASE_URL", "")
+	rc.SetJSONBody(t, map[string]string{"user_id": "u2", "mode": AdminResetModeToken})
+	if status, _ := AdminResetPassword(rc); status != http.StatusInternalServerError {
+		t.Errorf("no APP_BASE_URL: status = %d", status)
+	}
+	if rc.Users["bob@example.com"].VToken != "" {
+		t.Error("no APP_BASE_URL: token issued")
+	}
+
+	t.Setenv("APP_BASE_URL", "https://app.example.com")
+	rc.SetJSONBody(t, map[string]string{"user_id": "u2", "mode": AdminResetModeToken})
+	status, resp = AdminResetPassword(rc)
+	if status != http.StatusOK {
+		t.Fatalf("reset token: status = %d (resp %v)", status, resp.Body)
+	}
+	token := rc.Users["bob@example.com"].VToken
+	if url, _ := resp.Body.(map[string]interface{})["reset_url"].(string); token == "" ||
+		url != "https://app.example.com/reset-password?token="+token {
+		t.Errorf("reset_url = %q, token = %q", url, token)
+	}
+
+	rc.SetJSONBody(t, map[string]string{"user_id": "u2", "mode": "email"})
+	if status, _ := AdminResetPassword(rc); status != http.StatusBadRequest {
+		t.Errorf("bad mode: status = %d", status)
+	}
+}
+
+func TestTemporaryPassword(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		password, err := temporaryPassword()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(password) != temporaryPasswordLength || !ValidatePasswordDefault(password).Valid {
+			t.Errorf("password %q does not meet the requirements", password)
+		}
+		if seen[password] {
+			t.Errorf("password %q repeated", password)
+		}
+		seen[password] = true
+	}
+}
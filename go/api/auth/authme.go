@@ -12,11 +12,13 @@ import (
 )
 
 func HandleAuthMe(c echo.Context) error {
-	rc := EchoFactory.NewFromEcho(c, "SHD_ATM_017")
-	reqID := rc.ReqID()
-	status_code, resp := HandleAuthMeBase(rc, reqID)
-	c.JSON(status_code, resp)
-	return nil
+	return EchoFactory.WrapEcho(AuthMe)(c)
+}
+
+// AuthMe is the framework-agnostic "/auth/me" handler.
+func AuthMe(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	status_code, resp := HandleAuthMeBase(rc, rc.ReqID())
+	return status_code, ApiTypes.JSONPayload(resp)
 }
 
 func HandleAuthMeBase(
@@ -45,6 +47,16 @@ func HandleAuthMeBase(
 		return ApiTypes.CustomHttpStatus_NotLoggedIn, resp
 	}
 
+	// Sessions of email logins record their activity (Kratos keeps its own)
+	if os.Getenv("AUTH_USE_KRATOS") != "true" {
+		last_activity, err := sysdatastores.GetLastActivity(rc, user_info.Email)
+		if err != nil {
+			logger.Warn("failed to get last activity", "error", err, "email", user_info.Email)
+		} else {
+			user_info.LastActivityAt = last_activity
+		}
+	}
+
 	user_info_str, _ := json.Marshal(user_info)
 	base_url := os.Getenv("APP_BASE_URL")
 	var resp = ApiTypes.JimoResponse{
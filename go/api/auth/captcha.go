@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
+)
+
+// Supported CAPTCHA providers. Both verify a client token server-side
+// through a siteverify endpoint with the same request and response shape.
+const (
+	CaptchaProviderHCaptcha  = "hcaptcha"
+	CaptchaProviderTurnstile = "turnstile"
+)
+
+var captchaVerifyURLs = map[string]string{
+	CaptchaProviderHCaptcha:  "https://api.hcaptcha.com/siteverify",
+	CaptchaProviderTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// CaptchaConfig configures the CAPTCHA check on signup and password reset
+type CaptchaConfig struct {
+	Enabled  bool
+	Provider string
+	Secret   string
+	// VerifyURL overrides the provider's siteverify endpoint (tests)
+	VerifyURL string
+	Timeout   time.Duration
+}
+
+// LoadCaptchaConfig builds the config from the [captcha] section of
+// LibConfig and the environment:
+//
+//   - CAPTCHA_SECRET: the provider secret (environment only)
+//   - CAPTCHA_DISABLED=true: turns the check off, for tests and dev
+//
+// An enabled config with an unknown provider or no secret is an error.
+func LoadCaptchaConfig() (CaptchaConfig, error) {
+	conf := ApiTypes.LibConfig.CaptchaConf
+	cfg := CaptchaConfig{
+		Enabled:  conf.EnableCaptcha == "enabled",
+		Provider: strings.ToLower(strings.TrimSpace(conf.Provider)),
+		Secret:   os.Getenv("CAPTCHA_SECRET"),
+		Timeout:  5 * time.Second,
+	}
+	if os.Getenv("CAPTCHA_DISABLED") == "true" {
+		cfg.Enabled = false
+	}
+	if !cfg.Enabled {
+		return cfg, nil
+	}
+
+	if _, ok := captchaVerifyURLs[cfg.Provider]; !ok {
+		return cfg, fmt.Errorf("unknown captcha provider %q, expecting %s or %s (SHD_CPT_061)",
+			cfg.Provider, CaptchaProviderHCaptcha, CaptchaProviderTurnstile)
+	}
+	if cfg.Secret == "" {
+		return cfg, fmt.Errorf("captcha enabled but CAPTCHA_SECRET not set (SHD_CPT_065)")
+	}
+	return cfg, nil
+}
+
+var (
+	captchaMu      sync.RWMutex
+	captchaConfig  *CaptchaConfig
+	captchaLoadErr error
+)
+
+// SetCaptchaConfig replaces the CAPTCHA configuration. Without it,
+// LoadCaptchaConfig is used on first use.
+func SetCaptchaConfig(cfg CaptchaConfig) {
+	captchaMu.Lock()
+	defer captchaMu.Unlock()
+	captchaConfig = &cfg
+	captchaLoadErr = nil
+}
+
+func getCaptchaConfig() (CaptchaConfig, error) {
+	captchaMu.RLock()
+	if captchaConfig != nil {
+		defer captchaMu.RUnlock()
+		return *captchaConfig, captchaLoadErr
+	}
+	captchaMu.RUnlock()
+
+	captchaMu.Lock()
+	defer captchaMu.Unlock()
+	if captchaConfig == nil {
+		cfg, err := LoadCaptchaConfig()
+		captchaConfig = &cfg
+		captchaLoadErr = err
+	}
+	return *captchaConfig, captchaLoadErr
+}
+
+// captchaVerifyResponse is the siteverify response of both providers
+type captchaVerifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// errCaptchaRejected means the provider judged the token invalid, as
+// opposed to the provider being unreachable.
+var errCaptchaRejected = errors.New("captcha token rejected")
+
+// VerifyCaptcha checks 'token' with the configured provider. It returns
+// nil if the token is valid or the check is disabled.
+func VerifyCaptcha(ctx context.Context, token string, remote_ip string) error {
+	cfg, err := getCaptchaConfig()
+	if err != nil {
+		return err
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+	return cfg.verify(ctx, token, remote_ip)
+}
+
+func (cfg CaptchaConfig) verify(ctx context.Context, token string, remote_ip string) error {
+	if strings.TrimSpace(token) == "" {
+		return fmt.Errorf("missing captcha token: %w (SHD_CPT_127)", errCaptchaRejected)
+	}
+
+	verify_url := cfg.VerifyURL
+	if verify_url == "" {
+		verify_url = captchaVerifyURLs[cfg.Provider]
+	}
+	form := url.Values{}
+	form.Set("secret", cfg.Secret)
+	form.Set("response", token)
+	if remote_ip != "" {
+		form.Set("remoteip", remote_ip)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, verify_url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create captcha request: %w (SHD_CPT_144)", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("captcha provider unreachable: %w (SHD_CPT_150)", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("failed to read captcha response: %w (SHD_CPT_156)", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("captcha provider returned status %d (SHD_CPT_159)", resp.StatusCode)
+	}
+
+	var result captchaVerifyResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("invalid captcha response: %w (SHD_CPT_164)", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%w, error_codes:%v (SHD_CPT_167)", errCaptchaRejected, result.ErrorCodes)
+	}
+	return nil
+}
+
+// checkCaptcha verifies the CAPTCHA token of a signup or password reset
+// request before it touches the DB or sends email. It returns 0 if the
+// request may proceed, otherwise the status and message for the client.
+// Rejections are not written to the activity log, which bots would flood.
+func checkCaptcha(
+	ctx context.Context,
+	logger ApiTypes.JimoLogger,
+	req *http.Request,
+	token string) (int, string) {
+	remote_ip, _ := ApiUtils.ResolveRequestIP(req)
+	err := VerifyCaptcha(ctx, token, remote_ip)
+	switch {
+	case err == nil:
+		return 0, ""
+
+	case errors.Is(err, errCaptchaRejected):
+		logger.Warn("captcha check failed", "ip", remote_ip, "error", err)
+		return http.StatusBadRequest, "CAPTCHA verification failed. Please try again."
+
+	default:
+		logger.Error("captcha check unavailable", "ip", remote_ip, "error", err)
+		return http.StatusServiceUnavailable, "CAPTCHA verification is unavailable. Please try again later."
+	}
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/labstack/echo/v4"
+)
+
+// fakeCaptchaProvider accepts the token "good" and counts the calls
+func fakeCaptchaProvider(t *testing.T, calls *int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		if r.PostForm.Get("secret") != "test-secret" {
+			t.Errorf("secret = %q, want test-secret", r.PostForm.Get("secret"))
+		}
+		resp := captchaVerifyResponse{Success: r.PostForm.Get("response") == "good"}
+		if !resp.Success {
+			resp.ErrorCodes = []string{"invalid-input-response"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func useCaptchaConfig(t *testing.T, cfg CaptchaConfig) {
+	t.Helper()
+	SetCaptchaConfig(cfg)
+	t.Cleanup(func() { SetCaptchaConfig(CaptchaConfig{}) })
+}
+
+func TestVerifyCaptcha(t *testing.T) {
+	var calls int
+	srv := fakeCaptchaProvider(t, &calls)
+	cfg := CaptchaConfig{
+		Enabled:   true,
+		Provider:  CaptchaProviderTurnstile,
+		Secret:    "test-secret",
+		VerifyURL: srv.URL,
+		Timeout:   time.Second,
+	}
+	useCaptchaConfig(t, cfg)
+	ctx := context.Background()
+
+	if err := VerifyCaptcha(ctx, "good", "203.0.113.7"); err != nil {
+		t.Errorf("valid token: %v", err)
+	}
+	if err := VerifyCaptcha(ctx, "bad", ""); err == nil || !strings.Contains(err.Error(), "invalid-input-response") {
+		t.Errorf("invalid token: error = %v", err)
+	}
+	calls = 0
+	if err := VerifyCaptcha(ctx, " ", ""); err == nil || !strings.Contains(err.Error(), "missing captcha token") {
+		t.Errorf("missing token: error = %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("missing token called the provider %d times", calls)
+	}
+
+	cfg.VerifyURL = "http://127.0.0.1:1"
+	useCaptchaConfig(t, cfg)
+	if err := VerifyCaptcha(ctx, "good", ""); err == nil || !strings.Contains(err.Error(), "unreachable") {
+		t.Errorf("unreachable provider: error = %v", err)
+	}
+
+	useCaptchaConfig(t, CaptchaConfig{Enabled: false})
+	if err := VerifyCaptcha(ctx, "", ""); err != nil {
+		t.Errorf("disabled check: %v", err)
+	}
+}
+
+func TestLoadCaptchaConfig(t *testing.T) {
+	saved := ApiTypes.LibConfig.CaptchaConf
+	t.Cleanup(func() { ApiTypes.LibConfig.CaptchaConf = saved })
+
+	tests := []struct {
+		name     string
+		conf     ApiTypes.CaptchaConfig
+		secret   string
+		disabled string
+		enabled  bool
+		wantErr  string
+	}{
+		{name: "not configured"},
+		{name: "enabled", conf: ApiTypes.CaptchaConfig{EnableCaptcha: "enabled", Provider: "HCaptcha"},
+			secret: "s", enabled: true},
+		{name: "disabled by env", conf: ApiTypes.CaptchaConfig{EnableCaptcha: "enabled", Provider: "turnstile"},
+			disabled: "true"},
+		{name: "no secret", conf: ApiTypes.CaptchaConfig{EnableCaptcha: "enabled", Provider: "turnstile"},
+			enabled: true, wantErr: "CAPTCHA_SECRET not set"},
+		{name: "unknown provider", conf: ApiTypes.CaptchaConfig{EnableCaptcha: "enabled", Provider: "recaptcha"},
+			secret: "s", enabled: true, wantErr: "unknown captcha provider"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ApiTypes.LibConfig.CaptchaConf = tt.conf
+			t.Setenv("CAPTCHA_SECRET", tt.secret)
+			t.Setenv("CAPTCHA_DISABLED", tt.disabled)
+
+			cfg, err := LoadCaptchaConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Enabled != tt.enabled {
+				t.Errorf("enabled = %v, want %v", cfg.Enabled, tt.enabled)
+			}
+		})
+	}
+}
+
+func TestRecoveryRequiresCaptcha(t *testing.T) {
+	var calls int
+	srv := fakeCaptchaProvider(t, &calls)
+	useCaptchaConfig(t, CaptchaConfig{
+		Enabled:   true,
+		Provider:  CaptchaProviderHCaptcha,
+		Secret:    "test-secret",
+		VerifyURL: srv.URL,
+		Timeout:   time.Second,
+	})
+	t.Setenv("APP_BASE_URL", "")
+
+	for _, tc := range []struct {
+		body string
+		want int
+	}{
+		{`{"email":"a@example.com"}`, http.StatusBadRequest},
+		{`{"email":"a@example.com","captcha_token":"bad"}`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/auth/recovery", strings.NewReader(tc.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := HandleRecoverySubmitKratos(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatalf("handler: %v", err)
+		}
+		if rec.Code != tc.want || !strings.Contains(rec.Body.String(), "CAPTCHA verification failed") {
+			t.Errorf("%s: status = %d, body = %s", tc.body, rec.Code, rec.Body.String())
+		}
+	}
+}
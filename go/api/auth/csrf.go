@@ -132,8 +132,14 @@ func CSRFProtectedHandler(
 // for state-changing requests. Requests without these headers are only allowed
 // if they appear to be same-origin (checking other indicators).
 func IsSafeOrigin(c echo.Context) bool {
-	origin := c.Request().Header.Get("Origin")
-	referer := c.Request().Header.Get("Referer")
+	return IsSafeOriginRequest(c.Request())
+}
+
+// IsSafeOriginRequest is the framework-agnostic form of IsSafeOrigin, used by
+// handlers that only have access to the RequestContext.
+func IsSafeOriginRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	referer := r.Header.Get("Referer")
 
 	// Get allowed domain from environment
 	appDomain := os.Getenv("APP_BASE_URL")
@@ -148,7 +154,7 @@ func IsSafeOrigin(c echo.Context) bool {
 
 	// If we have an Origin header, validate it
 	if origin != "" {
-		if isSameOriginAsRequest(origin, c) {
+		if isSameOriginAsRequest(origin, r) {
 			return true
 		}
 		return isOriginAllowed(origin, appDomain)
@@ -156,7 +162,7 @@ func IsSafeOrigin(c echo.Context) bool {
 
 	// No Origin header - check Referer as fallback
 	if referer != "" {
-		if isSameOriginAsRequest(referer, c) {
+		if isSameOriginAsRequest(referer, r) {
 			return true
 		}
 		return isOriginAllowed(referer, appDomain)
@@ -172,8 +178,8 @@ func IsSafeOrigin(c echo.Context) bool {
 	if os.Getenv("ENV") == "production" {
 		// Check if this looks like a fetch/XHR request (has typical headers)
 		// Same-origin requests from modern browsers typically have these
-		contentType := c.Request().Header.Get("Content-Type")
-		accept := c.Request().Header.Get("Accept")
+		contentType := r.Header.Get("Content-Type")
+		accept := r.Header.Get("Accept")
 
 		// If it's a JSON API request without origin headers, it's suspicious
 		if strings.Contains(contentType, "application/json") ||
@@ -209,9 +215,9 @@ func isOriginAllowed(origin string, appDomain string) bool {
 	return false
 }
 
-func isSameOriginAsRequest(origin string, c echo.Context) bool {
+func isSameOriginAsRequest(origin string, r *http.Request) bool {
 	originHost := extractHost(origin)
-	requestHost := extractHost(c.Request().Host)
+	requestHost := extractHost(r.Host)
 	if originHost == "" || requestHost == "" {
 		return false
 	}
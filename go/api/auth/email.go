@@ -3,28 +3,31 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/mail"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/chendingplano/shared/go/api/ApiTypes"
 	"github.com/chendingplano/shared/go/api/ApiUtils"
 	"github.com/chendingplano/shared/go/api/EchoFactory"
 	"github.com/chendingplano/shared/go/api/sysdatastores"
-	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // SECURITY: Dummy hash for timing-safe comparison when user doesn't exist.
 // This prevents timing attacks that could enumerate valid email addresses.
-var dummyPasswordHash = func() []byte {
-	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy_password_for_timing_safety"), bcrypt.DefaultCost)
+// It is made on first use, with the configured bcrypt cost and pepper, so
+// that checking it takes as long as checking a real hash.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := ApiUtils.HashPassword("dummy_password_for_timing_safety")
 	return hash
-}()
+})
 
 type User struct {
 	Name     string `json:"name"`
@@ -42,11 +45,19 @@ type EmailSignupRequest struct {
 	LastName  string `json:"last_name"`
 	Email     string `json:"email"`
 	Password  string `json:"password"`
+	// CaptchaToken is required when the CAPTCHA check is enabled
+	CaptchaToken string `json:"captcha_token,omitempty"`
 }
 
 type EmailLoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	// Identifier is the user's email or user name. Email is still
+	// accepted for clients that predate it.
+	Identifier string `json:"identifier"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	// ResendVerification asks for a new verification email if the
+	// password is right but the email is not verified yet
+	ResendVerification bool `json:"resend_verification,omitempty"`
 }
 
 type EmailLoginResponse struct {
@@ -74,19 +85,25 @@ func isValidEmail(email string) bool {
 	return err == nil
 }
 
+// HandleEmailLogin is kept as the Echo entry point; new code should
+// register EmailLogin through EchoFactory.RegisterRoute.
 func HandleEmailLogin(c echo.Context) error {
-	rc := EchoFactory.NewFromEcho(c, "SHD_EML_073")
-	defer rc.Close()
+	return EchoFactory.WrapEcho(EmailLogin)(c)
+}
+
+// EmailLogin is the framework-agnostic email login handler.
+func EmailLogin(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
 	logger := rc.GetLogger()
+	req := rc.GetRequest()
 
 	// SECURITY: Rate limiting to prevent brute-force attacks
-	clientIP := c.RealIP()
+	clientIP, _ := ApiUtils.ResolveRequestIP(req)
 	allowed, remaining, retryAfter := CheckLoginRateLimit(clientIP)
 	if !allowed {
 		logger.Warn("Rate limit exceeded for login",
 			"ip", clientIP,
 			"retry_after", retryAfter.String())
-		return c.JSON(http.StatusTooManyRequests, map[string]string{
+		return http.StatusTooManyRequests, ApiTypes.JSONPayload(map[string]string{
 			"status":  "error",
 			"message": "Too many login attempts. Please try again later.",
 			"loc":     "SHD_EML_RATE_001",
@@ -95,30 +112,38 @@ func HandleEmailLogin(c echo.Context) error {
 	_ = remaining // Used for X-RateLimit-Remaining header if needed
 
 	// SECURITY: Validate request origin to prevent CSRF attacks
-	if !IsSafeOrigin(c) {
+	if !IsSafeOriginRequest(req) {
 		logger.Warn("CSRF protection: rejected cross-origin request",
-			"origin", c.Request().Header.Get("Origin"),
-			"referer", c.Request().Header.Get("Referer"))
-		return c.JSON(http.StatusForbidden, map[string]string{
+			"origin", req.Header.Get("Origin"),
+			"referer", req.Header.Get("Referer"))
+		return http.StatusForbidden, ApiTypes.JSONPayload(map[string]string{
 			"status":  "error",
 			"message": "Invalid request origin",
 			"loc":     "SHD_EML_CSRF_001",
 		})
 	}
 
-	body, _ := io.ReadAll(c.Request().Body)
-	path := c.Path()
-	logger.Info("Handle request", "path", path)
+	body, _ := io.ReadAll(req.Body)
+	logger.Info("Handle request", "path", req.URL.Path)
 	status_code, msg := HandleEmailLoginBase(rc, body, clientIP)
-	c.JSON(status_code, msg)
-	return nil
+	return status_code, ApiTypes.JSONPayload(msg)
 }
 
-// HandleEmailLoginBase processes email login requests.
+// HandleEmailLoginBase processes email login requests. The user is
+// identified by req.Identifier (or req.Email), looked up by email if it
+// parses as one and by user name otherwise.
 // It returns (status_code, json).
 //   - When success, json = {"status":"ok", "redirect_url": "...", "loc": "..."}.
 //   - When failure, json = {"status":"error", "message": "...", "loc": "..."}.
 //
+// Unknown users and wrong passwords get the same "invalid credentials"
+// response. Only with the right password does the response tell that
+// the email is not verified yet (CustomHttpStatus_EmailNotVerified, with
+// "resend_verification": "available", or "sent" if the request set
+// resend_verification) or that the account is disabled
+// (CustomHttpStatus_AccountDisabled). Unverified users may log in if
+// LibConfig.AllowUnverifiedLogin is set.
+//
 // The clientIP parameter is used to reset rate limiting on successful login.
 func HandleEmailLoginBase(
 	rc ApiTypes.RequestContext,
@@ -150,13 +175,17 @@ func HandleEmailLoginBase(
 		}
 	}
 
-	if !isValidEmail(req.Email) {
-		error_msg := "invalid email format (SHD_EML_081)"
-		logger.Error("invalid email format", "email", req.Email)
+	identifier := strings.TrimSpace(req.Identifier)
+	if identifier == "" {
+		identifier = strings.TrimSpace(req.Email)
+	}
+	if identifier == "" {
+		error_msg := "missing email or user name (SHD_EML_081)"
+		logger.Error("missing login identifier")
 
 		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
 			ActivityName: ApiTypes.ActivityName_Auth,
-			ActivityType: ApiTypes.ActivityType_BadEmail,
+			ActivityType: ApiTypes.ActivityType_BadRequest,
 			AppName:      ApiTypes.AppName_Auth,
 			ModuleName:   ApiTypes.ModuleName_EmailAuth,
 			ActivityMsg:  &error_msg,
@@ -169,12 +198,29 @@ func HandleEmailLoginBase(
 		}
 	}
 
+	// An identifier that parses as an email is looked up by email,
+	// anything else by user name.
+	var user_info *ApiTypes.UserInfo
+	var exist bool
+	if isValidEmail(identifier) {
+		user_info, exist = rc.GetUserInfoByEmail(identifier)
+	} else {
+		user_info, exist = rc.GetUserInfoByUserName(identifier)
+	}
+
 	// SECURITY: Check per-account rate limiting to prevent distributed brute-force attacks.
 	// This protects against attackers using multiple IPs to attack a single account.
-	accountAllowed, _, accountRetryAfter := CheckAccountLockout(req.Email)
+	// The attempts are counted against the account, whichever identifier
+	// names it, so that its email and user name share one limit; only an
+	// identifier that names no user is counted on its own.
+	lockout_key := identifier
+	if exist && user_info.Email != "" {
+		lockout_key = user_info.Email
+	}
+	accountAllowed, _, accountRetryAfter := CheckAccountLockout(lockout_key)
 	if !accountAllowed {
 		logger.Warn("Account locked due to too many failed attempts",
-			"email", req.Email,
+			"identifier", identifier,
 			"retry_after", accountRetryAfter.String())
 
 		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
@@ -182,7 +228,7 @@ func HandleEmailLoginBase(
 			ActivityType: ApiTypes.ActivityType_AuthFailure,
 			AppName:      ApiTypes.AppName_Auth,
 			ModuleName:   ApiTypes.ModuleName_EmailAuth,
-			ActivityMsg:  func() *string { s := fmt.Sprintf("Account locked due to rate limiting: %s", req.Email); return &s }(),
+			ActivityMsg:  func() *string { s := fmt.Sprintf("Account locked due to rate limiting: %s", identifier); return &s }(),
 			CallerLoc:    "SHD_EML_ACCT_LOCK"})
 
 		return http.StatusTooManyRequests, map[string]string{
@@ -192,15 +238,22 @@ func HandleEmailLoginBase(
 		}
 	}
 
-	user_info, exist := rc.GetUserInfoByEmail(req.Email)
+	disabled := exist && user_info.UserStatus == ApiTypes.UserStatus_Disabled
+	if disabled && user_info.Password == "" {
+		// Without a password to check, a disabled user is treated as
+		// non-existent
+		logger.Warn("login attempt for disabled user without password", "identifier", identifier)
+		exist = false
+	}
+
 	if !exist {
 		// SECURITY: Perform dummy bcrypt comparison to prevent timing attacks.
-		// This ensures response time is similar whether email exists or not,
-		// preventing attackers from enumerating valid emails via timing analysis.
-		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		// This ensures response time is similar whether the user exists or not,
+		// preventing attackers from enumerating valid users via timing analysis.
+		_, _ = ApiUtils.VerifyPassword(dummyPasswordHash(), req.Password)
 
-		error_msg := "email not found, email"
-		logger.Warn("login attempt for non-existent email", "email", req.Email)
+		error_msg := fmt.Sprintf("user not found:%s", identifier)
+		logger.Warn("login attempt for non-existent user", "identifier", identifier)
 
 		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
 			ActivityName: ApiTypes.ActivityName_Auth,
@@ -211,14 +264,27 @@ func HandleEmailLoginBase(
 			CallerLoc:    "SHD_EML_131"})
 
 		// Return generic error to prevent user enumeration
-		return http.StatusUnauthorized, map[string]string{
-			"status":  "error",
-			"message": error_msg,
-			"loc":     "SHD_EML_218",
-		}
+		return http.StatusUnauthorized, invalidCredentials("SHD_EML_218")
 	}
 
 	status, status_code, msg := rc.VerifyUserPassword(user_info, req.Password)
+	password_ok := status || status_code == ApiTypes.CustomHttpStatus_TwoFactorRequired
+	if password_ok && passwordExpired(user_info) {
+		// A temporary password set by an admin and not changed in time
+		logger.Warn("login failed: temporary password expired", "identifier", identifier)
+		return http.StatusUnauthorized, invalidCredentials("SHD_EML_1006")
+	}
+	if disabled {
+		if !password_ok {
+			logger.Warn("login failed: invalid password for disabled user", "identifier", identifier)
+			return http.StatusUnauthorized, invalidCredentials("SHD_EML_262")
+		}
+		return accountDisabled(rc, user_info, identifier)
+	}
+	if password_ok && !user_info.Verified && !ApiTypes.LibConfig.AllowUnverifiedLogin {
+		return emailNotVerified(rc, user_info, identifier, req.ResendVerification)
+	}
+
 	if !status {
 		if status_code == ApiTypes.CustomHttpStatus_PasswordNotSet {
 			return status_code, map[string]string{
@@ -228,25 +294,164 @@ func HandleEmailLoginBase(
 			}
 		}
 
-		// SECURITY: Return generic error for invalid password
-		logger.Warn("login failed: invalid password", "email", req.Email)
-		return http.StatusUnauthorized, map[string]string{
-			"status":  "error",
-			"message": "invalid password",
-			"loc":     "SHD_EML_237",
+		if status_code == ApiTypes.CustomHttpStatus_TwoFactorRequired {
+			return startTwoFactorChallenge(rc, user_info, user_info.Email, clientIP)
 		}
+
+		if status_code == http.StatusInternalServerError {
+			return status_code, map[string]string{
+				"status":  "error",
+				"message": msg,
+				"loc":     "SHD_EML_243",
+			}
+		}
+
+		// SECURITY: Return generic error for invalid password
+		logger.Warn("login failed: invalid password", "identifier", identifier)
+		return http.StatusUnauthorized, invalidCredentials("SHD_EML_237")
 	}
 
+	return finishEmailLogin(rc, user_info, user_info.Email, clientIP)
+}
+
+// saveEmailSession saves the session of an email login or verification.
+// Both identify the user by email: it is the registration ID, and the
+// auth token is stored on the user record found by it.
+func saveEmailSession(
+	rc ApiTypes.RequestContext,
+	login_method string,
+	session_id string,
+	auth_token string,
+	user_info *ApiTypes.UserInfo,
+	expiry time.Time) error {
+	user_name := user_info.UserName
+	if user_name == "" {
+		user_name = user_info.Email
+	}
+	return rc.SaveSession(
+		login_method,
+		session_id,
+		auth_token,
+		user_name,
+		"email",
+		user_info.Email,
+		user_info.Email,
+		expiry,
+		true)
+}
+
+// invalidCredentials is the login failure response for both an unknown
+// user and a wrong password, so the response does not tell them apart.
+func invalidCredentials(loc string) map[string]string {
+	return map[string]string{
+		"status":  "error",
+		"message": "invalid credentials",
+		"loc":     loc,
+	}
+}
+
+// accountDisabled is the login response for a disabled user who gave the
+// right password. Only such a user learns that the account is disabled.
+func accountDisabled(
+	rc ApiTypes.RequestContext,
+	user_info *ApiTypes.UserInfo,
+	identifier string) (int, map[string]string) {
+	rc.GetLogger().Warn("login failed: account disabled", "identifier", identifier, "user_id", user_info.UserId)
+
+	error_msg := fmt.Sprintf("login to disabled account, user_id:%s", user_info.UserId)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: ApiTypes.ActivityType_AuthFailure,
+		AppName:      ApiTypes.AppName_Auth,
+		ModuleName:   ApiTypes.ModuleName_EmailAuth,
+		ActivityMsg:  &error_msg,
+		CallerLoc:    "SHD_EML_287"})
+
+	return ApiTypes.CustomHttpStatus_AccountDisabled, map[string]string{
+		"status":  "error",
+		"message": "This account has been disabled. Please contact support.",
+		"loc":     "SHD_EML_287",
+	}
+}
+
+// emailNotVerified is the login response for a user who gave the right
+// password but has not verified the email yet. With 'resend', a new
+// verification email is sent.
+func emailNotVerified(
+	rc ApiTypes.RequestContext,
+	user_info *ApiTypes.UserInfo,
+	identifier string,
+	resend bool) (int, map[string]string) {
+	logger := rc.GetLogger()
+	logger.Warn("login failed: email not verified", "identifier", identifier, "resend", resend)
+
+	error_msg := fmt.Sprintf("login with unverified email, user_id:%s, resend:%v", user_info.UserId, resend)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: ApiTypes.ActivityType_UnverifiedEmail,
+		AppName:      ApiTypes.AppName_Auth,
+		ModuleName:   ApiTypes.ModuleName_EmailAuth,
+		ActivityMsg:  &error_msg,
+		CallerLoc:    "SHD_EML_311"})
+
+	resp := map[string]string{
+		"status":              "error",
+		"message":             "Please verify your email address before logging in. We can send you a new verification link.",
+		"resend_verification": "available",
+		"loc":                 "SHD_EML_311",
+	}
+	if !resend {
+		return ApiTypes.CustomHttpStatus_EmailNotVerified, resp
+	}
+
+	resend_info := &ApiTypes.UserInfo{
+		UserIdType: user_info.UserIdType,
+		UserName:   user_info.UserName,
+		Email:      user_info.Email,
+		AuthType:   user_info.AuthType,
+		UserStatus: user_info.UserStatus,
+		FirstName:  user_info.FirstName,
+		LastName:   user_info.LastName,
+	}
+	if _, err := sendNewVerificationEmail(rc, resend_info, ""); errors.Is(err, ErrEmailCooldown) {
+		resp["message"] = "Please verify your email address before logging in. " +
+			"A verification link was sent recently, please check your email or try again later."
+		resp["loc"] = "SHD_EML_1003"
+		return http.StatusTooManyRequests, resp
+	} else if err != nil {
+		logger.Error("failed to resend verification email", "error", err, "email", user_info.Email)
+		resp["message"] = "Please verify your email address before logging in. " +
+			"We could not send a new verification link, please try again later."
+		resp["loc"] = "SHD_EML_341"
+		return ApiTypes.CustomHttpStatus_EmailNotVerified, resp
+	}
+	resp["message"] = "Please verify your email address before logging in. " +
+		"A new verification link has been sent to your email."
+	resp["resend_verification"] = "sent"
+	resp["loc"] = "SHD_EML_347"
+	return ApiTypes.CustomHttpStatus_EmailNotVerified, resp
+}
+
+// finishEmailLogin creates the session for a user who passed all login
+// checks (password and, if enabled, 2FA). 'email' is the address the user
+// logged in with.
+func finishEmailLogin(
+	rc ApiTypes.RequestContext,
+	user_info *ApiTypes.UserInfo,
+	email string,
+	clientIP string) (int, map[string]string) {
+	logger := rc.GetLogger()
+
 	// SECURITY: Reset both IP and account rate limits on successful login
 	if clientIP != "" {
-		ResetLoginRateLimits(clientIP, req.Email)
+		ResetLoginRateLimits(clientIP, email)
 	}
 
 	// Generate Pocketbase auth token (similar to Google OAuth flow)
-	auth_token, err := rc.GenerateAuthToken(req.Email)
+	auth_token, err := rc.GenerateAuthToken(email)
 	if err != nil {
 		error_msg := fmt.Sprintf("failed to generate auth token: %v (SHD_EML_272)", err)
-		logger.Error("failed generating auth token", "error", err, "email", req.Email)
+		logger.Error("failed generating auth token", "error", err, "email", email)
 
 		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
 			ActivityName: ApiTypes.ActivityName_Auth,
@@ -272,19 +477,10 @@ func HandleEmailLoginBase(
 	expired_time_str := expired_time.Format(customLayout)
 
 	// Save session in DB for audit logging
-	err1 := rc.SaveSession(
-		"email_login",
-		sessionID,
-		auth_token,
-		req.Email,
-		"email",
-		req.Email,
-		req.Email,
-		expired_time,
-		true)
+	err1 := saveEmailSession(rc, "email_login", sessionID, auth_token, user_info, expired_time)
 
 	if err1 != nil {
-		logger.Warn("failed saving session", "error", err1, "email", req.Email)
+		logger.Warn("failed saving session", "error", err1, "email", email)
 	}
 
 	sysdatastores.AddSessionLog(sysdatastores.SessionLogDef{
@@ -292,10 +488,10 @@ func HandleEmailLoginBase(
 		SessionID:    sessionID,
 		AuthToken:    auth_token,
 		Status:       "active",
-		UserName:     req.Email,
+		UserName:     email,
 		UserNameType: "email",
-		UserRegID:    req.Email,
-		UserEmail:    &req.Email,
+		UserRegID:    email,
+		UserEmail:    &email,
 		CallerLoc:    "SHD_EML_267",
 		ExpiresAt:    &expired_time_str,
 	})
@@ -310,10 +506,10 @@ func HandleEmailLoginBase(
 	user_name := user_info.FirstName + " " + user_info.LastName
 	redirect_url := ApiUtils.GetOAuthRedirectURL(rc, auth_token, user_name)
 	msg1 := fmt.Sprintf("email login success, email:%s, session_id:%s, redirect_url:%s",
-		req.Email, ApiUtils.MaskToken(sessionID), redirect_url)
+		email, ApiUtils.MaskToken(sessionID), redirect_url)
 	logger.Info(
 		"Email login success",
-		"email", req.Email,
+		"email", email,
 		"session_id", ApiUtils.MaskToken(sessionID),
 		"redirect_url", redirect_url,
 		"loc", "SHD_EML_316")
@@ -326,13 +522,72 @@ func HandleEmailLoginBase(
 		ActivityMsg:  &msg1,
 		CallerLoc:    "SHD_EML_324"})
 
-	msg = fmt.Sprintf("email login success, email:%s, redirectURL:%s, loc:(SHD_EML_190)",
-		req.Email, redirect_url)
-	return http.StatusOK, map[string]string{
+	publishAuthEvent(AuthEventLoggedIn, user_info)
+	resp := map[string]string{
 		"status":       "ok",
 		"redirect_url": redirect_url,
 		"loc":          "SHD_EML_190",
 	}
+	if user_info.PasswordExpiresAt != nil {
+		// Logged in with a temporary password: the client asks for a new
+		// one before it stops working
+		resp["password_expires_at"] = user_info.PasswordExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return http.StatusOK, resp
+}
+
+// passwordExpired reports whether the password of 'user_info' is a
+// temporary one past its expiry
+func passwordExpired(user_info *ApiTypes.UserInfo) bool {
+	return user_info.PasswordExpiresAt != nil && !time.Now().Before(*user_info.PasswordExpiresAt)
+}
+
+// sendNewVerificationEmail gives 'user_info' a new verification token,
+// saves it with UpsertUser (which creates the user with 'plain_password',
+// or only refreshes the token of an existing one) and emails the
+// verification link. It returns the saved user, or ErrEmailCooldown
+// (before saving anything) if the address got verification emails too
+// recently or too often.
+func sendNewVerificationEmail(
+	rc ApiTypes.RequestContext,
+	user_info *ApiTypes.UserInfo,
+	plain_password string) (*ApiTypes.UserInfo, error) {
+	logger := rc.GetLogger()
+	if retry_after, err := CheckEmailCooldown(rc, EmailKindVerification, user_info.Email); err != nil {
+		return nil, fmt.Errorf("%w, retry after %s", err, retry_after)
+	}
+
+	var saved_user *ApiTypes.UserInfo
+	token, err := issueToken(rc, func(token string) error {
+		user_info.VToken = token
+		var err error
+		saved_user, err = rc.UpsertUser(user_info,
+			plain_password, false, false, false, false, false)
+		return err
+	})
+	if err != nil {
+		ReleaseEmailCooldown(EmailKindVerification, user_info.Email)
+		return nil, err
+	}
+
+	home_domain := os.Getenv("APP_BASE_URL")
+	if home_domain == "" {
+		logger.Error("missing APP_BASE_URL env var", "email", user_info.Email)
+	}
+
+	verificationURL := fmt.Sprintf("%s/auth/email/verify?token=%s", home_domain, token)
+	// SECURITY: Do not log full verification URLs or tokens - they allow account takeover
+	logger.Info("sending verification email",
+		"to", user_info.Email,
+		"token", ApiUtils.MaskToken(token))
+
+	rc.PushCallFlow("SHD_EML_642")
+	go func(to string) {
+		if err := sendVerificationEmail(rc, to, verificationURL); err != nil {
+			ReleaseEmailCooldown(EmailKindVerification, to)
+		}
+	}(user_info.Email)
+	return saved_user, nil
 }
 
 func sendVerificationEmail(
@@ -370,64 +625,66 @@ func sendVerificationEmail(
 }
 
 func HandleEmailVerify(c echo.Context) error {
-	rc := EchoFactory.NewFromEcho(c, "SHD_EML_272")
-	defer rc.Close()
-	logger := rc.GetLogger()
-	logger.Info("Handle Email Verify (GET)")
-
-	is_post := false
-	HandleEmailVerifyCommon(rc, c, is_post)
-	return nil
+	return EchoFactory.WrapEcho(EmailVerify)(c)
 }
 
 func HandleEmailVerifyPost(c echo.Context) error {
-	// It supports two URL params:
-	//	?token=<token>
-	//	?type=<type>
-	// Currently, it supports only type:'auth'
-	rc := EchoFactory.NewFromEcho(c, "SHD_EML_272")
-	defer rc.Close()
-	logger := rc.GetLogger()
-	logger.Info("Handle Email Verify (POST)")
+	return EchoFactory.WrapEcho(EmailVerifyPost)(c)
+}
+
+// EmailVerify handles the verification link clicked in the browser (GET).
+func EmailVerify(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	rc.GetLogger().Info("Handle Email Verify (GET)")
+	is_post := false
+	return HandleEmailVerifyCommon(rc, is_post)
+}
 
+// EmailVerifyPost handles programmatic verification (POST).
+// It supports two URL params:
+//
+//	?token=<token>
+//	?type=<type>
+//
+// Currently, it supports only type:'auth'
+func EmailVerifyPost(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	rc.GetLogger().Info("Handle Email Verify (POST)")
 	is_post := true
-	HandleEmailVerifyCommon(rc, c, is_post)
-	return nil
+	return HandleEmailVerifyCommon(rc, is_post)
 }
 
 func HandleEmailVerifyCommon(
 	rc ApiTypes.RequestContext,
-	c echo.Context,
-	is_post bool) {
+	is_post bool) (int, ApiTypes.ResponsePayload) {
 
 	logger := rc.GetLogger()
 
 	status_code, resp, err := HandleEmailVerifyBase(rc, is_post)
 	if err == nil {
 		// Verify success. Retrieve the query parm 'type'
-		verifyType := c.QueryParam("type") // "auth"
+		verifyType := rc.QueryParam("type") // "auth"
 		if verifyType == "auth" {
 			// It needs to return the response
-			c.JSON(status_code, resp)
-			return
+			return status_code, ApiTypes.JSONPayload(resp)
 		}
 
-		// Success case: redirect to the dashboard
+		// Success case: redirect to the dashboard, or to the client-supplied
+		// 'redirect' if it is allowed.
 		// Cookie was already set in HandleEmailVerifyBase
 		redirectURL := resp["redirect_url"]
+		if candidate := rc.QueryParam("redirect"); candidate != "" {
+			redirectURL = SafeRedirect(rc, candidate)
+		}
 		if len(redirectURL) <= 0 {
 			redirectURL = os.Getenv("APP_BASE_URL") + "/login"
 			logger.Error("missing redirectURL",
 				"status_code", status_code,
 				"redirect_url", redirectURL,
 				"is_post", is_post)
-			c.Redirect(http.StatusSeeOther, redirectURL)
-			return
+			return http.StatusSeeOther, ApiTypes.RedirectPayload(redirectURL)
 		}
 
 		logger.Info("email verify success, redirecting", "redirect_url", redirectURL)
-		c.Redirect(http.StatusSeeOther, redirectURL)
-		return
+		return http.StatusSeeOther, ApiTypes.RedirectPayload(redirectURL)
 	}
 
 	// Error case
@@ -443,11 +700,10 @@ func HandleEmailVerifyCommon(
 		}
 
 		domainName := os.Getenv("APP_BASE_URL")
-		c.Redirect(http.StatusSeeOther, domainName+"/login?error="+errorType)
-		return
+		return http.StatusSeeOther, ApiTypes.RedirectPayload(domainName + "/login?error=" + errorType)
 	}
 
-	c.JSON(status_code, resp)
+	return status_code, ApiTypes.JSONPayload(resp)
 }
 
 // HandleEmailVerifyBase handles email verification.
@@ -568,8 +824,11 @@ func HandleEmailVerifyBase(
 		return http.StatusBadRequest, resp, fmt.Errorf("%s", e_msg)
 	}
 
-	// Mark user as verified first
-	err1 := rc.MarkUserVerified(user_info.Email)
+	// Mark user as verified first. A user who was already verified, e.g.
+	// opening the link again or in a concurrent request, doesn't get the
+	// welcome email again.
+	first_verification := !user_info.Verified
+	newly_verified, err1 := rc.MarkUserVerified(user_info.Email)
 	if err1 != nil {
 		log_id := sysdatastores.NextActivityLogID()
 		error_msg := fmt.Sprintf("mark user failed, error:%v, user_name:%s, log_id:%d",
@@ -595,6 +854,14 @@ func HandleEmailVerifyBase(
 		}
 		return http.StatusInternalServerError, resp, fmt.Errorf("%s", error_msg)
 	}
+	publishAuthEvent(AuthEventVerified, user_info)
+	if first_verification && newly_verified {
+		// The email goes out after the response, so it gets a copy of
+		// the user's fields and a request context of its own
+		if welcome, err := newWelcomeEmail(logger, user_info); err == nil && welcome != nil {
+			go welcome.send(EchoFactory.NewRCAsAdmin("SHD_EML_813"))
+		}
+	}
 
 	// Generate Pocketbase auth token (not session ID)
 	authToken, err := rc.GenerateAuthToken(user_info.Email)
@@ -630,16 +897,7 @@ func HandleEmailVerifyBase(
 	expired_time_str := expired_time.Format(customLayout)
 
 	// Save session in DB for audit logging (goes through EchoFactory Kratos guard)
-	err1 = rc.SaveSession(
-		"email_verify",
-		sessionID,
-		authToken,
-		user_info.UserName,
-		"email",
-		user_info.Email,
-		user_info.Email,
-		expired_time,
-		true)
+	err1 = saveEmailSession(rc, "email_verify", sessionID, authToken, user_info, expired_time)
 
 	if err1 != nil {
 		logger.Error("failed saving session (non-critical)", "error", err1)
@@ -705,16 +963,20 @@ func HandleEmailVerifyBase(
 }
 
 func HandleEmailSignup(c echo.Context) error {
-	rc := EchoFactory.NewFromEcho(c, "SHD_EML_509")
+	return EchoFactory.WrapEcho(EmailSignup)(c)
+}
+
+// EmailSignup is the framework-agnostic email signup handler.
+func EmailSignup(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
 	logger := rc.GetLogger()
-	defer rc.Close()
+	req := rc.GetRequest()
 
 	// SECURITY: Validate request origin to prevent CSRF attacks
-	if !IsSafeOrigin(c) {
+	if !IsSafeOriginRequest(req) {
 		logger.Warn("CSRF protection: rejected cross-origin request",
-			"origin", c.Request().Header.Get("Origin"),
-			"referer", c.Request().Header.Get("Referer"))
-		return c.JSON(http.StatusForbidden, EmailSignupResponse{
+			"origin", req.Header.Get("Origin"),
+			"referer", req.Header.Get("Referer"))
+		return http.StatusForbidden, ApiTypes.JSONPayload(EmailSignupResponse{
 			Message: "Invalid request origin",
 			LOC:     "SHD_EML_CSRF_002",
 		})
@@ -725,8 +987,7 @@ func HandleEmailSignup(c echo.Context) error {
 	call_flow := fmt.Sprintf("%s->SHD_EML_482", ctx.Value(ApiTypes.CallFlowKey))
 	new_ctx := context.WithValue(ctx, ApiTypes.CallFlowKey, call_flow)
 	status_code, resp := HandleEmailSignupBase(new_ctx, rc)
-	c.JSON(status_code, resp)
-	return nil
+	return status_code, ApiTypes.JSONPayload(resp)
 }
 
 func HandleEmailSignupBase(
@@ -737,7 +998,8 @@ func HandleEmailSignupBase(
 	//   "first_name": "John",		// Optional
 	//   "last_name": "Doe",		// Optional
 	//   "email": "xxx",
-	//   "password": "yyy"
+	//   "password": "yyy",
+	//   "captcha_token": "zzz"	// Required if the CAPTCHA check is enabled
 	// }
 	logger := rc.GetLogger()
 	logger.Info("Handle email signup request")
@@ -766,6 +1028,13 @@ func HandleEmailSignupBase(
 
 	logger.Info("Parsing request success")
 
+	if status, msg := checkCaptcha(ctx, logger, rc.GetRequest(), req.CaptchaToken); status != 0 {
+		return status, EmailSignupResponse{
+			Message: msg,
+			LOC:     "SHD_EML_CPT_001",
+		}
+	}
+
 	if !isValidEmail(req.Email) {
 		log_id := sysdatastores.NextActivityLogID()
 		error_msg := fmt.Sprintf("invalid email format, email:%s, log_id:%d (SHD_EML_547)", req.Email, log_id)
@@ -826,6 +1095,16 @@ func HandleEmailSignupBase(
 	// if databaseutil.UserExists(req.Email) {
 	user_info, exist := rc.GetUserInfoByEmail(req.Email)
 	if exist {
+		// Signing up again must not re-enable a disabled account.
+		if user_info.UserStatus == ApiTypes.UserStatus_Disabled {
+			logger.Warn("signup attempt for disabled user", "email", req.Email)
+			resp := EmailSignupResponse{
+				Message: "This account has been disabled. Please contact support.",
+				LOC:     "SHD_EML_577",
+			}
+			return http.StatusForbidden, resp
+		}
+
 		if user_info.Verified {
 			logger.Warn("email already exists", "email", req.Email)
 
@@ -847,8 +1126,8 @@ func HandleEmailSignupBase(
 		logger.Info("Email signup: email exists but not verified", "email", req.Email)
 	}
 
-	// 3. Generate a verification token and Create a user record with "verified = false"
-	token := uuid.NewString()
+	// 3. Create a user record with "verified = false" and send the
+	// verification email
 	var user_name = req.Email
 	user_info = new(ApiTypes.UserInfo)
 	user_info.UserIdType = "email"
@@ -858,10 +1137,17 @@ func HandleEmailSignupBase(
 	user_info.UserStatus = "active"
 	user_info.FirstName = req.FirstName
 	user_info.LastName = req.LastName
-	user_info.VToken = token
-	_, err1 := rc.UpsertUser(user_info,
-		req.Password, false, false, false, false, false)
+	saved_user, err1 := sendNewVerificationEmail(rc, user_info, req.Password)
+	token := user_info.VToken
 
+	if errors.Is(err1, ErrEmailCooldown) {
+		resp := EmailSignupResponse{
+			Message: "A verification email was sent to this address recently. " +
+				"Please check your email or try again later.",
+			LOC: "SHD_EML_1004",
+		}
+		return http.StatusTooManyRequests, resp
+	}
 	if err1 != nil {
 		error_msg := fmt.Sprintf("failed creating user (SHD_EML_710), error:%v", err1)
 		logger.Error("failed creating user account", "error", err1, "email", req.Email)
@@ -881,22 +1167,6 @@ func HandleEmailSignupBase(
 		return http.StatusInternalServerError, resp
 	}
 
-	home_domain := os.Getenv("APP_BASE_URL")
-	if home_domain == "" {
-		logger.Error("missing APP_BASE_URL env var", "email", req.Email)
-	}
-
-	// 4. Send verification email
-	verificationURL := fmt.Sprintf("%s/auth/email/verify?token=%s", home_domain, token)
-	// SECURITY: Do not log full verification URLs or tokens - they allow account takeover
-	logger.Info("sending verification email",
-		"to", req.Email,
-		"verificationURL", verificationURL,
-		"token", ApiUtils.MaskToken(token))
-
-	rc.PushCallFlow("SHD_EML_642")
-	go sendVerificationEmail(rc, req.Email, verificationURL)
-
 	log_id := sysdatastores.NextActivityLogID()
 	resp_msg := fmt.Sprintf("Signup successful! Please check your email:%s to verify your account, log_id:%d.",
 		req.Email, log_id)
@@ -916,6 +1186,11 @@ func HandleEmailSignupBase(
 		ModuleName:   ApiTypes.ModuleName_EmailAuth,
 		ActivityMsg:  &msg,
 		CallerLoc:    "SHD_EML_689"})
+
+	if saved_user != nil {
+		user_info = saved_user
+	}
+	publishAuthEvent(AuthEventSignedUp, user_info)
 	return http.StatusOK, resp
 }
 
@@ -1019,6 +1294,16 @@ func HandleForgotPasswordBase(
 	// Log internally whether user exists, but don't reveal this to the client.
 	successMsg := "If an account exists with this email, a password reset link has been sent."
 
+	// The cooldown is checked, and a send recorded, whether or not the
+	// account exists, so that a 429 doesn't tell existing accounts apart
+	if _, err := CheckEmailCooldown(rc, EmailKindPasswordReset, req.Email); err != nil {
+		return http.StatusTooManyRequests, map[string]string{
+			"status":  "error",
+			"message": "Too many password reset requests for this email. Please try again later.",
+			"loc":     "SHD_EML_1005",
+		}
+	}
+
 	user, exist := rc.GetUserInfoByEmail(req.Email)
 	if !exist {
 		// SECURITY: Log internally but return success to prevent enumeration
@@ -1045,12 +1330,23 @@ func HandleForgotPasswordBase(
 	}
 
 	// Will report errors if authentication is managed by Kratos!
-	token := uuid.NewString()
-	rc.UpdateTokenByEmail(req.Email, token)
+	token, err := issueToken(rc, func(token string) error {
+		return rc.UpdateTokenByEmail(req.Email, token)
+	})
+	if err != nil {
+		logger.Error("failed to issue reset token", "email", req.Email, "error", err)
+		ReleaseEmailCooldown(EmailKindPasswordReset, req.Email)
+		return http.StatusInternalServerError, map[string]string{
+			"status":  "error",
+			"message": "server error (reset token)",
+			"loc":     "SHD_EML_039",
+		}
+	}
 
 	home_domain := os.Getenv("APP_BASE_URL")
 	if home_domain == "" {
 		logger.Error("APP_BASE_URLnot set")
+		ReleaseEmailCooldown(EmailKindPasswordReset, req.Email)
 		return http.StatusBadRequest, map[string]string{
 			"status":  "error",
 			"message": "server error (env var not set)",
@@ -1066,7 +1362,12 @@ func HandleForgotPasswordBase(
     `, user.UserName, resetURL, resetURL)
 	textBody := fmt.Sprintf("Hi %s,\n\nClick the link below to reset your password:\n%s", user.UserName, resetURL)
 	rc.PushCallFlow("SHD_EML_786")
-	go ApiUtils.SendMail(rc, req.Email, "Password Reset", textBody, htmlBody, ApiUtils.EmailTypePasswordReset)
+	go func(to string) {
+		err := ApiUtils.SendMail(rc, to, "Password Reset", textBody, htmlBody, ApiUtils.EmailTypePasswordReset)
+		if err != nil {
+			ReleaseEmailCooldown(EmailKindPasswordReset, to)
+		}
+	}(req.Email)
 
 	log_id := sysdatastores.NextActivityLogID()
 	msg := fmt.Sprintf("reset link sent to email:%s", req.Email)
@@ -1255,6 +1556,8 @@ func HandleResetPasswordConfirmBase(
 		ModuleName:   ApiTypes.ModuleName_EmailAuth,
 		ActivityMsg:  &msg,
 		CallerLoc:    "SHD_EML_779"})
+
+	publishAuthEvent(AuthEventPasswordReset, user_info)
 	return http.StatusOK, "Password has been reset successfully (SHD_EML_263)."
 }
 */
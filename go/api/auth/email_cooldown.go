@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+// Kinds of emails limited by the email cooldown. Each kind has its own
+// limit, so a verification email doesn't hold back a password reset.
+const (
+	EmailKindVerification  = "verification"
+	EmailKindPasswordReset = "password_reset"
+)
+
+// Defaults of LibConfig.EmailSendIntervalSeconds and EmailSendMaxPerDay
+const (
+	defaultEmailSendInterval  = 60 * time.Second
+	defaultEmailSendMaxPerDay = 10
+)
+
+// ErrEmailCooldown is returned when an email is not sent because the
+// address got one of its kind too recently or too often
+var ErrEmailCooldown = errors.New("too many emails to this address (SHD_EML_1001)")
+
+// emailCooldown tracks the emails sent to each address, to keep a client
+// that repeats a signup or a password reset from flooding a victim's
+// inbox. Like the rate limiters, it is kept in memory: a restart or
+// another instance starts from no sends.
+type emailCooldown struct {
+	mu    sync.Mutex
+	sends map[string][]time.Time // By kind and address, oldest first, of the last 24 hours
+}
+
+var (
+	emailCooldowns    *emailCooldown
+	emailCooldownOnce sync.Once
+)
+
+func getEmailCooldown() *emailCooldown {
+	emailCooldownOnce.Do(func() {
+		emailCooldowns = &emailCooldown{sends: make(map[string][]time.Time)}
+		go emailCooldowns.cleanup()
+	})
+	return emailCooldowns
+}
+
+// emailSendLimits returns the configured interval and daily maximum; a
+// zero interval or maximum means no limit of that kind
+func emailSendLimits() (time.Duration, int) {
+	interval := defaultEmailSendInterval
+	if seconds := ApiTypes.LibConfig.EmailSendIntervalSeconds; seconds < 0 {
+		interval = 0
+	} else if seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+	max_per_day := defaultEmailSendMaxPerDay
+	if n := ApiTypes.LibConfig.EmailSendMaxPerDay; n < 0 {
+		max_per_day = 0
+	} else if n > 0 {
+		max_per_day = n
+	}
+	return interval, max_per_day
+}
+
+// allow records a send of an email of 'kind' to 'email' at 'now' and
+// returns true, or returns false and how long to wait if the address got
+// one less than 'interval' ago or 'max_per_day' in the last 24 hours.
+func (ec *emailCooldown) allow(
+	kind string,
+	email string,
+	now time.Time,
+	interval time.Duration,
+	max_per_day int) (bool, time.Duration) {
+	key := kind + ":" + strings.ToLower(strings.TrimSpace(email))
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	sends := pruneSends(ec.sends[key], now)
+	if n := len(sends); n > 0 && interval > 0 && now.Sub(sends[n-1]) < interval {
+		ec.sends[key] = sends
+		return false, sends[n-1].Add(interval).Sub(now)
+	}
+	if max_per_day > 0 && len(sends) >= max_per_day {
+		ec.sends[key] = sends
+		return false, sends[len(sends)-max_per_day].Add(24 * time.Hour).Sub(now)
+	}
+	ec.sends[key] = append(sends, now)
+	return true, 0
+}
+
+// release takes back the last send of an email of 'kind' to 'email'
+// recorded by allow
+func (ec *emailCooldown) release(kind string, email string) {
+	key := kind + ":" + strings.ToLower(strings.TrimSpace(email))
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if sends := ec.sends[key]; len(sends) > 0 {
+		ec.sends[key] = sends[:len(sends)-1]
+	}
+}
+
+// pruneSends drops the sends older than 24 hours
+func pruneSends(sends []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(sends) && now.Sub(sends[i]) >= 24*time.Hour {
+		i++
+	}
+	return sends[i:]
+}
+
+// cleanup periodically removes the addresses without a send in the last
+// 24 hours
+func (ec *emailCooldown) cleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ec.mu.Lock()
+		now := time.Now()
+		for key, sends := range ec.sends {
+			if sends = pruneSends(sends, now); len(sends) == 0 {
+				delete(ec.sends, key)
+			} else {
+				ec.sends[key] = sends
+			}
+		}
+		ec.mu.Unlock()
+	}
+}
+
+// CheckEmailCooldown must be called before sending an email of 'kind'
+// (EmailKind*) to 'email'. It records the send and returns nil, or, if
+// the address got one too recently or too often, logs the attempt to the
+// activity log and returns ErrEmailCooldown with how long to wait. The
+// send is recorded up front so that concurrent requests can't all pass;
+// call ReleaseEmailCooldown if the email is not sent after all.
+func CheckEmailCooldown(rc ApiTypes.RequestContext, kind string, email string) (time.Duration, error) {
+	interval, max_per_day := emailSendLimits()
+	allowed, retry_after := getEmailCooldown().allow(kind, email, time.Now(), interval, max_per_day)
+	if allowed {
+		return 0, nil
+	}
+
+	retry_after = retry_after.Round(time.Second)
+	msg := fmt.Sprintf("%s email to %s throttled, retry after %s", kind, email, retry_after)
+	rc.GetLogger().Warn("email throttled", "kind", kind, "email", email, "retry_after", retry_after.String())
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: ApiTypes.ActivityType_EmailThrottled,
+		AppName:      ApiTypes.AppName_Auth,
+		ModuleName:   ApiTypes.ModuleName_EmailAuth,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_EML_1002"})
+	return retry_after, ErrEmailCooldown
+}
+
+// ReleaseEmailCooldown takes back the send recorded by CheckEmailCooldown
+// for an email of 'kind' to 'email' that was not sent, e.g. because saving
+// its token or sending it failed, so that only emails actually sent count
+// against the limits.
+func ReleaseEmailCooldown(kind string, email string) {
+	getEmailCooldown().release(kind, email)
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+func TestEmailCooldownAllow(t *testing.T) {
+	ec := &emailCooldown{sends: make(map[string][]time.Time)}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if ok, _ := ec.allow(EmailKindVerification, "amy@example.com", start, time.Minute, 3); !ok {
+		t.Fatal("first send throttled")
+	}
+	ok, retry := ec.allow(EmailKindVerification, " AMY@example.com", start.Add(20*time.Second), time.Minute, 3)
+	if ok || retry != 40*time.Second {
+		t.Errorf("send within the interval: ok %v, retry %s, want false, 40s", ok, retry)
+	}
+	if ok, _ := ec.allow(EmailKindPasswordReset, "amy@example.com", start.Add(20*time.Second), time.Minute, 3); !ok {
+		t.Error("password reset held back by a verification email")
+	}
+
+	for i := 1; i < 3; i++ {
+		if ok, _ := ec.allow(EmailKindVerification, "amy@example.com", start.Add(time.Duration(i)*time.Hour), time.Minute, 3); !ok {
+			t.Fatalf("send %d throttled", i+1)
+		}
+	}
+	ok, retry = ec.allow(EmailKindVerification, "amy@example.com", start.Add(5*time.Hour), time.Minute, 3)
+	if ok || retry != 19*time.Hour {
+		t.Errorf("send over the daily maximum: ok %v, retry %s, want false, 19h", ok, retry)
+	}
+	if ok, _ := ec.allow(EmailKindVerification, "amy@example.com", start.Add(24*time.Hour), time.Minute, 3); !ok {
+		t.Error("send throttled after the oldest one expired")
+	}
+
+	// No limits
+	for i := 0; i < 5; i++ {
+		if ok, _ := ec.allow(EmailKindVerification, "bob@example.com", start, 0, 0); !ok {
+			t.Fatalf("unlimited send %d throttled", i+1)
+		}
+	}
+}
+
+func TestEmailCooldownRelease(t *testing.T) {
+	ec := &emailCooldown{sends: make(map[string][]time.Time)}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// A send that failed doesn't hold back the next one
+	if ok, _ := ec.allow(EmailKindPasswordReset, "amy@example.com", start, time.Minute, 1); !ok {
+		t.Fatal("first send throttled")
+	}
+	ec.release(EmailKindPasswordReset, " Amy@example.com")
+	if ok, _ := ec.allow(EmailKindPasswordReset, "amy@example.com", start.Add(time.Second), time.Minute, 1); !ok {
+		t.Error("send throttled after the failed one was released")
+	}
+	if ok, _ := ec.allow(EmailKindPasswordReset, "amy@example.com", start.Add(time.Hour), time.Minute, 1); ok {
+		t.Error("send over the daily maximum allowed")
+	}
+
+	// Releasing without a send is a no-op
+	ec.release(EmailKindVerification, "bob@example.com")
+	if n := len(ec.sends[EmailKindVerification+":bob@example.com"]); n != 0 {
+		t.Errorf("sends = %d, want 0", n)
+	}
+}
+
+func TestEmailSendLimits(t *testing.T) {
+	saved := ApiTypes.LibConfig
+	t.Cleanup(func() { ApiTypes.LibConfig = saved })
+
+	ApiTypes.LibConfig.EmailSendIntervalSeconds, ApiTypes.LibConfig.EmailSendMaxPerDay = 0, 0
+	if interval, max_per_day := emailSendLimits(); interval != time.Minute || max_per_day != 10 {
+		t.Errorf("defaults = %s, %d, want 1m0s, 10", interval, max_per_day)
+	}
+	ApiTypes.LibConfig.EmailSendIntervalSeconds, ApiTypes.LibConfig.EmailSendMaxPerDay = 300, 4
+	if interval, max_per_day := emailSendLimits(); interval != 5*time.Minute || max_per_day != 4 {
+		t.Errorf("configured = %s, %d, want 5m0s, 4", interval, max_per_day)
+	}
+	ApiTypes.LibConfig.EmailSendIntervalSeconds, ApiTypes.LibConfig.EmailSendMaxPerDay = -1, -1
+	if interval, max_per_day := emailSendLimits(); interval != 0 || max_per_day != 0 {
+		t.Errorf("disabled = %s, %d, want 0s, 0", interval, max_per_day)
+	}
+}
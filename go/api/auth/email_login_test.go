@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+func TestHandleEmailLoginBaseIdentifier(t *testing.T) {
+	rc := testharness.NewFakeRequestContext(t, nil)
+	for _, user := range []*ApiTypes.UserInfo{
+		{UserId: "u1", UserName: "alice", Email: "alice@example.com", UserStatus: "active", Verified: true},
+		{UserId: "u2", UserName: "shared", Email: "bob@example.com", UserStatus: "active", Verified: true},
+		{UserId: "u3", UserName: "shared", Email: "carol@example.com", UserStatus: "active", Verified: true},
+	} {
+		rc.Users[user.Email] = user
+		rc.Passwords[user.Email] = "secret"
+	}
+
+	for _, tt := range []struct {
+		name        string
+		body        map[string]string
+		want_status int
+		want_msg    string
+	}{
+		{name: "email identifier",
+			body:        map[string]string{"identifier": "alice@example.com", "password": "secret"},
+			want_status: http.StatusOK},
+		{name: "user name identifier",
+			body:        map[string]string{"identifier": "alice", "password": "secret"},
+			want_status: http.StatusOK},
+		{name: "legacy email field",
+			body:        map[string]string{"email": "alice@example.com", "password": "secret"},
+			want_status: http.StatusOK},
+		{name: "unknown user name",
+			body:        map[string]string{"identifier": "nobody", "password": "secret"},
+			want_status: http.StatusUnauthorized, want_msg: "invalid credentials"},
+		{name: "unknown email",
+			body:        map[string]string{"identifier": "nobody@example.com", "password": "secret"},
+			want_status: http.StatusUnauthorized, want_msg: "invalid credentials"},
+		{name: "wrong password",
+			body:        map[string]string{"identifier": "alice", "password": "wrong"},
+			want_status: http.StatusUnauthorized, want_msg: "invalid credentials"},
+		{name: "shared user name",
+			body:        map[string]string{"identifier": "shared", "password": "secret"},
+			want_status: http.StatusUnauthorized, want_msg: "invalid credentials"},
+		{name: "missing identifier",
+			body:        map[string]string{"password": "secret"},
+			want_status: http.StatusBadRequest, want_msg: "missing email or user name (SHD_EML_081)"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.body)
+			status, resp := HandleEmailLoginBase(rc, body, "")
+			if status != tt.want_status {
+				t.Fatalf("status = %d, want %d (resp %v)", status, tt.want_status, resp)
+			}
+			if tt.want_msg != "" && resp["message"] != tt.want_msg {
+				t.Errorf("message = %q, want %q", resp["message"], tt.want_msg)
+			}
+		})
+	}
+}
+
+func TestHandleEmailLoginSavesSession(t *testing.T) {
+	rc := testharness.NewFakeRequestContext(t, nil)
+	rc.Users["alice@example.com"] = &ApiTypes.UserInfo{
+		UserId: "u1", UserName: "alice", Email: "alice@example.com", UserStatus: "active", Verified: true}
+	rc.Passwords["alice@example.com"] = "secret"
+
+	body, _ := json.Marshal(map[string]string{"identifier": "alice", "password": "secret"})
+	if status, resp := HandleEmailLoginBase(rc, body, ""); status != http.StatusOK {
+		t.Fatalf("status = %d (resp %v)", status, resp)
+	}
+	if len(rc.Sessions) != 1 {
+		t.Fatalf("saved %d sessions, want 1", len(rc.Sessions))
+	}
+	got := rc.Sessions[0]
+	if got.LoginMethod != "email_login" || got.UserName != "alice" || got.UserNameType != "email" ||
+		got.UserRegID != "alice@example.com" || got.UserEmail != "alice@example.com" ||
+		!got.NeedUpdateUser || got.SessionID == "" || got.AuthToken == "" || got.Expiry.IsZero() {
+		t.Errorf("saved session = %+v", got)
+	}
+	if rc.Cookies["session_id"] != got.SessionID {
+		t.Errorf("session cookie = %q, want %q", rc.Cookies["session_id"], got.SessionID)
+	}
+}
+
+// TestHandleEmailLoginAccountState checks that only a user with the right
+// password learns that the email is unverified or the account disabled
+func TestHandleEmailLoginAccountState(t *testing.T) {
+	rc := testharness.NewFakeRequestContext(t, nil)
+	rc.Users["dave@example.com"] = &ApiTypes.UserInfo{
+		UserId: "u4", UserName: "dave", Email: "dave@example.com", UserStatus: "active"}
+	rc.Users["erin@example.com"] = &ApiTypes.UserInfo{
+		UserId: "u5", UserName: "erin", Email: "erin@example.com", UserStatus: ApiTypes.UserStatus_Disabled,
+		Password: "hash", Verified: true}
+	rc.Passwords["dave@example.com"] = "secret"
+	rc.Passwords["erin@example.com"] = "secret"
+
+	sent := make(chan string, 1)
+	ApiUtils.SetEmailSender(func(rc ApiTypes.RequestContext, to, subject, textBody, htmlBody string, emailType string) error {
+		sent <- to
+		return nil
+	})
+	t.Cleanup(func() { ApiUtils.SetEmailSender(nil) })
+
+	for _, tt := range []struct {
+		name        string
+		body        map[string]interface{}
+		want_status int
+		want_resend string
+	}{
+		{name: "unverified, wrong password",
+			body:        map[string]interface{}{"identifier": "dave", "password": "wrong"},
+			want_status: http.StatusUnauthorized},
+		{name: "unverified",
+			body:        map[string]interface{}{"identifier": "dave", "password": "secret"},
+			want_status: ApiTypes.CustomHttpStatus_EmailNotVerified, want_resend: "available"},
+		{name: "disabled, wrong password",
+			body:        map[string]interface{}{"identifier": "erin", "password": "wrong"},
+			want_status: http.StatusUnauthorized},
+		{name: "disabled",
+			body:        map[string]interface{}{"identifier": "erin", "password": "secret"},
+			want_status: ApiTypes.CustomHttpStatus_AccountDisabled},
+		{name: "unverified, resend",
+			body:        map[string]interface{}{"identifier": "dave", "password": "secret", "resend_verification": true},
+			want_status: ApiTypes.CustomHttpStatus_EmailNotVerified, want_resend: "sent"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.body)
+			status, resp := HandleEmailLoginBase(rc, body, "")
+			if status != tt.want_status {
+				t.Fatalf("status = %d, want %d (resp %v)", status, tt.want_status, resp)
+			}
+			if status == http.StatusUnauthorized && resp["message"] != "invalid credentials" {
+				t.Errorf("message = %q, want invalid credentials", resp["message"])
+			}
+			if resp["resend_verification"] != tt.want_resend {
+				t.Errorf("resend_verification = %q, want %q", resp["resend_verification"], tt.want_resend)
+			}
+		})
+	}
+
+	select {
+	case to := <-sent:
+		if to != "dave@example.com" {
+			t.Errorf("verification email sent to %q", to)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("verification email not sent")
+	}
+	if rc.Users["dave@example.com"].VToken == "" || len(rc.Sessions) != 0 {
+		t.Errorf("after resend: token %q, %d sessions", rc.Users["dave@example.com"].VToken, len(rc.Sessions))
+	}
+
+	// The deployment may let unverified users log in
+	saved := ApiTypes.LibConfig.AllowUnverifiedLogin
+	ApiTypes.LibConfig.AllowUnverifiedLogin = true
+	t.Cleanup(func() { ApiTypes.LibConfig.AllowUnverifiedLogin = saved })
+	body, _ := json.Marshal(map[string]interface{}{"identifier": "dave", "password": "secret"})
+	if status, resp := HandleEmailLoginBase(rc, body, ""); status != http.StatusOK {
+		t.Errorf("unverified login allowed by config: status = %d (resp %v)", status, resp)
+	}
+}
+
+// TestHandleEmailLoginAccountLockout checks that the email and the user
+// name of an account share one lockout
+func TestHandleEmailLoginAccountLockout(t *testing.T) {
+	rc := testharness.NewFakeRequestContext(t, nil)
+	rc.Users["frank@example.com"] = &ApiTypes.UserInfo{
+		UserId: "u6", UserName: "frank", Email: "frank@example.com", UserStatus: "active", Verified: true}
+	rc.Passwords["frank@example.com"] = "secret"
+	t.Cleanup(func() { ResetAccountLockout("frank@example.com") })
+
+	login := func(identifier string, password string) int {
+		body, _ := json.Marshal(map[string]string{"identifier": identifier, "password": password})
+		status, _ := HandleEmailLoginBase(rc, body, "")
+		return status
+	}
+	for i := 0; i < 10; i++ {
+		identifier := "frank"
+		if i%2 == 1 {
+			identifier = "frank@example.com"
+		}
+		if status := login(identifier, "wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want %d", i+1, status, http.StatusUnauthorized)
+		}
+	}
+	for _, identifier := range []string{"frank", "frank@example.com"} {
+		if status := login(identifier, "secret"); status != http.StatusTooManyRequests {
+			t.Errorf("%s: status = %d, want %d", identifier, status, http.StatusTooManyRequests)
+		}
+	}
+}
+
+// TestHandleEmailLoginTemporaryPassword checks that a temporary password
+// works until it expires, and that the client is told it expires
+func TestHandleEmailLoginTemporaryPassword(t *testing.T) {
+	rc := testharness.NewFakeRequestContext(t, nil)
+	rc.Users["gina@example.com"] = &ApiTypes.UserInfo{
+		UserId: "u7", UserName: "gina", Email: "gina@example.com", UserStatus: "active", Verified: true}
+	rc.Passwords["gina@example.com"] = "temporary"
+	body, _ := json.Marshal(map[string]string{"identifier": "gina", "password": "temporary"})
+
+	expires_at := time.Now().Add(time.Hour)
+	rc.Users["gina@example.com"].PasswordExpiresAt = &expires_at
+	status, resp := HandleEmailLoginBase(rc, body, "")
+	if status != http.StatusOK || resp["password_expires_at"] != expires_at.UTC().Format(time.RFC3339) {
+		t.Errorf("before expiry: status = %d (resp %v)", status, resp)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	rc.Users["gina@example.com"].PasswordExpiresAt = &expired
+	status, resp = HandleEmailLoginBase(rc, body, "")
+	if status != http.StatusUnauthorized || resp["message"] != "invalid credentials" {
+		t.Errorf("after expiry: status = %d (resp %v)", status, resp)
+	}
+}
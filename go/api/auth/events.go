@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/loggerutil"
+)
+
+// Auth events published by the email handlers
+const (
+	AuthEventSignedUp      = "user.signed_up"
+	AuthEventVerified      = "user.verified"
+	AuthEventLoggedIn      = "user.logged_in"
+	AuthEventPasswordReset = "password.reset"
+)
+
+// AuthEvent is one login/signup event. Passwords and tokens are never
+// part of it.
+type AuthEvent struct {
+	Type   string    `json:"type"`
+	UserID string    `json:"user_id"`
+	Email  string    `json:"email"`
+	Time   time.Time `json:"time"`
+}
+
+// EventPublisher receives auth events. Publish is called on the request
+// path, so implementations must not block: queue the event or drop it.
+type EventPublisher interface {
+	Publish(event AuthEvent)
+}
+
+// NoopEventPublisher drops every event. It is the default.
+type NoopEventPublisher struct{}
+
+func (NoopEventPublisher) Publish(AuthEvent) {}
+
+var (
+	eventMu        sync.RWMutex
+	eventPublisher EventPublisher = NoopEventPublisher{}
+)
+
+// SetEventPublisher sets where auth events go; nil restores the no-op
+// publisher.
+func SetEventPublisher(p EventPublisher) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	if p == nil {
+		p = NoopEventPublisher{}
+	}
+	eventPublisher = p
+}
+
+func publishAuthEvent(event_type string, user_info *ApiTypes.UserInfo) {
+	eventMu.RLock()
+	p := eventPublisher
+	eventMu.RUnlock()
+	p.Publish(AuthEvent{
+		Type:   event_type,
+		UserID: user_info.UserId,
+		Email:  user_info.Email,
+		Time:   time.Now().UTC(),
+	})
+}
+
+// ChannelEventPublisher sends events to a channel, e.g. for in-process
+// consumers and tests. Events are dropped while the channel is full.
+type ChannelEventPublisher struct {
+	C       chan AuthEvent
+	dropped atomic.Int64
+}
+
+// NewChannelEventPublisher creates a publisher with a channel buffering
+// up to 'size' events
+func NewChannelEventPublisher(size int) *ChannelEventPublisher {
+	return &ChannelEventPublisher{C: make(chan AuthEvent, size)}
+}
+
+func (p *ChannelEventPublisher) Publish(event AuthEvent) {
+	select {
+	case p.C <- event:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped because C was full
+func (p *ChannelEventPublisher) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+// HTTPEventPublisher POSTs each event as JSON to a URL from a background
+// goroutine. Events are dropped while its queue is full; failed posts
+// are logged and not retried.
+type HTTPEventPublisher struct {
+	url     string
+	client  *http.Client
+	queue   chan AuthEvent
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Int64
+}
+
+// NewHTTPEventPublisher starts a publisher posting to 'url', queueing up
+// to 'queue_size' events. Call Close to stop it.
+func NewHTTPEventPublisher(url string, queue_size int, timeout time.Duration) *HTTPEventPublisher {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	p := &HTTPEventPublisher{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan AuthEvent, queue_size),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *HTTPEventPublisher) Publish(event AuthEvent) {
+	select {
+	case p.queue <- event:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped because the queue was full
+func (p *HTTPEventPublisher) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+// Close posts the queued events and stops the publisher. Events
+// published after Close panic, so call SetEventPublisher first.
+func (p *HTTPEventPublisher) Close() {
+	p.once.Do(func() { close(p.queue) })
+	<-p.done
+}
+
+func (p *HTTPEventPublisher) run() {
+	defer close(p.done)
+	logger := loggerutil.CreateDefaultLogger("SHD_EVT_135")
+	for event := range p.queue {
+		if err := p.post(event); err != nil {
+			logger.Error("failed posting auth event",
+				"type", event.Type,
+				"email", event.Email,
+				"error", err)
+		}
+	}
+}
+
+func (p *HTTPEventPublisher) post(event AuthEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w (SHD_EVT_149)", err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w (SHD_EVT_153)", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post event: %w (SHD_EVT_158)", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("event endpoint returned status %d (SHD_EVT_162)", resp.StatusCode)
+	}
+	return nil
+}
+
+// NATSConn is the part of a NATS connection the publisher needs;
+// *nats.Conn satisfies it.
+type NATSConn interface {
+	Publish(subject string, data []byte) error
+}
+
+// NATSEventPublisher publishes each event as JSON to the subject
+// prefix + event type, e.g. "auth.user.logged_in". The NATS client
+// buffers publishes, so this does not wait for the server.
+type NATSEventPublisher struct {
+	conn   NATSConn
+	prefix string
+}
+
+// NewNATSEventPublisher creates a publisher on 'conn'. An empty prefix
+// defaults to "auth.".
+func NewNATSEventPublisher(conn NATSConn, prefix string) *NATSEventPublisher {
+	if prefix == "" {
+		prefix = "auth."
+	} else if !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &NATSEventPublisher{conn: conn, prefix: prefix}
+}
+
+func (p *NATSEventPublisher) Publish(event AuthEvent) {
+	data, err := json.Marshal(event)
+	if err == nil {
+		err = p.conn.Publish(p.prefix+event.Type, data)
+	}
+	if err != nil {
+		logger := loggerutil.CreateDefaultLogger("SHD_EVT_197")
+		logger.Error("failed publishing auth event",
+			"type", event.Type,
+			"email", event.Email,
+			"error", err)
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+func useEventPublisher(t *testing.T, p EventPublisher) {
+	t.Helper()
+	SetEventPublisher(p)
+	t.Cleanup(func() { SetEventPublisher(nil) })
+}
+
+func TestEmailLoginPublishesEvent(t *testing.T) {
+	events := NewChannelEventPublisher(4)
+	useEventPublisher(t, events)
+
+	rc := testharness.NewFakeRequestContext(t, nil)
+	rc.Users["alice@example.com"] = &ApiTypes.UserInfo{
+		UserId: "u1", UserName: "alice", Email: "alice@example.com", UserStatus: "active", Verified: true}
+	rc.Passwords["alice@example.com"] = "secret"
+
+	body, _ := json.Marshal(map[string]string{"identifier": "alice", "password": "wrong"})
+	if status, _ := HandleEmailLoginBase(rc, body, ""); status != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status = %d", status)
+	}
+	if len(events.C) != 0 {
+		t.Fatalf("failed login published %d events", len(events.C))
+	}
+
+	body, _ = json.Marshal(map[string]string{"identifier": "alice", "password": "secret"})
+	if status, resp := HandleEmailLoginBase(rc, body, ""); status != http.StatusOK {
+		t.Fatalf("status = %d (resp %v)", status, resp)
+	}
+	if len(events.C) != 1 {
+		t.Fatalf("published %d events, want 1", len(events.C))
+	}
+	got := <-events.C
+	if got.Type != AuthEventLoggedIn || got.UserID != "u1" || got.Email != "alice@example.com" || got.Time.IsZero() {
+		t.Errorf("event = %+v", got)
+	}
+}
+
+func TestChannelEventPublisherDoesNotBlock(t *testing.T) {
+	p := NewChannelEventPublisher(1)
+	p.Publish(AuthEvent{Type: AuthEventSignedUp})
+	p.Publish(AuthEvent{Type: AuthEventVerified})
+	if got := (<-p.C).Type; got != AuthEventSignedUp {
+		t.Errorf("event = %s, want %s", got, AuthEventSignedUp)
+	}
+	if p.Dropped() != 1 {
+		t.Errorf("dropped = %d, want 1", p.Dropped())
+	}
+}
+
+func TestHTTPEventPublisher(t *testing.T) {
+	received := make(chan AuthEvent, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event AuthEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		received <- event
+	}))
+	defer srv.Close()
+
+	p := NewHTTPEventPublisher(srv.URL, 4, time.Second)
+	p.Publish(AuthEvent{Type: AuthEventLoggedIn, UserID: "u1", Email: "alice@example.com"})
+	p.Close()
+
+	select {
+	case got := <-received:
+		if got.Type != AuthEventLoggedIn || got.UserID != "u1" {
+			t.Errorf("event = %+v", got)
+		}
+	default:
+		t.Fatal("no event posted")
+	}
+}
+
+type fakeNATSConn struct {
+	subjects []string
+	data     [][]byte
+}
+
+func (c *fakeNATSConn) Publish(subject string, data []byte) error {
+	c.subjects = append(c.subjects, subject)
+	c.data = append(c.data, data)
+	return nil
+}
+
+func TestNATSEventPublisher(t *testing.T) {
+	conn := &fakeNATSConn{}
+	NewNATSEventPublisher(conn, "").Publish(AuthEvent{Type: AuthEventPasswordReset, Email: "a@example.com"})
+	NewNATSEventPublisher(conn, "myapp").Publish(AuthEvent{Type: AuthEventVerified})
+
+	want := []string{"auth.password.reset", "myapp.user.verified"}
+	if len(conn.subjects) != 2 || conn.subjects[0] != want[0] || conn.subjects[1] != want[1] {
+		t.Fatalf("subjects = %v, want %v", conn.subjects, want)
+	}
+	var event AuthEvent
+	if err := json.Unmarshal(conn.data[0], &event); err != nil || event.Email != "a@example.com" {
+		t.Errorf("data = %s, err %v", conn.data[0], err)
+	}
+}
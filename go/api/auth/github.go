@@ -44,36 +44,20 @@ func getGitHubOAuthConfig() *oauth2.Config {
 }
 
 func HandleGitHubLogin(c echo.Context) error {
-	// Generate a per-request, time-limited nonce for CSRF protection
-	nonce := GenerateOAuthNonce()
-
-	// Handle cache full case (DoS protection)
-	if nonce == "" {
-		log.Printf("***** Alarm: OAuth nonce cache full - possible DoS attack (SHD_GHB_035)")
-		return c.String(http.StatusServiceUnavailable, "Service temporarily unavailable. Please try again.")
-	}
-
-	url := getGitHubOAuthConfig().AuthCodeURL(nonce)
-	msg := fmt.Sprintf("Github Login, url:%s", url)
-	log.Printf("%s (SHD_GHB_032)", msg)
-
-	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
-		ActivityName: ApiTypes.ActivityName_Auth,
-		ActivityType: ApiTypes.ActivityType_GitHubAuth,
-		AppName:      ApiTypes.AppName_Auth,
-		ModuleName:   ApiTypes.ModuleName_GitHubAuth,
-		ActivityMsg:  &msg,
-		CallerLoc:    "SHD_GHB_041"})
-
-	return c.Redirect(http.StatusTemporaryRedirect, url)
+	return EchoFactory.WrapEcho(GitHubLogin)(c)
 }
 
+// HandleGitHubLoginPocket is an alias of HandleGitHubLogin, kept for one
+// release. Pocketbase apps should register EchoFactory.WrapHTTP(GitHubLogin).
 func HandleGitHubLoginPocket(e echo.Context) error {
-	rc := EchoFactory.NewFromEcho(e, "ARX_GHB_050")
-	defer rc.Close()
+	return HandleGitHubLogin(e)
+}
+
+// GitHubLogin is the framework-agnostic GitHub login handler. It redirects
+// the browser to GitHub's consent page.
+func GitHubLogin(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
 	logger := rc.GetLogger()
-	path := e.Path()
-	logger.Info("Handle request", "path", path)
+	logger.Info("Handle request", "path", rc.GetRequest().URL.Path)
 
 	// Generate a per-request, time-limited nonce for CSRF protection
 	nonce := GenerateOAuthNonce()
@@ -81,8 +65,8 @@ func HandleGitHubLoginPocket(e echo.Context) error {
 	// Handle cache full case (DoS protection)
 	if nonce == "" {
 		logger.Error("OAuth nonce cache full - possible DoS attack", "loc", "SHD_GHB_066")
-		http.Error(e.Response(), "Service temporarily unavailable. Please try again.", http.StatusServiceUnavailable)
-		return nil
+		return http.StatusServiceUnavailable,
+			ApiTypes.TextPayload("Service temporarily unavailable. Please try again.")
 	}
 
 	url := getGitHubOAuthConfig().AuthCodeURL(nonce)
@@ -97,27 +81,22 @@ func HandleGitHubLoginPocket(e echo.Context) error {
 		ActivityMsg:  &msg,
 		CallerLoc:    "SHD_GHB_041"})
 
-	http.Redirect(e.Response(), e.Request(), url, http.StatusTemporaryRedirect)
-	return nil
+	return http.StatusTemporaryRedirect, ApiTypes.RedirectPayload(url)
 }
 
 func HandleGitHubCallback(c echo.Context) error {
-	rc := EchoFactory.NewFromEcho(c, "SHD_GHB_068")
-	reqID := rc.ReqID()
-	status_code, msg := HandleGitHubCallbackBase(rc, reqID)
-	c.String(status_code, msg)
-	return nil
+	return EchoFactory.WrapEcho(GitHubCallback)(c)
 }
 
-/*
-func HandleGitHubCallbackPocket(e *core.RequestEvent) error {
-	rc := RequestHandlers.NewFromPocket(e)
-	reqID := rc.ReqID()
-	status_code, msg := HandleGitHubCallbackBase(rc, reqID)
-	e.String(status_code, msg)
-	return nil
+// GitHubCallback is the framework-agnostic GitHub OAuth callback handler.
+// On success HandleGitHubCallbackBase returns 303 with the redirect URL.
+func GitHubCallback(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	status_code, msg := HandleGitHubCallbackBase(rc, rc.ReqID())
+	if status_code == http.StatusSeeOther {
+		return status_code, ApiTypes.RedirectPayload(msg)
+	}
+	return status_code, ApiTypes.TextPayload(msg)
 }
-*/
 
 // githubEmail represents an email from GitHub's /user/emails endpoint
 type githubEmail struct {
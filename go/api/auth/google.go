@@ -93,14 +93,18 @@ func decodeOAuthState(encoded string) (oauthState, error) {
 }
 
 func HandleGoogleLogin(c echo.Context) error {
-	rc := EchoFactory.NewFromEcho(c, "SHD_GGL_095")
-	defer rc.Close()
+	return EchoFactory.WrapEcho(GoogleLogin)(c)
+}
+
+// GoogleLogin is the framework-agnostic Google login handler. It redirects
+// the browser to Google's consent page.
+func GoogleLogin(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
 	logger := rc.GetLogger()
 
 	config := getGoogleOauthConfig()
 
 	// Capture and validate returnUrl from query params
-	returnURL := c.QueryParam("returnUrl")
+	returnURL := rc.QueryParam("returnUrl")
 	if returnURL != "" && !ApiUtils.IsSafeReturnURL(returnURL) {
 		logger.Warn("rejected unsafe returnUrl", "returnUrl", returnURL)
 		returnURL = "" // Reject unsafe URLs
@@ -112,24 +116,28 @@ func HandleGoogleLogin(c echo.Context) error {
 	// Handle cache full case (DoS protection)
 	if stateStr == "" {
 		logger.Error("OAuth nonce cache full - possible DoS attack")
-		return c.String(http.StatusServiceUnavailable, "Service temporarily unavailable. Please try again.")
+		return http.StatusServiceUnavailable,
+			ApiTypes.TextPayload("Service temporarily unavailable. Please try again.")
 	}
 
 	authURL := config.AuthCodeURL(stateStr, oauth2.AccessTypeOffline)
 	logger.Info("HandleGoogleLogin called", "returnUrl", returnURL, "redirect_to", authURL)
-	return c.Redirect(http.StatusTemporaryRedirect, authURL)
+	return http.StatusTemporaryRedirect, ApiTypes.RedirectPayload(authURL)
 }
 
 func HandleGoogleCallback(c echo.Context) error {
-	rc := EchoFactory.NewFromEcho(c, "SHD_GGL_067")
-	defer rc.Close()
+	return EchoFactory.WrapEcho(GoogleCallback)(c)
+}
+
+// GoogleCallback is the framework-agnostic Google OAuth callback handler.
+func GoogleCallback(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
 	logger := rc.GetLogger()
 	logger.Info("handle google callback")
 	status_code, redirect_url := HandleGoogleCallbackBase(rc)
 	if status_code == http.StatusSeeOther {
-		return c.Redirect(http.StatusSeeOther, redirect_url)
+		return status_code, ApiTypes.RedirectPayload(redirect_url)
 	}
-	return c.String(status_code, redirect_url)
+	return status_code, ApiTypes.TextPayload(redirect_url)
 }
 
 func HandleGoogleCallbackBase(
@@ -242,6 +250,13 @@ func HandleGoogleCallbackBase(
 	}
 
 	user_info, found := rc.GetUserInfoByEmail(googleUserInfo.Email)
+	if found && user_info.UserStatus == ApiTypes.UserStatus_Disabled {
+		// The upsert below would otherwise re-enable the account.
+		error_msg := "This account has been disabled. Please contact support."
+		logger.Warn("google login for disabled user", "email", googleUserInfo.Email)
+		return http.StatusForbidden, error_msg
+	}
+
 	if !found {
 		// Add user to database by rc.
 		user_info = new(ApiTypes.UserInfo)
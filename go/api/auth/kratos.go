@@ -1225,6 +1225,8 @@ type KratosSignupRequest struct {
 	Email     string `json:"email,omitempty"`
 	FirstName string `json:"first_name,omitempty"`
 	LastName  string `json:"last_name,omitempty"`
+	// CaptchaToken is required when the CAPTCHA check is enabled
+	CaptchaToken string `json:"captcha_token,omitempty"`
 }
 
 // KratosSignupTraits represents the Kratos identity traits
@@ -1323,6 +1325,14 @@ func HandleEmailSignupKratosBase(
 		}
 	}
 
+	if status, msg := checkCaptcha(c.Request().Context(), logger, c.Request(), req.CaptchaToken); status != 0 {
+		return status, KratosSignupResponse{
+			Status:  "error",
+			Message: msg,
+			LOC:     "SHD_0207180111",
+		}
+	}
+
 	// Handle legacy format - if traits.email is empty but email field is set
 	email := req.Traits.Email
 	firstName := ""
@@ -2241,6 +2251,8 @@ func HandleVerificationSubmitKratos(c echo.Context) error {
 	var reqBody struct {
 		FlowID string `json:"flow_id"`
 		Code   string `json:"code"`
+		// Optional page to go to after verification, checked by SafeRedirect
+		Redirect string `json:"redirect"`
 	}
 	if err := c.Bind(&reqBody); err != nil {
 		logger.Error("Invalid request body", "error", err)
@@ -2307,10 +2319,7 @@ func HandleVerificationSubmitKratos(c echo.Context) error {
 	// Kratos returns 303 redirect on success
 	if resp.StatusCode == http.StatusSeeOther {
 		logger.Info("Verification successful (Kratos 303 redirect)")
-		return c.JSON(http.StatusOK, map[string]string{
-			"status": "verified",
-			"state":  "passed_challenge",
-		})
+		return c.JSON(http.StatusOK, verifiedResponse(rc, reqBody.Redirect))
 	}
 
 	body, _ := io.ReadAll(resp.Body)
@@ -2320,10 +2329,7 @@ func HandleVerificationSubmitKratos(c echo.Context) error {
 	if err := json.Unmarshal(body, &result); err == nil {
 		if state, ok := result["state"].(string); ok && state == "passed_challenge" {
 			logger.Info("Verification successful")
-			return c.JSON(http.StatusOK, map[string]string{
-				"status": "verified",
-				"state":  "passed_challenge",
-			})
+			return c.JSON(http.StatusOK, verifiedResponse(rc, reqBody.Redirect))
 		}
 	}
 
@@ -2332,6 +2338,19 @@ func HandleVerificationSubmitKratos(c echo.Context) error {
 	return c.JSONBlob(resp.StatusCode, body)
 }
 
+// verifiedResponse is the response to a successful verification. It
+// includes the safe form of the client-supplied redirect, if any.
+func verifiedResponse(rc ApiTypes.RequestContext, redirect string) map[string]string {
+	resp := map[string]string{
+		"status": "verified",
+		"state":  "passed_challenge",
+	}
+	if redirect != "" {
+		resp["redirect_url"] = SafeRedirect(rc, redirect)
+	}
+	return resp
+}
+
 // HandleRecoverySubmitKratos proxies recovery requests to Kratos.
 // It handles two states:
 // - Email submission (no flow_id): creates a recovery flow and submits the email
@@ -2357,6 +2376,11 @@ func HandleRecoverySubmitKratos(c echo.Context) error {
 		Email  string `json:"email"`
 		FlowID string `json:"flow_id"`
 		Code   string `json:"code"`
+		// Required on email submission when the CAPTCHA check is enabled
+		CaptchaToken string `json:"captcha_token"`
+		// Optional page to go to after the password is set, checked by
+		// SafeRedirect
+		Redirect string `json:"redirect"`
 	}
 	if err := c.Bind(&reqBody); err != nil {
 		logger.Error("Invalid request body", "error", err)
@@ -2384,6 +2408,14 @@ func HandleRecoverySubmitKratos(c echo.Context) error {
 			})
 		}
 
+		if status, msg := checkCaptcha(c.Request().Context(), logger, c.Request(), reqBody.CaptchaToken); status != 0 {
+			return c.JSON(status, map[string]string{
+				"status":  "error",
+				"message": msg,
+				"loc":     "SHD_0214160040",
+			})
+		}
+
 		httpClient := &http.Client{Timeout: 10 * time.Second}
 
 		// Step 1: Create a new recovery flow via Kratos API
@@ -2568,9 +2600,13 @@ func HandleRecoverySubmitKratos(c echo.Context) error {
 						SameSite: http.SameSiteLaxMode,
 					})
 
-					return c.JSON(http.StatusOK, map[string]string{
+					success := map[string]string{
 						"status": "success",
-					})
+					}
+					if reqBody.Redirect != "" {
+						success["redirect_url"] = SafeRedirect(rc, reqBody.Redirect)
+					}
+					return c.JSON(http.StatusOK, success)
 				}
 			}
 		}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
+	"github.com/chendingplano/shared/go/api/EchoFactory"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+	"github.com/labstack/echo/v4"
+)
+
+// HandleLogout is kept as the Echo entry point; new code should
+// register Logout through EchoFactory.RegisterRoute.
+func HandleLogout(c echo.Context) error {
+	return EchoFactory.WrapEcho(Logout)(c)
+}
+
+// Logout is the framework-agnostic logout handler of the email, Google
+// and GitHub logins (Kratos sessions use HandleLogoutKratos).
+func Logout(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	logger := rc.GetLogger()
+	req := rc.GetRequest()
+
+	// SECURITY: Validate request origin, so that another site can't log
+	// the user out
+	if !IsSafeOriginRequest(req) {
+		logger.Warn("CSRF protection: rejected cross-origin request",
+			"origin", req.Header.Get("Origin"),
+			"referer", req.Header.Get("Referer"))
+		return http.StatusForbidden, ApiTypes.JSONPayload(map[string]string{
+			"status":  "error",
+			"message": "Invalid request origin",
+			"loc":     "SHD_LGO_CSRF_001",
+		})
+	}
+
+	status_code, msg := HandleLogoutBase(rc)
+	return status_code, ApiTypes.JSONPayload(msg)
+}
+
+// HandleLogoutBase ends the session of the request's session_id cookie
+// and clears the cookie. Clearing the cookie alone would leave the session
+// valid until it expires, for anyone holding a copy of the cookie: the
+// session is made inactive in the login sessions table, so that
+// sysdatastores.IsValidSession rejects it, and the logout is added to the
+// session log.
+//
+// It returns (status_code, json). A request without a session, or with one
+// already ended, is logged out as well.
+func HandleLogoutBase(rc ApiTypes.RequestContext) (int, map[string]string) {
+	logger := rc.GetLogger()
+	session_id := rc.GetCookie("session_id")
+
+	// The cookie is cleared even if the session could not be ended
+	rc.DeleteCookie("session_id")
+
+	logged_out := map[string]string{
+		"status":       "ok",
+		"message":      "Logged out",
+		"redirect_url": "/login",
+		"loc":          "SHD_LGO_061",
+	}
+	if session_id == "" {
+		logger.Info("Logout without session")
+		return http.StatusOK, logged_out
+	}
+
+	session, err := sysdatastores.GetLoginSession(rc, session_id)
+	if errors.Is(err, sql.ErrNoRows) {
+		logger.Warn("Logout of unknown session", "session_id", ApiUtils.MaskToken(session_id))
+		return http.StatusOK, logged_out
+	}
+	if err == nil {
+		_, err = sysdatastores.EndSession(rc, session_id)
+	}
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to end session: %v (SHD_LGO_081)", err)
+		logger.Error("failed to end session", "error", err, "session_id", ApiUtils.MaskToken(session_id))
+		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+			ActivityName: ApiTypes.ActivityName_Auth,
+			ActivityType: ApiTypes.ActivityType_DatabaseError,
+			AppName:      ApiTypes.AppName_Auth,
+			ModuleName:   ApiTypes.ModuleName_EmailAuth,
+			ActivityMsg:  &error_msg,
+			CallerLoc:    "SHD_LGO_089"})
+		return http.StatusInternalServerError, map[string]string{
+			"status":  "error",
+			"message": "failed to end session",
+			"loc":     "SHD_LGO_093",
+		}
+	}
+
+	expires_at := session.ExpiresAt.Format("2006-01-02 15:04:05")
+	user_email := session.UserEmail
+	sysdatastores.AddSessionLog(sysdatastores.SessionLogDef{
+		LoginMethod:  session.LoginMethod,
+		SessionID:    session_id,
+		Status:       sysdatastores.SessionStatusLoggedOut,
+		UserName:     session.UserName,
+		UserNameType: session.UserNameType,
+		UserRegID:    session.UserRegID,
+		UserEmail:    &user_email,
+		CallerLoc:    "SHD_LGO_106",
+		ExpiresAt:    &expires_at,
+	})
+
+	msg := fmt.Sprintf("user logged out, email:%s, session_id:%s, login_method:%s, logout_time:%s",
+		session.UserEmail, ApiUtils.MaskToken(session_id), session.LoginMethod,
+		time.Now().Format(time.RFC3339))
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: ApiTypes.ActivityType_UserLogout,
+		AppName:      ApiTypes.AppName_Auth,
+		ModuleName:   ApiTypes.ModuleName_EmailAuth,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_LGO_118"})
+
+	logger.Info("Logout success",
+		"email", session.UserEmail,
+		"session_id", ApiUtils.MaskToken(session_id))
+	return http.StatusOK, logged_out
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+func TestHandleLogoutBaseEndsSession(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	saved_db, saved_type := ApiTypes.SharedDBHandle, ApiTypes.DBType
+	saved_table := ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions
+	ApiTypes.SharedDBHandle, ApiTypes.DBType = db, ApiTypes.PgName
+	ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions = "login_sessions"
+	defer func() {
+		ApiTypes.SharedDBHandle, ApiTypes.DBType = saved_db, saved_type
+		ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions = saved_table
+	}()
+
+	rc := testharness.NewFakeRequestContext(t, nil)
+	rc.Cookies["session_id"] = "sess-1"
+
+	mock.ExpectQuery("SELECT session_id, login_method, status, user_name, user_name_type, user_reg_id, " +
+		"user_email, expires_at FROM login_sessions WHERE session_id = $1").
+		WithArgs("sess-1").
+		WillReturnRows(sqlmock.NewRows([]string{"session_id", "login_method", "status", "user_name",
+			"user_name_type", "user_reg_id", "user_email", "expires_at"}).
+			AddRow("sess-1", "email_login", "active", "alice", "email", "alice@example.com",
+				"alice@example.com", time.Now().Add(time.Hour)))
+	mock.ExpectExec("UPDATE login_sessions SET status = $1 WHERE session_id = $2 AND status = $3").
+		WithArgs(sysdatastores.SessionStatusInactive, "sess-1", sysdatastores.SessionStatusActive).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	status, resp := HandleLogoutBase(rc)
+	if status != http.StatusOK || resp["status"] != "ok" {
+		t.Fatalf("status = %d, resp = %v", status, resp)
+	}
+	if _, ok := rc.Cookies["session_id"]; ok {
+		t.Error("session cookie not cleared")
+	}
+
+	// The cookie presented again is rejected: the session is not active
+	mock.ExpectQuery("SELECT COUNT(*) FROM login_sessions WHERE session_id = $1 AND status = $2 AND expires_at > $3").
+		WithArgs("sess-1", sysdatastores.SessionStatusActive, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	valid, err := sysdatastores.IsValidSession(rc, "sess-1")
+	if err != nil || valid {
+		t.Errorf("IsValidSession = %v, %v; want false", valid, err)
+	}
+
+	// Without a session cookie there is nothing to end
+	if status, _ := HandleLogoutBase(rc); status != http.StatusOK {
+		t.Errorf("logout without session: status = %d", status)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
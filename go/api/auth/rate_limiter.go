@@ -158,7 +158,9 @@ var (
 	// Even if an attacker uses multiple IPs, they can only attempt a limited number
 	// of logins per account.
 	accountLockoutRateLimiter *RateLimiter
-	rateLimiterOnce           sync.Once
+	// Per-user limiter for TOTP / recovery code attempts
+	twoFactorRateLimiter *RateLimiter
+	rateLimiterOnce      sync.Once
 )
 
 // initRateLimiters initializes the global rate limiters
@@ -181,6 +183,9 @@ func initRateLimiters() {
 			BlockDuration:  30 * time.Minute, // lock account for 30 minutes
 			KeyFunc:        defaultKeyFunc,   // Not used for account lockout (uses email directly)
 		})
+		// 5 code attempts per 15 minutes per user; a 6-digit code cannot be
+		// brute-forced at this rate.
+		twoFactorRateLimiter = NewRateLimiter(DefaultRateLimitConfig())
 	})
 }
 
@@ -278,3 +283,16 @@ func ResetLoginRateLimits(ip string, email string) {
 	ResetLoginRateLimit(ip)
 	ResetAccountLockout(email)
 }
+
+// CheckTwoFactorRateLimit checks if a 2FA code attempt for a user is allowed
+// Returns (allowed, remainingAttempts, retryAfterDuration)
+func CheckTwoFactorRateLimit(user_id string) (bool, int, time.Duration) {
+	initRateLimiters()
+	return twoFactorRateLimiter.Allow(user_id)
+}
+
+// ResetTwoFactorRateLimit resets the 2FA rate limit after a successful code
+func ResetTwoFactorRateLimit(user_id string) {
+	initRateLimiters()
+	twoFactorRateLimiter.Reset(user_id)
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+// getHomeURL returns the URL the auth flows fall back to: the HomeURL set
+// by SetAuthInfo, or APP_BASE_URL.
+func getHomeURL() string {
+	if AuthInfo1.HomeURL != "" {
+		return AuthInfo1.HomeURL
+	}
+	return os.Getenv("APP_BASE_URL")
+}
+
+// isAllowedRedirectHost reports whether 'host' (host[:port]) is the host of
+// the home URL or is in auth.allowed_redirect_hosts. Entries without a
+// port match the host on any port.
+func isAllowedRedirectHost(host string, home_url string) bool {
+	if home, err := url.Parse(home_url); err == nil && home.Host != "" &&
+		strings.EqualFold(home.Host, host) {
+		return true
+	}
+
+	hostname := host
+	if u, err := url.Parse("//" + host); err == nil {
+		hostname = u.Hostname()
+	}
+	for _, allowed := range ApiTypes.CommonConfig.Auth.AllowedRedirectHosts {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "" {
+			continue
+		}
+		if strings.EqualFold(allowed, host) || strings.EqualFold(allowed, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// SafeRedirect returns 'candidate' if it is safe to redirect to after login,
+// verification or password reset, otherwise the home URL. A candidate is
+// safe if it is a relative path (resolved against the home URL) or an
+// http(s) URL on the home host or a host in auth.allowed_redirect_hosts.
+// Rejected candidates are logged, as they may be open-redirect attempts.
+func SafeRedirect(
+	rc ApiTypes.RequestContext,
+	candidate string) string {
+	home_url := getHomeURL()
+	if candidate == "" {
+		return home_url
+	}
+
+	if ApiUtils.IsSafeReturnURL(candidate) {
+		return strings.TrimRight(home_url, "/") + candidate
+	}
+
+	lower := strings.ToLower(candidate)
+	if !strings.Contains(lower, "javascript:") && !strings.Contains(lower, "data:") &&
+		!strings.Contains(candidate, "\\") {
+		parsed, err := url.Parse(candidate)
+		if err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") &&
+			parsed.Host != "" && parsed.User == nil &&
+			isAllowedRedirectHost(parsed.Host, home_url) {
+			return candidate
+		}
+	}
+
+	logger := rc.GetLogger()
+	logger.Warn("redirect rejected, not in allowed redirect hosts",
+		"redirect", candidate,
+		"default_to", home_url,
+		"loc", "SHD_RDR_071")
+
+	msg := fmt.Sprintf("redirect rejected:%s, default to:%s (SHD_RDR_071)", candidate, home_url)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: ApiTypes.ActivityType_Redirect,
+		AppName:      ApiTypes.AppName_Auth,
+		ModuleName:   ApiTypes.ModuleName_EmailAuth,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_RDR_078"})
+	return home_url
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+func TestSafeRedirect(t *testing.T) {
+	saved_home, saved_hosts := AuthInfo1.HomeURL, ApiTypes.CommonConfig.Auth.AllowedRedirectHosts
+	t.Cleanup(func() {
+		AuthInfo1.HomeURL = saved_home
+		ApiTypes.CommonConfig.Auth.AllowedRedirectHosts = saved_hosts
+	})
+	AuthInfo1.HomeURL = "https://app.example.com"
+	ApiTypes.CommonConfig.Auth.AllowedRedirectHosts = []string{"docs.example.com", "localhost:5173"}
+
+	rc := testharness.NewFakeRequestContext(t, nil)
+	home := "https://app.example.com"
+	for _, tt := range []struct {
+		candidate string
+		want      string
+	}{
+		{"", home},
+		{"/dashboard?tab=1", home + "/dashboard?tab=1"},
+		{"https://app.example.com/settings", "https://app.example.com/settings"},
+		{"https://docs.example.com/intro", "https://docs.example.com/intro"},
+		{"https://DOCS.example.com:8443/intro", "https://DOCS.example.com:8443/intro"},
+		{"http://localhost:5173/login", "http://localhost:5173/login"},
+		{"http://localhost:8080/login", home},
+		{"https://evil.com/", home},
+		{"//evil.com/", home},
+		{"/\\evil.com", home},
+		{"https://app.example.com.evil.com/", home},
+		{"https://app.example.com@evil.com/", home},
+		{"javascript:alert(1)", home},
+		{"ftp://docs.example.com/", home},
+	} {
+		if got := SafeRedirect(rc, tt.candidate); got != tt.want {
+			t.Errorf("SafeRedirect(%q) = %q, want %q", tt.candidate, got, tt.want)
+		}
+	}
+}
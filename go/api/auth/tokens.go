@@ -1,11 +1,14 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"sync"
 	"time"
 
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -142,3 +145,36 @@ func ParseToken(tokenStr string) (jwt.MapClaims, error) {
 
 	return nil, fmt.Errorf("invalid token")
 }
+
+// maxTokenAttempts bounds the tokens issueToken tries. Random 256-bit
+// tokens don't collide unless the random source is broken, so a conflict
+// on every attempt is reported rather than retried further.
+const maxTokenAttempts = 3
+
+// newUserToken returns a new email verification or password reset token.
+// Tests replace it to force collisions.
+var newUserToken = func() string {
+	return ApiUtils.GenerateSecureToken(32)
+}
+
+// issueToken saves a new token with 'save', which stores it as the user's
+// v_token and so replaces the previous token, and returns it. A token
+// that is already another user's (ApiTypes.ErrTokenConflict) is replaced
+// with a new one; other errors are returned as they are.
+func issueToken(rc ApiTypes.RequestContext, save func(token string) error) (string, error) {
+	for attempt := 1; attempt <= maxTokenAttempts; attempt++ {
+		token := newUserToken()
+		err := save(token)
+		if err == nil {
+			return token, nil
+		}
+		if !errors.Is(err, ApiTypes.ErrTokenConflict) {
+			return "", err
+		}
+		rc.GetLogger().Warn("token already in use, issuing another",
+			"token", ApiUtils.MaskToken(token),
+			"attempt", attempt)
+	}
+	return "", fmt.Errorf("no unused token after %d attempts (SHD_TOK_105): %w",
+		maxTokenAttempts, ApiTypes.ErrTokenConflict)
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+// useTokens makes newUserToken return 'tokens' in turn, repeating the
+// last one
+func useTokens(t *testing.T, tokens ...string) {
+	saved := newUserToken
+	t.Cleanup(func() { newUserToken = saved })
+	newUserToken = func() string {
+		token := tokens[0]
+		if len(tokens) > 1 {
+			tokens = tokens[1:]
+		}
+		return token
+	}
+}
+
+func TestIssueTokenRetriesOnConflict(t *testing.T) {
+	t.Setenv("APP_BASE_URL", "https://app.example.com")
+	rc := newAdminRC(t, testAdmin)
+	rc.Users["bob@example.com"].VToken = "old"
+	rc.Users["carol@example.com"] = &ApiTypes.UserInfo{
+		UserId: "u3", Email: "carol@example.com", VToken: "taken"}
+
+	// The first token collides with carol's, the second replaces bob's
+	useTokens(t, "taken", "fresh")
+	rc.SetJSONBody(t, map[string]string{"user_id": "u2", "mode": AdminResetModeToken})
+	status, resp := AdminResetPassword(rc)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d (resp %v)", status, resp.Body)
+	}
+	if url, _ := resp.Body.(map[string]interface{})["reset_url"].(string); !strings.HasSuffix(url, "token=fresh") {
+		t.Errorf("reset_url = %q, want the second token", url)
+	}
+	if got := rc.Users["bob@example.com"].VToken; got != "fresh" {
+		t.Errorf("bob's token = %q, want fresh", got)
+	}
+	if got := rc.Users["carol@example.com"].VToken; got != "taken" {
+		t.Errorf("carol's token = %q, want it unchanged", got)
+	}
+
+	// Conflicts on every attempt are reported
+	useTokens(t, "taken")
+	save := func(token string) error { return rc.UpdateTokenByEmail("bob@example.com", token) }
+	if _, err := issueToken(rc, save); !errors.Is(err, ApiTypes.ErrTokenConflict) {
+		t.Errorf("err = %v, want ErrTokenConflict", err)
+	}
+	if got := rc.Users["bob@example.com"].VToken; got != "fresh" {
+		t.Errorf("bob's token = %q after failed attempts", got)
+	}
+
+	// Other errors are not retried
+	calls := 0
+	_, err := issueToken(rc, func(string) error {
+		calls++
+		return errors.New("database down")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("err = %v after %d calls, want the error after one", err, calls)
+	}
+}
+
+func TestNewUserToken(t *testing.T) {
+	token := newUserToken()
+	if len(token) != 64 || strings.Trim(token, "0123456789abcdef") != "" {
+		t.Errorf("token = %q, want 64 hex characters", token)
+	}
+	if newUserToken() == token {
+		t.Error("tokens repeat")
+	}
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test secret (SHA1)
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeMatchesRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last 6 digits.
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		got, err := totpCode(rfcSecret, totpStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != want {
+			t.Errorf("time %d: got %s want %s", unix, got, want)
+		}
+	}
+}
+
+func TestMatchTOTPCodeAllowsOneStepOfSkew(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	prev, _ := totpCode(rfcSecret, totpStep(now)-1)
+	next, _ := totpCode(rfcSecret, totpStep(now)+1)
+	too_old, _ := totpCode(rfcSecret, totpStep(now)-2)
+
+	if _, ok := MatchTOTPCode(rfcSecret, prev, now, 0); !ok {
+		t.Error("code from previous step rejected")
+	}
+	if _, ok := MatchTOTPCode(rfcSecret, next, now, 0); !ok {
+		t.Error("code from next step rejected")
+	}
+	if _, ok := MatchTOTPCode(rfcSecret, too_old, now, 0); ok {
+		t.Error("code from two steps ago accepted")
+	}
+}
+
+func TestMatchTOTPCodeRejectsReplay(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	code, _ := totpCode(rfcSecret, totpStep(now))
+
+	step, ok := MatchTOTPCode(rfcSecret, code, now, 0)
+	if !ok {
+		t.Fatal("valid code rejected")
+	}
+	if _, ok := MatchTOTPCode(rfcSecret, code, now, step); ok {
+		t.Fatal("replayed code accepted")
+	}
+}
+
+func TestRecoveryCodesHashNormalized(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("unexpected count: %d codes, %d hashes", len(codes), len(hashes))
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if seen[code] {
+			t.Fatalf("duplicate code %s", code)
+		}
+		seen[code] = true
+
+		typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+		if HashRecoveryCode(typed) != hashes[i] {
+			t.Errorf("hash of %q does not match %q", typed, code)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("My App", "a@b.com", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/My%20App:a@b.com?") ||
+		!strings.Contains(uri, "secret=ABC") || !strings.Contains(uri, "issuer=My+App") {
+		t.Fatalf("unexpected uri: %s", uri)
+	}
+}
+
+func TestTwoFactorChallengeLimitsAttempts(t *testing.T) {
+	cc := &twoFactorChallengeCache{
+		entries:     make(map[string]*twoFactorChallenge),
+		ttl:         time.Minute,
+		maxAttempts: 2,
+		maxSize:     10,
+	}
+	token := cc.add("u1", "a@b.com", "1.2.3.4")
+
+	for i := 0; i < 2; i++ {
+		if c, ok := cc.attempt(token); !ok || c.userID != "u1" {
+			t.Fatalf("attempt %d rejected", i+1)
+		}
+	}
+	if _, ok := cc.attempt(token); ok {
+		t.Fatal("attempt beyond maxAttempts accepted")
+	}
+
+	token = cc.add("u1", "a@b.com", "1.2.3.4")
+	cc.consume(token)
+	if _, ok := cc.attempt(token); ok {
+		t.Fatal("consumed challenge accepted")
+	}
+}
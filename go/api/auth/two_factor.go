@@ -0,0 +1,430 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/security"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+// TOTP two-factor authentication for email-login accounts (non-Kratos).
+//
+//   - POST /auth/2fa/setup    (authenticated) new secret + recovery codes
+//   - POST /auth/2fa/confirm  (authenticated) first code, turns 2FA on
+//   - POST /auth/2fa/verify   challenge token + code -> session
+//   - POST /auth/2fa/reset    (admin) turns 2FA off for a user
+//
+// When 2FA is on, email login answers CustomHttpStatus_TwoFactorRequired
+// with a short-lived challenge token instead of creating a session.
+
+// twoFactorKeyEnvVar holds the base64 AES-256 key the TOTP secrets are
+// encrypted with.
+const twoFactorKeyEnvVar = "TWO_FACTOR_ENCRYPTION_KEY"
+
+var (
+	twoFactorKey     []byte
+	twoFactorKeyOnce sync.Once
+	twoFactorKeyErr  error
+)
+
+func getTwoFactorKey() ([]byte, error) {
+	twoFactorKeyOnce.Do(func() {
+		twoFactorKey, twoFactorKeyErr = security.LoadKeyFromEnv(twoFactorKeyEnvVar)
+	})
+	return twoFactorKey, twoFactorKeyErr
+}
+
+// twoFactorChallenge is a login that passed the password check and waits
+// for a 2FA code.
+type twoFactorChallenge struct {
+	userID    string
+	email     string
+	clientIP  string
+	expiresAt time.Time
+	attempts  int
+}
+
+// twoFactorChallengeCache stores pending challenges, like nonceCache does
+// for OAuth state.
+type twoFactorChallengeCache struct {
+	mu          sync.Mutex
+	entries     map[string]*twoFactorChallenge
+	ttl         time.Duration
+	maxAttempts int
+	maxSize     int
+}
+
+var twoFactorChallenges = &twoFactorChallengeCache{
+	entries:     make(map[string]*twoFactorChallenge),
+	ttl:         5 * time.Minute,
+	maxAttempts: 5,
+	maxSize:     100000,
+}
+
+// add stores a new challenge and returns its token. It returns "" if the
+// cache is full (DoS protection).
+func (cc *twoFactorChallengeCache) add(user_id string, email string, clientIP string) string {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		// FAIL SECURE: same policy as GenerateOAuthNonce
+		panic("crypto/rand.Read failed: " + err.Error())
+	}
+	token := base64.URLEncoding.EncodeToString(bytes)
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	now := time.Now()
+	for key, entry := range cc.entries {
+		if now.After(entry.expiresAt) {
+			delete(cc.entries, key)
+		}
+	}
+	if len(cc.entries) >= cc.maxSize {
+		return ""
+	}
+
+	cc.entries[token] = &twoFactorChallenge{
+		userID:    user_id,
+		email:     email,
+		clientIP:  clientIP,
+		expiresAt: now.Add(cc.ttl),
+	}
+	return token
+}
+
+// attempt returns a copy of the challenge and counts one code attempt
+// against it. A challenge is dropped once it expires or runs out of
+// attempts.
+func (cc *twoFactorChallengeCache) attempt(token string) (twoFactorChallenge, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	entry, ok := cc.entries[token]
+	if !ok {
+		return twoFactorChallenge{}, false
+	}
+	if time.Now().After(entry.expiresAt) || entry.attempts >= cc.maxAttempts {
+		delete(cc.entries, token)
+		return twoFactorChallenge{}, false
+	}
+	entry.attempts++
+	return *entry, true
+}
+
+// consume removes a challenge after it was used successfully
+func (cc *twoFactorChallengeCache) consume(token string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	delete(cc.entries, token)
+}
+
+// startTwoFactorChallenge is called by HandleEmailLoginBase when the
+// password is correct but the user has 2FA enabled.
+func startTwoFactorChallenge(
+	rc ApiTypes.RequestContext,
+	user_info *ApiTypes.UserInfo,
+	email string,
+	clientIP string) (int, map[string]string) {
+	logger := rc.GetLogger()
+	token := twoFactorChallenges.add(user_info.UserId, email, clientIP)
+	if token == "" {
+		logger.Error("two-factor challenge cache full", "email", email)
+		return http.StatusServiceUnavailable, map[string]string{
+			"status":  "error",
+			"message": "Login temporarily unavailable. Please try again later.",
+			"loc":     "SHD_2FA_152",
+		}
+	}
+
+	logger.Info("two-factor challenge issued", "email", email)
+	return ApiTypes.CustomHttpStatus_TwoFactorRequired, map[string]string{
+		"status":          "2fa_required",
+		"message":         "Two-factor authentication required",
+		"challenge_token": token,
+		"redirect_url":    "/verify-2fa",
+		"loc":             "SHD_2FA_161",
+	}
+}
+
+type twoFactorCodeRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+// verifyTwoFactorCode checks a TOTP code or, if 'code' is not a 6-digit
+// number, a recovery code. Accepted codes are marked used.
+func verifyTwoFactorCode(
+	rc ApiTypes.RequestContext,
+	user_id string,
+	two_factor *sysdatastores.UserTwoFactorDef,
+	code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if !isTOTPCodeFormat(code) {
+		return sysdatastores.ConsumeTwoFactorRecoveryCode(rc, user_id, HashRecoveryCode(code))
+	}
+
+	key, err := getTwoFactorKey()
+	if err != nil {
+		return false, err
+	}
+	secret, err := security.DecryptString(two_factor.Secret, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to decrypt TOTP secret (SHD_2FA_184): %w", err)
+	}
+
+	step, ok := MatchTOTPCode(secret, code, time.Now(), two_factor.LastStep)
+	if !ok {
+		return false, nil
+	}
+	return sysdatastores.UseTwoFactorStep(rc, user_id, step)
+}
+
+func isTOTPCodeFormat(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func twoFactorError(status_code int, message string, loc string) (int, ApiTypes.ResponsePayload) {
+	return status_code, ApiTypes.JSONPayload(map[string]string{
+		"status":  "error",
+		"message": message,
+		"loc":     loc,
+	})
+}
+
+// TwoFactorSetup handles POST /auth/2fa/setup. It generates a new TOTP
+// secret and recovery codes for the logged-in user. 2FA is not enabled
+// until TwoFactorConfirm accepts a first code. The recovery codes are
+// only returned here; the server keeps their hashes.
+func TwoFactorSetup(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	logger := rc.GetLogger()
+	user_info := rc.IsAuthenticated()
+	if user_info == nil {
+		return twoFactorError(http.StatusUnauthorized, "Authentication required", "SHD_2FA_220")
+	}
+
+	two_factor, err := sysdatastores.GetUserTwoFactor(rc, user_info.UserId)
+	if err != nil {
+		return twoFactorError(http.StatusInternalServerError, "failed to read two-factor state", "SHD_2FA_225")
+	}
+	if two_factor.Enabled {
+		return twoFactorError(http.StatusConflict, "Two-factor authentication is already enabled", "SHD_2FA_228")
+	}
+
+	key, err := getTwoFactorKey()
+	if err != nil {
+		logger.Error("two-factor encryption key not configured", "error", err)
+		return twoFactorError(http.StatusInternalServerError,
+			"Two-factor authentication is not configured", "SHD_2FA_234")
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return twoFactorError(http.StatusInternalServerError, err.Error(), "SHD_2FA_239")
+	}
+	encrypted, err := security.EncryptString(secret, key)
+	if err != nil {
+		logger.Error("failed to encrypt TOTP secret", "error", err)
+		return twoFactorError(http.StatusInternalServerError, "failed to encrypt secret", "SHD_2FA_244")
+	}
+	codes, hashes, err := GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return twoFactorError(http.StatusInternalServerError, err.Error(), "SHD_2FA_248")
+	}
+
+	if err := sysdatastores.SetUserTwoFactorSecret(rc, user_info.UserId, encrypted, hashes); err != nil {
+		return twoFactorError(http.StatusInternalServerError, "failed to save two-factor secret", "SHD_2FA_252")
+	}
+
+	issuer := ApiTypes.CommonConfig.AppInfo.AppName
+	if issuer == "" {
+		issuer = "Shared"
+	}
+
+	logger.Info("two-factor setup started", "user_id", user_info.UserId)
+	return http.StatusOK, ApiTypes.JSONPayload(map[string]interface{}{
+		"status":         "ok",
+		"otpauth_uri":    TOTPURI(issuer, user_info.Email, secret),
+		"secret":         secret,
+		"recovery_codes": codes,
+		"loc":            "SHD_2FA_265",
+	})
+}
+
+// TwoFactorConfirm handles POST /auth/2fa/confirm {"code": "123456"}. It
+// turns 2FA on once the user proves the authenticator app is set up.
+func TwoFactorConfirm(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	logger := rc.GetLogger()
+	user_info := rc.IsAuthenticated()
+	if user_info == nil {
+		return twoFactorError(http.StatusUnauthorized, "Authentication required", "SHD_2FA_275")
+	}
+
+	var req twoFactorCodeRequest
+	if err := rc.Bind(&req); err != nil || !isTOTPCodeFormat(strings.TrimSpace(req.Code)) {
+		return twoFactorError(http.StatusBadRequest, "a 6-digit code is required", "SHD_2FA_280")
+	}
+
+	if allowed, _, _ := CheckTwoFactorRateLimit(user_info.UserId); !allowed {
+		return twoFactorError(http.StatusTooManyRequests,
+			"Too many attempts. Please try again later.", "SHD_2FA_285")
+	}
+
+	two_factor, err := sysdatastores.GetUserTwoFactor(rc, user_info.UserId)
+	if err != nil {
+		return twoFactorError(http.StatusInternalServerError, "failed to read two-factor state", "SHD_2FA_290")
+	}
+	if two_factor.Enabled {
+		return twoFactorError(http.StatusConflict, "Two-factor authentication is already enabled", "SHD_2FA_293")
+	}
+	if two_factor.Secret == "" {
+		return twoFactorError(http.StatusBadRequest, "call /auth/2fa/setup first", "SHD_2FA_296")
+	}
+
+	ok, err := verifyTwoFactorCode(rc, user_info.UserId, two_factor, req.Code)
+	if err != nil {
+		logger.Error("failed to verify two-factor code", "error", err)
+		return twoFactorError(http.StatusInternalServerError, "failed to verify code", "SHD_2FA_302")
+	}
+	if !ok {
+		return twoFactorError(http.StatusUnauthorized, "invalid code", "SHD_2FA_305")
+	}
+
+	if err := sysdatastores.EnableUserTwoFactor(rc, user_info.UserId); err != nil {
+		return twoFactorError(http.StatusInternalServerError, "failed to enable two-factor", "SHD_2FA_309")
+	}
+	ResetTwoFactorRateLimit(user_info.UserId)
+
+	msg := fmt.Sprintf("two-factor enabled, user_id:%s, email:%s", user_info.UserId, user_info.Email)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: ApiTypes.ActivityType_TwoFactorEnabled,
+		AppName:      ApiTypes.AppName_Auth,
+		ModuleName:   ApiTypes.ModuleName_TwoFactor,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_2FA_320"})
+
+	return http.StatusOK, ApiTypes.JSONPayload(map[string]string{
+		"status": "ok",
+		"loc":    "SHD_2FA_324",
+	})
+}
+
+// TwoFactorVerify handles POST /auth/2fa/verify
+// {"challenge_token": "...", "code": "123456" or a recovery code}.
+// On success it creates the session, like a password-only email login.
+func TwoFactorVerify(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	logger := rc.GetLogger()
+
+	// SECURITY: Validate request origin to prevent CSRF attacks
+	if !IsSafeOriginRequest(rc.GetRequest()) {
+		return twoFactorError(http.StatusForbidden, "Invalid request origin", "SHD_2FA_336")
+	}
+
+	var req twoFactorCodeRequest
+	if err := rc.Bind(&req); err != nil || req.ChallengeToken == "" || strings.TrimSpace(req.Code) == "" {
+		return twoFactorError(http.StatusBadRequest, "challenge_token and code are required", "SHD_2FA_341")
+	}
+
+	challenge, ok := twoFactorChallenges.attempt(req.ChallengeToken)
+	if !ok {
+		return twoFactorError(http.StatusUnauthorized,
+			"Invalid or expired challenge. Please log in again.", "SHD_2FA_347")
+	}
+
+	if allowed, _, _ := CheckTwoFactorRateLimit(challenge.userID); !allowed {
+		logger.Warn("two-factor rate limit exceeded", "email", challenge.email)
+		return twoFactorError(http.StatusTooManyRequests,
+			"Too many attempts. Please try again later.", "SHD_2FA_353")
+	}
+
+	user_info, err := sysdatastores.GetUserInfoByUserID(rc, challenge.userID, sysdatastores.ExcludeDisabledUsers)
+	if err != nil || user_info == nil {
+		twoFactorChallenges.consume(req.ChallengeToken)
+		return twoFactorError(http.StatusUnauthorized,
+			"Invalid or expired challenge. Please log in again.", "SHD_2FA_360")
+	}
+
+	two_factor, err := sysdatastores.GetUserTwoFactor(rc, challenge.userID)
+	if err != nil {
+		return twoFactorError(http.StatusInternalServerError, "failed to read two-factor state", "SHD_2FA_365")
+	}
+
+	ok, err = verifyTwoFactorCode(rc, challenge.userID, two_factor, req.Code)
+	if err != nil {
+		logger.Error("failed to verify two-factor code", "error", err)
+		return twoFactorError(http.StatusInternalServerError, "failed to verify code", "SHD_2FA_371")
+	}
+	if !ok {
+		msg := fmt.Sprintf("invalid two-factor code, email:%s", challenge.email)
+		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+			ActivityName: ApiTypes.ActivityName_Auth,
+			ActivityType: ApiTypes.ActivityType_TwoFactorFailure,
+			AppName:      ApiTypes.AppName_Auth,
+			ModuleName:   ApiTypes.ModuleName_TwoFactor,
+			ActivityMsg:  &msg,
+			CallerLoc:    "SHD_2FA_381"})
+		return twoFactorError(http.StatusUnauthorized, "invalid code", "SHD_2FA_382")
+	}
+
+	twoFactorChallenges.consume(req.ChallengeToken)
+	ResetTwoFactorRateLimit(challenge.userID)
+
+	status_code, resp := finishEmailLogin(rc, user_info, challenge.email, challenge.clientIP)
+	return status_code, ApiTypes.JSONPayload(resp)
+}
+
+// TwoFactorReset handles POST /auth/2fa/reset {"user_id": "..."}. Admins
+// use it for users who lost both their device and recovery codes.
+func TwoFactorReset(rc ApiTypes.RequestContext) (int, ApiTypes.ResponsePayload) {
+	admin_info := rc.IsAuthenticated()
+	if admin_info == nil {
+		return twoFactorError(http.StatusUnauthorized, "Authentication required", "SHD_2FA_397")
+	}
+	if !admin_info.Admin {
+		return twoFactorError(http.StatusForbidden, "Admin access required", "SHD_2FA_400")
+	}
+
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := rc.Bind(&req); err != nil || req.UserID == "" {
+		return twoFactorError(http.StatusBadRequest, "user_id is required", "SHD_2FA_407")
+	}
+
+	found, err := sysdatastores.ResetUserTwoFactor(rc, req.UserID)
+	if err != nil {
+		return twoFactorError(http.StatusInternalServerError, "failed to reset two-factor", "SHD_2FA_412")
+	}
+	if !found {
+		return twoFactorError(http.StatusNotFound, "User not found", "SHD_2FA_415")
+	}
+	ResetTwoFactorRateLimit(req.UserID)
+
+	msg := fmt.Sprintf("two-factor reset, user_id:%s, by:%s", req.UserID, admin_info.Email)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: ApiTypes.ActivityType_TwoFactorReset,
+		AppName:      ApiTypes.AppName_Auth,
+		ModuleName:   ApiTypes.ModuleName_TwoFactor,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_2FA_426"})
+
+	return http.StatusOK, ApiTypes.JSONPayload(map[string]string{
+		"status": "ok",
+		"loc":    "SHD_2FA_430",
+	})
+}
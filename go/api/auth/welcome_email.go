@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+// Built-in welcome email, used unless the config overrides it
+const (
+	defaultWelcomeSubject = "Welcome to {{.AppName}}"
+
+	defaultWelcomeText = `Hi {{.Name}},
+
+Your email is verified and your {{.AppName}} account is ready.
+{{if .GettingStartedURL}}
+Get started here:
+{{.GettingStartedURL}}
+{{end}}`
+
+	defaultWelcomeHTML = `
+        <p>Hi {{.Name}},</p>
+        <p>Your email is verified and your {{.AppName}} account is ready.</p>
+        {{if .GettingStartedURL}}<p><a href="{{.GettingStartedURL}}">Get started</a></p>{{end}}`
+)
+
+// WelcomeEmailData is what the welcome email templates are executed with
+type WelcomeEmailData struct {
+	Name              string
+	Email             string
+	AppName           string
+	GettingStartedURL string
+}
+
+// WelcomeEmailConfig configures the welcome email sent when a user
+// verifies their email for the first time
+type WelcomeEmailConfig struct {
+	Enabled           bool
+	AppName           string
+	GettingStartedURL string
+	Subject           *texttemplate.Template
+	Text              *texttemplate.Template
+	HTML              *htmltemplate.Template
+}
+
+// LoadWelcomeEmailConfig builds the config from the [welcome_email]
+// section of LibConfig. The getting-started link defaults to
+// APP_BASE_URL. An enabled config without an app name, with a link that
+// is not an absolute http(s) URL or with a template that doesn't parse is
+// an error.
+func LoadWelcomeEmailConfig() (WelcomeEmailConfig, error) {
+	conf := ApiTypes.LibConfig.WelcomeEmailConf
+	cfg := WelcomeEmailConfig{
+		Enabled:           conf.EnableWelcomeEmail == "enabled",
+		AppName:           strings.TrimSpace(conf.AppName),
+		GettingStartedURL: strings.TrimSpace(conf.GettingStartedURL),
+	}
+	if !cfg.Enabled {
+		return cfg, nil
+	}
+
+	if cfg.AppName == "" {
+		return cfg, fmt.Errorf("welcome email enabled but app_name not set (SHD_WEL_071)")
+	}
+	if cfg.GettingStartedURL == "" {
+		cfg.GettingStartedURL = os.Getenv("APP_BASE_URL")
+	}
+	if cfg.GettingStartedURL != "" {
+		u, err := url.Parse(cfg.GettingStartedURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("invalid welcome email getting_started_url %q (SHD_WEL_078)",
+				cfg.GettingStartedURL)
+		}
+	}
+
+	var err error
+	if cfg.Subject, err = texttemplate.New("subject").Parse(orDefault(conf.Subject, defaultWelcomeSubject)); err != nil {
+		return cfg, fmt.Errorf("invalid welcome email subject (SHD_WEL_085): %w", err)
+	}
+	if cfg.Text, err = texttemplate.New("text").Parse(orDefault(conf.TextTemplate, defaultWelcomeText)); err != nil {
+		return cfg, fmt.Errorf("invalid welcome email text_template (SHD_WEL_088): %w", err)
+	}
+	if cfg.HTML, err = htmltemplate.New("html").Parse(orDefault(conf.HTMLTemplate, defaultWelcomeHTML)); err != nil {
+		return cfg, fmt.Errorf("invalid welcome email html_template (SHD_WEL_091): %w", err)
+	}
+	return cfg, nil
+}
+
+func orDefault(value string, default_value string) string {
+	if strings.TrimSpace(value) == "" {
+		return default_value
+	}
+	return value
+}
+
+var (
+	welcomeEmailMu      sync.RWMutex
+	welcomeEmailConfig  *WelcomeEmailConfig
+	welcomeEmailLoadErr error
+)
+
+// SetWelcomeEmailConfig replaces the welcome email configuration. Without
+// it, LoadWelcomeEmailConfig is used on first use.
+func SetWelcomeEmailConfig(cfg WelcomeEmailConfig) {
+	welcomeEmailMu.Lock()
+	defer welcomeEmailMu.Unlock()
+	welcomeEmailConfig = &cfg
+	welcomeEmailLoadErr = nil
+}
+
+func getWelcomeEmailConfig() (WelcomeEmailConfig, error) {
+	welcomeEmailMu.RLock()
+	if welcomeEmailConfig != nil {
+		defer welcomeEmailMu.RUnlock()
+		return *welcomeEmailConfig, welcomeEmailLoadErr
+	}
+	welcomeEmailMu.RUnlock()
+
+	welcomeEmailMu.Lock()
+	defer welcomeEmailMu.Unlock()
+	if welcomeEmailConfig == nil {
+		cfg, err := LoadWelcomeEmailConfig()
+		welcomeEmailConfig = &cfg
+		welcomeEmailLoadErr = err
+	}
+	return *welcomeEmailConfig, welcomeEmailLoadErr
+}
+
+// renderWelcomeEmail returns the subject, text and HTML bodies of the
+// welcome email to 'user_info'
+func renderWelcomeEmail(cfg WelcomeEmailConfig, user_info *ApiTypes.UserInfo) (string, string, string, error) {
+	name := strings.TrimSpace(user_info.FirstName + " " + user_info.LastName)
+	if name == "" {
+		name = user_info.UserName
+	}
+	data := WelcomeEmailData{
+		Name:              name,
+		Email:             user_info.Email,
+		AppName:           cfg.AppName,
+		GettingStartedURL: cfg.GettingStartedURL,
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := cfg.Subject.Execute(&subject, data); err != nil {
+		return "", "", "", fmt.Errorf("failed to render welcome email subject (SHD_WEL_151): %w", err)
+	}
+	if err := cfg.Text.Execute(&text, data); err != nil {
+		return "", "", "", fmt.Errorf("failed to render welcome email text (SHD_WEL_154): %w", err)
+	}
+	if err := cfg.HTML.Execute(&html, data); err != nil {
+		return "", "", "", fmt.Errorf("failed to render welcome email html (SHD_WEL_157): %w", err)
+	}
+	// A header can't span lines
+	return strings.Join(strings.Fields(subject.String()), " "), text.String(), html.String(), nil
+}
+
+// welcomeEmail is a rendered welcome email. It copies what the send
+// needs from the request, so it can be sent after the request is done.
+type welcomeEmail struct {
+	to      string
+	subject string
+	text    string
+	html    string
+}
+
+// newWelcomeEmail renders the welcome email to a user who has just
+// verified their email for the first time. It returns nil if the
+// deployment doesn't send it.
+func newWelcomeEmail(logger ApiTypes.JimoLogger, user_info *ApiTypes.UserInfo) (*welcomeEmail, error) {
+	cfg, err := getWelcomeEmailConfig()
+	if err != nil {
+		logger.Error("invalid welcome email config", "error", err)
+		return nil, err
+	}
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	subject, text_body, html_body, err := renderWelcomeEmail(cfg, user_info)
+	if err != nil {
+		logger.Error("failed rendering welcome email", "error", err, "email", user_info.Email)
+		return nil, err
+	}
+	return &welcomeEmail{to: user_info.Email, subject: subject, text: text_body, html: html_body}, nil
+}
+
+// send sends the welcome email. 'rc' is a request context of the sender's
+// own, not the request's, which may be done by then. The caller makes
+// sure it is sent once per user.
+func (m *welcomeEmail) send(rc ApiTypes.RequestContext) error {
+	logger := rc.GetLogger()
+	log_id := sysdatastores.NextActivityLogID()
+	rc.PushCallFlow("SHD_WEL_184")
+	err := ApiUtils.SendMail(rc, m.to, m.subject, m.text, m.html, ApiUtils.EmailTypeWelcome)
+	rc.PopCallFlow()
+	if err != nil {
+		error_msg := fmt.Sprintf("failed sending welcome email to %s, error:%v, log_id:%d",
+			m.to, err, log_id)
+		logger.Error("failed sending welcome email", "error", err, "email", m.to, "log_id", log_id)
+		sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+			LogID:        log_id,
+			ActivityName: ApiTypes.ActivityName_Auth,
+			ActivityType: ApiTypes.ActivityType_Failed,
+			AppName:      ApiTypes.AppName_Auth,
+			ModuleName:   ApiTypes.ModuleName_EmailAuth,
+			ActivityMsg:  &error_msg,
+			CallerLoc:    "SHD_WEL_196"})
+		return fmt.Errorf("failed sending welcome email (SHD_WEL_197): %w", err)
+	}
+
+	msg := fmt.Sprintf("Sent welcome email to %s, log_id:%d", m.to, log_id)
+	logger.Info("Sent welcome email", "email", m.to, "log_id", log_id)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		LogID:        log_id,
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: ApiTypes.ActivityType_SentEmail,
+		AppName:      ApiTypes.AppName_Auth,
+		ModuleName:   ApiTypes.ModuleName_EmailAuth,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_WEL_209"})
+	return nil
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+func TestLoadWelcomeEmailConfig(t *testing.T) {
+	saved := ApiTypes.LibConfig.WelcomeEmailConf
+	t.Cleanup(func() { ApiTypes.LibConfig.WelcomeEmailConf = saved })
+	t.Setenv("APP_BASE_URL", "https://app.example.com")
+
+	tests := []struct {
+		name    string
+		conf    ApiTypes.WelcomeEmailConfig
+		enabled bool
+		wantURL string
+		wantErr string
+	}{
+		{name: "not configured"},
+		{name: "enabled", conf: ApiTypes.WelcomeEmailConfig{EnableWelcomeEmail: "enabled", AppName: "Jimo"},
+			enabled: true, wantURL: "https://app.example.com"},
+		{name: "getting started link",
+			conf: ApiTypes.WelcomeEmailConfig{EnableWelcomeEmail: "enabled", AppName: "Jimo",
+				GettingStartedURL: "https://docs.example.com/start"},
+			enabled: true, wantURL: "https://docs.example.com/start"},
+		{name: "no app name", conf: ApiTypes.WelcomeEmailConfig{EnableWelcomeEmail: "enabled"},
+			wantErr: "app_name not set"},
+		{name: "relative link",
+			conf:    ApiTypes.WelcomeEmailConfig{EnableWelcomeEmail: "enabled", AppName: "Jimo", GettingStartedURL: "/start"},
+			wantErr: "invalid welcome email getting_started_url"},
+		{name: "bad template",
+			conf:    ApiTypes.WelcomeEmailConfig{EnableWelcomeEmail: "enabled", AppName: "Jimo", TextTemplate: "{{.Name"},
+			wantErr: "invalid welcome email text_template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ApiTypes.LibConfig.WelcomeEmailConf = tt.conf
+			cfg, err := LoadWelcomeEmailConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Enabled != tt.enabled || cfg.GettingStartedURL != tt.wantURL {
+				t.Errorf("enabled = %v, link = %q, want %v, %q", cfg.Enabled, cfg.GettingStartedURL, tt.enabled, tt.wantURL)
+			}
+		})
+	}
+}
+
+type sentEmail struct {
+	to, subject, text, html, email_type string
+}
+
+// useWelcomeEmailConfig enables the welcome email and captures the emails
+// sent
+func useWelcomeEmailConfig(t *testing.T, conf ApiTypes.WelcomeEmailConfig) chan sentEmail {
+	t.Helper()
+	saved := ApiTypes.LibConfig.WelcomeEmailConf
+	ApiTypes.LibConfig.WelcomeEmailConf = conf
+	cfg, err := LoadWelcomeEmailConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetWelcomeEmailConfig(cfg)
+
+	sent := make(chan sentEmail, 4)
+	ApiUtils.SetEmailSender(func(_ ApiTypes.RequestContext, to, subject, text, html, email_type string) error {
+		sent <- sentEmail{to, subject, text, html, email_type}
+		return nil
+	})
+	t.Cleanup(func() {
+		ApiTypes.LibConfig.WelcomeEmailConf = saved
+		SetWelcomeEmailConfig(WelcomeEmailConfig{})
+		ApiUtils.SetEmailSender(nil)
+	})
+	return sent
+}
+
+func TestRenderWelcomeEmail(t *testing.T) {
+	useWelcomeEmailConfig(t, ApiTypes.WelcomeEmailConfig{
+		EnableWelcomeEmail: "enabled",
+		AppName:            "Jimo",
+		GettingStartedURL:  "https://app.example.com/start",
+		Subject:            "{{.AppName}}:\n welcome {{.Name}}",
+	})
+	cfg, _ := getWelcomeEmailConfig()
+
+	subject, text, html, err := renderWelcomeEmail(cfg, &ApiTypes.UserInfo{
+		UserName: "alice", FirstName: "Alice", LastName: "<b>Smith</b>", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Jimo: welcome Alice <b>Smith</b>" {
+		t.Errorf("subject = %q", subject)
+	}
+	if !strings.Contains(text, "Hi Alice <b>Smith</b>,") || !strings.Contains(text, "https://app.example.com/start") {
+		t.Errorf("text = %q", text)
+	}
+	if !strings.Contains(html, "Alice &lt;b&gt;Smith&lt;/b&gt;") || !strings.Contains(html, `href="https://app.example.com/start"`) {
+		t.Errorf("html = %q", html)
+	}
+
+	_, text, _, _ = renderWelcomeEmail(cfg, &ApiTypes.UserInfo{UserName: "bob"})
+	if !strings.Contains(text, "Hi bob,") {
+		t.Errorf("text without a name = %q", text)
+	}
+}
+
+// TestEmailVerifySendsWelcomeOnce checks that the welcome email is sent
+// on the first verification only
+func TestEmailVerifySendsWelcomeOnce(t *testing.T) {
+	sent := useWelcomeEmailConfig(t, ApiTypes.WelcomeEmailConfig{EnableWelcomeEmail: "enabled", AppName: "Jimo"})
+	t.Setenv("APP_BASE_URL", "")
+
+	rc := testharness.NewFakeRequestContext(t, nil)
+	rc.Users["alice@example.com"] = &ApiTypes.UserInfo{
+		UserId: "u1", UserName: "alice", Email: "alice@example.com", UserStatus: "pending", VToken: "vtoken"}
+	rc.Query["token"] = "vtoken"
+
+	if status, resp, err := HandleEmailVerifyBase(rc, false); status != http.StatusOK {
+		t.Fatalf("status = %d, %v (resp %v)", status, err, resp)
+	}
+	select {
+	case email := <-sent:
+		if email.to != "alice@example.com" || email.subject != "Welcome to Jimo" ||
+			email.email_type != ApiUtils.EmailTypeWelcome {
+			t.Errorf("sent %+v", email)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no welcome email sent")
+	}
+
+	// Opening the link again
+	if status, resp, err := HandleEmailVerifyBase(rc, false); status != http.StatusOK {
+		t.Fatalf("second verify: status = %d, %v (resp %v)", status, err, resp)
+	}
+	select {
+	case email := <-sent:
+		t.Errorf("second verify sent %+v", email)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// verifiedElsewhereRC is a request context whose user is verified by a
+// concurrent request between the lookup and the update
+type verifiedElsewhereRC struct {
+	*testharness.FakeRequestContext
+}
+
+func (r verifiedElsewhereRC) MarkUserVerified(email string) (bool, error) {
+	r.FakeRequestContext.MarkUserVerified(email)
+	return false, nil
+}
+
+// TestEmailVerifyConcurrentWelcome checks that of two requests verifying
+// the same user, only the one whose update verified it sends the email
+func TestEmailVerifyConcurrentWelcome(t *testing.T) {
+	sent := useWelcomeEmailConfig(t, ApiTypes.WelcomeEmailConfig{EnableWelcomeEmail: "enabled", AppName: "Jimo"})
+	t.Setenv("APP_BASE_URL", "")
+
+	rc := testharness.NewFakeRequestContext(t, nil)
+	rc.Users["alice@example.com"] = &ApiTypes.UserInfo{
+		UserId: "u1", UserName: "alice", Email: "alice@example.com", UserStatus: "pending", VToken: "vtoken"}
+	rc.Query["token"] = "vtoken"
+
+	if status, resp, err := HandleEmailVerifyBase(verifiedElsewhereRC{rc}, false); status != http.StatusOK {
+		t.Fatalf("status = %d, %v (resp %v)", status, err, resp)
+	}
+	select {
+	case email := <-sent:
+		t.Errorf("sent %+v for a user verified by another request", email)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,203 @@
+package databaseutil
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig configures the per-database circuit breakers.
+type BreakerConfig struct {
+	// FailureRate is the fraction of failed calls (0..1) within Window
+	// that opens the breaker
+	FailureRate float64
+	// MinRequests is the minimum number of calls within Window before
+	// FailureRate is evaluated
+	MinRequests int
+	// Window is the time window for counting calls and failures
+	Window time.Duration
+	// OpenDuration is how long the breaker stays open before letting a
+	// single probe call through (half-open)
+	OpenDuration time.Duration
+}
+
+// DefaultBreakerConfig returns the defaults used for database breakers
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		FailureRate:  0.5,              // open when half the calls fail
+		MinRequests:  20,               // after at least 20 calls
+		Window:       30 * time.Second, // per 30 seconds
+		OpenDuration: 15 * time.Second, // probe again after 15 seconds
+	}
+}
+
+// CircuitBreaker tracks the failure rate of calls to one database. Only
+// transient failures (see IsRetryableError) count as failures; a
+// constraint violation means the database is healthy.
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	name        string
+	config      BreakerConfig
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+	now         func() time.Time
+}
+
+// NewCircuitBreaker creates a closed breaker. 'name' is used as the
+// db.name metric attribute.
+func NewCircuitBreaker(name string, config BreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:   name,
+		config: config,
+		state:  BreakerClosed,
+		now:    time.Now,
+	}
+}
+
+// State returns the current state of the breaker
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Allow reports whether a call may proceed. While open it returns false
+// until OpenDuration has passed, then lets exactly one probe call through.
+// Every allowed call must be followed by Record.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		if cb.now().Sub(cb.openedAt) < cb.config.OpenDuration {
+			return false
+		}
+		cb.setState(BreakerHalfOpen)
+		cb.probing = true
+		return true
+
+	case BreakerHalfOpen:
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+		return true
+	}
+	return true
+}
+
+// Record reports the outcome of an allowed call
+func (cb *CircuitBreaker) Record(failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := cb.now()
+	switch cb.state {
+	case BreakerHalfOpen:
+		cb.probing = false
+		if failed {
+			cb.openedAt = now
+			cb.setState(BreakerOpen)
+			return
+		}
+		cb.resetWindow(now)
+		cb.setState(BreakerClosed)
+
+	case BreakerClosed:
+		if now.Sub(cb.windowStart) >= cb.config.Window {
+			cb.resetWindow(now)
+		}
+		cb.requests++
+		if failed {
+			cb.failures++
+		}
+
+		if cb.requests >= cb.config.MinRequests &&
+			float64(cb.failures) >= cb.config.FailureRate*float64(cb.requests) {
+			cb.openedAt = now
+			cb.setState(BreakerOpen)
+		}
+	}
+}
+
+func (cb *CircuitBreaker) resetWindow(now time.Time) {
+	cb.windowStart = now
+	cb.requests = 0
+	cb.failures = 0
+}
+
+// setState must be called with cb.mu held
+func (cb *CircuitBreaker) setState(state BreakerState) {
+	if cb.state == state {
+		return
+	}
+	recordBreakerTransition(cb.name, cb.state, state)
+	cb.state = state
+}
+
+var (
+	breakersMu    sync.Mutex
+	breakers      = make(map[*sql.DB]*CircuitBreaker)
+	breakerConfig = DefaultBreakerConfig()
+)
+
+// SetBreakerConfig replaces the breaker config. Existing breakers are
+// discarded, so it should be called during startup.
+func SetBreakerConfig(config BreakerConfig) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	breakerConfig = config
+	breakers = make(map[*sql.DB]*CircuitBreaker)
+}
+
+// GetBreaker returns the circuit breaker for the given database handle
+func GetBreaker(db *sql.DB) *CircuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	cb, ok := breakers[db]
+	if !ok {
+		cb = NewCircuitBreaker(dbName(db), breakerConfig)
+		breakers[db] = cb
+	}
+	return cb
+}
+
+func dbName(db *sql.DB) string {
+	switch db {
+	case ApiTypes.SharedDBHandle:
+		return "shared"
+	case ApiTypes.ProjectDBHandle:
+		return "project"
+	case ApiTypes.AutotesterDBHandle:
+		return "autotester"
+	}
+	return "other"
+}
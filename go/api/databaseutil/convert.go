@@ -0,0 +1,197 @@
+package databaseutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiUtils"
+)
+
+// ConvertValueByType converts a value scanned from the database to the Go
+// type that matches its field data type (e.g. []byte from MySQL to int for
+// "int" fields). Timestamps are returned as RFC3339 in UTC.
+func ConvertValueByType(value interface{}, dataType string) interface{} {
+	return ConvertValueInZone(value, dataType, nil)
+}
+
+// ConvertValueInZone is ConvertValueByType with timestamps returned in
+// 'loc' (UTC if nil).
+func ConvertValueInZone(value interface{}, dataType string, loc *time.Location) interface{} {
+	return ConvertValueWithFormat(value, dataType, loc, "")
+}
+
+// ConvertValueWithFormat is ConvertValueInZone with timestamps and dates
+// returned in 'format' (see ApiUtils.FormatTimestampAs). An empty format
+// is RFC3339 for timestamps and 2006-01-02 for dates. NULL and MySQL zero
+// dates are returned as nil.
+func ConvertValueWithFormat(value interface{}, dataType string, loc *time.Location, format string) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	switch dataType {
+	case "string", "varchar", "text", "char", "longtext", "mediumtext":
+		if val, ok := value.(string); ok {
+			return val
+		}
+		if val, ok := value.([]byte); ok {
+			return string(val)
+		}
+		return fmt.Sprintf("%v", value)
+
+	case "int", "integer", "bigint", "smallint", "tinyint":
+		if val, ok := value.([]byte); ok {
+			intVal, err := strconv.Atoi(string(val))
+			if err == nil {
+				return intVal
+			}
+		}
+		if val, ok := value.(int64); ok {
+			return int(val)
+		}
+		if val, ok := value.(int32); ok {
+			return int(val)
+		}
+		if val, ok := value.(int); ok {
+			return val
+		}
+		return value
+
+	case "float", "double", "decimal", "numeric":
+		if val, ok := value.([]byte); ok {
+			floatVal, err := strconv.ParseFloat(string(val), 64)
+			if err == nil {
+				return floatVal
+			}
+		}
+		if val, ok := value.(float64); ok {
+			return val
+		}
+		if val, ok := value.(float32); ok {
+			return float64(val)
+		}
+		return value
+
+	case "bool", "boolean":
+		if val, ok := value.([]byte); ok {
+			str := string(val)
+			boolVal, err := strconv.ParseBool(str)
+			if err == nil {
+				return boolVal
+			}
+			// Handle common boolean representations
+			return str == "1" || str == "true" || str == "TRUE" || str == "True"
+		}
+		if val, ok := value.(bool); ok {
+			return val
+		}
+		return value
+
+	case "datetime", "timestamp", "timestamptz":
+		// PG drivers scan timestamps as time.Time; MySQL without
+		// parseTime returns them as text in its own format. Text
+		// without an offset is from a naive column, i.e. UTC.
+		switch val := value.(type) {
+		case time.Time:
+			return ApiUtils.FormatTimestampAs(val, loc, format)
+		case []byte:
+			value = string(val)
+		}
+		if val, ok := value.(string); ok {
+			if isZeroDate(val) {
+				return nil
+			}
+			if t, err := ApiUtils.ParseTimestampInZone(val, time.UTC); err == nil {
+				return ApiUtils.FormatTimestampAs(t, loc, format)
+			}
+			return val
+		}
+		return fmt.Sprintf("%v", value)
+
+	case "date":
+		// A date has no zone; converting it would shift the day
+		switch val := value.(type) {
+		case time.Time:
+			if format == "" {
+				return val.Format("2006-01-02")
+			}
+			return ApiUtils.FormatTimestampAs(val, val.Location(), format)
+		case []byte:
+			value = string(val)
+		}
+		if val, ok := value.(string); ok {
+			if isZeroDate(val) {
+				return nil
+			}
+			if format != "" {
+				if t, err := time.Parse("2006-01-02", val); err == nil {
+					return ApiUtils.FormatTimestampAs(t, time.UTC, format)
+				}
+			}
+			return val
+		}
+		return fmt.Sprintf("%v", value)
+
+	case "time":
+		// For time of day, return as string
+		if val, ok := value.(string); ok {
+			return val
+		}
+		if val, ok := value.([]byte); ok {
+			return string(val)
+		}
+		return fmt.Sprintf("%v", value)
+
+	case "array":
+		// MySQL stores arrays in JSON columns (see FieldDef.ElementType);
+		// they are decoded to arrays. PG arrays are returned as text.
+		if val, ok := value.(string); ok {
+			value = []byte(val)
+		}
+		if val, ok := value.([]byte); ok {
+			if elements, ok := decodeJSONArray(val); ok {
+				return elements
+			}
+			return string(val)
+		}
+		return value
+
+	default:
+		// For unknown types or JSON, return as string or the original value
+		if val, ok := value.([]byte); ok {
+			return string(val)
+		}
+		return value
+	}
+}
+
+// decodeJSONArray decodes 'data' if it is a JSON array. Integral numbers
+// are returned as int64, other numbers as float64.
+func decodeJSONArray(data []byte) ([]interface{}, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var elements []interface{}
+	if err := decoder.Decode(&elements); err != nil || elements == nil {
+		return nil, false
+	}
+	for i, element := range elements {
+		if num, ok := element.(json.Number); ok {
+			if n, err := num.Int64(); err == nil {
+				elements[i] = n
+			} else if f, err := num.Float64(); err == nil {
+				elements[i] = f
+			}
+		}
+	}
+	return elements, true
+}
+
+// isZeroDate tells MySQL's zero DATE/DATETIME ("0000-00-00 00:00:00"),
+// which stands for a missing value.
+func isZeroDate(s string) bool {
+	return strings.HasPrefix(s, "0000-00-00")
+}
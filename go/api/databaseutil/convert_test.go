@@ -0,0 +1,72 @@
+package databaseutil
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConvertValueWithFormat(t *testing.T) {
+	new_york, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("load location: %v", err)
+	}
+	// lib/pq scans timestamptz in the session zone
+	pg_tz := time.Date(2024, 1, 2, 0, 4, 5, 0, time.FixedZone("", -3*3600))
+	pg_naive := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	pg_date := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		value     interface{}
+		data_type string
+		loc       *time.Location
+		format    string
+		want      interface{}
+	}{
+		{name: "pg timestamptz", value: pg_tz, data_type: "timestamptz",
+			want: "2024-01-02T03:04:05Z"},
+		{name: "pg timestamptz in zone", value: pg_tz, data_type: "timestamptz", loc: new_york,
+			want: "2024-01-01T22:04:05-05:00"},
+		{name: "pg naive timestamp", value: pg_naive, data_type: "timestamp",
+			want: "2024-01-02T03:04:05Z"},
+		{name: "pg fraction", value: pg_naive.Add(123456 * time.Microsecond), data_type: "timestamptz",
+			want: "2024-01-02T03:04:05.123456Z"},
+		{name: "pg null", value: nil, data_type: "timestamptz", want: nil},
+		{name: "mysql datetime", value: []byte("2024-01-02 03:04:05"), data_type: "datetime",
+			want: "2024-01-02T03:04:05Z"},
+		{name: "mysql datetime fraction", value: []byte("2024-01-02 03:04:05.500000"), data_type: "datetime",
+			want: "2024-01-02T03:04:05.5Z"},
+		{name: "mysql zero datetime", value: []byte("0000-00-00 00:00:00"), data_type: "datetime", want: nil},
+		{name: "mysql text kept if unparsable", value: []byte("soon"), data_type: "datetime", want: "soon"},
+		{name: "pg date", value: pg_date, data_type: "date", want: "2024-01-02"},
+		{name: "pg date not shifted by zone", value: pg_date, data_type: "date", loc: new_york,
+			want: "2024-01-02"},
+		{name: "mysql date", value: []byte("2024-01-02"), data_type: "date", want: "2024-01-02"},
+		{name: "mysql zero date", value: []byte("0000-00-00"), data_type: "date", want: nil},
+		{name: "unix", value: pg_tz, data_type: "timestamptz", format: "unix",
+			want: pg_naive.Unix()},
+		{name: "unix_ms", value: []byte("2024-01-02 03:04:05.250"), data_type: "datetime", format: "unix_ms",
+			want: pg_naive.UnixMilli() + 250},
+		{name: "layout in zone", value: pg_tz, data_type: "timestamptz", loc: new_york,
+			format: "2006-01-02 15:04 MST", want: "2024-01-01 22:04 EST"},
+		{name: "date layout", value: []byte("2024-01-02"), data_type: "date", format: "02/01/2006",
+			want: "02/01/2024"},
+		{name: "date unix", value: pg_date, data_type: "date", format: "unix", want: pg_date.Unix()},
+		{name: "mysql json array", value: []byte(`["a","b"]`), data_type: "array",
+			want: []interface{}{"a", "b"}},
+		{name: "mysql json int array", value: []byte(`[3, 4.5]`), data_type: "array",
+			want: []interface{}{int64(3), 4.5}},
+		{name: "pg array text", value: []byte("{a,b}"), data_type: "array", want: "{a,b}"},
+		{name: "null array", value: nil, data_type: "array", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ConvertValueWithFormat(tt.value, tt.data_type, tt.loc, tt.format)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -70,6 +70,8 @@ func IsValidTableName(name string) bool {
 	return regexp.MustCompile(`^[a-zA-Z0-9_]+$`).MatchString(name)
 }
 
+// HandleSelect is HandleSelectDB for the configured database type
+// (ApiTypes.DBType). It is kept for existing callers.
 func HandleSelect(c echo.Context,
 	logger ApiTypes.JimoLogger,
 	base_stmt string,
@@ -78,6 +80,23 @@ func HandleSelect(c echo.Context,
 	whereClauses []string,
 	args []interface{},
 	limit string) (*sql.Rows, error) {
+	return HandleSelectDB(c, logger, ApiTypes.DBType, base_stmt, db,
+		allowedFields, whereClauses, args, limit)
+}
+
+// HandleSelectDB runs 'base_stmt' on 'db' with the filters in the query
+// parameters of 'c'. The placeholders follow 'db_type' ("?" for MySQL,
+// "$n" for PostgreSQL) and are numbered after the placeholders of the
+// given 'whereClauses' and 'args'.
+func HandleSelectDB(c echo.Context,
+	logger ApiTypes.JimoLogger,
+	db_type string,
+	base_stmt string,
+	db *sql.DB,
+	allowedFields map[string]bool,
+	whereClauses []string,
+	args []interface{},
+	limit string) (*sql.Rows, error) {
 	// This function handles dynamic WHERE clause construction.
 	// The conditions are passed as query parameters:
 	// field_0, op_0, val_0, logic_opr_0
@@ -91,6 +110,12 @@ func HandleSelect(c echo.Context,
 
 	logger.Info("To retrieve data for Documents (SHD_DBS_024)")
 
+	if !ApiTypes.IsValidDBType(db_type) {
+		error_msg := fmt.Errorf("(MID_26031071) unsupported database type: %s", db_type)
+		logger.Error("Invalid database type in HandleSelect", "db_type", db_type)
+		return nil, error_msg
+	}
+
 	i := 0
 	for {
 		logger.Info("Processing filter index", "index", i)
@@ -124,12 +149,17 @@ func HandleSelect(c echo.Context,
 		val := c.QueryParam(fmt.Sprintf("val_%d", i))
 
 		// SECURITY: Build WHERE clause with placeholders only - never interpolate val
-		if i > 0 {
-			whereClauses = append(whereClauses, fmt.Sprintf("%s %s %s ?", logic_opr, field, op))
+		args = append(args, val)
+		placeholder := "?"
+		if db_type == ApiTypes.PgName {
+			placeholder = fmt.Sprintf("$%d", len(args))
+		}
+		// The clauses passed in are joined with the first filter by AND
+		if len(whereClauses) > 0 {
+			whereClauses = append(whereClauses, fmt.Sprintf("%s %s %s %s", logic_opr, field, op, placeholder))
 		} else {
-			whereClauses = append(whereClauses, fmt.Sprintf("%s %s ?", field, op))
+			whereClauses = append(whereClauses, fmt.Sprintf("%s %s %s", field, op, placeholder))
 		}
-		args = append(args, val)
 
 		logger.Info("Received filter", "field", field, "op", op, "logic_opr", logic_opr)
 		i++
@@ -198,33 +228,24 @@ func StrPtr(s string) *string {
 	return &s
 }
 
+// CloseDatabase closes the database handles of both backends. Handles
+// are often shared (e.g. the migration handles may be the project or
+// shared handles), so each distinct handle is closed once.
 func CloseDatabase(config ApiTypes.CommonConfigDef) {
-	if config.MySQLConf.ProjectDBHandle != nil {
-		config.MySQLConf.ProjectDBHandle.Close()
-	}
-
-	if config.MySQLConf.SharedDBHandle != nil && config.MySQLConf.SharedDBHandle != config.MySQLConf.ProjectDBHandle {
-		config.MySQLConf.SharedDBHandle.Close()
-	}
-
-	if config.MySQLConf.AutotesterDBHandle != nil {
-		config.MySQLConf.AutotesterDBHandle.Close()
-	}
-
-	if config.PGConf.ProjectDBHandle != nil {
-		config.PGConf.ProjectDBHandle.Close()
-	}
-
-	if config.PGConf.SharedDBHandle != nil && config.PGConf.SharedDBHandle != config.PGConf.ProjectDBHandle {
-		config.PGConf.SharedDBHandle.Close()
-	}
-
-	if config.PGConf.SharedMigrationDBHandle != nil && config.PGConf.SharedMigrationDBHandle != config.PGConf.ProjectDBHandle {
-		config.PGConf.SharedMigrationDBHandle.Close()
-	}
-
-	if config.PGConf.AutotesterDBHandle != nil {
-		config.PGConf.AutotesterDBHandle.Close()
+	closed := make(map[*sql.DB]bool)
+	for _, conf := range []ApiTypes.DatabaseConfig{config.MySQLConf, config.PGConf} {
+		for _, db := range []*sql.DB{
+			conf.ProjectDBHandle,
+			conf.SharedDBHandle,
+			conf.ProjectMigrationDBHandle,
+			conf.SharedMigrationDBHandle,
+			conf.AutotesterDBHandle,
+		} {
+			if db != nil && !closed[db] {
+				closed[db] = true
+				db.Close()
+			}
+		}
 	}
 }
 
@@ -243,11 +264,14 @@ func CreateGenericTable(
 		"json_doc JSON NOT NULL, " +
 		"record_data TEXT NOT NULL, " +
 		"remarks TEXT DEFAULT NULL, " +
-		"del_flag TINYINT(1) DEFAULT 0, " +
 		"created_by VARCHAR(255) NOT NULL, " +
 		"updated_by VARCHAR(255) NOT NULL, " +
 		"created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, " +
-		"updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, "
+		"updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP"
+
+	if !IsValidTableName(table_name) {
+		return fmt.Errorf("(MID_26031084) invalid table name:%s", table_name)
+	}
 
 	var stmt string
 	var db *sql.DB
@@ -256,16 +280,23 @@ func CreateGenericTable(
 		db = mysqlConfig.ProjectDBHandle
 		stmt = "CREATE TABLE IF NOT EXISTS " + table_name + "(" +
 			"doc_id BIGINT AUTO_INCREMENT PRIMARY KEY, " + common_fields +
+			", del_flag TINYINT(1) DEFAULT 0" +
 			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;"
 
 	case ApiTypes.PgName:
 		db = pgConfig.ProjectDBHandle
-		stmt = "CREATE TABLE IF NOT EXISTS " + table_name + "(" + common_fields + ");"
+		stmt = "CREATE TABLE IF NOT EXISTS " + table_name + "(" +
+			"doc_id BIGSERIAL PRIMARY KEY, " + common_fields +
+			", del_flag SMALLINT DEFAULT 0);"
 
 	default:
 		return fmt.Errorf("(MID_26031080) database type not supported:%s", db_type)
 	}
 
+	if db == nil {
+		return fmt.Errorf("(MID_26031085) no %s database handle, table:%s", db_type, table_name)
+	}
+
 	_, err := db.Exec(stmt)
 	if err != nil {
 		return fmt.Errorf("(MID_26031081) failed to create table: %w", err)
@@ -273,3 +304,46 @@ func CreateGenericTable(
 	logger.Info("Table created successfully (SHD_DBS_322)", "table_name", table_name)
 	return nil
 }
+
+// GetTableColumns returns the columns of 'table_name' in the current schema,
+// keyed by lower-cased column name. The value is the database's data type
+// (information_schema.columns.data_type), lower-cased. It returns an empty
+// map if the table does not exist.
+func GetTableColumns(
+	db *sql.DB,
+	db_type string,
+	table_name string) (map[string]string, error) {
+	var query string
+	switch db_type {
+	case ApiTypes.MysqlName:
+		query = "SELECT column_name, data_type FROM information_schema.columns " +
+			"WHERE table_schema = DATABASE() AND table_name = ?"
+
+	case ApiTypes.PgName:
+		query = "SELECT column_name, data_type FROM information_schema.columns " +
+			"WHERE table_schema = current_schema() AND table_name = $1"
+
+	default:
+		return nil, fmt.Errorf("(MID_26031080) unsupported database type: %s", db_type)
+	}
+
+	rows, err := db.Query(query, table_name)
+	if err != nil {
+		return nil, fmt.Errorf("(MID_26031081) failed to query columns, table:%s, error: %w", table_name, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]string)
+	for rows.Next() {
+		var column_name, data_type string
+		if err := rows.Scan(&column_name, &data_type); err != nil {
+			return nil, fmt.Errorf("(MID_26031082) failed to scan column, table:%s, error: %w", table_name, err)
+		}
+		columns[strings.ToLower(column_name)] = strings.ToLower(data_type)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("(MID_26031083) failed to iterate columns, table:%s, error: %w", table_name, err)
+	}
+	return columns, nil
+}
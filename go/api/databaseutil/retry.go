@@ -0,0 +1,236 @@
+package databaseutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// MaxDBRetries is the number of retries after the first attempt
+const MaxDBRetries = 3
+
+var (
+	retryBaseDelay = 50 * time.Millisecond
+	retryMaxDelay  = 1 * time.Second
+)
+
+// ErrCircuitOpen is returned without touching the database while the
+// breaker for that database is open. Handlers should map it to 503.
+var ErrCircuitOpen = errors.New("database temporarily unavailable, circuit breaker is open")
+
+// IsCircuitOpen reports whether err was caused by an open breaker
+func IsCircuitOpen(err error) bool {
+	return errors.Is(err, ErrCircuitOpen)
+}
+
+// IsRetryableError reports whether err is transient: a broken or refused
+// connection, a server shutting down (failover), a serialization failure
+// (40001) or a deadlock (40P01). Everything else, including context
+// cancellation and sql.ErrNoRows, is not retryable.
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var sql_state interface{ SQLState() string }
+	if errors.As(err, &sql_state) {
+		code := sql_state.SQLState()
+		switch {
+		case code == "40001", code == "40P01":
+			return true
+		case strings.HasPrefix(code, "08"):
+			// connection_exception class
+			return true
+		case code == "57P01", code == "57P02", code == "57P03":
+			// admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var net_err net.Error
+	if errors.As(err, &net_err) {
+		return true
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "connection reset by peer") ||
+		strings.Contains(msg, "broken pipe") ||
+		strings.Contains(msg, "connection refused")
+}
+
+// IsWriteRetryableError reports whether a write that failed with err can
+// be run again without being applied twice: a serialization failure
+// (40001) or a deadlock (40P01), which roll the transaction back, or
+// driver.ErrBadConn, which a driver only returns before the statement is
+// sent. A connection lost after that (including on commit) is not
+// retryable, since the server may have applied the write.
+func IsWriteRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var sql_state interface{ SQLState() string }
+	if errors.As(err, &sql_state) {
+		code := sql_state.SQLState()
+		return code == "40001" || code == "40P01"
+	}
+	return errors.Is(err, driver.ErrBadConn)
+}
+
+// WithRetry runs fn against db through the database's circuit breaker.
+// Retryable errors are retried up to MaxDBRetries times with jittered
+// exponential backoff; no retry is started if it would outlive the
+// context deadline. A statement that failed with a connection error may
+// or may not have been applied, so WithRetry is for reads; writes use
+// WithWriteRetry.
+func WithRetry(ctx context.Context, db *sql.DB, fn func(ctx context.Context) error) error {
+	return withRetry(ctx, db, IsRetryableError, fn)
+}
+
+// WithWriteRetry is WithRetry for writes: fn is only run again after an
+// error that IsWriteRetryableError accepts.
+func WithWriteRetry(ctx context.Context, db *sql.DB, fn func(ctx context.Context) error) error {
+	return withRetry(ctx, db, IsWriteRetryableError, fn)
+}
+
+// withRetry runs fn, retrying the errors 'retryable' accepts. The breaker
+// counts every transient error (IsRetryableError) as a failure.
+func withRetry(
+	ctx context.Context,
+	db *sql.DB,
+	retryable func(err error) bool,
+	fn func(ctx context.Context) error) error {
+	cb := GetBreaker(db)
+	if !cb.Allow() {
+		return ErrCircuitOpen
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn(ctx)
+		if err == nil || !retryable(err) || attempt >= MaxDBRetries {
+			break
+		}
+
+		delay := backoffDelay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			break
+		}
+
+		recordRetry(ctx, cb.name, attempt+1)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			cb.Record(true)
+			return err
+		case <-timer.C:
+		}
+	}
+
+	cb.Record(IsRetryableError(err))
+	return err
+}
+
+// ExecWithRetry is db.ExecContext wrapped in WithWriteRetry
+func ExecWithRetry(ctx context.Context, db *sql.DB, query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := WithWriteRetry(ctx, db, func(ctx context.Context) error {
+		var err error
+		result, err = db.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+// QueryWithRetry is db.QueryContext wrapped in WithRetry. Only opening
+// the result set is retried; errors while iterating are not.
+func QueryWithRetry(ctx context.Context, db *sql.DB, query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := WithRetry(ctx, db, func(ctx context.Context) error {
+		var err error
+		rows, err = db.QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// QueryRowWithRetry runs a single-row query and scans it into dest,
+// wrapped in WithRetry. It returns sql.ErrNoRows if there is no row.
+func QueryRowWithRetry(ctx context.Context, db *sql.DB, query string, args []interface{}, dest ...interface{}) error {
+	return WithRetry(ctx, db, func(ctx context.Context) error {
+		return db.QueryRowContext(ctx, query, args...).Scan(dest...)
+	})
+}
+
+// backoffDelay returns a delay in [d/2, d) where d doubles per attempt,
+// capped at retryMaxDelay
+func backoffDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+var (
+	dbRetryCounter             metric.Int64Counter
+	dbBreakerTransitionCounter metric.Int64Counter
+	dbMetricsOnce              sync.Once
+)
+
+func initDBMetrics() {
+	dbMetricsOnce.Do(func() {
+		meter := otel.Meter("github.com/chendingplano/shared/go/api/databaseutil")
+		counter, err := meter.Int64Counter("db.client.retries",
+			metric.WithDescription("Database calls retried after a transient error"))
+		if err == nil {
+			dbRetryCounter = counter
+		}
+
+		counter, err = meter.Int64Counter("db.client.breaker.transitions",
+			metric.WithDescription("Database circuit breaker state transitions"))
+		if err == nil {
+			dbBreakerTransitionCounter = counter
+		}
+	})
+}
+
+func recordRetry(ctx context.Context, db_name string, attempt int) {
+	initDBMetrics()
+	if dbRetryCounter != nil {
+		dbRetryCounter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("db.name", db_name),
+			attribute.Int("retry.attempt", attempt)))
+	}
+}
+
+func recordBreakerTransition(db_name string, from BreakerState, to BreakerState) {
+	initDBMetrics()
+	if dbBreakerTransitionCounter != nil {
+		dbBreakerTransitionCounter.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("db.name", db_name),
+			attribute.String("breaker.from", from.String()),
+			attribute.String("breaker.to", to.String())))
+	}
+}
@@ -0,0 +1,325 @@
+package databaseutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// flakyConnector is a fake driver whose statements fail with failErr for
+// the first 'failures' calls, then succeed.
+type flakyConnector struct {
+	mu       sync.Mutex
+	failures int
+	failErr  error
+	calls    int
+}
+
+func (fc *flakyConnector) Connect(context.Context) (driver.Conn, error) {
+	return &flakyConn{fc: fc}, nil
+}
+
+func (fc *flakyConnector) Driver() driver.Driver { return nil }
+
+func (fc *flakyConnector) next() error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.calls++
+	if fc.calls <= fc.failures {
+		return fc.failErr
+	}
+	return nil
+}
+
+type flakyConn struct{ fc *flakyConnector }
+
+func (c *flakyConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *flakyConn) Close() error                        { return nil }
+func (c *flakyConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *flakyConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	if err := c.fc.next(); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *flakyConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	if err := c.fc.next(); err != nil {
+		return nil, err
+	}
+	return &flakyRows{}, nil
+}
+
+type flakyRows struct{ done bool }
+
+func (r *flakyRows) Columns() []string { return []string{"n"} }
+func (r *flakyRows) Close() error      { return nil }
+func (r *flakyRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(42)
+	return nil
+}
+
+// lossyConnector is a fake driver that applies every write, then loses
+// the connection before the client hears back: on commit (commitErr) or,
+// outside a transaction, on the statement itself (execErr).
+type lossyConnector struct {
+	mu        sync.Mutex
+	applied   int
+	commitErr error
+	execErr   error
+}
+
+func (lc *lossyConnector) Connect(context.Context) (driver.Conn, error) {
+	return &lossyConn{lc: lc}, nil
+}
+
+func (lc *lossyConnector) Driver() driver.Driver { return nil }
+
+type lossyConn struct {
+	lc   *lossyConnector
+	inTx bool
+}
+
+func (c *lossyConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *lossyConn) Close() error                        { return nil }
+
+func (c *lossyConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	return &lossyTx{c: c}, nil
+}
+
+func (c *lossyConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	c.lc.mu.Lock()
+	defer c.lc.mu.Unlock()
+	c.lc.applied++
+	if !c.inTx && c.lc.execErr != nil {
+		return nil, c.lc.execErr
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type lossyTx struct{ c *lossyConn }
+
+func (tx *lossyTx) Commit() error {
+	tx.c.inTx = false
+	return tx.c.lc.commitErr
+}
+
+func (tx *lossyTx) Rollback() error {
+	tx.c.inTx = false
+	return nil
+}
+
+func newLossyDB(t *testing.T, lc *lossyConnector) *sql.DB {
+	t.Helper()
+	retryBaseDelay = time.Millisecond
+	retryMaxDelay = 5 * time.Millisecond
+	SetBreakerConfig(DefaultBreakerConfig())
+	db := sql.OpenDB(lc)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newFlakyDB(t *testing.T, failures int, failErr error) (*sql.DB, *flakyConnector) {
+	t.Helper()
+	retryBaseDelay = time.Millisecond
+	retryMaxDelay = 5 * time.Millisecond
+	SetBreakerConfig(DefaultBreakerConfig())
+	fc := &flakyConnector{failures: failures, failErr: failErr}
+	db := sql.OpenDB(fc)
+	t.Cleanup(func() { db.Close() })
+	return db, fc
+}
+
+func TestExecWithRetrySucceedsAfterTransientFailures(t *testing.T) {
+	db, fc := newFlakyDB(t, 2, &pq.Error{Code: "40001"})
+
+	result, err := ExecWithRetry(context.Background(), db, "UPDATE t SET n = 1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		t.Fatalf("unexpected rows affected: %d", n)
+	}
+	if fc.calls != 3 {
+		t.Fatalf("unexpected calls: got %d want 3", fc.calls)
+	}
+}
+
+// A write whose commit or reply is lost may have been applied, so it is
+// not run again
+func TestWriteRetryDoesNotRepeatAppliedWrite(t *testing.T) {
+	lc := &lossyConnector{commitErr: io.ErrUnexpectedEOF}
+	db := newLossyDB(t, lc)
+
+	err := WithWriteRetry(context.Background(), db, func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, "INSERT INTO t VALUES (1)"); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lc.applied != 1 {
+		t.Fatalf("write applied %d times, want 1", lc.applied)
+	}
+
+	lc = &lossyConnector{execErr: syscall.ECONNRESET}
+	if _, err := ExecWithRetry(context.Background(), newLossyDB(t, lc), "DELETE FROM t"); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lc.applied != 1 {
+		t.Fatalf("statement applied %d times, want 1", lc.applied)
+	}
+}
+
+func TestQueryRowWithRetryGivesUpAfterMaxRetries(t *testing.T) {
+	db, fc := newFlakyDB(t, 10, &pq.Error{Code: "40P01"})
+
+	var n int
+	err := QueryRowWithRetry(context.Background(), db, "SELECT n FROM t", nil, &n)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if fc.calls != MaxDBRetries+1 {
+		t.Fatalf("unexpected calls: got %d want %d", fc.calls, MaxDBRetries+1)
+	}
+}
+
+func TestQueryRowWithRetryDoesNotRetryPermanentErrors(t *testing.T) {
+	db, fc := newFlakyDB(t, 1, &pq.Error{Code: "23505"})
+
+	var n int
+	err := QueryRowWithRetry(context.Background(), db, "SELECT n FROM t", nil, &n)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if fc.calls != 1 {
+		t.Fatalf("unexpected calls: got %d want 1", fc.calls)
+	}
+
+	if err := QueryRowWithRetry(context.Background(), db, "SELECT n FROM t", nil, &n); err != nil || n != 42 {
+		t.Fatalf("unexpected result: n=%d err=%v", n, err)
+	}
+}
+
+func TestWithRetryRespectsContextDeadline(t *testing.T) {
+	db, fc := newFlakyDB(t, 10, &pq.Error{Code: "40001"})
+	retryBaseDelay = time.Second
+	retryMaxDelay = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := ExecWithRetry(ctx, db, "UPDATE t SET n = 1"); err == nil {
+		t.Fatal("expected error")
+	}
+	if fc.calls != 1 {
+		t.Fatalf("unexpected calls: got %d want 1", fc.calls)
+	}
+}
+
+func TestCircuitBreakerOpensAndHalfOpens(t *testing.T) {
+	db, fc := newFlakyDB(t, 1000, &pq.Error{Code: "08006"})
+	SetBreakerConfig(BreakerConfig{
+		FailureRate:  0.5,
+		MinRequests:  2,
+		Window:       time.Minute,
+		OpenDuration: time.Hour,
+	})
+	now := time.Now()
+	cb := GetBreaker(db)
+	cb.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := ExecWithRetry(context.Background(), db, "UPDATE t SET n = 1"); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if cb.State() != BreakerOpen {
+		t.Fatalf("unexpected state: %s", cb.State())
+	}
+
+	calls := fc.calls
+	if _, err := ExecWithRetry(context.Background(), db, "UPDATE t SET n = 1"); !IsCircuitOpen(err) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if fc.calls != calls {
+		t.Fatal("open breaker must not reach the database")
+	}
+
+	// After OpenDuration one probe is let through; it succeeds and closes
+	// the breaker.
+	fc.mu.Lock()
+	fc.failures = 0
+	fc.mu.Unlock()
+	now = now.Add(time.Hour)
+	if _, err := ExecWithRetry(context.Background(), db, "UPDATE t SET n = 1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cb.State() != BreakerClosed {
+		t.Fatalf("unexpected state: %s", cb.State())
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{&pq.Error{Code: "57P01"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{driver.ErrBadConn, true},
+		{errors.New("read tcp: connection reset by peer"), true},
+		{sql.ErrNoRows, false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, c := range cases {
+		if got := IsRetryableError(c.err); got != c.want {
+			t.Errorf("IsRetryableError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestIsWriteRetryableError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{driver.ErrBadConn, true},
+		{&pq.Error{Code: "08006"}, false},
+		{&pq.Error{Code: "57P01"}, false},
+		{io.ErrUnexpectedEOF, false},
+		{syscall.ECONNRESET, false},
+		{syscall.EPIPE, false},
+		{errors.New("read tcp: connection reset by peer"), false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, c := range cases {
+		if got := IsWriteRetryableError(c.err); got != c.want {
+			t.Errorf("IsWriteRetryableError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
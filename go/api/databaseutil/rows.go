@@ -0,0 +1,58 @@
+package databaseutil
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// QueryRows runs 'query' with QueryWithRetry and returns its rows as maps
+// keyed by column name (see ScanRows).
+func QueryRows(
+	ctx context.Context,
+	db *sql.DB,
+	query string,
+	args []interface{},
+	columnTypes map[string]string) ([]map[string]interface{}, error) {
+	rows, err := QueryWithRetry(ctx, db, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed (SHD_DBS_501): %w", err)
+	}
+	defer rows.Close()
+	return ScanRows(rows, columnTypes)
+}
+
+// ScanRows reads all of 'rows' into maps keyed by column name. Each value
+// is converted with ConvertValueByType using the data type of its column
+// in 'columnTypes' (e.g. "int", "timestamp"). Columns not in columnTypes
+// are returned as the driver scanned them, with []byte turned into
+// string. NULL is always nil. It returns an empty slice, not nil, if
+// there are no rows. The caller closes 'rows'.
+func ScanRows(rows *sql.Rows, columnTypes map[string]string) ([]map[string]interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns (SHD_DBS_502): %w", err)
+	}
+
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	results := []map[string]interface{}{}
+	for rows.Next() {
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("scan failed (SHD_DBS_503): %w", err)
+		}
+		record := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			record[column] = ConvertValueByType(values[i], columnTypes[column])
+		}
+		results = append(results, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows (SHD_DBS_504): %w", err)
+	}
+	return results, nil
+}
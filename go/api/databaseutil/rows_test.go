@@ -0,0 +1,53 @@
+package databaseutil
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestQueryRows(t *testing.T) {
+	db, mock := newMockDB(t)
+	created := time.Date(2026, 1, 10, 2, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT id, name, active, created_at, extra FROM users WHERE id > $1").
+		WithArgs(0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "active", "created_at", "extra"}).
+			AddRow([]byte("1"), []byte("alice"), []byte("1"), created, []byte("x")).
+			AddRow(int64(2), nil, false, nil, nil))
+
+	records, err := QueryRows(context.Background(), db,
+		"SELECT id, name, active, created_at, extra FROM users WHERE id > $1", []interface{}{0},
+		map[string]string{"id": "int", "name": "string", "active": "bool", "created_at": "timestamp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{"id": 1, "name": "alice", "active": true, "created_at": "2026-01-10T02:00:00Z", "extra": "x"},
+		{"id": 2, "name": nil, "active": false, "created_at": nil, "extra": nil},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %#v, want %#v", records, want)
+	}
+
+	mock.ExpectQuery("SELECT id FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	records, err = QueryRows(context.Background(), db, "SELECT id FROM users", nil, nil)
+	if err != nil || records == nil || len(records) != 0 {
+		t.Errorf("no rows: records = %#v, %v", records, err)
+	}
+
+	rowErr := errors.New("connection lost")
+	mock.ExpectQuery("SELECT id FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).RowError(0, rowErr))
+	if _, err := QueryRows(context.Background(), db, "SELECT id FROM users", nil, nil); !errors.Is(err, rowErr) {
+		t.Errorf("row error = %v, want %v", err, rowErr)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
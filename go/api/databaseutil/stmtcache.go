@@ -0,0 +1,129 @@
+package databaseutil
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// Prepared statements for hot lookups, cached per database handle and
+// query text. The query text already encodes the db type and table, so
+// the same lookup against another table gets its own statement.
+//
+// database/sql re-prepares a *sql.Stmt transparently on each pool
+// connection it runs on, including connections opened after a reset.
+// A statement that fails for any other reason than no rows is dropped
+// from the cache and prepared again on next use; this also covers
+// PostgreSQL's "cached plan must not change result type" after a
+// schema change.
+type stmtKey struct {
+	db    *sql.DB
+	query string
+}
+
+var (
+	stmtsMu sync.RWMutex
+	stmts   = make(map[stmtKey]*sql.Stmt)
+)
+
+// errStmtClosed is what database/sql returns when a statement was
+// closed by another goroutine's invalidation while we held it
+const errStmtClosed = "sql: statement is closed"
+
+// PrepareCached returns the cached prepared statement for 'query' on db,
+// preparing it on first use. The statement is shared; callers must not
+// close it.
+func PrepareCached(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) {
+	key := stmtKey{db: db, query: query}
+	stmtsMu.RLock()
+	stmt, ok := stmts[key]
+	stmtsMu.RUnlock()
+	if ok {
+		return stmt, nil
+	}
+
+	stmtsMu.Lock()
+	defer stmtsMu.Unlock()
+	if stmt, ok := stmts[key]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	stmts[key] = stmt
+	return stmt, nil
+}
+
+// invalidateStmt drops stmt from the cache if it is still the cached
+// statement for 'query', and closes it
+func invalidateStmt(db *sql.DB, query string, stmt *sql.Stmt) {
+	key := stmtKey{db: db, query: query}
+	stmtsMu.Lock()
+	if stmts[key] != stmt {
+		stmtsMu.Unlock()
+		return
+	}
+	delete(stmts, key)
+	stmtsMu.Unlock()
+	stmt.Close()
+}
+
+// CloseStmts closes and forgets all cached statements for db. Call it
+// before closing db or replacing the shared handle.
+func CloseStmts(db *sql.DB) {
+	stmtsMu.Lock()
+	var closing []*sql.Stmt
+	for key, stmt := range stmts {
+		if key.db == db {
+			closing = append(closing, stmt)
+			delete(stmts, key)
+		}
+	}
+	stmtsMu.Unlock()
+	for _, stmt := range closing {
+		stmt.Close()
+	}
+}
+
+// WithPreparedStmt runs fn with the cached prepared statement for
+// 'query', wrapped in WithRetry. If fn fails with anything but
+// sql.ErrNoRows or a context error, the statement is dropped so the
+// next call prepares it again.
+func WithPreparedStmt(
+	ctx context.Context,
+	db *sql.DB,
+	query string,
+	fn func(ctx context.Context, stmt *sql.Stmt) error) error {
+	return WithRetry(ctx, db, func(ctx context.Context) error {
+		var err error
+		// A second attempt only if another goroutine closed the statement
+		// between our cache lookup and the query
+		for attempt := 0; attempt < 2; attempt++ {
+			var stmt *sql.Stmt
+			stmt, err = PrepareCached(ctx, db, query)
+			if err != nil {
+				return err
+			}
+			err = fn(ctx, stmt)
+			if err == nil || errors.Is(err, sql.ErrNoRows) ||
+				errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			invalidateStmt(db, query, stmt)
+			if err.Error() != errStmtClosed {
+				return err
+			}
+		}
+		return err
+	})
+}
+
+// QueryRowPrepared is QueryRowWithRetry through the prepared-statement
+// cache. It returns sql.ErrNoRows if there is no row.
+func QueryRowPrepared(ctx context.Context, db *sql.DB, query string, args []interface{}, dest ...interface{}) error {
+	return WithPreparedStmt(ctx, db, query, func(ctx context.Context, stmt *sql.Stmt) error {
+		return stmt.QueryRowContext(ctx, args...).Scan(dest...)
+	})
+}
@@ -0,0 +1,94 @@
+package databaseutil
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+func TestWithPreparedStmt(t *testing.T) {
+	const query = "SELECT name FROM users WHERE email = $1"
+	tdb := testharness.NewMockDB(t)
+	t.Cleanup(func() { CloseStmts(tdb.DB) })
+	ctx := context.Background()
+
+	// Prepared once, then reused
+	prep := tdb.Mock.ExpectPrepare(query)
+	prep.ExpectQuery().WithArgs("a@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	prep.ExpectQuery().WithArgs("b@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+
+	var name string
+	if err := QueryRowPrepared(ctx, tdb.DB, query, []interface{}{"a@example.com"}, &name); err != nil || name != "alice" {
+		t.Fatalf("first lookup: name %q, err %v", name, err)
+	}
+	if err := QueryRowPrepared(ctx, tdb.DB, query, []interface{}{"b@example.com"}, &name); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("no rows: err = %v, want sql.ErrNoRows", err)
+	}
+
+	// A failed statement is closed and prepared again on next use
+	prep.ExpectQuery().WithArgs("c@example.com").
+		WillReturnError(errors.New("cached plan must not change result type"))
+	prep.WillBeClosed()
+	if err := QueryRowPrepared(ctx, tdb.DB, query, []interface{}{"c@example.com"}, &name); err == nil {
+		t.Fatal("failed lookup: want error")
+	}
+	tdb.Mock.ExpectPrepare(query).ExpectQuery().WithArgs("c@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("carol"))
+	if err := QueryRowPrepared(ctx, tdb.DB, query, []interface{}{"c@example.com"}, &name); err != nil || name != "carol" {
+		t.Fatalf("after re-prepare: name %q, err %v", name, err)
+	}
+}
+
+// BenchmarkUserLookup compares a lookup re-sent as query text on every
+// call with the same lookup through the prepared-statement cache. It
+// needs a real database (SHARED_TEST_DB=postgres or testcontainers);
+// sqlmock has no planner to measure.
+func BenchmarkUserLookup(b *testing.B) {
+	tdb := testharness.NewTestDB(b)
+	if tdb.IsMock() {
+		b.Skip("needs a real database")
+	}
+	db := tdb.DB
+	b.Cleanup(func() { CloseStmts(db) })
+	ctx := context.Background()
+
+	if _, err := db.Exec(`CREATE TABLE bench_users (
+		id TEXT PRIMARY KEY, name TEXT, email TEXT UNIQUE, status TEXT, created_at TIMESTAMPTZ DEFAULT now())`); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO bench_users (id, name, email, status)
+		SELECT 'u' || i, 'user' || i, 'user' || i || '@example.com', 'active' FROM generate_series(1, 10000) i`); err != nil {
+		b.Fatal(err)
+	}
+	const query = "SELECT id, name, email, status, created_at FROM bench_users " +
+		"WHERE email = $1 AND status <> 'disabled' LIMIT 1"
+
+	var id, name, email, status string
+	var created sql.NullTime
+	run := func(b *testing.B, lookup func(args []interface{}) error) {
+		for i := 0; i < b.N; i++ {
+			args := []interface{}{fmt.Sprintf("user%d@example.com", i%10000+1)}
+			if err := lookup(args); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("unprepared", func(b *testing.B) {
+		run(b, func(args []interface{}) error {
+			return QueryRowWithRetry(ctx, db, query, args, &id, &name, &email, &status, &created)
+		})
+	})
+	b.Run("prepared", func(b *testing.B) {
+		run(b, func(args []interface{}) error {
+			return QueryRowPrepared(ctx, db, query, args, &id, &name, &email, &status, &created)
+		})
+	})
+}
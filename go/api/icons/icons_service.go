@@ -1,19 +1,22 @@
 package icons
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
+	"image/jpeg"
+	"image/png"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/chendingplano/shared/go/api/ApiTypes"
 	"github.com/chendingplano/shared/go/api/loggerutil"
 	"github.com/google/uuid"
+	"golang.org/x/image/draw"
 	_ "golang.org/x/image/webp"
 )
 
@@ -263,6 +266,169 @@ func (s *iconServiceImpl) GetIconFilePath(category string, filename string) (str
 	return filePath, nil
 }
 
+// GenerateThumbnail writes a resized copy of a raster icon next to the original.
+// JPEG icons are re-encoded as JPEG; everything else (PNG, GIF, WebP) becomes PNG
+// since the standard library cannot encode WebP. For animated GIFs only the first
+// frame is kept.
+func (s *iconServiceImpl) GenerateThumbnail(
+	rc ApiTypes.RequestContext,
+	icon *ApiTypes.IconDef,
+	maxDim int,
+	force bool) (string, int64, error) {
+
+	log := rc.GetLogger()
+
+	if icon.MimeType == "image/svg+xml" {
+		return "", 0, fmt.Errorf("svg icons are not resized (SHD_ICN_SVC_262): %s", icon.ID)
+	}
+
+	if maxDim <= 0 || maxDim > ApiTypes.MaxIconThumbnailDim {
+		return "", 0, fmt.Errorf("invalid thumbnail dimension (SHD_ICN_SVC_266): %d", maxDim)
+	}
+
+	category := sanitizePath(icon.Category)
+	categoryDir := s.getCategoryDir(category)
+	srcPath := filepath.Join(categoryDir, sanitizePath(icon.FileName))
+
+	thumbName := thumbnailName(icon, maxDim)
+	thumbPath := filepath.Join(categoryDir, thumbName)
+	relPath := filepath.Join("icons", category, thumbName)
+
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		log.Error("icon file not found for thumbnail", "error", err, "path", srcPath)
+		return "", 0, fmt.Errorf("icon file not found (SHD_ICN_SVC_283): %w", err)
+	}
+
+	// Reuse the cached thumbnail unless the original changed after it was written
+	if !force {
+		if thumbInfo, err := os.Stat(thumbPath); err == nil && !thumbInfo.ModTime().Before(srcInfo.ModTime()) {
+			return relPath, thumbInfo.Size(), nil
+		}
+	}
+
+	content, err := os.ReadFile(srcPath)
+	if err != nil {
+		log.Error("failed to read icon file", "error", err, "path", srcPath)
+		return "", 0, fmt.Errorf("failed to read icon file (SHD_ICN_SVC_295): %w", err)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		log.Error("failed to decode icon image", "error", err, "path", srcPath)
+		return "", 0, fmt.Errorf("failed to decode icon image (SHD_ICN_SVC_301): %w", err)
+	}
+
+	w, h := thumbnailSize(src.Bounds().Dx(), src.Bounds().Dy(), maxDim)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+
+	var buf bytes.Buffer
+	if icon.MimeType == "image/jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		log.Error("failed to encode thumbnail", "error", err, "path", thumbPath)
+		return "", 0, fmt.Errorf("failed to encode thumbnail (SHD_ICN_SVC_315): %w", err)
+	}
+
+	if err := os.WriteFile(thumbPath, buf.Bytes(), 0644); err != nil {
+		log.Error("failed to write thumbnail", "error", err, "path", thumbPath)
+		return "", 0, fmt.Errorf("failed to write thumbnail (SHD_ICN_SVC_320): %w", err)
+	}
+
+	log.Info("Icon thumbnail created",
+		"id", icon.ID,
+		"thumbnail", thumbName,
+		"width", w,
+		"height", h,
+		"size", buf.Len())
+
+	return relPath, int64(buf.Len()), nil
+}
+
+// DeleteThumbnails removes the thumbnails of an icon, of every size
+func (s *iconServiceImpl) DeleteThumbnails(
+	rc ApiTypes.RequestContext,
+	icon *ApiTypes.IconDef) error {
+
+	log := rc.GetLogger()
+	categoryDir := s.getCategoryDir(icon.Category)
+
+	entries, err := os.ReadDir(categoryDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		log.Error("failed to read icon category", "error", err, "path", categoryDir)
+		return fmt.Errorf("failed to read icon category (SHD_ICN_SVC_355): %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isThumbnailOf(icon, entry.Name()) {
+			continue
+		}
+		thumbPath := filepath.Join(categoryDir, entry.Name())
+		if err := os.Remove(thumbPath); err != nil && !os.IsNotExist(err) {
+			log.Error("failed to delete thumbnail", "error", err, "path", thumbPath)
+			return fmt.Errorf("failed to delete thumbnail (SHD_ICN_SVC_365): %w", err)
+		}
+		log.Info("Icon thumbnail deleted", "id", icon.ID, "path", thumbPath)
+	}
+	return nil
+}
+
+// thumbnailName is the file name of an icon's thumbnail for maxDim:
+// <base>_thumb<maxDim>.jpg for JPEG icons, .png for the other rasters.
+func thumbnailName(icon *ApiTypes.IconDef, maxDim int) string {
+	return fmt.Sprintf("%s%d%s", thumbnailPrefix(icon), maxDim, thumbnailExt(icon))
+}
+
+// isThumbnailOf reports whether fileName is a thumbnail of icon, of any size
+func isThumbnailOf(icon *ApiTypes.IconDef, fileName string) bool {
+	dim, ok := strings.CutPrefix(fileName, thumbnailPrefix(icon))
+	if !ok {
+		return false
+	}
+	dim, ok = strings.CutSuffix(dim, thumbnailExt(icon))
+	if !ok {
+		return false
+	}
+	d, err := strconv.Atoi(dim)
+	return err == nil && d > 0 && strconv.Itoa(d) == dim
+}
+
+func thumbnailPrefix(icon *ApiTypes.IconDef) string {
+	return strings.TrimSuffix(sanitizePath(icon.FileName), filepath.Ext(icon.FileName)) + "_thumb"
+}
+
+func thumbnailExt(icon *ApiTypes.IconDef) string {
+	if icon.MimeType == "image/jpeg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// thumbnailSize scales (width, height) so the longest side equals maxDim,
+// keeping the aspect ratio and never returning a zero dimension.
+func thumbnailSize(width, height, maxDim int) (int, int) {
+	if width >= height {
+		h := height * maxDim / width
+		if h < 1 {
+			h = 1
+		}
+		return maxDim, h
+	}
+
+	w := width * maxDim / height
+	if w < 1 {
+		w = 1
+	}
+	return w, maxDim
+}
+
 /*
 // DeleteIconFileByPath is a helper to delete icon file using service
 func DeleteIconFileByPath(category string, fileName string) error {
@@ -0,0 +1,139 @@
+package icons
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/testharness"
+)
+
+func TestThumbnailSize(t *testing.T) {
+	tests := []struct {
+		width, height, maxDim int
+		wantW, wantH          int
+	}{
+		{100, 50, 64, 64, 32},
+		{50, 100, 64, 32, 64},
+		{100, 100, 32, 32, 32},
+		{1000, 1, 64, 64, 1},
+		{1, 1000, 64, 1, 64},
+	}
+	for _, tt := range tests {
+		if w, h := thumbnailSize(tt.width, tt.height, tt.maxDim); w != tt.wantW || h != tt.wantH {
+			t.Errorf("thumbnailSize(%d, %d, %d) = %d, %d; want %d, %d",
+				tt.width, tt.height, tt.maxDim, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestThumbnailNames(t *testing.T) {
+	png_icon := &ApiTypes.IconDef{FileName: "abc.webp", MimeType: "image/webp"}
+	jpg_icon := &ApiTypes.IconDef{FileName: "abc.jpeg", MimeType: "image/jpeg"}
+	if got := thumbnailName(png_icon, 64); got != "abc_thumb64.png" {
+		t.Errorf("webp thumbnail = %s", got)
+	}
+	if got := thumbnailName(jpg_icon, 32); got != "abc_thumb32.jpg" {
+		t.Errorf("jpeg thumbnail = %s", got)
+	}
+
+	tests := map[string]bool{
+		"abc_thumb64.png":  true,
+		"abc_thumb512.png": true,
+		"abc.webp":         false,
+		"abc_thumb64.jpg":  false,
+		"abc_thumb.png":    false,
+		"abc_thumb064.png": false,
+		"abc_thumbx.png":   false,
+		"abcd_thumb64.png": false,
+	}
+	for name, want := range tests {
+		if got := isThumbnailOf(png_icon, name); got != want {
+			t.Errorf("isThumbnailOf(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestGenerateAndDeleteThumbnails(t *testing.T) {
+	dir := t.TempDir()
+	s := NewIconService(dir).(*iconServiceImpl)
+	rc := testharness.NewFakeRequestContext(t, nil)
+	category_dir := s.getCategoryDir("logos")
+	if err := os.MkdirAll(category_dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(category_dir, "abc.png")
+	writePNG(t, src, 100, 50)
+	writePNG(t, filepath.Join(category_dir, "abcd_thumb64.png"), 1, 1)
+	icon := &ApiTypes.IconDef{ID: "icon-1", Category: "logos", FileName: "abc.png", MimeType: "image/png"}
+
+	rel_path, size, err := s.GenerateThumbnail(rc, icon, 64, false)
+	if err != nil {
+		t.Fatalf("GenerateThumbnail: %v", err)
+	}
+	if want := filepath.Join("icons", "logos", "abc_thumb64.png"); rel_path != want || size <= 0 {
+		t.Errorf("thumbnail = %s (%d bytes), want %s", rel_path, size, want)
+	}
+	if _, _, err := s.GenerateThumbnail(rc, icon, 32, false); err != nil {
+		t.Fatalf("GenerateThumbnail: %v", err)
+	}
+
+	// The cached thumbnail is reused until the original changes
+	thumb := filepath.Join(category_dir, "abc_thumb64.png")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(src, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(thumb, old.Add(time.Minute), old.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.GenerateThumbnail(rc, icon, 64, false); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(thumb); !info.ModTime().Equal(old.Add(time.Minute)) {
+		t.Error("current thumbnail was rewritten")
+	}
+	if err := os.Chtimes(src, time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.GenerateThumbnail(rc, icon, 64, false); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(thumb); info.ModTime().Equal(old.Add(time.Minute)) {
+		t.Error("stale thumbnail was not rewritten")
+	}
+
+	// Every size goes, the original and other icons' files stay
+	if err := s.DeleteThumbnails(rc, icon); err != nil {
+		t.Fatalf("DeleteThumbnails: %v", err)
+	}
+	entries, err := os.ReadDir(category_dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "abc.png" || names[1] != "abcd_thumb64.png" {
+		t.Errorf("files after delete = %v", names)
+	}
+}
+
+func writePNG(t *testing.T, path string, width, height int) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"github.com/chendingplano/shared/go/api/ApiTypes"
 	"github.com/chendingplano/shared/go/api/ApiUtils"
 	"github.com/chendingplano/shared/go/api/EchoFactory"
+	"github.com/chendingplano/shared/go/api/attachments"
 	"github.com/chendingplano/shared/go/api/auth"
 	"github.com/chendingplano/shared/go/api/icons"
+	"github.com/chendingplano/shared/go/api/outlook"
 	"github.com/chendingplano/shared/go/api/stores"
 	"github.com/chendingplano/shared/go/api/sysdatastores"
 	"github.com/chendingplano/shared/go/authmiddleware"
@@ -68,9 +70,23 @@ func InitLib(ctx context.Context, config_path string, loc string) {
 
 	// 4. Init the icon service
 	icons.InitIconService(admin_rc)
+
+	// 5. Init the attachment service
+	if err := attachments.InitAttachmentService(admin_rc); err != nil {
+		logger.Error("Failed to init the attachment service", "error", err)
+	}
+
+	// 6. Start the Outlook token refresh and subscription renewal worker
+	if ApiTypes.LibConfig.OutlookConf.EnableWorker == "enabled" {
+		if err := outlook.StartWorker(logger); err != nil {
+			logger.Error("Failed to start the outlook worker", "error", err)
+		}
+	}
 }
 
 func ExitLib() {
+	attachments.StopSweeper()
+	outlook.StopWorker()
 	stores.StopInMemStore()
 	sysdatastores.StopActivityLogCache()
 	sysdatastores.StopSessionLogCache()
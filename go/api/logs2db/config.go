@@ -21,11 +21,18 @@ const (
 // Log2DBConfig holds all configuration parsed from the TOML file and environment variables.
 type Log2DBConfig struct {
 	// From TOML
-	LogFileDir     string            `mapstructure:"log_file_dir"`
-	DBTableName    string            `mapstructure:"db_table_name"`
-	LogEntryFormat string            `mapstructure:"log_entry_format"`
-	SyncFreqSec    int               `mapstructure:"sync_freq_in_secon"`
-	JSONMapping    map[string]string `mapstructure:"json-mapping"`
+	LogFileDir      string            `mapstructure:"log_file_dir"`
+	DBTableName     string            `mapstructure:"db_table_name"`
+	LogEntryFormat  string            `mapstructure:"log_entry_format"`
+	SyncFreqSec     int               `mapstructure:"sync_freq_in_secon"`
+	WatchMode       string            `mapstructure:"watch_mode"`        // "poll" (default) or "fsnotify"
+	WatchDebounceMs int               `mapstructure:"watch_debounce_ms"` // fsnotify only; default 200
+	JSONMapping     map[string]string `mapstructure:"json-mapping"`
+	Sinks           []SinkConfig      `mapstructure:"sinks"`
+
+	// Ingest limits, so a backlog does not starve the primary workload
+	MaxInsertRate   int `mapstructure:"max_insert_rate"`    // rows/sec over all files; 0 = unlimited
+	MaxLinesPerFile int `mapstructure:"max_lines_per_file"` // new lines read from a file per cycle; 0 = all
 
 	// From environment variables
 	PGHost     string
@@ -39,6 +46,20 @@ type Log2DBConfig struct {
 	PIDFilePath   string // <LogFileDir>/.log2db.pid
 }
 
+// Watch modes: how new log lines are detected
+const (
+	WatchModePoll     = "poll"     // scan all files every sync_freq_in_secon
+	WatchModeFsnotify = "fsnotify" // scan changed files on write events
+)
+
+// SinkConfig is one [[sinks]] entry. Sinks are written in order and the
+// first one is the primary. Without any, entries go to db_table_name.
+type SinkConfig struct {
+	Type       string   `mapstructure:"type"`        // "pg_table" or "stdout"
+	TableName  string   `mapstructure:"table_name"`  // pg_table only; defaults to db_table_name
+	EntryTypes []string `mapstructure:"entry_types"` // only these entry types; empty means all
+}
+
 // LoadConfig reads the LOG2DB_CONFIG env var, parses the TOML file via Viper,
 // merges with PG_* env vars, sets defaults, and validates.
 func LoadConfig() (*Log2DBConfig, error) {
@@ -62,11 +83,16 @@ func LoadConfig() (*Log2DBConfig, error) {
 	}
 
 	config := &Log2DBConfig{
-		LogFileDir:     v.GetString("log_file_dir"),
-		DBTableName:    v.GetString("db_table_name"),
-		LogEntryFormat: v.GetString("log_entry_format"),
-		SyncFreqSec:    v.GetInt("sync_freq_in_secon"),
-		JSONMapping:    v.GetStringMapString("json-mapping"),
+		LogFileDir:      v.GetString("log_file_dir"),
+		DBTableName:     v.GetString("db_table_name"),
+		LogEntryFormat:  v.GetString("log_entry_format"),
+		SyncFreqSec:     v.GetInt("sync_freq_in_secon"),
+		WatchMode:       v.GetString("watch_mode"),
+		WatchDebounceMs: v.GetInt("watch_debounce_ms"),
+		JSONMapping:     v.GetStringMapString("json-mapping"),
+
+		MaxInsertRate:   v.GetInt("max_insert_rate"),
+		MaxLinesPerFile: v.GetInt("max_lines_per_file"),
 
 		PGHost:     getEnvOrDefault("PG_HOST", "127.0.0.1"),
 		PGPort:     getEnvIntOrDefault("PG_PORT", 5432),
@@ -75,10 +101,20 @@ func LoadConfig() (*Log2DBConfig, error) {
 		PGDatabase: os.Getenv("PG_DB_NAME"),
 	}
 
+	if err := v.UnmarshalKey("sinks", &config.Sinks); err != nil {
+		return nil, fmt.Errorf("failed to parse sinks: %w (%s)", err, LOC_CFG_LOAD)
+	}
+
 	// Defaults
 	if config.SyncFreqSec <= 0 {
 		config.SyncFreqSec = 10
 	}
+	if config.WatchMode == "" {
+		config.WatchMode = WatchModePoll
+	}
+	if config.WatchDebounceMs <= 0 {
+		config.WatchDebounceMs = 200
+	}
 
 	// Expand log file dir
 	config.LogFileDir, err = expandPath(config.LogFileDir)
@@ -108,6 +144,28 @@ func (c *Log2DBConfig) Validate() error {
 	if c.LogEntryFormat == "" {
 		return fmt.Errorf("log_entry_format is required in config (%s)", LOC_CFG_VALID)
 	}
+	if c.WatchMode != WatchModePoll && c.WatchMode != WatchModeFsnotify {
+		return fmt.Errorf("watch_mode: unknown mode %q, want %q or %q (%s)",
+			c.WatchMode, WatchModePoll, WatchModeFsnotify, LOC_CFG_VALID)
+	}
+	if c.MaxInsertRate < 0 {
+		return fmt.Errorf("max_insert_rate must not be negative, got %d (%s)", c.MaxInsertRate, LOC_CFG_VALID)
+	}
+	if c.MaxLinesPerFile < 0 {
+		return fmt.Errorf("max_lines_per_file must not be negative, got %d (%s)", c.MaxLinesPerFile, LOC_CFG_VALID)
+	}
+	for i, sc := range c.Sinks {
+		switch sc.Type {
+		case SinkTypePGTable, SinkTypeStdout:
+		default:
+			return fmt.Errorf("sinks[%d]: unknown type %q, want %q or %q (%s)",
+				i, sc.Type, SinkTypePGTable, SinkTypeStdout, LOC_CFG_VALID)
+		}
+		if sc.Type != SinkTypePGTable && sc.TableName != "" {
+			return fmt.Errorf("sinks[%d]: table_name is only valid for %s sinks (%s)",
+				i, SinkTypePGTable, LOC_CFG_VALID)
+		}
+	}
 	if c.PGUser == "" {
 		return fmt.Errorf("PG_USER_NAME environment variable not set (%s)", LOC_CFG_VALID)
 	}
@@ -127,6 +185,26 @@ func (c *Log2DBConfig) Validate() error {
 	return nil
 }
 
+// SinkTableNames returns the tables written by the pg_table sinks, in
+// sink order.
+func (c *Log2DBConfig) SinkTableNames() []string {
+	if len(c.Sinks) == 0 {
+		return []string{c.DBTableName}
+	}
+	var names []string
+	for _, sc := range c.Sinks {
+		if sc.Type != SinkTypePGTable {
+			continue
+		}
+		if sc.TableName == "" {
+			names = append(names, c.DBTableName)
+		} else {
+			names = append(names, sc.TableName)
+		}
+	}
+	return names
+}
+
 // ConnectionString returns a PostgreSQL connection string.
 func (c *Log2DBConfig) ConnectionString() string {
 	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
@@ -14,8 +14,18 @@ const (
 	LOC_INSERT_COUNT  = "SHD_L2D_023"
 )
 
-// EnsureTable creates the target table if it doesn't exist.
+// EnsureTable creates the tables of all PG table sinks if they don't exist.
 func (s *Log2DBService) EnsureTable(ctx context.Context) error {
+	for _, t := range s.tableSinks() {
+		if err := t.EnsureTable(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureTable creates the sink's table if it doesn't exist.
+func (k *PGTableSink) EnsureTable(ctx context.Context) error {
 	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 		id               VARCHAR(40) PRIMARY KEY,
 		entry_type       VARCHAR(20) NOT NULL,
@@ -31,24 +41,24 @@ func (s *Log2DBService) EnsureTable(ctx context.Context) error {
 		remarks          TEXT,
 		created_at       TIMESTAMPTZ NOT NULL,
 		UNIQUE(log_filename, log_line_num)
-	)`, s.config.DBTableName)
+	)`, k.tableName)
 
-	if _, err := s.db.ExecContext(ctx, stmt); err != nil {
-		return fmt.Errorf("failed to create table %s: %w (%s)", s.config.DBTableName, err, LOC_INSERT_TABLE)
+	if _, err := k.db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to create table %s: %w (%s)", k.tableName, err, LOC_INSERT_TABLE)
 	}
 
 	// Create indexes for common queries
 	indexes := []string{
 		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_filename ON %s (log_filename)`,
-			s.config.DBTableName, s.config.DBTableName),
+			k.tableName, k.tableName),
 		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_entry_type ON %s (entry_type)`,
-			s.config.DBTableName, s.config.DBTableName),
+			k.tableName, k.tableName),
 		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_created_at ON %s (created_at)`,
-			s.config.DBTableName, s.config.DBTableName),
+			k.tableName, k.tableName),
 	}
 
 	for _, idx := range indexes {
-		if _, err := s.db.ExecContext(ctx, idx); err != nil {
+		if _, err := k.db.ExecContext(ctx, idx); err != nil {
 			return fmt.Errorf("failed to create index: %w (%s)", err, LOC_INSERT_TABLE)
 		}
 	}
@@ -58,14 +68,19 @@ func (s *Log2DBService) EnsureTable(ctx context.Context) error {
 
 const batchSize = 100
 
+// InsertBatch inserts a slice of LogEntry records into db_table_name.
+func (s *Log2DBService) InsertBatch(ctx context.Context, entries []LogEntry) (int, error) {
+	return NewPGTableSink(s.db, s.config.DBTableName).InsertBatch(ctx, entries)
+}
+
 // InsertBatch inserts a slice of LogEntry records using a transaction.
 // Uses multi-row INSERT with ON CONFLICT DO NOTHING for idempotency.
-func (s *Log2DBService) InsertBatch(ctx context.Context, entries []LogEntry) (int, error) {
+func (k *PGTableSink) InsertBatch(ctx context.Context, entries []LogEntry) (int, error) {
 	if len(entries) == 0 {
 		return 0, nil
 	}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	tx, err := k.db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, fmt.Errorf("failed to begin transaction: %w (%s)", err, LOC_INSERT_BATCH)
 	}
@@ -165,7 +180,7 @@ func (s *Log2DBService) InsertBatch(ctx context.Context, entries []LogEntry) (in
 			error_msg, remarks, created_at)
 			VALUES %s
 			ON CONFLICT (log_filename, log_line_num) DO NOTHING`,
-			s.config.DBTableName,
+			k.tableName,
 			strings.Join(valueStrings, ","),
 		)
 
@@ -185,20 +200,36 @@ func (s *Log2DBService) InsertBatch(ctx context.Context, entries []LogEntry) (in
 	return totalInserted, nil
 }
 
-// TruncateTable removes all rows from the target table (for reload).
+// TruncateTable removes all rows from the tables of all PG table sinks
+// (for reload).
 func (s *Log2DBService) TruncateTable(ctx context.Context) error {
-	stmt := fmt.Sprintf("TRUNCATE TABLE %s", s.config.DBTableName)
-	if _, err := s.db.ExecContext(ctx, stmt); err != nil {
-		return fmt.Errorf("failed to truncate table %s: %w (%s)", s.config.DBTableName, err, LOC_INSERT_TRUNC)
+	for _, t := range s.tableSinks() {
+		if err := t.Truncate(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Truncate removes all rows from the sink's table.
+func (k *PGTableSink) Truncate(ctx context.Context) error {
+	stmt := fmt.Sprintf("TRUNCATE TABLE %s", k.tableName)
+	if _, err := k.db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to truncate table %s: %w (%s)", k.tableName, err, LOC_INSERT_TRUNC)
 	}
 	return nil
 }
 
-// CountEntries returns the total number of rows in the target table.
+// CountEntries returns the total number of rows in db_table_name.
 func (s *Log2DBService) CountEntries(ctx context.Context) (int, error) {
+	return NewPGTableSink(s.db, s.config.DBTableName).Count(ctx)
+}
+
+// Count returns the total number of rows in the sink's table.
+func (k *PGTableSink) Count(ctx context.Context) (int, error) {
 	var count int
-	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", s.config.DBTableName)
-	if err := s.db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", k.tableName)
+	if err := k.db.QueryRowContext(ctx, query).Scan(&count); err != nil {
 		return 0, fmt.Errorf("failed to count entries: %w (%s)", err, LOC_INSERT_COUNT)
 	}
 	return count, nil
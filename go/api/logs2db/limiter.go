@@ -0,0 +1,111 @@
+package logs2db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Location codes for ingest limiting
+const (
+	LOC_LIMIT_WAIT = "SHD_L2D_090"
+)
+
+// rateWindow is the period over which the current ingest rate is measured
+const rateWindow = 10 * time.Second
+
+// ingestLimiter throttles the rows written to the sinks so that a backlog
+// of large files does not starve the primary workload of the database. It
+// is shared by everything that writes in the process (RunLoop, Reload);
+// reload refuses to run next to the daemon, so one process writes at a
+// time and max_insert_rate holds for the database.
+type ingestLimiter struct {
+	limiter *rate.Limiter // nil: no rate limit
+
+	mu      sync.Mutex
+	buckets [rateWindow / time.Second]int64 // rows written per second, ring
+	times   [rateWindow / time.Second]int64 // unix second of each bucket
+	waits   int64                           // writes that had to wait
+}
+
+// newIngestLimiter returns a limiter allowing maxRate rows/sec; 0
+// disables the limit.
+func newIngestLimiter(maxRate int) *ingestLimiter {
+	l := &ingestLimiter{}
+	if maxRate > 0 {
+		// A burst of one second of rows: chunks are never larger
+		l.limiter = rate.NewLimiter(rate.Limit(maxRate), maxRate)
+	}
+	return l
+}
+
+// chunkSize is the largest number of rows acquire accepts at once, or 0
+// when writes need not be split
+func (l *ingestLimiter) chunkSize() int {
+	if l.limiter == nil {
+		return 0
+	}
+	return l.limiter.Burst()
+}
+
+// acquire blocks until n rows may be written
+func (l *ingestLimiter) acquire(ctx context.Context, n int) error {
+	if l.limiter == nil {
+		return nil
+	}
+	r := l.limiter.ReserveN(time.Now(), n)
+	if !r.OK() {
+		return fmt.Errorf("write of %d rows exceeds max_insert_rate burst %d (%s)",
+			n, l.limiter.Burst(), LOC_LIMIT_WAIT)
+	}
+	delay := r.Delay()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	l.waits++
+	l.mu.Unlock()
+	return nil
+}
+
+// record counts n rows written now
+func (l *ingestLimiter) record(n int) {
+	now := time.Now().Unix()
+	i := now % int64(len(l.buckets))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.times[i] != now {
+		l.times[i] = now
+		l.buckets[i] = 0
+	}
+	l.buckets[i] += int64(n)
+}
+
+// currentRate returns the rows/sec written over the last rateWindow, and
+// the number of writes that were throttled since the service started
+func (l *ingestLimiter) currentRate() (float64, int64) {
+	now := time.Now().Unix()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var rows int64
+	for i, t := range l.times {
+		if now-t < int64(len(l.buckets)) {
+			rows += l.buckets[i]
+		}
+	}
+	return float64(rows) / rateWindow.Seconds(), l.waits
+}
@@ -0,0 +1,94 @@
+package logs2db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIngestLimiterAcquire(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unlimited", func(t *testing.T) {
+		l := newIngestLimiter(0)
+		if l.chunkSize() != 0 {
+			t.Errorf("chunkSize = %d, want 0", l.chunkSize())
+		}
+		if err := l.acquire(ctx, 1000000); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+	})
+
+	t.Run("over burst", func(t *testing.T) {
+		l := newIngestLimiter(100)
+		if l.chunkSize() != 100 {
+			t.Errorf("chunkSize = %d, want 100", l.chunkSize())
+		}
+		if err := l.acquire(ctx, 101); err == nil {
+			t.Error("acquired more rows than the burst")
+		}
+	})
+
+	// The first second of rows goes at once, the next waits its share
+	t.Run("throttled", func(t *testing.T) {
+		l := newIngestLimiter(1000)
+		if err := l.acquire(ctx, 1000); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if _, waits := l.currentRate(); waits != 0 {
+			t.Errorf("waits = %d, want 0", waits)
+		}
+
+		start := time.Now()
+		if err := l.acquire(ctx, 100); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+			t.Errorf("acquire returned after %v, want about 100ms", elapsed)
+		}
+		if _, waits := l.currentRate(); waits != 1 {
+			t.Errorf("waits = %d, want 1", waits)
+		}
+	})
+
+	// A cancelled wait gives its rows back
+	t.Run("cancelled", func(t *testing.T) {
+		l := newIngestLimiter(10)
+		if err := l.acquire(ctx, 10); err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if err := l.acquire(cctx, 10); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("acquire = %v, want deadline exceeded", err)
+		}
+		if tokens := l.limiter.Tokens(); tokens < -1 {
+			t.Errorf("tokens = %v after the cancelled wait, want about 0", tokens)
+		}
+	})
+}
+
+func TestIngestLimiterCurrentRate(t *testing.T) {
+	l := newIngestLimiter(0)
+	if rate, _ := l.currentRate(); rate != 0 {
+		t.Errorf("rate = %v, want 0", rate)
+	}
+
+	l.record(30)
+	l.record(20)
+	if rate, _ := l.currentRate(); rate != 5 {
+		t.Errorf("rate = %v, want 5 (50 rows over 10s)", rate)
+	}
+
+	// Rows older than the window are not counted
+	now := time.Now().Unix()
+	old := (now - 5) % int64(len(l.buckets))
+	l.mu.Lock()
+	l.times[old] = now - int64(len(l.buckets)) - 1
+	l.buckets[old] = 1000
+	l.mu.Unlock()
+	if rate, _ := l.currentRate(); rate != 5 {
+		t.Errorf("rate = %v, want 5 without the old rows", rate)
+	}
+}
@@ -92,9 +92,20 @@ func (s *Log2DBService) DiscoverLogFiles() ([]string, error) {
 // ScanFile reads a single log file starting from the given line offset,
 // parses each line as JSON, extracts mapped fields, and returns LogEntry slices.
 func (s *Log2DBService) ScanFile(ctx context.Context, filePath string, startLine int) ([]LogEntry, int, error) {
+	entries, lineNum, _, err := s.scanFile(ctx, filePath, startLine, 0)
+	return entries, lineNum, err
+}
+
+// scanFile is ScanFile reading at most maxLines new lines (0 for all). It
+// also returns whether it stopped at maxLines, i.e. the file may have more.
+func (s *Log2DBService) scanFile(
+	ctx context.Context,
+	filePath string,
+	startLine int,
+	maxLines int) ([]LogEntry, int, bool, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to open log file %s: %w (%s)", filePath, err, LOC_SCAN_FILE)
+		return nil, 0, false, fmt.Errorf("failed to open log file %s: %w (%s)", filePath, err, LOC_SCAN_FILE)
 	}
 	defer f.Close()
 
@@ -111,11 +122,15 @@ func (s *Log2DBService) ScanFile(ctx context.Context, filePath string, startLine
 		if lineNum%1000 == 0 {
 			select {
 			case <-ctx.Done():
-				return entries, lineNum, ctx.Err()
+				return entries, lineNum, false, ctx.Err()
 			default:
 			}
 		}
 
+		if maxLines > 0 && lineNum-startLine >= maxLines {
+			return entries, lineNum, true, nil
+		}
+
 		lineNum++
 		if lineNum <= startLine {
 			continue
@@ -166,10 +181,10 @@ func (s *Log2DBService) ScanFile(ctx context.Context, filePath string, startLine
 	}
 
 	if err := scanner.Err(); err != nil {
-		return entries, lineNum, fmt.Errorf("error reading log file %s: %w (%s)", filePath, err, LOC_SCAN_FILE)
+		return entries, lineNum, false, fmt.Errorf("error reading log file %s: %w (%s)", filePath, err, LOC_SCAN_FILE)
 	}
 
-	return entries, lineNum, nil
+	return entries, lineNum, false, nil
 }
 
 // CountFileLines counts the total number of lines in a file.
@@ -24,6 +24,7 @@ type ScanResult struct {
 	LinesInserted int
 	LinesSkipped  int // already loaded
 	LinesFailed   int // malformed JSON
+	FilesDeferred int // stopped at max_lines_per_file, continued next cycle
 	Duration      time.Duration
 }
 
@@ -32,6 +33,10 @@ type RuntimeStats struct {
 	StartTime        time.Time
 	EntriesSinceStart atomic.Int64
 	TotalErrors       atomic.Int64
+
+	// Set by GetStats
+	InsertRate      float64 // rows/sec written over the last 10 seconds
+	ThrottledWrites int64   // writes held back by max_insert_rate
 }
 
 // Log2DBService is the main service that coordinates scanning, parsing,
@@ -42,6 +47,10 @@ type Log2DBService struct {
 	state  *StateManager
 	logger *slog.Logger
 	stats  *RuntimeStats
+	sinks  []Sink // sinks[0] is the primary
+
+	// limiter is shared by RunLoop and Reload
+	limiter *ingestLimiter
 }
 
 // NewService creates a new Log2DBService with a logger.
@@ -53,6 +62,7 @@ func NewService(config *Log2DBConfig, logger *slog.Logger) *Log2DBService {
 		stats: &RuntimeStats{
 			StartTime: time.Now(),
 		},
+		limiter: newIngestLimiter(config.MaxInsertRate),
 	}
 }
 
@@ -63,8 +73,8 @@ func NewServiceWithDB(config *Log2DBConfig, db *sql.DB, logger *slog.Logger) *Lo
 	return s
 }
 
-// Initialize opens the DB connection (if not provided), creates the target
-// table if needed, and loads the state file.
+// Initialize opens the DB connection (if not provided), creates the sinks
+// and their tables if needed, and loads the state file.
 func (s *Log2DBService) Initialize(ctx context.Context) error {
 	if s.db == nil {
 		db, err := sql.Open("postgres", s.config.ConnectionString())
@@ -82,6 +92,12 @@ func (s *Log2DBService) Initialize(ctx context.Context) error {
 		s.db = db
 	}
 
+	sinks, err := s.buildSinks()
+	if err != nil {
+		return err
+	}
+	s.sinks = sinks
+
 	if err := s.EnsureTable(ctx); err != nil {
 		return err
 	}
@@ -102,8 +118,11 @@ func (s *Log2DBService) Close() {
 
 // GetStats returns a copy of the runtime statistics.
 func (s *Log2DBService) GetStats() RuntimeStats {
+	insertRate, throttled := s.limiter.currentRate()
 	return RuntimeStats{
-		StartTime: s.stats.StartTime,
+		StartTime:       s.stats.StartTime,
+		InsertRate:      insertRate,
+		ThrottledWrites: throttled,
 	}
 }
 
@@ -124,68 +143,115 @@ func (s *Log2DBService) RunOnce(ctx context.Context) (*ScanResult, error) {
 			return result, ctx.Err()
 		default:
 		}
+		s.scanAndWrite(ctx, filePath, result)
+	}
 
-		basename := filepath.Base(filePath)
-		lastLine := s.state.GetLastLine(basename)
+	result.Duration = time.Since(start)
+	return result, nil
+}
 
-		entries, lastLineRead, err := s.ScanFile(ctx, filePath, lastLine)
-		if err != nil {
-			s.logger.Error("Failed to scan file",
-				"file", basename,
-				"error", err,
-				"loc", LOC_SVC_SCAN)
-			s.stats.TotalErrors.Add(1)
-			continue
-		}
+// scanAndWrite reads the new lines of one file, at most max_lines_per_file
+// of them, writes them to the sinks and advances the file's state. Errors
+// are logged and counted. It returns true if the file has lines left for
+// the next cycle.
+func (s *Log2DBService) scanAndWrite(ctx context.Context, filePath string, result *ScanResult) bool {
+	basename := filepath.Base(filePath)
+	lastLine := s.state.GetLastLine(basename)
 
-		result.FilesScanned++
-		result.LinesSkipped += lastLine
+	entries, lastLineRead, more, err := s.scanFile(ctx, filePath, lastLine, s.config.MaxLinesPerFile)
+	if err != nil {
+		s.logger.Error("Failed to scan file",
+			"file", basename,
+			"error", err,
+			"loc", LOC_SVC_SCAN)
+		s.stats.TotalErrors.Add(1)
+		return false
+	}
 
-		if len(entries) == 0 {
-			// Update state even if no new entries (file might have been read to end)
-			if lastLineRead > lastLine {
-				s.state.SetLastLine(basename, lastLineRead)
-			}
-			continue
+	result.FilesScanned++
+	result.LinesSkipped += lastLine
+	if more {
+		result.FilesDeferred++
+	}
+
+	if len(entries) == 0 {
+		// Update state even if no new entries (file might have been read to end)
+		if lastLineRead > lastLine {
+			s.state.SetLastLine(basename, lastLineRead)
 		}
+		return more
+	}
 
-		// Count failed entries
-		for _, e := range entries {
-			if e.ErrorMsg != "" {
-				result.LinesFailed++
-			}
+	// Count failed entries
+	for _, e := range entries {
+		if e.ErrorMsg != "" {
+			result.LinesFailed++
 		}
+	}
+
+	// Under max_insert_rate the entries are written in chunks of at most
+	// one second of rows. A failed chunk keeps the state at the line
+	// before it, so the next cycle resumes there.
+	chunkSize := s.limiter.chunkSize()
+	if chunkSize == 0 {
+		chunkSize = len(entries)
+	}
+	for start := 0; start < len(entries); start += chunkSize {
+		end := min(start+chunkSize, len(entries))
+		chunk := entries[start:end]
 
-		inserted, err := s.InsertBatch(ctx, entries)
+		inserted, err := s.writeSinks(ctx, basename, chunk)
 		if err != nil {
-			s.logger.Error("Failed to insert entries",
+			s.logger.Error("Failed to write entries",
 				"file", basename,
-				"count", len(entries),
+				"count", len(chunk),
 				"error", err,
 				"loc", LOC_SVC_SCAN)
 			s.stats.TotalErrors.Add(1)
-			continue
+			if start > 0 {
+				s.saveLastLine(basename, chunk[0].LogLineNum-1)
+			}
+			return false
 		}
 
 		result.LinesInserted += inserted
 		s.stats.EntriesSinceStart.Add(int64(inserted))
-
-		// Update state with the last line we read
-		if err := s.state.SetLastLine(basename, lastLineRead); err != nil {
-			s.logger.Error("Failed to save state",
-				"file", basename,
-				"error", err,
-				"loc", LOC_SVC_SCAN)
-		}
 	}
 
-	result.Duration = time.Since(start)
-	return result, nil
+	// Update state with the last line we read
+	s.saveLastLine(basename, lastLineRead)
+	return more
+}
+
+// saveLastLine records the last line of a file that was loaded
+func (s *Log2DBService) saveLastLine(basename string, lastLine int) {
+	if err := s.state.SetLastLine(basename, lastLine); err != nil {
+		s.logger.Error("Failed to save state",
+			"file", basename,
+			"error", err,
+			"loc", LOC_SVC_SCAN)
+	}
 }
 
-// RunLoop starts the polling loop at the configured frequency.
-// Blocks until ctx is cancelled.
+// RunLoop loads new log lines until ctx is cancelled: on file events in
+// the fsnotify watch mode, otherwise by polling at the configured
+// frequency. The watch mode falls back to polling if the watcher fails.
 func (s *Log2DBService) RunLoop(ctx context.Context) error {
+	if s.config.WatchMode == WatchModeFsnotify {
+		err := s.runWatchLoop(ctx)
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+		s.logger.Warn("File watcher unavailable, falling back to polling",
+			"error", err,
+			"poll_interval_sec", s.config.SyncFreqSec,
+			"loc", LOC_SVC_RUN)
+	}
+	return s.runPollLoop(ctx)
+}
+
+// runPollLoop scans all files at the configured frequency
+func (s *Log2DBService) runPollLoop(ctx context.Context) error {
 	ticker := time.NewTicker(time.Duration(s.config.SyncFreqSec) * time.Second)
 	defer ticker.Stop()
 
@@ -193,11 +259,7 @@ func (s *Log2DBService) RunLoop(ctx context.Context) error {
 	if result, err := s.RunOnce(ctx); err != nil {
 		s.logger.Error("Initial scan failed", "error", err, "loc", LOC_SVC_RUN)
 	} else if result.LinesInserted > 0 {
-		s.logger.Info("Initial scan complete",
-			"files", result.FilesScanned,
-			"inserted", result.LinesInserted,
-			"failed", result.LinesFailed,
-			"duration", result.Duration)
+		s.logCycle("Initial scan complete", result)
 	}
 
 	for {
@@ -211,20 +273,34 @@ func (s *Log2DBService) RunLoop(ctx context.Context) error {
 				s.logger.Error("Scan cycle failed", "error", err, "loc", LOC_SVC_RUN)
 				s.stats.TotalErrors.Add(1)
 			} else if result.LinesInserted > 0 {
-				s.logger.Info("Scan cycle complete",
-					"files", result.FilesScanned,
-					"inserted", result.LinesInserted,
-					"failed", result.LinesFailed,
-					"duration", result.Duration)
+				s.logCycle("Scan cycle complete", result)
 			}
 		}
 	}
 }
 
-// Reload truncates the table, resets state, and reloads all files.
+// logCycle logs the result of a scan cycle with the current insert rate
+func (s *Log2DBService) logCycle(msg string, result *ScanResult) {
+	insertRate, throttled := s.limiter.currentRate()
+	s.logger.Info(msg,
+		"files", result.FilesScanned,
+		"inserted", result.LinesInserted,
+		"failed", result.LinesFailed,
+		"deferred", result.FilesDeferred,
+		"duration", result.Duration,
+		"rows_per_sec", insertRate,
+		"throttled_writes", throttled)
+}
+
+// Reload truncates the sink tables, resets state, and reloads all files
+// into every sink.
 func (s *Log2DBService) Reload(ctx context.Context) (*ScanResult, error) {
-	s.logger.Info("Reloading: truncating table and rescanning all files",
-		"table", s.config.DBTableName,
+	tables := make([]string, 0, len(s.sinks))
+	for _, t := range s.tableSinks() {
+		tables = append(tables, t.tableName)
+	}
+	s.logger.Info("Reloading: truncating tables and rescanning all files",
+		"tables", tables,
 		"loc", LOC_SVC_RELOAD)
 
 	if err := s.TruncateTable(ctx); err != nil {
@@ -235,5 +311,25 @@ func (s *Log2DBService) Reload(ctx context.Context) (*ScanResult, error) {
 		return nil, fmt.Errorf("failed to reset state: %w (%s)", err, LOC_SVC_RELOAD)
 	}
 
-	return s.RunOnce(ctx)
+	// Scan until no file is left over by max_lines_per_file
+	total := &ScanResult{}
+	for {
+		result, err := s.RunOnce(ctx)
+		if result != nil {
+			total.FilesScanned = max(total.FilesScanned, result.FilesScanned)
+			total.LinesInserted += result.LinesInserted
+			total.LinesFailed += result.LinesFailed
+			total.Duration += result.Duration
+		}
+		if err != nil {
+			return total, err
+		}
+		if result.FilesDeferred == 0 {
+			return total, nil
+		}
+		s.logger.Info("Reload in progress",
+			"inserted", total.LinesInserted,
+			"files_remaining", result.FilesDeferred,
+			"loc", LOC_SVC_RELOAD)
+	}
 }
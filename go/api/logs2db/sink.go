@@ -0,0 +1,260 @@
+package logs2db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Location codes for sink operations
+const (
+	LOC_SINK_CONFIG = "SHD_L2D_070"
+	LOC_SINK_WRITE  = "SHD_L2D_071"
+	LOC_SINK_STDOUT = "SHD_L2D_072"
+)
+
+// Sink types accepted in the [[sinks]] config
+const (
+	SinkTypePGTable = "pg_table"
+	SinkTypeStdout  = "stdout"
+)
+
+// Sink receives the parsed entries of each scan cycle. The first configured
+// sink is the primary: the state file only advances once the primary has
+// accepted the entries. The other sinks are best effort.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, rows []LogEntry) error
+}
+
+// countingSink is implemented by sinks that know how many of the rows were
+// new (e.g. the PG table sink skips lines that are already loaded).
+type countingSink interface {
+	WriteCount(ctx context.Context, rows []LogEntry) (int, error)
+}
+
+// writeSink writes rows to sink and returns the number of rows it took
+func writeSink(ctx context.Context, sink Sink, rows []LogEntry) (int, error) {
+	if cs, ok := sink.(countingSink); ok {
+		return cs.WriteCount(ctx, rows)
+	}
+	if err := sink.Write(ctx, rows); err != nil {
+		return 0, err
+	}
+	return len(rows), nil
+}
+
+// PGTableSink writes entries to a PostgreSQL table. It is the default sink.
+type PGTableSink struct {
+	db        *sql.DB
+	tableName string
+}
+
+// NewPGTableSink returns a sink that writes to tableName
+func NewPGTableSink(db *sql.DB, tableName string) *PGTableSink {
+	return &PGTableSink{db: db, tableName: tableName}
+}
+
+func (k *PGTableSink) Name() string {
+	return SinkTypePGTable + ":" + k.tableName
+}
+
+func (k *PGTableSink) Write(ctx context.Context, rows []LogEntry) error {
+	_, err := k.InsertBatch(ctx, rows)
+	return err
+}
+
+func (k *PGTableSink) WriteCount(ctx context.Context, rows []LogEntry) (int, error) {
+	return k.InsertBatch(ctx, rows)
+}
+
+// StdoutSink writes each entry as one JSON object per line.
+type StdoutSink struct {
+	out io.Writer
+}
+
+// NewStdoutSink returns a sink that writes to out (os.Stdout if nil)
+func NewStdoutSink(out io.Writer) *StdoutSink {
+	if out == nil {
+		out = os.Stdout
+	}
+	return &StdoutSink{out: out}
+}
+
+func (k *StdoutSink) Name() string {
+	return SinkTypeStdout
+}
+
+// stdoutRow is the JSON form of a LogEntry
+type stdoutRow struct {
+	ID              string          `json:"id"`
+	EntryType       string          `json:"entry_type"`
+	Message         string          `json:"message"`
+	SysPrompt       string          `json:"sys_prompt,omitempty"`
+	SysPromptNLines int             `json:"sys_prompt_nlines,omitempty"`
+	CallerFilename  string          `json:"caller_filename,omitempty"`
+	CallerLine      int             `json:"caller_line,omitempty"`
+	JSONObj         json.RawMessage `json:"json_obj"`
+	LogFilename     string          `json:"log_filename"`
+	LogLineNum      int             `json:"log_line_num"`
+	ErrorMsg        string          `json:"error_msg,omitempty"`
+	Remarks         string          `json:"remarks,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
+func (k *StdoutSink) Write(ctx context.Context, rows []LogEntry) error {
+	enc := json.NewEncoder(k.out)
+	for _, e := range rows {
+		jsonObj := json.RawMessage(e.JSONObj)
+		if len(jsonObj) == 0 {
+			jsonObj = json.RawMessage("{}")
+		}
+		row := stdoutRow{
+			ID:              e.ID,
+			EntryType:       e.EntryType,
+			Message:         e.Message,
+			SysPrompt:       e.SysPrompt,
+			SysPromptNLines: e.SysPromptNLines,
+			CallerFilename:  e.CallerFilename,
+			CallerLine:      e.CallerLine,
+			JSONObj:         jsonObj,
+			LogFilename:     e.LogFilename,
+			LogLineNum:      e.LogLineNum,
+			ErrorMsg:        e.ErrorMsg,
+			Remarks:         e.Remarks,
+			CreatedAt:       e.CreatedAt,
+		}
+		if err := enc.Encode(row); err != nil {
+			return fmt.Errorf("failed to write entry %s:%d: %w (%s)",
+				e.LogFilename, e.LogLineNum, err, LOC_SINK_STDOUT)
+		}
+	}
+	return nil
+}
+
+// entryTypeFilter passes only the entries of the given types to its sink,
+// e.g. to copy errors to a separate table.
+type entryTypeFilter struct {
+	sink  Sink
+	types map[string]bool
+}
+
+func newEntryTypeFilter(sink Sink, types []string) *entryTypeFilter {
+	f := &entryTypeFilter{sink: sink, types: make(map[string]bool, len(types))}
+	for _, t := range types {
+		f.types[t] = true
+	}
+	return f
+}
+
+func (f *entryTypeFilter) Name() string {
+	return f.sink.Name()
+}
+
+func (f *entryTypeFilter) filter(rows []LogEntry) []LogEntry {
+	var kept []LogEntry
+	for _, e := range rows {
+		if f.types[e.EntryType] {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func (f *entryTypeFilter) Write(ctx context.Context, rows []LogEntry) error {
+	kept := f.filter(rows)
+	if len(kept) == 0 {
+		return nil
+	}
+	return f.sink.Write(ctx, kept)
+}
+
+func (f *entryTypeFilter) WriteCount(ctx context.Context, rows []LogEntry) (int, error) {
+	kept := f.filter(rows)
+	if len(kept) == 0 {
+		return 0, nil
+	}
+	return writeSink(ctx, f.sink, kept)
+}
+
+// buildSinks creates the sinks of the [[sinks]] config in order. Without
+// any, the entries go to db_table_name as before.
+func (s *Log2DBService) buildSinks() ([]Sink, error) {
+	if len(s.config.Sinks) == 0 {
+		return []Sink{NewPGTableSink(s.db, s.config.DBTableName)}, nil
+	}
+
+	sinks := make([]Sink, 0, len(s.config.Sinks))
+	for i, sc := range s.config.Sinks {
+		var sink Sink
+		switch sc.Type {
+		case SinkTypePGTable:
+			tableName := sc.TableName
+			if tableName == "" {
+				tableName = s.config.DBTableName
+			}
+			sink = NewPGTableSink(s.db, tableName)
+		case SinkTypeStdout:
+			sink = NewStdoutSink(nil)
+		default:
+			return nil, fmt.Errorf("sinks[%d]: unknown sink type %q (%s)", i, sc.Type, LOC_SINK_CONFIG)
+		}
+
+		if len(sc.EntryTypes) > 0 {
+			sink = newEntryTypeFilter(sink, sc.EntryTypes)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// tableSinks returns the PG table sinks among the configured sinks
+func (s *Log2DBService) tableSinks() []*PGTableSink {
+	var tables []*PGTableSink
+	for _, sink := range s.sinks {
+		if f, ok := sink.(*entryTypeFilter); ok {
+			sink = f.sink
+		}
+		if t, ok := sink.(*PGTableSink); ok {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// writeSinks writes entries to all sinks, once the ingest limiter lets
+// it. Only a failure of the primary sink is returned; the others are
+// logged so they cannot hold back the primary. It returns the number of
+// entries the primary took.
+func (s *Log2DBService) writeSinks(ctx context.Context, basename string, entries []LogEntry) (int, error) {
+	if len(s.sinks) == 0 {
+		return 0, fmt.Errorf("no sinks, service not initialized (%s)", LOC_SINK_WRITE)
+	}
+
+	if err := s.limiter.acquire(ctx, len(entries)); err != nil {
+		return 0, err
+	}
+
+	written, err := writeSink(ctx, s.sinks[0], entries)
+	if err != nil {
+		return 0, err
+	}
+	s.limiter.record(len(entries))
+
+	for _, sink := range s.sinks[1:] {
+		if err := sink.Write(ctx, entries); err != nil {
+			s.logger.Warn("Failed to write to secondary sink",
+				"sink", sink.Name(),
+				"file", basename,
+				"count", len(entries),
+				"error", err,
+				"loc", LOC_SINK_WRITE)
+			s.stats.TotalErrors.Add(1)
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,162 @@
+package logs2db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStdoutSinkWrite(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewStdoutSink(&out)
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	rows := []LogEntry{
+		{ID: "a1", EntryType: "info", Message: "started", JSONObj: []byte(`{"msg":"started"}`),
+			LogFilename: "app.log", LogLineNum: 1, CreatedAt: created},
+		{ID: "a2", EntryType: "error", Message: "failed", ErrorMsg: "boom", CallerFilename: "main.go", CallerLine: 42,
+			LogFilename: "app.log", LogLineNum: 2, CreatedAt: created},
+	}
+	if err := sink.Write(context.Background(), rows); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// One JSON object per line, empty fields left out, json_obj never empty
+	want := `{"id":"a1","entry_type":"info","message":"started","json_obj":{"msg":"started"},` +
+		`"log_filename":"app.log","log_line_num":1,"created_at":"2026-01-02T03:04:05Z"}` + "\n" +
+		`{"id":"a2","entry_type":"error","message":"failed","caller_filename":"main.go","caller_line":42,` +
+		`"json_obj":{},"log_filename":"app.log","log_line_num":2,"error_msg":"boom","created_at":"2026-01-02T03:04:05Z"}` + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+	if sink.Name() != SinkTypeStdout {
+		t.Errorf("name = %q", sink.Name())
+	}
+}
+
+// recordingSink records the rows written to it
+type recordingSink struct {
+	rows []LogEntry
+	err  error
+}
+
+func (r *recordingSink) Name() string { return "recording" }
+
+func (r *recordingSink) Write(ctx context.Context, rows []LogEntry) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.rows = append(r.rows, rows...)
+	return nil
+}
+
+func TestEntryTypeFilter(t *testing.T) {
+	ctx := context.Background()
+	rows := []LogEntry{{ID: "1", EntryType: "info"}, {ID: "2", EntryType: "error"}, {ID: "3", EntryType: "warn"}}
+
+	inner := &recordingSink{}
+	filter := newEntryTypeFilter(inner, []string{"error", "warn"})
+	n, err := writeSink(ctx, filter, rows)
+	if err != nil {
+		t.Fatalf("writeSink: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("written = %d, want 2", n)
+	}
+	var ids []string
+	for _, e := range inner.rows {
+		ids = append(ids, e.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"2", "3"}) {
+		t.Errorf("rows = %v, want [2 3]", ids)
+	}
+
+	// No matching entry doesn't reach the sink, even a failing one
+	failing := newEntryTypeFilter(&recordingSink{err: errors.New("down")}, []string{"debug"})
+	if err := failing.Write(ctx, rows); err != nil {
+		t.Errorf("Write without matching entries: %v", err)
+	}
+}
+
+func TestBuildSinks(t *testing.T) {
+	service := func(sinks ...SinkConfig) *Log2DBService {
+		return &Log2DBService{config: &Log2DBConfig{DBTableName: "logs", Sinks: sinks}}
+	}
+
+	sinks, err := service().buildSinks()
+	if err != nil || len(sinks) != 1 || sinks[0].Name() != "pg_table:logs" {
+		t.Fatalf("default sinks = %v, %v, want pg_table:logs", sinks, err)
+	}
+
+	s := service(
+		SinkConfig{Type: SinkTypePGTable},
+		SinkConfig{Type: SinkTypePGTable, TableName: "errors", EntryTypes: []string{"error"}},
+		SinkConfig{Type: SinkTypeStdout},
+	)
+	sinks, err = s.buildSinks()
+	if err != nil {
+		t.Fatalf("buildSinks: %v", err)
+	}
+	var names []string
+	for _, sink := range sinks {
+		names = append(names, sink.Name())
+	}
+	if want := []string{"pg_table:logs", "pg_table:errors", "stdout"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("sinks = %v, want %v", names, want)
+	}
+	if _, ok := sinks[1].(*entryTypeFilter); !ok {
+		t.Errorf("sink with entry_types = %T, want a filter", sinks[1])
+	}
+	s.sinks = sinks
+	if tables := s.tableSinks(); len(tables) != 2 || tables[1].tableName != "errors" {
+		t.Errorf("table sinks = %v", tables)
+	}
+
+	if _, err := service(SinkConfig{Type: "kafka"}).buildSinks(); err == nil ||
+		!strings.Contains(err.Error(), `unknown sink type "kafka"`) {
+		t.Errorf("unknown type error = %v", err)
+	}
+}
+
+// A failing secondary sink doesn't fail the write; a failing primary does
+func TestWriteSinks(t *testing.T) {
+	ctx := context.Background()
+	rows := []LogEntry{{ID: "1"}, {ID: "2"}}
+	service := func(sinks ...Sink) *Log2DBService {
+		return &Log2DBService{
+			config:  &Log2DBConfig{},
+			logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			stats:   &RuntimeStats{},
+			sinks:   sinks,
+			limiter: newIngestLimiter(0),
+		}
+	}
+
+	primary := &recordingSink{}
+	s := service(primary, &recordingSink{err: errors.New("down")})
+	n, err := s.writeSinks(ctx, "app.log", rows)
+	if err != nil || n != 2 || len(primary.rows) != 2 {
+		t.Errorf("writeSinks = %d, %v, primary got %d rows", n, err, len(primary.rows))
+	}
+	if got := s.stats.TotalErrors.Load(); got != 1 {
+		t.Errorf("errors = %d, want 1", got)
+	}
+
+	secondary := &recordingSink{}
+	s = service(&recordingSink{err: errors.New("down")}, secondary)
+	if _, err := s.writeSinks(ctx, "app.log", rows); err == nil {
+		t.Error("primary failure not returned")
+	}
+	if len(secondary.rows) != 0 {
+		t.Errorf("secondary got %d rows after the primary failed", len(secondary.rows))
+	}
+
+	if _, err := service().writeSinks(ctx, "app.log", rows); err == nil {
+		t.Error("wrote without sinks")
+	}
+}
@@ -0,0 +1,152 @@
+package logs2db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Location codes for watch operations
+const (
+	LOC_WATCH_START = "SHD_L2D_080"
+	LOC_WATCH_RUN   = "SHD_L2D_081"
+)
+
+// runWatchLoop scans the log files written to, on fsnotify events. Events
+// are debounced: the first event of a burst starts a timer of
+// watch_debounce_ms and the files written to until it fires are scanned
+// together, so a busy file is still scanned at least that often. The
+// offsets are tracked in the state file as in the polling loop.
+//
+// It returns an error if the watcher cannot be set up (e.g. inotify is
+// unavailable or the watch limit is reached) or fails later; RunLoop then
+// falls back to polling. It returns nil when ctx is cancelled.
+func (s *Log2DBService) runWatchLoop(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w (%s)", err, LOC_WATCH_START)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(s.config.LogFileDir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w (%s)", s.config.LogFileDir, err, LOC_WATCH_START)
+	}
+	s.logger.Info("Watching log directory",
+		"dir", s.config.LogFileDir,
+		"debounce_ms", s.config.WatchDebounceMs)
+
+	// Catch up with what was written while the service was down
+	if result, err := s.RunOnce(ctx); err != nil {
+		s.logger.Error("Initial scan failed", "error", err, "loc", LOC_WATCH_RUN)
+	} else if result.LinesInserted > 0 {
+		s.logCycle("Initial scan complete", result)
+	}
+
+	debounce := time.Duration(s.config.WatchDebounceMs) * time.Millisecond
+	pending := make(map[string]bool)
+	var timer *time.Timer
+	var timerC <-chan time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Shutting down log2db service")
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return fmt.Errorf("file watcher closed (%s)", LOC_WATCH_RUN)
+			}
+			// Hidden files are the state and PID files
+			if strings.HasPrefix(filepath.Base(event.Name), ".") ||
+				!event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			pending[event.Name] = true
+			if timerC == nil {
+				timer = time.NewTimer(debounce)
+				timerC = timer.C
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return fmt.Errorf("file watcher closed (%s)", LOC_WATCH_RUN)
+			}
+			if !errors.Is(err, fsnotify.ErrEventOverflow) {
+				return fmt.Errorf("file watcher failed: %w (%s)", err, LOC_WATCH_RUN)
+			}
+			// Events were lost; a full scan picks up whatever they were
+			s.logger.Warn("File watcher events overflowed, rescanning all files", "loc", LOC_WATCH_RUN)
+			s.stats.TotalErrors.Add(1)
+			if _, err := s.RunOnce(ctx); err != nil {
+				s.logger.Error("Scan cycle failed", "error", err, "loc", LOC_WATCH_RUN)
+			}
+
+		case <-timerC:
+			timerC = nil
+			deferred := s.scanPending(ctx, pending)
+			pending = make(map[string]bool)
+
+			// Files stopped at max_lines_per_file go on in the next
+			// burst, without waiting for another write
+			for _, path := range deferred {
+				pending[path] = true
+			}
+			if len(pending) > 0 {
+				timer = time.NewTimer(debounce)
+				timerC = timer.C
+			}
+		}
+	}
+}
+
+// scanPending scans the files written to, oldest first like RunOnce. It
+// returns the files that have lines left over by max_lines_per_file.
+func (s *Log2DBService) scanPending(ctx context.Context, pending map[string]bool) []string {
+	start := time.Now()
+	result := &ScanResult{}
+
+	type fileWithTime struct {
+		path    string
+		modTime time.Time
+	}
+	var files []fileWithTime
+	for path := range pending {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue // removed or rotated away since the event
+		}
+		files = append(files, fileWithTime{path: path, modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	var deferred []string
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if s.scanAndWrite(ctx, f.path, result) {
+			deferred = append(deferred, f.path)
+		}
+	}
+
+	result.Duration = time.Since(start)
+	if result.LinesInserted > 0 {
+		s.logCycle("Scan cycle complete", result)
+	}
+	return deferred
+}
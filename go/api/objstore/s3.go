@@ -0,0 +1,394 @@
+package objstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config configures an S3-compatible bucket (AWS S3, MinIO, R2, ...)
+type S3Config struct {
+	// Endpoint defaults to https://s3.<region>.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket as <endpoint>/<bucket>/<key> instead
+	// of <bucket>.<endpoint host>/<key>. Most S3-compatible servers need it.
+	PathStyle bool
+	// Prefix is prepended to every key, e.g. "attachments/"
+	Prefix     string
+	HTTPClient *http.Client
+}
+
+// S3ConfigFromEnv reads <prefix>S3_ENDPOINT, <prefix>S3_REGION,
+// <prefix>S3_BUCKET, <prefix>S3_ACCESS_KEY, <prefix>S3_SECRET_KEY,
+// <prefix>S3_PATH_STYLE ("true") and <prefix>S3_PREFIX.
+func S3ConfigFromEnv(prefix string) S3Config {
+	return S3Config{
+		Endpoint:  os.Getenv(prefix + "S3_ENDPOINT"),
+		Region:    os.Getenv(prefix + "S3_REGION"),
+		Bucket:    os.Getenv(prefix + "S3_BUCKET"),
+		AccessKey: os.Getenv(prefix + "S3_ACCESS_KEY"),
+		SecretKey: os.Getenv(prefix + "S3_SECRET_KEY"),
+		PathStyle: os.Getenv(prefix+"S3_PATH_STYLE") == "true",
+		Prefix:    os.Getenv(prefix + "S3_PREFIX"),
+	}
+}
+
+// S3Store talks to the S3 REST API directly, signing requests with AWS
+// Signature Version 4.
+type S3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Store validates the config. It does not contact the bucket.
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("s3 bucket, access key and secret key are required (SHD_OBS_063)")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q (SHD_OBS_073)", cfg.Endpoint)
+	}
+
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	return &S3Store{cfg: cfg, endpoint: endpoint, client: client}, nil
+}
+
+func (s *S3Store) Backend() string {
+	return BackendS3
+}
+
+// objectURL returns the URL of 'key' (already prefixed), or of the
+// bucket if key is empty.
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	path := u.Path
+	if s.cfg.PathStyle {
+		path += "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = path + "/" + key
+	u.RawPath = s.endpoint.Path
+	if s.cfg.PathStyle {
+		u.RawPath += "/" + uriEncode(s.cfg.Bucket, false)
+	}
+	u.RawPath += "/" + uriEncode(key, false)
+	return &u
+}
+
+func (s *S3Store) do(ctx context.Context, method string, key string, query url.Values,
+	body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	u := s.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 request (SHD_OBS_111): %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	signV4(req, s.cfg.Region, s.cfg.AccessKey, s.cfg.SecretKey, time.Now(), unsignedPayload)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s failed (SHD_OBS_122): %w", method, err)
+	}
+	return resp, nil
+}
+
+// s3Error reads an unsuccessful response
+func s3Error(op string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	var s3err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &s3err) == nil && s3err.Code != "" {
+		return fmt.Errorf("s3 %s failed, status:%d, code:%s, message:%s (SHD_OBS_135)",
+			op, resp.StatusCode, s3err.Code, s3err.Message)
+	}
+	return fmt.Errorf("s3 %s failed, status:%d (SHD_OBS_138)", op, resp.StatusCode)
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	if size < 0 {
+		return fmt.Errorf("s3 put requires the object size (SHD_OBS_146)")
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if size == 0 {
+		r = http.NoBody
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, s.cfg.Prefix+key, nil, r, size, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("put", resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if offset > 0 || length >= 0 {
+		if length < 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		} else if length == 0 {
+			return io.NopCloser(strings.NewReader("")), nil
+		} else {
+			header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+		}
+	}
+
+	resp, err := s.do(ctx, http.MethodGet, s.cfg.Prefix+key, nil, nil, 0, header)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		return nil, s3Error("get", resp)
+	}
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.cfg.Prefix+key, nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		mod_time, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return &ObjectInfo{Key: key, Size: resp.ContentLength, ModTime: mod_time}, nil
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("s3 head failed, status:%d (SHD_OBS_214)", resp.StatusCode)
+	}
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.cfg.Prefix+key, nil, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusNotFound {
+		return s3Error("delete", resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List uses ListObjectsV2, following continuation tokens
+func (s *S3Store) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.cfg.Prefix+prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return s3Error("list", resp)
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("invalid s3 list response (SHD_OBS_265): %w", err)
+		}
+
+		for _, obj := range result.Contents {
+			info := ObjectInfo{
+				Key:     strings.TrimPrefix(obj.Key, s.cfg.Prefix),
+				Size:    obj.Size,
+				ModTime: obj.LastModified,
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// unsignedPayload skips hashing the body, so uploads can be streamed
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// signV4 adds the AWS Signature Version 4 headers to req.
+func signV4(
+	req *http.Request,
+	region string,
+	access_key string,
+	secret_key string,
+	now time.Time,
+	payload_hash string) {
+	amz_date := now.UTC().Format("20060102T150405Z")
+	date := amz_date[:8]
+
+	req.Header.Set("X-Amz-Date", amz_date)
+	req.Header.Set("X-Amz-Content-Sha256", payload_hash)
+
+	// Sign host and all x-amz-* headers, plus content-type and range
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "range" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical_headers strings.Builder
+	for _, name := range names {
+		canonical_headers.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed_headers := strings.Join(names, ";")
+
+	canonical_uri := req.URL.EscapedPath()
+	if canonical_uri == "" {
+		canonical_uri = "/"
+	}
+	canonical_request := strings.Join([]string{
+		req.Method,
+		canonical_uri,
+		req.URL.RawQuery,
+		canonical_headers.String(),
+		signed_headers,
+		payload_hash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	request_hash := sha256.Sum256([]byte(canonical_request))
+	string_to_sign := "AWS4-HMAC-SHA256\n" + amz_date + "\n" + scope + "\n" + hex.EncodeToString(request_hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secret_key), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, string_to_sign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		access_key, scope, signed_headers, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but the RFC 3986 unreserved
+// characters, and '/' unless encode_slash is set.
+func uriEncode(s string, encode_slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encode_slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,68 @@
+// Package objstore stores opaque objects (files) under string keys on a
+// pluggable backend: the local filesystem or an S3-compatible bucket.
+// Metadata about the objects is kept by the callers, e.g. the attachments
+// table.
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Store is a storage backend. Keys are slash-separated relative paths
+// such as "2026/10/3f2a...". Implementations must be safe for concurrent
+// use.
+type Store interface {
+	// Backend returns BackendLocal or BackendS3
+	Backend() string
+
+	// Put stores 'size' bytes from r under key, replacing any existing
+	// object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Get returns the object from byte 'offset' on. 'length' < 0 reads to
+	// the end. It returns ErrNotFound if the object does not exist.
+	Get(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error)
+
+	// Stat returns ErrNotFound if the object does not exist
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+
+	// Delete removes the object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// List calls fn for every object whose key starts with prefix. It
+	// stops at the first error fn returns.
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
+
+// ValidateKey rejects keys that could escape the store root
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid object key %q (SHD_OBS_057)", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid object key %q (SHD_OBS_061)", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package outlook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TokenResult is the outcome of a token refresh.
+type TokenResult struct {
+	AccessToken string
+	// RefreshToken is the rotated refresh token, or the old one if
+	// Microsoft did not return a new one.
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// refreshAccessToken redeems 'refresh_token' at the Microsoft identity
+// platform token endpoint.
+func refreshAccessToken(ctx context.Context, cfg Config, refresh_token string) (*TokenResult, error) {
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, fmt.Errorf("outlook client credentials not configured (SHD_OLK_039)")
+	}
+
+	form := url.Values{}
+	form.Set("client_id", cfg.ClientID)
+	form.Set("client_secret", cfg.ClientSecret)
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refresh_token)
+	form.Set("scope", cfg.Scopes)
+
+	token_url := fmt.Sprintf("%s/%s/oauth2/v2.0/token",
+		strings.TrimRight(cfg.AuthorityURL, "/"), url.PathEscape(cfg.TenantID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, token_url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request (SHD_OLK_051): %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	body, status, err := doRequest(cfg, req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed (SHD_OLK_057): %w", err)
+	}
+
+	var resp tokenResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid token response, status:%d (SHD_OLK_062): %w", status, err)
+	}
+	if status != http.StatusOK || resp.AccessToken == "" {
+		return nil, fmt.Errorf("token refresh rejected, status:%d, error:%s, description:%s (SHD_OLK_065)",
+			status, resp.Error, resp.ErrorDescription)
+	}
+
+	result := &TokenResult{
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+		ExpiresAt:    time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}
+	if result.RefreshToken == "" {
+		result.RefreshToken = refresh_token
+	}
+	return result, nil
+}
+
+// renewSubscription extends the Graph change-notification subscription
+// 'sub_id' to 'expires_at' and returns the expiration Graph granted.
+func renewSubscription(
+	ctx context.Context,
+	cfg Config,
+	access_token string,
+	sub_id string,
+	expires_at time.Time) (time.Time, error) {
+	payload, err := json.Marshal(map[string]string{
+		"expirationDateTime": expires_at.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to encode renewal (SHD_OLK_091): %w", err)
+	}
+
+	sub_url := fmt.Sprintf("%s/v1.0/subscriptions/%s",
+		strings.TrimRight(cfg.GraphURL, "/"), url.PathEscape(sub_id))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, sub_url, bytes.NewReader(payload))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to create renewal request (SHD_OLK_098): %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+access_token)
+
+	body, status, err := doRequest(cfg, req)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("renewal request failed (SHD_OLK_105): %w", err)
+	}
+	if status != http.StatusOK {
+		return time.Time{}, fmt.Errorf("subscription renewal rejected, status:%d, body:%s (SHD_OLK_108)",
+			status, truncate(string(body), 512))
+	}
+
+	var resp struct {
+		ExpirationDateTime time.Time `json:"expirationDateTime"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.ExpirationDateTime.IsZero() {
+		// Renewed, but the response did not say until when
+		return expires_at, nil
+	}
+	return resp.ExpirationDateTime, nil
+}
+
+func doRequest(cfg Config, req *http.Request) ([]byte, int, error) {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return body, resp.StatusCode, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
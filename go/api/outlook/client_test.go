@@ -0,0 +1,101 @@
+package outlook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testConfig(url string) Config {
+	cfg := DefaultConfig()
+	cfg.TenantID = "tenant1"
+	cfg.ClientID = "client1"
+	cfg.ClientSecret = "secret1"
+	cfg.AuthorityURL = url
+	cfg.GraphURL = url
+	return cfg
+}
+
+func TestRefreshAccessToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tenant1/oauth2/v2.0/token" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		switch r.Form.Get("refresh_token") {
+		case "rotating":
+			io.WriteString(w, `{"access_token":"at2","refresh_token":"rt2","expires_in":3600}`)
+		case "keep":
+			io.WriteString(w, `{"access_token":"at3","expires_in":3600}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"invalid_grant","error_description":"revoked"}`)
+		}
+	}))
+	defer server.Close()
+	cfg := testConfig(server.URL)
+
+	result, err := refreshAccessToken(context.Background(), cfg, "rotating")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AccessToken != "at2" || result.RefreshToken != "rt2" {
+		t.Errorf("got %+v", result)
+	}
+	if d := time.Until(result.ExpiresAt); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("unexpected expiry %v", result.ExpiresAt)
+	}
+
+	result, err = refreshAccessToken(context.Background(), cfg, "keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RefreshToken != "keep" {
+		t.Errorf("expected the old refresh token to be kept, got %q", result.RefreshToken)
+	}
+
+	_, err = refreshAccessToken(context.Background(), cfg, "revoked")
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("expected invalid_grant error, got %v", err)
+	}
+}
+
+func TestRenewSubscription(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/v1.0/subscriptions/sub1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer at1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id": "sub1", "expirationDateTime": body["expirationDateTime"]})
+	}))
+	defer server.Close()
+	cfg := testConfig(server.URL)
+
+	want := time.Now().Add(cfg.SubLifetime).UTC().Truncate(time.Second)
+	got, err := renewSubscription(context.Background(), cfg, "at1", "sub1", want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := renewSubscription(context.Background(), cfg, "at1", "gone", want); err == nil {
+		t.Fatal("expected an error for an unknown subscription")
+	}
+}
@@ -0,0 +1,130 @@
+package outlook
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+// tokenKeyEnvVar holds the base64 AES-256 key the Outlook tokens are
+// encrypted with in the users table.
+const tokenKeyEnvVar = "OUTLOOK_TOKEN_ENCRYPTION_KEY"
+
+// Config configures token refresh and subscription renewal.
+type Config struct {
+	// TenantID is the Entra ID tenant, or "common" for multi-tenant apps
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// Scopes are requested on refresh; they must include offline_access
+	// for Microsoft to return a new refresh token.
+	Scopes string
+
+	// RefreshWindow: tokens expiring within it are refreshed
+	RefreshWindow time.Duration
+	// SubRenewWindow: subscriptions expiring within it are renewed
+	SubRenewWindow time.Duration
+	// SubLifetime is how long a renewed subscription is extended by.
+	// Graph caps mail subscriptions at 10080 minutes.
+	SubLifetime  time.Duration
+	ScanInterval time.Duration
+	// BatchSize is the maximum number of users handled per scan
+	BatchSize int
+	// MaxFailures consecutive failures disable the integration of a user
+	MaxFailures int
+
+	AuthorityURL string
+	GraphURL     string
+	HTTPClient   *http.Client
+}
+
+// DefaultConfig returns a Config without client credentials.
+func DefaultConfig() Config {
+	return Config{
+		TenantID:       "common",
+		Scopes:         "offline_access Mail.Read",
+		RefreshWindow:  10 * time.Minute,
+		SubRenewWindow: 12 * time.Hour,
+		SubLifetime:    70 * time.Hour,
+		ScanInterval:   5 * time.Minute,
+		BatchSize:      100,
+		MaxFailures:    5,
+		AuthorityURL:   "https://login.microsoftonline.com",
+		GraphURL:       "https://graph.microsoft.com",
+		HTTPClient:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// LoadConfig returns DefaultConfig overridden by the [outlook] section of
+// LibConfig, then by the environment:
+//
+//   - OUTLOOK_TENANT_ID, OUTLOOK_CLIENT_ID, OUTLOOK_SCOPES
+//   - OUTLOOK_CLIENT_SECRET (environment only)
+//   - OUTLOOK_REFRESH_WINDOW, OUTLOOK_SUB_RENEW_WINDOW, OUTLOOK_SCAN_INTERVAL
+//     (Go durations, e.g. "10m")
+//   - OUTLOOK_MAX_FAILURES
+func LoadConfig() Config {
+	cfg := DefaultConfig()
+	lib_conf := ApiTypes.LibConfig.OutlookConf
+	setString(&cfg.TenantID, lib_conf.TenantID)
+	setString(&cfg.ClientID, lib_conf.ClientID)
+	setString(&cfg.Scopes, lib_conf.Scopes)
+
+	setString(&cfg.TenantID, os.Getenv("OUTLOOK_TENANT_ID"))
+	setString(&cfg.ClientID, os.Getenv("OUTLOOK_CLIENT_ID"))
+	setString(&cfg.Scopes, os.Getenv("OUTLOOK_SCOPES"))
+	cfg.ClientSecret = os.Getenv("OUTLOOK_CLIENT_SECRET")
+	setDuration(&cfg.RefreshWindow, os.Getenv("OUTLOOK_REFRESH_WINDOW"))
+	setDuration(&cfg.SubRenewWindow, os.Getenv("OUTLOOK_SUB_RENEW_WINDOW"))
+	setDuration(&cfg.ScanInterval, os.Getenv("OUTLOOK_SCAN_INTERVAL"))
+	if n, err := strconv.Atoi(os.Getenv("OUTLOOK_MAX_FAILURES")); err == nil && n > 0 {
+		cfg.MaxFailures = n
+	}
+	return cfg
+}
+
+func setString(dest *string, value string) {
+	if value != "" {
+		*dest = value
+	}
+}
+
+func setDuration(dest *time.Duration, value string) {
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		*dest = d
+	}
+}
+
+var (
+	configMu     sync.RWMutex
+	activeConfig *Config
+)
+
+// SetConfig replaces the configuration used by the worker and
+// GetValidOutlookToken. Without it, LoadConfig is used on first use.
+func SetConfig(cfg Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	activeConfig = &cfg
+}
+
+func getConfig() Config {
+	configMu.RLock()
+	cfg := activeConfig
+	configMu.RUnlock()
+	if cfg != nil {
+		return *cfg
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+	if activeConfig == nil {
+		loaded := LoadConfig()
+		activeConfig = &loaded
+	}
+	return *activeConfig
+}
@@ -0,0 +1,134 @@
+package outlook
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/security"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+var (
+	// ErrNotConnected: the user has no Outlook refresh token
+	ErrNotConnected = errors.New("outlook is not connected")
+	// ErrDisabled: the integration was disabled after repeated failures.
+	// Storing new tokens (SaveOutlookTokens) enables it again.
+	ErrDisabled = errors.New("outlook integration is disabled")
+)
+
+var (
+	tokenKey     []byte
+	tokenKeyOnce sync.Once
+	tokenKeyErr  error
+)
+
+func getTokenKey() ([]byte, error) {
+	tokenKeyOnce.Do(func() {
+		tokenKey, tokenKeyErr = security.LoadKeyFromEnv(tokenKeyEnvVar)
+	})
+	return tokenKey, tokenKeyErr
+}
+
+// userLocks serializes refreshes per user within this process. Microsoft
+// rotates refresh tokens, so two concurrent refreshes with the same token
+// would leave one of them holding a stale token.
+var userLocks sync.Map
+
+func lockUser(user_id string) func() {
+	mu, _ := userLocks.LoadOrStore(user_id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// SaveOutlookTokens encrypts and stores the tokens of a user, e.g. after
+// the user connected Outlook. It also re-enables a disabled integration.
+func SaveOutlookTokens(
+	rc ApiTypes.RequestContext,
+	user_id string,
+	access_token string,
+	refresh_token string,
+	expires_at time.Time) error {
+	key, err := getTokenKey()
+	if err != nil {
+		return fmt.Errorf("outlook token key not available (SHD_OLK_057): %w", err)
+	}
+	enc_access, err := security.EncryptString(access_token, key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt access token (SHD_OLK_061): %w", err)
+	}
+	enc_refresh, err := security.EncryptString(refresh_token, key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt refresh token (SHD_OLK_065): %w", err)
+	}
+	return sysdatastores.SetUserOutlookTokens(rc, user_id, enc_access, enc_refresh, expires_at)
+}
+
+// GetValidOutlookToken returns an access token of the user that is valid
+// for at least the configured refresh window, refreshing it first if the
+// worker has not done so yet.
+func GetValidOutlookToken(rc ApiTypes.RequestContext, userID string) (string, error) {
+	cfg := getConfig()
+	unlock := lockUser(userID)
+	defer unlock()
+
+	def, err := sysdatastores.GetUserOutlook(rc, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("user not found, user_id:%s (SHD_OLK_082): %w", userID, err)
+		}
+		return "", fmt.Errorf("failed to read outlook state (SHD_OLK_084): %w", err)
+	}
+	if def.RefreshToken == "" {
+		return "", ErrNotConnected
+	}
+	if !def.DisabledAt.IsZero() {
+		return "", ErrDisabled
+	}
+
+	if def.AccessToken != "" && time.Until(def.TokenExpiresAt) > cfg.RefreshWindow {
+		key, err := getTokenKey()
+		if err != nil {
+			return "", fmt.Errorf("outlook token key not available (SHD_OLK_095): %w", err)
+		}
+		access_token, err := security.DecryptString(def.AccessToken, key)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt access token (SHD_OLK_099): %w", err)
+		}
+		return access_token, nil
+	}
+
+	result, err := refreshUser(rc, cfg, def)
+	if err != nil {
+		recordFailure(rc, cfg, def, "token refresh", err)
+		return "", err
+	}
+	return result.AccessToken, nil
+}
+
+// refreshUser refreshes the token of 'def' and stores the result. The
+// caller holds the user lock.
+func refreshUser(rc ApiTypes.RequestContext, cfg Config, def *sysdatastores.UserOutlookDef) (*TokenResult, error) {
+	key, err := getTokenKey()
+	if err != nil {
+		return nil, fmt.Errorf("outlook token key not available (SHD_OLK_116): %w", err)
+	}
+	refresh_token, err := security.DecryptString(def.RefreshToken, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt refresh token (SHD_OLK_120): %w", err)
+	}
+
+	result, err := refreshAccessToken(rc.Context(), cfg, refresh_token)
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveOutlookTokens(rc, def.UserID, result.AccessToken, result.RefreshToken, result.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	rc.GetLogger().Info("outlook token refreshed", "user_id", def.UserID, "expires_at", result.ExpiresAt)
+	return result, nil
+}
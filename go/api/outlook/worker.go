@@ -0,0 +1,212 @@
+package outlook
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/EchoFactory"
+	"github.com/chendingplano/shared/go/api/security"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+// WorkerStats summarizes one scan.
+type WorkerStats struct {
+	Scanned   int
+	Refreshed int
+	Renewed   int
+	Failed    int
+	Disabled  int
+}
+
+type worker struct {
+	running atomic.Bool
+	cancel  context.CancelFunc
+}
+
+var wk = &worker{}
+
+// StartWorker starts the background loop that refreshes expiring Outlook
+// tokens and renews expiring Graph subscriptions every ScanInterval. It
+// returns an error if the client credentials or the token encryption key
+// are missing.
+func StartWorker(logger ApiTypes.JimoLogger) error {
+	cfg := getConfig()
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return fmt.Errorf("OUTLOOK_CLIENT_ID and OUTLOOK_CLIENT_SECRET are required (SHD_OLK_037)")
+	}
+	if _, err := getTokenKey(); err != nil {
+		return fmt.Errorf("outlook token key not available (SHD_OLK_040): %w", err)
+	}
+	if wk.cancel != nil {
+		return nil
+	}
+
+	logger.Info("outlook: starting worker",
+		"tenant_id", cfg.TenantID,
+		"scan_interval", cfg.ScanInterval,
+		"refresh_window", cfg.RefreshWindow,
+		"sub_renew_window", cfg.SubRenewWindow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wk.cancel = cancel
+	go workerLoop(ctx, logger, cfg.ScanInterval)
+	return nil
+}
+
+// StopWorker stops the background loop.
+func StopWorker() {
+	if wk.cancel != nil {
+		wk.cancel()
+		wk.cancel = nil
+	}
+}
+
+// workerLoop runs a scan immediately, then every 'interval' until ctx is
+// cancelled.
+func workerLoop(ctx context.Context, logger ApiTypes.JimoLogger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rc := EchoFactory.NewRCAsAdmin("SHD_OLK_073")
+		stats, err := RunOnce(rc)
+		rc.Close()
+		if err != nil {
+			logger.Warn("outlook: scan failed", "error", err)
+		} else if stats.Scanned > 0 {
+			logger.Info("outlook: scan complete", "stats", stats)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce refreshes the tokens and renews the subscriptions that are due.
+// Concurrent calls beyond the first are no-ops.
+func RunOnce(rc ApiTypes.RequestContext) (WorkerStats, error) {
+	var stats WorkerStats
+	if !wk.running.CompareAndSwap(false, true) {
+		return stats, nil
+	}
+	defer wk.running.Store(false)
+
+	cfg := getConfig()
+	now := time.Now()
+	users, err := sysdatastores.ListOutlookUsersDue(rc,
+		now.Add(cfg.RefreshWindow), now.Add(cfg.SubRenewWindow), cfg.BatchSize)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, def := range users {
+		stats.Scanned++
+		processUser(rc, cfg, def.UserID, &stats)
+	}
+	return stats, nil
+}
+
+func processUser(rc ApiTypes.RequestContext, cfg Config, user_id string, stats *WorkerStats) {
+	unlock := lockUser(user_id)
+	defer unlock()
+
+	// Re-read: GetValidOutlookToken may have refreshed it meanwhile
+	def, err := sysdatastores.GetUserOutlook(rc, user_id)
+	if err != nil || def.RefreshToken == "" || !def.DisabledAt.IsZero() {
+		return
+	}
+
+	now := time.Now()
+	var access_token string
+	if def.AccessToken == "" || def.TokenExpiresAt.Before(now.Add(cfg.RefreshWindow)) {
+		result, err := refreshUser(rc, cfg, def)
+		if err != nil {
+			stats.Failed++
+			if recordFailure(rc, cfg, def, "token refresh", err) {
+				stats.Disabled++
+			}
+			return
+		}
+		stats.Refreshed++
+		access_token = result.AccessToken
+	}
+
+	if def.SubID == "" || def.SubExpiresAt.After(now.Add(cfg.SubRenewWindow)) {
+		return
+	}
+
+	if access_token == "" {
+		key, err := getTokenKey()
+		if err == nil {
+			access_token, err = security.DecryptString(def.AccessToken, key)
+		}
+		if err != nil {
+			stats.Failed++
+			if recordFailure(rc, cfg, def, "subscription renewal", err) {
+				stats.Disabled++
+			}
+			return
+		}
+	}
+
+	expires_at, err := renewSubscription(rc.Context(), cfg, access_token, def.SubID, now.Add(cfg.SubLifetime))
+	if err == nil {
+		err = sysdatastores.SetUserOutlookSubscription(rc, def.UserID, def.SubID, expires_at)
+	}
+	if err != nil {
+		stats.Failed++
+		if recordFailure(rc, cfg, def, "subscription renewal", err) {
+			stats.Disabled++
+		}
+		return
+	}
+	stats.Renewed++
+	rc.GetLogger().Info("outlook subscription renewed", "user_id", def.UserID, "expires_at", expires_at)
+}
+
+// recordFailure counts a failure of 'op' for the user and logs it as an
+// alarm. It returns true if the integration got disabled.
+func recordFailure(
+	rc ApiTypes.RequestContext,
+	cfg Config,
+	def *sysdatastores.UserOutlookDef,
+	op string,
+	op_err error) bool {
+	logger := rc.GetLogger()
+	failures, disabled, err := sysdatastores.RecordUserOutlookFailure(rc, def.UserID, cfg.MaxFailures)
+	if err != nil {
+		logger.Error("failed to record outlook failure", "error", err, "user_id", def.UserID)
+	}
+
+	error_msg := fmt.Sprintf("***** Alarm outlook %s failed, user_id:%s, email:%s, failures:%d/%d, error:%v",
+		op, def.UserID, def.Email, failures, cfg.MaxFailures, op_err)
+	logger.Error(error_msg)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Outlook,
+		ActivityType: ApiTypes.ActivityType_IntegrationFailure,
+		AppName:      ApiTypes.AppName_Outlook,
+		ModuleName:   ApiTypes.ModuleName_OutlookWorker,
+		ActivityMsg:  &error_msg,
+		CallerLoc:    "SHD_OLK_187"})
+
+	if !disabled {
+		return false
+	}
+
+	msg := fmt.Sprintf("***** Alarm outlook integration disabled after %d failures, user_id:%s, email:%s",
+		failures, def.UserID, def.Email)
+	logger.Error(msg)
+	sysdatastores.AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Outlook,
+		ActivityType: ApiTypes.ActivityType_IntegrationDisabled,
+		AppName:      ApiTypes.AppName_Outlook,
+		ModuleName:   ApiTypes.ModuleName_OutlookWorker,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_OLK_202"})
+	return true
+}
@@ -0,0 +1,99 @@
+package pgbackup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Location codes for the WAL archiving self-test
+const (
+	LOC_ARCHIVE_TEST_SWITCH  = "SHD_PGB_126"
+	LOC_ARCHIVE_TEST_FAILED  = "SHD_PGB_127"
+	LOC_ARCHIVE_TEST_TIMEOUT = "SHD_PGB_128"
+)
+
+// archiveTestPollInterval is how often TestArchiving looks for the segment
+var archiveTestPollInterval = time.Second
+
+// ArchiveTestResult contains the outcome of a WAL archiving self-test
+type ArchiveTestResult struct {
+	Segment  string        `json:"segment"`
+	Path     string        `json:"path,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// TestArchiving checks WAL archiving end to end: it closes the current
+// WAL segment with pg_switch_wal() and waits up to 'timeout' for the
+// segment to appear in WALArchiveDir, as the archive script writes it
+// (<segment>.gz) or uncompressed. It fails early if pg_stat_archiver
+// reports that archive_command failed for the segment.
+//
+// It needs a database connection and a role allowed to run
+// pg_switch_wal() (superuser unless granted).
+func (s *BackupService) TestArchiving(
+	ctx context.Context,
+	logger *slog.Logger,
+	timeout time.Duration) (*ArchiveTestResult, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("archiving self-test needs a database connection (%s)", LOC_ARCHIVE_TEST_SWITCH)
+	}
+
+	start := time.Now()
+	result := &ArchiveTestResult{}
+	// pg_walfile_name() of the switch location names the segment just closed
+	err := s.db.QueryRowContext(ctx, "SELECT pg_walfile_name(pg_switch_wal())").Scan(&result.Segment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to switch WAL segment: %w (%s)", err, LOC_ARCHIVE_TEST_SWITCH)
+	}
+	logger.Info("Waiting for WAL segment to be archived",
+		"segment", result.Segment,
+		"archive_dir", s.config.WALArchiveDir,
+		"timeout", timeout)
+
+	candidates := []string{
+		filepath.Join(s.config.WALArchiveDir, result.Segment+".gz"),
+		filepath.Join(s.config.WALArchiveDir, result.Segment),
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		for _, path := range candidates {
+			if _, err := os.Stat(path); err == nil {
+				result.Path = path
+				result.Duration = time.Since(start)
+				logger.Info("WAL archiving works",
+					"segment", result.Segment,
+					"path", path,
+					"duration", result.Duration)
+				return result, nil
+			}
+		}
+
+		var failedWAL sql.NullString
+		var failedTime sql.NullTime
+		err := s.db.QueryRowContext(ctx,
+			"SELECT last_failed_wal, last_failed_time FROM pg_stat_archiver").Scan(&failedWAL, &failedTime)
+		if err != nil {
+			logger.Warn("Failed to query pg_stat_archiver", "error", err)
+		} else if failedWAL.Valid && failedWAL.String == result.Segment {
+			return result, fmt.Errorf("archive_command failed for WAL segment %s at %s; check archive_command, "+
+				"the permissions of %s, the PostgreSQL log and %s (%s)",
+				result.Segment, failedTime.Time.Format(time.RFC3339), s.config.WALArchiveDir,
+				filepath.Join(s.config.LogDir, "wal_archive.log"), LOC_ARCHIVE_TEST_FAILED)
+		}
+
+		if time.Now().After(deadline) {
+			return result, fmt.Errorf("WAL segment %s not archived to %s within %s; check archive_mode "+
+				"and archive_command (%s)", result.Segment, s.config.WALArchiveDir, timeout, LOC_ARCHIVE_TEST_TIMEOUT)
+		}
+		select {
+		case <-ctx.Done():
+			return result, fmt.Errorf("archiving self-test canceled: %w (%s)", ctx.Err(), LOC_ARCHIVE_TEST_TIMEOUT)
+		case <-time.After(archiveTestPollInterval):
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package pgbackup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTestArchiving(t *testing.T) {
+	const (
+		switchQuery   = "SELECT pg_walfile_name(pg_switch_wal())"
+		archiverQuery = "SELECT last_failed_wal, last_failed_time FROM pg_stat_archiver"
+		segment       = "000000010000000000000003"
+	)
+	saved := archiveTestPollInterval
+	archiveTestPollInterval = time.Millisecond
+	defer func() { archiveTestPollInterval = saved }()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	archiveDir := t.TempDir()
+	config := &BackupConfig{WALArchiveDir: archiveDir, LogDir: t.TempDir()}
+	service := NewBackupServiceWithDB(config, db)
+	ctx := context.Background()
+	switchRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"pg_walfile_name"}).AddRow(segment)
+	}
+
+	// The archiver failed on the segment
+	mock.ExpectQuery(switchQuery).WillReturnRows(switchRows())
+	mock.ExpectQuery(archiverQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"last_failed_wal", "last_failed_time"}).AddRow(segment, time.Now()))
+	if _, err := service.TestArchiving(ctx, testLogger, time.Minute); err == nil ||
+		!strings.Contains(err.Error(), "archive_command failed for WAL segment "+segment) ||
+		!strings.Contains(err.Error(), LOC_ARCHIVE_TEST_FAILED) {
+		t.Errorf("failed archiving: error = %v", err)
+	}
+
+	// Nothing arrives in time; an older failure does not count
+	mock.ExpectQuery(switchQuery).WillReturnRows(switchRows())
+	mock.ExpectQuery(archiverQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"last_failed_wal", "last_failed_time"}).AddRow("000000010000000000000001", time.Now()))
+	if _, err := service.TestArchiving(ctx, testLogger, 0); err == nil ||
+		!strings.Contains(err.Error(), LOC_ARCHIVE_TEST_TIMEOUT) {
+		t.Errorf("timeout: error = %v", err)
+	}
+
+	// The archive script's compressed copy counts
+	path := filepath.Join(archiveDir, segment+".gz")
+	if err := os.WriteFile(path, []byte("wal"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(switchQuery).WillReturnRows(switchRows())
+	result, err := service.TestArchiving(ctx, testLogger, time.Minute)
+	if err != nil {
+		t.Fatalf("archived: %v", err)
+	}
+	if result.Segment != segment || result.Path != path {
+		t.Errorf("result = %+v", result)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewBackupService(config).TestArchiving(ctx, testLogger, time.Minute); err == nil ||
+		!strings.Contains(err.Error(), "needs a database connection") {
+		t.Errorf("no database: error = %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package pgbackup
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
@@ -9,6 +10,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -19,8 +22,14 @@ const (
 	LOC_BACKUP_EXEC     = "SHD_PGB_022"
 	LOC_BACKUP_MANIFEST = "SHD_PGB_023"
 	LOC_BACKUP_SIZE     = "SHD_PGB_024"
+	LOC_BACKUP_LABEL    = "SHD_PGB_025"
+	LOC_BACKUP_UPLOAD   = "SHD_PGB_026"
 )
 
+// labelPattern is the format of backup labels: letters, digits, '.', '_'
+// and '-', starting with a letter or digit
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
 // BackupResult contains information about a completed backup
 type BackupResult struct {
 	BackupID   string    `json:"backup_id"`
@@ -30,27 +39,89 @@ type BackupResult struct {
 	SizeBytes  int64     `json:"size_bytes"`
 	WALStart   string    `json:"wal_start,omitempty"`
 	WALEnd     string    `json:"wal_end,omitempty"`
+	Labels     []string  `json:"labels,omitempty"`
 	Success    bool      `json:"success"`
 	ErrorMsg   string    `json:"error_msg,omitempty"`
+
+	// LastVerify is the last verification of the backup, nil if it was
+	// never verified. It is stored apart from the manifest.
+	LastVerify *VerifyRecord `json:"-"`
+}
+
+// HasLabel returns true if the backup has the given label
+func (b *BackupResult) HasLabel(label string) bool {
+	for _, l := range b.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
 }
 
+// ValidateLabels checks that every label has the allowed format
+func ValidateLabels(labels []string) error {
+	for _, label := range labels {
+		if !labelPattern.MatchString(label) {
+			return fmt.Errorf("invalid label %q: use up to 64 letters, digits, '.', '_' or '-' (%s)",
+				label, LOC_BACKUP_LABEL)
+		}
+	}
+	return nil
+}
+
+// FilterByLabel returns the backups that have the given label, or all
+// backups if label is empty
+func FilterByLabel(backups []*BackupResult, label string) []*BackupResult {
+	if label == "" {
+		return backups
+	}
+	filtered := []*BackupResult{}
+	for _, b := range backups {
+		if b.HasLabel(label) {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
+
+// manifestName is the file of a backup holding its BackupResult
+const manifestName = "pgbackup_manifest.json"
+
 // BackupService provides backup operations
 type BackupService struct {
 	config *BackupConfig
 	db     *sql.DB
+	store  BackupStore
 }
 
-// NewBackupService creates a new backup service
+// NewBackupService creates a new backup service keeping base backups in
+// BaseBackupDir
 func NewBackupService(config *BackupConfig) *BackupService {
-	return &BackupService{config: config}
+	return NewBackupServiceWithStore(config, nil, NewLocalBackupStore(config.BaseBackupDir))
 }
 
 // NewBackupServiceWithDB creates a new backup service with database connection
 func NewBackupServiceWithDB(config *BackupConfig, db *sql.DB) *BackupService {
-	return &BackupService{config: config, db: db}
+	return NewBackupServiceWithStore(config, db, NewLocalBackupStore(config.BaseBackupDir))
 }
 
-// Initialize creates required directories and installs the WAL archive script
+// NewBackupServiceWithStore creates a new backup service keeping base
+// backups in store. db may be nil.
+func NewBackupServiceWithStore(config *BackupConfig, db *sql.DB, store BackupStore) *BackupService {
+	return &BackupService{config: config, db: db, store: store}
+}
+
+// storesLocally returns true if base backups stay in BaseBackupDir, where
+// pg_basebackup writes them
+func (s *BackupService) storesLocally() bool {
+	local, ok := s.store.(*LocalBackupStore)
+	return ok && filepath.Clean(local.Root()) == filepath.Clean(s.config.BaseBackupDir)
+}
+
+// Initialize creates required directories and installs the WAL archive
+// script. When connected, it also checks the PostgreSQL configuration and,
+// if archiving is configured, runs TestArchiving (unless
+// ArchiveTestWait is 0); a failed self-test fails Initialize.
 func (s *BackupService) Initialize(ctx context.Context, logger *slog.Logger) error {
 	logger.Info("Initializing backup environment", "backup_dir", s.config.BackupBaseDir)
 
@@ -61,6 +132,7 @@ func (s *BackupService) Initialize(ctx context.Context, logger *slog.Logger) err
 		s.config.WALArchiveDir,
 		s.config.LogDir,
 		s.config.ScriptsDir,
+		s.config.TableDumpDir,
 	}
 
 	for _, dir := range dirs {
@@ -80,6 +152,12 @@ func (s *BackupService) Initialize(ctx context.Context, logger *slog.Logger) err
 		if err := s.verifyPostgreSQLConfig(ctx, logger); err != nil {
 			logger.Warn("PostgreSQL configuration check failed", "error", err)
 			logger.Info("You may need to configure PostgreSQL manually. See documentation for required settings.")
+			logger.Info("Re-run init after configuring PostgreSQL to test WAL archiving")
+		} else if s.config.ArchiveTestWait > 0 {
+			timeout := time.Duration(s.config.ArchiveTestWait) * time.Second
+			if _, err := s.TestArchiving(ctx, logger, timeout); err != nil {
+				return fmt.Errorf("WAL archiving self-test failed: %w", err)
+			}
 		}
 	}
 
@@ -237,11 +315,21 @@ func (s *BackupService) verifyPostgreSQLConfig(ctx context.Context, logger *slog
 	return nil
 }
 
-// PerformBaseBackup executes pg_basebackup to create a full backup
-func (s *BackupService) PerformBaseBackup(ctx context.Context, logger *slog.Logger) (*BackupResult, error) {
+// PerformBaseBackup executes pg_basebackup to create a full backup.
+// The labels are stored in the backup manifest so that the backup can be
+// found (and, if configured, kept by retention) later.
+func (s *BackupService) PerformBaseBackup(ctx context.Context, logger *slog.Logger, labels []string) (*BackupResult, error) {
+	if err := ValidateLabels(labels); err != nil {
+		return nil, err
+	}
+	if err := ValidateBaseBackupArgs(s.config.BaseBackupArgs); err != nil {
+		return nil, err
+	}
+
 	result := &BackupResult{
 		BackupID:  time.Now().Format("20060102_150405"),
 		StartTime: time.Now(),
+		Labels:    labels,
 	}
 
 	// Create backup directory with timestamp
@@ -256,6 +344,7 @@ func (s *BackupService) PerformBaseBackup(ctx context.Context, logger *slog.Logg
 	logger.Info("Starting base backup",
 		"backup_id", result.BackupID,
 		"path", backupDir,
+		"labels", labels,
 		"host", s.config.PGHost,
 		"port", s.config.PGPort)
 
@@ -267,7 +356,8 @@ func (s *BackupService) PerformBaseBackup(ctx context.Context, logger *slog.Logg
 	// -v: verbose
 	// -z: compress (gzip)
 	// --checkpoint=fast: start backup immediately
-	cmd := exec.CommandContext(ctx, "pg_basebackup",
+	// The configured extra arguments come last, so they override these.
+	args := []string{
 		"-h", s.config.PGHost,
 		"-p", fmt.Sprintf("%d", s.config.PGPort),
 		"-U", s.config.PGUser,
@@ -279,7 +369,10 @@ func (s *BackupService) PerformBaseBackup(ctx context.Context, logger *slog.Logg
 		"-z",                // gzip compression
 		"--checkpoint=fast", // don't wait for checkpoint
 		"--label", fmt.Sprintf("backup_%s", result.BackupID),
-	)
+	}
+	args = append(args, s.config.BaseBackupArgs...)
+	logger.Debug("pg_basebackup command", "command", "pg_basebackup "+strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, "pg_basebackup", args...)
 
 	// Set password via environment
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.PGPassword))
@@ -308,8 +401,25 @@ func (s *BackupService) PerformBaseBackup(ctx context.Context, logger *slog.Logg
 	result.SizeBytes = size
 	result.Success = true
 
+	// Record the first WAL segment the backup needs, for WAL cleanup
+	if walStart, err := readStartWALFile(filepath.Join(backupDir, "base.tar.gz")); err != nil {
+		logger.Warn("Failed to read start WAL of backup", "error", err)
+	} else {
+		result.WALStart = walStart
+	}
+
+	// Move the backup to the store unless it already is in it
+	if !s.storesLocally() {
+		if err := s.uploadBackup(ctx, logger, result.BackupID, backupDir); err != nil {
+			result.Success = false
+			result.ErrorMsg = err.Error()
+			return result, err
+		}
+		result.BackupPath = s.store.Location(result.BackupID)
+	}
+
 	// Write backup manifest
-	if err := s.writeBackupManifest(result); err != nil {
+	if err := s.writeBackupManifest(ctx, result); err != nil {
 		logger.Warn("Failed to write backup manifest", "error", err)
 	}
 
@@ -317,9 +427,10 @@ func (s *BackupService) PerformBaseBackup(ctx context.Context, logger *slog.Logg
 		"backup_id", result.BackupID,
 		"duration", result.EndTime.Sub(result.StartTime).Round(time.Second),
 		"size_mb", float64(result.SizeBytes)/(1024*1024))
+	s.recordBackupRun(ctx, logger, result)
 
 	// Sync to remote if configured (non-blocking: failures are logged as warnings)
-	if s.config.RemoteEnabled() {
+	if s.config.RemoteEnabled() && s.storesLocally() {
 		syncResult := s.SyncBaseBackup(ctx, logger, result.BackupID)
 		if !syncResult.Success {
 			logger.Warn("Base backup completed locally but remote sync failed. Run 'pgbackup sync' to retry.",
@@ -331,21 +442,53 @@ func (s *BackupService) PerformBaseBackup(ctx context.Context, logger *slog.Logg
 }
 
 // writeBackupManifest creates a JSON manifest file for the backup
-func (s *BackupService) writeBackupManifest(result *BackupResult) error {
-	manifestPath := filepath.Join(result.BackupPath, "pgbackup_manifest.json")
-
+func (s *BackupService) writeBackupManifest(ctx context.Context, result *BackupResult) error {
 	data, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal manifest: %w (%s)", err, LOC_BACKUP_MANIFEST)
 	}
 
-	if err := os.WriteFile(manifestPath, data, 0600); err != nil {
+	if err := s.store.Write(ctx, result.BackupID, manifestName, bytes.NewReader(data)); err != nil {
 		return fmt.Errorf("failed to write manifest: %w (%s)", err, LOC_BACKUP_MANIFEST)
 	}
 
 	return nil
 }
 
+// uploadBackup copies the files pg_basebackup wrote to backupDir into the
+// store, then removes backupDir
+func (s *BackupService) uploadBackup(ctx context.Context, logger *slog.Logger, backupID, backupDir string) error {
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return fmt.Errorf("failed to read backup directory: %w (%s)", err, LOC_BACKUP_UPLOAD)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		f, err := os.Open(filepath.Join(backupDir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w (%s)", entry.Name(), err, LOC_BACKUP_UPLOAD)
+		}
+		err = s.store.Write(ctx, backupID, entry.Name(), f)
+		f.Close()
+		if err != nil {
+			// Don't leave a partial backup in the store
+			if delErr := s.store.Delete(ctx, backupID); delErr != nil {
+				logger.Warn("Failed to remove partial backup from store", "backup_id", backupID, "error", delErr)
+			}
+			return fmt.Errorf("failed to upload %s: %w (%s)", entry.Name(), err, LOC_BACKUP_UPLOAD)
+		}
+		logger.Info("Uploaded backup file", "backup_id", backupID, "file", entry.Name())
+	}
+
+	if err := os.RemoveAll(backupDir); err != nil {
+		logger.Warn("Failed to remove local copy of uploaded backup", "path", backupDir, "error", err)
+	}
+	return nil
+}
+
 // calculateDirSize calculates the total size of a directory
 func (s *BackupService) calculateDirSize(path string) (int64, error) {
 	var size int64
@@ -366,42 +509,22 @@ func (s *BackupService) calculateDirSize(path string) (int64, error) {
 
 // ListBackups returns all available backups
 func (s *BackupService) ListBackups() ([]*BackupResult, error) {
-	entries, err := os.ReadDir(s.config.BaseBackupDir)
+	ctx := context.Background()
+	objects, err := s.store.List(ctx, "")
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []*BackupResult{}, nil
-		}
-		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+		return nil, fmt.Errorf("failed to list backups: %w", err)
 	}
 
-	var backups []*BackupResult
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
+	backups := []*BackupResult{}
+	for start := 0; start < len(objects); {
+		end := start
+		for end < len(objects) && objects[end].BackupID == objects[start].BackupID {
+			end++
 		}
-
-		manifestPath := filepath.Join(s.config.BaseBackupDir, entry.Name(), "pgbackup_manifest.json")
-		data, err := os.ReadFile(manifestPath)
-		if err != nil {
-			// If no manifest, create a basic result from directory info
-			info, err := entry.Info()
-			if err != nil {
-				continue
-			}
-			backups = append(backups, &BackupResult{
-				BackupID:   entry.Name(),
-				BackupPath: filepath.Join(s.config.BaseBackupDir, entry.Name()),
-				StartTime:  info.ModTime(),
-				Success:    true,
-			})
-			continue
+		if backup, err := s.readBackup(ctx, objects[start].BackupID, objects[start:end]); err == nil {
+			backups = append(backups, backup)
 		}
-
-		var result BackupResult
-		if err := json.Unmarshal(data, &result); err != nil {
-			continue
-		}
-		backups = append(backups, &result)
+		start = end
 	}
 
 	return backups, nil
@@ -409,32 +532,94 @@ func (s *BackupService) ListBackups() ([]*BackupResult, error) {
 
 // GetBackup retrieves a specific backup by ID
 func (s *BackupService) GetBackup(backupID string) (*BackupResult, error) {
-	backupPath := filepath.Join(s.config.BaseBackupDir, backupID)
-	manifestPath := filepath.Join(backupPath, "pgbackup_manifest.json")
-
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+	ctx := context.Background()
+	if err := validateObjectName("backup ID", backupID); err != nil {
+		return nil, err
+	}
+	objects, err := s.store.List(ctx, backupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup files: %w", err)
+	}
+	if len(objects) == 0 {
 		return nil, fmt.Errorf("backup not found: %s", backupID)
 	}
+	return s.readBackup(ctx, backupID, objects)
+}
 
-	data, err := os.ReadFile(manifestPath)
+// readBackup reads the manifest of a backup and its last verification.
+// Without a manifest, the backup is described from its files: it started
+// when the newest of them was written.
+func (s *BackupService) readBackup(ctx context.Context, backupID string, objects []BackupObject) (*BackupResult, error) {
+	result, err := s.readBackupManifest(ctx, backupID, objects)
 	if err != nil {
-		// Return basic info if no manifest
-		info, err := os.Stat(backupPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to stat backup: %w", err)
+		return nil, err
+	}
+
+	for _, obj := range objects {
+		if obj.Name != verifyRecordName {
+			continue
+		}
+		// An unreadable record leaves the backup unverified
+		if record, err := s.readVerifyRecord(ctx, backupID); err == nil {
+			result.LastVerify = record
 		}
-		return &BackupResult{
+		break
+	}
+
+	return result, nil
+}
+
+// readBackupManifest reads the manifest of a backup, or describes the
+// backup from its files when it has none
+func (s *BackupService) readBackupManifest(ctx context.Context, backupID string, objects []BackupObject) (*BackupResult, error) {
+	r, err := s.store.Open(ctx, backupID, manifestName)
+	if err != nil {
+		result := &BackupResult{
 			BackupID:   backupID,
-			BackupPath: backupPath,
-			StartTime:  info.ModTime(),
+			BackupPath: s.store.Location(backupID),
 			Success:    true,
-		}, nil
+		}
+		for _, obj := range objects {
+			// Verifying a backup doesn't make it any newer
+			if obj.Name == verifyRecordName {
+				continue
+			}
+			result.SizeBytes += obj.Size
+			if obj.ModTime.After(result.StartTime) {
+				result.StartTime = obj.ModTime
+			}
+		}
+		return result, nil
 	}
+	defer r.Close()
 
 	var result BackupResult
-	if err := json.Unmarshal(data, &result); err != nil {
+	if err := json.NewDecoder(r).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to parse manifest: %w", err)
 	}
 
 	return &result, nil
 }
+
+// LatestBackup returns the most recent backup, or the most recent one
+// with the given label if label is not empty
+func (s *BackupService) LatestBackup(label string) (*BackupResult, error) {
+	backups, err := s.ListBackups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var latest *BackupResult
+	for _, b := range FilterByLabel(backups, label) {
+		if latest == nil || b.StartTime.After(latest.StartTime) {
+			latest = b
+		}
+	}
+	if latest == nil {
+		if label != "" {
+			return nil, fmt.Errorf("no backups found with label %q", label)
+		}
+		return nil, fmt.Errorf("no backups found")
+	}
+	return latest, nil
+}
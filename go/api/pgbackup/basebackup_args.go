@@ -0,0 +1,117 @@
+package pgbackup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Location code for pg_basebackup argument validation
+const LOC_CFG_BASEBACKUP_ARGS = "SHD_PGB_131"
+
+// baseBackupOption is a pg_basebackup option that may be passed through
+// PG_BACKUP_BASEBACKUP_ARGS. valid is nil for options without a value.
+type baseBackupOption struct {
+	long  string
+	short byte
+	valid func(string) bool
+}
+
+func oneOf(values ...string) func(string) bool {
+	return func(v string) bool {
+		for _, value := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func matches(pattern string) func(string) bool {
+	re := regexp.MustCompile(pattern)
+	return re.MatchString
+}
+
+// baseBackupOptions is the allowlist of extra pg_basebackup arguments.
+// Options that change where the backup goes, what it connects to or the
+// layout that verify, restore and WAL cleanup read (-D, -h, -d, -F, -R,
+// --label, ...) are not in it, and neither is -X none: a backup without
+// its WAL is not restorable from the backup alone.
+var baseBackupOptions = []baseBackupOption{
+	{long: "wal-method", short: 'X', valid: oneOf("fetch", "f", "stream", "s")},
+	{long: "checkpoint", short: 'c', valid: oneOf("fast", "spread")},
+	{long: "max-rate", short: 'r', valid: matches(`^[0-9]+[kM]?$`)},
+	{long: "compress", short: 'Z', valid: matches(`^[1-9]$`)},
+	{long: "slot", short: 'S', valid: matches(`^[a-z0-9_]{1,63}$`)},
+	{long: "no-slot"},
+	{long: "no-verify-checksums", short: 'k'},
+	{long: "no-estimate-size"},
+	{long: "no-manifest"},
+	{long: "manifest-checksums", valid: oneOf("NONE", "CRC32C", "SHA224", "SHA256", "SHA384", "SHA512")},
+}
+
+func findBaseBackupOption(long string, short byte) *baseBackupOption {
+	for i := range baseBackupOptions {
+		opt := &baseBackupOptions[i]
+		if (long != "" && opt.long == long) || (short != 0 && opt.short == short) {
+			return opt
+		}
+	}
+	return nil
+}
+
+// ParseBaseBackupArgs splits PG_BACKUP_BASEBACKUP_ARGS on whitespace
+func ParseBaseBackupArgs(value string) []string {
+	return strings.Fields(value)
+}
+
+// ValidateBaseBackupArgs checks extra pg_basebackup arguments against the
+// allowlist. Options may be given as --name=value, --name value, -Xvalue
+// or -X value.
+func ValidateBaseBackupArgs(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var opt *baseBackupOption
+		var value string
+		hasValue := false
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name := arg[2:]
+			if eq := strings.IndexByte(name, '='); eq >= 0 {
+				name, value, hasValue = name[:eq], name[eq+1:], true
+			}
+			opt = findBaseBackupOption(name, 0)
+
+		case len(arg) >= 2 && arg[0] == '-':
+			opt = findBaseBackupOption("", arg[1])
+			if len(arg) > 2 {
+				value, hasValue = arg[2:], true
+			}
+
+		default:
+			return fmt.Errorf("unexpected pg_basebackup argument %q (%s)", arg, LOC_CFG_BASEBACKUP_ARGS)
+		}
+		if opt == nil {
+			return fmt.Errorf("pg_basebackup option %q is not allowed (%s)", arg, LOC_CFG_BASEBACKUP_ARGS)
+		}
+
+		if opt.valid == nil {
+			if hasValue {
+				return fmt.Errorf("pg_basebackup option %q takes no value (%s)", arg, LOC_CFG_BASEBACKUP_ARGS)
+			}
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return fmt.Errorf("pg_basebackup option %q needs a value (%s)", arg, LOC_CFG_BASEBACKUP_ARGS)
+			}
+			i++
+			value = args[i]
+		}
+		if !opt.valid(value) {
+			return fmt.Errorf("invalid value %q of pg_basebackup option %q (%s)", value, arg, LOC_CFG_BASEBACKUP_ARGS)
+		}
+	}
+	return nil
+}
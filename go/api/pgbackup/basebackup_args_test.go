@@ -0,0 +1,46 @@
+package pgbackup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBaseBackupArgs(t *testing.T) {
+	valid := []string{
+		"",
+		"--wal-method=fetch",
+		"-X stream --checkpoint=spread",
+		"-Xf -c fast",
+		"--max-rate 32M -Z 9 --no-verify-checksums",
+		"--slot=backup_slot --no-manifest",
+		"-k --manifest-checksums SHA256",
+	}
+	for _, value := range valid {
+		if err := ValidateBaseBackupArgs(ParseBaseBackupArgs(value)); err != nil {
+			t.Errorf("%q: %v", value, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"-D /tmp/elsewhere":          "not allowed",
+		"--pgdata=/tmp/elsewhere":    "not allowed",
+		"--label=x":                  "not allowed",
+		"-R":                         "not allowed",
+		"--wal-method=none":          "invalid value",
+		"-X":                         "needs a value",
+		"--checkpoint=now":           "invalid value",
+		"-Z 0":                       "invalid value",
+		"--slot=x;rm":                "invalid value",
+		"--no-manifest=yes":          "takes no value",
+		"-kX":                        "takes no value",
+		"fetch":                      "unexpected",
+		"--max-rate=1G --no-slot":    "invalid value",
+		"--dbname=host=evil.example": "not allowed",
+	}
+	for value, want := range invalid {
+		err := ValidateBaseBackupArgs(ParseBaseBackupArgs(value))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: err = %v, want %q", value, err, want)
+		}
+	}
+}
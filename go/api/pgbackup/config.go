@@ -15,6 +15,7 @@ const (
 	LOC_CFG_LOAD  = "SHD_PGB_001"
 	LOC_CFG_VALID = "SHD_PGB_002"
 	LOC_CFG_PATH  = "SHD_PGB_003"
+	LOC_CFG_FILE  = "SHD_PGB_004"
 )
 
 // BackupConfig holds all configuration for backup operations
@@ -32,14 +33,30 @@ type BackupConfig struct {
 	WALArchiveDir string // Where WAL files are archived ($PG_BACKUP_DIR/wal_archive)
 	LogDir        string // Log directory ($PG_BACKUP_DIR/logs)
 	ScriptsDir    string // Scripts directory ($PG_BACKUP_DIR/scripts)
+	TableDumpDir  string // Single-table dumps ($PG_BACKUP_DIR/tables)
 
 	// Archive script path
 	ArchiveScriptPath string
 
+	// Lock file taken by backups, cleanups and syncs so they don't overlap
+	// ($PG_BACKUP_DIR/.pgbackup.lock), and PID file of 'pgbackup daemon'
+	LockFilePath string
+	PIDFilePath  string
+
+	// Cron expressions of the daemon jobs ("off" disables a job)
+	BackupSchedule  string // PG_BACKUP_SCHEDULE (default: daily at 2:00)
+	CleanupSchedule string // PG_BACKUP_CLEANUP_SCHEDULE (default: Sunday at 3:00)
+	SyncSchedule    string // PG_BACKUP_SYNC_SCHEDULE (default: hourly; needs RemoteHost)
+
 	// Retention settings
-	RetainDays    int // Keep backups for N days (default: 7)
-	RetainCount   int // Keep at least N backups (default: 3)
-	RetainWALDays int // Keep WAL files for N days (default: 14)
+	RetainDays    int  // Keep backups for N days (default: 7)
+	RetainCount   int  // Keep at least N backups (default: 3)
+	RetainWALDays int  // Keep WAL files for N days (default: 14)
+	RetainLabeled bool // Never delete labeled backups (default: false)
+
+	// A backup last verified more than VerifyMaxAgeDays ago is listed as
+	// STALE (PG_BACKUP_VERIFY_MAX_AGE_DAYS, default: 7; 0: never stale)
+	VerifyMaxAgeDays int
 
 	// Remote sync (optional - enabled when RemoteHost is set)
 	RemoteHost string // Remote hostname/IP (PG_BACKUP_REMOTE_HOST)
@@ -49,41 +66,97 @@ type BackupConfig struct {
 
 	// PostgreSQL data directory (for recovery)
 	PGDataDir string
+	// PostgreSQL version of restored backups without a PG_VERSION file
+	// (PG_BACKUP_PG_VERSION, e.g. "11" or "9.6")
+	PGVersion string
+
+	// Free space required by a backup: the size of the databases times
+	// DiskSpaceFactor, or MinFreeSpaceMB when the database is unreachable
+	DiskSpaceFactor float64 // PG_BACKUP_DISK_SPACE_FACTOR (default: 1.5)
+	MinFreeSpaceMB  int     // PG_BACKUP_MIN_FREE_MB (default: 1024)
+
+	// Post-restore validation (restore --validate)
+	ValidatePort  int    // Port of the throwaway instance (PG_BACKUP_VALIDATE_PORT, default: 54329)
+	ValidateQuery string // Validation query (PG_BACKUP_VALIDATE_QUERY)
+
+	// Seconds init waits for a test WAL segment to be archived; 0 skips
+	// the test (PG_BACKUP_ARCHIVE_TEST_TIMEOUT, default: 60)
+	ArchiveTestWait int
+
+	// Durations and sizes of past base backups, kept through retention
+	// ($PG_BACKUP_DIR/backup_history.jsonl)
+	HistoryFilePath string
+
+	// A backup taking more than DurationAlertFactor times the median of
+	// the previous runs is logged as a warning and sent to AlertWebhookURL
+	// (PG_BACKUP_DURATION_ALERT_FACTOR, default: 2; 0: no check)
+	DurationAlertFactor float64
+	AlertWebhookURL     string // PG_BACKUP_ALERT_WEBHOOK_URL (optional)
+
+	// Extra pg_basebackup arguments, appended to the defaults so they
+	// override them (PG_BACKUP_BASEBACKUP_ARGS, e.g. "--wal-method=fetch
+	// --checkpoint=spread"). Only allowlisted options are accepted.
+	BaseBackupArgs []string
 }
 
-// LoadConfig loads configuration from environment variables
+// LoadConfig loads configuration from environment variables and, if
+// PGBACKUP_CONFIG names one, a TOML or YAML config file. An environment
+// variable wins over the file; see configFileKeys for the file's keys.
 func LoadConfig() (*BackupConfig, error) {
-	backupDir := os.Getenv("PG_BACKUP_DIR")
+	src, err := loadConfigFile(os.Getenv("PGBACKUP_CONFIG"))
+	if err != nil {
+		return nil, err
+	}
+
+	backupDir := src.get("PG_BACKUP_DIR")
 	if backupDir == "" {
 		return nil, fmt.Errorf("PG_BACKUP_DIR environment variable not set (%s)", LOC_CFG_LOAD)
 	}
 
 	// Expand ~ to home directory
-	backupDir, err := expandPath(backupDir)
+	backupDir, err = expandPath(backupDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to expand backup dir path: %w (%s)", err, LOC_CFG_PATH)
 	}
 
 	config := &BackupConfig{
-		PGHost:            getEnvOrDefault("PG_HOST", "127.0.0.1"),
-		PGPort:            getEnvIntOrDefault("PG_PORT", 5432),
-		PGUser:            os.Getenv("PG_USER_NAME"),
-		PGPassword:        os.Getenv("PG_PASSWORD"),
-		PGDatabase:        os.Getenv("PG_DB_NAME"),
-		BackupBaseDir:     backupDir,
-		BaseBackupDir:     filepath.Join(backupDir, "base"),
-		WALArchiveDir:     filepath.Join(backupDir, "wal_archive"),
-		LogDir:            filepath.Join(backupDir, "logs"),
-		ScriptsDir:        filepath.Join(backupDir, "scripts"),
-		ArchiveScriptPath: filepath.Join(backupDir, "scripts", "archive_wal.sh"),
-		RetainDays:        getEnvIntOrDefault("PG_BACKUP_RETAIN_DAYS", 7),
-		RetainCount:       getEnvIntOrDefault("PG_BACKUP_RETAIN_COUNT", 3),
-		RetainWALDays:     getEnvIntOrDefault("PG_BACKUP_RETAIN_WAL_DAYS", 14),
-		RemoteHost:        os.Getenv("PG_BACKUP_REMOTE_HOST"),
-		RemoteUser:        getEnvOrDefault("PG_BACKUP_REMOTE_USER", ""),
-		RemoteDir:         getEnvOrDefault("PG_BACKUP_REMOTE_DIR", ""),
-		RemotePort:        getEnvIntOrDefault("PG_BACKUP_REMOTE_PORT", 22),
-		PGDataDir:         os.Getenv("PGDATA"),
+		PGHost:              src.getOrDefault("PG_HOST", "127.0.0.1"),
+		PGPort:              src.getIntOrDefault("PG_PORT", 5432),
+		PGUser:              src.get("PG_USER_NAME"),
+		PGPassword:          src.get("PG_PASSWORD"),
+		PGDatabase:          src.get("PG_DB_NAME"),
+		BackupBaseDir:       backupDir,
+		BaseBackupDir:       filepath.Join(backupDir, "base"),
+		WALArchiveDir:       filepath.Join(backupDir, "wal_archive"),
+		LogDir:              filepath.Join(backupDir, "logs"),
+		ScriptsDir:          filepath.Join(backupDir, "scripts"),
+		TableDumpDir:        filepath.Join(backupDir, "tables"),
+		ArchiveScriptPath:   filepath.Join(backupDir, "scripts", "archive_wal.sh"),
+		LockFilePath:        filepath.Join(backupDir, ".pgbackup.lock"),
+		PIDFilePath:         filepath.Join(backupDir, ".pgbackup.pid"),
+		HistoryFilePath:     filepath.Join(backupDir, "backup_history.jsonl"),
+		BackupSchedule:      src.getOrDefault("PG_BACKUP_SCHEDULE", "0 2 * * *"),
+		CleanupSchedule:     src.getOrDefault("PG_BACKUP_CLEANUP_SCHEDULE", "0 3 * * 0"),
+		SyncSchedule:        src.getOrDefault("PG_BACKUP_SYNC_SCHEDULE", "0 * * * *"),
+		RetainDays:          src.getIntOrDefault("PG_BACKUP_RETAIN_DAYS", 7),
+		RetainCount:         src.getIntOrDefault("PG_BACKUP_RETAIN_COUNT", 3),
+		RetainWALDays:       src.getIntOrDefault("PG_BACKUP_RETAIN_WAL_DAYS", 14),
+		RetainLabeled:       src.getBoolOrDefault("PG_BACKUP_RETAIN_LABELED", false),
+		VerifyMaxAgeDays:    src.getIntOrDefault("PG_BACKUP_VERIFY_MAX_AGE_DAYS", 7),
+		RemoteHost:          src.get("PG_BACKUP_REMOTE_HOST"),
+		RemoteUser:          src.getOrDefault("PG_BACKUP_REMOTE_USER", ""),
+		RemoteDir:           src.getOrDefault("PG_BACKUP_REMOTE_DIR", ""),
+		RemotePort:          src.getIntOrDefault("PG_BACKUP_REMOTE_PORT", 22),
+		PGDataDir:           src.get("PGDATA"),
+		PGVersion:           src.get("PG_BACKUP_PG_VERSION"),
+		DiskSpaceFactor:     src.getFloatOrDefault("PG_BACKUP_DISK_SPACE_FACTOR", 1.5),
+		MinFreeSpaceMB:      src.getIntOrDefault("PG_BACKUP_MIN_FREE_MB", 1024),
+		ValidatePort:        src.getIntOrDefault("PG_BACKUP_VALIDATE_PORT", 54329),
+		ValidateQuery:       src.getOrDefault("PG_BACKUP_VALIDATE_QUERY", "SELECT count(*) FROM pg_catalog.pg_class"),
+		ArchiveTestWait:     src.getIntOrDefault("PG_BACKUP_ARCHIVE_TEST_TIMEOUT", 60),
+		DurationAlertFactor: src.getFloatOrDefault("PG_BACKUP_DURATION_ALERT_FACTOR", 2),
+		AlertWebhookURL:     src.get("PG_BACKUP_ALERT_WEBHOOK_URL"),
+		BaseBackupArgs:      ParseBaseBackupArgs(src.get("PG_BACKUP_BASEBACKUP_ARGS")),
 	}
 
 	if err := config.Validate(); err != nil {
@@ -107,6 +180,19 @@ func (c *BackupConfig) Validate() error {
 	if c.BackupBaseDir == "" {
 		return fmt.Errorf("PG_BACKUP_DIR environment variable not set (%s)", LOC_CFG_VALID)
 	}
+	if c.DiskSpaceFactor < 1 {
+		return fmt.Errorf("PG_BACKUP_DISK_SPACE_FACTOR must be at least 1, got %g (%s)", c.DiskSpaceFactor, LOC_CFG_VALID)
+	}
+	if c.VerifyMaxAgeDays < 0 {
+		return fmt.Errorf("PG_BACKUP_VERIFY_MAX_AGE_DAYS must not be negative, got %d (%s)", c.VerifyMaxAgeDays, LOC_CFG_VALID)
+	}
+	if c.DurationAlertFactor != 0 && c.DurationAlertFactor <= 1 {
+		return fmt.Errorf("PG_BACKUP_DURATION_ALERT_FACTOR must be 0 or more than 1, got %g (%s)",
+			c.DurationAlertFactor, LOC_CFG_VALID)
+	}
+	if err := ValidateBaseBackupArgs(c.BaseBackupArgs); err != nil {
+		return fmt.Errorf("PG_BACKUP_BASEBACKUP_ARGS: %w", err)
+	}
 	return nil
 }
 
@@ -163,17 +249,37 @@ func expandPath(path string) (string, error) {
 	return path, nil
 }
 
-// getEnvOrDefault returns the environment variable value or a default
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+// getOrDefault returns the setting 'key' or a default
+func (src configSource) getOrDefault(key, defaultValue string) string {
+	if value := src.get(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
-// getEnvIntOrDefault returns the environment variable as int or a default
-func getEnvIntOrDefault(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
+// getBoolOrDefault returns the setting 'key' as bool or a default
+func (src configSource) getBoolOrDefault(key string, defaultValue bool) bool {
+	if value := src.get(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// getFloatOrDefault returns the setting 'key' as float64 or a default
+func (src configSource) getFloatOrDefault(key string, defaultValue float64) float64 {
+	if value := src.get(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+// getIntOrDefault returns the setting 'key' as int or a default
+func (src configSource) getIntOrDefault(key string, defaultValue int) int {
+	if value := src.get(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
 			return intValue
 		}
@@ -0,0 +1,100 @@
+package pgbackup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// configFileKeys maps the keys of a config file to the environment
+// variables they stand for. The PG_BACKUP_ settings drop the prefix.
+var configFileKeys = map[string]string{
+	"pg_host":               "PG_HOST",
+	"pg_port":               "PG_PORT",
+	"pg_user":               "PG_USER_NAME",
+	"pg_password":           "PG_PASSWORD",
+	"pg_database":           "PG_DB_NAME",
+	"pgdata":                "PGDATA",
+	"backup_dir":            "PG_BACKUP_DIR",
+	"schedule":              "PG_BACKUP_SCHEDULE",
+	"cleanup_schedule":      "PG_BACKUP_CLEANUP_SCHEDULE",
+	"sync_schedule":         "PG_BACKUP_SYNC_SCHEDULE",
+	"retain_days":           "PG_BACKUP_RETAIN_DAYS",
+	"retain_count":          "PG_BACKUP_RETAIN_COUNT",
+	"retain_wal_days":       "PG_BACKUP_RETAIN_WAL_DAYS",
+	"retain_labeled":        "PG_BACKUP_RETAIN_LABELED",
+	"verify_max_age_days":   "PG_BACKUP_VERIFY_MAX_AGE_DAYS",
+	"remote_host":           "PG_BACKUP_REMOTE_HOST",
+	"remote_user":           "PG_BACKUP_REMOTE_USER",
+	"remote_dir":            "PG_BACKUP_REMOTE_DIR",
+	"remote_port":           "PG_BACKUP_REMOTE_PORT",
+	"pg_version":            "PG_BACKUP_PG_VERSION",
+	"disk_space_factor":     "PG_BACKUP_DISK_SPACE_FACTOR",
+	"min_free_mb":           "PG_BACKUP_MIN_FREE_MB",
+	"validate_port":         "PG_BACKUP_VALIDATE_PORT",
+	"validate_query":        "PG_BACKUP_VALIDATE_QUERY",
+	"archive_test_timeout":  "PG_BACKUP_ARCHIVE_TEST_TIMEOUT",
+	"duration_alert_factor": "PG_BACKUP_DURATION_ALERT_FACTOR",
+	"alert_webhook_url":     "PG_BACKUP_ALERT_WEBHOOK_URL",
+	"basebackup_args":       "PG_BACKUP_BASEBACKUP_ARGS",
+}
+
+// configSource holds the settings of a config file by environment
+// variable name. get returns the environment variable if it is set, and
+// the file's setting otherwise.
+type configSource map[string]string
+
+func (src configSource) get(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return src[key]
+}
+
+// loadConfigFile reads the config file 'path' (TOML, or YAML for .yaml
+// and .yml files). An empty 'path' means no file. Keys not in
+// configFileKeys are rejected, so that a misspelled setting doesn't
+// silently fall back to its default.
+func loadConfigFile(path string) (configSource, error) {
+	if path == "" {
+		return configSource{}, nil
+	}
+
+	path, err := expandPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand config file path: %w (%s)", err, LOC_CFG_PATH)
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		v.SetConfigType("yaml")
+	default:
+		v.SetConfigType("toml")
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w (%s)", path, err, LOC_CFG_FILE)
+	}
+
+	var unknown []string
+	src := configSource{}
+	for _, key := range v.AllKeys() {
+		env, ok := configFileKeys[key]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		src[env] = v.GetString(key)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown keys in config file %s: %s (%s)",
+			path, strings.Join(unknown, ", "), LOC_CFG_FILE)
+	}
+	return src, nil
+}
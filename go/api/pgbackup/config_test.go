@@ -0,0 +1,63 @@
+package pgbackup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	for _, key := range configFileKeys {
+		t.Setenv(key, "")
+	}
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	toml := write("pgbackup.toml", `
+backup_dir = "`+dir+`"
+pg_user = "backup"
+pg_password = "secret"
+pg_database = "app"
+retain_days = 30
+retain_labeled = true
+`)
+	t.Setenv("PGBACKUP_CONFIG", toml)
+	t.Setenv("PG_BACKUP_RETAIN_DAYS", "10")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if config.PGUser != "backup" || config.PGDatabase != "app" || !config.RetainLabeled {
+		t.Errorf("file settings not loaded: %+v", config)
+	}
+	if config.RetainDays != 10 {
+		t.Errorf("retain days = %d, want 10 from the environment", config.RetainDays)
+	}
+	if config.RetainCount != 3 || config.BaseBackupDir != filepath.Join(dir, "base") {
+		t.Errorf("defaults not applied: %+v", config)
+	}
+
+	yaml := write("pgbackup.yaml", "backup_dir: "+dir+"\npg_user: yaml\npg_password: secret\npg_database: app\n")
+	t.Setenv("PGBACKUP_CONFIG", yaml)
+	if config, err := LoadConfig(); err != nil || config.PGUser != "yaml" {
+		t.Errorf("YAML config: %+v, %v", config, err)
+	}
+
+	bad := write("bad.toml", "backup_dir = \"/tmp\"\nretain_dayz = 3\n[remote]\nhost = \"x\"\n")
+	t.Setenv("PGBACKUP_CONFIG", bad)
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "remote.host, retain_dayz") {
+		t.Errorf("unknown keys: err = %v", err)
+	}
+
+	t.Setenv("PGBACKUP_CONFIG", filepath.Join(dir, "missing.toml"))
+	if _, err := LoadConfig(); err == nil {
+		t.Error("missing config file accepted")
+	}
+}
@@ -0,0 +1,198 @@
+package pgbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Location codes for daemon operations
+const (
+	LOC_DAEMON_START = "SHD_PGB_093"
+	LOC_DAEMON_JOB   = "SHD_PGB_094"
+)
+
+// Jobs run by the daemon
+const (
+	JobBackup  = "backup"
+	JobCleanup = "cleanup"
+	JobSync    = "sync"
+)
+
+// lockRetryDelay is how long a job waits for the lock before retrying
+const lockRetryDelay = time.Minute
+
+// daemonJob is one scheduled job of the daemon
+type daemonJob struct {
+	name     string
+	schedule *Schedule
+	run      func(ctx context.Context) (string, error) // Returns a summary of the run
+	next     time.Time
+}
+
+// Daemon runs backups, cleanups and syncs on their schedules, so hosts
+// don't need cron or launchd. Jobs run one at a time and take the lock
+// file: a job that comes due while another runs (here or in a pgbackup
+// command) is deferred until the lock is free.
+type Daemon struct {
+	service *BackupService
+	logger  *slog.Logger
+	jobs    []*daemonJob
+}
+
+// NewDaemon creates a daemon with the jobs configured by
+// PG_BACKUP_SCHEDULE, PG_BACKUP_CLEANUP_SCHEDULE and
+// PG_BACKUP_SYNC_SCHEDULE. A schedule of "off" disables its job; sync
+// also needs PG_BACKUP_REMOTE_HOST.
+func NewDaemon(service *BackupService, logger *slog.Logger) (*Daemon, error) {
+	d := &Daemon{service: service, logger: logger}
+	config := service.config
+
+	for _, j := range []struct {
+		name string
+		expr string
+		run  func(ctx context.Context) (string, error)
+	}{
+		{JobBackup, config.BackupSchedule, d.runBackup},
+		{JobCleanup, config.CleanupSchedule, d.runCleanup},
+		{JobSync, config.SyncSchedule, d.runSync},
+	} {
+		if j.expr == "" || strings.EqualFold(j.expr, "off") {
+			logger.Info("Scheduled job disabled", "job", j.name)
+			continue
+		}
+		if j.name == JobSync && !config.RemoteEnabled() {
+			logger.Info("Scheduled job disabled, remote sync not configured", "job", j.name)
+			continue
+		}
+		schedule, err := ParseSchedule(j.expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s schedule: %w (%s)", j.name, err, LOC_DAEMON_START)
+		}
+		d.jobs = append(d.jobs, &daemonJob{name: j.name, schedule: schedule, run: j.run})
+	}
+
+	if len(d.jobs) == 0 {
+		return nil, fmt.Errorf("no scheduled jobs enabled (%s)", LOC_DAEMON_START)
+	}
+	return d, nil
+}
+
+// Run runs the jobs on their schedules until 'ctx' is canceled. A job
+// that is running then is allowed to finish, so a backup is not left
+// half-written.
+func (d *Daemon) Run(ctx context.Context) error {
+	now := time.Now()
+	for _, job := range d.jobs {
+		job.next = job.schedule.Next(now)
+		if job.next.IsZero() {
+			return fmt.Errorf("%s schedule %q never matches (%s)", job.name, job.schedule, LOC_DAEMON_START)
+		}
+		d.logger.Info("Scheduled job", "job", job.name, "schedule", job.schedule.String(), "next_run", job.next)
+	}
+
+	for {
+		next := d.jobs[0].next
+		for _, job := range d.jobs[1:] {
+			if job.next.Before(next) {
+				next = job.next
+			}
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			d.logger.Info("Daemon stopped")
+			return nil
+		case <-timer.C:
+		}
+
+		d.runDue(ctx, time.Now())
+	}
+}
+
+// runDue runs the jobs due at 'now' in order and schedules their next
+// runs. The next run is computed from when the job finished, so ticks
+// missed during a long job are not run one after another.
+func (d *Daemon) runDue(ctx context.Context, now time.Time) {
+	for _, job := range d.jobs {
+		if ctx.Err() != nil {
+			return
+		}
+		if job.next.After(now) {
+			continue
+		}
+
+		lock, err := AcquireLock(d.service.config.LockFilePath)
+		if err != nil {
+			if errors.Is(err, ErrLocked) {
+				job.next = now.Add(lockRetryDelay)
+				d.logger.Info("Scheduled job deferred, another operation holds the lock",
+					"job", job.name, "retry_at", job.next)
+			} else {
+				job.next = job.schedule.Next(now)
+				d.logger.Error("Scheduled job failed", "job", job.name, "error", err,
+					"next_run", job.next, "location", LOC_DAEMON_JOB)
+			}
+			continue
+		}
+
+		start := time.Now()
+		d.logger.Info("Scheduled job started", "job", job.name)
+		summary, err := job.run(context.WithoutCancel(ctx))
+		lock.Release()
+
+		now = time.Now()
+		job.next = job.schedule.Next(now)
+		if err != nil {
+			d.logger.Error("Scheduled job failed",
+				"job", job.name,
+				"error", err,
+				"duration", now.Sub(start).Round(time.Second),
+				"next_run", job.next,
+				"location", LOC_DAEMON_JOB)
+			continue
+		}
+		d.logger.Info("Scheduled job finished",
+			"job", job.name,
+			"result", summary,
+			"duration", now.Sub(start).Round(time.Second),
+			"next_run", job.next)
+	}
+}
+
+func (d *Daemon) runBackup(ctx context.Context) (string, error) {
+	if err := d.service.CheckDiskSpace(ctx, d.logger); err != nil {
+		return "", fmt.Errorf("disk space check failed: %w", err)
+	}
+	result, err := d.service.PerformBaseBackup(ctx, d.logger, nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("backup %s, %.2f MB", result.BackupID, float64(result.SizeBytes)/(1024*1024)), nil
+}
+
+func (d *Daemon) runCleanup(ctx context.Context) (string, error) {
+	result, err := d.service.ApplyRetention(ctx, d.logger)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("deleted %d backups and %d WAL files, freed %.2f MB",
+		len(result.DeletedBackups), result.DeletedWALFiles,
+		float64(result.FreedSpaceBytes)/(1024*1024)), nil
+}
+
+func (d *Daemon) runSync(ctx context.Context) (string, error) {
+	result, err := d.service.SyncAll(ctx, d.logger)
+	if err != nil {
+		return "", err
+	}
+	if !result.Success {
+		return "", fmt.Errorf("remote sync failed: %s", result.ErrorMsg)
+	}
+	return "synced to " + result.Destination, nil
+}
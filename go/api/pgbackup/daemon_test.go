@@ -0,0 +1,98 @@
+package pgbackup
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDaemon(t *testing.T) {
+	config := &BackupConfig{BackupSchedule: "0 2 * * *", CleanupSchedule: "off", SyncSchedule: "0 * * * *"}
+	d, err := NewDaemon(NewBackupService(config), testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sync needs a remote host
+	if len(d.jobs) != 1 || d.jobs[0].name != JobBackup {
+		t.Errorf("jobs = %v, want only backup", d.jobs)
+	}
+
+	config.RemoteHost = "backup.example.com"
+	if d, err = NewDaemon(NewBackupService(config), testLogger); err != nil || len(d.jobs) != 2 {
+		t.Errorf("with remote: %v jobs, error %v, want 2", len(d.jobs), err)
+	}
+
+	config.BackupSchedule = "0 2 * *"
+	if _, err := NewDaemon(NewBackupService(config), testLogger); err == nil ||
+		!strings.Contains(err.Error(), "backup schedule") {
+		t.Errorf("invalid schedule: error = %v", err)
+	}
+
+	_, err = NewDaemon(NewBackupService(&BackupConfig{BackupSchedule: "off", CleanupSchedule: "OFF"}), testLogger)
+	if err == nil || !strings.Contains(err.Error(), "no scheduled jobs") {
+		t.Errorf("no jobs: error = %v", err)
+	}
+}
+
+func TestDaemonRunDue(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), ".pgbackup.lock")
+	d := &Daemon{
+		service: NewBackupService(&BackupConfig{LockFilePath: lockPath}),
+		logger:  testLogger,
+	}
+	var ran []string
+	job := func(name string, expr string, err error) *daemonJob {
+		s, _ := ParseSchedule(expr)
+		return &daemonJob{name: name, schedule: s, run: func(context.Context) (string, error) {
+			ran = append(ran, name)
+			// Jobs run under the lock
+			if _, lockErr := AcquireLock(lockPath); !errors.Is(lockErr, ErrLocked) {
+				t.Errorf("%s ran without the lock: %v", name, lockErr)
+			}
+			return "done", err
+		}}
+	}
+	backup := job(JobBackup, "0 2 * * *", nil)
+	cleanup := job(JobCleanup, "0 3 * * 0", errors.New("disk full"))
+	d.jobs = []*daemonJob{backup, cleanup}
+
+	now := time.Now()
+	backup.next = now.Add(-time.Minute)
+	cleanup.next = now.Add(time.Hour)
+	d.runDue(context.Background(), now)
+	if len(ran) != 1 || ran[0] != JobBackup {
+		t.Errorf("ran = %v, want [backup]", ran)
+	}
+	if !backup.next.After(now) || !cleanup.next.Equal(now.Add(time.Hour)) {
+		t.Errorf("next runs = %v, %v", backup.next, cleanup.next)
+	}
+
+	// A failed job is rescheduled like a successful one
+	ran = nil
+	cleanup.next = now
+	d.runDue(context.Background(), now)
+	if len(ran) != 1 || !cleanup.next.After(now) {
+		t.Errorf("failed job: ran = %v, next = %v", ran, cleanup.next)
+	}
+
+	// A job due while another operation holds the lock is deferred
+	lock, err := AcquireLock(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ran = nil
+	backup.next = now
+	d.runDue(context.Background(), now)
+	if len(ran) != 0 || !backup.next.Equal(now.Add(lockRetryDelay)) {
+		t.Errorf("locked: ran = %v, next = %v, want a retry at %v", ran, backup.next, now.Add(lockRetryDelay))
+	}
+
+	lock.Release()
+	d.runDue(context.Background(), backup.next)
+	if len(ran) != 1 {
+		t.Errorf("after release: ran = %v, want [backup]", ran)
+	}
+}
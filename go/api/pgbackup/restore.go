@@ -2,11 +2,13 @@ package pgbackup
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,6 +20,7 @@ const (
 	LOC_RESTORE_EXTRACT = "SHD_PGB_052"
 	LOC_RESTORE_CONFIG  = "SHD_PGB_053"
 	LOC_RESTORE_WAL     = "SHD_PGB_054"
+	LOC_RESTORE_VERSION = "SHD_PGB_055"
 )
 
 // RestoreOptions configures a restore operation
@@ -26,8 +29,13 @@ type RestoreOptions struct {
 	TargetTime      *time.Time // Point-in-time recovery target (optional)
 	TargetXID       string     // Recovery target transaction ID (optional)
 	TargetName      string     // Recovery target named restore point (optional)
+	TargetImmediate bool       // Stop as soon as the backup is consistent (optional)
 	TargetDirectory string     // Where to restore (defaults to PGDATA)
 	DryRun          bool       // Just validate, don't actually restore
+
+	// Validate, if set, starts a throwaway PostgreSQL on the restored
+	// directory after the restore and runs a validation query
+	Validate *ValidateOptions
 }
 
 // RestoreResult contains information about a restore operation
@@ -37,7 +45,10 @@ type RestoreResult struct {
 	RecoveredTo  time.Time `json:"recovered_to,omitempty"`
 	WALFilesUsed int       `json:"wal_files_used"`
 	TargetDir    string    `json:"target_dir"`
+	PGVersion    string    `json:"pg_version,omitempty"`
 	ErrorMsg     string    `json:"error_msg,omitempty"`
+
+	Validation *ValidateResult `json:"validation,omitempty"`
 }
 
 // PrepareRestore validates and prepares for a restore operation
@@ -49,27 +60,58 @@ func (s *BackupService) PrepareRestore(ctx context.Context, logger *slog.Logger,
 		"dry_run", opts.DryRun)
 
 	// 1. Verify backup exists
-	backupPath := filepath.Join(s.config.BaseBackupDir, opts.BackupID)
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+	if err := validateObjectName("backup ID", opts.BackupID); err != nil {
+		return fmt.Errorf("%w (%s)", err, LOC_RESTORE_START)
+	}
+	objects, err := s.store.List(ctx, opts.BackupID)
+	if err != nil {
+		return fmt.Errorf("failed to list backup files: %w (%s)", err, LOC_RESTORE_START)
+	}
+	if len(objects) == 0 {
 		return fmt.Errorf("backup not found: %s (%s)", opts.BackupID, LOC_RESTORE_START)
 	}
 
 	// 2. Verify backup has required files (base.tar.gz at minimum)
-	baseTar := filepath.Join(backupPath, "base.tar.gz")
-	if _, err := os.Stat(baseTar); os.IsNotExist(err) {
-		return fmt.Errorf("backup is incomplete (missing base.tar.gz): %s (%s)", opts.BackupID, LOC_RESTORE_VALIDATE)
+	if _, err := s.store.Stat(ctx, opts.BackupID, "base.tar.gz"); err != nil {
+		if errors.Is(err, ErrBackupObjectNotFound) {
+			return fmt.Errorf("backup is incomplete (missing base.tar.gz): %s (%s)", opts.BackupID, LOC_RESTORE_VALIDATE)
+		}
+		return fmt.Errorf("failed to stat base.tar.gz: %w (%s)", err, LOC_RESTORE_VALIDATE)
 	}
 
-	// 3. Determine target directory
+	// 3. Check the target directory and that PostgreSQL is stopped
+	targetDir, err := s.checkRestoreTarget(ctx, logger, opts)
+	if err != nil {
+		return err
+	}
+
+	// 4. If target time specified, verify WAL files are available
+	if opts.TargetTime != nil {
+		if err := s.verifyWALAvailability(logger, opts.BackupID, *opts.TargetTime); err != nil {
+			logger.Warn("WAL availability check", "warning", err)
+		}
+	}
+
+	logger.Info("Restore preparation complete",
+		"backup_id", opts.BackupID,
+		"target_dir", targetDir)
+
+	return nil
+}
+
+// checkRestoreTarget returns the directory to restore into. It must be
+// empty (unless it is a dry run) and PostgreSQL must be stopped.
+func (s *BackupService) checkRestoreTarget(ctx context.Context, logger *slog.Logger, opts RestoreOptions) (string, error) {
+	// Determine target directory
 	targetDir := opts.TargetDirectory
 	if targetDir == "" {
 		targetDir = s.config.PGDataDir
 	}
 	if targetDir == "" {
-		return fmt.Errorf("target directory not specified and PGDATA not set (%s)", LOC_RESTORE_START)
+		return "", fmt.Errorf("target directory not specified and PGDATA not set (%s)", LOC_RESTORE_START)
 	}
 
-	// 4. Check if target directory exists and has data
+	// Check if target directory exists and has data
 	if _, err := os.Stat(targetDir); err == nil {
 		entries, _ := os.ReadDir(targetDir)
 		if len(entries) > 0 {
@@ -78,29 +120,17 @@ func (s *BackupService) PrepareRestore(ctx context.Context, logger *slog.Logger,
 				"path", targetDir,
 				"files", len(entries))
 			if !opts.DryRun {
-				return fmt.Errorf("target directory %s is not empty - back it up first or specify a different directory (%s)",
+				return "", fmt.Errorf("target directory %s is not empty - back it up first or specify a different directory (%s)",
 					targetDir, LOC_RESTORE_VALIDATE)
 			}
 		}
 	}
 
-	// 5. Check if PostgreSQL is running (it should be stopped)
+	// Check if PostgreSQL is running (it should be stopped)
 	if s.isPostgreSQLRunning(ctx, logger) {
-		return fmt.Errorf("PostgreSQL appears to be running - stop it before restore (%s)", LOC_RESTORE_START)
+		return "", fmt.Errorf("PostgreSQL appears to be running - stop it before restore (%s)", LOC_RESTORE_START)
 	}
-
-	// 6. If target time specified, verify WAL files are available
-	if opts.TargetTime != nil {
-		if err := s.verifyWALAvailability(logger, opts.BackupID, *opts.TargetTime); err != nil {
-			logger.Warn("WAL availability check", "warning", err)
-		}
-	}
-
-	logger.Info("Restore preparation complete",
-		"backup_id", opts.BackupID,
-		"target_dir", targetDir)
-
-	return nil
+	return targetDir, nil
 }
 
 // Restore performs the actual restore operation
@@ -128,8 +158,6 @@ func (s *BackupService) Restore(ctx context.Context, logger *slog.Logger, opts R
 		return result, nil
 	}
 
-	backupPath := filepath.Join(s.config.BaseBackupDir, opts.BackupID)
-
 	// 1. Create target directory if it doesn't exist
 	if err := os.MkdirAll(targetDir, 0700); err != nil {
 		result.Success = false
@@ -138,15 +166,23 @@ func (s *BackupService) Restore(ctx context.Context, logger *slog.Logger, opts R
 	}
 
 	// 2. Extract base backup
-	logger.Info("Extracting base backup", "from", backupPath, "to", targetDir)
-	if err := s.extractBackup(ctx, logger, backupPath, targetDir); err != nil {
+	logger.Info("Extracting base backup", "from", s.store.Location(opts.BackupID), "to", targetDir)
+	if err := s.extractBackup(ctx, logger, opts.BackupID, targetDir); err != nil {
 		result.Success = false
 		result.ErrorMsg = fmt.Sprintf("failed to extract backup: %v", err)
 		return result, fmt.Errorf("%s (%s)", result.ErrorMsg, LOC_RESTORE_EXTRACT)
 	}
 
-	// 3. Create recovery configuration (PostgreSQL 12+)
-	if err := s.createRecoveryConfig(logger, targetDir, opts); err != nil {
+	// 3. Create recovery configuration in the format of the backup's version
+	pgVersion, err := s.restoreVersion(logger, targetDir)
+	if err != nil {
+		result.Success = false
+		result.ErrorMsg = err.Error()
+		return result, err
+	}
+	result.PGVersion = pgVersion.String()
+
+	if err := s.createRecoveryConfig(logger, targetDir, pgVersion, opts); err != nil {
 		result.Success = false
 		result.ErrorMsg = fmt.Sprintf("failed to create recovery config: %v", err)
 		return result, fmt.Errorf("%s (%s)", result.ErrorMsg, LOC_RESTORE_CONFIG)
@@ -157,6 +193,21 @@ func (s *BackupService) Restore(ctx context.Context, logger *slog.Logger, opts R
 		result.RecoveredTo = *opts.TargetTime
 	}
 
+	if opts.Validate != nil {
+		// The restore itself succeeded; a failed validation is reported
+		// in result.Validation and returned as the error.
+		validation, err := s.ValidateRestore(ctx, logger, targetDir, *opts.Validate)
+		result.Validation = validation
+		if err != nil {
+			return result, err
+		}
+		logger.Info("Restore complete and validated",
+			"backup_used", opts.BackupID,
+			"target_dir", targetDir,
+			"recovery_duration", validation.RecoveryDuration)
+		return result, nil
+	}
+
 	logger.Info("Restore complete - start PostgreSQL to begin recovery",
 		"backup_used", opts.BackupID,
 		"target_dir", targetDir,
@@ -165,33 +216,41 @@ func (s *BackupService) Restore(ctx context.Context, logger *slog.Logger, opts R
 	return result, nil
 }
 
-// extractBackup extracts the backup tar files to the target directory
-func (s *BackupService) extractBackup(ctx context.Context, logger *slog.Logger, backupPath, targetDir string) error {
+// extractBackup streams the tar files of a backup from the store into tar
+// to extract them to the target directory
+func (s *BackupService) extractBackup(ctx context.Context, logger *slog.Logger, backupID, targetDir string) error {
 	// Find tar files in backup
-	entries, err := os.ReadDir(backupPath)
+	objects, err := s.store.List(ctx, backupID)
 	if err != nil {
-		return fmt.Errorf("failed to read backup directory: %w", err)
+		return fmt.Errorf("failed to list backup files: %w", err)
 	}
 
-	for _, entry := range entries {
-		if !strings.HasSuffix(entry.Name(), ".tar.gz") && !strings.HasSuffix(entry.Name(), ".tar") {
+	for _, obj := range objects {
+		if !strings.HasSuffix(obj.Name, ".tar.gz") && !strings.HasSuffix(obj.Name, ".tar") {
 			continue
 		}
 
-		tarPath := filepath.Join(backupPath, entry.Name())
-		logger.Info("Extracting", "file", entry.Name())
+		logger.Info("Extracting", "file", obj.Name, "size_mb", float64(obj.Size)/(1024*1024))
+
+		r, err := s.store.Open(ctx, backupID, obj.Name)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", obj.Name, err)
+		}
 
 		var cmd *exec.Cmd
-		if strings.HasSuffix(entry.Name(), ".tar.gz") {
+		if strings.HasSuffix(obj.Name, ".tar.gz") {
 			// Compressed tar
-			cmd = exec.CommandContext(ctx, "tar", "-xzf", tarPath, "-C", targetDir)
+			cmd = exec.CommandContext(ctx, "tar", "-xzf", "-", "-C", targetDir)
 		} else {
 			// Uncompressed tar
-			cmd = exec.CommandContext(ctx, "tar", "-xf", tarPath, "-C", targetDir)
+			cmd = exec.CommandContext(ctx, "tar", "-xf", "-", "-C", targetDir)
 		}
+		cmd.Stdin = r
 
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to extract %s: %v, output: %s", entry.Name(), err, string(output))
+		output, err := cmd.CombinedOutput()
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %v, output: %s", obj.Name, err, string(output))
 		}
 	}
 
@@ -203,21 +262,93 @@ func (s *BackupService) extractBackup(ctx context.Context, logger *slog.Logger,
 	return nil
 }
 
-// createRecoveryConfig creates the recovery configuration files (PostgreSQL 12+)
-func (s *BackupService) createRecoveryConfig(logger *slog.Logger, pgDataDir string, opts RestoreOptions) error {
-	// For PostgreSQL 12+: create recovery.signal and set parameters in postgresql.auto.conf
+// PGVersion is a PostgreSQL major version as written to PG_VERSION in
+// the data directory: "9.6" before PostgreSQL 10, "11" since.
+type PGVersion struct {
+	Major int
+	Minor int // Only before PostgreSQL 10
+}
 
-	// 1. Create recovery.signal (empty file, signals recovery mode)
-	signalPath := filepath.Join(pgDataDir, "recovery.signal")
-	if err := os.WriteFile(signalPath, []byte{}, 0600); err != nil {
-		return fmt.Errorf("failed to create recovery.signal: %w", err)
+// Recovery targets with recovery_target_action need PostgreSQL 9.5+
+var minRecoveryPGVersion = PGVersion{Major: 9, Minor: 5}
+
+// ParsePGVersion parses a major version such as "11", "9.6" or "16.2"
+// (the minor release of 10+ is ignored).
+func ParsePGVersion(s string) (PGVersion, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.Split(s, ".")
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major <= 0 || len(parts) > 3 {
+		return PGVersion{}, fmt.Errorf("invalid PostgreSQL version %q (%s)", s, LOC_RESTORE_VERSION)
+	}
+	v := PGVersion{Major: major}
+	if major < 10 {
+		if len(parts) < 2 {
+			return PGVersion{}, fmt.Errorf("invalid PostgreSQL version %q, expecting 9.x (%s)", s, LOC_RESTORE_VERSION)
+		}
+		if v.Minor, err = strconv.Atoi(parts[1]); err != nil {
+			return PGVersion{}, fmt.Errorf("invalid PostgreSQL version %q (%s)", s, LOC_RESTORE_VERSION)
+		}
+	}
+	return v, nil
+}
+
+func (v PGVersion) String() string {
+	if v.Major < 10 {
+		return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+	}
+	return strconv.Itoa(v.Major)
+}
+
+func (v PGVersion) less(o PGVersion) bool {
+	return v.Major < o.Major || (v.Major == o.Major && v.Minor < o.Minor)
+}
+
+// usesRecoveryConf tells versions before 12, which read recovery
+// parameters from recovery.conf instead of recovery.signal and
+// postgresql.auto.conf
+func (v PGVersion) usesRecoveryConf() bool {
+	return v.Major < 12
+}
+
+// restoreVersion returns the version of the restored data directory: its
+// PG_VERSION file, or PG_BACKUP_PG_VERSION if the file is missing.
+func (s *BackupService) restoreVersion(logger *slog.Logger, pgDataDir string) (PGVersion, error) {
+	var v PGVersion
+	data, err := os.ReadFile(filepath.Join(pgDataDir, "PG_VERSION"))
+	switch {
+	case err == nil:
+		if v, err = ParsePGVersion(string(data)); err != nil {
+			return v, fmt.Errorf("PG_VERSION in %s: %w", pgDataDir, err)
+		}
+		if s.config.PGVersion != "" && s.config.PGVersion != v.String() {
+			logger.Warn("PG_BACKUP_PG_VERSION differs from the backup, using the backup's PG_VERSION",
+				"configured", s.config.PGVersion, "pg_version", v.String())
+		}
+
+	case os.IsNotExist(err) && s.config.PGVersion != "":
+		if v, err = ParsePGVersion(s.config.PGVersion); err != nil {
+			return v, fmt.Errorf("PG_BACKUP_PG_VERSION: %w", err)
+		}
+
+	case os.IsNotExist(err):
+		return v, fmt.Errorf("no PG_VERSION in %s and PG_BACKUP_PG_VERSION not set (%s)",
+			pgDataDir, LOC_RESTORE_VERSION)
+
+	default:
+		return v, fmt.Errorf("failed to read PG_VERSION: %w (%s)", err, LOC_RESTORE_VERSION)
 	}
-	logger.Info("Created recovery.signal")
 
-	// 2. Build recovery parameters
+	if v.less(minRecoveryPGVersion) {
+		return v, fmt.Errorf("PostgreSQL %s is not supported, restore needs %s or later (%s)",
+			v, minRecoveryPGVersion, LOC_RESTORE_VERSION)
+	}
+	return v, nil
+}
+
+// recoveryParams returns the recovery parameters for 'opts', one per line
+func (s *BackupService) recoveryParams(logger *slog.Logger, opts RestoreOptions) string {
 	var recoveryParams strings.Builder
-	recoveryParams.WriteString("\n# Recovery configuration added by pgbackup\n")
-	recoveryParams.WriteString("# Remove these lines after recovery is complete\n")
 
 	// restore_command - how to fetch archived WAL files
 	restoreCmd := fmt.Sprintf("gunzip -c %s/%%f.gz > %%p || cp %s/%%f %%p",
@@ -241,10 +372,46 @@ func (s *BackupService) createRecoveryConfig(logger *slog.Logger, pgDataDir stri
 		logger.Info("Set recovery target name", "name", opts.TargetName)
 	}
 
+	if opts.TargetImmediate {
+		recoveryParams.WriteString("recovery_target = 'immediate'\n")
+		logger.Info("Set recovery target to the end of the backup")
+	}
+
 	// What to do when recovery target is reached
 	recoveryParams.WriteString("recovery_target_action = 'promote'\n")
+	return recoveryParams.String()
+}
+
+// createRecoveryConfig creates the recovery configuration files in the
+// format of version 'v': recovery.conf before PostgreSQL 12,
+// recovery.signal and postgresql.auto.conf since.
+func (s *BackupService) createRecoveryConfig(
+	logger *slog.Logger,
+	pgDataDir string,
+	v PGVersion,
+	opts RestoreOptions) error {
+	params := s.recoveryParams(logger, opts)
+
+	if v.usesRecoveryConf() {
+		// recovery.conf holds the parameters and signals recovery mode;
+		// PostgreSQL renames it to recovery.done when recovery completes
+		content := "# Recovery configuration written by pgbackup\n" + params
+		confPath := filepath.Join(pgDataDir, "recovery.conf")
+		if err := os.WriteFile(confPath, []byte(content), 0600); err != nil {
+			return fmt.Errorf("failed to write recovery.conf: %w", err)
+		}
+		logger.Info("Created recovery.conf", "pg_version", v.String())
+		return nil
+	}
+
+	// 1. Create recovery.signal (empty file, signals recovery mode)
+	signalPath := filepath.Join(pgDataDir, "recovery.signal")
+	if err := os.WriteFile(signalPath, []byte{}, 0600); err != nil {
+		return fmt.Errorf("failed to create recovery.signal: %w", err)
+	}
+	logger.Info("Created recovery.signal")
 
-	// 3. Append to postgresql.auto.conf
+	// 2. Append the parameters to postgresql.auto.conf
 	autoConfPath := filepath.Join(pgDataDir, "postgresql.auto.conf")
 
 	f, err := os.OpenFile(autoConfPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
@@ -253,11 +420,13 @@ func (s *BackupService) createRecoveryConfig(logger *slog.Logger, pgDataDir stri
 	}
 	defer f.Close()
 
-	if _, err := f.WriteString(recoveryParams.String()); err != nil {
+	content := "\n# Recovery configuration added by pgbackup\n" +
+		"# Remove these lines after recovery is complete\n" + params
+	if _, err := f.WriteString(content); err != nil {
 		return fmt.Errorf("failed to write recovery params: %w", err)
 	}
 
-	logger.Info("Updated postgresql.auto.conf with recovery parameters")
+	logger.Info("Updated postgresql.auto.conf with recovery parameters", "pg_version", v.String())
 	return nil
 }
 
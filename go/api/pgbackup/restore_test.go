@@ -0,0 +1,142 @@
+package pgbackup
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestParsePGVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    PGVersion
+		wantErr bool
+	}{
+		{in: "16\n", want: PGVersion{Major: 16}},
+		{in: "11", want: PGVersion{Major: 11}},
+		{in: "10.4", want: PGVersion{Major: 10}},
+		{in: "9.6", want: PGVersion{Major: 9, Minor: 6}},
+		{in: "9", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "v12", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePGVersion(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%q: got %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		pgVersion  string // PG_VERSION file content; "" for none
+		configured string
+		want       string
+		wantErr    string
+	}{
+		{name: "from data dir", pgVersion: "11\n", want: "11"},
+		{name: "data dir wins over config", pgVersion: "16\n", configured: "11", want: "16"},
+		{name: "from config", configured: "9.6", want: "9.6"},
+		{name: "unknown", wantErr: "PG_BACKUP_PG_VERSION not set"},
+		{name: "too old", pgVersion: "9.4\n", wantErr: "PostgreSQL 9.4 is not supported"},
+		{name: "garbage", pgVersion: "abc\n", wantErr: "invalid PostgreSQL version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.pgVersion != "" {
+				if err := os.WriteFile(filepath.Join(dir, "PG_VERSION"), []byte(tt.pgVersion), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := NewBackupService(&BackupConfig{PGVersion: tt.configured})
+			got, err := s.restoreVersion(testLogger, dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got.String() != tt.want {
+				t.Errorf("got %v, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateRecoveryConfig(t *testing.T) {
+	target := time.Date(2026, 2, 2, 14, 30, 0, 0, time.UTC)
+	opts := RestoreOptions{TargetTime: &target, TargetName: "before_migration"}
+	params := "restore_command = 'gunzip -c /backup/wal/%f.gz > %p || cp /backup/wal/%f %p'\n" +
+		"recovery_target_time = '2026-02-02 14:30:00+00'\n" +
+		"recovery_target_name = 'before_migration'\n" +
+		"recovery_target_action = 'promote'\n"
+
+	t.Run("recovery.conf before 12", func(t *testing.T) {
+		for _, version := range []string{"9.6", "11"} {
+			dir := t.TempDir()
+			v, _ := ParsePGVersion(version)
+			s := NewBackupService(&BackupConfig{WALArchiveDir: "/backup/wal"})
+			if err := s.createRecoveryConfig(testLogger, dir, v, opts); err != nil {
+				t.Fatalf("%s: %v", version, err)
+			}
+
+			want := "# Recovery configuration written by pgbackup\n" + params
+			if got := readFile(t, filepath.Join(dir, "recovery.conf")); got != want {
+				t.Errorf("%s: recovery.conf =\n%s\nwant\n%s", version, got, want)
+			}
+			for _, name := range []string{"recovery.signal", "postgresql.auto.conf"} {
+				if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+					t.Errorf("%s: unexpected %s", version, name)
+				}
+			}
+		}
+	})
+
+	t.Run("recovery.signal since 12", func(t *testing.T) {
+		dir := t.TempDir()
+		existing := "# Do not edit this file manually!\nwal_level = 'replica'\n"
+		if err := os.WriteFile(filepath.Join(dir, "postgresql.auto.conf"), []byte(existing), 0600); err != nil {
+			t.Fatal(err)
+		}
+		s := NewBackupService(&BackupConfig{WALArchiveDir: "/backup/wal"})
+		if err := s.createRecoveryConfig(testLogger, dir, PGVersion{Major: 12}, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := readFile(t, filepath.Join(dir, "recovery.signal")); got != "" {
+			t.Errorf("recovery.signal = %q, want empty", got)
+		}
+		want := existing + "\n# Recovery configuration added by pgbackup\n" +
+			"# Remove these lines after recovery is complete\n" + params
+		if got := readFile(t, filepath.Join(dir, "postgresql.auto.conf")); got != want {
+			t.Errorf("postgresql.auto.conf =\n%s\nwant\n%s", got, want)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "recovery.conf")); !os.IsNotExist(err) {
+			t.Error("unexpected recovery.conf")
+		}
+	})
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
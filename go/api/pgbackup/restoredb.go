@@ -0,0 +1,235 @@
+package pgbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Location codes for restores into a new database
+const (
+	LOC_RESTOREDB_NAME    = "SHD_PGB_120"
+	LOC_RESTOREDB_START   = "SHD_PGB_121"
+	LOC_RESTOREDB_EXISTS  = "SHD_PGB_122"
+	LOC_RESTOREDB_EXTRACT = "SHD_PGB_123"
+	LOC_RESTOREDB_DUMP    = "SHD_PGB_124"
+	LOC_RESTOREDB_LOAD    = "SHD_PGB_125"
+)
+
+// DBRestoreOptions configures the restore of one database of a backup
+// into a new database on the running server
+type DBRestoreOptions struct {
+	BackupID   string
+	DBName     string        // Database to create on the running server
+	SourceDB   string        // Database of the backup to load (default: PG_DB_NAME)
+	TargetTime *time.Time    // Replay archived WAL up to this time (default: end of the backup)
+	WorkDir    string        // Where the backup is extracted (default: system temp directory)
+	Port       int           // Port for the throwaway instance (default: PG_BACKUP_VALIDATE_PORT)
+	Timeout    time.Duration // How long to wait for recovery to finish (default: 30m)
+	BinDir     string        // Directory of pg_ctl, psql, pg_dump and pg_restore (default: PATH)
+}
+
+// DBRestoreResult contains information about a restore into a new database
+type DBRestoreResult struct {
+	Success          bool          `json:"success"`
+	BackupUsed       string        `json:"backup_used"`
+	SourceDB         string        `json:"source_db"`
+	DBName           string        `json:"db_name"`
+	RecoveredTo      time.Time     `json:"recovered_to,omitempty"`
+	PGVersion        string        `json:"pg_version,omitempty"`
+	RecoveryDuration time.Duration `json:"recovery_duration"`
+	ErrorMsg         string        `json:"error_msg,omitempty"`
+}
+
+// RestoreToDB loads one database of a backup into a new database on the
+// running server, e.g. to look at or copy back data without stopping
+// the cluster.
+//
+// The backup is extracted into a temporary directory and recovered by a
+// throwaway PostgreSQL (as ValidateRestore does); the source database is
+// then copied with pg_dump and pg_restore. Only that database is copied:
+// roles and other global objects are not, and the objects are owned by
+// PG_DB_USER. The new database must not exist yet; if loading fails it
+// is dropped again.
+func (s *BackupService) RestoreToDB(
+	ctx context.Context,
+	logger *slog.Logger,
+	opts DBRestoreOptions) (*DBRestoreResult, error) {
+	if opts.SourceDB == "" {
+		opts.SourceDB = s.config.PGDatabase
+	}
+	if opts.Port == 0 {
+		opts.Port = s.config.ValidatePort
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Minute
+	}
+	result := &DBRestoreResult{
+		BackupUsed: opts.BackupID,
+		SourceDB:   opts.SourceDB,
+		DBName:     opts.DBName,
+	}
+
+	fail := func(loc string, format string, args ...interface{}) (*DBRestoreResult, error) {
+		result.Success = false
+		result.ErrorMsg = fmt.Sprintf(format, args...)
+		logger.Error("Restore to database failed", "error", result.ErrorMsg, "db_name", opts.DBName)
+		return result, fmt.Errorf("%s (%s)", result.ErrorMsg, loc)
+	}
+
+	// 1. Database names are passed to SQL, so they must be plain identifiers
+	for _, name := range []string{opts.DBName, opts.SourceDB} {
+		if !identPattern.MatchString(name) {
+			return fail(LOC_RESTOREDB_NAME,
+				"invalid database name %q: use letters, digits, '_' or '$'", name)
+		}
+	}
+
+	// 2. The backup must be complete
+	if err := validateObjectName("backup ID", opts.BackupID); err != nil {
+		return fail(LOC_RESTOREDB_START, "%v", err)
+	}
+	if _, err := s.store.Stat(ctx, opts.BackupID, "base.tar.gz"); err != nil {
+		if errors.Is(err, ErrBackupObjectNotFound) {
+			return fail(LOC_RESTOREDB_START, "backup not found or incomplete (missing base.tar.gz): %s", opts.BackupID)
+		}
+		return fail(LOC_RESTOREDB_START, "failed to stat base.tar.gz: %v", err)
+	}
+
+	// 3. The new database must not exist
+	exists, err := s.serverQuery(ctx, opts.BinDir,
+		fmt.Sprintf("SELECT 1 FROM pg_catalog.pg_database WHERE datname = '%s'", opts.DBName))
+	if err != nil {
+		return fail(LOC_RESTOREDB_EXISTS, "failed to check for database %s: %v", opts.DBName, err)
+	}
+	if exists != "" {
+		return fail(LOC_RESTOREDB_EXISTS, "database %s already exists - choose a new name", opts.DBName)
+	}
+
+	logger.Info("Restoring database into a new database",
+		"backup_id", opts.BackupID,
+		"source_db", opts.SourceDB,
+		"db_name", opts.DBName,
+		"target_time", opts.TargetTime)
+
+	// 4. Extract and configure recovery in a temporary data directory
+	workDir, err := os.MkdirTemp(opts.WorkDir, "pgbackup-restoredb-")
+	if err != nil {
+		return fail(LOC_RESTOREDB_EXTRACT, "failed to create work directory: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+	dataDir := filepath.Join(workDir, "data")
+	if err := os.Mkdir(dataDir, 0700); err != nil {
+		return fail(LOC_RESTOREDB_EXTRACT, "failed to create data directory: %v", err)
+	}
+
+	if err := s.extractBackup(ctx, logger, opts.BackupID, dataDir); err != nil {
+		return fail(LOC_RESTOREDB_EXTRACT, "failed to extract backup: %v", err)
+	}
+	pgVersion, err := s.restoreVersion(logger, dataDir)
+	if err != nil {
+		return fail(LOC_RESTOREDB_EXTRACT, "%v", err)
+	}
+	result.PGVersion = pgVersion.String()
+
+	recovery := RestoreOptions{BackupID: opts.BackupID, TargetTime: opts.TargetTime}
+	if opts.TargetTime == nil {
+		recovery.TargetImmediate = true
+	}
+	if err := s.createRecoveryConfig(logger, dataDir, pgVersion, recovery); err != nil {
+		return fail(LOC_RESTOREDB_EXTRACT, "failed to create recovery config: %v", err)
+	}
+
+	// 5. Recover it with a throwaway instance and dump the source database
+	validateOpts := ValidateOptions{Port: opts.Port, Timeout: opts.Timeout, BinDir: opts.BinDir}
+	inst, loc, err := s.startThrowaway(ctx, logger, dataDir, validateOpts)
+	if err != nil {
+		return fail(loc, "%v", err)
+	}
+	result.RecoveryDuration = inst.recoveryDuration
+
+	dumpPath := filepath.Join(workDir, opts.SourceDB+".dump")
+	dumpCmd := exec.CommandContext(ctx, s.pgBinary(opts.BinDir, "pg_dump"),
+		"-h", inst.socketDir,
+		"-p", fmt.Sprintf("%d", opts.Port),
+		"-U", s.config.PGUser,
+		"-d", opts.SourceDB,
+		"-Fc",
+		"-f", dumpPath,
+	)
+	dumpCmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.PGPassword))
+	output, err := dumpCmd.CombinedOutput()
+	inst.stop(logger)
+	if err != nil {
+		return fail(LOC_RESTOREDB_DUMP, "pg_dump of %s failed: %v, output: %s",
+			opts.SourceDB, err, strings.TrimSpace(string(output)))
+	}
+
+	// 6. Create the new database and load the dump into it
+	if _, err := s.serverQuery(ctx, opts.BinDir, fmt.Sprintf(`CREATE DATABASE "%s"`, opts.DBName)); err != nil {
+		return fail(LOC_RESTOREDB_LOAD, "failed to create database %s: %v", opts.DBName, err)
+	}
+
+	// --no-owner/--no-privileges: the roles of the backup need not exist
+	// on the running server
+	restoreCmd := exec.CommandContext(ctx, s.pgBinary(opts.BinDir, "pg_restore"),
+		"-h", s.config.PGHost,
+		"-p", fmt.Sprintf("%d", s.config.PGPort),
+		"-U", s.config.PGUser,
+		"-d", opts.DBName,
+		"--no-owner",
+		"--no-privileges",
+		"--exit-on-error",
+		dumpPath,
+	)
+	restoreCmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.PGPassword))
+	if output, err := restoreCmd.CombinedOutput(); err != nil {
+		// The database is ours, so a partial load is not left behind
+		if _, dropErr := s.serverQuery(context.Background(), opts.BinDir,
+			fmt.Sprintf(`DROP DATABASE IF EXISTS "%s"`, opts.DBName)); dropErr != nil {
+			logger.Error("Failed to drop partially restored database",
+				"db_name", opts.DBName,
+				"error", dropErr,
+				"loc", LOC_RESTOREDB_LOAD)
+		}
+		return fail(LOC_RESTOREDB_LOAD, "pg_restore into %s failed: %v, output: %s",
+			opts.DBName, err, strings.TrimSpace(string(output)))
+	}
+
+	result.Success = true
+	if opts.TargetTime != nil {
+		result.RecoveredTo = *opts.TargetTime
+	}
+	logger.Info("Restore to database completed successfully",
+		"backup_used", opts.BackupID,
+		"source_db", opts.SourceDB,
+		"db_name", opts.DBName,
+		"recovery_duration", result.RecoveryDuration)
+	return result, nil
+}
+
+// serverQuery runs 'query' with psql on the configured (running) server,
+// connected to PG_DB_NAME, and returns the unaligned, tuples-only output.
+func (s *BackupService) serverQuery(ctx context.Context, binDir string, query string) (string, error) {
+	cmd := exec.CommandContext(ctx, s.pgBinary(binDir, "psql"),
+		"-X", "-A", "-t",
+		"-v", "ON_ERROR_STOP=1",
+		"-h", s.config.PGHost,
+		"-p", fmt.Sprintf("%d", s.config.PGPort),
+		"-U", s.config.PGUser,
+		"-d", s.config.PGDatabase,
+		"-c", query)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.PGPassword))
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,138 @@
+package pgbackup
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeServerTools writes pg_ctl, psql, pg_dump and pg_restore scripts to
+// a directory. psql logs its queries to 'queries' and reports database
+// "taken" as existing; pg_ctl start keeps the recovery settings in
+// 'auto.conf'; pg_restore fails for database "broken".
+func fakeServerTools(t *testing.T) string {
+	t.Helper()
+	binDir := t.TempDir()
+	scripts := map[string]string{
+		"pg_ctl": `#!/bin/sh
+if [ "$1" = "start" ]; then
+  cp "$3/postgresql.auto.conf" "$(dirname "$0")/auto.conf"
+fi
+`,
+		"psql": `#!/bin/sh
+query="$(eval echo \${$#})"
+echo "$query" >> "$(dirname "$0")/queries"
+case "$query" in
+  *pg_is_in_recovery*) echo f ;;
+  *"datname = 'taken'"*) echo 1 ;;
+esac
+`,
+		"pg_dump": `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -f) out="$2"; shift ;;
+  esac
+  shift
+done
+echo dump > "$out"
+`,
+		"pg_restore": `#!/bin/sh
+echo "$@" > "$(dirname "$0")/restore_args"
+case "$*" in
+  *"-d broken"*) echo "pg_restore: error: could not execute query" >&2; exit 1 ;;
+esac
+`,
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(binDir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return binDir
+}
+
+func TestRestoreToDB(t *testing.T) {
+	ctx := context.Background()
+	binDir := fakeServerTools(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	store := NewLocalBackupStore(filepath.Join(t.TempDir(), "base"))
+	if err := store.Write(ctx, "20260102_020000", "base.tar.gz",
+		bytes.NewReader(tarGz(t, map[string]string{"PG_VERSION": "16\n"}))); err != nil {
+		t.Fatal(err)
+	}
+	config := &BackupConfig{PGHost: "127.0.0.1", PGPort: 5432, PGUser: "tester", PGDatabase: "app"}
+	service := NewBackupServiceWithStore(config, nil, store)
+	workDir := t.TempDir()
+	restore := func(dbName string) (*DBRestoreResult, error) {
+		return service.RestoreToDB(ctx, testLogger, DBRestoreOptions{
+			BackupID: "20260102_020000",
+			DBName:   dbName,
+			WorkDir:  workDir,
+			Port:     port,
+			BinDir:   binDir,
+		})
+	}
+	queries := func() string {
+		data, _ := os.ReadFile(filepath.Join(binDir, "queries"))
+		os.Remove(filepath.Join(binDir, "queries"))
+		return string(data)
+	}
+
+	for _, name := range []string{"", `app"; DROP DATABASE app; --`, "app-copy"} {
+		if _, err := restore(name); err == nil || !strings.Contains(err.Error(), LOC_RESTOREDB_NAME) {
+			t.Errorf("db name %q: error = %v", name, err)
+		}
+	}
+	if q := queries(); q != "" {
+		t.Errorf("invalid names ran queries: %s", q)
+	}
+
+	// An existing database is never touched
+	if _, err := restore("taken"); err == nil || !strings.Contains(err.Error(), "database taken already exists") {
+		t.Errorf("existing database: error = %v", err)
+	}
+	if q := queries(); strings.Contains(q, "CREATE") || strings.Contains(q, "DROP") {
+		t.Errorf("existing database: queries = %s", q)
+	}
+
+	result, err := restore("app_restored")
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if !result.Success || result.SourceDB != "app" || result.PGVersion != "16" {
+		t.Errorf("result = %+v", result)
+	}
+	if q := queries(); !strings.Contains(q, `CREATE DATABASE "app_restored"`) || strings.Contains(q, "DROP") {
+		t.Errorf("queries = %s", q)
+	}
+	args, _ := os.ReadFile(filepath.Join(binDir, "restore_args"))
+	if !strings.Contains(string(args), "-h 127.0.0.1 -p 5432 -U tester -d app_restored --no-owner") {
+		t.Errorf("pg_restore args = %s", args)
+	}
+	// Without a target time recovery stops at the end of the backup
+	if conf, _ := os.ReadFile(filepath.Join(binDir, "auto.conf")); !strings.Contains(string(conf), "recovery_target = 'immediate'") {
+		t.Errorf("recovery config = %s", conf)
+	}
+
+	// A failed load drops the new database again
+	if _, err := restore("broken"); err == nil || !strings.Contains(err.Error(), LOC_RESTOREDB_LOAD) {
+		t.Errorf("failed load: error = %v", err)
+	}
+	if q := queries(); !strings.Contains(q, `DROP DATABASE IF EXISTS "broken"`) {
+		t.Errorf("failed load: queries = %s", q)
+	}
+
+	if entries, _ := os.ReadDir(workDir); len(entries) != 0 {
+		t.Errorf("work directories left behind: %v", entries)
+	}
+}
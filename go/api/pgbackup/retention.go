@@ -5,9 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"sort"
-	"strings"
 	"time"
 )
 
@@ -23,20 +21,38 @@ const (
 type RetentionResult struct {
 	DeletedBackups  []string `json:"deleted_backups"`
 	DeletedWALFiles int      `json:"deleted_wal_files"`
+	WALFiles        []string `json:"wal_files,omitempty"` // Names of the deleted WAL files
 	RetainedBackups []string `json:"retained_backups"`
+	PinnedBackups   []string `json:"pinned_backups"`
 	FreedSpaceBytes int64    `json:"freed_space_bytes"`
+	DryRun          bool     `json:"dry_run,omitempty"` // Nothing was deleted; the lists are previews
+}
+
+// RetentionOptions configures a cleanup
+type RetentionOptions struct {
+	DryRun bool // Only report what would be deleted
 }
 
 // ApplyRetention removes old backups according to retention policy
 func (s *BackupService) ApplyRetention(ctx context.Context, logger *slog.Logger) (*RetentionResult, error) {
+	return s.ApplyRetentionWithOptions(ctx, logger, RetentionOptions{})
+}
+
+// ApplyRetentionWithOptions is ApplyRetention with options, e.g. a dry
+// run that previews the backups and WAL files a cleanup would delete
+func (s *BackupService) ApplyRetentionWithOptions(ctx context.Context, logger *slog.Logger, opts RetentionOptions) (*RetentionResult, error) {
 	logger.Info("Applying retention policy",
 		"retain_days", s.config.RetainDays,
 		"retain_count", s.config.RetainCount,
-		"retain_wal_days", s.config.RetainWALDays)
+		"retain_wal_days", s.config.RetainWALDays,
+		"retain_labeled", s.config.RetainLabeled,
+		"dry_run", opts.DryRun)
 
 	result := &RetentionResult{
+		DryRun:          opts.DryRun,
 		DeletedBackups:  []string{},
 		RetainedBackups: []string{},
+		PinnedBackups:   []string{},
 	}
 
 	// List all base backups
@@ -58,9 +74,22 @@ func (s *BackupService) ApplyRetention(ctx context.Context, logger *slog.Logger)
 	cutoffDate := time.Now().AddDate(0, 0, -s.config.RetainDays)
 
 	// Process each backup
-	for i, backup := range backups {
+	kept := 0
+	for _, backup := range backups {
+		// Labeled backups are pinned if configured. They do not count
+		// towards the minimum count, and do not hold back WAL cleanup:
+		// each backup carries the WAL it needs to be restored.
+		if s.config.RetainLabeled && len(backup.Labels) > 0 {
+			result.PinnedBackups = append(result.PinnedBackups, backup.BackupID)
+			logger.Info("Retaining backup (labeled)",
+				"backup_id", backup.BackupID,
+				"labels", backup.Labels)
+			continue
+		}
+
 		// Always keep minimum count (newest backups)
-		if i < s.config.RetainCount {
+		if kept < s.config.RetainCount {
+			kept++
 			result.RetainedBackups = append(result.RetainedBackups, backup.BackupID)
 			logger.Info("Retaining backup (within minimum count)",
 				"backup_id", backup.BackupID,
@@ -75,9 +104,11 @@ func (s *BackupService) ApplyRetention(ctx context.Context, logger *slog.Logger)
 				"age_days", int(time.Since(backup.StartTime).Hours()/24))
 
 			// Calculate size before deletion
-			size, _ := s.calculateDirSize(backup.BackupPath)
+			size := s.backupSize(ctx, backup.BackupID)
 
-			if err := s.deleteBackup(backup.BackupPath); err != nil {
+			if opts.DryRun {
+				// Previewed below with the others
+			} else if err := s.deleteBackup(ctx, backup.BackupID); err != nil {
 				logger.Warn("Failed to delete backup",
 					"backup_id", backup.BackupID,
 					"error", err)
@@ -94,108 +125,46 @@ func (s *BackupService) ApplyRetention(ctx context.Context, logger *slog.Logger)
 		}
 	}
 
-	// Clean old WAL files
-	walDeleted, walFreed, err := s.cleanOldWALFiles(ctx, logger, result.RetainedBackups)
+	// Clean WAL files no retained backup needs
+	walDeleted, walFreed, err := s.cleanOldWALFiles(ctx, logger, result.RetainedBackups, opts.DryRun)
 	if err != nil {
-		logger.Warn("Failed to clean WAL files", "error", err)
+		logger.Warn("Failed to clean WAL files, keeping all of them", "error", err)
 	} else {
-		result.DeletedWALFiles = walDeleted
+		result.WALFiles = walDeleted
+		result.DeletedWALFiles = len(walDeleted)
 		result.FreedSpaceBytes += walFreed
 	}
 
 	logger.Info("Retention policy applied",
 		"deleted_backups", len(result.DeletedBackups),
 		"retained_backups", len(result.RetainedBackups),
+		"pinned_backups", len(result.PinnedBackups),
 		"deleted_wal_files", result.DeletedWALFiles,
 		"freed_space_mb", float64(result.FreedSpaceBytes)/(1024*1024))
 
 	return result, nil
 }
 
-// deleteBackup removes a backup directory
-func (s *BackupService) deleteBackup(backupPath string) error {
-	// Verify the path is within our backup directory (safety check)
-	absBackupDir, _ := filepath.Abs(s.config.BaseBackupDir)
-	absPath, _ := filepath.Abs(backupPath)
-
-	if !strings.HasPrefix(absPath, absBackupDir) {
-		return fmt.Errorf("refusing to delete path outside backup directory: %s (%s)",
-			backupPath, LOC_RETENTION_DEL)
-	}
-
-	if err := os.RemoveAll(backupPath); err != nil {
-		return fmt.Errorf("failed to remove backup: %w (%s)", err, LOC_RETENTION_DEL)
-	}
-
-	return nil
-}
-
-// cleanOldWALFiles removes WAL files no longer needed for recovery
-func (s *BackupService) cleanOldWALFiles(_ context.Context, logger *slog.Logger, retainedBackups []string) (int, int64, error) {
-	if _, err := os.Stat(s.config.WALArchiveDir); os.IsNotExist(err) {
-		return 0, 0, nil
-	}
-
-	entries, err := os.ReadDir(s.config.WALArchiveDir)
+// backupSize returns the total size of the files of a backup
+func (s *BackupService) backupSize(ctx context.Context, backupID string) int64 {
+	objects, err := s.store.List(ctx, backupID)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to read WAL archive: %w (%s)", err, LOC_RETENTION_WAL)
+		return 0
 	}
-
-	// Find the oldest retained backup to determine WAL cutoff
-	var oldestRetainedTime time.Time
-	for _, backupID := range retainedBackups {
-		backup, err := s.GetBackup(backupID)
-		if err != nil {
-			continue
-		}
-		if oldestRetainedTime.IsZero() || backup.StartTime.Before(oldestRetainedTime) {
-			oldestRetainedTime = backup.StartTime
-		}
+	var size int64
+	for _, obj := range objects {
+		size += obj.Size
 	}
+	return size
+}
 
-	// If no retained backups, use WAL retention days
-	walCutoff := time.Now().AddDate(0, 0, -s.config.RetainWALDays)
-	if !oldestRetainedTime.IsZero() && oldestRetainedTime.Before(walCutoff) {
-		walCutoff = oldestRetainedTime
-	}
-
-	var deleted int
-	var freedBytes int64
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
-
-		// Delete WAL files older than cutoff
-		if info.ModTime().Before(walCutoff) {
-			walPath := filepath.Join(s.config.WALArchiveDir, entry.Name())
-
-			// Safety check
-			if !strings.HasPrefix(walPath, s.config.WALArchiveDir) {
-				continue
-			}
-
-			size := info.Size()
-			if err := os.Remove(walPath); err != nil {
-				logger.Warn("Failed to delete WAL file", "file", entry.Name(), "error", err)
-				continue
-			}
-
-			deleted++
-			freedBytes += size
-			logger.Info("Deleted old WAL file",
-				"file", entry.Name(),
-				"age_days", int(time.Since(info.ModTime()).Hours()/24))
-		}
+// deleteBackup removes a backup from the store
+func (s *BackupService) deleteBackup(ctx context.Context, backupID string) error {
+	if err := s.store.Delete(ctx, backupID); err != nil {
+		return fmt.Errorf("failed to remove backup: %w (%s)", err, LOC_RETENTION_DEL)
 	}
 
-	return deleted, freedBytes, nil
+	return nil
 }
 
 // GetOldestWALFile returns information about the oldest WAL file in the archive
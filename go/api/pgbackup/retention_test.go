@@ -0,0 +1,158 @@
+package pgbackup
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBackupLabel = `START WAL LOCATION: 0/10000028 (file 000000010000000000000010)
+CHECKPOINT LOCATION: 0/10000060
+BACKUP METHOD: streamed
+BACKUP FROM: primary
+START TIME: 2026-01-10 02:00:00 UTC
+LABEL: pg_basebackup base backup
+START TIMELINE: 1
+`
+
+func TestReadStartWAL(t *testing.T) {
+	archive := tarGz(t, map[string]string{"PG_VERSION": "16\n", "backup_label": testBackupLabel})
+	seg, err := readStartWAL(bytes.NewReader(archive), true)
+	if err != nil || seg != "000000010000000000000010" {
+		t.Errorf("start WAL = %q, %v", seg, err)
+	}
+
+	archive = tarGz(t, map[string]string{"PG_VERSION": "16\n"})
+	if seg, err := readStartWAL(bytes.NewReader(archive), true); err == nil {
+		t.Errorf("archive without backup_label: start WAL = %q", seg)
+	}
+	if seg, err := parseBackupLabel(strings.NewReader("LABEL: x\n")); err == nil {
+		t.Errorf("label without START WAL LOCATION: start WAL = %q", seg)
+	}
+}
+
+// TestApplyRetentionKeepsRequiredWAL checks that WAL cleanup never
+// deletes a segment at or after the start WAL of the oldest retained
+// backup, whatever its timeline or age, and that a dry run deletes
+// nothing.
+func TestApplyRetentionKeepsRequiredWAL(t *testing.T) {
+	ctx := context.Background()
+	walDir := t.TempDir()
+	store := NewLocalBackupStore(t.TempDir())
+	config := &BackupConfig{WALArchiveDir: walDir, RetainDays: 7, RetainCount: 1, RetainWALDays: 14}
+	service := NewBackupServiceWithStore(config, nil, store)
+
+	now := time.Now()
+	backups := []struct {
+		result *BackupResult
+		label  string
+	}{
+		// Expired
+		{&BackupResult{BackupID: "20260101_020000", StartTime: now.AddDate(0, 0, -20), WALStart: "000000010000000000000004"}, ""},
+		// Taken before WALStart was recorded: read from backup_label
+		{&BackupResult{BackupID: "20260110_020000", StartTime: now.AddDate(0, 0, -3)}, testBackupLabel},
+		{&BackupResult{BackupID: "20260112_020000", StartTime: now.AddDate(0, 0, -1), WALStart: "000000020000000000000014"}, ""},
+	}
+	for _, b := range backups {
+		files := map[string]string{"PG_VERSION": "16\n"}
+		if b.label != "" {
+			files["backup_label"] = b.label
+		}
+		b.result.Success = true
+		if err := store.Write(ctx, b.result.BackupID, "base.tar.gz", bytes.NewReader(tarGz(t, files))); err != nil {
+			t.Fatal(err)
+		}
+		if err := service.writeBackupManifest(ctx, b.result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := now.AddDate(0, 0, -30)
+	walFiles := map[string]time.Time{
+		"000000010000000000000004.gz":                 old,
+		"00000001000000000000000F.gz":                 old,
+		"00000001000000000000000F.partial.gz":         old,
+		"00000001000000000000000F.00000028.backup.gz": old,
+		"00000001000000000000000E":                    now, // Newer than RetainWALDays
+		"00000002.history":                            old,
+		"000000010000000000000010.gz":                 old,
+		"000000010000000000000010.00000028.backup.gz": old,
+		"000000010000000000000011.gz":                 old,
+		"000000020000000000000012.gz":                 old,
+		"000000020000000000000014.gz":                 now,
+		"README":                                      old,
+	}
+	for name, mtime := range walFiles {
+		path := filepath.Join(walDir, name)
+		if err := os.WriteFile(path, []byte("wal"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orphaned := []string{
+		"000000010000000000000004.gz",
+		"00000001000000000000000F.00000028.backup.gz",
+		"00000001000000000000000F.gz",
+		"00000001000000000000000F.partial.gz",
+	}
+
+	// Dry run: previewed, nothing deleted
+	result, err := service.ApplyRetentionWithOptions(ctx, testLogger, RetentionOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.DryRun || !reflect.DeepEqual(result.WALFiles, orphaned) ||
+		!reflect.DeepEqual(result.DeletedBackups, []string{"20260101_020000"}) {
+		t.Errorf("dry run = %+v", result)
+	}
+	for name := range walFiles {
+		if _, err := os.Stat(filepath.Join(walDir, name)); err != nil {
+			t.Errorf("dry run deleted %s", name)
+		}
+	}
+	if _, err := service.GetBackup("20260101_020000"); err != nil {
+		t.Errorf("dry run deleted backup: %v", err)
+	}
+
+	result, err = service.ApplyRetention(ctx, testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.WALFiles, orphaned) || result.DeletedWALFiles != len(orphaned) {
+		t.Errorf("deleted WAL files = %v", result.WALFiles)
+	}
+	for name := range walFiles {
+		_, err := os.Stat(filepath.Join(walDir, name))
+		deleted := os.IsNotExist(err)
+		wantDeleted := false
+		for _, o := range orphaned {
+			wantDeleted = wantDeleted || o == name
+		}
+		if deleted != wantDeleted {
+			t.Errorf("%s: deleted = %v, want %v", name, deleted, wantDeleted)
+		}
+	}
+
+	// Once the start WAL of a retained backup is unknown, nothing is deleted
+	if err := store.Write(ctx, "20260113_020000", "base.tar.gz",
+		bytes.NewReader(tarGz(t, map[string]string{"PG_VERSION": "16\n"}))); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.writeBackupManifest(ctx, &BackupResult{BackupID: "20260113_020000", StartTime: now, Success: true}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = service.ApplyRetention(ctx, testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DeletedWALFiles != 0 {
+		t.Errorf("with unknown start WAL, deleted %v", result.WALFiles)
+	}
+}
@@ -0,0 +1,170 @@
+package pgbackup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Location codes for schedule operations
+const (
+	LOC_SCHEDULE_PARSE = "SHD_PGB_090"
+)
+
+// Schedule is a parsed cron expression with the standard five fields:
+// minute, hour, day of month, month and day of week (0 or 7 = Sunday).
+// Fields accept '*', numbers, ranges (1-5), lists (1,15) and steps (*/15,
+// 8-18/2). The shortcuts @hourly, @daily, @weekly and @monthly are
+// accepted too.
+type Schedule struct {
+	expr    string
+	minutes [60]bool
+	hours   [24]bool
+	dom     [32]bool
+	months  [13]bool
+	dow     [7]bool
+
+	// Like cron, when both day fields are restricted a day matches if
+	// either matches
+	domStar bool
+	dowStar bool
+}
+
+var scheduleShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseSchedule parses a cron expression.
+func ParseSchedule(expr string) (*Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if shortcut, ok := scheduleShortcuts[spec]; ok {
+		spec = shortcut
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: want 5 fields (minute hour day month weekday) (%s)",
+			expr, LOC_SCHEDULE_PARSE)
+	}
+
+	s := &Schedule{
+		expr:    expr,
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	var dow [8]bool
+	for _, f := range []struct {
+		name     string
+		value    string
+		min, max int
+		set      []bool
+	}{
+		{"minute", fields[0], 0, 59, s.minutes[:]},
+		{"hour", fields[1], 0, 23, s.hours[:]},
+		{"day of month", fields[2], 1, 31, s.dom[:]},
+		{"month", fields[3], 1, 12, s.months[:]},
+		{"day of week", fields[4], 0, 7, dow[:]},
+	} {
+		if err := parseScheduleField(f.value, f.min, f.max, f.set); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s: %w (%s)", expr, f.name, err, LOC_SCHEDULE_PARSE)
+		}
+	}
+	copy(s.dow[:], dow[:7])
+	s.dow[0] = s.dow[0] || dow[7]
+	return s, nil
+}
+
+// parseScheduleField marks the values of one comma-separated field in 'set'
+func parseScheduleField(field string, min, max int, set []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil || lo > hi {
+				return fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return fmt.Errorf("invalid value %q", rangePart)
+			}
+			lo = n
+			// "5/10" means from 5 to the end in steps of 10
+			if step == 1 {
+				hi = n
+			}
+		}
+		if lo < min || hi > max {
+			return fmt.Errorf("%q out of range %d-%d", rangePart, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+// String returns the expression the schedule was parsed from
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// Next returns the first time after 't' (at minute precision, in the
+// location of 't') that matches the schedule. It returns the zero time if
+// nothing matches within five years (e.g. "0 0 31 2 *").
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.months[t.Month()] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom[t.Day()]
+	dow := s.dow[t.Weekday()]
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,66 @@
+package pgbackup
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// A Monday
+	from := time.Date(2026, 2, 2, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"0 2 * * *", "2026-02-03 02:00"},
+		{"*/15 * * * *", "2026-02-02 10:30"},
+		{"@hourly", "2026-02-02 11:00"},
+		{"0 3 * * 0", "2026-02-08 03:00"},
+		{"0 3 * * 7", "2026-02-08 03:00"},
+		{"30 8-18/4 * * 1-5", "2026-02-02 12:30"},
+		{"0 0 1,15 * *", "2026-02-15 00:00"},
+		{"0 0 29 2 *", "2028-02-29 00:00"},
+		// Both day fields restricted: either matches (the 10th or a Friday)
+		{"0 12 10 * 5", "2026-02-06 12:00"},
+		{"5/20 10 * * *", "2026-02-02 10:25"},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from).Format("2006-01-02 15:04"); got != tt.want {
+			t.Errorf("%s: next = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+
+	s, _ := ParseSchedule("0 0 31 2 *")
+	if next := s.Next(from); !next.IsZero() {
+		t.Errorf("impossible schedule: next = %v, want zero", next)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, tt := range []struct {
+		expr    string
+		wantErr string
+	}{
+		{"0 2 * *", "want 5 fields"},
+		{"60 * * * *", "out of range"},
+		{"0 24 * * *", "out of range"},
+		{"0 0 0 * *", "out of range"},
+		{"0 0 * 13 *", "out of range"},
+		{"0 0 * * 8", "out of range"},
+		{"*/0 * * * *", "invalid step"},
+		{"5-1 * * * *", "invalid range"},
+		{"a * * * *", "invalid value"},
+		{"@yearly", "want 5 fields"},
+	} {
+		_, err := ParseSchedule(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%q: error = %v, want it to contain %q", tt.expr, err, tt.wantErr)
+		}
+	}
+}
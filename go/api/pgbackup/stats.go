@@ -0,0 +1,300 @@
+package pgbackup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Location codes for backup history and alerts
+const (
+	LOC_HISTORY_READ  = "SHD_PGB_140"
+	LOC_HISTORY_WRITE = "SHD_PGB_141"
+	LOC_ALERT_SEND    = "SHD_PGB_142"
+)
+
+const (
+	// historyMaxRuns is the number of runs kept in the history file
+	historyMaxRuns = 200
+
+	// durationWindow is the number of previous runs a backup's duration
+	// is compared with, and durationMinRuns the fewest that make a
+	// baseline
+	durationWindow  = 10
+	durationMinRuns = 3
+
+	// alertTimeout bounds a webhook call, which must not hold up backups
+	alertTimeout = 10 * time.Second
+)
+
+// AlertEventDurationRegression is the event of the alert sent when a
+// backup takes much longer than the previous ones
+const AlertEventDurationRegression = "backup_duration_regression"
+
+// BackupRun is the history entry of a successful base backup. The history
+// outlives the backups, which retention deletes.
+type BackupRun struct {
+	BackupID        string    `json:"backup_id"`
+	StartTime       time.Time `json:"start_time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	SizeBytes       int64     `json:"size_bytes"`
+}
+
+// Duration returns the duration of the run
+func (r BackupRun) Duration() time.Duration {
+	return time.Duration(r.DurationSeconds * float64(time.Second))
+}
+
+// DurationAlert is the payload posted to PG_BACKUP_ALERT_WEBHOOK_URL
+type DurationAlert struct {
+	Event                 string    `json:"event"`
+	Host                  string    `json:"host"`
+	BackupID              string    `json:"backup_id"`
+	Time                  time.Time `json:"time"`
+	DurationSeconds       float64   `json:"duration_seconds"`
+	MedianDurationSeconds float64   `json:"median_duration_seconds"`
+	Factor                float64   `json:"factor"`
+	Runs                  int       `json:"runs"`
+}
+
+// ReadBackupHistory returns the runs in the history file, oldest first.
+// A missing file is an empty history; unreadable lines are skipped.
+func (s *BackupService) ReadBackupHistory() ([]BackupRun, error) {
+	runs := []BackupRun{}
+	f, err := os.Open(s.config.HistoryFilePath)
+	if os.IsNotExist(err) {
+		return runs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup history: %w (%s)", err, LOC_HISTORY_READ)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var run BackupRun
+		if err := json.Unmarshal(scanner.Bytes(), &run); err == nil && run.BackupID != "" {
+			runs = append(runs, run)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read backup history: %w (%s)", err, LOC_HISTORY_READ)
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].StartTime.Before(runs[j].StartTime) })
+	return runs, nil
+}
+
+// writeBackupHistory replaces the history file with the last
+// historyMaxRuns of 'runs'
+func (s *BackupService) writeBackupHistory(runs []BackupRun) error {
+	if len(runs) > historyMaxRuns {
+		runs = runs[len(runs)-historyMaxRuns:]
+	}
+	var buf bytes.Buffer
+	for _, run := range runs {
+		line, err := json.Marshal(run)
+		if err != nil {
+			return fmt.Errorf("failed to marshal backup history: %w (%s)", err, LOC_HISTORY_WRITE)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.config.HistoryFilePath), 0700); err != nil {
+		return fmt.Errorf("failed to create history dir: %w (%s)", err, LOC_HISTORY_WRITE)
+	}
+	tmp := s.config.HistoryFilePath + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write backup history: %w (%s)", err, LOC_HISTORY_WRITE)
+	}
+	if err := os.Rename(tmp, s.config.HistoryFilePath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write backup history: %w (%s)", err, LOC_HISTORY_WRITE)
+	}
+	return nil
+}
+
+// medianDuration returns the median duration of the last durationWindow
+// runs, or false if there are fewer than durationMinRuns
+func medianDuration(runs []BackupRun) (time.Duration, bool) {
+	if len(runs) > durationWindow {
+		runs = runs[len(runs)-durationWindow:]
+	}
+	if len(runs) < durationMinRuns {
+		return 0, false
+	}
+	seconds := make([]float64, len(runs))
+	for i, run := range runs {
+		seconds[i] = run.DurationSeconds
+	}
+	return time.Duration(median(seconds) * float64(time.Second)), true
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
+
+// checkDuration returns the alert for 'run' if it took more than 'factor'
+// times the median of the 'previous' runs, nil otherwise
+func checkDuration(previous []BackupRun, run BackupRun, factor float64) *DurationAlert {
+	if factor <= 0 {
+		return nil
+	}
+	med, ok := medianDuration(previous)
+	if !ok || med <= 0 || run.Duration() <= time.Duration(float64(med)*factor) {
+		return nil
+	}
+	host, _ := os.Hostname()
+	return &DurationAlert{
+		Event:                 AlertEventDurationRegression,
+		Host:                  host,
+		BackupID:              run.BackupID,
+		Time:                  time.Now().UTC(),
+		DurationSeconds:       run.DurationSeconds,
+		MedianDurationSeconds: med.Seconds(),
+		Factor:                run.DurationSeconds / med.Seconds(),
+		Runs:                  min(len(previous), durationWindow),
+	}
+}
+
+// recordBackupRun adds a successful backup to the history and warns, and
+// sends an alert if a webhook is configured, when it took much longer
+// than the previous ones. Failures are logged: they don't fail the backup.
+func (s *BackupService) recordBackupRun(ctx context.Context, logger *slog.Logger, result *BackupResult) {
+	if s.config.HistoryFilePath == "" {
+		return
+	}
+	run := BackupRun{
+		BackupID:        result.BackupID,
+		StartTime:       result.StartTime,
+		DurationSeconds: result.EndTime.Sub(result.StartTime).Seconds(),
+		SizeBytes:       result.SizeBytes,
+	}
+	runs, err := s.ReadBackupHistory()
+	if err != nil {
+		logger.Warn("Failed to read backup history", "error", err)
+		return
+	}
+
+	if alert := checkDuration(runs, run, s.config.DurationAlertFactor); alert != nil {
+		logger.Warn("Base backup took much longer than usual",
+			"backup_id", run.BackupID,
+			"duration", run.Duration().Round(time.Second),
+			"median_duration", time.Duration(alert.MedianDurationSeconds*float64(time.Second)).Round(time.Second),
+			"factor", fmt.Sprintf("%.1fx", alert.Factor),
+			"runs", alert.Runs)
+		if s.config.AlertWebhookURL != "" {
+			if err := s.sendAlert(ctx, alert); err != nil {
+				logger.Warn("Failed to send backup duration alert", "error", err)
+			}
+		}
+	}
+
+	if err := s.writeBackupHistory(append(runs, run)); err != nil {
+		logger.Warn("Failed to write backup history", "error", err)
+	}
+}
+
+// sendAlert posts 'alert' as JSON to the configured webhook
+func (s *BackupService) sendAlert(ctx context.Context, alert interface{}) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w (%s)", err, LOC_ALERT_SEND)
+	}
+	ctx, cancel := context.WithTimeout(ctx, alertTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.AlertWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid PG_BACKUP_ALERT_WEBHOOK_URL: %w (%s)", err, LOC_ALERT_SEND)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %w (%s)", err, LOC_ALERT_SEND)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned %s (%s)", resp.Status, LOC_ALERT_SEND)
+	}
+	return nil
+}
+
+// BackupStats summarizes the backup history for 'pgbackup stats'
+type BackupStats struct {
+	// Runs are the last runs, oldest first, each with its duration
+	// compared with the median of the runs before it (0: no baseline)
+	Runs           []BackupRun
+	DurationFactor []float64
+
+	TotalRuns      int
+	MedianDuration time.Duration // Of the last durationWindow runs
+	MedianSize     int64         // Of the last durationWindow runs
+
+	// DurationTrend and SizeTrend compare the median of the last
+	// durationWindow runs with the median of the durationWindow runs
+	// before them; 0 without enough history
+	DurationTrend float64
+	SizeTrend     float64
+}
+
+// ComputeBackupStats summarizes 'runs' (oldest first), listing the last
+// 'last' of them
+func ComputeBackupStats(runs []BackupRun, last int) *BackupStats {
+	stats := &BackupStats{TotalRuns: len(runs)}
+	if len(runs) == 0 {
+		return stats
+	}
+
+	start := 0
+	if last > 0 && len(runs) > last {
+		start = len(runs) - last
+	}
+	for i := start; i < len(runs); i++ {
+		factor := 0.0
+		if med, ok := medianDuration(runs[:i]); ok && med > 0 {
+			factor = runs[i].DurationSeconds / med.Seconds()
+		}
+		stats.Runs = append(stats.Runs, runs[i])
+		stats.DurationFactor = append(stats.DurationFactor, factor)
+	}
+
+	recent := runs[max(0, len(runs)-durationWindow):]
+	durations, sizes := runValues(recent)
+	stats.MedianDuration = time.Duration(median(durations) * float64(time.Second))
+	stats.MedianSize = int64(median(sizes))
+
+	if len(runs) >= durationWindow+durationMinRuns {
+		older := runs[max(0, len(runs)-2*durationWindow) : len(runs)-durationWindow]
+		oldDurations, oldSizes := runValues(older)
+		if m := median(oldDurations); m > 0 {
+			stats.DurationTrend = median(durations) / m
+		}
+		if m := median(oldSizes); m > 0 {
+			stats.SizeTrend = median(sizes) / m
+		}
+	}
+	return stats
+}
+
+func runValues(runs []BackupRun) (durations []float64, sizes []float64) {
+	for _, run := range runs {
+		durations = append(durations, run.DurationSeconds)
+		sizes = append(sizes, float64(run.SizeBytes))
+	}
+	return durations, sizes
+}
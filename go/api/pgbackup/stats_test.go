@@ -0,0 +1,170 @@
+package pgbackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testRuns returns runs a day apart with the given durations in minutes
+func testRuns(minutes ...float64) []BackupRun {
+	start := time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC)
+	runs := make([]BackupRun, len(minutes))
+	for i, m := range minutes {
+		runs[i] = BackupRun{
+			BackupID:        start.AddDate(0, 0, i).Format("20060102_150405"),
+			StartTime:       start.AddDate(0, 0, i),
+			DurationSeconds: m * 60,
+			SizeBytes:       int64(100+i) << 20,
+		}
+	}
+	return runs
+}
+
+func TestCheckDuration(t *testing.T) {
+	tests := []struct {
+		name      string
+		previous  []BackupRun
+		minutes   float64
+		factor    float64
+		wantAlert bool
+	}{
+		{name: "no history", minutes: 60, factor: 2},
+		{name: "too little history", previous: testRuns(10, 10), minutes: 60, factor: 2},
+		{name: "usual", previous: testRuns(10, 12, 11), minutes: 20, factor: 2},
+		{name: "slow", previous: testRuns(10, 12, 11), minutes: 25, factor: 2, wantAlert: true},
+		{name: "turned off", previous: testRuns(10, 12, 11), minutes: 60, factor: 0},
+		// Only the last durationWindow runs count
+		{name: "old slow runs", previous: testRuns(100, 100, 100, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10),
+			minutes: 30, factor: 2, wantAlert: true},
+	}
+	for _, tt := range tests {
+		run := BackupRun{BackupID: "run", DurationSeconds: tt.minutes * 60}
+		alert := checkDuration(tt.previous, run, tt.factor)
+		if (alert != nil) != tt.wantAlert {
+			t.Errorf("%s: alert = %+v, want alert %v", tt.name, alert, tt.wantAlert)
+		}
+	}
+
+	alert := checkDuration(testRuns(10, 12, 11), BackupRun{BackupID: "run", DurationSeconds: 33 * 60}, 2)
+	if alert.MedianDurationSeconds != 11*60 || alert.Factor != 3 || alert.Runs != 3 ||
+		alert.Event != AlertEventDurationRegression {
+		t.Errorf("alert = %+v", alert)
+	}
+}
+
+func TestRecordBackupRun(t *testing.T) {
+	alerts := make(chan DurationAlert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert DurationAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		alerts <- alert
+	}))
+	defer srv.Close()
+
+	s := NewBackupService(&BackupConfig{
+		HistoryFilePath:     filepath.Join(t.TempDir(), "backup_history.jsonl"),
+		DurationAlertFactor: 2,
+		AlertWebhookURL:     srv.URL,
+	})
+	record := func(id string, minutes float64) {
+		start := time.Now()
+		s.recordBackupRun(context.Background(), testLogger, &BackupResult{
+			BackupID:  id,
+			StartTime: start,
+			EndTime:   start.Add(time.Duration(minutes * float64(time.Minute))),
+			SizeBytes: 1 << 30,
+			Success:   true,
+		})
+	}
+
+	for i, minutes := range []float64{10, 12, 11} {
+		record(fmt.Sprintf("run%d", i), minutes)
+	}
+	if len(alerts) != 0 {
+		t.Fatal("alert sent without a baseline")
+	}
+
+	record("slow", 40)
+	select {
+	case alert := <-alerts:
+		if alert.BackupID != "slow" || alert.MedianDurationSeconds != 11*60 {
+			t.Errorf("alert = %+v", alert)
+		}
+	default:
+		t.Fatal("no alert sent")
+	}
+
+	runs, err := s.ReadBackupHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 4 || runs[3].BackupID != "slow" || runs[3].Duration() != 40*time.Minute {
+		t.Errorf("history = %+v", runs)
+	}
+}
+
+func TestWriteBackupHistoryKeepsLastRuns(t *testing.T) {
+	s := NewBackupService(&BackupConfig{HistoryFilePath: filepath.Join(t.TempDir(), "backup_history.jsonl")})
+	minutes := make([]float64, historyMaxRuns+5)
+	for i := range minutes {
+		minutes[i] = float64(i + 1)
+	}
+	if err := s.writeBackupHistory(testRuns(minutes...)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A damaged line is skipped
+	f, err := os.OpenFile(s.config.HistoryFilePath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{not json\n")
+	f.Close()
+
+	runs, err := s.ReadBackupHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != historyMaxRuns || runs[0].DurationSeconds != 6*60 {
+		t.Errorf("kept %d runs starting with %v, want %d starting with the 6th", len(runs), runs[0], historyMaxRuns)
+	}
+}
+
+func TestComputeBackupStats(t *testing.T) {
+	if stats := ComputeBackupStats(nil, 20); stats.TotalRuns != 0 || len(stats.Runs) != 0 {
+		t.Errorf("empty stats = %+v", stats)
+	}
+
+	// Ten runs of 10 minutes, then ten of 15
+	minutes := []float64{}
+	for i := 0; i < 20; i++ {
+		minutes = append(minutes, 10+5*float64(i/10))
+	}
+	stats := ComputeBackupStats(testRuns(minutes...), 5)
+	if stats.TotalRuns != 20 || len(stats.Runs) != 5 || stats.Runs[4].BackupID != testRuns(minutes...)[19].BackupID {
+		t.Errorf("runs = %d listed of %d", len(stats.Runs), stats.TotalRuns)
+	}
+	if stats.MedianDuration != 15*time.Minute || stats.DurationTrend != 1.5 {
+		t.Errorf("median = %s, trend = %g, want 15m and 1.5", stats.MedianDuration, stats.DurationTrend)
+	}
+	if stats.SizeTrend <= 1 {
+		t.Errorf("size trend = %g, want growth", stats.SizeTrend)
+	}
+	if f := stats.DurationFactor[4]; f != 1 {
+		t.Errorf("factor of the last run = %g, want 1", f)
+	}
+
+	// Without enough history there is no trend
+	if stats := ComputeBackupStats(testRuns(10, 11, 12), 0); stats.DurationTrend != 0 || stats.DurationFactor[0] != 0 {
+		t.Errorf("short history stats = %+v", stats)
+	}
+}
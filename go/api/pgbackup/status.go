@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -18,6 +19,7 @@ import (
 const (
 	LOC_STATUS_START = "SHD_PGB_070"
 	LOC_STATUS_PG    = "SHD_PGB_071"
+	LOC_STATUS_DISK  = "SHD_PGB_095"
 )
 
 // BackupStatus contains comprehensive status information
@@ -69,8 +71,12 @@ type BackupStatus struct {
 	ArchiveCommand  string `json:"archive_command,omitempty"`
 
 	// Retention settings
-	RetainDays  int `json:"retain_days"`
-	RetainCount int `json:"retain_count"`
+	RetainDays    int  `json:"retain_days"`
+	RetainCount   int  `json:"retain_count"`
+	RetainLabeled bool `json:"retain_labeled"`
+
+	// Backups per label
+	LabelCounts map[string]int `json:"label_counts,omitempty"`
 
 	// All backups
 	Backups []*BackupResult `json:"backups"`
@@ -190,6 +196,7 @@ func (s *BackupService) GetStatus(ctx context.Context, logger *slog.Logger) (*Ba
 		WALArchiveDir: s.config.WALArchiveDir,
 		RetainDays:    s.config.RetainDays,
 		RetainCount:   s.config.RetainCount,
+		RetainLabeled: s.config.RetainLabeled,
 		Backups:       []*BackupResult{},
 	}
 
@@ -209,6 +216,12 @@ func (s *BackupService) GetStatus(ctx context.Context, logger *slog.Logger) (*Ba
 		// Calculate totals and find latest/oldest
 		for _, b := range backups {
 			status.TotalSizeBytes += b.SizeBytes
+			for _, label := range b.Labels {
+				if status.LabelCounts == nil {
+					status.LabelCounts = make(map[string]int)
+				}
+				status.LabelCounts[label]++
+			}
 		}
 
 		if len(backups) > 0 {
@@ -345,6 +358,9 @@ func (s *BackupService) PrintStatus(ctx context.Context, logger *slog.Logger) er
 	fmt.Printf("  WAL Archive Directory:  %s\n", status.WALArchiveDir)
 	fmt.Printf("  Retention Policy:       %d days, minimum %d backups\n",
 		status.RetainDays, status.RetainCount)
+	if status.RetainLabeled {
+		fmt.Printf("  Labeled Backups:        kept (exempt from retention)\n")
+	}
 	if status.PGConfigured {
 		fmt.Printf("  wal_level:              %s\n", status.WALLevel)
 		fmt.Printf("  archive_mode:           %s\n", status.ArchiveMode)
@@ -371,6 +387,14 @@ func (s *BackupService) PrintStatus(ctx context.Context, logger *slog.Logger) er
 			status.OldestBackupID,
 			formatDuration(time.Since(status.OldestBackupTime)))
 	}
+	if len(status.LabelCounts) > 0 {
+		labels := make([]string, 0, len(status.LabelCounts))
+		for label, count := range status.LabelCounts {
+			labels = append(labels, fmt.Sprintf("%s (%d)", label, count))
+		}
+		sort.Strings(labels)
+		fmt.Printf("  Labels:                 %s\n", strings.Join(labels, ", "))
+	}
 	fmt.Println()
 
 	// WAL archive
@@ -405,11 +429,16 @@ func (s *BackupService) PrintStatus(ctx context.Context, logger *slog.Logger) er
 			if !b.Success {
 				successMark = "FAILED"
 			}
-			fmt.Printf("  %s  %s  %.2f MB  [%s]\n",
+			labels := ""
+			if len(b.Labels) > 0 {
+				labels = "  labels: " + strings.Join(b.Labels, ",")
+			}
+			fmt.Printf("  %s  %s  %.2f MB  [%s]%s\n",
 				b.BackupID,
 				b.StartTime.Format("2006-01-02 15:04:05"),
 				float64(b.SizeBytes)/(1024*1024),
-				successMark)
+				successMark,
+				labels)
 		}
 	}
 
@@ -441,7 +470,12 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%.1f days", d.Hours()/24)
 }
 
-// CheckDiskSpace checks if there's enough disk space for a new backup
+// CheckDiskSpace checks that the backup directory is writable and has
+// enough free space for a new backup. A base backup copies the whole
+// cluster, so the estimated backup size is the total size of its
+// databases, and the free space must be at least that times
+// PG_BACKUP_DISK_SPACE_FACTOR. Without a database connection (or if the
+// size query fails) it must be at least PG_BACKUP_MIN_FREE_MB.
 func (s *BackupService) CheckDiskSpace(ctx context.Context, logger *slog.Logger) error {
 	// Get disk space info for backup directory
 	var stat os.FileInfo
@@ -459,8 +493,7 @@ func (s *BackupService) CheckDiskSpace(ctx context.Context, logger *slog.Logger)
 		return fmt.Errorf("backup path is not a directory: %s", s.config.BackupBaseDir)
 	}
 
-	// Note: Getting actual free disk space requires platform-specific code
-	// For now, we just verify the directory exists and is writable
+	// Verify the directory is writable
 	testFile := fmt.Sprintf("%s/.pgbackup_test_%d", s.config.BackupBaseDir, time.Now().UnixNano())
 	f, err := os.Create(testFile)
 	if err != nil {
@@ -470,5 +503,54 @@ func (s *BackupService) CheckDiskSpace(ctx context.Context, logger *slog.Logger)
 	os.Remove(testFile)
 
 	logger.Info("Backup directory is writable", "path", s.config.BackupBaseDir)
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(s.config.BackupBaseDir, &fs); err != nil {
+		return fmt.Errorf("failed to get free space of %s: %w (%s)", s.config.BackupBaseDir, err, LOC_STATUS_DISK)
+	}
+	available := int64(fs.Bavail) * int64(fs.Bsize)
+
+	const mb = 1024 * 1024
+	required := int64(s.config.MinFreeSpaceMB) * mb
+	estimate, err := s.estimateBackupSize(ctx)
+	if err != nil {
+		logger.Warn("Could not estimate backup size, requiring the static minimum",
+			"error", err,
+			"min_free_mb", s.config.MinFreeSpaceMB)
+	} else {
+		required = int64(float64(estimate) * s.config.DiskSpaceFactor)
+	}
+
+	if available < required {
+		if err != nil {
+			return fmt.Errorf("not enough disk space in %s: %.2f MB available, %.2f MB required "+
+				"(PG_BACKUP_MIN_FREE_MB, backup size unknown), short by %.2f MB (%s)",
+				s.config.BackupBaseDir, float64(available)/mb, float64(required)/mb,
+				float64(required-available)/mb, LOC_STATUS_DISK)
+		}
+		return fmt.Errorf("not enough disk space in %s: %.2f MB available, %.2f MB required "+
+			"(estimated backup size %.2f MB x %g), short by %.2f MB (%s)",
+			s.config.BackupBaseDir, float64(available)/mb, float64(required)/mb,
+			float64(estimate)/mb, s.config.DiskSpaceFactor, float64(required-available)/mb, LOC_STATUS_DISK)
+	}
+
+	logger.Info("Enough disk space for backup",
+		"available_mb", available/mb,
+		"required_mb", required/mb,
+		"estimated_backup_mb", estimate/mb)
 	return nil
 }
+
+// estimateBackupSize returns the total size of the cluster's databases
+func (s *BackupService) estimateBackupSize(ctx context.Context) (int64, error) {
+	if s.db == nil {
+		return 0, fmt.Errorf("no database connection (%s)", LOC_STATUS_DISK)
+	}
+	var size int64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(pg_database_size(datname)), 0)::bigint FROM pg_database WHERE datallowconn").Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query database size: %w (%s)", err, LOC_STATUS_DISK)
+	}
+	return size, nil
+}
@@ -0,0 +1,53 @@
+package pgbackup
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCheckDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	sizeQuery := "SELECT COALESCE(SUM(pg_database_size(datname)), 0)::bigint FROM pg_database WHERE datallowconn"
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	config := &BackupConfig{BackupBaseDir: dir, DiskSpaceFactor: 1.5, MinFreeSpaceMB: 1}
+	service := NewBackupServiceWithDB(config, db)
+
+	// A small database fits
+	mock.ExpectQuery(sizeQuery).WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(int64(1024 * 1024)))
+	if err := service.CheckDiskSpace(context.Background(), testLogger); err != nil {
+		t.Errorf("small database: %v", err)
+	}
+
+	// One larger than any disk does not
+	mock.ExpectQuery(sizeQuery).WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(int64(1) << 60))
+	err = service.CheckDiskSpace(context.Background(), testLogger)
+	if err == nil || !strings.Contains(err.Error(), "estimated backup size 1099511627776.00 MB x 1.5") ||
+		!strings.Contains(err.Error(), "short by") {
+		t.Errorf("huge database: error = %v", err)
+	}
+
+	// Without the size, the static minimum applies
+	mock.ExpectQuery(sizeQuery).WillReturnError(errors.New("connection refused"))
+	if err := service.CheckDiskSpace(context.Background(), testLogger); err != nil {
+		t.Errorf("size query failed, 1 MB minimum: %v", err)
+	}
+	config.MinFreeSpaceMB = 1 << 40
+	err = NewBackupService(config).CheckDiskSpace(context.Background(), testLogger)
+	if err == nil || !strings.Contains(err.Error(), "PG_BACKUP_MIN_FREE_MB, backup size unknown") {
+		t.Errorf("no database, huge minimum: error = %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,236 @@
+package pgbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Location codes for backup store operations
+const (
+	LOC_STORE_NAME   = "SHD_PGB_110"
+	LOC_STORE_LIST   = "SHD_PGB_111"
+	LOC_STORE_OPEN   = "SHD_PGB_112"
+	LOC_STORE_WRITE  = "SHD_PGB_113"
+	LOC_STORE_DELETE = "SHD_PGB_114"
+)
+
+// ErrBackupObjectNotFound is returned by Open and Stat for a missing file
+var ErrBackupObjectNotFound = errors.New("backup object not found")
+
+// BackupObject describes one file of a base backup
+type BackupObject struct {
+	BackupID string
+	Name     string // File name within the backup, e.g. base.tar.gz
+	Size     int64
+	ModTime  time.Time
+}
+
+// BackupStore is where base backups are kept. A backup is a set of files
+// (tar archives and pgbackup_manifest.json) grouped under its backup ID;
+// backup IDs and file names never contain a path separator.
+//
+// LocalBackupStore keeps them in BaseBackupDir. Remote stores (S3, GCS)
+// implement the same methods, so listing, verification, restore and
+// retention work unchanged against them.
+type BackupStore interface {
+	// Location describes a backup for logs and BackupResult.BackupPath
+	Location(backupID string) string
+
+	// List returns the files of a backup, or of all backups when backupID
+	// is empty, sorted by backup ID and name
+	List(ctx context.Context, backupID string) ([]BackupObject, error)
+
+	// Open streams a file; the caller closes it
+	Open(ctx context.Context, backupID, name string) (io.ReadCloser, error)
+
+	// Write stores a file, replacing any existing one
+	Write(ctx context.Context, backupID, name string, r io.Reader) error
+
+	// Stat returns a file's size and modification time
+	Stat(ctx context.Context, backupID, name string) (*BackupObject, error)
+
+	// Delete removes a backup and all its files
+	Delete(ctx context.Context, backupID string) error
+}
+
+// validateObjectName rejects backup IDs and file names that would escape
+// the backup they belong to
+func validateObjectName(kind, name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid %s %q (%s)", kind, name, LOC_STORE_NAME)
+	}
+	return nil
+}
+
+// LocalBackupStore keeps each backup as a directory of files under a root
+// directory, the layout pg_basebackup -D <root>/<backup_id> produces
+type LocalBackupStore struct {
+	root string
+}
+
+// NewLocalBackupStore returns a store rooted at dir. The directory is
+// created on the first write.
+func NewLocalBackupStore(dir string) *LocalBackupStore {
+	return &LocalBackupStore{root: dir}
+}
+
+// Root returns the directory the store keeps backups in
+func (l *LocalBackupStore) Root() string {
+	return l.root
+}
+
+func (l *LocalBackupStore) Location(backupID string) string {
+	return filepath.Join(l.root, backupID)
+}
+
+func (l *LocalBackupStore) path(backupID, name string) (string, error) {
+	if err := validateObjectName("backup ID", backupID); err != nil {
+		return "", err
+	}
+	if err := validateObjectName("backup file name", name); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, backupID, name), nil
+}
+
+func (l *LocalBackupStore) List(ctx context.Context, backupID string) ([]BackupObject, error) {
+	backupIDs := []string{backupID}
+	if backupID == "" {
+		entries, err := os.ReadDir(l.root)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return []BackupObject{}, nil
+			}
+			return nil, fmt.Errorf("failed to read backup directory: %w (%s)", err, LOC_STORE_LIST)
+		}
+		backupIDs = backupIDs[:0]
+		for _, entry := range entries {
+			if entry.IsDir() {
+				backupIDs = append(backupIDs, entry.Name())
+			}
+		}
+	} else if err := validateObjectName("backup ID", backupID); err != nil {
+		return nil, err
+	}
+
+	objects := []BackupObject{}
+	for _, id := range backupIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(filepath.Join(l.root, id))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read backup %s: %w (%s)", id, err, LOC_STORE_LIST)
+		}
+		for _, entry := range entries {
+			// Skip subdirectories and files still being written
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".write-") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			objects = append(objects, BackupObject{
+				BackupID: id,
+				Name:     entry.Name(),
+				Size:     info.Size(),
+				ModTime:  info.ModTime(),
+			})
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].BackupID != objects[j].BackupID {
+			return objects[i].BackupID < objects[j].BackupID
+		}
+		return objects[i].Name < objects[j].Name
+	})
+	return objects, nil
+}
+
+func (l *LocalBackupStore) Open(_ context.Context, backupID, name string) (io.ReadCloser, error) {
+	path, err := l.path(backupID, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s/%s: %w", backupID, name, ErrBackupObjectNotFound)
+		}
+		return nil, fmt.Errorf("failed to open %s/%s: %w (%s)", backupID, name, err, LOC_STORE_OPEN)
+	}
+	return f, nil
+}
+
+// Write goes through a temporary file so that a failed write never leaves
+// a partial file behind
+func (l *LocalBackupStore) Write(ctx context.Context, backupID, name string, r io.Reader) error {
+	path, err := l.path(backupID, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w (%s)", err, LOC_STORE_WRITE)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".write-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w (%s)", err, LOC_STORE_WRITE)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s/%s: %w (%s)", backupID, name, err, LOC_STORE_WRITE)
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return fmt.Errorf("failed to set permissions on %s/%s: %w (%s)", backupID, name, err, LOC_STORE_WRITE)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s/%s: %w (%s)", backupID, name, err, LOC_STORE_WRITE)
+	}
+	return nil
+}
+
+func (l *LocalBackupStore) Stat(_ context.Context, backupID, name string) (*BackupObject, error) {
+	path, err := l.path(backupID, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s/%s: %w", backupID, name, ErrBackupObjectNotFound)
+		}
+		return nil, fmt.Errorf("failed to stat %s/%s: %w (%s)", backupID, name, err, LOC_STORE_OPEN)
+	}
+	return &BackupObject{BackupID: backupID, Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *LocalBackupStore) Delete(_ context.Context, backupID string) error {
+	if err := validateObjectName("backup ID", backupID); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(l.root, backupID)); err != nil {
+		return fmt.Errorf("failed to remove backup %s: %w (%s)", backupID, err, LOC_STORE_DELETE)
+	}
+	return nil
+}
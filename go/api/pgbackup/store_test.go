@@ -0,0 +1,172 @@
+package pgbackup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarGz returns a gzipped tar holding the given files
+func tarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLocalBackupStore(t *testing.T) {
+	ctx := context.Background()
+	root := filepath.Join(t.TempDir(), "base")
+	store := NewLocalBackupStore(root)
+
+	// A missing root holds no backups
+	objects, err := store.List(ctx, "")
+	if err != nil || len(objects) != 0 {
+		t.Fatalf("empty list = %v, %v", objects, err)
+	}
+
+	for _, f := range []struct{ id, name, content string }{
+		{"20260102_020000", "pg_wal.tar.gz", "wal"},
+		{"20260102_020000", "base.tar.gz", "base"},
+		{"20260101_020000", "base.tar.gz", "old"},
+	} {
+		if err := store.Write(ctx, f.id, f.name, strings.NewReader(f.content)); err != nil {
+			t.Fatalf("write %s/%s: %v", f.id, f.name, err)
+		}
+	}
+
+	objects, err = store.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.BackupID+"/"+obj.Name)
+	}
+	if got := strings.Join(keys, ","); got != "20260101_020000/base.tar.gz,20260102_020000/base.tar.gz,20260102_020000/pg_wal.tar.gz" {
+		t.Errorf("list = %s", got)
+	}
+
+	r, err := store.Open(ctx, "20260102_020000", "base.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "base" {
+		t.Errorf("open = %q", data)
+	}
+
+	if _, err := store.Stat(ctx, "20260102_020000", "missing.tar"); !errors.Is(err, ErrBackupObjectNotFound) {
+		t.Errorf("stat of a missing file: error = %v", err)
+	}
+	for _, id := range []string{"..", "../tables", "a/b", ""} {
+		if _, err := store.Open(ctx, id, "base.tar.gz"); err == nil || !strings.Contains(err.Error(), LOC_STORE_NAME) {
+			t.Errorf("open %q: error = %v", id, err)
+		}
+		if err := store.Delete(ctx, id); err == nil {
+			t.Errorf("delete %q succeeded", id)
+		}
+	}
+
+	if err := store.Delete(ctx, "20260101_020000"); err != nil {
+		t.Fatal(err)
+	}
+	if objects, _ := store.List(ctx, "20260101_020000"); len(objects) != 0 {
+		t.Errorf("deleted backup still lists %v", objects)
+	}
+	if _, err := os.Stat(filepath.Join(root, "20260102_020000", "base.tar.gz")); err != nil {
+		t.Errorf("delete removed another backup: %v", err)
+	}
+}
+
+func TestBackupServiceThroughStore(t *testing.T) {
+	ctx := context.Background()
+	baseDir := filepath.Join(t.TempDir(), "base")
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "000000010000000000000001"), []byte("wal"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := &BackupConfig{BaseBackupDir: baseDir, WALArchiveDir: walDir}
+
+	// The store need not be BaseBackupDir: nothing may read it directly
+	store := NewLocalBackupStore(filepath.Join(t.TempDir(), "remote"))
+	service := NewBackupServiceWithStore(config, nil, store)
+
+	good := &BackupResult{BackupID: "20260102_020000", Labels: []string{"release"}, Success: true}
+	if err := store.Write(ctx, good.BackupID, "base.tar.gz",
+		bytes.NewReader(tarGz(t, map[string]string{"PG_VERSION": "16\n"}))); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.writeBackupManifest(ctx, good); err != nil {
+		t.Fatal(err)
+	}
+	// A backup without manifest whose archive is truncated
+	archive := tarGz(t, map[string]string{"PG_VERSION": "16\n"})
+	if err := store.Write(ctx, "20260101_020000", "base.tar.gz", bytes.NewReader(archive[:len(archive)/2])); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := service.ListBackups()
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("list = %v, %v", backups, err)
+	}
+	if b := backups[1]; b.BackupID != good.BackupID || !b.HasLabel("release") {
+		t.Errorf("backup with manifest = %+v", b)
+	}
+	if b := backups[0]; b.BackupPath != store.Location("20260101_020000") || b.StartTime.IsZero() || b.SizeBytes == 0 {
+		t.Errorf("backup without manifest = %+v", b)
+	}
+	if _, err := service.GetBackup("20260103_020000"); err == nil {
+		t.Errorf("get of a missing backup succeeded")
+	}
+
+	result, err := service.Verify(ctx, testLogger, good.BackupID)
+	if err != nil || !result.Success {
+		t.Errorf("verify good backup = %+v, %v", result, err)
+	}
+	result, err = service.Verify(ctx, testLogger, "20260101_020000")
+	if err != nil || result.Success || result.TarFilesOK {
+		t.Errorf("verify truncated backup = %+v, %v", result, err)
+	}
+
+	targetDir := t.TempDir()
+	if err := service.extractBackup(ctx, testLogger, good.BackupID, targetDir); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(targetDir, "PG_VERSION")); err != nil || string(data) != "16\n" {
+		t.Errorf("extracted PG_VERSION = %q, %v", data, err)
+	}
+
+	if err := service.deleteBackup(ctx, "20260101_020000"); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := service.ListBackups(); len(backups) != 1 {
+		t.Errorf("after delete, list = %v", backups)
+	}
+	if _, err := os.Stat(baseDir); !os.IsNotExist(err) {
+		t.Errorf("BaseBackupDir was used: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package pgbackup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Location codes for streamed backups and restores
+const (
+	LOC_STREAM_BACKUP  = "SHD_PGB_132"
+	LOC_STREAM_ARGS    = "SHD_PGB_133"
+	LOC_STREAM_RESTORE = "SHD_PGB_134"
+	LOC_STREAM_EXTRACT = "SHD_PGB_135"
+)
+
+// StreamedBackupName is what a restore from a stream reports as the
+// backup used: a streamed backup has no backup ID
+const StreamedBackupName = "stream"
+
+// walMethod returns the value of the last -X/--wal-method option in
+// 'args', which ValidateBaseBackupArgs accepted, or "" if there is none
+func walMethod(args []string) string {
+	method := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case strings.HasPrefix(arg, "--wal-method="):
+			method = strings.TrimPrefix(arg, "--wal-method=")
+		case arg == "--wal-method" || arg == "-X":
+			if i+1 < len(args) {
+				i++
+				method = args[i]
+			}
+		case strings.HasPrefix(arg, "-X"):
+			method = arg[2:]
+		}
+	}
+	return method
+}
+
+// streamBaseBackupArgs returns the pg_basebackup arguments of a backup
+// written to stdout. The WAL is fetched into the tar at the end of the
+// backup: pg_basebackup can't stream WAL while writing the tar to stdout.
+func (s *BackupService) streamBaseBackupArgs(label string) ([]string, error) {
+	if err := ValidateBaseBackupArgs(s.config.BaseBackupArgs); err != nil {
+		return nil, err
+	}
+	if method := walMethod(s.config.BaseBackupArgs); method == "stream" || method == "s" {
+		return nil, fmt.Errorf("PG_BACKUP_BASEBACKUP_ARGS sets --wal-method=%s, which pg_basebackup "+
+			"can't use for a backup to stdout (%s)", method, LOC_STREAM_ARGS)
+	}
+
+	// -D -: write the tar to stdout
+	// -Xf: fetch the WAL into the tar at the end of the backup
+	// The configured extra arguments come last, so they override these.
+	args := []string{
+		"-h", s.config.PGHost,
+		"-p", fmt.Sprintf("%d", s.config.PGPort),
+		"-U", s.config.PGUser,
+		"-D", "-",
+		"-Ft",               // tar format
+		"-Xf",               // fetch WAL
+		"-v",                // verbose
+		"-z",                // gzip compression
+		"--checkpoint=fast", // don't wait for checkpoint
+		"--label", label,
+	}
+	return append(args, s.config.BaseBackupArgs...), nil
+}
+
+// StreamBaseBackup runs pg_basebackup and writes the backup, a gzipped
+// tar of the data directory with the WAL it needs, to 'w', e.g. stdout
+// to pipe it to another host. Nothing is written to the backup store: a
+// streamed backup has no manifest, labels or backup ID, so list, verify,
+// retention, remote sync and WAL cleanup don't know about it. Restore it
+// with RestoreFromStream. pg_basebackup can only write a cluster without
+// extra tablespaces to stdout.
+func (s *BackupService) StreamBaseBackup(ctx context.Context, logger *slog.Logger, w io.Writer) error {
+	start := time.Now()
+	args, err := s.streamBaseBackupArgs(fmt.Sprintf("backup_stream_%s", start.Format("20060102_150405")))
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Starting streamed base backup",
+		"host", s.config.PGHost,
+		"port", s.config.PGPort)
+	logger.Debug("pg_basebackup command", "command", "pg_basebackup "+strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, "pg_basebackup", args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.PGPassword))
+	cw := &countingWriter{w: w}
+	cmd.Stdout = cw
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		logger.Error("Streamed base backup failed",
+			"error", err,
+			"output", stderr.String())
+		return fmt.Errorf("pg_basebackup failed: %v (%s)", err, LOC_STREAM_BACKUP)
+	}
+
+	logger.Info("Streamed base backup completed successfully",
+		"duration", time.Since(start).Round(time.Second),
+		"size_mb", float64(cw.n)/(1024*1024))
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// validateStreamRestore rejects the options a restore from a stream
+// can't honour. The backup comes from the stream, not the store, and
+// recovery stops at the end of the backup, using the WAL in it.
+func validateStreamRestore(opts RestoreOptions) error {
+	if opts.BackupID != "" {
+		return fmt.Errorf("a restore from a stream takes no backup ID (%s)", LOC_STREAM_RESTORE)
+	}
+	if opts.TargetTime != nil || opts.TargetXID != "" || opts.TargetName != "" || opts.TargetImmediate {
+		return fmt.Errorf("a restore from a stream recovers to the end of the backup and takes no "+
+			"recovery target (%s)", LOC_STREAM_RESTORE)
+	}
+	return nil
+}
+
+// RestoreFromStream extracts a backup written by StreamBaseBackup from
+// 'r' into the target directory. opts.BackupID and the recovery targets
+// must not be set (see validateStreamRestore); DryRun only checks the
+// target directory without reading 'r'.
+//
+// No recovery configuration is written: when started, PostgreSQL replays
+// the WAL included in the backup and comes up as a copy of the source.
+func (s *BackupService) RestoreFromStream(
+	ctx context.Context,
+	logger *slog.Logger,
+	r io.Reader,
+	opts RestoreOptions) (*RestoreResult, error) {
+	result := &RestoreResult{BackupUsed: StreamedBackupName}
+	fail := func(err error) (*RestoreResult, error) {
+		result.Success = false
+		result.ErrorMsg = err.Error()
+		return result, err
+	}
+
+	if err := validateStreamRestore(opts); err != nil {
+		return fail(err)
+	}
+	targetDir, err := s.checkRestoreTarget(ctx, logger, opts)
+	if err != nil {
+		return fail(err)
+	}
+	result.TargetDir = targetDir
+
+	if opts.DryRun {
+		logger.Info("Dry run - restore from stream validated but not executed")
+		result.Success = true
+		return result, nil
+	}
+
+	if err := os.MkdirAll(targetDir, 0700); err != nil {
+		return fail(fmt.Errorf("failed to create target directory: %v (%s)", err, LOC_STREAM_EXTRACT))
+	}
+
+	logger.Info("Extracting base backup from stream", "to", targetDir)
+	cmd := exec.CommandContext(ctx, "tar", "-xzf", "-", "-C", targetDir)
+	cmd.Stdin = r
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fail(fmt.Errorf("failed to extract backup from stream: %v, output: %s (%s)",
+			err, string(output), LOC_STREAM_EXTRACT))
+	}
+	if err := os.Chmod(targetDir, 0700); err != nil {
+		logger.Warn("Failed to set permissions on data directory", "error", err)
+	}
+
+	pgVersion, err := s.restoreVersion(logger, targetDir)
+	if err != nil {
+		return fail(err)
+	}
+	result.PGVersion = pgVersion.String()
+	result.Success = true
+
+	if opts.Validate != nil {
+		validation, err := s.ValidateRestore(ctx, logger, targetDir, *opts.Validate)
+		result.Validation = validation
+		if err != nil {
+			return result, err
+		}
+		logger.Info("Restore from stream complete and validated",
+			"target_dir", targetDir,
+			"recovery_duration", validation.RecoveryDuration)
+		return result, nil
+	}
+
+	logger.Info("Restore from stream complete - start PostgreSQL to replay the WAL of the backup",
+		"target_dir", targetDir)
+	return result, nil
+}
@@ -0,0 +1,136 @@
+package pgbackup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamBaseBackupArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		extra   []string
+		wantWAL string
+		wantErr string
+	}{
+		{name: "defaults"},
+		{name: "fetch", extra: []string{"--wal-method=fetch"}, wantWAL: "fetch"},
+		{name: "stream", extra: []string{"-Xs"}, wantErr: "--wal-method=s"},
+		{name: "stream long", extra: []string{"--wal-method", "stream"}, wantErr: "--wal-method=stream"},
+		{name: "stream then fetch", extra: []string{"-X", "stream", "-Xf"}, wantWAL: "f"},
+		{name: "not allowed", extra: []string{"-D", "/tmp"}, wantErr: "not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewBackupService(&BackupConfig{PGHost: "localhost", PGPort: 5432, PGUser: "postgres",
+				BaseBackupArgs: tt.extra})
+			args, err := s.streamBaseBackupArgs("backup_stream_test")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			joined := strings.Join(args, " ")
+			if !strings.Contains(joined, "-D - -Ft -Xf") {
+				t.Errorf("args = %q, want the tar written to stdout", joined)
+			}
+			if got := walMethod(tt.extra); got != tt.wantWAL {
+				t.Errorf("walMethod = %q, want %q", got, tt.wantWAL)
+			}
+		})
+	}
+}
+
+func TestValidateStreamRestore(t *testing.T) {
+	target := time.Now()
+	tests := []struct {
+		name    string
+		opts    RestoreOptions
+		wantErr string
+	}{
+		{name: "target dir", opts: RestoreOptions{TargetDirectory: "/tmp/x", DryRun: true}},
+		{name: "backup id", opts: RestoreOptions{BackupID: "20260202_100000"}, wantErr: "no backup ID"},
+		{name: "target time", opts: RestoreOptions{TargetTime: &target}, wantErr: "no recovery target"},
+		{name: "target name", opts: RestoreOptions{TargetName: "before_migration"}, wantErr: "no recovery target"},
+	}
+	for _, tt := range tests {
+		err := validateStreamRestore(tt.opts)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want it to contain %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// gzippedTar returns a .tar.gz holding 'files'
+func gzippedTar(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestRestoreFromStream(t *testing.T) {
+	s := NewBackupService(&BackupConfig{PGHost: "127.0.0.1", PGPort: 1})
+	targetDir := filepath.Join(t.TempDir(), "data")
+	stream := gzippedTar(t, map[string]string{"PG_VERSION": "16\n", "backup_label": "START WAL LOCATION: 0/2000028\n"})
+
+	result, err := s.RestoreFromStream(context.Background(), testLogger, stream,
+		RestoreOptions{TargetDirectory: targetDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success || result.BackupUsed != StreamedBackupName || result.PGVersion != "16" {
+		t.Errorf("result = %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "backup_label")); err != nil {
+		t.Errorf("backup not extracted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "recovery.signal")); !os.IsNotExist(err) {
+		t.Errorf("recovery.signal written: %v", err)
+	}
+
+	// The target now has data
+	_, err = s.RestoreFromStream(context.Background(), testLogger,
+		gzippedTar(t, map[string]string{"PG_VERSION": "16\n"}), RestoreOptions{TargetDirectory: targetDir})
+	if err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Errorf("error = %v, want a non-empty target error", err)
+	}
+
+	// Not a gzipped tar
+	_, err = s.RestoreFromStream(context.Background(), testLogger, strings.NewReader("garbage"),
+		RestoreOptions{TargetDirectory: filepath.Join(t.TempDir(), "data")})
+	if err == nil || !strings.Contains(err.Error(), LOC_STREAM_EXTRACT) {
+		t.Errorf("error = %v, want an extract error", err)
+	}
+}
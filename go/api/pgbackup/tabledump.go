@@ -0,0 +1,350 @@
+package pgbackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Location codes for table dump operations
+const (
+	LOC_TDUMP_TABLE    = "SHD_PGB_100"
+	LOC_TDUMP_EXEC     = "SHD_PGB_101"
+	LOC_TDUMP_MANIFEST = "SHD_PGB_102"
+	LOC_TDUMP_RESTORE  = "SHD_PGB_103"
+	LOC_TDUMP_VERIFY   = "SHD_PGB_104"
+)
+
+const (
+	tableDumpFile     = "table.dump"
+	tableManifestFile = "pgbackup_table_manifest.json"
+)
+
+// identPattern is an unquoted PostgreSQL identifier. Schema and table
+// names are restricted to it as they are passed to pg_dump -t.
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]{0,62}$`)
+
+// TableDumpResult describes a dump of a single table. Table dumps live in
+// $PG_BACKUP_DIR/tables, apart from the base backups, and are not
+// touched by retention.
+type TableDumpResult struct {
+	DumpID    string    `json:"dump_id"`
+	Schema    string    `json:"schema"`
+	Table     string    `json:"table"`
+	Database  string    `json:"database"`
+	DumpPath  string    `json:"dump_path"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	SizeBytes int64     `json:"size_bytes"`
+	Success   bool      `json:"success"`
+	ErrorMsg  string    `json:"error_msg,omitempty"`
+}
+
+// QualifiedName returns schema.table
+func (d *TableDumpResult) QualifiedName() string {
+	return d.Schema + "." + d.Table
+}
+
+// TableRestoreOptions configures the restore of a table dump
+type TableRestoreOptions struct {
+	DumpID string
+	DryRun bool   // Only check that the dump is loadable
+	Clean  bool   // Drop and recreate the table; otherwise load the data into the existing table
+	BinDir string // Directory of pg_dump and pg_restore (default: PATH)
+}
+
+// TableRestoreResult contains information about a table restore
+type TableRestoreResult struct {
+	Success  bool     `json:"success"`
+	DumpID   string   `json:"dump_id"`
+	Table    string   `json:"table"`
+	DryRun   bool     `json:"dry_run"`
+	Entries  []string `json:"entries,omitempty"` // TOC entries of the dump
+	ErrorMsg string   `json:"error_msg,omitempty"`
+}
+
+// ParseTableName splits "schema.table" (or "table", in schema public).
+// Only unquoted identifiers are accepted.
+func ParseTableName(name string) (string, string, error) {
+	schema, table, found := strings.Cut(name, ".")
+	if !found {
+		schema, table = "public", name
+	}
+	if !identPattern.MatchString(schema) || !identPattern.MatchString(table) {
+		return "", "", fmt.Errorf("invalid table name %q: use schema.table with letters, digits, '_' or '$' (%s)",
+			name, LOC_TDUMP_TABLE)
+	}
+	return schema, table, nil
+}
+
+// DumpTable dumps one table with pg_dump -t in custom format, so it can be
+// restored on its own with RestoreTable.
+func (s *BackupService) DumpTable(
+	ctx context.Context,
+	logger *slog.Logger,
+	name string,
+	binDir string) (*TableDumpResult, error) {
+	schema, table, err := ParseTableName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TableDumpResult{
+		DumpID:    fmt.Sprintf("%s_%s.%s", time.Now().Format("20060102_150405"), schema, table),
+		Schema:    schema,
+		Table:     table,
+		Database:  s.config.PGDatabase,
+		StartTime: time.Now(),
+	}
+
+	dumpDir := filepath.Join(s.config.TableDumpDir, result.DumpID)
+	if err := os.MkdirAll(dumpDir, 0700); err != nil {
+		result.ErrorMsg = fmt.Sprintf("failed to create dump dir: %v", err)
+		return result, fmt.Errorf("%s (%s)", result.ErrorMsg, LOC_TDUMP_EXEC)
+	}
+	result.DumpPath = filepath.Join(dumpDir, tableDumpFile)
+
+	logger.Info("Starting table dump",
+		"dump_id", result.DumpID,
+		"table", result.QualifiedName(),
+		"database", s.config.PGDatabase)
+
+	// -Fc: custom format, read by pg_restore
+	// -t:  the table only; quoted so the name is matched exactly
+	cmd := exec.CommandContext(ctx, s.pgBinary(binDir, "pg_dump"),
+		"-h", s.config.PGHost,
+		"-p", fmt.Sprintf("%d", s.config.PGPort),
+		"-U", s.config.PGUser,
+		"-d", s.config.PGDatabase,
+		"-Fc",
+		"-t", fmt.Sprintf(`"%s"."%s"`, schema, table),
+		"-f", result.DumpPath,
+	)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.PGPassword))
+
+	output, err := cmd.CombinedOutput()
+	result.EndTime = time.Now()
+	if err != nil {
+		result.ErrorMsg = fmt.Sprintf("pg_dump failed: %v", err)
+		logger.Error("Table dump failed",
+			"error", err,
+			"output", string(output),
+			"dump_id", result.DumpID)
+		os.RemoveAll(dumpDir)
+		return result, fmt.Errorf("%s (%s)", result.ErrorMsg, LOC_TDUMP_EXEC)
+	}
+
+	// pg_dump succeeds with an empty dump if the table does not exist
+	entries, err := s.tableDumpEntries(ctx, binDir, result.DumpPath)
+	if err == nil && !hasTableData(entries, schema, table) {
+		err = fmt.Errorf("table %s not found in database %s", result.QualifiedName(), s.config.PGDatabase)
+	}
+	if err != nil {
+		result.ErrorMsg = err.Error()
+		os.RemoveAll(dumpDir)
+		return result, fmt.Errorf("%s (%s)", result.ErrorMsg, LOC_TDUMP_VERIFY)
+	}
+
+	if info, err := os.Stat(result.DumpPath); err == nil {
+		result.SizeBytes = info.Size()
+	}
+	result.Success = true
+
+	if err := writeTableManifest(dumpDir, result); err != nil {
+		logger.Warn("Failed to write table dump manifest", "error", err)
+	}
+
+	logger.Info("Table dump completed successfully",
+		"dump_id", result.DumpID,
+		"duration", result.EndTime.Sub(result.StartTime).Round(time.Second),
+		"size_mb", float64(result.SizeBytes)/(1024*1024))
+	return result, nil
+}
+
+// RestoreTable loads a table dump into the database with pg_restore, in a
+// single transaction. By default only the rows are loaded, into the
+// existing (e.g. truncated) table; with Clean the table is dropped and
+// recreated. With DryRun the dump is only read through, to check that it
+// is loadable.
+func (s *BackupService) RestoreTable(
+	ctx context.Context,
+	logger *slog.Logger,
+	opts TableRestoreOptions) (*TableRestoreResult, error) {
+	dump, err := s.GetTableDump(opts.DumpID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TableRestoreResult{
+		DumpID: dump.DumpID,
+		Table:  dump.QualifiedName(),
+		DryRun: opts.DryRun,
+	}
+
+	logger.Info("Preparing table restore",
+		"dump_id", dump.DumpID,
+		"table", result.Table,
+		"database", s.config.PGDatabase,
+		"clean", opts.Clean,
+		"dry_run", opts.DryRun)
+
+	// pg_restore -l reads the table of contents, so a dump that is not an
+	// archive, or has no data for the table, fails here
+	entries, err := s.tableDumpEntries(ctx, opts.BinDir, dump.DumpPath)
+	if err == nil && !hasTableData(entries, dump.Schema, dump.Table) {
+		err = fmt.Errorf("dump has no data for table %s", result.Table)
+	}
+	if err != nil {
+		result.ErrorMsg = err.Error()
+		return result, fmt.Errorf("dump %s is not loadable: %w (%s)", dump.DumpID, err, LOC_TDUMP_VERIFY)
+	}
+	result.Entries = entries
+
+	if opts.DryRun {
+		// The TOC alone doesn't show that the data is readable; restoring
+		// to /dev/null reads and decompresses all of it
+		if err := s.readTableDump(ctx, opts.BinDir, dump.DumpPath); err != nil {
+			result.ErrorMsg = err.Error()
+			return result, fmt.Errorf("dump %s is not loadable: %w (%s)", dump.DumpID, err, LOC_TDUMP_VERIFY)
+		}
+		result.Success = true
+		return result, nil
+	}
+
+	args := []string{
+		"-h", s.config.PGHost,
+		"-p", fmt.Sprintf("%d", s.config.PGPort),
+		"-U", s.config.PGUser,
+		"-d", s.config.PGDatabase,
+		"--single-transaction",
+		"--exit-on-error",
+	}
+	if opts.Clean {
+		args = append(args, "--clean", "--if-exists")
+	} else {
+		args = append(args, "--data-only")
+	}
+	args = append(args, dump.DumpPath)
+
+	cmd := exec.CommandContext(ctx, s.pgBinary(opts.BinDir, "pg_restore"), args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.PGPassword))
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		result.ErrorMsg = fmt.Sprintf("pg_restore failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+		logger.Error("Table restore failed", "error", err, "output", string(output), "dump_id", dump.DumpID)
+		return result, fmt.Errorf("%s (%s)", result.ErrorMsg, LOC_TDUMP_RESTORE)
+	}
+
+	result.Success = true
+	logger.Info("Table restore completed successfully", "dump_id", dump.DumpID, "table", result.Table)
+	return result, nil
+}
+
+// readTableDump reads a whole custom-format dump as a restore would,
+// writing the SQL to /dev/null (pg_restore -f /dev/null)
+func (s *BackupService) readTableDump(ctx context.Context, binDir string, dumpPath string) error {
+	output, err := exec.CommandContext(ctx, s.pgBinary(binDir, "pg_restore"), "-f", os.DevNull, dumpPath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("pg_restore -f %s failed: %v, output: %s", os.DevNull, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// tableDumpEntries returns the table of contents of a custom-format dump
+// (pg_restore -l), without the comment lines.
+func (s *BackupService) tableDumpEntries(ctx context.Context, binDir string, dumpPath string) ([]string, error) {
+	output, err := exec.CommandContext(ctx, s.pgBinary(binDir, "pg_restore"), "-l", dumpPath).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("pg_restore -l failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, nil
+}
+
+// hasTableData reports whether a TOC has the TABLE DATA entry of the
+// table. The entries read: <id>; <oid> <oid> TABLE DATA <schema> <table> <owner>
+func hasTableData(entries []string, schema string, table string) bool {
+	want := fmt.Sprintf(" TABLE DATA %s %s ", schema, table)
+	for _, entry := range entries {
+		if strings.Contains(entry+" ", want) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTableManifest writes the JSON manifest of a table dump
+func writeTableManifest(dumpDir string, result *TableDumpResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w (%s)", err, LOC_TDUMP_MANIFEST)
+	}
+	if err := os.WriteFile(filepath.Join(dumpDir, tableManifestFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to write manifest: %w (%s)", err, LOC_TDUMP_MANIFEST)
+	}
+	return nil
+}
+
+// ListTableDumps returns all table dumps. Dumps without a readable
+// manifest are skipped, as the table they hold is unknown.
+func (s *BackupService) ListTableDumps() ([]*TableDumpResult, error) {
+	entries, err := os.ReadDir(s.config.TableDumpDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*TableDumpResult{}, nil
+		}
+		return nil, fmt.Errorf("failed to read table dump directory: %w", err)
+	}
+
+	var dumps []*TableDumpResult
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dump, err := s.GetTableDump(entry.Name())
+		if err != nil {
+			continue
+		}
+		dumps = append(dumps, dump)
+	}
+	return dumps, nil
+}
+
+// GetTableDump retrieves a table dump by ID
+func (s *BackupService) GetTableDump(dumpID string) (*TableDumpResult, error) {
+	if dumpID == "" || strings.ContainsAny(dumpID, `/\`) || dumpID == "." || dumpID == ".." {
+		return nil, fmt.Errorf("invalid dump id: %q (%s)", dumpID, LOC_TDUMP_TABLE)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.config.TableDumpDir, dumpID, tableManifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("table dump not found: %s (%s)", dumpID, LOC_TDUMP_MANIFEST)
+		}
+		return nil, fmt.Errorf("failed to read manifest: %w (%s)", err, LOC_TDUMP_MANIFEST)
+	}
+
+	var dump TableDumpResult
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w (%s)", err, LOC_TDUMP_MANIFEST)
+	}
+	// The dump is read from where it is now, not where it was written
+	dump.DumpPath = filepath.Join(s.config.TableDumpDir, dumpID, tableDumpFile)
+	return &dump, nil
+}
@@ -0,0 +1,165 @@
+package pgbackup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseTableName(t *testing.T) {
+	tests := []struct {
+		in         string
+		wantSchema string
+		wantTable  string
+		wantErr    bool
+	}{
+		{in: "public.orders", wantSchema: "public", wantTable: "orders"},
+		{in: "orders", wantSchema: "public", wantTable: "orders"},
+		{in: "billing.invoice_items", wantSchema: "billing", wantTable: "invoice_items"},
+		{in: "a.b.c", wantErr: true},
+		{in: "public.", wantErr: true},
+		{in: `public."Orders"`, wantErr: true},
+		{in: "public.orders;drop", wantErr: true},
+	}
+	for _, tt := range tests {
+		schema, table, err := ParseTableName(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s.%s", tt.in, schema, table)
+			}
+			continue
+		}
+		if err != nil || schema != tt.wantSchema || table != tt.wantTable {
+			t.Errorf("%q: got %s.%s, %v, want %s.%s", tt.in, schema, table, err, tt.wantSchema, tt.wantTable)
+		}
+	}
+}
+
+// fakePGTools writes pg_dump and pg_restore scripts to a directory. The
+// dump holds the -t pattern; pg_restore -l lists a TABLE DATA entry for
+// public.orders only, pg_restore -f records its arguments in read_args and
+// fails on a dump marked corrupt, and a restore records its arguments in
+// restore_args.
+func fakePGTools(t *testing.T) string {
+	t.Helper()
+	binDir := t.TempDir()
+	scripts := map[string]string{
+		"pg_dump": `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -t) table="$2"; shift ;;
+    -f) out="$2"; shift ;;
+  esac
+  shift
+done
+echo "$table" > "$out"
+`,
+		"pg_restore": `#!/bin/sh
+if [ "$1" = "-l" ]; then
+  echo ";"
+  echo "; Archive created at 2026-02-02 10:00:00 UTC"
+  if grep -q '"public"."orders"' "$2"; then
+    echo "215; 0 16386 TABLE DATA public orders postgres"
+  fi
+  exit 0
+fi
+if [ "$1" = "-f" ]; then
+  echo "$@" > "$(dirname "$0")/read_args"
+  if grep -q corrupt "$3"; then
+    echo "pg_restore: error: could not uncompress data" >&2
+    exit 1
+  fi
+  exit 0
+fi
+echo "$@" > "$(dirname "$0")/restore_args"
+`,
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(binDir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return binDir
+}
+
+func TestDumpAndRestoreTable(t *testing.T) {
+	binDir := fakePGTools(t)
+	config := &BackupConfig{
+		PGHost:       "127.0.0.1",
+		PGPort:       5432,
+		PGUser:       "tester",
+		PGDatabase:   "app",
+		TableDumpDir: filepath.Join(t.TempDir(), "tables"),
+	}
+	service := NewBackupService(config)
+	ctx := context.Background()
+
+	dump, err := service.DumpTable(ctx, testLogger, "orders", binDir)
+	if err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+	if !dump.Success || dump.QualifiedName() != "public.orders" || dump.SizeBytes == 0 {
+		t.Errorf("dump result = %+v", dump)
+	}
+
+	// A table pg_dump does not find leaves no dump behind
+	if _, err := service.DumpTable(ctx, testLogger, "public.missing", binDir); err == nil ||
+		!strings.Contains(err.Error(), "table public.missing not found") {
+		t.Errorf("missing table: error = %v", err)
+	}
+
+	dumps, err := service.ListTableDumps()
+	if err != nil || len(dumps) != 1 || dumps[0].DumpID != dump.DumpID {
+		t.Fatalf("list = %v, %v, want only %s", dumps, err, dump.DumpID)
+	}
+
+	restoreArgs := filepath.Join(binDir, "restore_args")
+	result, err := service.RestoreTable(ctx, testLogger, TableRestoreOptions{
+		DumpID: dump.DumpID, DryRun: true, BinDir: binDir})
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if !result.Success || len(result.Entries) != 1 {
+		t.Errorf("dry run result = %+v", result)
+	}
+	if _, err := os.Stat(restoreArgs); !os.IsNotExist(err) {
+		t.Errorf("dry run ran pg_restore")
+	}
+	if data, _ := os.ReadFile(filepath.Join(binDir, "read_args")); strings.TrimSpace(string(data)) != "-f /dev/null "+dump.DumpPath {
+		t.Errorf("dry run read args = %q, want the dump read to /dev/null", data)
+	}
+
+	for _, clean := range []bool{false, true} {
+		if _, err := service.RestoreTable(ctx, testLogger, TableRestoreOptions{
+			DumpID: dump.DumpID, Clean: clean, BinDir: binDir}); err != nil {
+			t.Fatalf("restore (clean=%v): %v", clean, err)
+		}
+		data, _ := os.ReadFile(restoreArgs)
+		args := string(data)
+		if !strings.Contains(args, "--single-transaction") || !strings.HasSuffix(strings.TrimSpace(args), dump.DumpPath) {
+			t.Errorf("restore args = %q", args)
+		}
+		if clean != strings.Contains(args, "--clean") || clean == strings.Contains(args, "--data-only") {
+			t.Errorf("clean=%v: restore args = %q", clean, args)
+		}
+	}
+
+	// A dump with a valid TOC but unreadable data fails the dry run
+	f, err := os.OpenFile(dump.DumpPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("corrupt\n")
+	f.Close()
+	if _, err := service.RestoreTable(ctx, testLogger, TableRestoreOptions{
+		DumpID: dump.DumpID, DryRun: true, BinDir: binDir}); err == nil ||
+		!strings.Contains(err.Error(), "could not uncompress data") {
+		t.Errorf("dry run of a corrupt dump: error = %v", err)
+	}
+
+	if _, err := service.RestoreTable(ctx, testLogger, TableRestoreOptions{DumpID: "../base"}); err == nil {
+		t.Errorf("restore of a path outside the table dumps succeeded")
+	}
+}
@@ -0,0 +1,254 @@
+package pgbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Location codes for restore tests
+const (
+	LOC_TESTRESTORE_START   = "SHD_PGB_150"
+	LOC_TESTRESTORE_EXTRACT = "SHD_PGB_151"
+	LOC_TESTRESTORE_RUN     = "SHD_PGB_152"
+	LOC_TESTRESTORE_QUERY   = "SHD_PGB_153"
+	LOC_TESTRESTORE_CLEANUP = "SHD_PGB_154"
+)
+
+// containerDataDir is where the restored data directory is mounted in a
+// test restore container
+const containerDataDir = "/var/lib/postgresql/data"
+
+// TestRestoreOptions configures a restore test
+type TestRestoreOptions struct {
+	BackupID string
+	Query    string        // Smoke query (default: PG_BACKUP_VALIDATE_QUERY)
+	Expect   string        // If set, the smoke query must output exactly this
+	WorkDir  string        // Where the backup is extracted (default: system temp directory)
+	Port     int           // Port for the throwaway instance (default: PG_BACKUP_VALIDATE_PORT)
+	Timeout  time.Duration // How long to wait for recovery to finish (default: 30m)
+	BinDir   string        // Directory of pg_ctl and psql (default: PATH)
+
+	// Image, if set, runs the throwaway PostgreSQL in a container of this
+	// image (e.g. postgres:16) with Runtime (default: docker) instead of
+	// with the local binaries
+	Image   string
+	Runtime string
+}
+
+// TestRestoreResult contains the outcome of a restore test
+type TestRestoreResult struct {
+	Success          bool          `json:"success"`
+	BackupUsed       string        `json:"backup_used"`
+	PGVersion        string        `json:"pg_version,omitempty"`
+	Image            string        `json:"image,omitempty"` // Empty if the local binaries ran the test
+	RecoveryDuration time.Duration `json:"recovery_duration"`
+	Duration         time.Duration `json:"duration"`
+	Query            string        `json:"query"`
+	QueryResult      string        `json:"query_result,omitempty"`
+	ErrorMsg         string        `json:"error_msg,omitempty"`
+}
+
+// TestRestore checks that a backup restores: it extracts the backup into
+// a temporary directory, recovers it to the end of the backup with a
+// throwaway PostgreSQL, runs the smoke query and removes everything
+// again. The backup store and the running server are not changed.
+//
+// The throwaway PostgreSQL is started with the local binaries, as
+// ValidateRestore does, or in a container if opts.Image is set. A
+// container has no network; the WAL archive is mounted read-only at the
+// same path so that restore_command finds it.
+func (s *BackupService) TestRestore(
+	ctx context.Context,
+	logger *slog.Logger,
+	opts TestRestoreOptions) (*TestRestoreResult, error) {
+	if opts.Query == "" {
+		opts.Query = s.config.ValidateQuery
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Minute
+	}
+	if opts.Image != "" && opts.Runtime == "" {
+		opts.Runtime = "docker"
+	}
+	started := time.Now()
+	result := &TestRestoreResult{
+		BackupUsed: opts.BackupID,
+		Image:      opts.Image,
+		Query:      opts.Query,
+	}
+
+	fail := func(loc string, format string, args ...interface{}) (*TestRestoreResult, error) {
+		result.Success = false
+		result.ErrorMsg = fmt.Sprintf(format, args...)
+		result.Duration = time.Since(started)
+		logger.Error("Restore test failed", "error", result.ErrorMsg, "backup_id", opts.BackupID)
+		return result, fmt.Errorf("%s (%s)", result.ErrorMsg, loc)
+	}
+
+	// 1. The backup must be complete
+	if err := validateObjectName("backup ID", opts.BackupID); err != nil {
+		return fail(LOC_TESTRESTORE_START, "%v", err)
+	}
+	if _, err := s.store.Stat(ctx, opts.BackupID, "base.tar.gz"); err != nil {
+		if errors.Is(err, ErrBackupObjectNotFound) {
+			return fail(LOC_TESTRESTORE_START, "backup not found or incomplete (missing base.tar.gz): %s", opts.BackupID)
+		}
+		return fail(LOC_TESTRESTORE_START, "failed to stat base.tar.gz: %v", err)
+	}
+	logger.Info("Testing restore", "backup_id", opts.BackupID, "image", opts.Image)
+
+	// 2. Extract and configure recovery in a temporary data directory
+	workDir, err := os.MkdirTemp(opts.WorkDir, "pgbackup-testrestore-")
+	if err != nil {
+		return fail(LOC_TESTRESTORE_EXTRACT, "failed to create work directory: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(workDir); err != nil {
+			logger.Warn("Failed to remove work directory", "dir", workDir, "error", err, "loc", LOC_TESTRESTORE_CLEANUP)
+		}
+	}()
+	dataDir := filepath.Join(workDir, "data")
+	if err := os.Mkdir(dataDir, 0700); err != nil {
+		return fail(LOC_TESTRESTORE_EXTRACT, "failed to create data directory: %v", err)
+	}
+
+	if err := s.extractBackup(ctx, logger, opts.BackupID, dataDir); err != nil {
+		return fail(LOC_TESTRESTORE_EXTRACT, "failed to extract backup: %v", err)
+	}
+	pgVersion, err := s.restoreVersion(logger, dataDir)
+	if err != nil {
+		return fail(LOC_TESTRESTORE_EXTRACT, "%v", err)
+	}
+	result.PGVersion = pgVersion.String()
+
+	recovery := RestoreOptions{BackupID: opts.BackupID, TargetImmediate: true}
+	if err := s.createRecoveryConfig(logger, dataDir, pgVersion, recovery); err != nil {
+		return fail(LOC_TESTRESTORE_EXTRACT, "failed to create recovery config: %v", err)
+	}
+
+	// 3. Recover it and run the smoke query
+	var output string
+	if opts.Image != "" {
+		var loc string
+		output, loc, err = s.testRestoreInContainer(ctx, logger, dataDir, opts, result)
+		if err != nil {
+			return fail(loc, "%v", err)
+		}
+	} else {
+		validation, err := s.ValidateRestore(ctx, logger, dataDir, ValidateOptions{
+			Port:    opts.Port,
+			Query:   opts.Query,
+			Timeout: opts.Timeout,
+			BinDir:  opts.BinDir,
+		})
+		if validation != nil {
+			result.RecoveryDuration = validation.RecoveryDuration
+		}
+		if err != nil {
+			return fail(LOC_TESTRESTORE_RUN, "%v", err)
+		}
+		output = validation.QueryResult
+	}
+	result.QueryResult = output
+
+	// 4. Check the result of the smoke query
+	if opts.Expect != "" && output != opts.Expect {
+		return fail(LOC_TESTRESTORE_QUERY, "smoke query returned %q, expected %q", output, opts.Expect)
+	}
+
+	result.Success = true
+	result.Duration = time.Since(started)
+	logger.Info("Restore test passed",
+		"backup_id", opts.BackupID,
+		"pg_version", result.PGVersion,
+		"recovery_duration", result.RecoveryDuration,
+		"duration", result.Duration)
+	return result, nil
+}
+
+// testRestoreInContainer recovers 'dataDir' in a container of opts.Image,
+// runs the smoke query in it and removes the container. It returns the
+// query output, or the location code of the failed step.
+func (s *BackupService) testRestoreInContainer(
+	ctx context.Context,
+	logger *slog.Logger,
+	dataDir string,
+	opts TestRestoreOptions,
+	result *TestRestoreResult) (string, string, error) {
+	name := fmt.Sprintf("pgbackup-testrestore-%d", time.Now().UnixNano())
+	runArgs := []string{"run", "-d", "--name", name,
+		"--network", "none",
+		// The extracted files are ours, so the server runs as us
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"-e", "PGDATA=" + containerDataDir,
+		"-v", dataDir + ":" + containerDataDir,
+	}
+	if s.config.WALArchiveDir != "" {
+		if _, err := os.Stat(s.config.WALArchiveDir); err == nil {
+			runArgs = append(runArgs, "-v", s.config.WALArchiveDir+":"+s.config.WALArchiveDir+":ro")
+		}
+	}
+	runArgs = append(runArgs, opts.Image, "postgres",
+		"-c", "archive_mode=off",
+		"-c", "listen_addresses=",
+		"-c", "unix_socket_directories=/tmp")
+
+	logger.Info("Starting throwaway PostgreSQL container",
+		"runtime", opts.Runtime,
+		"image", opts.Image,
+		"container", name)
+	start := time.Now()
+	if output, err := exec.CommandContext(ctx, opts.Runtime, runArgs...).CombinedOutput(); err != nil {
+		return "", LOC_TESTRESTORE_RUN, fmt.Errorf("failed to start container: %v, output: %s",
+			err, strings.TrimSpace(string(output)))
+	}
+	defer func() {
+		rmCmd := exec.Command(opts.Runtime, "rm", "-f", name)
+		if output, err := rmCmd.CombinedOutput(); err != nil {
+			logger.Error("Failed to remove throwaway PostgreSQL container",
+				"container", name,
+				"error", err,
+				"output", string(output),
+				"loc", LOC_TESTRESTORE_CLEANUP)
+		} else {
+			logger.Info("Removed throwaway PostgreSQL container", "container", name)
+		}
+	}()
+
+	query := func(query string) (string, error) {
+		cmd := exec.CommandContext(ctx, opts.Runtime, "exec",
+			"-e", "PGPASSWORD="+s.config.PGPassword,
+			name, "psql",
+			"-X", "-A", "-t",
+			"-v", "ON_ERROR_STOP=1",
+			"-h", "/tmp",
+			"-U", s.config.PGUser,
+			"-d", s.config.PGDatabase,
+			"-c", query)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("%v, output: %s", err, strings.TrimSpace(string(output)))
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+
+	if err := waitForRecovery(ctx, start, opts.Timeout, query); err != nil {
+		logs, _ := exec.Command(opts.Runtime, "logs", "--tail", "50", name).CombinedOutput()
+		return "", LOC_TESTRESTORE_RUN, fmt.Errorf("%v, log: %s", err, truncateLog(string(logs)))
+	}
+	result.RecoveryDuration = time.Since(start)
+	logger.Info("Recovery finished", "duration", result.RecoveryDuration)
+
+	output, err := query(opts.Query)
+	if err != nil {
+		return "", LOC_TESTRESTORE_QUERY, fmt.Errorf("smoke query failed: %v", err)
+	}
+	return output, "", nil
+}
@@ -0,0 +1,131 @@
+package pgbackup
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeContainerRuntime writes a docker script to a directory. It logs its
+// arguments to 'calls'; exec answers the recovery check with "f" and
+// other queries with "42".
+func fakeContainerRuntime(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/calls"
+if [ "$1" = "exec" ]; then
+  for query; do :; done
+  case "$query" in
+    *pg_is_in_recovery*) echo f ;;
+    *) echo 42 ;;
+  esac
+fi
+`
+	path := filepath.Join(dir, "docker")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTestRestore(t *testing.T) {
+	ctx := context.Background()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	store := NewLocalBackupStore(filepath.Join(t.TempDir(), "base"))
+	if err := store.Write(ctx, "20260102_020000", "base.tar.gz",
+		bytes.NewReader(tarGz(t, map[string]string{"PG_VERSION": "16\n"}))); err != nil {
+		t.Fatal(err)
+	}
+	walDir := t.TempDir()
+	config := &BackupConfig{PGUser: "tester", PGDatabase: "app", WALArchiveDir: walDir,
+		ValidateQuery: "SELECT count(*) FROM pg_catalog.pg_class"}
+	service := NewBackupServiceWithStore(config, nil, store)
+	workDir := t.TempDir()
+
+	t.Run("binaries", func(t *testing.T) {
+		binDir := fakeServerTools(t)
+		result, err := service.TestRestore(ctx, testLogger, TestRestoreOptions{
+			BackupID: "20260102_020000",
+			WorkDir:  workDir,
+			Port:     port,
+			BinDir:   binDir,
+		})
+		if err != nil {
+			t.Fatalf("test restore: %v", err)
+		}
+		if !result.Success || result.PGVersion != "16" || result.Query != config.ValidateQuery {
+			t.Errorf("result = %+v", result)
+		}
+		if conf, _ := os.ReadFile(filepath.Join(binDir, "auto.conf")); !strings.Contains(string(conf), "recovery_target = 'immediate'") {
+			t.Errorf("recovery config = %s", conf)
+		}
+		if queries, _ := os.ReadFile(filepath.Join(binDir, "queries")); !strings.Contains(string(queries), config.ValidateQuery) {
+			t.Errorf("smoke query not run: %s", queries)
+		}
+	})
+
+	t.Run("container", func(t *testing.T) {
+		runtime := fakeContainerRuntime(t)
+		calls := filepath.Join(filepath.Dir(runtime), "calls")
+		opts := TestRestoreOptions{
+			BackupID: "20260102_020000",
+			Query:    "SELECT count(*) FROM users",
+			Expect:   "42",
+			WorkDir:  workDir,
+			Image:    "postgres:16",
+			Runtime:  runtime,
+		}
+		result, err := service.TestRestore(ctx, testLogger, opts)
+		if err != nil {
+			t.Fatalf("test restore: %v", err)
+		}
+		if !result.Success || result.QueryResult != "42" || result.Image != "postgres:16" {
+			t.Errorf("result = %+v", result)
+		}
+		data, _ := os.ReadFile(calls)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if !strings.HasPrefix(lines[0], "run -d --name pgbackup-testrestore-") ||
+			!strings.Contains(lines[0], "--network none") ||
+			!strings.Contains(lines[0], walDir+":"+walDir+":ro") ||
+			!strings.Contains(lines[0], "postgres:16 postgres -c archive_mode=off") {
+			t.Errorf("run = %s", lines[0])
+		}
+		if last := lines[len(lines)-1]; !strings.HasPrefix(last, "rm -f pgbackup-testrestore-") {
+			t.Errorf("container not removed, last call = %s", last)
+		}
+
+		// A smoke query returning something else fails the test, and the
+		// container is still removed
+		os.Remove(calls)
+		opts.Expect = "0"
+		result, err = service.TestRestore(ctx, testLogger, opts)
+		if err == nil || result.Success || !strings.Contains(err.Error(), LOC_TESTRESTORE_QUERY) {
+			t.Errorf("unexpected result: error = %v, result = %+v", err, result)
+		}
+		if data, _ := os.ReadFile(calls); !strings.Contains(string(data), "rm -f") {
+			t.Errorf("container not removed: %s", data)
+		}
+	})
+
+	t.Run("missing backup", func(t *testing.T) {
+		_, err := service.TestRestore(ctx, testLogger, TestRestoreOptions{BackupID: "20260103_020000", WorkDir: workDir})
+		if err == nil || !strings.Contains(err.Error(), LOC_TESTRESTORE_START) {
+			t.Errorf("error = %v", err)
+		}
+	})
+
+	if entries, _ := os.ReadDir(workDir); len(entries) != 0 {
+		t.Errorf("work directories left behind: %v", entries)
+	}
+}
@@ -0,0 +1,241 @@
+package pgbackup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Location codes for post-restore validation
+const (
+	LOC_VALIDATE_START    = "SHD_PGB_080"
+	LOC_VALIDATE_RECOVERY = "SHD_PGB_081"
+	LOC_VALIDATE_QUERY    = "SHD_PGB_082"
+	LOC_VALIDATE_STOP     = "SHD_PGB_083"
+)
+
+// recoveryPollInterval is how often a starting instance is asked whether
+// it is still in recovery
+var recoveryPollInterval = 2 * time.Second
+
+// ValidateOptions configures the post-restore validation. It starts a
+// throwaway PostgreSQL on the restored directory, so it needs the
+// PostgreSQL binaries (pg_ctl, psql) and a free port.
+type ValidateOptions struct {
+	Port    int           // Port for the throwaway instance (default: PG_BACKUP_VALIDATE_PORT)
+	Query   string        // Validation query (default: PG_BACKUP_VALIDATE_QUERY)
+	Timeout time.Duration // How long to wait for recovery to finish (default: 30m)
+	BinDir  string        // Directory of pg_ctl and psql (default: PATH)
+}
+
+// ValidateResult contains the outcome of a post-restore validation
+type ValidateResult struct {
+	Success          bool          `json:"success"`
+	Port             int           `json:"port"`
+	RecoveryDuration time.Duration `json:"recovery_duration"`
+	Query            string        `json:"query"`
+	QueryResult      string        `json:"query_result,omitempty"`
+	ErrorMsg         string        `json:"error_msg,omitempty"`
+}
+
+// ValidateRestore starts a throwaway PostgreSQL on 'dataDir', waits for
+// recovery to finish, runs the validation query and stops the instance.
+//
+// The instance runs with archive_mode off and listens on localhost only,
+// so it does not push WAL into the archive. Recovery happens in place:
+// after a successful validation the restored directory is already
+// recovered and promoted.
+func (s *BackupService) ValidateRestore(
+	ctx context.Context,
+	logger *slog.Logger,
+	dataDir string,
+	opts ValidateOptions) (*ValidateResult, error) {
+	if opts.Port == 0 {
+		opts.Port = s.config.ValidatePort
+	}
+	if opts.Query == "" {
+		opts.Query = s.config.ValidateQuery
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Minute
+	}
+	result := &ValidateResult{Port: opts.Port, Query: opts.Query}
+
+	fail := func(loc string, format string, args ...interface{}) (*ValidateResult, error) {
+		result.Success = false
+		result.ErrorMsg = fmt.Sprintf(format, args...)
+		logger.Error("Restore validation failed", "error", result.ErrorMsg)
+		return result, fmt.Errorf("%s (%s)", result.ErrorMsg, loc)
+	}
+
+	inst, loc, err := s.startThrowaway(ctx, logger, dataDir, opts)
+	if err != nil {
+		return fail(loc, "%v", err)
+	}
+	defer inst.stop(logger)
+	result.RecoveryDuration = inst.recoveryDuration
+
+	output, err := s.validationQuery(ctx, opts, inst.socketDir, opts.Query)
+	if err != nil {
+		return fail(LOC_VALIDATE_QUERY, "validation query failed: %v", err)
+	}
+	result.QueryResult = output
+	result.Success = true
+
+	logger.Info("Restore validation passed",
+		"recovery_duration", result.RecoveryDuration,
+		"query", opts.Query,
+		"result", output)
+	return result, nil
+}
+
+// throwawayInstance is a PostgreSQL started by startThrowaway on a
+// restored data directory
+type throwawayInstance struct {
+	dataDir          string
+	socketDir        string
+	pgCtl            string
+	recoveryDuration time.Duration
+}
+
+// startThrowaway starts a PostgreSQL on 'dataDir' (archive_mode off,
+// localhost and a private socket directory only) and waits for recovery
+// to finish. On error it returns the location code of the failed step;
+// otherwise the caller stops the instance with stop.
+func (s *BackupService) startThrowaway(
+	ctx context.Context,
+	logger *slog.Logger,
+	dataDir string,
+	opts ValidateOptions) (*throwawayInstance, string, error) {
+	// 1. The port must be free
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", opts.Port))
+	if err != nil {
+		return nil, LOC_VALIDATE_START, fmt.Errorf("port %d is not free: %v", opts.Port, err)
+	}
+	ln.Close()
+
+	socketDir, err := os.MkdirTemp("", "pgbackup-validate-")
+	if err != nil {
+		return nil, LOC_VALIDATE_START, fmt.Errorf("failed to create socket directory: %v", err)
+	}
+	inst := &throwawayInstance{
+		dataDir:   dataDir,
+		socketDir: socketDir,
+		pgCtl:     s.pgBinary(opts.BinDir, "pg_ctl"),
+	}
+
+	// 2. Start the throwaway instance
+	serverOpts := fmt.Sprintf("-c port=%d -c listen_addresses=localhost -c unix_socket_directories=%s -c archive_mode=off",
+		opts.Port, socketDir)
+	logger.Info("Starting throwaway PostgreSQL",
+		"data_dir", dataDir,
+		"port", opts.Port)
+
+	start := time.Now()
+	startCmd := exec.CommandContext(ctx, inst.pgCtl, "start",
+		"-D", dataDir,
+		"-w", "-t", fmt.Sprintf("%d", int(opts.Timeout.Seconds())),
+		"-l", filepath.Join(socketDir, "postgres.log"),
+		"-o", serverOpts)
+	if output, err := startCmd.CombinedOutput(); err != nil {
+		serverLog, _ := os.ReadFile(filepath.Join(socketDir, "postgres.log"))
+		os.RemoveAll(socketDir)
+		return nil, LOC_VALIDATE_START, fmt.Errorf("failed to start PostgreSQL: %v, output: %s, log: %s",
+			err, strings.TrimSpace(string(output)), truncateLog(string(serverLog)))
+	}
+
+	// 3. Wait for recovery to finish
+	err = waitForRecovery(ctx, start, opts.Timeout, func(query string) (string, error) {
+		return s.validationQuery(ctx, opts, socketDir, query)
+	})
+	if err != nil {
+		inst.stop(logger)
+		return nil, LOC_VALIDATE_RECOVERY, err
+	}
+	inst.recoveryDuration = time.Since(start)
+	logger.Info("Recovery finished", "duration", inst.recoveryDuration)
+	return inst, "", nil
+}
+
+// waitForRecovery polls an instance started at 'start' with 'query'
+// until it is out of recovery, for at most 'timeout'
+func waitForRecovery(ctx context.Context, start time.Time, timeout time.Duration, query func(string) (string, error)) error {
+	deadline := start.Add(timeout)
+	for {
+		inRecovery, err := query("SELECT pg_is_in_recovery()")
+		if err == nil && inRecovery == "f" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("recovery did not finish within %s (last error: %v)", timeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cancelled while waiting for recovery: %v", ctx.Err())
+		case <-time.After(recoveryPollInterval):
+		}
+	}
+}
+
+// stop shuts the instance down and removes its socket directory
+func (inst *throwawayInstance) stop(logger *slog.Logger) {
+	defer os.RemoveAll(inst.socketDir)
+	stopCmd := exec.Command(inst.pgCtl, "stop", "-D", inst.dataDir, "-m", "fast", "-w")
+	if output, err := stopCmd.CombinedOutput(); err != nil {
+		logger.Error("Failed to stop throwaway PostgreSQL",
+			"error", err,
+			"output", string(output),
+			"loc", LOC_VALIDATE_STOP)
+	} else {
+		logger.Info("Stopped throwaway PostgreSQL")
+	}
+}
+
+// validationQuery runs 'query' on the throwaway instance and returns the
+// unaligned, tuples-only output.
+func (s *BackupService) validationQuery(
+	ctx context.Context,
+	opts ValidateOptions,
+	socketDir string,
+	query string) (string, error) {
+	cmd := exec.CommandContext(ctx, s.pgBinary(opts.BinDir, "psql"),
+		"-X", "-A", "-t",
+		"-v", "ON_ERROR_STOP=1",
+		"-h", socketDir,
+		"-p", fmt.Sprintf("%d", opts.Port),
+		"-U", s.config.PGUser,
+		"-d", s.config.PGDatabase,
+		"-c", query)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", s.config.PGPassword))
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// pgBinary returns the path of a PostgreSQL binary in 'binDir', or its
+// name (resolved through PATH) if binDir is empty.
+func (s *BackupService) pgBinary(binDir string, name string) string {
+	if binDir == "" {
+		return name
+	}
+	return filepath.Join(binDir, name)
+}
+
+// truncateLog keeps the tail of a server log for error messages
+func truncateLog(log string) string {
+	const max = 2000
+	log = strings.TrimSpace(log)
+	if len(log) <= max {
+		return log
+	}
+	return "..." + log[len(log)-max:]
+}
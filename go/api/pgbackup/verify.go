@@ -1,13 +1,17 @@
 package pgbackup
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Location codes for verify operations
@@ -15,8 +19,35 @@ const (
 	LOC_VERIFY_START = "SHD_PGB_060"
 	LOC_VERIFY_TAR   = "SHD_PGB_061"
 	LOC_VERIFY_WAL   = "SHD_PGB_062"
+	LOC_VERIFY_SAVE  = "SHD_PGB_063"
 )
 
+// verifyRecordName is the file of a backup holding its VerifyRecord
+const verifyRecordName = "pgbackup_verify.json"
+
+// Verification status of a backup, as shown by 'pgbackup list'
+const (
+	VerifyStatusVerified   = "VERIFIED"   // Last verification passed recently
+	VerifyStatusStale      = "STALE"      // Last verification passed too long ago
+	VerifyStatusFailed     = "FAILED"     // Last verification found issues
+	VerifyStatusUnverified = "UNVERIFIED" // Never verified
+)
+
+// VerifyRecord is the outcome of the last verification of a backup. Verify
+// stores it next to the backup manifest so listing backups doesn't have to
+// verify them again.
+type VerifyRecord struct {
+	VerifiedAt time.Time `json:"verified_at"`
+	Success    bool      `json:"success"`
+	Issues     []string  `json:"issues,omitempty"`
+}
+
+// VerifyOptions configures VerifyAllWithOptions
+type VerifyOptions struct {
+	Label          string // Only verify backups with this label
+	OnlyUnverified bool   // Skip backups whose status is VerifyStatusVerified
+}
+
 // VerifyResult contains information about a verification operation
 type VerifyResult struct {
 	BackupID      string   `json:"backup_id"`
@@ -36,35 +67,30 @@ func (s *BackupService) Verify(ctx context.Context, logger *slog.Logger, backupI
 
 	// If no backup ID specified, verify the latest backup
 	if backupID == "" {
-		backups, err := s.ListBackups()
+		latest, err := s.LatestBackup("")
 		if err != nil {
-			return nil, fmt.Errorf("failed to list backups: %w (%s)", err, LOC_VERIFY_START)
-		}
-		if len(backups) == 0 {
-			return nil, fmt.Errorf("no backups found (%s)", LOC_VERIFY_START)
-		}
-
-		// Find the latest backup
-		var latest *BackupResult
-		for _, b := range backups {
-			if latest == nil || b.StartTime.After(latest.StartTime) {
-				latest = b
-			}
+			return nil, fmt.Errorf("%w (%s)", err, LOC_VERIFY_START)
 		}
 		backupID = latest.BackupID
 		result.BackupID = backupID
 	}
 
-	backupPath := filepath.Join(s.config.BaseBackupDir, backupID)
-	logger.Info("Verifying backup", "backup_id", backupID, "path", backupPath)
+	if err := validateObjectName("backup ID", backupID); err != nil {
+		return nil, err
+	}
+	logger.Info("Verifying backup", "backup_id", backupID, "path", s.store.Location(backupID))
 
-	// Check backup directory exists
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+	// Check backup exists
+	objects, err := s.store.List(ctx, backupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup files: %w (%s)", err, LOC_VERIFY_START)
+	}
+	if len(objects) == 0 {
 		return nil, fmt.Errorf("backup not found: %s (%s)", backupID, LOC_VERIFY_START)
 	}
 
 	// 1. Verify tar files
-	tarFilesOK, tarFiles, tarIssues := s.verifyTarFiles(ctx, logger, backupPath)
+	tarFilesOK, tarFiles, tarIssues := s.verifyTarFiles(ctx, logger, backupID, objects)
 	result.TarFiles = tarFiles
 	result.TarFilesOK = tarFilesOK
 	result.Issues = append(result.Issues, tarIssues...)
@@ -85,30 +111,80 @@ func (s *BackupService) Verify(ctx context.Context, logger *slog.Logger, backupI
 			"issues", len(result.Issues))
 	}
 
+	// The backup was verified either way; failing to record it is not
+	// a verification failure
+	record := &VerifyRecord{
+		VerifiedAt: time.Now().UTC(),
+		Success:    result.Success,
+		Issues:     result.Issues,
+	}
+	if err := s.writeVerifyRecord(ctx, backupID, record); err != nil {
+		logger.Warn("Failed to record verification result", "backup_id", backupID, "error", err)
+	}
+
 	return result, nil
 }
 
-// verifyTarFiles checks the integrity of tar.gz files in the backup
-func (s *BackupService) verifyTarFiles(ctx context.Context, logger *slog.Logger, backupPath string) (bool, []string, []string) {
-	entries, err := os.ReadDir(backupPath)
+// writeVerifyRecord stores the VerifyRecord of a backup
+func (s *BackupService) writeVerifyRecord(ctx context.Context, backupID string, record *VerifyRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal verification record: %w (%s)", err, LOC_VERIFY_SAVE)
+	}
+	if err := s.store.Write(ctx, backupID, verifyRecordName, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write verification record: %w (%s)", err, LOC_VERIFY_SAVE)
+	}
+	return nil
+}
+
+// readVerifyRecord reads the VerifyRecord of a backup
+func (s *BackupService) readVerifyRecord(ctx context.Context, backupID string) (*VerifyRecord, error) {
+	r, err := s.store.Open(ctx, backupID, verifyRecordName)
 	if err != nil {
-		return false, nil, []string{fmt.Sprintf("failed to read backup directory: %v", err)}
+		return nil, err
 	}
+	defer r.Close()
 
+	var record VerifyRecord
+	if err := json.NewDecoder(r).Decode(&record); err != nil {
+		return nil, fmt.Errorf("failed to parse verification record: %w", err)
+	}
+	return &record, nil
+}
+
+// VerificationStatus returns the verification status of a backup at 'now':
+// one of VerifyStatusVerified, VerifyStatusStale, VerifyStatusFailed or
+// VerifyStatusUnverified
+func (s *BackupService) VerificationStatus(backup *BackupResult, now time.Time) string {
+	record := backup.LastVerify
+	switch {
+	case record == nil:
+		return VerifyStatusUnverified
+	case !record.Success:
+		return VerifyStatusFailed
+	case s.config.VerifyMaxAgeDays > 0 &&
+		now.Sub(record.VerifiedAt) > time.Duration(s.config.VerifyMaxAgeDays)*24*time.Hour:
+		return VerifyStatusStale
+	default:
+		return VerifyStatusVerified
+	}
+}
+
+// verifyTarFiles checks the integrity of the tar.gz files of a backup
+func (s *BackupService) verifyTarFiles(ctx context.Context, logger *slog.Logger, backupID string, objects []BackupObject) (bool, []string, []string) {
 	var tarFiles []string
 	var issues []string
 	allOK := true
 
-	for _, entry := range entries {
-		name := entry.Name()
+	for _, obj := range objects {
+		name := obj.Name
 		if !strings.HasSuffix(name, ".tar.gz") && !strings.HasSuffix(name, ".tar") {
 			continue
 		}
 
-		tarPath := filepath.Join(backupPath, name)
 		tarFiles = append(tarFiles, name)
 
-		if err := s.verifyTarGz(ctx, tarPath); err != nil {
+		if err := s.verifyTarGz(ctx, backupID, name); err != nil {
 			issues = append(issues, fmt.Sprintf("corrupt tar file %s: %v", name, err))
 			allOK = false
 			logger.Error("Tar file verification failed", "file", name, "error", err)
@@ -138,28 +214,45 @@ func (s *BackupService) verifyTarFiles(ctx context.Context, logger *slog.Logger,
 	return allOK, tarFiles, issues
 }
 
-// verifyTarGz tests a tar.gz file for integrity
-func (s *BackupService) verifyTarGz(ctx context.Context, tarPath string) error {
-	// Use gzip -t to test compressed files
-	if strings.HasSuffix(tarPath, ".gz") {
-		cmd := exec.CommandContext(ctx, "gzip", "-t", tarPath)
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("gzip test failed: %v, output: %s (%s)",
-				err, strings.TrimSpace(string(output)), LOC_VERIFY_TAR)
+// verifyTarGz reads a tar or tar.gz file of a backup from the store to the
+// end, which checks the gzip checksum and the tar structure
+func (s *BackupService) verifyTarGz(ctx context.Context, backupID, name string) error {
+	f, err := s.store.Open(ctx, backupID, name)
+	if err != nil {
+		return fmt.Errorf("%w (%s)", err, LOC_VERIFY_TAR)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("gzip test failed: %v (%s)", err, LOC_VERIFY_TAR)
 		}
+		defer gz.Close()
+		r = gz
 	}
 
-	// Use tar -tf to list contents (verifies tar structure)
-	var cmd *exec.Cmd
-	if strings.HasSuffix(tarPath, ".gz") {
-		cmd = exec.CommandContext(ctx, "tar", "-tzf", tarPath)
-	} else {
-		cmd = exec.CommandContext(ctx, "tar", "-tf", tarPath)
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, tr)
+		}
+		if err != nil {
+			return fmt.Errorf("tar test failed: %v (%s)", err, LOC_VERIFY_TAR)
+		}
 	}
 
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("tar test failed: %v, output: %s (%s)",
-			err, strings.TrimSpace(string(output)), LOC_VERIFY_TAR)
+	// Trailing data after the end of the archive must still decompress
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return fmt.Errorf("gzip test failed: %v (%s)", err, LOC_VERIFY_TAR)
 	}
 
 	return nil
@@ -215,15 +308,28 @@ func (s *BackupService) verifyWALContinuity(_ context.Context, logger *slog.Logg
 	return true, issues
 }
 
-// VerifyAll verifies all available backups
-func (s *BackupService) VerifyAll(ctx context.Context, logger *slog.Logger) ([]*VerifyResult, error) {
+// VerifyAll verifies all available backups, or only those with the
+// given label if label is not empty
+func (s *BackupService) VerifyAll(ctx context.Context, logger *slog.Logger, label string) ([]*VerifyResult, error) {
+	return s.VerifyAllWithOptions(ctx, logger, VerifyOptions{Label: label})
+}
+
+// VerifyAllWithOptions is VerifyAll with options, e.g. to only verify
+// the backups that were never verified, failed or went stale
+func (s *BackupService) VerifyAllWithOptions(ctx context.Context, logger *slog.Logger, opts VerifyOptions) ([]*VerifyResult, error) {
 	backups, err := s.ListBackups()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list backups: %w", err)
 	}
+	backups = FilterByLabel(backups, opts.Label)
 
+	now := time.Now()
 	var results []*VerifyResult
 	for _, backup := range backups {
+		if opts.OnlyUnverified && s.VerificationStatus(backup, now) == VerifyStatusVerified {
+			logger.Info("Skipping verified backup", "backup_id", backup.BackupID)
+			continue
+		}
 		result, err := s.Verify(ctx, logger, backup.BackupID)
 		if err != nil {
 			logger.Warn("Verification failed for backup",
@@ -0,0 +1,108 @@
+package pgbackup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestVerifyRecordsResult checks that Verify stores its result with the
+// backup, that listing reads it back as the verification status, and
+// that --only-unverified skips the verified backups.
+func TestVerifyRecordsResult(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "000000010000000000000010"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := NewLocalBackupStore(t.TempDir())
+	config := &BackupConfig{WALArchiveDir: walDir, VerifyMaxAgeDays: 7}
+	service := NewBackupServiceWithStore(config, nil, store)
+
+	archive := tarGz(t, map[string]string{"PG_VERSION": "16\n"})
+	for _, id := range []string{"20260110_020000", "20260111_020000"} {
+		if err := store.Write(ctx, id, "base.tar.gz", bytes.NewReader(archive)); err != nil {
+			t.Fatal(err)
+		}
+		if err := service.writeBackupManifest(ctx, &BackupResult{BackupID: id, StartTime: time.Now(), Success: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the gzip header: corrupt
+	if err := store.Write(ctx, "20260112_020000", "base.tar.gz", bytes.NewReader(archive[:10])); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := func() map[string]string {
+		t.Helper()
+		backups, err := service.ListBackups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]string{}
+		for _, b := range backups {
+			got[b.BackupID] = service.VerificationStatus(b, time.Now())
+		}
+		return got
+	}
+
+	if got := statuses(); got["20260110_020000"] != VerifyStatusUnverified {
+		t.Fatalf("before verify: %v", got)
+	}
+
+	if result, err := service.Verify(ctx, logger, "20260110_020000"); err != nil || !result.Success {
+		t.Fatalf("verify: %+v, %v", result, err)
+	}
+	if result, err := service.Verify(ctx, logger, "20260112_020000"); err != nil || result.Success {
+		t.Fatalf("verify corrupt backup: %+v, %v", result, err)
+	}
+
+	got := statuses()
+	want := map[string]string{
+		"20260110_020000": VerifyStatusVerified,
+		"20260111_020000": VerifyStatusUnverified,
+		"20260112_020000": VerifyStatusFailed,
+	}
+	for id, status := range want {
+		if got[id] != status {
+			t.Errorf("%s: status = %q, want %q", id, got[id], status)
+		}
+	}
+
+	// The record doesn't make a backup without manifest look newer
+	backup, err := service.GetBackup("20260112_020000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.SizeBytes != 10 {
+		t.Errorf("size of backup without manifest = %d, want 10", backup.SizeBytes)
+	}
+	if status := service.VerificationStatus(backup, time.Now().AddDate(0, 0, 8)); status != VerifyStatusFailed {
+		t.Errorf("failed backup later: status = %q", status)
+	}
+	backup, err = service.GetBackup("20260110_020000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := service.VerificationStatus(backup, time.Now().AddDate(0, 0, 8)); status != VerifyStatusStale {
+		t.Errorf("verified backup 8 days later: status = %q", status)
+	}
+
+	results, err := service.VerifyAllWithOptions(ctx, logger, VerifyOptions{OnlyUnverified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verified []string
+	for _, result := range results {
+		verified = append(verified, result.BackupID)
+	}
+	if len(verified) != 2 || verified[0] != "20260111_020000" || verified[1] != "20260112_020000" {
+		t.Errorf("only unverified: verified %v", verified)
+	}
+}
@@ -0,0 +1,205 @@
+package pgbackup
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Location codes for WAL cleanup
+const (
+	LOC_WAL_CLEANUP_LABEL = "SHD_PGB_129"
+	LOC_WAL_CLEANUP_START = "SHD_PGB_130"
+)
+
+// walArchivePattern matches the files pg_archivecleanup may remove: WAL
+// segments, partial segments and backup history files, as the archive
+// script writes them (gzipped) or uncompressed. Timeline history files
+// (*.history) are always kept.
+var walArchivePattern = regexp.MustCompile(`^([0-9A-F]{24})(\.partial|\.[0-9A-F]{8}\.backup)?(\.gz)?$`)
+
+// startWALPattern is the line of backup_label naming the first WAL
+// segment a base backup needs
+var startWALPattern = regexp.MustCompile(`^START WAL LOCATION: \S+ \(file ([0-9A-F]{24})\)`)
+
+// parseBackupLabel returns the start WAL segment named in a backup_label
+func parseBackupLabel(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if m := startWALPattern.FindStringSubmatch(scanner.Text()); m != nil {
+			return m[1], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("backup_label has no START WAL LOCATION (%s)", LOC_WAL_CLEANUP_LABEL)
+}
+
+// readStartWAL returns the start WAL segment of a base backup from the
+// backup_label in its base.tar.gz (or base.tar)
+func readStartWAL(r io.Reader, gzipped bool) (string, error) {
+	if gzipped {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return "", fmt.Errorf("failed to read base archive: %w (%s)", err, LOC_WAL_CLEANUP_LABEL)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return "", fmt.Errorf("base archive has no backup_label (%s)", LOC_WAL_CLEANUP_LABEL)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read base archive: %w (%s)", err, LOC_WAL_CLEANUP_LABEL)
+		}
+		if path.Clean(hdr.Name) == "backup_label" {
+			return parseBackupLabel(tr)
+		}
+	}
+}
+
+// readStartWALFile is readStartWAL of a base archive on disk
+func readStartWALFile(archivePath string) (string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open base archive: %w (%s)", err, LOC_WAL_CLEANUP_LABEL)
+	}
+	defer f.Close()
+	return readStartWAL(f, strings.HasSuffix(archivePath, ".gz"))
+}
+
+// backupStartWAL returns the start WAL segment of a backup: from its
+// manifest, or for backups taken before it was recorded, from the
+// backup_label in the store
+func (s *BackupService) backupStartWAL(ctx context.Context, backup *BackupResult) (string, error) {
+	if backup.WALStart != "" {
+		return backup.WALStart, nil
+	}
+	for _, name := range []string{"base.tar.gz", "base.tar"} {
+		f, err := s.store.Open(ctx, backup.BackupID, name)
+		if err != nil {
+			continue
+		}
+		seg, err := readStartWAL(f, strings.HasSuffix(name, ".gz"))
+		f.Close()
+		return seg, err
+	}
+	return "", fmt.Errorf("backup %s has no base archive (%s)", backup.BackupID, LOC_WAL_CLEANUP_START)
+}
+
+// walCleanupSegment returns the start WAL segment of the oldest of the
+// retained backups. WAL before it is not needed by any of them.
+func (s *BackupService) walCleanupSegment(ctx context.Context, retainedBackups []string) (string, error) {
+	oldest := ""
+	for _, backupID := range retainedBackups {
+		backup, err := s.GetBackup(backupID)
+		if err != nil {
+			return "", fmt.Errorf("failed to read backup %s: %w (%s)", backupID, err, LOC_WAL_CLEANUP_START)
+		}
+		seg, err := s.backupStartWAL(ctx, backup)
+		if err != nil {
+			return "", fmt.Errorf("start WAL of backup %s unknown: %w", backupID, err)
+		}
+		// As pg_archivecleanup, compare without the timeline
+		if oldest == "" || seg[8:] < oldest[8:] {
+			oldest = seg
+		}
+	}
+	return oldest, nil
+}
+
+// orphanedWALFiles returns the names in the WAL archive that precede
+// 'cleanupSegment' the way pg_archivecleanup decides it: the log and
+// segment numbers (the name without its timeline) sort before those of
+// cleanupSegment. Files modified after 'keepAfter' are kept regardless.
+func orphanedWALFiles(entries []os.DirEntry, cleanupSegment string, keepAfter time.Time) []string {
+	var orphaned []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		m := walArchivePattern.FindStringSubmatch(entry.Name())
+		if m == nil || m[1][8:] >= cleanupSegment[8:] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(keepAfter) {
+			continue
+		}
+		orphaned = append(orphaned, entry.Name())
+	}
+	sort.Strings(orphaned)
+	return orphaned
+}
+
+// cleanOldWALFiles removes the WAL files no retained backup needs: those
+// before the start WAL of the oldest retained backup and older than
+// RetainWALDays. If the start WAL of any retained backup is unknown, or
+// no backup is retained, nothing is removed. With dryRun the files are
+// only listed.
+func (s *BackupService) cleanOldWALFiles(
+	ctx context.Context,
+	logger *slog.Logger,
+	retainedBackups []string,
+	dryRun bool) ([]string, int64, error) {
+	entries, err := os.ReadDir(s.config.WALArchiveDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed to read WAL archive: %w (%s)", err, LOC_RETENTION_WAL)
+	}
+
+	if len(retainedBackups) == 0 {
+		logger.Info("No retained backup, keeping all WAL files")
+		return nil, 0, nil
+	}
+	cleanupSegment, err := s.walCleanupSegment(ctx, retainedBackups)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keepAfter := time.Now().AddDate(0, 0, -s.config.RetainWALDays)
+	orphaned := orphanedWALFiles(entries, cleanupSegment, keepAfter)
+	logger.Info("WAL cleanup",
+		"oldest_needed_segment", cleanupSegment,
+		"orphaned_files", len(orphaned),
+		"dry_run", dryRun)
+
+	var deleted []string
+	var freedBytes int64
+	for _, name := range orphaned {
+		walPath := filepath.Join(s.config.WALArchiveDir, name)
+		info, err := os.Stat(walPath)
+		if err != nil {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(walPath); err != nil {
+				logger.Warn("Failed to delete WAL file", "file", name, "error", err)
+				continue
+			}
+			logger.Info("Deleted orphaned WAL file", "file", name)
+		}
+		deleted = append(deleted, name)
+		freedBytes += info.Size()
+	}
+
+	return deleted, freedBytes, nil
+}
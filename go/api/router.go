@@ -1,8 +1,10 @@
 package api
 
 import (
+	"net/http"
 	"os"
 
+	"github.com/chendingplano/shared/go/api/EchoFactory"
 	"github.com/chendingplano/shared/go/api/RequestHandlers"
 	"github.com/chendingplano/shared/go/api/auth"
 	"github.com/chendingplano/shared/go/api/loggerutil"
@@ -32,40 +34,40 @@ func RegisterRoutes(e *echo.Echo) {
 		// Note: With Kratos OIDC, the callback is handled by Kratos internally
 		// at /self-service/methods/oidc/callback/google
 		// We still register the legacy callback route for backwards compatibility
-		e.GET("/auth/google/callback", func(c echo.Context) error {
-			return auth.HandleGoogleCallback(c)
-		})
+		EchoFactory.RegisterRoute(e, http.MethodGet, "/auth/google/callback", auth.GoogleCallback)
 	} else {
-		e.GET("/auth/google/login", func(c echo.Context) error {
-			return auth.HandleGoogleLogin(c)
-		})
-		e.GET("/auth/google/callback", func(c echo.Context) error {
-			return auth.HandleGoogleCallback(c)
-		})
+		EchoFactory.RegisterRoute(e, http.MethodGet, "/auth/google/login", auth.GoogleLogin)
+		EchoFactory.RegisterRoute(e, http.MethodGet, "/auth/google/callback", auth.GoogleCallback)
 	}
 
 	logger.Info("Register /auth/github/login route")
-	e.GET("/auth/github/login", func(c echo.Context) error {
-		return auth.HandleGitHubLogin(c)
-	})
+	EchoFactory.RegisterRoute(e, http.MethodGet, "/auth/github/login", auth.GitHubLogin)
 
 	logger.Info("Register /auth/github/callback route")
-	e.GET("/auth/github/callback", func(c echo.Context) error {
-		return auth.HandleGitHubCallback(c)
-	})
+	EchoFactory.RegisterRoute(e, http.MethodGet, "/auth/github/callback", auth.GitHubCallback)
 
 	// Email auth
-	emailLogin := echo.HandlerFunc(auth.HandleEmailLogin)
-	emailSignup := echo.HandlerFunc(auth.HandleEmailSignup)
-	authMe := echo.HandlerFunc(auth.HandleAuthMe)
 	if useKratos {
-		emailLogin = auth.HandleEmailLoginKratos
-		emailSignup = auth.HandleEmailSignupKratos
-		authMe = auth.HandleAuthMeKratos
+		e.POST("/auth/email/login", auth.HandleEmailLoginKratos)
+		e.POST("/auth/email/signup", auth.HandleEmailSignupKratos)
+		e.GET("/auth/me", auth.HandleAuthMeKratos)
+	} else {
+		EchoFactory.RegisterRoute(e, http.MethodPost, "/auth/email/login", auth.EmailLogin)
+		EchoFactory.RegisterRoute(e, http.MethodPost, "/auth/email/signup", auth.EmailSignup)
+		EchoFactory.RegisterRoute(e, http.MethodGet, "/auth/me", auth.AuthMe)
+		EchoFactory.RegisterRoute(e, http.MethodPost, "/auth/logout", auth.Logout)
+
+		// TOTP two-factor (Kratos handles 2FA through its own flows)
+		EchoFactory.RegisterRoute(e, http.MethodPost, "/auth/2fa/setup", auth.TwoFactorSetup)
+		EchoFactory.RegisterRoute(e, http.MethodPost, "/auth/2fa/confirm", auth.TwoFactorConfirm)
+		EchoFactory.RegisterRoute(e, http.MethodPost, "/auth/2fa/verify", auth.TwoFactorVerify)
+		EchoFactory.RegisterRoute(e, http.MethodPost, "/auth/2fa/reset", auth.TwoFactorReset)
+
+		// Account overrides for support staff (admin only). With Kratos,
+		// accounts are managed in Kratos.
+		EchoFactory.RegisterRoute(e, http.MethodPost, "/auth/admin/verify-user", auth.AdminVerifyUser)
+		EchoFactory.RegisterRoute(e, http.MethodPost, "/auth/admin/reset-password", auth.AdminResetPassword)
 	}
-	e.POST("/auth/email/login", emailLogin)
-	e.POST("/auth/email/signup", emailSignup)
-	e.GET("/auth/me", authMe)
 
 	// Kratos-only routes
 	if useKratos {
@@ -80,15 +82,34 @@ func RegisterRoutes(e *echo.Echo) {
 
 	// Shared API
 	e.POST("/shared_api/v1/jimo_req", RequestHandlers.HandleJimoRequestEcho)
+	e.POST("/shared_api/v1/jimo_export_csv", RequestHandlers.HandleDBExportCSVEcho)
+
+	// Jimo resource definitions (admin)
+	EchoFactory.RegisterRoute(e, http.MethodGet, "/shared_api/v1/resources", RequestHandlers.ListResources)
+	EchoFactory.RegisterRoute(e, http.MethodPost, "/shared_api/v1/resources", RequestHandlers.SaveResource)
+	EchoFactory.RegisterRoute(e, http.MethodDelete, "/shared_api/v1/resources", RequestHandlers.DeleteResource)
+
+	// Jimo quotas usage
+	EchoFactory.RegisterRoute(e, http.MethodGet, "/shared_api/v1/usage/me", RequestHandlers.GetMyUsage)
+	EchoFactory.RegisterRoute(e, http.MethodGet, "/shared_api/v1/usage/report", RequestHandlers.GetUsageReport)
 
 	// Icon service
 	e.GET("/shared_api/v1/icons", RequestHandlers.HandleListIcons)
 	e.GET("/shared_api/v1/icons/categories", RequestHandlers.HandleGetCategories)
 	e.GET("/shared_api/v1/icons/:id", RequestHandlers.HandleGetIcon)
+	e.GET("/shared_api/v1/icons/:id/thumbnail", RequestHandlers.HandleIconThumbnail)
 	e.POST("/shared_api/v1/icons", RequestHandlers.HandleUploadIcon)
 	e.DELETE("/shared_api/v1/icons/:id", RequestHandlers.HandleDeleteIcon)
 	e.GET("/shared_api/v1/icons/file/:category/:filename", RequestHandlers.HandleServeIconFile)
 
+	// Attachments
+	EchoFactory.RegisterRoute(e, http.MethodGet, "/shared_api/v1/attachments", RequestHandlers.ListAttachments)
+	EchoFactory.RegisterRoute(e, http.MethodPost, "/shared_api/v1/attachments", RequestHandlers.UploadAttachment)
+	EchoFactory.RegisterRoute(e, http.MethodPost, "/shared_api/v1/attachments/sweep", RequestHandlers.SweepAttachments)
+	EchoFactory.RegisterRoute(e, http.MethodGet, "/shared_api/v1/attachments/:id", RequestHandlers.GetAttachment)
+	EchoFactory.RegisterRoute(e, http.MethodGet, "/shared_api/v1/attachments/:id/download", RequestHandlers.DownloadAttachment)
+	EchoFactory.RegisterRoute(e, http.MethodDelete, "/shared_api/v1/attachments/:id", RequestHandlers.DeleteAttachment)
+
 	// IP geolocation service (ip66.dev MMDB)
 	e.GET("/shared_api/v1/ipdb/lookup", RequestHandlers.HandleIPLookup)
 	e.GET("/shared_api/v1/ipdb/sync/status", RequestHandlers.HandleIPSyncStatus)
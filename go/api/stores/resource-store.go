@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -48,16 +49,71 @@ func InitResourceStore(db_type string,
 // Public API
 // GetResourceDef retrieves the resource def by resource_name and resource_opr.
 // If not found, it returns error.
+// Deleted and suspended resources are treated as not found.
 func GetResourceDef(resource_name string, resource_opr string) (ApiTypes.ResourceStoreDef, error) {
+	var resource_def ApiTypes.ResourceStoreDef
+	if resource_store_singleton == nil {
+		return resource_def, fmt.Errorf("resource store not initialized (SHD_RST_053)")
+	}
+
 	key := resource_name + "_" + resource_opr
+	resource_store_singleton.mu.Lock()
 	resource_def, exists := resource_store_singleton.resource_map[key]
-	if exists {
+	resource_store_singleton.mu.Unlock()
+	if exists && isResourceActive(resource_def.ResourceDef.ResourceStatus) {
 		return resource_def, nil
 	}
 	return resource_def, fmt.Errorf("resource not exist, resource_name:%s, resource_opr:%s (SHD_RST_055)",
 		resource_name, resource_opr)
 }
 
+// Public API
+// ReloadResourceStore reloads all resources from the database. It is called
+// after a resource definition is saved or deleted.
+func ReloadResourceStore() error {
+	if resource_store_singleton == nil {
+		return fmt.Errorf("resource store not initialized (SHD_RST_074)")
+	}
+	resource_store_singleton.LoadResourcesFromDB()
+	return nil
+}
+
+// Public API
+// BuildResourceStoreDef parses the field defs, selected fields and on-conflict
+// columns from row.ResourceJSON. It is used to check a definition before it
+// is saved; unlike LoadResourcesFromDB, it returns the first parse error.
+func BuildResourceStoreDef(
+	logger ApiTypes.JimoLogger,
+	row ApiTypes.ResourceDef) (ApiTypes.ResourceStoreDef, error) {
+	var c_row ApiTypes.ResourceStoreDef
+	c_row.ResourceDef = row
+	if row.ResourceJSON == nil {
+		return c_row, fmt.Errorf("missing resource_def, resource_name:%s (SHD_RST_089)", row.ResourceName)
+	}
+
+	field_defs, err := ConstructFieldDefs(logger, row.ResourceJSON, row.ResourceName)
+	if err != nil {
+		return c_row, err
+	}
+	c_row.FieldDefs = field_defs
+
+	selected_defs, err := ConstructSelectedFields(logger, row.ResourceJSON, row.ResourceName)
+	if err != nil {
+		return c_row, err
+	}
+	c_row.SelectedFields = selected_defs
+
+	if err := constructOnConflictCols(row.ResourceJSON, row.ResourceName, &c_row); err != nil {
+		return c_row, err
+	}
+	return c_row, nil
+}
+
+func isResourceActive(resource_status string) bool {
+	return !strings.EqualFold(resource_status, ApiTypes.ResourceStatus_Deleted) &&
+		!strings.EqualFold(resource_status, ApiTypes.ResourceStatus_Suspended)
+}
+
 // Public API
 func StopResourceStore() {
 	resource_store_singleton.StopResourceStore()
@@ -123,7 +179,11 @@ func (c *ResourceStore) LoadResourcesFromDB() {
 
 	defer rows.Close()
 
+	// Build a new map and swap it in, so that readers never see a
+	// partially loaded store.
+	resource_map := make(map[string]ApiTypes.ResourceStoreDef)
 	var resource_def_sql, resource_cond_sql sql.NullString
+	var db_name_sql, table_name_sql sql.NullString
 	var num_resources = 0
 	var resource_name, resource_opr string
 	for rows.Next() {
@@ -133,14 +193,18 @@ func (c *ResourceStore) LoadResourcesFromDB() {
 			&resource_opr,
 			&row.ResourceDesc,
 			&row.ResourceType,
-			&row.DBName,
-			&row.TableName,
+			&db_name_sql,
+			&table_name_sql,
 			&row.ResourceStatus,
 			&resource_def_sql,
 			&resource_cond_sql); err != nil {
 			c.logger.Error("row scan error", "error", err)
 			continue
 		}
+		row.ResourceName = resource_name
+		row.ResourceOpr = resource_opr
+		row.DBName = db_name_sql.String
+		row.TableName = table_name_sql.String
 
 		if resource_cond_sql.Valid {
 			resource_cond_str := resource_cond_sql.String
@@ -157,7 +221,6 @@ func (c *ResourceStore) LoadResourcesFromDB() {
 
 		key := resource_name + "_" + resource_opr
 		var c_row ApiTypes.ResourceStoreDef
-		c_row.ResourceDef = row
 
 		if resource_def_sql.Valid {
 			err := parseResourceDef(c.logger, &row, resource_def_sql)
@@ -228,12 +291,20 @@ func (c *ResourceStore) LoadResourcesFromDB() {
 
 					c_row.SelectedFields = selected_defs
 				}
+
+				if err := constructOnConflictCols(row.ResourceJSON, resource_name, &c_row); err != nil {
+					c.logger.Error("invalid on-conflict columns",
+						"error", err,
+						"r_name", resource_name,
+						"opr", resource_opr)
+				}
 			}
 		}
 
 		c.logger.Info("Add resource", "r_name", resource_name, "opr", resource_opr)
 
-		c.resource_map[key] = c_row
+		c_row.ResourceDef = row
+		resource_map[key] = c_row
 		num_resources += 1
 	}
 
@@ -242,6 +313,10 @@ func (c *ResourceStore) LoadResourcesFromDB() {
 		return
 	}
 
+	c.mu.Lock()
+	c.resource_map = resource_map
+	c.mu.Unlock()
+
 	c.logger.Info("Load Resource success, num_resources", "n_rcs", num_resources)
 }
 
@@ -440,3 +515,41 @@ func ConstructSelectedFields(
 
 	return selected_fields, nil
 }
+
+// constructOnConflictCols reads the optional "on_conflict_cols" and
+// "on_conflict_update_cols" string arrays from resource_json. They are used
+// by inserts (see RequestHandlers.CreateOnConflictPG).
+func constructOnConflictCols(
+	resource_json map[string]interface{},
+	resource_name string,
+	c_row *ApiTypes.ResourceStoreDef) error {
+	for _, field_name := range []string{"on_conflict_cols", "on_conflict_update_cols"} {
+		value_obj, ok := resource_json[field_name]
+		if !ok || value_obj == nil {
+			continue
+		}
+
+		value_slice, ok := value_obj.([]interface{})
+		if !ok {
+			return fmt.Errorf("field %s is not an array, resource_name:%s (SHD_RST_471)",
+				field_name, resource_name)
+		}
+
+		cols := make([]string, 0, len(value_slice))
+		for _, v := range value_slice {
+			col, ok := v.(string)
+			if !ok || col == "" {
+				return fmt.Errorf("field %s contains an invalid column:%v, resource_name:%s (SHD_RST_479)",
+					field_name, v, resource_name)
+			}
+			cols = append(cols, col)
+		}
+
+		if field_name == "on_conflict_cols" {
+			c_row.OnConflictCols = cols
+		} else {
+			c_row.OnConflictUpdateCols = cols
+		}
+	}
+	return nil
+}
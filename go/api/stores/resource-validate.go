@@ -0,0 +1,135 @@
+package stores
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+// ValidateResourceSchema checks a resource definition against the live
+// schema of its table in the shared database, which resource queries run
+// against. It returns one message per mismatch (missing table, unknown
+// column, incompatible data type, unknown on-conflict column). An empty result means the definition matches.
+// Resources whose type is not 'table' are not checked.
+func ValidateResourceSchema(
+	rc ApiTypes.RequestContext,
+	resource_def ApiTypes.ResourceStoreDef) ([]string, error) {
+	logger := rc.GetLogger()
+	row := resource_def.ResourceDef
+	if row.ResourceType != ApiTypes.ResourceType_Table {
+		return nil, nil
+	}
+
+	if !databaseutil.IsValidTableName(row.TableName) {
+		return []string{fmt.Sprintf("invalid table name:%s", row.TableName)}, nil
+	}
+
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	if db == nil {
+		return nil, fmt.Errorf("shared database not initialized (SHD_RSV_031)")
+	}
+
+	columns, err := databaseutil.GetTableColumns(db, ApiTypes.DBType, row.TableName)
+	if err != nil {
+		logger.Error("failed to read table columns", "error", err, "table_name", row.TableName)
+		return nil, fmt.Errorf("failed to read table columns (SHD_RSV_037): %w", err)
+	}
+
+	if len(columns) == 0 {
+		return []string{fmt.Sprintf("table %s does not exist", row.TableName)}, nil
+	}
+
+	var mismatches []string
+	check_field_defs := func(attr_name string, field_defs []ApiTypes.FieldDef) {
+		for _, field_def := range field_defs {
+			if field_def.DataType == "_ignore" {
+				continue
+			}
+
+			column_type, ok := columns[strings.ToLower(field_def.FieldName)]
+			if !ok {
+				mismatches = append(mismatches, fmt.Sprintf("%s: column %s not found in table %s",
+					attr_name, field_def.FieldName, row.TableName))
+				continue
+			}
+
+			if !isDataTypeCompatible(field_def.DataType, column_type) {
+				mismatches = append(mismatches, fmt.Sprintf("%s: column %s has type %s, field def declares %s",
+					attr_name, field_def.FieldName, column_type, field_def.DataType))
+			}
+		}
+	}
+	check_field_defs("field_defs", resource_def.FieldDefs)
+	check_field_defs("selected_fields", resource_def.SelectedFields)
+
+	for _, col := range append(resource_def.OnConflictCols, resource_def.OnConflictUpdateCols...) {
+		if _, ok := columns[strings.ToLower(col)]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("on-conflict column %s not found in table %s",
+				col, row.TableName))
+		}
+	}
+
+	if len(resource_def.OnConflictCols) > 0 && len(resource_def.OnConflictUpdateCols) == 0 {
+		mismatches = append(mismatches, "on_conflict_cols requires on_conflict_update_cols")
+	}
+
+	return mismatches, nil
+}
+
+// dataTypeCategory groups FieldDef data types and information_schema data
+// types into coarse categories. It returns "" for types it does not know, in
+// which case no type check is done.
+func dataTypeCategory(data_type string) string {
+	data_type = strings.ToLower(strings.TrimSpace(data_type))
+	switch {
+	case data_type == "string" || data_type == "text" || data_type == "char" ||
+		strings.Contains(data_type, "varchar") || strings.HasPrefix(data_type, "character") ||
+		strings.HasSuffix(data_type, "text") || data_type == "uuid":
+		return "string"
+
+	case data_type == "int" || data_type == "integer" || strings.HasSuffix(data_type, "int") ||
+		strings.HasSuffix(data_type, "serial"):
+		return "int"
+
+	case data_type == "float" || data_type == "double" || data_type == "double precision" ||
+		data_type == "real" || data_type == "decimal" || data_type == "numeric":
+		return "float"
+
+	case data_type == "bool" || data_type == "boolean":
+		return "bool"
+
+	case strings.HasPrefix(data_type, "timestamp") || data_type == "datetime" ||
+		data_type == "date" || strings.HasPrefix(data_type, "time"):
+		return "datetime"
+	}
+	return ""
+}
+
+// isDataTypeCompatible reports whether a FieldDef data type can be stored in
+// a column of the given database type. Integers fit in float columns,
+// booleans fit in integer columns (MySQL stores them as tinyint), and any
+// known type may be stored in a text column.
+func isDataTypeCompatible(field_type string, column_type string) bool {
+	field_category := dataTypeCategory(field_type)
+	column_category := dataTypeCategory(column_type)
+	if field_category == "" || column_category == "" {
+		return true
+	}
+
+	if field_category == column_category {
+		return true
+	}
+
+	if field_category == "int" && column_category == "float" {
+		return true
+	}
+
+	if field_category == "bool" && column_category == "int" {
+		return true
+	}
+
+	return column_category == "string"
+}
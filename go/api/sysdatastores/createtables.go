@@ -2,8 +2,10 @@ package sysdatastores
 
 import (
 	"database/sql"
+	"strings"
 
 	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
 	"github.com/chendingplano/shared/go/api/ipdb"
 	_ "github.com/lib/pq"
 )
@@ -21,6 +23,9 @@ func CreateSysTables(logger ApiTypes.JimoLogger) error {
 	CreatePromptStoreTable(logger, db, database_type, ApiTypes.LibConfig.SystemTableNames.TableNamePromptStore)
 	CreateResourcesTable(logger, db, database_type, ApiTypes.LibConfig.SystemTableNames.TableNameResources)
 	CreateTableManagerTable(logger)
+	CreateQuotasTables(logger, db, database_type)
+	CreateIdempotencyKeysTable(logger, db, database_type)
+	CreateAttachmentsTable(logger, db, database_type)
 	CreateIconsTable(logger, db, database_type, ApiTypes.LibConfig.SystemTableNames.TableNameResources)
 	ipdb.CreateTables(logger)
 
@@ -30,10 +35,73 @@ func CreateSysTables(logger ApiTypes.JimoLogger) error {
 	return nil
 }
 
+// SystemSchemaVersion is the schema level of the system tables that
+// CreateSysTables and RunMigrations produce. Bump it whenever a migration
+// changes the columns of a system table; system data bundles (see
+// ExportSystemData) only import into the same version.
+const SystemSchemaVersion = 4
+
 // RunMigrations applies schema migrations to existing tables.
 // Each migration is idempotent - safe to run multiple times.
 func RunMigrations(logger ApiTypes.JimoLogger, db *sql.DB, db_type string) {
 	logger.Info("Running database migrations")
 
+	// Users soft-delete (disabled_at), two-factor, Outlook, last login and
+	// password expiry columns. The users table is optional (it does not exist when Kratos is
+	// used), so only migrate it if it exists.
+	columns, err := databaseutil.GetTableColumns(db, db_type, UsersTableName)
+	if err != nil {
+		logger.Error("failed to read users columns", "error", err)
+	} else if len(columns) > 0 {
+		user_columns := append([]string{"disabled_at TIMESTAMP DEFAULT NULL"}, UsersTwoFactorColumns...)
+		user_columns = append(user_columns, UsersOutlookColumns...)
+		user_columns = append(user_columns, UsersLastLoginColumn, UsersPasswordExpiresColumn)
+		for _, col := range user_columns {
+			if _, ok := columns[strings.Fields(col)[0]]; ok {
+				continue
+			}
+			stmt := "ALTER TABLE " + UsersTableName + " ADD COLUMN " + col
+			if err := databaseutil.ExecuteStatement(db, stmt); err != nil {
+				logger.Error("migration failed", "error", err, "stmt", stmt)
+			}
+		}
+		migrateIndex(logger, db, db_type, columns, "last_login_at",
+			UsersTableName, "idx_users_last_login", usersLastLoginIndexStmt(UsersTableName))
+	}
+
+	// Login session activity (last_activity_at), for the idle timeout
+	sessions_table := ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions
+	columns, err = databaseutil.GetTableColumns(db, db_type, sessions_table)
+	if err != nil {
+		logger.Error("failed to read login sessions columns", "error", err)
+	} else if len(columns) > 0 {
+		if _, ok := columns["last_activity_at"]; !ok {
+			stmt := "ALTER TABLE " + sessions_table + " ADD COLUMN last_activity_at TIMESTAMP NULL DEFAULT NULL"
+			if err := databaseutil.ExecuteStatement(db, stmt); err != nil {
+				logger.Error("migration failed", "error", err, "stmt", stmt)
+			}
+		}
+		migrateIndex(logger, db, db_type, columns, "last_activity_at",
+			sessions_table, "idx_last_activity", sessionsLastActivityIndexStmt(sessions_table))
+	}
+
 	logger.Info("Database migrations completed")
 }
+
+// migrateIndex creates the index 'index_name' on the column 'column' that
+// a migration added. PG creates it with 'pg_stmt', which is idempotent;
+// MySQL has no CREATE INDEX IF NOT EXISTS, so the index is only added
+// along with the column ('columns' are those from before the migration).
+func migrateIndex(logger ApiTypes.JimoLogger, db *sql.DB, db_type string,
+	columns map[string]string, column, table_name, index_name, pg_stmt string) {
+	stmt := pg_stmt
+	if db_type == ApiTypes.MysqlName {
+		if _, ok := columns[column]; ok {
+			return
+		}
+		stmt = "ALTER TABLE " + table_name + " ADD INDEX " + index_name + " (" + column + ")"
+	}
+	if err := databaseutil.ExecuteStatement(db, stmt); err != nil {
+		logger.Error("migration failed", "error", err, "stmt", stmt)
+	}
+}
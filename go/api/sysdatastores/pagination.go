@@ -0,0 +1,68 @@
+package sysdatastores
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+// PageLimits are the default and maximum page size of a listing.
+type PageLimits struct {
+	Default int
+	Max     int
+}
+
+// Clamp returns 'page_size', or Default if it is not positive, capped at Max.
+func (l PageLimits) Clamp(page_size int) int {
+	if page_size <= 0 {
+		page_size = l.Default
+	}
+	if page_size > l.Max {
+		page_size = l.Max
+	}
+	return page_size
+}
+
+// PaginatedQuery runs 'base' (SELECT <fields> FROM <table>) filtered by
+// 'where' (conditions joined with AND, without the WHERE keyword; may be
+// empty) and ordered by 'order_by', and returns the page of 'page_size'
+// rows starting at 'offset', plus the total number of matching rows.
+// 'page_size' is clamped to 'limits'; for page numbers, pass
+// page * limits.Clamp(page_size) as the offset. 'args' bind the
+// placeholders in 'where'. The caller closes the rows.
+func PaginatedQuery(
+	ctx context.Context,
+	db *sql.DB,
+	base string,
+	where string,
+	args []interface{},
+	order_by string,
+	offset int,
+	page_size int,
+	limits PageLimits) (*sql.Rows, int64, error) {
+	if where != "" {
+		base += " WHERE " + where
+	}
+
+	var total int64
+	count_query := "SELECT COUNT(*) FROM (" + base + ") AS matched"
+	if err := databaseutil.QueryRowWithRetry(ctx, db, count_query, args, &total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count rows (SHD_PAG_053): %w", err)
+	}
+
+	// Limit and offset are ints, so they are safe to inline; this keeps
+	// the query independent of the placeholder style.
+	query := base
+	if order_by != "" {
+		query += " ORDER BY " + order_by
+	}
+	query += fmt.Sprintf(" LIMIT %d OFFSET %d", limits.Clamp(page_size), max(offset, 0))
+
+	rows, err := databaseutil.QueryWithRetry(ctx, db, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query rows (SHD_PAG_065): %w", err)
+	}
+	return rows, total, nil
+}
@@ -0,0 +1,80 @@
+package sysdatastores
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPageLimitsClamp(t *testing.T) {
+	limits := PageLimits{Default: 50, Max: 200}
+	for page_size, want := range map[int]int{-1: 50, 0: 50, 10: 10, 200: 200, 500: 200} {
+		if got := limits.Clamp(page_size); got != want {
+			t.Errorf("Clamp(%d) = %d, want %d", page_size, got, want)
+		}
+	}
+}
+
+func TestPaginatedQuery(t *testing.T) {
+	limits := PageLimits{Default: 50, Max: 200}
+	tests := []struct {
+		name       string
+		where      string
+		args       []interface{}
+		offset     int
+		page_size  int
+		want_count string
+		want_query string
+	}{
+		{
+			name:       "filtered page",
+			where:      "category = $1",
+			args:       []interface{}{"tools"},
+			offset:     20,
+			page_size:  10,
+			want_count: "SELECT COUNT(*) FROM (SELECT id FROM icons WHERE category = $1) AS matched",
+			want_query: "SELECT id FROM icons WHERE category = $1 ORDER BY created_at DESC LIMIT 10 OFFSET 20",
+		},
+		{
+			name:       "no filter, page size capped, negative offset",
+			offset:     -5,
+			page_size:  1000,
+			want_count: "SELECT COUNT(*) FROM (SELECT id FROM icons) AS matched",
+			want_query: "SELECT id FROM icons ORDER BY created_at DESC LIMIT 200 OFFSET 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery(tt.want_count).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+			mock.ExpectQuery(tt.want_query).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+
+			rows, total, err := PaginatedQuery(context.Background(), db, "SELECT id FROM icons",
+				tt.where, tt.args, "created_at DESC", tt.offset, tt.page_size, limits)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer rows.Close()
+
+			num_rows := 0
+			for rows.Next() {
+				num_rows++
+			}
+			if total != 42 || num_rows != 2 {
+				t.Errorf("total = %d, rows = %d, want 42 and 2", total, num_rows)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
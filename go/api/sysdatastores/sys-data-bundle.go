@@ -0,0 +1,577 @@
+package sysdatastores
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+// SystemDataBundleFormat identifies the JSON documents ExportSystemData writes
+const SystemDataBundleFormat = "shared-system-data"
+
+const (
+	// ImportModeMerge inserts records that do not exist and updates the
+	// ones that do, matched by the table's natural key.
+	ImportModeMerge = "merge"
+
+	// ImportModeReplace empties each table in the bundle, then inserts its
+	// records. All tables are replaced in one transaction.
+	ImportModeReplace = "replace"
+)
+
+// SystemDataBundle is a portable copy of system table records.
+type SystemDataBundle struct {
+	Format        string            `json:"format"`
+	SchemaVersion int               `json:"schema_version"`
+	DBType        string            `json:"db_type"`
+	ExportedAt    time.Time         `json:"exported_at"`
+	Tables        []SystemDataTable `json:"tables"`
+}
+
+// SystemDataTable holds the records of one system table. Columns maps each
+// exported column to its database data type, which is used to decode the
+// values on import (timestamps are RFC 3339 strings, JSON columns are
+// embedded as JSON).
+type SystemDataTable struct {
+	Name    string                   `json:"name"`
+	Columns map[string]string        `json:"columns"`
+	Records []map[string]interface{} `json:"records"`
+}
+
+type SystemDataExportOptions struct {
+	// IncludeSecrets exports password and token columns and the tables
+	// that hold credentials (login sessions). They are left out by default.
+	IncludeSecrets bool
+}
+
+type SystemDataImportOptions struct {
+	// Mode is ImportModeMerge or ImportModeReplace
+	Mode string
+
+	// IncludeSecrets imports the password and token columns if the bundle
+	// has them. Without it they are dropped, so merging keeps the target's
+	// credentials.
+	IncludeSecrets bool
+}
+
+type systemDataSpec struct {
+	tableName  func() string
+	naturalKey []string
+
+	// caseInsensitiveKey matches the natural key with LOWER()
+	caseInsensitiveKey bool
+
+	// idColumn is the table's own id. Merging keeps the target's id for
+	// records that already exist, so references to it stay valid.
+	idColumn string
+
+	// generated columns are assigned by the database and not exported
+	generated []string
+
+	// secretColumns are exported only with IncludeSecrets
+	secretColumns []string
+
+	// secret tables are exported only with IncludeSecrets
+	secret bool
+}
+
+// SystemDataTables are the tables ExportSystemData exports by default.
+var SystemDataTables = []string{"users", "resources", "icons", "login_sessions"}
+
+var systemDataSpecs = map[string]systemDataSpec{
+	"users": {
+		tableName:          func() string { return UsersTableName },
+		naturalKey:         []string{"email"},
+		caseInsensitiveKey: true,
+		idColumn:           "id",
+		secretColumns: []string{
+			"password", "v_token", "two_factor_secret", "two_factor_recovery_codes",
+			"outlook_access_token", "outlook_refresh_token",
+		},
+	},
+	"resources": {
+		tableName:  func() string { return ApiTypes.LibConfig.SystemTableNames.TableNameResources },
+		naturalKey: []string{"resource_name", "resource_opr"},
+		generated:  []string{"resource_id"},
+	},
+	"icons": {
+		tableName:  func() string { return IconsTableName },
+		naturalKey: []string{"category", "name"},
+		idColumn:   "id",
+	},
+	"login_sessions": {
+		tableName:  func() string { return ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions },
+		naturalKey: []string{"session_id"},
+		secret:     true,
+	},
+}
+
+// ExportSystemData writes the records of 'tables' (names from
+// SystemDataTables; all of them the options allow if empty) from the
+// shared database to 'w' as a SystemDataBundle.
+func ExportSystemData(
+	rc ApiTypes.RequestContext,
+	tables []string,
+	w io.Writer,
+	opts SystemDataExportOptions) error {
+	logger := rc.GetLogger()
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	db_type := ApiTypes.DBType
+	if len(tables) == 0 {
+		for _, name := range SystemDataTables {
+			if !systemDataSpecs[name].secret || opts.IncludeSecrets {
+				tables = append(tables, name)
+			}
+		}
+	}
+
+	bundle := SystemDataBundle{
+		Format:        SystemDataBundleFormat,
+		SchemaVersion: SystemSchemaVersion,
+		DBType:        db_type,
+		ExportedAt:    time.Now().UTC(),
+	}
+	for _, name := range tables {
+		spec, err := getSystemDataSpec(name)
+		if err != nil {
+			return err
+		}
+		if spec.secret && !opts.IncludeSecrets {
+			return fmt.Errorf("table %s holds credentials and is exported only with IncludeSecrets (SHD_SDB_137)", name)
+		}
+
+		table, err := exportSystemTable(rc.Context(), db, db_type, name, spec, opts.IncludeSecrets)
+		if err != nil {
+			logger.Error("failed to export system table", "table", name, "error", err)
+			return err
+		}
+		bundle.Tables = append(bundle.Tables, *table)
+		logger.Info("exported system table", "table", name, "records", len(table.Records))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(bundle); err != nil {
+		return fmt.Errorf("failed to write bundle (SHD_SDB_152): %w", err)
+	}
+	return nil
+}
+
+func exportSystemTable(
+	ctx context.Context,
+	db *sql.DB,
+	db_type string,
+	name string,
+	spec systemDataSpec,
+	include_secrets bool) (*SystemDataTable, error) {
+	table_name := spec.tableName()
+	db_columns, err := databaseutil.GetTableColumns(db, db_type, table_name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s (SHD_SDB_166): %w", table_name, err)
+	}
+	if len(db_columns) == 0 {
+		return nil, fmt.Errorf("table %s does not exist (SHD_SDB_169)", table_name)
+	}
+
+	table := SystemDataTable{Name: name, Columns: make(map[string]string)}
+	columns := []string{}
+	for column, data_type := range db_columns {
+		if contains(spec.generated, column) || (!include_secrets && contains(spec.secretColumns, column)) {
+			continue
+		}
+		columns = append(columns, column)
+		table.Columns[column] = data_type
+	}
+	sort.Strings(columns)
+
+	query := "SELECT " + strings.Join(columns, ", ") + " FROM " + table_name +
+		" ORDER BY " + strings.Join(spec.naturalKey, ", ")
+	rows, err := databaseutil.QueryWithRetry(ctx, db, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s (SHD_SDB_187): %w", table_name, err)
+	}
+	defer rows.Close()
+
+	table.Records = []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan %s (SHD_SDB_199): %w", table_name, err)
+		}
+
+		record := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			record[column] = encodeSystemValue(values[i], table.Columns[column])
+		}
+		table.Records = append(table.Records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s (SHD_SDB_209): %w", table_name, err)
+	}
+	return &table, nil
+}
+
+// ImportSystemData loads a bundle written by ExportSystemData into the
+// shared database and returns the number of records imported per table.
+// Everything is imported in one transaction. The bundle must have the
+// same SystemSchemaVersion as this server, and every column in it must
+// exist in the database.
+func ImportSystemData(
+	rc ApiTypes.RequestContext,
+	r io.Reader,
+	opts SystemDataImportOptions) (map[string]int, error) {
+	logger := rc.GetLogger()
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	db_type := ApiTypes.DBType
+
+	if opts.Mode != ImportModeMerge && opts.Mode != ImportModeReplace {
+		return nil, fmt.Errorf("invalid import mode %q, expecting %s or %s (SHD_SDB_227)",
+			opts.Mode, ImportModeMerge, ImportModeReplace)
+	}
+
+	var bundle SystemDataBundle
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("failed to read bundle (SHD_SDB_235): %w", err)
+	}
+	if bundle.Format != SystemDataBundleFormat {
+		return nil, fmt.Errorf("not a system data bundle, format:%q (SHD_SDB_238)", bundle.Format)
+	}
+	if bundle.SchemaVersion != SystemSchemaVersion {
+		return nil, fmt.Errorf("bundle has schema version %d but this server is at %d; "+
+			"export the data again with a server at version %d (SHD_SDB_242)",
+			bundle.SchemaVersion, SystemSchemaVersion, SystemSchemaVersion)
+	}
+
+	// Check all tables before writing anything
+	plans := make([]*systemImportPlan, 0, len(bundle.Tables))
+	for i := range bundle.Tables {
+		plan, err := planSystemImport(db, db_type, &bundle.Tables[i], opts.IncludeSecrets)
+		if err != nil {
+			logger.Error("system data bundle rejected", "table", bundle.Tables[i].Name, "error", err)
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+
+	counts := make(map[string]int)
+	err := databaseutil.WithWriteRetry(rc.Context(), db, func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, plan := range plans {
+			num, err := plan.run(ctx, tx, db_type, opts.Mode)
+			if err != nil {
+				return err
+			}
+			counts[plan.table.Name] = num
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		logger.Error("failed to import system data", "error", err, "mode", opts.Mode)
+		return nil, fmt.Errorf("failed to import system data (SHD_SDB_275): %w", err)
+	}
+
+	logger.Info("imported system data", "mode", opts.Mode, "records", counts)
+	return counts, nil
+}
+
+type systemImportPlan struct {
+	table      *SystemDataTable
+	spec       systemDataSpec
+	table_name string
+	columns    []string
+}
+
+func planSystemImport(
+	db *sql.DB,
+	db_type string,
+	table *SystemDataTable,
+	include_secrets bool) (*systemImportPlan, error) {
+	spec, err := getSystemDataSpec(table.Name)
+	if err != nil {
+		return nil, err
+	}
+	if spec.secret && !include_secrets {
+		return nil, fmt.Errorf("table %s holds credentials and is imported only with IncludeSecrets (SHD_SDB_298)",
+			table.Name)
+	}
+
+	plan := &systemImportPlan{table: table, spec: spec, table_name: spec.tableName()}
+	db_columns, err := databaseutil.GetTableColumns(db, db_type, plan.table_name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s (SHD_SDB_305): %w", plan.table_name, err)
+	}
+	if len(db_columns) == 0 {
+		return nil, fmt.Errorf("table %s does not exist; start the server once to create the system tables (SHD_SDB_308)",
+			plan.table_name)
+	}
+
+	for column := range table.Columns {
+		if !databaseutil.IsValidTableName(column) {
+			return nil, fmt.Errorf("invalid column name %q in table %s (SHD_SDB_314)", column, table.Name)
+		}
+		if _, ok := db_columns[column]; !ok {
+			return nil, fmt.Errorf("column %s.%s is not in the database; it is not migrated to schema version %d (SHD_SDB_317)",
+				plan.table_name, column, SystemSchemaVersion)
+		}
+		if contains(spec.generated, column) || (!include_secrets && contains(spec.secretColumns, column)) {
+			continue
+		}
+		plan.columns = append(plan.columns, column)
+	}
+	sort.Strings(plan.columns)
+
+	for _, key := range spec.naturalKey {
+		if !contains(plan.columns, key) {
+			return nil, fmt.Errorf("table %s is missing key column %s (SHD_SDB_329)", table.Name, key)
+		}
+	}
+	return plan, nil
+}
+
+func (plan *systemImportPlan) run(
+	ctx context.Context,
+	tx *sql.Tx,
+	db_type string,
+	mode string) (int, error) {
+	if mode == ImportModeReplace {
+		// TRUNCATE commits implicitly on MySQL, so it cannot be used there
+		stmt := "TRUNCATE TABLE " + plan.table_name
+		if db_type == ApiTypes.MysqlName {
+			stmt = "DELETE FROM " + plan.table_name
+		}
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return 0, fmt.Errorf("failed to empty %s (SHD_SDB_347): %w", plan.table_name, err)
+		}
+	}
+
+	insert_stmt := "INSERT INTO " + plan.table_name + " (" + strings.Join(plan.columns, ", ") +
+		") VALUES (" + placeholderList(db_type, 1, len(plan.columns)) + ")"
+
+	// For merge: the key condition, and the columns an update sets
+	key_cond := []string{}
+	for i, key := range plan.spec.naturalKey {
+		if plan.spec.caseInsensitiveKey {
+			key_cond = append(key_cond, "LOWER("+key+") = LOWER("+placeholder(db_type, i+1)+")")
+		} else {
+			key_cond = append(key_cond, key+" = "+placeholder(db_type, i+1))
+		}
+	}
+	exists_query := "SELECT COUNT(*) FROM " + plan.table_name + " WHERE " + strings.Join(key_cond, " AND ")
+	update_columns := []string{}
+	for _, column := range plan.columns {
+		if column != plan.spec.idColumn && !contains(plan.spec.naturalKey, column) {
+			update_columns = append(update_columns, column)
+		}
+	}
+	update_stmt := ""
+	if len(update_columns) > 0 {
+		sets := make([]string, len(update_columns))
+		for i, column := range update_columns {
+			sets[i] = column + " = " + placeholder(db_type, i+1)
+		}
+		key_cond = key_cond[:0]
+		for i, key := range plan.spec.naturalKey {
+			p := placeholder(db_type, len(update_columns)+i+1)
+			if plan.spec.caseInsensitiveKey {
+				key_cond = append(key_cond, "LOWER("+key+") = LOWER("+p+")")
+			} else {
+				key_cond = append(key_cond, key+" = "+p)
+			}
+		}
+		update_stmt = "UPDATE " + plan.table_name + " SET " + strings.Join(sets, ", ") +
+			" WHERE " + strings.Join(key_cond, " AND ")
+	}
+
+	for idx, record := range plan.table.Records {
+		values := make(map[string]interface{}, len(plan.columns))
+		for _, column := range plan.columns {
+			value, err := decodeSystemValue(record[column], plan.table.Columns[column])
+			if err != nil {
+				return 0, fmt.Errorf("table %s, record %d, column %s (SHD_SDB_394): %w",
+					plan.table.Name, idx, column, err)
+			}
+			values[column] = value
+		}
+		key_args := make([]interface{}, len(plan.spec.naturalKey))
+		for i, key := range plan.spec.naturalKey {
+			key_args[i] = values[key]
+		}
+
+		if mode == ImportModeMerge {
+			var num int
+			if err := tx.QueryRowContext(ctx, exists_query, key_args...).Scan(&num); err != nil {
+				return 0, fmt.Errorf("failed to look up %s record %d (SHD_SDB_406): %w", plan.table.Name, idx, err)
+			}
+			if num > 0 {
+				if update_stmt == "" {
+					continue
+				}
+				args := make([]interface{}, 0, len(update_columns)+len(key_args))
+				for _, column := range update_columns {
+					args = append(args, values[column])
+				}
+				args = append(args, key_args...)
+				if _, err := tx.ExecContext(ctx, update_stmt, args...); err != nil {
+					return 0, fmt.Errorf("failed to update %s record %d (SHD_SDB_418): %w", plan.table.Name, idx, err)
+				}
+				continue
+			}
+		}
+
+		args := make([]interface{}, len(plan.columns))
+		for i, column := range plan.columns {
+			args[i] = values[column]
+		}
+		if _, err := tx.ExecContext(ctx, insert_stmt, args...); err != nil {
+			return 0, fmt.Errorf("failed to insert %s record %d (SHD_SDB_429): %w", plan.table.Name, idx, err)
+		}
+	}
+	return len(plan.table.Records), nil
+}
+
+// encodeSystemValue converts a scanned value to its bundle form
+func encodeSystemValue(value interface{}, data_type string) interface{} {
+	if value == nil {
+		return nil
+	}
+	if t, ok := value.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	if isJSONColumn(data_type) {
+		var raw []byte
+		switch val := value.(type) {
+		case []byte:
+			raw = val
+		case string:
+			raw = []byte(val)
+		}
+		if json.Valid(raw) {
+			return json.RawMessage(append([]byte(nil), raw...))
+		}
+	}
+	return databaseutil.ConvertValueByType(value, fieldTypeOfColumn(data_type))
+}
+
+// decodeSystemValue converts a bundle value to what is bound for a column
+// of type 'data_type'.
+func decodeSystemValue(value interface{}, data_type string) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if isJSONColumn(data_type) {
+		if str, ok := value.(string); ok {
+			return str, nil
+		}
+		bytes, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(bytes), nil
+	}
+
+	switch val := value.(type) {
+	case json.Number:
+		switch fieldTypeOfColumn(data_type) {
+		case "bigint":
+			return val.Int64()
+		case "double":
+			return val.Float64()
+		}
+		return val.String(), nil
+
+	case string:
+		if fieldTypeOfColumn(data_type) == "timestamp" {
+			if t, err := time.Parse(time.RFC3339Nano, val); err == nil {
+				return t, nil
+			}
+		}
+		return val, nil
+
+	case bool:
+		return val, nil
+	}
+	return nil, fmt.Errorf("unexpected value type %T", value)
+}
+
+// fieldTypeOfColumn maps a database data type (as reported by
+// information_schema) to the data type names ConvertValueByType uses.
+func fieldTypeOfColumn(data_type string) string {
+	switch {
+	case strings.Contains(data_type, "char") || strings.Contains(data_type, "text") || data_type == "uuid":
+		return "string"
+
+	case strings.Contains(data_type, "int"):
+		return "bigint"
+
+	case data_type == "double precision" || data_type == "real" || data_type == "numeric" ||
+		data_type == "decimal" || data_type == "float" || data_type == "double":
+		return "double"
+
+	case data_type == "boolean":
+		return "boolean"
+
+	case strings.HasPrefix(data_type, "timestamp") || data_type == "datetime" ||
+		data_type == "date" || strings.HasPrefix(data_type, "time"):
+		return "timestamp"
+	}
+	return data_type
+}
+
+func isJSONColumn(data_type string) bool {
+	return data_type == "json" || data_type == "jsonb"
+}
+
+func getSystemDataSpec(name string) (systemDataSpec, error) {
+	spec, ok := systemDataSpecs[name]
+	if !ok {
+		return spec, fmt.Errorf("unknown system table %q, expecting one of %s (SHD_SDB_523)",
+			name, strings.Join(SystemDataTables, ", "))
+	}
+	if spec.tableName() == "" {
+		return spec, fmt.Errorf("table name of %s is not configured, check libconfig.toml (SHD_SDB_530)", name)
+	}
+	return spec, nil
+}
+
+func placeholder(db_type string, n int) string {
+	if db_type == ApiTypes.MysqlName {
+		return "?"
+	}
+	return fmt.Sprintf("$%d", n)
+}
+
+func placeholderList(db_type string, start int, count int) string {
+	list := make([]string, count)
+	for i := range list {
+		list[i] = placeholder(db_type, start+i)
+	}
+	return strings.Join(list, ", ")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package sysdatastores
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/loggerutil"
+)
+
+func TestSystemValuesRoundTrip(t *testing.T) {
+	created := time.Date(2026, 3, 4, 5, 6, 7, 800, time.UTC)
+	cases := []struct {
+		data_type string
+		scanned   interface{}
+		want      interface{}
+	}{
+		{"character varying", "alice", "alice"},
+		{"bigint", int64(42), int64(42)},
+		{"double precision", 1.5, 1.5},
+		{"boolean", true, true},
+		{"timestamp without time zone", created, created},
+		{"jsonb", []byte(`{"a":[1,2]}`), `{"a":[1,2]}`},
+		{"text", nil, nil},
+	}
+
+	for _, c := range cases {
+		// Through JSON, as in a bundle
+		bytes, err := json.Marshal(encodeSystemValue(c.scanned, c.data_type))
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", c.data_type, err)
+		}
+		decoder := json.NewDecoder(strings.NewReader(string(bytes)))
+		decoder.UseNumber()
+		var bundled interface{}
+		if err := decoder.Decode(&bundled); err != nil {
+			t.Fatalf("%s: unmarshal: %v", c.data_type, err)
+		}
+
+		got, err := decodeSystemValue(bundled, c.data_type)
+		if err != nil {
+			t.Fatalf("%s: decode: %v", c.data_type, err)
+		}
+		if tm, ok := got.(time.Time); ok {
+			if !tm.Equal(created) {
+				t.Errorf("%s: got %v, want %v", c.data_type, tm, created)
+			}
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: got %#v (%T), want %#v", c.data_type, got, got, c.want)
+		}
+	}
+}
+
+func TestImportSystemDataRejectsSchemaMismatch(t *testing.T) {
+	rc := testRC{logger: loggerutil.CreateDefaultLogger("SHD_SDB_T01")}
+	bundle := `{"format":"shared-system-data","schema_version":1,"tables":[]}`
+
+	_, err := ImportSystemData(rc, strings.NewReader(bundle), SystemDataImportOptions{Mode: ImportModeMerge})
+	if err == nil || !strings.Contains(err.Error(), "schema version 1") {
+		t.Fatalf("expected schema version error, got %v", err)
+	}
+
+	_, err = ImportSystemData(rc, strings.NewReader(bundle), SystemDataImportOptions{Mode: "upsert"})
+	if err == nil || !strings.Contains(err.Error(), "invalid import mode") {
+		t.Fatalf("expected mode error, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package sysdatastores
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/lib/pq"
+)
+
+// NaiveTimestampColumn is a timestamp column without a time zone
+// (TIMESTAMP WITHOUT TIME ZONE on PG, DATETIME on MySQL). The Jimo
+// handlers treat such columns as UTC wall clocks (see
+// ApiTypes.TimestampKindNaive); columns filled by CURRENT_TIMESTAMP hold
+// the wall clock of the server's session zone instead.
+type NaiveTimestampColumn struct {
+	TableName  string
+	ColumnName string
+	DataType   string
+}
+
+// MigrationSQL returns the PG statement that turns the column into a
+// TIMESTAMP WITH TIME ZONE, reading the stored wall clocks in 'zone'.
+// It returns "" for MySQL, whose TIMESTAMP cannot hold the DATETIME range.
+func (c NaiveTimestampColumn) MigrationSQL(db_type string, zone string) string {
+	if db_type != ApiTypes.PgName {
+		return ""
+	}
+	return fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE TIMESTAMP WITH TIME ZONE USING %s AT TIME ZONE %s;",
+		c.TableName, c.ColumnName, c.ColumnName, pq.QuoteLiteral(zone))
+}
+
+// SystemTableNames returns the names of the system tables, as configured
+// in libconfig where they are configurable.
+func SystemTableNames() []string {
+	conf := ApiTypes.LibConfig.SystemTableNames
+	names := []string{
+		UsersTableName,
+		conf.TableNameLoginSessions,
+		conf.TableNameSessionLog,
+		conf.TableNameActivityLog,
+		conf.TableNameIDMgr,
+		conf.TableNameEmailStore,
+		conf.TableNamePromptStore,
+		conf.TableNameResources,
+		conf.TableNameTableManager,
+		conf.TableNameDBMigrations,
+		IconsTableName,
+		AttachmentsTableName,
+		QuotasTableName,
+		QuotaUsageTableName,
+	}
+
+	var tables []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			tables = append(tables, name)
+		}
+	}
+	return tables
+}
+
+// FindNaiveTimestampColumns returns the naive timestamp columns of
+// 'tables' (the system tables if empty), ordered by table and column
+// position. It only reads the catalog.
+func FindNaiveTimestampColumns(
+	ctx context.Context,
+	db *sql.DB,
+	db_type string,
+	tables []string) ([]NaiveTimestampColumn, error) {
+	if len(tables) == 0 {
+		tables = SystemTableNames()
+	}
+
+	var query string
+	var args []interface{}
+	switch db_type {
+	case ApiTypes.PgName:
+		query = "SELECT table_name, column_name, data_type FROM information_schema.columns " +
+			"WHERE table_schema = current_schema() AND data_type = 'timestamp without time zone' " +
+			"AND table_name = ANY($1) ORDER BY table_name, ordinal_position"
+		args = []interface{}{pq.Array(tables)}
+
+	case ApiTypes.MysqlName:
+		placeholders := make([]string, len(tables))
+		for i, name := range tables {
+			placeholders[i] = "?"
+			args = append(args, name)
+		}
+		query = "SELECT table_name, column_name, data_type FROM information_schema.columns " +
+			"WHERE table_schema = DATABASE() AND data_type = 'datetime' " +
+			"AND table_name IN (" + strings.Join(placeholders, ",") + ") " +
+			"ORDER BY table_name, ordinal_position"
+
+	default:
+		return nil, fmt.Errorf("unsupported database type:%s (SHD_STC_098)", db_type)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %w (SHD_STC_103)", err)
+	}
+	defer rows.Close()
+
+	var columns []NaiveTimestampColumn
+	for rows.Next() {
+		var c NaiveTimestampColumn
+		if err := rows.Scan(&c.TableName, &c.ColumnName, &c.DataType); err != nil {
+			return nil, fmt.Errorf("failed to scan column: %w (SHD_STC_110)", err)
+		}
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read columns: %w (SHD_STC_115)", err)
+	}
+	return columns, nil
+}
@@ -0,0 +1,57 @@
+package sysdatastores
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+func TestFindNaiveTimestampColumns(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("data_type = 'timestamp without time zone'").
+		WillReturnRows(sqlmock.NewRows([]string{"table_name", "column_name", "data_type"}).
+			AddRow("users", "disabled_at", "timestamp without time zone").
+			AddRow("jimo_quotas", "updated_at", "timestamp without time zone"))
+	mock.ExpectQuery("data_type = 'datetime'").
+		WithArgs("users", "icons").
+		WillReturnRows(sqlmock.NewRows([]string{"table_name", "column_name", "data_type"}).
+			AddRow("users", "disabled_at", "datetime"))
+
+	columns, err := FindNaiveTimestampColumns(context.Background(), db, ApiTypes.PgName, nil)
+	if err != nil {
+		t.Fatalf("pg: %v", err)
+	}
+	want := []NaiveTimestampColumn{
+		{TableName: "users", ColumnName: "disabled_at", DataType: "timestamp without time zone"},
+		{TableName: "jimo_quotas", ColumnName: "updated_at", DataType: "timestamp without time zone"},
+	}
+	if !reflect.DeepEqual(columns, want) {
+		t.Errorf("pg: columns = %v, want %v", columns, want)
+	}
+
+	columns, err = FindNaiveTimestampColumns(context.Background(), db, ApiTypes.MysqlName, []string{"users", "icons"})
+	if err != nil {
+		t.Fatalf("mysql: %v", err)
+	}
+	if len(columns) != 1 || columns[0].MigrationSQL(ApiTypes.MysqlName, "UTC") != "" {
+		t.Errorf("mysql: columns = %v", columns)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	got := want[0].MigrationSQL(ApiTypes.PgName, "America/New_York")
+	if got != "ALTER TABLE users ALTER COLUMN disabled_at TYPE TIMESTAMP WITH TIME ZONE "+
+		"USING disabled_at AT TIME ZONE 'America/New_York';" {
+		t.Errorf("migration sql = %s", got)
+	}
+}
@@ -5,6 +5,7 @@ package sysdatastores
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -59,6 +60,8 @@ func CreateActivityLogTable(
 	case ApiTypes.MysqlName:
 		stmt = "CREATE TABLE IF NOT EXISTS " + table_name + "(" + fields +
 			", INDEX idx_created_at (created_at) " +
+			", INDEX idx_app_module_created (app_name, module_name, created_at) " +
+			", INDEX idx_type_created (activity_type, created_at) " +
 			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;"
 
 	case ApiTypes.PgName:
@@ -78,8 +81,18 @@ func CreateActivityLogTable(
 	}
 
 	if db_type == ApiTypes.PgName {
+		// Indexes for QueryActivityLogs filters. They are created with IF NOT
+		// EXISTS, so existing tables get them on the next startup.
 		idx1 := `CREATE INDEX IF NOT EXISTS idx_created_at ON ` + table_name + ` (created_at);`
 		databaseutil.ExecuteStatement(db, idx1)
+
+		idx2 := `CREATE INDEX IF NOT EXISTS idx_` + table_name + `_app_module_created ON ` +
+			table_name + ` (app_name, module_name, created_at);`
+		databaseutil.ExecuteStatement(db, idx2)
+
+		idx3 := `CREATE INDEX IF NOT EXISTS idx_` + table_name + `_type_created ON ` +
+			table_name + ` (activity_type, created_at);`
+		databaseutil.ExecuteStatement(db, idx3)
 	}
 
 	logger.Info("Create table success", "table_name", table_name)
@@ -107,6 +120,10 @@ func StopActivityLogCache() {
 
 // Public API
 func NextActivityLogID() int64 {
+	// The cache is not initialized (e.g. in unit tests)
+	if activity_log_singleton == nil {
+		return -1
+	}
 	return activity_log_singleton.nextLogID()
 }
 
@@ -303,3 +320,141 @@ func (c *ActivityLogCache) insertRecords(records []ApiTypes.ActivityLogDef) erro
 	}
 	return nil
 }
+
+// ActivityLogFilter selects activity logs for QueryActivityLogs. Empty
+// fields are not filtered on. From is inclusive, To is exclusive.
+type ActivityLogFilter struct {
+	AppName      string    `json:"app_name,omitempty"`
+	ModuleName   string    `json:"module_name,omitempty"`
+	ActivityName string    `json:"activity_name,omitempty"`
+	ActivityType string    `json:"activity_type,omitempty"`
+	From         time.Time `json:"from,omitempty"`
+	To           time.Time `json:"to,omitempty"`
+	Limit        int       `json:"limit,omitempty"`
+	Offset       int       `json:"offset,omitempty"`
+}
+
+// activityLogPageLimits are the Limit default and cap of QueryActivityLogs
+var activityLogPageLimits = PageLimits{Default: 100, Max: 1000}
+
+// QueryActivityLogs returns the activity logs matching 'filter', newest
+// first, and the total number of matching logs (for pagination). Limit
+// defaults to 100 and is capped at 1000. Logs still in the write cache
+// (up to 10 seconds old) are not included.
+func QueryActivityLogs(
+	rc ApiTypes.RequestContext,
+	filter ActivityLogFilter) ([]ApiTypes.ActivityLogDef, int64, error) {
+	logger := rc.GetLogger()
+	c := activity_log_singleton
+	if c == nil {
+		return nil, 0, fmt.Errorf("cache not initialized; call InitCache first (SHD_ALG_336)")
+	}
+
+	var conditions []string
+	var args []interface{}
+	add_condition := func(cond string, value interface{}) {
+		args = append(args, value)
+		placeholder := "?"
+		if c.db_type == ApiTypes.PgName {
+			placeholder = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, strings.Replace(cond, "?", placeholder, 1))
+	}
+
+	if filter.AppName != "" {
+		add_condition("app_name = ?", filter.AppName)
+	}
+	if filter.ModuleName != "" {
+		add_condition("module_name = ?", filter.ModuleName)
+	}
+	if filter.ActivityName != "" {
+		add_condition("activity_name = ?", filter.ActivityName)
+	}
+	if filter.ActivityType != "" {
+		add_condition("activity_type = ?", filter.ActivityType)
+	}
+	if !filter.From.IsZero() {
+		add_condition("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add_condition("created_at < ?", filter.To)
+	}
+
+	rows, total, err := PaginatedQuery(rc.Context(), c.db,
+		"SELECT log_id, activity_name, activity_type, app_name, module_name, "+
+			"activity_msg, activity_notes, caller_loc, created_at FROM "+c.table_name,
+		strings.Join(conditions, " AND "), args,
+		"created_at DESC, log_id DESC", filter.Offset, filter.Limit, activityLogPageLimits)
+	if err != nil {
+		logger.Error("failed to query activity logs", "error", err)
+		return nil, 0, fmt.Errorf("failed to query activity logs (SHD_ALG_398): %w", err)
+	}
+	defer rows.Close()
+
+	records := []ApiTypes.ActivityLogDef{}
+	for rows.Next() {
+		var record ApiTypes.ActivityLogDef
+		var msg, notes sql.NullString
+		var created_at sql.NullTime
+		if err := rows.Scan(&record.LogID, &record.ActivityName, &record.ActivityType,
+			&record.AppName, &record.ModuleName, &msg, &notes, &record.CallerLoc, &created_at); err != nil {
+			logger.Error("failed to scan activity log", "error", err)
+			return nil, 0, fmt.Errorf("failed to scan activity log (SHD_ALG_409): %w", err)
+		}
+
+		if msg.Valid {
+			record.ActivityMsg = &msg.String
+		}
+		if notes.Valid {
+			record.Activity_notes = &notes.String
+		}
+		if created_at.Valid {
+			created_str := created_at.Time.Format(time.RFC3339)
+			record.CreatedAt = &created_str
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read activity logs (SHD_ALG_425): %w", err)
+	}
+
+	return records, total, nil
+}
+
+// PurgeActivityLogs deletes activity logs created more than 'older_than'
+// ago and returns the number of deleted logs. Use it for retention.
+func PurgeActivityLogs(
+	rc ApiTypes.RequestContext,
+	older_than time.Duration) (int64, error) {
+	logger := rc.GetLogger()
+	c := activity_log_singleton
+	if c == nil {
+		return 0, fmt.Errorf("cache not initialized; call InitCache first (SHD_ALG_439)")
+	}
+	if older_than <= 0 {
+		return 0, fmt.Errorf("older_than must be positive (SHD_ALG_442): %s", older_than)
+	}
+
+	var stmt string
+	switch c.db_type {
+	case ApiTypes.MysqlName:
+		stmt = "DELETE FROM " + c.table_name + " WHERE created_at < ?"
+
+	case ApiTypes.PgName:
+		stmt = "DELETE FROM " + c.table_name + " WHERE created_at < $1"
+
+	default:
+		return 0, fmt.Errorf("unsupported database type (SHD_ALG_454): %s", c.db_type)
+	}
+
+	cutoff := time.Now().Add(-older_than)
+	result, err := databaseutil.ExecWithRetry(rc.Context(), c.db, stmt, cutoff)
+	if err != nil {
+		logger.Error("failed to purge activity logs", "error", err)
+		return 0, fmt.Errorf("failed to purge activity logs (SHD_ALG_461): %w", err)
+	}
+
+	num_deleted, _ := result.RowsAffected()
+	logger.Info("Purged activity logs", "num_deleted", num_deleted, "cutoff", cutoff)
+	return num_deleted, nil
+}
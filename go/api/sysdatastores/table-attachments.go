@@ -0,0 +1,207 @@
+package sysdatastores
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+const AttachmentsTableName = "attachments"
+
+var attachments_field_names = "id, owner_table, owner_id, file_name, mime_type, size, " +
+	"storage_backend, storage_key, sha256, creator, created_at"
+
+// CreateAttachmentsTable creates the metadata table of the attachments
+// module. The file contents live in the storage backend under
+// storage_key.
+func CreateAttachmentsTable(
+	logger ApiTypes.JimoLogger,
+	db *sql.DB,
+	db_type string) error {
+	logger.Info("Create table", "table_name", AttachmentsTableName)
+
+	fields := "id              VARCHAR(40)     PRIMARY KEY, " +
+		"owner_table     VARCHAR(128)    NOT NULL, " +
+		"owner_id        VARCHAR(128)    NOT NULL, " +
+		"file_name       VARCHAR(255)    NOT NULL, " +
+		"mime_type       VARCHAR(128)    NOT NULL, " +
+		"size            BIGINT          NOT NULL DEFAULT 0, " +
+		"storage_backend VARCHAR(16)     NOT NULL, " +
+		"storage_key     VARCHAR(512)    NOT NULL, " +
+		"sha256          CHAR(64)        NOT NULL, " +
+		"creator         VARCHAR(255)    NOT NULL, " +
+		"created_at      TIMESTAMP       DEFAULT CURRENT_TIMESTAMP"
+
+	var stmts []string
+	switch db_type {
+	case ApiTypes.MysqlName:
+		stmts = []string{
+			"CREATE TABLE IF NOT EXISTS " + AttachmentsTableName + "(" + fields +
+				", INDEX idx_attachments_owner (owner_table, owner_id)" +
+				", INDEX idx_attachments_key (storage_key)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;",
+		}
+
+	case ApiTypes.PgName:
+		stmts = []string{
+			"CREATE TABLE IF NOT EXISTS " + AttachmentsTableName + "(" + fields + ")",
+			"CREATE INDEX IF NOT EXISTS idx_attachments_owner ON " + AttachmentsTableName + " (owner_table, owner_id);",
+			"CREATE INDEX IF NOT EXISTS idx_attachments_key ON " + AttachmentsTableName + " (storage_key);",
+		}
+
+	default:
+		err := fmt.Errorf("database type not supported:%s (SHD_ATT_060)", db_type)
+		logger.Error("db_type not supported", "db_type", db_type)
+		return err
+	}
+
+	for _, stmt := range stmts {
+		if err := databaseutil.ExecuteStatement(db, stmt); err != nil {
+			logger.Error("failed creating table", "error", err, "stmt", stmt)
+			return fmt.Errorf("failed creating table (SHD_ATT_067), err: %w, stmt:%s", err, stmt)
+		}
+	}
+
+	logger.Info("Create table success", "table_name", AttachmentsTableName)
+	return nil
+}
+
+type attachmentRowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAttachment(row attachmentRowScanner) (*ApiTypes.AttachmentDef, error) {
+	var def ApiTypes.AttachmentDef
+	var created_at sql.NullTime
+	err := row.Scan(&def.ID, &def.OwnerTable, &def.OwnerID, &def.FileName, &def.MimeType, &def.Size,
+		&def.StorageBackend, &def.StorageKey, &def.SHA256, &def.Creator, &created_at)
+	if err != nil {
+		return nil, err
+	}
+	def.CreatedAt = created_at.Time
+	return &def, nil
+}
+
+// InsertAttachment inserts 'def'; the caller sets def.ID.
+func InsertAttachment(rc ApiTypes.RequestContext, def *ApiTypes.AttachmentDef) error {
+	db_type := ApiTypes.DBType
+	if def.CreatedAt.IsZero() {
+		def.CreatedAt = time.Now().UTC()
+	}
+	values := ""
+	for i := 1; i <= 11; i++ {
+		if i > 1 {
+			values += ", "
+		}
+		values += placeholder(db_type, i)
+	}
+	stmt := "INSERT INTO " + AttachmentsTableName + " (" + attachments_field_names + ") VALUES (" + values + ")"
+
+	_, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt,
+		def.ID, def.OwnerTable, def.OwnerID, def.FileName, def.MimeType, def.Size,
+		def.StorageBackend, def.StorageKey, def.SHA256, def.Creator, def.CreatedAt)
+	if err != nil {
+		rc.GetLogger().Error("failed to insert attachment", "error", err, "id", def.ID)
+		return fmt.Errorf("failed to insert attachment (SHD_ATT_108): %w", err)
+	}
+	return nil
+}
+
+// GetAttachmentByID returns nil if the attachment does not exist
+func GetAttachmentByID(rc ApiTypes.RequestContext, id string) (*ApiTypes.AttachmentDef, error) {
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	query := "SELECT " + attachments_field_names + " FROM " + AttachmentsTableName +
+		" WHERE id = " + placeholder(ApiTypes.DBType, 1)
+
+	var def *ApiTypes.AttachmentDef
+	err := databaseutil.WithRetry(rc.Context(), db, func(ctx context.Context) error {
+		var err error
+		def, err = scanAttachment(db.QueryRowContext(ctx, query, id))
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		rc.GetLogger().Error("failed to get attachment", "error", err, "id", id)
+		return nil, fmt.Errorf("failed to get attachment (SHD_ATT_129): %w", err)
+	}
+	return def, nil
+}
+
+// ListAttachmentsByOwner returns the attachments of a record, oldest first
+func ListAttachmentsByOwner(
+	rc ApiTypes.RequestContext,
+	owner_table string,
+	owner_id string) ([]*ApiTypes.AttachmentDef, error) {
+	db_type := ApiTypes.DBType
+	query := "SELECT " + attachments_field_names + " FROM " + AttachmentsTableName +
+		" WHERE owner_table = " + placeholder(db_type, 1) + " AND owner_id = " + placeholder(db_type, 2) +
+		" ORDER BY created_at, id"
+	rows, err := databaseutil.QueryWithRetry(rc.Context(), ApiTypes.SharedDBHandle, query, owner_table, owner_id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attachments (SHD_ATT_145): %w", err)
+	}
+	defer rows.Close()
+
+	list := []*ApiTypes.AttachmentDef{}
+	for rows.Next() {
+		def, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment (SHD_ATT_153): %w", err)
+		}
+		list = append(list, def)
+	}
+	return list, rows.Err()
+}
+
+// ListAttachmentsByBackend calls fn for every attachment stored on
+// 'backend'. It is used by the orphan sweep.
+func ListAttachmentsByBackend(
+	rc ApiTypes.RequestContext,
+	backend string,
+	fn func(*ApiTypes.AttachmentDef) error) error {
+	query := "SELECT " + attachments_field_names + " FROM " + AttachmentsTableName +
+		" WHERE storage_backend = " + placeholder(ApiTypes.DBType, 1)
+	rows, err := databaseutil.QueryWithRetry(rc.Context(), ApiTypes.SharedDBHandle, query, backend)
+	if err != nil {
+		return fmt.Errorf("failed to list attachments (SHD_ATT_170): %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		def, err := scanAttachment(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan attachment (SHD_ATT_177): %w", err)
+		}
+		if err := fn(def); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DeleteAttachment deletes the metadata row. It returns sql.ErrNoRows if
+// the attachment does not exist.
+func DeleteAttachment(rc ApiTypes.RequestContext, id string) error {
+	stmt := "DELETE FROM " + AttachmentsTableName + " WHERE id = " + placeholder(ApiTypes.DBType, 1)
+	result, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt, id)
+	if err != nil {
+		rc.GetLogger().Error("failed to delete attachment", "error", err, "id", id)
+		return fmt.Errorf("failed to delete attachment (SHD_ATT_193): %w", err)
+	}
+
+	num_rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected (SHD_ATT_198): %w", err)
+	}
+	if num_rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package sysdatastores
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+// TableConstraints are the table-level parts of a table created by
+// CreateTableFromFieldDefs. Each entry of Unique and Indexes is a list of
+// columns.
+type TableConstraints struct {
+	// PrimaryKey defaults to the "_auto_inc" field, if there is one
+	PrimaryKey []string
+	Unique     [][]string
+	Indexes    [][]string
+}
+
+// CreateTableFromFieldDefs creates 'table_name' (if it does not exist) with
+// one column per FieldDef, plus the constraints and indexes in
+// 'constraints'. Fields with DataType "_ignore" are not columns.
+func CreateTableFromFieldDefs(
+	logger ApiTypes.JimoLogger,
+	db *sql.DB,
+	db_type string,
+	table_name string,
+	field_defs []ApiTypes.FieldDef,
+	constraints TableConstraints) error {
+
+	logger.Info("Create table", "table_name", table_name)
+
+	stmts, err := CreateTableFromFieldDefsSQL(db_type, table_name, field_defs, constraints)
+	if err != nil {
+		logger.Error("failed generating create table statements", "error", err, "table_name", table_name)
+		return err
+	}
+
+	for _, stmt := range stmts {
+		if err := databaseutil.ExecuteStatement(db, stmt); err != nil {
+			logger.Error("failed creating table", "error", err, "stmt", stmt)
+			return fmt.Errorf("failed creating table (SHD_TFD_045), err: %w, stmt:%s", err, stmt)
+		}
+	}
+
+	logger.Info("Create table success", "table_name", table_name)
+	return nil
+}
+
+// CreateTableFromFieldDefsSQL returns the statements CreateTableFromFieldDefs
+// runs, without running them: the CREATE TABLE statement followed by the
+// index statements (on MySQL, indexes are part of CREATE TABLE).
+func CreateTableFromFieldDefsSQL(
+	db_type string,
+	table_name string,
+	field_defs []ApiTypes.FieldDef,
+	constraints TableConstraints) ([]string, error) {
+	if db_type != ApiTypes.MysqlName && db_type != ApiTypes.PgName {
+		return nil, fmt.Errorf("database type not supported:%s (SHD_TFD_062)", db_type)
+	}
+	if !databaseutil.IsValidTableName(table_name) {
+		return nil, fmt.Errorf("invalid table name:%s (SHD_TFD_065)", table_name)
+	}
+
+	columns := []string{}
+	known := make(map[string]bool)
+	auto_inc := ""
+	for _, f := range field_defs {
+		if f.DataType == "_ignore" {
+			continue
+		}
+		if !databaseutil.IsValidTableName(f.FieldName) {
+			return nil, fmt.Errorf("invalid field name:%s (SHD_TFD_075)", f.FieldName)
+		}
+		if known[f.FieldName] {
+			return nil, fmt.Errorf("duplicate field:%s (SHD_TFD_078)", f.FieldName)
+		}
+		known[f.FieldName] = true
+
+		col_type, err := ColumnTypeFromFieldDef(db_type, f)
+		if err != nil {
+			return nil, err
+		}
+		column := f.FieldName + " " + col_type
+		if f.DataType == "_auto_inc" {
+			auto_inc = f.FieldName
+		} else if f.Required {
+			column += " NOT NULL"
+		}
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns for table:%s (SHD_TFD_094)", table_name)
+	}
+
+	checkCols := func(cols []string) error {
+		if len(cols) == 0 {
+			return fmt.Errorf("empty column list (SHD_TFD_099)")
+		}
+		for _, col := range cols {
+			if !known[col] {
+				return fmt.Errorf("unknown column in constraint:%s (SHD_TFD_103)", col)
+			}
+		}
+		return nil
+	}
+
+	primary_key := constraints.PrimaryKey
+	if len(primary_key) == 0 && auto_inc != "" {
+		primary_key = []string{auto_inc}
+	}
+	if len(primary_key) > 0 {
+		if err := checkCols(primary_key); err != nil {
+			return nil, err
+		}
+		columns = append(columns, "PRIMARY KEY ("+strings.Join(primary_key, ", ")+")")
+	}
+
+	for _, cols := range constraints.Unique {
+		if err := checkCols(cols); err != nil {
+			return nil, err
+		}
+		name := "uq_" + table_name + "_" + strings.Join(cols, "_")
+		columns = append(columns, "CONSTRAINT "+name+" UNIQUE ("+strings.Join(cols, ", ")+")")
+	}
+
+	index_stmts := []string{}
+	for _, cols := range constraints.Indexes {
+		if err := checkCols(cols); err != nil {
+			return nil, err
+		}
+		name := "idx_" + table_name + "_" + strings.Join(cols, "_")
+		if db_type == ApiTypes.MysqlName {
+			columns = append(columns, "INDEX "+name+" ("+strings.Join(cols, ", ")+")")
+		} else {
+			index_stmts = append(index_stmts, "CREATE INDEX IF NOT EXISTS "+name+
+				" ON "+table_name+" ("+strings.Join(cols, ", ")+");")
+		}
+	}
+
+	stmt := "CREATE TABLE IF NOT EXISTS " + table_name + "(" + strings.Join(columns, ", ") + ")"
+	if db_type == ApiTypes.MysqlName {
+		stmt += " ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;"
+	}
+	return append([]string{stmt}, index_stmts...), nil
+}
+
+// ColumnTypeFromFieldDef returns the column type for a FieldDef. It accepts
+// the DataTypes the insert path converts values for (see
+// RequestHandlers.convertFieldValue). MySQL has no array type, so arrays
+// are stored as JSON there.
+func ColumnTypeFromFieldDef(db_type string, f ApiTypes.FieldDef) (string, error) {
+	is_pg := db_type == ApiTypes.PgName
+	pick := func(pg string, mysql string) (string, error) {
+		if is_pg {
+			return pg, nil
+		}
+		return mysql, nil
+	}
+
+	switch strings.ToLower(ApiTypes.FieldDataType(f)) {
+	case "text":
+		return "TEXT", nil
+
+	case "varchar", "char", "string":
+		return "VARCHAR(255)", nil
+
+	case "integer", "int", "int4":
+		return pick("INTEGER", "INT")
+
+	case "bigint", "int8":
+		return "BIGINT", nil
+
+	case "smallint", "int2":
+		return "SMALLINT", nil
+
+	case "real", "float4":
+		return pick("REAL", "FLOAT")
+
+	case "double precision", "float8":
+		return pick("DOUBLE PRECISION", "DOUBLE")
+
+	case "boolean", "bool":
+		return "BOOLEAN", nil
+
+	case "date":
+		return "DATE", nil
+
+	case "timestamp":
+		return "TIMESTAMP", nil
+
+	case "timestamptz":
+		return pick("TIMESTAMP WITH TIME ZONE", "TIMESTAMP")
+
+	case "json", "jsonb":
+		return pick("JSONB", "JSON")
+
+	case "text[]", "varchar[]", "string[]":
+		return pick("TEXT[]", "JSON")
+
+	case "integer[]", "int[]", "int4[]":
+		return pick("INTEGER[]", "JSON")
+
+	case "array":
+		switch f.ElementType {
+		case "string":
+			return pick("TEXT[]", "JSON")
+
+		case "int32":
+			return pick("INTEGER[]", "JSON")
+
+		case "int64":
+			return pick("BIGINT[]", "JSON")
+		}
+		return "", fmt.Errorf("array element data type not supported:%s, field:%s (SHD_TFD_210)",
+			f.ElementType, f.FieldName)
+
+	case "_creator", "_updater":
+		return "VARCHAR(64)", nil
+
+	case "_auto_inc":
+		return pick("BIGSERIAL", "BIGINT AUTO_INCREMENT")
+	}
+
+	return "", fmt.Errorf("data type not supported:%s, field:%s (SHD_TFD_219)", f.DataType, f.FieldName)
+}
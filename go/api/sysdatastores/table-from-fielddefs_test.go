@@ -0,0 +1,89 @@
+package sysdatastores
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+)
+
+var testFieldDefs = []ApiTypes.FieldDef{
+	{FieldName: "id", DataType: "_auto_inc"},
+	{FieldName: "name", DataType: "string", Required: true},
+	{FieldName: "score", DataType: "float8"},
+	{FieldName: "tags", DataType: "array", ElementType: "string"},
+	{FieldName: "meta", DataType: "jsonb"},
+	{FieldName: "scratch", DataType: "_ignore"},
+	{FieldName: "creator", DataType: "_creator"},
+}
+
+func TestCreateTableFromFieldDefsSQL(t *testing.T) {
+	constraints := TableConstraints{
+		Unique:  [][]string{{"name"}},
+		Indexes: [][]string{{"creator", "name"}},
+	}
+
+	stmts, err := CreateTableFromFieldDefsSQL(ApiTypes.PgName, "scores", testFieldDefs, constraints)
+	if err != nil {
+		t.Fatalf("pg: %v", err)
+	}
+	want := "CREATE TABLE IF NOT EXISTS scores(id BIGSERIAL, name VARCHAR(255) NOT NULL, " +
+		"score DOUBLE PRECISION, tags TEXT[], meta JSONB, creator VARCHAR(64), " +
+		"PRIMARY KEY (id), CONSTRAINT uq_scores_name UNIQUE (name))"
+	if len(stmts) != 2 || stmts[0] != want {
+		t.Fatalf("pg: unexpected statements:\n%s", strings.Join(stmts, "\n"))
+	}
+	if stmts[1] != "CREATE INDEX IF NOT EXISTS idx_scores_creator_name ON scores (creator, name);" {
+		t.Errorf("pg: unexpected index: %s", stmts[1])
+	}
+
+	stmts, err = CreateTableFromFieldDefsSQL(ApiTypes.MysqlName, "scores", testFieldDefs, constraints)
+	if err != nil {
+		t.Fatalf("mysql: %v", err)
+	}
+	if len(stmts) != 1 ||
+		!strings.Contains(stmts[0], "id BIGINT AUTO_INCREMENT, ") ||
+		!strings.Contains(stmts[0], "tags JSON, meta JSON, ") ||
+		!strings.Contains(stmts[0], "INDEX idx_scores_creator_name (creator, name)") {
+		t.Fatalf("mysql: unexpected statements:\n%s", strings.Join(stmts, "\n"))
+	}
+}
+
+func TestCreateTableFromFieldDefsSQLRejectsBadInput(t *testing.T) {
+	cases := map[string]struct {
+		table       string
+		defs        []ApiTypes.FieldDef
+		constraints TableConstraints
+	}{
+		"bad table name": {"scores; DROP", testFieldDefs, TableConstraints{}},
+		"bad field name": {"scores", []ApiTypes.FieldDef{{FieldName: "a b", DataType: "text"}}, TableConstraints{}},
+		"unknown type":   {"scores", []ApiTypes.FieldDef{{FieldName: "a", DataType: "blob"}}, TableConstraints{}},
+		"unknown index":  {"scores", testFieldDefs, TableConstraints{Indexes: [][]string{{"missing"}}}},
+		"ignored column": {"scores", testFieldDefs, TableConstraints{Unique: [][]string{{"scratch"}}}},
+	}
+	for name, c := range cases {
+		if _, err := CreateTableFromFieldDefsSQL(ApiTypes.PgName, c.table, c.defs, c.constraints); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestColumnTypeFromFieldDefTimestampKind(t *testing.T) {
+	cases := []struct {
+		def  ApiTypes.FieldDef
+		want string
+	}{
+		{ApiTypes.FieldDef{FieldName: "a", DataType: "timestamp"}, "TIMESTAMP"},
+		{ApiTypes.FieldDef{FieldName: "a", DataType: "timestamptz"}, "TIMESTAMP WITH TIME ZONE"},
+		{ApiTypes.FieldDef{FieldName: "a", DataType: "timestamp", TimestampKind: ApiTypes.TimestampKindTZ},
+			"TIMESTAMP WITH TIME ZONE"},
+		{ApiTypes.FieldDef{FieldName: "a", DataType: "timestamptz", TimestampKind: ApiTypes.TimestampKindNaive},
+			"TIMESTAMP"},
+	}
+	for _, c := range cases {
+		got, err := ColumnTypeFromFieldDef(ApiTypes.PgName, c.def)
+		if err != nil || got != c.want {
+			t.Errorf("%s/%s: got %q, %v, want %q", c.def.DataType, c.def.TimestampKind, got, err, c.want)
+		}
+	}
+}
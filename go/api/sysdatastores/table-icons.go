@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"strings"
 
 	"github.com/chendingplano/shared/go/api/ApiTypes"
@@ -347,6 +348,9 @@ func GetIconByFileName(
 	return icon, nil
 }
 
+// iconPageLimits are the page size default and cap of ListIcons
+var iconPageLimits = PageLimits{Default: 50, Max: 200}
+
 // ListIcons retrieves icons with optional filters and pagination
 func ListIcons(
 	rc ApiTypes.RequestContext,
@@ -386,43 +390,19 @@ func ListIcons(
 			fmt.Sprintf("(name ILIKE $%d OR tags::text ILIKE $%d)", paramIndex, paramIndex+1))
 		searchPattern := "%" + req.Search + "%"
 		args = append(args, searchPattern, searchPattern)
-		paramIndex += 2
-	}
-
-	whereClause := ""
-	if len(whereClauses) > 0 {
-		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
-	}
-
-	// Count total records
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s %s", IconsTableName, whereClause)
-	var total int
-	err := db.QueryRow(countQuery, args...).Scan(&total)
-	if err != nil {
-		logger.Error("failed to count icons", "error", err)
-		return nil, 0, fmt.Errorf("failed to count icons (SHD_ICN_375): %w", err)
 	}
 
 	// Get paginated results
-	pageSize := req.PageSize
-	if pageSize <= 0 {
-		pageSize = 50
-	}
-	if pageSize > 200 {
-		pageSize = 200
-	}
-
-	offset := req.Page * pageSize
-
-	query := fmt.Sprintf("SELECT %s FROM %s %s ORDER BY created_at DESC LIMIT $%d OFFSET $%d",
-		Icons_selected_field_names, IconsTableName, whereClause, paramIndex, paramIndex+1)
-	args = append(args, pageSize, offset)
-
-	rows, err := db.Query(query, args...)
+	pageSize := iconPageLimits.Clamp(req.PageSize)
+	rows, total64, err := PaginatedQuery(rc.Context(), db,
+		fmt.Sprintf("SELECT %s FROM %s", Icons_selected_field_names, IconsTableName),
+		strings.Join(whereClauses, " AND "), args,
+		"created_at DESC", req.Page*pageSize, pageSize, iconPageLimits)
 	if err != nil {
 		logger.Error("failed to query icons", "error", err)
 		return nil, 0, fmt.Errorf("failed to query icons (SHD_ICN_394): %w", err)
 	}
+	total := int(total64)
 	defer rows.Close()
 
 	var iconsList []*ApiTypes.IconDef
@@ -537,6 +517,58 @@ func UpdateIcon(
 	return icon, nil
 }
 
+// GenerateThumbnail produces (or reuses) a resized copy of a raster icon whose
+// longest side is at most maxDim and returns the icon with the thumbnail's
+// file name. Each maxDim has its own thumbnail file, regenerated when the
+// original changes. SVG icons and icons already within maxDim have no
+// thumbnail (an empty name), so callers should serve the original file for them.
+// Returns nil, "", nil if the icon does not exist.
+func GenerateThumbnail(
+	rc ApiTypes.RequestContext,
+	iconID string,
+	maxDim int) (*ApiTypes.IconDef, string, error) {
+	logger := rc.GetLogger()
+
+	if maxDim <= 0 || maxDim > ApiTypes.MaxIconThumbnailDim {
+		return nil, "", fmt.Errorf("invalid thumbnail dimension (SHD_ICN_615): %d", maxDim)
+	}
+
+	icon, err := GetIconByID(rc, iconID)
+	if err != nil || icon == nil {
+		return icon, "", err
+	}
+
+	if !NeedsThumbnail(icon, maxDim) {
+		return icon, "", nil
+	}
+
+	if ApiTypes.DefaultIconService == nil {
+		logger.Error("icon service not initialized")
+		return nil, "", fmt.Errorf("icon service not initialized (SHD_ICN_629)")
+	}
+
+	relPath, _, err := ApiTypes.DefaultIconService.GenerateThumbnail(rc, icon, maxDim, false)
+	if err != nil {
+		logger.Error("failed to generate thumbnail", "error", err, "id", iconID)
+		return nil, "", fmt.Errorf("failed to generate thumbnail (SHD_ICN_635): %w", err)
+	}
+
+	return icon, filepath.Base(relPath), nil
+}
+
+// NeedsThumbnail reports whether an icon should be served through a resized copy.
+// SVGs scale natively and small rasters are already cheap to load.
+func NeedsThumbnail(icon *ApiTypes.IconDef, maxDim int) bool {
+	if icon.MimeType == "image/svg+xml" {
+		return false
+	}
+	if icon.Width != nil && icon.Height != nil &&
+		*icon.Width <= maxDim && *icon.Height <= maxDim {
+		return false
+	}
+	return true
+}
+
 // DeleteIcon deletes an icon by ID
 func DeleteIcon(
 	rc ApiTypes.RequestContext,
@@ -0,0 +1,101 @@
+package sysdatastores
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/icons"
+	"github.com/chendingplano/shared/go/api/loggerutil"
+)
+
+// Each size gets its own thumbnail, so asking for another size doesn't
+// replace the first one
+func TestGenerateThumbnailPerDimension(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	category_dir := filepath.Join(dir, "icons", "logos")
+	if err := os.MkdirAll(category_dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(category_dir, "abc.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewRGBA(image.Rect(0, 0, 100, 50))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	saved_db, saved_type, saved_service := ApiTypes.SharedDBHandle, ApiTypes.DBType, ApiTypes.DefaultIconService
+	ApiTypes.SharedDBHandle, ApiTypes.DBType = db, ApiTypes.PgName
+	ApiTypes.DefaultIconService = icons.NewIconService(dir)
+	defer func() {
+		ApiTypes.SharedDBHandle, ApiTypes.DBType, ApiTypes.DefaultIconService = saved_db, saved_type, saved_service
+	}()
+
+	rc := testRC{logger: loggerutil.CreateDefaultLogger("SHD_ICN_T01")}
+	query := "SELECT " + Icons_selected_field_names + " FROM icons WHERE id = $1"
+	expectIcon := func(id, file_name, mime_type string, width, height int) {
+		now := time.Now()
+		mock.ExpectQuery(query).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{
+			"id", "name", "category", "file_name", "file_path", "mime_type", "file_size", "width", "height",
+			"tags", "description", "creator", "updater", "created_at", "updated_at",
+		}).AddRow(id, "abc", "logos", file_name, "icons/logos/"+file_name, mime_type, 100, width, height,
+			[]byte(`[]`), nil, "admin", "admin", now, now))
+	}
+
+	thumbnails := make(map[int]string)
+	for _, dim := range []int{64, 32, 64} {
+		expectIcon("icon-1", "abc.png", "image/png", 100, 50)
+		icon, thumb_name, err := GenerateThumbnail(rc, "icon-1", dim)
+		if err != nil || icon == nil {
+			t.Fatalf("GenerateThumbnail(%d) = %v, %v", dim, icon, err)
+		}
+		if prev, ok := thumbnails[dim]; ok && prev != thumb_name {
+			t.Errorf("dim %d: thumbnail %s, was %s", dim, thumb_name, prev)
+		}
+		thumbnails[dim] = thumb_name
+	}
+	if thumbnails[64] == thumbnails[32] {
+		t.Fatalf("dims 64 and 32 share the thumbnail %s", thumbnails[64])
+	}
+	for dim, name := range thumbnails {
+		f, err := os.Open(filepath.Join(category_dir, name))
+		if err != nil {
+			t.Fatalf("dim %d: %v", dim, err)
+		}
+		cfg, err := png.DecodeConfig(f)
+		f.Close()
+		if err != nil || cfg.Width != dim || cfg.Height != dim/2 {
+			t.Errorf("dim %d: thumbnail is %dx%d, %v", dim, cfg.Width, cfg.Height, err)
+		}
+	}
+
+	// SVGs and icons within the size are served as is
+	expectIcon("icon-2", "logo.svg", "image/svg+xml", 0, 0)
+	if _, thumb_name, err := GenerateThumbnail(rc, "icon-2", 64); err != nil || thumb_name != "" {
+		t.Errorf("svg thumbnail = %q, %v; want none", thumb_name, err)
+	}
+	expectIcon("icon-1", "abc.png", "image/png", 100, 50)
+	if _, thumb_name, err := GenerateThumbnail(rc, "icon-1", 128); err != nil || thumb_name != "" {
+		t.Errorf("thumbnail within the size = %q, %v; want none", thumb_name, err)
+	}
+
+	if _, _, err := GenerateThumbnail(rc, "icon-1", ApiTypes.MaxIconThumbnailDim+1); err == nil {
+		t.Error("accepted a dimension over the maximum")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
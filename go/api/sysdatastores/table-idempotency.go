@@ -0,0 +1,197 @@
+package sysdatastores
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+const IdempotencyKeysTableName = "jimo_idempotency_keys"
+
+// IdempotencyKeyDef is a row of jimo_idempotency_keys: the outcome of the
+// request a user sent with an idempotency key. StatusCode is 0 while the
+// request is still running; ExpiresAt is then the end of its lease.
+type IdempotencyKeyDef struct {
+	UserName    string    `json:"user_name"`
+	IdemKey     string    `json:"idem_key"`
+	RequestHash string    `json:"request_hash"`
+	TableName   string    `json:"table_name"`
+	StatusCode  int       `json:"status_code"`
+	Response    string    `json:"response"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// CreateIdempotencyKeysTable creates jimo_idempotency_keys. Keys are
+// scoped per user.
+func CreateIdempotencyKeysTable(
+	logger ApiTypes.JimoLogger,
+	db *sql.DB,
+	db_type string) error {
+	logger.Info("Create table", "table_name", IdempotencyKeysTableName)
+
+	fields := "user_name          VARCHAR(255)    NOT NULL, " +
+		"idem_key           VARCHAR(255)    NOT NULL, " +
+		"request_hash       VARCHAR(64)     NOT NULL, " +
+		"table_name         VARCHAR(255)    NOT NULL, " +
+		"status_code        INT             NOT NULL DEFAULT 0, " +
+		"response           TEXT, " +
+		"created_at         TIMESTAMP       NOT NULL, " +
+		"expires_at         TIMESTAMP       NOT NULL, " +
+		"PRIMARY KEY (user_name, idem_key)"
+
+	var stmts []string
+	switch db_type {
+	case ApiTypes.MysqlName:
+		stmts = []string{
+			"CREATE TABLE IF NOT EXISTS " + IdempotencyKeysTableName + "(" + fields +
+				", INDEX idx_idem_keys_expires_at (expires_at)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;",
+		}
+
+	case ApiTypes.PgName:
+		stmts = []string{
+			"CREATE TABLE IF NOT EXISTS " + IdempotencyKeysTableName + "(" + fields + ")",
+			"CREATE INDEX IF NOT EXISTS idx_idem_keys_expires_at ON " + IdempotencyKeysTableName + " (expires_at);",
+		}
+
+	default:
+		err := fmt.Errorf("database type not supported:%s (SHD_IDK_061)", db_type)
+		logger.Error("db_type not supported", "db_type", db_type)
+		return err
+	}
+
+	for _, stmt := range stmts {
+		if err := databaseutil.ExecuteStatement(db, stmt); err != nil {
+			logger.Error("failed creating table", "error", err, "stmt", stmt)
+			return fmt.Errorf("failed creating table (SHD_IDK_068), err: %w, stmt:%s", err, stmt)
+		}
+	}
+
+	logger.Info("Create table success", "table_name", IdempotencyKeysTableName)
+	return nil
+}
+
+// ReserveIdempotencyKey records that 'def' (with StatusCode 0) is
+// running, until def.ExpiresAt. It returns true if the key was free, or
+// expired: a completed key past its TTL, or a running one past its lease,
+// whose outcome was never recorded. Otherwise it
+// returns false and the row of the key, which the caller replays or
+// reports as in progress.
+func ReserveIdempotencyKey(rc ApiTypes.RequestContext, def IdempotencyKeyDef) (bool, *IdempotencyKeyDef, error) {
+	db_type := ApiTypes.DBType
+	var insert_stmt string
+	switch db_type {
+	case ApiTypes.MysqlName:
+		insert_stmt = "INSERT IGNORE INTO " + IdempotencyKeysTableName +
+			" (user_name, idem_key, request_hash, table_name, status_code, created_at, expires_at) " +
+			"VALUES (?, ?, ?, ?, 0, ?, ?)"
+
+	case ApiTypes.PgName:
+		insert_stmt = "INSERT INTO " + IdempotencyKeysTableName +
+			" (user_name, idem_key, request_hash, table_name, status_code, created_at, expires_at) " +
+			"VALUES ($1, $2, $3, $4, 0, $5, $6) ON CONFLICT (user_name, idem_key) DO NOTHING"
+
+	default:
+		return false, nil, fmt.Errorf("unsupported database type (SHD_IDK_093): %s", db_type)
+	}
+	expired_stmt := "DELETE FROM " + IdempotencyKeysTableName +
+		" WHERE user_name = " + placeholder(db_type, 1) + " AND idem_key = " + placeholder(db_type, 2) +
+		" AND expires_at <= " + placeholder(db_type, 3)
+
+	db := ApiTypes.SharedDBHandle
+	ctx := rc.Context()
+	if _, err := databaseutil.ExecWithRetry(ctx, db, expired_stmt, def.UserName, def.IdemKey, def.CreatedAt); err != nil {
+		return false, nil, fmt.Errorf("failed to delete expired idempotency key (SHD_IDK_102): %w", err)
+	}
+	result, err := databaseutil.ExecWithRetry(ctx, db, insert_stmt, def.UserName, def.IdemKey,
+		def.RequestHash, def.TableName, def.CreatedAt, def.ExpiresAt)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to reserve idempotency key (SHD_IDK_107): %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		return true, nil, nil
+	}
+
+	existing, err := GetIdempotencyKey(rc, def.UserName, def.IdemKey)
+	if err != nil {
+		return false, nil, err
+	}
+	if existing == nil {
+		// Released or expired in between; the caller may try again
+		return false, nil, fmt.Errorf("idempotency key vanished (SHD_IDK_119)")
+	}
+	return false, existing, nil
+}
+
+// GetIdempotencyKey returns the row of 'idem_key' of 'user_name', or nil.
+func GetIdempotencyKey(rc ApiTypes.RequestContext, user_name string, idem_key string) (*IdempotencyKeyDef, error) {
+	db_type := ApiTypes.DBType
+	query := "SELECT user_name, idem_key, request_hash, table_name, status_code, response, created_at, expires_at " +
+		"FROM " + IdempotencyKeysTableName +
+		" WHERE user_name = " + placeholder(db_type, 1) + " AND idem_key = " + placeholder(db_type, 2)
+
+	var def IdempotencyKeyDef
+	var response sql.NullString
+	err := databaseutil.QueryRowWithRetry(rc.Context(), ApiTypes.SharedDBHandle, query,
+		[]interface{}{user_name, idem_key},
+		&def.UserName, &def.IdemKey, &def.RequestHash, &def.TableName, &def.StatusCode,
+		&response, &def.CreatedAt, &def.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read idempotency key (SHD_IDK_140): %w", err)
+	}
+	def.Response = response.String
+	return &def, nil
+}
+
+// CompleteIdempotencyKey records the outcome of the request of a key
+// reserved by ReserveIdempotencyKey, to be kept until 'expires_at'.
+func CompleteIdempotencyKey(
+	rc ApiTypes.RequestContext,
+	user_name string,
+	idem_key string,
+	status_code int,
+	response string,
+	expires_at time.Time) error {
+	db_type := ApiTypes.DBType
+	stmt := "UPDATE " + IdempotencyKeysTableName +
+		" SET status_code = " + placeholder(db_type, 1) + ", response = " + placeholder(db_type, 2) +
+		", expires_at = " + placeholder(db_type, 3) +
+		" WHERE user_name = " + placeholder(db_type, 4) + " AND idem_key = " + placeholder(db_type, 5)
+	if _, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt,
+		status_code, response, expires_at, user_name, idem_key); err != nil {
+		return fmt.Errorf("failed to complete idempotency key (SHD_IDK_159): %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes a key, e.g. of a request that failed and
+// may be retried.
+func ReleaseIdempotencyKey(rc ApiTypes.RequestContext, user_name string, idem_key string) error {
+	db_type := ApiTypes.DBType
+	stmt := "DELETE FROM " + IdempotencyKeysTableName +
+		" WHERE user_name = " + placeholder(db_type, 1) + " AND idem_key = " + placeholder(db_type, 2)
+	if _, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt, user_name, idem_key); err != nil {
+		return fmt.Errorf("failed to release idempotency key (SHD_IDK_171): %w", err)
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys deletes the keys expired at 'now' and returns how
+// many were deleted.
+func PurgeIdempotencyKeys(rc ApiTypes.RequestContext, now time.Time) (int64, error) {
+	stmt := "DELETE FROM " + IdempotencyKeysTableName +
+		" WHERE expires_at <= " + placeholder(ApiTypes.DBType, 1)
+	result, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys (SHD_IDK_182): %w", err)
+	}
+	return result.RowsAffected()
+}
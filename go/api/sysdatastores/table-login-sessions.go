@@ -10,6 +10,32 @@ import (
 	"github.com/chendingplano/shared/go/api/databaseutil"
 )
 
+// Login session statuses. SaveSession creates an active session and
+// EndSession (logout) makes it inactive; the session log records the logout
+// as SessionStatusLoggedOut.
+const (
+	SessionStatusActive    = "active"
+	SessionStatusInactive  = "inactive"
+	SessionStatusLoggedOut = "logged_out"
+)
+
+// LoginSession is a row of the login sessions table
+type LoginSession struct {
+	SessionID    string
+	LoginMethod  string
+	Status       string
+	UserName     string
+	UserNameType string
+	UserRegID    string
+	UserEmail    string
+	ExpiresAt    time.Time
+}
+
+// sessionTouchInterval is how stale last_activity_at may get: TouchSession
+// skips the write if the session was active more recently, so that a busy
+// client doesn't update its session on every request
+const sessionTouchInterval = time.Minute
+
 // CreateLoginSessionsTable creates the login sessions table.
 // SECURITY: Uses session_id as PRIMARY KEY to allow multiple sessions per user
 // (multi-device login). Previous versions used user_name as PK which forced
@@ -34,8 +60,10 @@ func CreateLoginSessionsTable(
 			"user_reg_id VARCHAR(255) DEFAULT NULL, " +
 			"user_email VARCHAR(255) DEFAULT NULL, " +
 			"expires_at TIMESTAMP NOT NULL, " +
+			"last_activity_at TIMESTAMP NULL DEFAULT NULL, " +
 			"created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, " +
 			"INDEX idx_expires (expires_at), " +
+			"INDEX idx_last_activity (last_activity_at), " +
 			"INDEX idx_user_id (user_id), " + // Added: index for user lookup
 			"INDEX idx_user_email (user_email) " + // Added: index for email lookup
 			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;"
@@ -52,6 +80,7 @@ func CreateLoginSessionsTable(
 			"user_reg_id VARCHAR(255) DEFAULT NULL, " +
 			"user_email VARCHAR(255) DEFAULT NULL, " +
 			"expires_at TIMESTAMP NOT NULL, " +
+			"last_activity_at TIMESTAMP DEFAULT NULL, " +
 			"created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW())"
 
 	default:
@@ -74,12 +103,20 @@ func CreateLoginSessionsTable(
 
 		idx3 := `CREATE INDEX IF NOT EXISTS idx_user_email ON ` + table_name + ` (user_email);`
 		databaseutil.ExecuteStatement(db, idx3)
+
+		databaseutil.ExecuteStatement(db, sessionsLastActivityIndexStmt(table_name))
 	}
 
 	logger.Info("Create table success", "table_name", table_name)
 	return nil
 }
 
+// sessionsLastActivityIndexStmt returns the PG index on last_activity_at,
+// to find idle sessions without scanning the table
+func sessionsLastActivityIndexStmt(table_name string) string {
+	return `CREATE INDEX IF NOT EXISTS idx_last_activity ON ` + table_name + ` (last_activity_at);`
+}
+
 // SaveSession creates a new session record.
 // SECURITY: Each login creates a NEW session (allows multi-device login).
 // Old sessions for the same user are NOT automatically invalidated.
@@ -111,22 +148,23 @@ func SaveSession(
 	case ApiTypes.MysqlName:
 		// Simple INSERT - session_id is PK, so each session is unique
 		stmt = fmt.Sprintf(`INSERT INTO %s (session_id, login_method, auth_token, status,
-                    user_id, user_name, user_name_type, user_reg_id, user_email, expires_at)
-              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, table_name)
+                    user_id, user_name, user_name_type, user_reg_id, user_email, expires_at, last_activity_at)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, table_name)
 
 	case ApiTypes.PgName:
 		// Simple INSERT - session_id is PK, so each session is unique
 		stmt = fmt.Sprintf(`INSERT INTO %s (session_id, login_method, auth_token, status,
-                    user_id, user_name, user_name_type, user_reg_id, user_email, expires_at)
-            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`, table_name)
+                    user_id, user_name, user_name_type, user_reg_id, user_email, expires_at, last_activity_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`, table_name)
 
 	default:
 		logger.Error("db_type not supported", "db_type", db_type)
 		return fmt.Errorf("unsupported database type (SHD_DBS_234): %s", db_type)
 	}
 
-	result, err := db.Exec(stmt, session_id, login_method, auth_token, "active",
-		user_id, user_name, user_name_type, user_reg_id, user_email, expiry)
+	login_time := time.Now()
+	result, err := databaseutil.ExecWithRetry(rc.Context(), db, stmt, session_id, login_method, auth_token, SessionStatusActive,
+		user_id, user_name, user_name_type, user_reg_id, user_email, expiry, login_time)
 	if err != nil {
 		logger.Error("failed save session",
 			"error", err,
@@ -156,6 +194,14 @@ func SaveSession(
 		"session_id", ApiUtils.MaskToken(session_id),
 		"user_email", user_email)
 
+	// Every login and email verification saves a session. Failing to
+	// record it doesn't fail the login.
+	if user_id != "" {
+		if err := UpdateLastLogin(rc, user_id, login_time); err != nil {
+			logger.Warn("failed to update last login", "error", err, "user_id", user_id)
+		}
+	}
+
 	if !need_update_user {
 		return nil
 	}
@@ -184,7 +230,7 @@ func DeleteUserSessions(rc ApiTypes.RequestContext, user_email string) error {
 		return err
 	}
 
-	result, err := db.Exec(stmt, user_email)
+	result, err := databaseutil.ExecWithRetry(rc.Context(), db, stmt, user_email)
 	if err != nil {
 		error_msg := fmt.Errorf("failed to delete user sessions (SHD_DBS_DEL_002), email:%s, err: %w",
 			user_email, err)
@@ -215,7 +261,7 @@ func DeleteSession(rc ApiTypes.RequestContext, session_id string) error {
 		return err
 	}
 
-	_, err := db.Exec(stmt, session_id)
+	_, err := databaseutil.ExecWithRetry(rc.Context(), db, stmt, session_id)
 	if err != nil {
 		error_msg := fmt.Errorf("failed to delete session (SHD_DBS_771), stmt:%s, session_id:%s, err: %w", stmt, session_id, err)
 		return error_msg
@@ -223,3 +269,168 @@ func DeleteSession(rc ApiTypes.RequestContext, session_id string) error {
 	logger.Info("Session deleted", "session_id", session_id)
 	return nil
 }
+
+// GetLoginSession returns the session 'session_id', active or not. It
+// returns an error wrapping sql.ErrNoRows if there is no such session.
+func GetLoginSession(rc ApiTypes.RequestContext, session_id string) (*LoginSession, error) {
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	var stmt string
+	db_type := ApiTypes.DBType
+	table_name := ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions
+
+	const fields = "session_id, login_method, status, user_name, user_name_type, user_reg_id, user_email, expires_at"
+	switch db_type {
+	case ApiTypes.MysqlName:
+		stmt = fmt.Sprintf("SELECT %s FROM %s WHERE session_id = ?", fields, table_name)
+
+	case ApiTypes.PgName:
+		stmt = fmt.Sprintf("SELECT %s FROM %s WHERE session_id = $1", fields, table_name)
+
+	default:
+		return nil, fmt.Errorf("unsupported database type (SHD_TLS_262): %s", db_type)
+	}
+
+	var login_method, status, user_name, user_name_type, user_reg_id, user_email sql.NullString
+	session := LoginSession{}
+	err := databaseutil.QueryRowWithRetry(rc.Context(), db, stmt, []interface{}{session_id},
+		&session.SessionID, &login_method, &status, &user_name, &user_name_type,
+		&user_reg_id, &user_email, &session.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session (SHD_TLS_271), session_id:%s, err: %w",
+			ApiUtils.MaskToken(session_id), err)
+	}
+	session.LoginMethod = login_method.String
+	session.Status = status.String
+	session.UserName = user_name.String
+	session.UserNameType = user_name_type.String
+	session.UserRegID = user_reg_id.String
+	session.UserEmail = user_email.String
+	return &session, nil
+}
+
+// IsValidSession returns true if the session 'session_id' exists, is
+// active and has not expired. A session ended by EndSession is not valid,
+// even if its cookie is presented again before it expires. With
+// LibConfig.SessionIdleTimeoutMinutes set, a session without a request
+// (see TouchSession) for that long is not valid either; sessions from
+// before last_activity_at was recorded only expire.
+func IsValidSession(rc ApiTypes.RequestContext, session_id string) (bool, error) {
+	if session_id == "" {
+		return false, nil
+	}
+
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	var stmt string
+	db_type := ApiTypes.DBType
+	table_name := ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions
+
+	switch db_type {
+	case ApiTypes.MysqlName:
+		stmt = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE session_id = ? AND status = ? AND expires_at > ?",
+			table_name)
+
+	case ApiTypes.PgName:
+		stmt = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE session_id = $1 AND status = $2 AND expires_at > $3",
+			table_name)
+
+	default:
+		return false, fmt.Errorf("unsupported database type (SHD_TLS_306): %s", db_type)
+	}
+
+	// expires_at and last_activity_at are compared with a Go time, as
+	// SaveSession and TouchSession wrote them
+	now := time.Now()
+	args := []interface{}{session_id, SessionStatusActive, now}
+	if idle := ApiTypes.LibConfig.SessionIdleTimeoutMinutes; idle > 0 {
+		stmt += fmt.Sprintf(" AND (last_activity_at IS NULL OR last_activity_at > %s)", placeholder(db_type, 4))
+		args = append(args, now.Add(-time.Duration(idle)*time.Minute))
+	}
+
+	var count int
+	err := databaseutil.QueryRowWithRetry(rc.Context(), db, stmt, args, &count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check session (SHD_TLS_314), session_id:%s, err: %w",
+			ApiUtils.MaskToken(session_id), err)
+	}
+	return count > 0, nil
+}
+
+// EndSession makes the session 'session_id' inactive, so that
+// IsValidSession rejects it from now on. The row is kept for auditing.
+// It returns false if the session did not exist or was not active.
+func EndSession(rc ApiTypes.RequestContext, session_id string) (bool, error) {
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	var stmt string
+	db_type := ApiTypes.DBType
+	table_name := ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions
+	logger := rc.GetLogger()
+
+	switch db_type {
+	case ApiTypes.MysqlName:
+		stmt = fmt.Sprintf("UPDATE %s SET status = ? WHERE session_id = ? AND status = ?", table_name)
+
+	case ApiTypes.PgName:
+		stmt = fmt.Sprintf("UPDATE %s SET status = $1 WHERE session_id = $2 AND status = $3", table_name)
+
+	default:
+		return false, fmt.Errorf("unsupported database type (SHD_TLS_337): %s", db_type)
+	}
+
+	result, err := databaseutil.ExecWithRetry(rc.Context(), db, stmt,
+		SessionStatusInactive, session_id, SessionStatusActive)
+	if err != nil {
+		return false, fmt.Errorf("failed to end session (SHD_TLS_343), session_id:%s, err: %w",
+			ApiUtils.MaskToken(session_id), err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected (SHD_TLS_349): %w", err)
+	}
+	logger.Info("Session ended",
+		"session_id", ApiUtils.MaskToken(session_id),
+		"ended", rowsAffected > 0)
+	return rowsAffected > 0, nil
+}
+
+// TouchSession records a request of the active session 'session_id' in
+// its last_activity_at, which the idle timeout of IsValidSession and the
+// last seen time of GetLastActivity use. It skips the write if the session
+// was touched less than sessionTouchInterval ago, so it is cheap to call
+// on every request.
+func TouchSession(rc ApiTypes.RequestContext, session_id string) error {
+	db_type := ApiTypes.DBType
+	table_name := ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions
+	stmt := fmt.Sprintf("UPDATE %s SET last_activity_at = %s WHERE session_id = %s AND status = %s "+
+		"AND (last_activity_at IS NULL OR last_activity_at < %s)", table_name,
+		placeholder(db_type, 1), placeholder(db_type, 2), placeholder(db_type, 3), placeholder(db_type, 4))
+
+	now := time.Now()
+	_, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt,
+		now, session_id, SessionStatusActive, now.Add(-sessionTouchInterval))
+	if err != nil {
+		return fmt.Errorf("failed to touch session (SHD_TLS_412), session_id:%s, err: %w",
+			ApiUtils.MaskToken(session_id), err)
+	}
+	return nil
+}
+
+// GetLastActivity returns the time of the last request of any session of
+// the user 'user_email', or nil if none was recorded.
+func GetLastActivity(rc ApiTypes.RequestContext, user_email string) (*time.Time, error) {
+	db_type := ApiTypes.DBType
+	table_name := ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions
+	stmt := fmt.Sprintf("SELECT MAX(last_activity_at) FROM %s WHERE user_email = %s",
+		table_name, placeholder(db_type, 1))
+
+	var last_activity sql.NullTime
+	err := databaseutil.QueryRowWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt,
+		[]interface{}{user_email}, &last_activity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last activity (SHD_TLS_430), email:%s, err: %w", user_email, err)
+	}
+	if !last_activity.Valid {
+		return nil, nil
+	}
+	return &last_activity.Time, nil
+}
@@ -0,0 +1,63 @@
+package sysdatastores
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/loggerutil"
+)
+
+func TestSessionIdleTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	saved_db, saved_type := ApiTypes.SharedDBHandle, ApiTypes.DBType
+	saved_table := ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions
+	saved_idle := ApiTypes.LibConfig.SessionIdleTimeoutMinutes
+	ApiTypes.SharedDBHandle, ApiTypes.DBType = db, ApiTypes.PgName
+	ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions = "login_sessions"
+	ApiTypes.LibConfig.SessionIdleTimeoutMinutes = 30
+	defer func() {
+		ApiTypes.SharedDBHandle, ApiTypes.DBType = saved_db, saved_type
+		ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions = saved_table
+		ApiTypes.LibConfig.SessionIdleTimeoutMinutes = saved_idle
+	}()
+
+	rc := testRC{logger: loggerutil.CreateDefaultLogger("SHD_TLS_T01")}
+
+	// A session idle for longer than the timeout is not valid
+	mock.ExpectQuery("SELECT COUNT(*) FROM login_sessions WHERE session_id = $1 AND status = $2 AND expires_at > $3 "+
+		"AND (last_activity_at IS NULL OR last_activity_at > $4)").
+		WithArgs("sess-1", SessionStatusActive, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	valid, err := IsValidSession(rc, "sess-1")
+	if err != nil || valid {
+		t.Errorf("IsValidSession = %v, %v; want false", valid, err)
+	}
+
+	mock.ExpectExec("UPDATE login_sessions SET last_activity_at = $1 WHERE session_id = $2 AND status = $3 "+
+		"AND (last_activity_at IS NULL OR last_activity_at < $4)").
+		WithArgs(sqlmock.AnyArg(), "sess-1", SessionStatusActive, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := TouchSession(rc, "sess-1"); err != nil {
+		t.Errorf("TouchSession: %v", err)
+	}
+
+	last := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery("SELECT MAX(last_activity_at) FROM login_sessions WHERE user_email = $1").
+		WithArgs("alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(last))
+	got, err := GetLastActivity(rc, "alice@example.com")
+	if err != nil || got == nil || !got.Equal(last) {
+		t.Errorf("GetLastActivity = %v, %v; want %v", got, err, last)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
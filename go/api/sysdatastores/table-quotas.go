@@ -0,0 +1,215 @@
+package sysdatastores
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+const (
+	QuotasTableName     = "jimo_quotas"
+	QuotaUsageTableName = "jimo_quota_usage"
+
+	QuotaScope_User  = "user"
+	QuotaScope_Table = "table"
+
+	// QuotaScopeDefault as scope_name sets the limits of every user (or
+	// table) that has no row of its own.
+	QuotaScopeDefault = "*"
+)
+
+// QuotaLimitDef is a row of jimo_quotas. A limit of 0 means unlimited.
+type QuotaLimitDef struct {
+	ScopeType        string `json:"scope_type"`
+	ScopeName        string `json:"scope_name"`
+	QueriesPerMinute int64  `json:"queries_per_minute"`
+	RowsPerHour      int64  `json:"rows_per_hour"`
+	WritesPerMinute  int64  `json:"writes_per_minute"`
+}
+
+// QuotaUsageDef is the usage of one metric by one user or table. Bucket
+// is the minute (Unix time / 60) the usage falls in; aggregated reports
+// leave it 0.
+type QuotaUsageDef struct {
+	ScopeType string `json:"scope_type"`
+	ScopeName string `json:"scope_name"`
+	Metric    string `json:"metric"`
+	Bucket    int64  `json:"bucket,omitempty"`
+	Count     int64  `json:"count"`
+}
+
+// CreateQuotasTables creates jimo_quotas (the configured limits) and
+// jimo_quota_usage (per-minute usage counts written by all instances).
+func CreateQuotasTables(
+	logger ApiTypes.JimoLogger,
+	db *sql.DB,
+	db_type string) error {
+	logger.Info("Create table", "table_name", QuotasTableName)
+
+	limits_fields := "scope_type         VARCHAR(16)     NOT NULL, " +
+		"scope_name         VARCHAR(255)    NOT NULL, " +
+		"queries_per_minute BIGINT          NOT NULL DEFAULT 0, " +
+		"rows_per_hour      BIGINT          NOT NULL DEFAULT 0, " +
+		"writes_per_minute  BIGINT          NOT NULL DEFAULT 0, " +
+		"updated_at         TIMESTAMP       DEFAULT CURRENT_TIMESTAMP, " +
+		"PRIMARY KEY (scope_type, scope_name)"
+
+	usage_fields := "scope_type         VARCHAR(16)     NOT NULL, " +
+		"scope_name         VARCHAR(255)    NOT NULL, " +
+		"metric             VARCHAR(32)     NOT NULL, " +
+		"bucket             BIGINT          NOT NULL, " +
+		"count              BIGINT          NOT NULL DEFAULT 0, " +
+		"PRIMARY KEY (scope_type, scope_name, metric, bucket)"
+
+	var stmts []string
+	switch db_type {
+	case ApiTypes.MysqlName:
+		stmts = []string{
+			"CREATE TABLE IF NOT EXISTS " + QuotasTableName + "(" + limits_fields +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;",
+			"CREATE TABLE IF NOT EXISTS " + QuotaUsageTableName + "(" + usage_fields +
+				", INDEX idx_quota_usage_bucket (bucket)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;",
+		}
+
+	case ApiTypes.PgName:
+		stmts = []string{
+			"CREATE TABLE IF NOT EXISTS " + QuotasTableName + "(" + limits_fields + ")",
+			"CREATE TABLE IF NOT EXISTS " + QuotaUsageTableName + "(" + usage_fields + ")",
+			"CREATE INDEX IF NOT EXISTS idx_quota_usage_bucket ON " + QuotaUsageTableName + " (bucket);",
+		}
+
+	default:
+		err := fmt.Errorf("database type not supported:%s (SHD_QTA_088)", db_type)
+		logger.Error("db_type not supported", "db_type", db_type)
+		return err
+	}
+
+	for _, stmt := range stmts {
+		if err := databaseutil.ExecuteStatement(db, stmt); err != nil {
+			logger.Error("failed creating table", "error", err, "stmt", stmt)
+			return fmt.Errorf("failed creating table (SHD_QTA_095), err: %w, stmt:%s", err, stmt)
+		}
+	}
+
+	logger.Info("Create table success", "table_name", QuotaUsageTableName)
+	return nil
+}
+
+// ListQuotaLimits returns all rows of jimo_quotas.
+func ListQuotaLimits(rc ApiTypes.RequestContext) ([]QuotaLimitDef, error) {
+	query := "SELECT scope_type, scope_name, queries_per_minute, rows_per_hour, writes_per_minute " +
+		"FROM " + QuotasTableName
+	rows, err := databaseutil.QueryWithRetry(rc.Context(), ApiTypes.SharedDBHandle, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quotas (SHD_QTA_109): %w", err)
+	}
+	defer rows.Close()
+
+	limits := []QuotaLimitDef{}
+	for rows.Next() {
+		var def QuotaLimitDef
+		if err := rows.Scan(&def.ScopeType, &def.ScopeName,
+			&def.QueriesPerMinute, &def.RowsPerHour, &def.WritesPerMinute); err != nil {
+			return nil, fmt.Errorf("failed to scan quota (SHD_QTA_118): %w", err)
+		}
+		limits = append(limits, def)
+	}
+	return limits, rows.Err()
+}
+
+// AddQuotaUsage adds the counts in 'usage' to jimo_quota_usage.
+func AddQuotaUsage(rc ApiTypes.RequestContext, usage []QuotaUsageDef) error {
+	if len(usage) == 0 {
+		return nil
+	}
+
+	var stmt string
+	switch ApiTypes.DBType {
+	case ApiTypes.MysqlName:
+		stmt = "INSERT INTO " + QuotaUsageTableName + " (scope_type, scope_name, metric, bucket, count) " +
+			"VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE count = count + VALUES(count)"
+
+	case ApiTypes.PgName:
+		stmt = "INSERT INTO " + QuotaUsageTableName + " (scope_type, scope_name, metric, bucket, count) " +
+			"VALUES ($1, $2, $3, $4, $5) ON CONFLICT (scope_type, scope_name, metric, bucket) " +
+			"DO UPDATE SET count = " + QuotaUsageTableName + ".count + EXCLUDED.count"
+
+	default:
+		return fmt.Errorf("unsupported database type (SHD_QTA_142): %s", ApiTypes.DBType)
+	}
+
+	db := ApiTypes.SharedDBHandle
+	return databaseutil.WithWriteRetry(rc.Context(), db, func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, u := range usage {
+			if _, err := tx.ExecContext(ctx, stmt, u.ScopeType, u.ScopeName, u.Metric, u.Bucket, u.Count); err != nil {
+				return fmt.Errorf("failed to add quota usage (SHD_QTA_155): %w", err)
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// GetQuotaUsageSince returns the per-minute usage rows from bucket
+// 'since_bucket' on, summed over all instances.
+func GetQuotaUsageSince(rc ApiTypes.RequestContext, since_bucket int64) ([]QuotaUsageDef, error) {
+	query := "SELECT scope_type, scope_name, metric, bucket, count FROM " + QuotaUsageTableName +
+		" WHERE bucket >= " + placeholder(ApiTypes.DBType, 1)
+	rows, err := databaseutil.QueryWithRetry(rc.Context(), ApiTypes.SharedDBHandle, query, since_bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota usage (SHD_QTA_168): %w", err)
+	}
+	defer rows.Close()
+
+	usage := []QuotaUsageDef{}
+	for rows.Next() {
+		var u QuotaUsageDef
+		if err := rows.Scan(&u.ScopeType, &u.ScopeName, &u.Metric, &u.Bucket, &u.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan quota usage (SHD_QTA_176): %w", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// QuotaUsageReport returns the usage per scope and metric from bucket
+// 'since_bucket' on, highest first.
+func QuotaUsageReport(rc ApiTypes.RequestContext, since_bucket int64) ([]QuotaUsageDef, error) {
+	query := "SELECT scope_type, scope_name, metric, SUM(count) AS total FROM " + QuotaUsageTableName +
+		" WHERE bucket >= " + placeholder(ApiTypes.DBType, 1) +
+		" GROUP BY scope_type, scope_name, metric ORDER BY total DESC"
+	rows, err := databaseutil.QueryWithRetry(rc.Context(), ApiTypes.SharedDBHandle, query, since_bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota usage report (SHD_QTA_191): %w", err)
+	}
+	defer rows.Close()
+
+	report := []QuotaUsageDef{}
+	for rows.Next() {
+		var u QuotaUsageDef
+		if err := rows.Scan(&u.ScopeType, &u.ScopeName, &u.Metric, &u.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan quota usage report (SHD_QTA_199): %w", err)
+		}
+		report = append(report, u)
+	}
+	return report, rows.Err()
+}
+
+// PurgeQuotaUsage deletes usage rows before bucket 'before_bucket'.
+func PurgeQuotaUsage(rc ApiTypes.RequestContext, before_bucket int64) (int64, error) {
+	stmt := "DELETE FROM " + QuotaUsageTableName + " WHERE bucket < " + placeholder(ApiTypes.DBType, 1)
+	result, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt, before_bucket)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge quota usage (SHD_QTA_211): %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -11,7 +11,7 @@ import (
 )
 
 const (
-	resource_store_selected_field_names = "resource_id, 	 	resource_desc,    resource_type, " +
+	resource_store_selected_field_names = "resource_id,       resource_name,  resource_opr,    resource_desc,    resource_type, " +
 		"db_name,           table_name,     resource_status, resource_remarks, resource_def, " +
 		"query_conds,       error_msg"
 
@@ -59,9 +59,10 @@ func CreateResourcesTable(
 	switch db_type {
 	case ApiTypes.MysqlName:
 		stmt = "CREATE TABLE IF NOT EXISTS " + table_name + "(" +
-			"resource_id  NOT NULL AUTO_INCREMENT PRIMARY KEY, " + fields_1 +
+			"resource_id  BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY, " + fields_1 +
 			"resource_def 		JSON     	 	NOT NULL, " +
-			"query_conds        JSON     	 	DEFAULT NULL, " + fields_2 +
+			"query_conds        JSON     	 	DEFAULT NULL, " +
+			"UNIQUE KEY uk_resource_name_opr (resource_name, resource_opr), " + fields_2 +
 			"ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;"
 
 	case ApiTypes.PgName:
@@ -86,6 +87,13 @@ func CreateResourcesTable(
 	if db_type == ApiTypes.PgName {
 		idx1 := `CREATE INDEX IF NOT EXISTS idx_created_at ON ` + table_name + ` (created_at);`
 		databaseutil.ExecuteStatement(db, idx1)
+
+		// SaveResource upserts on (resource_name, resource_opr)
+		idx2 := `CREATE UNIQUE INDEX IF NOT EXISTS idx_` + table_name + `_name_opr ON ` +
+			table_name + ` (resource_name, resource_opr);`
+		if err := databaseutil.ExecuteStatement(db, idx2); err != nil {
+			logger.Error("failed creating unique index", "error", err, "table_name", table_name)
+		}
 	}
 
 	logger.Info("Create table success", "table_name", table_name)
@@ -101,8 +109,7 @@ func GetResourceStoreTableDesc() string {
 // Resources are identified by resource_name and resource_opr.
 // Returns error if not found or other errors.
 // Otherwise returns the resource record.
-func GetResourceByName(rc ApiTypes.RequestContext, resource_name string, resource_action string) (ApiTypes.ResourceDef, error) {
-	// This function retrieves a prompt record by prompt_name.
+func GetResourceByName(rc ApiTypes.RequestContext, resource_name string, resource_opr string) (ApiTypes.ResourceDef, error) {
 	var query string
 	var db *sql.DB = ApiTypes.SharedDBHandle
 	db_type := ApiTypes.DBType
@@ -110,11 +117,11 @@ func GetResourceByName(rc ApiTypes.RequestContext, resource_name string, resourc
 	var resource_info ApiTypes.ResourceDef
 	switch db_type {
 	case ApiTypes.MysqlName:
-		query = fmt.Sprintf("SELECT %s FROM %s WHERE resource_name = ? AND resource_action = ? LIMIT 1",
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE resource_name = ? AND resource_opr = ? LIMIT 1",
 			resource_store_selected_field_names, table_name)
 
 	case ApiTypes.PgName:
-		query = fmt.Sprintf("SELECT %s FROM %s WHERE resource_name = $1 AND resource_action = $2 LIMIT 1",
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE resource_name = $1 AND resource_opr = $2 LIMIT 1",
 			resource_store_selected_field_names, table_name)
 
 	default:
@@ -123,27 +130,210 @@ func GetResourceByName(rc ApiTypes.RequestContext, resource_name string, resourc
 		return resource_info, err
 	}
 
-	// Query the database for dashboard data
+	err := scanResourceRow(db.QueryRow(query, resource_name, resource_opr), &resource_info)
+	if err != nil {
+		error_msg := fmt.Sprintf("database error:%v (SHD_RSC_133)", err)
+		log.Printf("%s", error_msg)
+		return resource_info, err
+	}
+
+	return resource_info, nil
+}
+
+// ListResources returns all resource records, ordered by name and operation.
+func ListResources(rc ApiTypes.RequestContext) ([]ApiTypes.ResourceDef, error) {
+	logger := rc.GetLogger()
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	table_name := ApiTypes.LibConfig.SystemTableNames.TableNameResources
+	query := fmt.Sprintf("SELECT %s FROM %s ORDER BY resource_name, resource_opr",
+		resource_store_selected_field_names, table_name)
+
+	rows, err := db.Query(query)
+	if err != nil {
+		logger.Error("failed to list resources", "error", err)
+		return nil, fmt.Errorf("failed to list resources (SHD_RSC_190): %w", err)
+	}
+	defer rows.Close()
+
+	var resources []ApiTypes.ResourceDef
+	for rows.Next() {
+		var resource_info ApiTypes.ResourceDef
+		if err := scanResourceRow(rows, &resource_info); err != nil {
+			logger.Error("failed to scan resource", "error", err)
+			return nil, fmt.Errorf("failed to scan resource (SHD_RSC_198): %w", err)
+		}
+		resources = append(resources, resource_info)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate resources (SHD_RSC_204): %w", err)
+	}
+	return resources, nil
+}
+
+// SaveResource inserts or updates the resource identified by
+// (resource_name, resource_opr) and returns its resource_id.
+func SaveResource(
+	rc ApiTypes.RequestContext,
+	resource_info *ApiTypes.ResourceDef,
+	user_name string) (int64, error) {
+	logger := rc.GetLogger()
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	db_type := ApiTypes.DBType
+	table_name := ApiTypes.LibConfig.SystemTableNames.TableNameResources
+
+	resource_json := resource_info.ResourceJSON
+	if resource_json == nil {
+		resource_json = map[string]interface{}{}
+	}
+	resource_def_bytes, err := json.Marshal(resource_json)
+	if err != nil {
+		return 0, fmt.Errorf("invalid resource_def (SHD_RSC_224): %w", err)
+	}
+
+	var query_conds interface{}
+	if resource_info.QueryCondsJSON != nil {
+		query_conds_bytes, err := json.Marshal(resource_info.QueryCondsJSON)
+		if err != nil {
+			return 0, fmt.Errorf("invalid query_conds (SHD_RSC_230): %w", err)
+		}
+		query_conds = string(query_conds_bytes)
+	}
+
+	args := []interface{}{
+		resource_info.ResourceName,
+		resource_info.ResourceOpr,
+		resource_info.ResourceDesc,
+		resource_info.ResourceType,
+		resource_info.DBName,
+		resource_info.TableName,
+		resource_info.ResourceStatus,
+		resource_info.ResourceRemarks,
+		string(resource_def_bytes),
+		query_conds,
+		"SHD_RSC_245",
+		user_name,
+		user_name,
+	}
+
+	var resource_id int64
+	switch db_type {
+	case ApiTypes.MysqlName:
+		stmt := fmt.Sprintf("INSERT INTO %s (resource_name, resource_opr, resource_desc, resource_type, "+
+			"db_name, table_name, resource_status, resource_remarks, resource_def, query_conds, "+
+			"loc, creator, updater) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE resource_id = LAST_INSERT_ID(resource_id), "+
+			"resource_desc = VALUES(resource_desc), resource_type = VALUES(resource_type), "+
+			"db_name = VALUES(db_name), table_name = VALUES(table_name), "+
+			"resource_status = VALUES(resource_status), resource_remarks = VALUES(resource_remarks), "+
+			"resource_def = VALUES(resource_def), query_conds = VALUES(query_conds), "+
+			"error_msg = NULL, updater = VALUES(updater), updated_at = CURRENT_TIMESTAMP", table_name)
+		result, err := db.Exec(stmt, args...)
+		if err != nil {
+			logger.Error("failed to save resource", "error", err, "r_name", resource_info.ResourceName)
+			return 0, fmt.Errorf("failed to save resource (SHD_RSC_263): %w", err)
+		}
+		resource_id, err = result.LastInsertId()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get resource_id (SHD_RSC_267): %w", err)
+		}
+
+	case ApiTypes.PgName:
+		stmt := fmt.Sprintf("INSERT INTO %s (resource_name, resource_opr, resource_desc, resource_type, "+
+			"db_name, table_name, resource_status, resource_remarks, resource_def, query_conds, "+
+			"loc, creator, updater) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) "+
+			"ON CONFLICT (resource_name, resource_opr) DO UPDATE SET "+
+			"resource_desc = EXCLUDED.resource_desc, resource_type = EXCLUDED.resource_type, "+
+			"db_name = EXCLUDED.db_name, table_name = EXCLUDED.table_name, "+
+			"resource_status = EXCLUDED.resource_status, resource_remarks = EXCLUDED.resource_remarks, "+
+			"resource_def = EXCLUDED.resource_def, query_conds = EXCLUDED.query_conds, "+
+			"error_msg = NULL, updater = EXCLUDED.updater, updated_at = CURRENT_TIMESTAMP "+
+			"RETURNING resource_id", table_name)
+		if err := db.QueryRow(stmt, args...).Scan(&resource_id); err != nil {
+			logger.Error("failed to save resource", "error", err, "r_name", resource_info.ResourceName)
+			return 0, fmt.Errorf("failed to save resource (SHD_RSC_282): %w", err)
+		}
+
+	default:
+		return 0, fmt.Errorf("unsupported database type (SHD_RSC_286): %s", db_type)
+	}
+
+	resource_info.ResourceID = resource_id
+	logger.Info("Resource saved",
+		"r_name", resource_info.ResourceName,
+		"opr", resource_info.ResourceOpr,
+		"resource_id", resource_id)
+	return resource_id, nil
+}
+
+// DeleteResource deletes the resource identified by (resource_name, resource_opr).
+// It returns false if the resource does not exist.
+func DeleteResource(
+	rc ApiTypes.RequestContext,
+	resource_name string,
+	resource_opr string) (bool, error) {
+	logger := rc.GetLogger()
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	db_type := ApiTypes.DBType
+	table_name := ApiTypes.LibConfig.SystemTableNames.TableNameResources
+
+	var stmt string
+	switch db_type {
+	case ApiTypes.MysqlName:
+		stmt = fmt.Sprintf("DELETE FROM %s WHERE resource_name = ? AND resource_opr = ?", table_name)
+
+	case ApiTypes.PgName:
+		stmt = fmt.Sprintf("DELETE FROM %s WHERE resource_name = $1 AND resource_opr = $2", table_name)
+
+	default:
+		return false, fmt.Errorf("unsupported database type (SHD_RSC_316): %s", db_type)
+	}
+
+	result, err := db.Exec(stmt, resource_name, resource_opr)
+	if err != nil {
+		logger.Error("failed to delete resource", "error", err, "r_name", resource_name)
+		return false, fmt.Errorf("failed to delete resource (SHD_RSC_322): %w", err)
+	}
+
+	num_rows, _ := result.RowsAffected()
+	logger.Info("Resource deleted", "r_name", resource_name, "opr", resource_opr, "num_rows", num_rows)
+	return num_rows > 0, nil
+}
+
+// resourceRowScanner is satisfied by both *sql.Row and *sql.Rows.
+type resourceRowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanResourceRow scans a row selected with resource_store_selected_field_names.
+func scanResourceRow(
+	row resourceRowScanner,
+	resource_info *ApiTypes.ResourceDef) error {
+	var db_name, table_name, resource_remarks, error_msg sql.NullString
 	resource_json_str := sql.NullString{}
 	query_conds_json_str := sql.NullString{}
-	err := db.QueryRow(query, resource_name, resource_action).Scan(
+	err := row.Scan(
 		&resource_info.ResourceID,
+		&resource_info.ResourceName,
+		&resource_info.ResourceOpr,
 		&resource_info.ResourceDesc,
 		&resource_info.ResourceType,
-		&resource_info.DBName,
-		&resource_info.TableName,
+		&db_name,
+		&table_name,
 		&resource_info.ResourceStatus,
-		&resource_info.ResourceRemarks,
+		&resource_remarks,
 		&resource_json_str,
 		&query_conds_json_str,
-		&resource_info.ErrorMsg)
-
+		&error_msg)
 	if err != nil {
-		error_msg := fmt.Sprintf("database error:%v (SHD_RSC_133)", err)
-		log.Printf("%s", error_msg)
-		return resource_info, err
+		return err
 	}
 
+	resource_info.DBName = db_name.String
+	resource_info.TableName = table_name.String
+	resource_info.ResourceRemarks = resource_remarks.String
+	resource_info.ErrorMsg = error_msg.String
+
 	if resource_json_str.Valid {
 		err = json.Unmarshal([]byte(resource_json_str.String), &resource_info.ResourceJSON)
 		if err != nil {
@@ -159,6 +349,5 @@ func GetResourceByName(rc ApiTypes.RequestContext, resource_name string, resourc
 			log.Printf("***** Alarm:%s", error_msg)
 		}
 	}
-
-	return resource_info, nil
+	return nil
 }
@@ -0,0 +1,273 @@
+package sysdatastores
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+// UsersTwoFactorColumns are the columns TOTP two-factor authentication adds
+// to the users table. RunMigrations adds them to existing tables.
+var UsersTwoFactorColumns = []string{
+	"two_factor_enabled 		bool 			DEFAULT false",
+	"two_factor_secret 			TEXT 			DEFAULT NULL",
+	"two_factor_recovery_codes 	TEXT 			DEFAULT NULL",
+	"two_factor_last_step 		BIGINT 			NOT NULL DEFAULT 0",
+}
+
+// UserTwoFactorDef is the two-factor state of a user.
+type UserTwoFactorDef struct {
+	Enabled bool
+	// Secret is the TOTP secret, encrypted with security.EncryptString
+	Secret string
+	// RecoveryCodes are SHA-256 hex hashes of the unused recovery codes
+	RecoveryCodes []string
+	// LastStep is the TOTP time step of the last accepted code. Codes for
+	// this step or earlier are rejected (replay protection).
+	LastStep int64
+}
+
+// GetUserTwoFactor returns the two-factor state of a user. It returns
+// sql.ErrNoRows if the user does not exist.
+func GetUserTwoFactor(
+	rc ApiTypes.RequestContext,
+	user_id string) (*UserTwoFactorDef, error) {
+	logger := rc.GetLogger()
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	var query string
+	switch ApiTypes.DBType {
+	case ApiTypes.MysqlName:
+		query = "SELECT two_factor_enabled, two_factor_secret, two_factor_recovery_codes, " +
+			"two_factor_last_step FROM " + UsersTableName + " WHERE id = ?"
+
+	case ApiTypes.PgName:
+		query = "SELECT two_factor_enabled, two_factor_secret, two_factor_recovery_codes, " +
+			"two_factor_last_step FROM " + UsersTableName + " WHERE id = $1"
+
+	default:
+		return nil, fmt.Errorf("unsupported database type (SHD_U2F_051): %s", ApiTypes.DBType)
+	}
+
+	var enabled sql.NullBool
+	var secret, recovery_codes sql.NullString
+	var def UserTwoFactorDef
+	err := databaseutil.QueryRowWithRetry(rc.Context(), db, query, []interface{}{user_id},
+		&enabled, &secret, &recovery_codes, &def.LastStep)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("failed to read two-factor state", "error", err, "user_id", user_id)
+		}
+		return nil, err
+	}
+
+	def.Enabled = enabled.Bool
+	def.Secret = secret.String
+	def.RecoveryCodes = splitRecoveryCodes(recovery_codes.String)
+	return &def, nil
+}
+
+// IsTwoFactorEnabled reports whether the user has confirmed TOTP 2FA.
+func IsTwoFactorEnabled(rc ApiTypes.RequestContext, user_id string) (bool, error) {
+	def, err := GetUserTwoFactor(rc, user_id)
+	if err != nil {
+		return false, err
+	}
+	return def.Enabled, nil
+}
+
+// SetUserTwoFactorSecret stores a new (encrypted) TOTP secret and recovery
+// code hashes for a user. 2FA stays disabled until EnableUserTwoFactor is
+// called after the first code is confirmed.
+func SetUserTwoFactorSecret(
+	rc ApiTypes.RequestContext,
+	user_id string,
+	secret string,
+	recovery_code_hashes []string) error {
+	var stmt string
+	switch ApiTypes.DBType {
+	case ApiTypes.MysqlName:
+		stmt = "UPDATE " + UsersTableName + " SET two_factor_enabled = false, two_factor_secret = ?, " +
+			"two_factor_recovery_codes = ?, two_factor_last_step = 0 WHERE id = ?"
+
+	case ApiTypes.PgName:
+		stmt = "UPDATE " + UsersTableName + " SET two_factor_enabled = false, two_factor_secret = $1, " +
+			"two_factor_recovery_codes = $2, two_factor_last_step = 0 WHERE id = $3"
+
+	default:
+		return fmt.Errorf("unsupported database type (SHD_U2F_098): %s", ApiTypes.DBType)
+	}
+
+	return execTwoFactorUpdate(rc, stmt, "SHD_U2F_101",
+		secret, strings.Join(recovery_code_hashes, ","), user_id)
+}
+
+// EnableUserTwoFactor turns on 2FA for a user whose secret has been set.
+func EnableUserTwoFactor(rc ApiTypes.RequestContext, user_id string) error {
+	var stmt string
+	switch ApiTypes.DBType {
+	case ApiTypes.MysqlName:
+		stmt = "UPDATE " + UsersTableName + " SET two_factor_enabled = true " +
+			"WHERE id = ? AND two_factor_secret IS NOT NULL"
+
+	case ApiTypes.PgName:
+		stmt = "UPDATE " + UsersTableName + " SET two_factor_enabled = true " +
+			"WHERE id = $1 AND two_factor_secret IS NOT NULL"
+
+	default:
+		return fmt.Errorf("unsupported database type (SHD_U2F_119): %s", ApiTypes.DBType)
+	}
+
+	return execTwoFactorUpdate(rc, stmt, "SHD_U2F_122", user_id)
+}
+
+// ResetUserTwoFactor disables 2FA for a user and clears the secret and
+// recovery codes. It returns false if the user does not exist.
+func ResetUserTwoFactor(rc ApiTypes.RequestContext, user_id string) (bool, error) {
+	var stmt string
+	switch ApiTypes.DBType {
+	case ApiTypes.MysqlName:
+		stmt = "UPDATE " + UsersTableName + " SET two_factor_enabled = false, two_factor_secret = NULL, " +
+			"two_factor_recovery_codes = NULL, two_factor_last_step = 0 WHERE id = ?"
+
+	case ApiTypes.PgName:
+		stmt = "UPDATE " + UsersTableName + " SET two_factor_enabled = false, two_factor_secret = NULL, " +
+			"two_factor_recovery_codes = NULL, two_factor_last_step = 0 WHERE id = $1"
+
+	default:
+		return false, fmt.Errorf("unsupported database type (SHD_U2F_139): %s", ApiTypes.DBType)
+	}
+
+	err := execTwoFactorUpdate(rc, stmt, "SHD_U2F_142", user_id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UseTwoFactorStep records 'step' as the last accepted TOTP step. It
+// returns false if a code for this step or a later one was already used,
+// so each code is accepted at most once even under concurrent requests.
+func UseTwoFactorStep(rc ApiTypes.RequestContext, user_id string, step int64) (bool, error) {
+	var stmt string
+	switch ApiTypes.DBType {
+	case ApiTypes.MysqlName:
+		stmt = "UPDATE " + UsersTableName + " SET two_factor_last_step = ? " +
+			"WHERE id = ? AND two_factor_last_step < ?"
+
+	case ApiTypes.PgName:
+		stmt = "UPDATE " + UsersTableName + " SET two_factor_last_step = $1 " +
+			"WHERE id = $2 AND two_factor_last_step < $1"
+
+	default:
+		return false, fmt.Errorf("unsupported database type (SHD_U2F_163): %s", ApiTypes.DBType)
+	}
+
+	args := []interface{}{step, user_id}
+	if ApiTypes.DBType == ApiTypes.MysqlName {
+		args = append(args, step)
+	}
+	err := execTwoFactorUpdate(rc, stmt, "SHD_U2F_170", args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ConsumeTwoFactorRecoveryCode removes a recovery code hash from the user's
+// unused codes. It returns false if the code is not (or no longer) there.
+func ConsumeTwoFactorRecoveryCode(
+	rc ApiTypes.RequestContext,
+	user_id string,
+	code_hash string) (bool, error) {
+	logger := rc.GetLogger()
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	var query, update_stmt string
+	switch ApiTypes.DBType {
+	case ApiTypes.MysqlName:
+		query = "SELECT two_factor_recovery_codes FROM " + UsersTableName + " WHERE id = ? FOR UPDATE"
+		update_stmt = "UPDATE " + UsersTableName + " SET two_factor_recovery_codes = ? WHERE id = ?"
+
+	case ApiTypes.PgName:
+		query = "SELECT two_factor_recovery_codes FROM " + UsersTableName + " WHERE id = $1 FOR UPDATE"
+		update_stmt = "UPDATE " + UsersTableName + " SET two_factor_recovery_codes = $1 WHERE id = $2"
+
+	default:
+		return false, fmt.Errorf("unsupported database type (SHD_U2F_195): %s", ApiTypes.DBType)
+	}
+
+	found := false
+	err := databaseutil.WithWriteRetry(rc.Context(), db, func(ctx context.Context) error {
+		found = false
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		var codes sql.NullString
+		if err := tx.QueryRowContext(ctx, query, user_id).Scan(&codes); err != nil {
+			return err
+		}
+
+		remaining := []string{}
+		for _, hash := range splitRecoveryCodes(codes.String) {
+			if !found && hash == code_hash {
+				found = true
+				continue
+			}
+			remaining = append(remaining, hash)
+		}
+		if !found {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, update_stmt, strings.Join(remaining, ","), user_id); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		logger.Error("failed to consume recovery code", "error", err, "user_id", user_id)
+		return false, fmt.Errorf("failed to consume recovery code (SHD_U2F_233): %w", err)
+	}
+	return found, nil
+}
+
+// execTwoFactorUpdate runs a single-row update and returns sql.ErrNoRows
+// if no row matched.
+func execTwoFactorUpdate(
+	rc ApiTypes.RequestContext,
+	stmt string,
+	loc string,
+	args ...interface{}) error {
+	logger := rc.GetLogger()
+	result, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt, args...)
+	if err != nil {
+		logger.Error("failed to update two-factor state", "error", err, "loc", loc)
+		return fmt.Errorf("failed to update two-factor state (%s): %w", loc, err)
+	}
+
+	num_rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected (%s): %w", loc, err)
+	}
+	if num_rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func splitRecoveryCodes(codes string) []string {
+	if codes == "" {
+		return nil
+	}
+	return strings.Split(codes, ",")
+}
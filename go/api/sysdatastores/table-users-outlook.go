@@ -0,0 +1,219 @@
+package sysdatastores
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/databaseutil"
+)
+
+// UsersOutlookColumns are the columns the Outlook (Microsoft Graph) mail
+// integration adds to the users table. RunMigrations adds them to
+// existing tables.
+var UsersOutlookColumns = []string{
+	"outlook_access_token 		TEXT 			DEFAULT NULL",
+	"outlook_refresh_token 		TEXT 			DEFAULT NULL",
+	"outlook_token_expires_at 	TIMESTAMP 		NULL DEFAULT NULL",
+	"outlook_sub_id 			VARCHAR(128) 	DEFAULT NULL",
+	"outlook_sub_expires_at 	TIMESTAMP 		NULL DEFAULT NULL",
+	"outlook_failures 			INTEGER 		NOT NULL DEFAULT 0",
+	"outlook_disabled_at 		TIMESTAMP 		NULL DEFAULT NULL",
+}
+
+var users_outlook_field_names = "id, email, outlook_access_token, outlook_refresh_token, " +
+	"outlook_token_expires_at, outlook_sub_id, outlook_sub_expires_at, " +
+	"outlook_failures, outlook_disabled_at"
+
+// UserOutlookDef is the Outlook integration state of a user.
+type UserOutlookDef struct {
+	UserID string
+	Email  string
+	// AccessToken and RefreshToken are encrypted by the outlook package
+	AccessToken    string
+	RefreshToken   string
+	TokenExpiresAt time.Time
+	SubID          string
+	SubExpiresAt   time.Time
+	// Failures is the number of consecutive failed refreshes or renewals
+	Failures int
+	// DisabledAt is set once the integration is disabled after repeated
+	// failures. It is zero while the integration is active.
+	DisabledAt time.Time
+}
+
+type outlookRowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUserOutlook(row outlookRowScanner) (*UserOutlookDef, error) {
+	var access_token, refresh_token, sub_id sql.NullString
+	var token_expires_at, sub_expires_at, disabled_at sql.NullTime
+	var def UserOutlookDef
+	err := row.Scan(&def.UserID, &def.Email, &access_token, &refresh_token,
+		&token_expires_at, &sub_id, &sub_expires_at, &def.Failures, &disabled_at)
+	if err != nil {
+		return nil, err
+	}
+
+	def.AccessToken = access_token.String
+	def.RefreshToken = refresh_token.String
+	def.SubID = sub_id.String
+	def.TokenExpiresAt = token_expires_at.Time
+	def.SubExpiresAt = sub_expires_at.Time
+	def.DisabledAt = disabled_at.Time
+	return &def, nil
+}
+
+// GetUserOutlook returns the Outlook state of a user. It returns
+// sql.ErrNoRows if the user does not exist.
+func GetUserOutlook(rc ApiTypes.RequestContext, user_id string) (*UserOutlookDef, error) {
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	query := "SELECT " + users_outlook_field_names + " FROM " + UsersTableName +
+		" WHERE id = " + placeholder(ApiTypes.DBType, 1)
+
+	var def *UserOutlookDef
+	err := databaseutil.WithRetry(rc.Context(), db, func(ctx context.Context) error {
+		var err error
+		def, err = scanUserOutlook(db.QueryRowContext(ctx, query, user_id))
+		return err
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			rc.GetLogger().Error("failed to read outlook state", "error", err, "user_id", user_id)
+		}
+		return nil, err
+	}
+	return def, nil
+}
+
+// ListOutlookUsersDue returns up to 'limit' active users with an Outlook
+// refresh token whose access token expires before 'token_before', or whose
+// subscription expires before 'sub_before'. Disabled users and users whose
+// integration was disabled are skipped.
+func ListOutlookUsersDue(
+	rc ApiTypes.RequestContext,
+	token_before time.Time,
+	sub_before time.Time,
+	limit int) ([]*UserOutlookDef, error) {
+	db_type := ApiTypes.DBType
+	query := "SELECT " + users_outlook_field_names + " FROM " + UsersTableName +
+		" WHERE outlook_refresh_token IS NOT NULL AND outlook_refresh_token <> ''" +
+		" AND outlook_disabled_at IS NULL" +
+		" AND user_status <> '" + ApiTypes.UserStatus_Disabled + "'" +
+		" AND (outlook_token_expires_at IS NULL OR outlook_token_expires_at < " + placeholder(db_type, 1) +
+		" OR (outlook_sub_id IS NOT NULL AND outlook_sub_id <> ''" +
+		" AND (outlook_sub_expires_at IS NULL OR outlook_sub_expires_at < " + placeholder(db_type, 2) + ")))" +
+		fmt.Sprintf(" ORDER BY outlook_token_expires_at LIMIT %d", limit)
+
+	rows, err := databaseutil.QueryWithRetry(rc.Context(), ApiTypes.SharedDBHandle, query, token_before, sub_before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outlook users (SHD_UOL_117): %w", err)
+	}
+	defer rows.Close()
+
+	users := []*UserOutlookDef{}
+	for rows.Next() {
+		def, err := scanUserOutlook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan outlook user (SHD_UOL_125): %w", err)
+		}
+		users = append(users, def)
+	}
+	return users, rows.Err()
+}
+
+// SetUserOutlookTokens stores new (encrypted) tokens for a user and
+// clears the failure count and any disabled state.
+func SetUserOutlookTokens(
+	rc ApiTypes.RequestContext,
+	user_id string,
+	access_token string,
+	refresh_token string,
+	expires_at time.Time) error {
+	db_type := ApiTypes.DBType
+	stmt := "UPDATE " + UsersTableName + " SET outlook_access_token = " + placeholder(db_type, 1) +
+		", outlook_refresh_token = " + placeholder(db_type, 2) +
+		", outlook_token_expires_at = " + placeholder(db_type, 3) +
+		", outlook_failures = 0, outlook_disabled_at = NULL WHERE id = " + placeholder(db_type, 4)
+	return execOutlookUpdate(rc, stmt, "SHD_UOL_145",
+		access_token, refresh_token, nullableTime(expires_at), user_id)
+}
+
+// SetUserOutlookSubscription stores the Graph subscription of a user and
+// clears the failure count.
+func SetUserOutlookSubscription(
+	rc ApiTypes.RequestContext,
+	user_id string,
+	sub_id string,
+	expires_at time.Time) error {
+	db_type := ApiTypes.DBType
+	stmt := "UPDATE " + UsersTableName + " SET outlook_sub_id = " + placeholder(db_type, 1) +
+		", outlook_sub_expires_at = " + placeholder(db_type, 2) +
+		", outlook_failures = 0 WHERE id = " + placeholder(db_type, 3)
+	return execOutlookUpdate(rc, stmt, "SHD_UOL_159", sub_id, nullableTime(expires_at), user_id)
+}
+
+// RecordUserOutlookFailure counts a failed refresh or renewal. Once the
+// user reaches 'max_failures' consecutive failures, the integration is
+// disabled. It returns the failure count and whether it is now disabled.
+func RecordUserOutlookFailure(
+	rc ApiTypes.RequestContext,
+	user_id string,
+	max_failures int) (int, bool, error) {
+	db_type := ApiTypes.DBType
+	stmt := "UPDATE " + UsersTableName + " SET outlook_failures = outlook_failures + 1, " +
+		"outlook_disabled_at = CASE WHEN outlook_failures + 1 >= " + placeholder(db_type, 1) +
+		" THEN CURRENT_TIMESTAMP ELSE outlook_disabled_at END WHERE id = " + placeholder(db_type, 2)
+	if db_type == ApiTypes.MysqlName {
+		// MySQL assigns left to right, so outlook_failures is already
+		// incremented when outlook_disabled_at is computed.
+		stmt = "UPDATE " + UsersTableName + " SET outlook_failures = outlook_failures + 1, " +
+			"outlook_disabled_at = CASE WHEN outlook_failures >= ? " +
+			"THEN CURRENT_TIMESTAMP ELSE outlook_disabled_at END WHERE id = ?"
+	}
+	if err := execOutlookUpdate(rc, stmt, "SHD_UOL_180", max_failures, user_id); err != nil {
+		return 0, false, err
+	}
+
+	def, err := GetUserOutlook(rc, user_id)
+	if err != nil {
+		return 0, false, err
+	}
+	return def.Failures, !def.DisabledAt.IsZero(), nil
+}
+
+// execOutlookUpdate runs a single-row update and returns sql.ErrNoRows
+// if no row matched.
+func execOutlookUpdate(
+	rc ApiTypes.RequestContext,
+	stmt string,
+	loc string,
+	args ...interface{}) error {
+	result, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt, args...)
+	if err != nil {
+		rc.GetLogger().Error("failed to update outlook state", "error", err, "loc", loc)
+		return fmt.Errorf("failed to update outlook state (%s): %w", loc, err)
+	}
+
+	num_rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected (%s): %w", loc, err)
+	}
+	if num_rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// nullableTime returns nil for a zero time; MySQL rejects year 0001
+// timestamps.
+func nullableTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
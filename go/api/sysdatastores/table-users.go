@@ -1,25 +1,50 @@
 package sysdatastores
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/chendingplano/shared/go/api/ApiTypes"
 	"github.com/chendingplano/shared/go/api/ApiUtils"
 	"github.com/chendingplano/shared/go/api/databaseutil"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 )
 
 // To generate short UUID
 // "github.com/lithammer/shortuuid/v4"
 
+const UsersTableName = "users"
+
+// UserLookupOpt modifies the GetUserInfoBy* lookups.
+type UserLookupOpt int
+
+const (
+	// ExcludeDisabledUsers makes GetUserInfoBy* treat disabled
+	// (soft-deleted) users as not found.
+	ExcludeDisabledUsers UserLookupOpt = iota + 1
+)
+
+// userStatusFilter returns the extra WHERE condition for 'opts'.
+func userStatusFilter(opts []UserLookupOpt) string {
+	for _, opt := range opts {
+		if opt == ExcludeDisabledUsers {
+			return " AND user_status <> '" + ApiTypes.UserStatus_Disabled + "'"
+		}
+	}
+	return ""
+}
+
 var Users_selected_field_names = "id, " +
 	"name, password, user_id_type, first_name, last_name, " +
 	"email, user_mobile, user_address, verified, admin, " +
 	"is_owner, email_visibility, auth_type, user_status, avatar, " +
 	"locale, " +
-	"v_token_expires_at, created, updated"
+	"v_token_expires_at, last_login_at, password_expires_at, created, updated"
 
 var Users_insert_field_names = "name, " +
 	"password, user_id_type, first_name, last_name, " +
@@ -35,10 +60,37 @@ func CreateUsersTable(
 
 	logger.Info("Create table", "table_name", table_name)
 
-	var stmt string
+	stmts, err := usersCreateTableStmts(db_type, table_name)
+	if err != nil {
+		logger.Error("db_type not supported", "db_type", db_type)
+		return err
+	}
+
+	err = databaseutil.ExecuteStatement(db, stmts[0])
+	if err != nil {
+		error_msg := fmt.Errorf("failed creating table (SHD_USR_045), err: %w, stmt:%s", err, stmts[0])
+		logger.Error("failed creating table", "error", err, "stmt", stmts[0])
+		return error_msg
+	}
+
+	for _, idx := range stmts[1:] {
+		if err := databaseutil.ExecuteStatement(db, idx); err != nil {
+			logger.Error("failed creating index", "error", err, "stmt", idx)
+			return fmt.Errorf("failed creating index (SHD_USR_056), err: %w, stmt:%s", err, idx)
+		}
+	}
+
+	logger.Info("Create table success", "table_name", table_name)
+
+	return nil
+}
+
+// usersCreateTableStmts returns the CREATE TABLE statement for the users
+// table followed by its index statements. The columns must cover
+// Users_selected_field_names and Users_insert_field_names.
+func usersCreateTableStmts(db_type string, table_name string) ([]string, error) {
 	fields :=
-		"id      VARCHAR(64) PRIMARY KEY DEFAULT gen_random_uuid()::text, " +
-			"name      				VARCHAR(128) 	NOT NULL, " +
+		"name      				VARCHAR(128) 	NOT NULL, " +
 			"password  				VARCHAR(128) 	DEFAULT NULL, " +
 			"user_id_type   		VARCHAR(32)  	DEFAULT NULL, " +
 			"first_name      		VARCHAR(128) 	DEFAULT NULL, " +
@@ -52,53 +104,173 @@ func CreateUsersTable(
 			"email_visibility 		bool 			DEFAULT true, " +
 			"auth_type      		VARCHAR(32) 	NOT NULL, " +
 			"user_status    		VARCHAR(32) 	NOT NULL, " +
-			"avatar         		text DEFAULT 	NULL, " +
+			"avatar         		TEXT 			DEFAULT NULL, " +
 			"locale         		VARCHAR(128) 	DEFAULT NULL, " +
 			"v_token      			VARCHAR(128) 	DEFAULT NULL, " +
-			"v_token_expires_at		TIMESTAMP 		DEFAULT NULL, " +
+			"v_token_expires_at		TIMESTAMP 		NULL DEFAULT NULL, " +
+			"disabled_at			TIMESTAMP 		NULL DEFAULT NULL, " +
+			UsersLastLoginColumn + ", " +
+			UsersPasswordExpiresColumn + ", " +
+			strings.Join(UsersTwoFactorColumns, ", ") + ", " +
+			strings.Join(UsersOutlookColumns, ", ") + ", " +
 			"created        		TIMESTAMP 		DEFAULT CURRENT_TIMESTAMP, " +
 			"updated        		TIMESTAMP 		DEFAULT CURRENT_TIMESTAMP "
 
 	switch db_type {
 	case ApiTypes.MysqlName:
-		stmt = "CREATE TABLE IF NOT EXISTS " + table_name + "(" + fields +
-			", INDEX idx_created_at (created_at) " +
-			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;"
+		// utf8mb4_unicode_ci is case-insensitive, so the unique key on
+		// email matches the LOWER(email) index used on PG.
+		return []string{
+			"CREATE TABLE IF NOT EXISTS " + table_name + "(" +
+				"id      VARCHAR(64) PRIMARY KEY DEFAULT (UUID()), " + fields +
+				", UNIQUE KEY users_email_unique (email) " +
+				", UNIQUE KEY users_v_token_unique (v_token) " +
+				", INDEX idx_users_created (created) " +
+				", INDEX idx_users_last_login (last_login_at) " +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;",
+		}, nil
 
 	case ApiTypes.PgName:
-		stmt = "CREATE TABLE IF NOT EXISTS " + table_name + "(" + fields + ")"
-
-	default:
-		err := fmt.Errorf("database type not supported:%s (SHD_USR_117)", db_type)
-		logger.Error("db_type not supported", "db_type", db_type)
-		return err
+		return []string{
+			"CREATE TABLE IF NOT EXISTS " + table_name + "(" +
+				"id      VARCHAR(64) PRIMARY KEY DEFAULT gen_random_uuid()::text, " + fields + ")",
+			"CREATE INDEX IF NOT EXISTS idx_users_created ON " + table_name + " (created);",
+			usersLastLoginIndexStmt(table_name),
+			"CREATE UNIQUE INDEX IF NOT EXISTS users_email_unique_lower ON " + table_name + " (LOWER(email));",
+			usersVTokenIndexStmt(table_name),
+		}, nil
 	}
+	return nil, fmt.Errorf("database type not supported:%s (SHD_USR_117)", db_type)
+}
 
-	err := databaseutil.ExecuteStatement(db, stmt)
+// UsersLastLoginColumn is the time of the user's last login or email
+// verification, set by SaveSession. RunMigrations adds it to existing
+// tables.
+const UsersLastLoginColumn = "last_login_at		TIMESTAMP 		NULL DEFAULT NULL"
+
+// UsersPasswordExpiresColumn is when a temporary password set by an admin
+// stops working. Setting a password clears it. RunMigrations adds it to
+// existing tables.
+const UsersPasswordExpiresColumn = "password_expires_at	TIMESTAMP 		NULL DEFAULT NULL"
+
+// usersLastLoginIndexStmt returns the PG index on last_login_at, to find
+// the users who haven't logged in for a while without scanning the table
+func usersLastLoginIndexStmt(table_name string) string {
+	return "CREATE INDEX IF NOT EXISTS idx_users_last_login ON " + table_name + " (last_login_at);"
+}
+
+// UpdateLastLogin sets the last login time of the user 'user_id' to
+// 'login_time'. It updates by primary key, so it stays cheap on every
+// login.
+func UpdateLastLogin(rc ApiTypes.RequestContext, user_id string, login_time time.Time) error {
+	db_type := ApiTypes.DBType
+	stmt := fmt.Sprintf("UPDATE %s SET last_login_at = %s WHERE id = %s",
+		UsersTableName, placeholder(db_type, 1), placeholder(db_type, 2))
+	_, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt, login_time, user_id)
 	if err != nil {
-		error_msg := fmt.Errorf("failed creating table (SHD_USR_045), err: %w, stmt:%s", err, stmt)
-		logger.Error("failed creating table", "error", err, "stmt", stmt)
-		return error_msg
+		return fmt.Errorf("failed to update last login (SHD_USR_1041), user_id:%s, err: %w", user_id, err)
 	}
+	return nil
+}
 
-	if db_type == ApiTypes.PgName {
-		idx1 := `CREATE INDEX IF NOT EXISTS idx_created_at ON ` + table_name + ` (created_at);`
-		databaseutil.ExecuteStatement(db, idx1)
+// usersVTokenIndex is the unique index on v_token, so that a token names
+// one user. Users without a token have NULL, which the index allows any
+// number of times; on PG rows from before the index may also have an
+// empty token.
+const usersVTokenIndex = "users_v_token_unique"
 
-		idx2 := `CREATE UNIQUE INDEX IF NOT EXISTS users_email_unique_lower ON ` + table_name + ` (LOWER(email));`
-		databaseutil.ExecuteStatement(db, idx2)
+func usersVTokenIndexStmt(table_name string) string {
+	return "CREATE UNIQUE INDEX IF NOT EXISTS " + usersVTokenIndex + " ON " + table_name +
+		" (v_token) WHERE v_token IS NOT NULL AND v_token <> '';"
+}
+
+// isVTokenConflict reports whether 'err' is a violation of the unique
+// index on v_token
+func isVTokenConflict(err error) bool {
+	var pq_err *pq.Error
+	if errors.As(err, &pq_err) {
+		return pq_err.Code == "23505" && pq_err.Constraint == usersVTokenIndex
+	}
+	var mysql_err *mysql.MySQLError
+	if errors.As(err, &mysql_err) {
+		return mysql_err.Number == 1062 && strings.Contains(mysql_err.Message, usersVTokenIndex)
 	}
+	return false
+}
 
-	logger.Info("Create table success", "table_name", table_name)
+// usersUpsertStmt returns the statement UpsertUser runs to insert a user,
+// or only refresh v_token if the email exists. The new token replaces
+// the old one with its expiry. On PG the statement returns the row; on
+// MySQL the row must be read back by email.
+func usersUpsertStmt(db_type string, table_name string) (string, error) {
+	num_fields := len(strings.Split(Users_insert_field_names, ","))
+	placeholders := make([]string, num_fields)
+	switch db_type {
+	case ApiTypes.MysqlName:
+		for i := range placeholders {
+			placeholders[i] = "?"
+		}
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) "+
+			"ON DUPLICATE KEY UPDATE v_token = VALUES(v_token), "+
+			"v_token_expires_at = VALUES(v_token_expires_at)",
+			table_name, Users_insert_field_names, strings.Join(placeholders, ", ")), nil
 
-	return nil
+	case ApiTypes.PgName:
+		for i := range placeholders {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) "+
+			"ON CONFLICT (LOWER(email)) DO UPDATE SET v_token = EXCLUDED.v_token, "+
+			"v_token_expires_at = EXCLUDED.v_token_expires_at "+
+			"RETURNING %s",
+			table_name, Users_insert_field_names, strings.Join(placeholders, ", "),
+			Users_selected_field_names), nil
+	}
+	return "", fmt.Errorf("unsupported database type (SHD_USR_313): %s", db_type)
+}
+
+// userInsertArgs returns the values of Users_insert_field_names, in order
+func userInsertArgs(user_info *ApiTypes.UserInfo) []interface{} {
+	// A zero expiry is stored as NULL; MySQL rejects year 0001 timestamps.
+	var v_token_expires_at interface{}
+	if !user_info.VTokenExpiresAt.IsZero() {
+		v_token_expires_at = user_info.VTokenExpiresAt
+	}
+
+	// No token is NULL, which the unique index on v_token allows for
+	// every user
+	var v_token interface{}
+	if user_info.VToken != "" {
+		v_token = user_info.VToken
+	}
+
+	return []interface{}{
+		user_info.UserName,
+		user_info.Password,
+		user_info.UserIdType,
+		user_info.FirstName,
+		user_info.LastName,
+		user_info.Email,
+		user_info.UserMobile,
+		user_info.UserAddress,
+		user_info.Verified,
+		user_info.Admin,
+		user_info.IsOwner,
+		user_info.EmailVisibility,
+		user_info.AuthType,
+		user_info.UserStatus,
+		user_info.Avatar,
+		user_info.Locale,
+		v_token, // write-only (not read back for security)
+		v_token_expires_at,
+	}
 }
 
 func scanUserRecord(
-	row *sql.Row,
+	row interface{ Scan(dest ...any) error },
 	user_info *ApiTypes.UserInfo) error {
 	// Use sql.NullTime for nullable timestamp columns to handle NULL values
-	var vTokenExpiresAt, created, updated sql.NullTime
+	var vTokenExpiresAt, lastLoginAt, passwordExpiresAt, created, updated sql.NullTime
 
 	err := row.Scan(
 		&user_info.UserId,
@@ -119,6 +291,8 @@ func scanUserRecord(
 		&user_info.Avatar,
 		&user_info.Locale,
 		&vTokenExpiresAt,
+		&lastLoginAt,
+		&passwordExpiresAt,
 		&created,
 		&updated,
 	)
@@ -130,6 +304,12 @@ func scanUserRecord(
 	if vTokenExpiresAt.Valid {
 		user_info.VTokenExpiresAt = vTokenExpiresAt.Time
 	}
+	if lastLoginAt.Valid {
+		user_info.LastLoginAt = &lastLoginAt.Time
+	}
+	if passwordExpiresAt.Valid {
+		user_info.PasswordExpiresAt = &passwordExpiresAt.Time
+	}
 	if created.Valid {
 		user_info.Created = created.Time
 	}
@@ -144,9 +324,13 @@ func scanUserRecord(
 // IMPORTANT: if the user does not exist, it returns nil, nil
 // The caller MUST check whether user_info is valid, even if
 // err is nil!!!
+// Pass ExcludeDisabledUsers to treat disabled users as not found.
+// Like the other hot lookups (by user name, by id) it runs as a cached
+// prepared statement, see databaseutil.PrepareCached.
 func GetUserInfoByEmail(
 	rc ApiTypes.RequestContext,
-	user_email string) (*ApiTypes.UserInfo, error) {
+	user_email string,
+	opts ...UserLookupOpt) (*ApiTypes.UserInfo, error) {
 	logger := rc.GetLogger()
 	var query string
 	var db *sql.DB = ApiTypes.SharedDBHandle
@@ -154,10 +338,12 @@ func GetUserInfoByEmail(
 	table_name := "users"
 	switch db_type {
 	case ApiTypes.MysqlName:
-		query = fmt.Sprintf("SELECT %s FROM %s WHERE email = ? LIMIT 1", Users_selected_field_names, table_name)
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE email = ?%s LIMIT 1",
+			Users_selected_field_names, table_name, userStatusFilter(opts))
 
 	case ApiTypes.PgName:
-		query = fmt.Sprintf("SELECT %s FROM %s WHERE email = $1 LIMIT 1", Users_selected_field_names, table_name)
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE email = $1%s LIMIT 1",
+			Users_selected_field_names, table_name, userStatusFilter(opts))
 
 	default:
 		err := fmt.Errorf("unsupported database type (SHD_USR_326): %s", db_type)
@@ -165,9 +351,10 @@ func GetUserInfoByEmail(
 		return nil, err
 	}
 
-	row := db.QueryRow(query, user_email)
 	user_info := new(ApiTypes.UserInfo)
-	err := scanUserRecord(row, user_info)
+	err := databaseutil.WithPreparedStmt(rc.Context(), db, query, func(ctx context.Context, stmt *sql.Stmt) error {
+		return scanUserRecord(stmt.QueryRowContext(ctx, user_email), user_info)
+	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			logger.Warn("user not found", "email", user_email)
@@ -184,9 +371,83 @@ func GetUserInfoByEmail(
 	return user_info, nil
 }
 
+// GetUserInfoByUserName retrieves UserInfo by user name (the 'name'
+// column). Like GetUserInfoByEmail, it returns nil, nil if the user does
+// not exist. User names are not unique; if more than one user has
+// 'user_name', it returns an error rather than pick one.
+// Pass ExcludeDisabledUsers to treat disabled users as not found.
+func GetUserInfoByUserName(
+	rc ApiTypes.RequestContext,
+	user_name string,
+	opts ...UserLookupOpt) (*ApiTypes.UserInfo, error) {
+	logger := rc.GetLogger()
+	var query string
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	db_type := ApiTypes.DBType
+	table_name := "users"
+	switch db_type {
+	case ApiTypes.MysqlName:
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE name = ?%s LIMIT 2",
+			Users_selected_field_names, table_name, userStatusFilter(opts))
+
+	case ApiTypes.PgName:
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE name = $1%s LIMIT 2",
+			Users_selected_field_names, table_name, userStatusFilter(opts))
+
+	default:
+		err := fmt.Errorf("unsupported database type (SHD_USR_311): %s", db_type)
+		logger.Error("unsupported db type", "db_type", db_type)
+		return nil, err
+	}
+
+	// LIMIT 2 is enough to tell a unique name from a shared one
+	var users []*ApiTypes.UserInfo
+	err := databaseutil.WithPreparedStmt(rc.Context(), db, query, func(ctx context.Context, stmt *sql.Stmt) error {
+		users = nil
+		rows, err := stmt.QueryContext(ctx, user_name)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			user_info := new(ApiTypes.UserInfo)
+			if err := scanUserRecord(rows, user_info); err != nil {
+				return err
+			}
+			users = append(users, user_info)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		logger.Error("failed scanning user record", "error", err)
+		return nil, err
+	}
+
+	switch len(users) {
+	case 0:
+		logger.Warn("user not found", "user_name", user_name)
+		return nil, nil
+
+	case 1:
+		logger.Info("User info retrieved",
+			"status", users[0].UserStatus,
+			"is_admin", users[0].Admin,
+			"user_name", user_name)
+		return users[0], nil
+
+	default:
+		logger.Warn("user name is not unique", "user_name", user_name)
+		return nil, fmt.Errorf("user name is not unique (SHD_USR_355): %s", user_name)
+	}
+}
+
+// GetUserInfoByUserID retrieves UserInfo by user id. It returns
+// sql.ErrNoRows if the user does not exist.
+// Pass ExcludeDisabledUsers to treat disabled users as not found.
 func GetUserInfoByUserID(
 	rc ApiTypes.RequestContext,
-	user_id string) (*ApiTypes.UserInfo, error) {
+	user_id string,
+	opts ...UserLookupOpt) (*ApiTypes.UserInfo, error) {
 	// This function checks whether 'user_email' is used in the users table.
 	var query string
 	var db *sql.DB = ApiTypes.SharedDBHandle
@@ -196,10 +457,12 @@ func GetUserInfoByUserID(
 	logger := rc.GetLogger()
 	switch db_type {
 	case ApiTypes.MysqlName:
-		query = fmt.Sprintf("SELECT %s FROM %s WHERE id = ? LIMIT 1", Users_selected_field_names, table_name)
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE id = ?%s LIMIT 1",
+			Users_selected_field_names, table_name, userStatusFilter(opts))
 
 	case ApiTypes.PgName:
-		query = fmt.Sprintf("SELECT %s FROM %s WHERE id = $1 LIMIT 1", Users_selected_field_names, table_name)
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE id = $1%s LIMIT 1",
+			Users_selected_field_names, table_name, userStatusFilter(opts))
 
 	default:
 		err := fmt.Errorf("unsupported database type (SHD_USR_326): %s", db_type)
@@ -207,9 +470,10 @@ func GetUserInfoByUserID(
 		return nil, err
 	}
 
-	row := db.QueryRow(query, user_id)
 	user_info := new(ApiTypes.UserInfo)
-	err := scanUserRecord(row, user_info)
+	err := databaseutil.WithPreparedStmt(rc.Context(), db, query, func(ctx context.Context, stmt *sql.Stmt) error {
+		return scanUserRecord(stmt.QueryRowContext(ctx, user_id), user_info)
+	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			logger.Warn("user not found", "user_id", user_id)
@@ -284,9 +548,61 @@ func MigrateUsersTable_AddVTokenExpiresAt(
 	return nil
 }
 
+// MigrateUsersTable_AddVTokenUnique adds the unique index on v_token to
+// existing users tables. On MySQL, empty tokens are set to NULL first,
+// since the index can't leave them out. This migration is idempotent.
+func MigrateUsersTable_AddVTokenUnique(
+	logger ApiTypes.JimoLogger,
+	db *sql.DB,
+	db_type string,
+	table_name string) error {
+	logger.Info("Running migration: add unique index on v_token", "table_name", table_name)
+
+	var stmt string
+	switch db_type {
+	case ApiTypes.MysqlName:
+		stmt = fmt.Sprintf(`
+			SELECT COUNT(*) FROM INFORMATION_SCHEMA.STATISTICS
+			WHERE TABLE_NAME = '%s' AND INDEX_NAME = '%s'
+		`, table_name, usersVTokenIndex)
+		var count int
+		if err := db.QueryRow(stmt).Scan(&count); err != nil {
+			logger.Error("failed to check index existence", "error", err)
+			return fmt.Errorf("migration check failed (SHD_MIG_004): %w", err)
+		}
+		if count > 0 {
+			logger.Info("Index on v_token already exists, skipping migration")
+			return nil
+		}
+		clear_stmt := fmt.Sprintf("UPDATE %s SET v_token = NULL WHERE v_token = ''", table_name)
+		if err := databaseutil.ExecuteStatement(db, clear_stmt); err != nil {
+			logger.Error("migration failed", "error", err, "stmt", clear_stmt)
+			return fmt.Errorf("migration failed (SHD_MIG_005): %w", err)
+		}
+		stmt = fmt.Sprintf("ALTER TABLE %s ADD UNIQUE KEY %s (v_token)", table_name, usersVTokenIndex)
+
+	case ApiTypes.PgName:
+		stmt = usersVTokenIndexStmt(table_name)
+
+	default:
+		err := fmt.Errorf("unsupported database type (SHD_MIG_006): %s", db_type)
+		logger.Error("db_type not supported", "db_type", db_type)
+		return err
+	}
+
+	if err := databaseutil.ExecuteStatement(db, stmt); err != nil {
+		logger.Error("migration failed", "error", err, "stmt", stmt)
+		return fmt.Errorf("migration failed (SHD_MIG_007): %w", err)
+	}
+
+	logger.Info("Migration completed: unique index on v_token added", "table_name", table_name)
+	return nil
+}
+
 func GetUserInfoByToken(
 	rc ApiTypes.RequestContext,
-	token string) (*ApiTypes.UserInfo, error) {
+	token string,
+	opts ...UserLookupOpt) (*ApiTypes.UserInfo, error) {
 	// This function checks whether 'user_email' is used in the users table.
 	return nil, fmt.Errorf("(MID_26030301) 'users' table not supported")
 }
@@ -296,57 +612,57 @@ func UpsertUser(
 	user_info *ApiTypes.UserInfo) error {
 	logger := rc.GetLogger()
 	var db *sql.DB = ApiTypes.SharedDBHandle
-	var insert_stmt string
 	db_type := ApiTypes.DBType
 	// table_name := ApiTypes.LibConfig.SystemTableNames.TableNameUsers
 	table_name := "users"
-	switch db_type {
-	case ApiTypes.MysqlName:
-		err := fmt.Errorf("mysql not supported yet")
-		logger.Error("mysql not supported yet")
-		return err
-
-	case ApiTypes.PgName:
-		insert_stmt = fmt.Sprintf("INSERT INTO %s (%s) VALUES ("+
-			"$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, "+
-			"$11, $12, $13, $14, $15, $16, $17, $18, $19, $20, "+
-			"$21, $22, $23) "+
-			"ON CONFLICT (LOWER(email)) DO UPDATE SET v_token = EXCLUDED.v_token "+
-			"RETURNING %s",
-			table_name, Users_insert_field_names, Users_selected_field_names)
-
-	default:
-		err := fmt.Errorf("unsupported database type (SHD_USR_313): %s", db_type)
+	insert_stmt, err := usersUpsertStmt(db_type, table_name)
+	if err != nil {
 		logger.Error("unsupported database type", "db_type", db_type)
 		return err
 	}
 
-	// Prepare the arguments in the same order as the field names
-	args := []interface{}{
-		user_info.UserName,
-		user_info.Password,
-		user_info.UserIdType,
-		user_info.FirstName,
-		user_info.LastName,
-		user_info.Email,
-		user_info.UserMobile,
-		user_info.UserAddress,
-		user_info.Verified,
-		user_info.Admin,
-		user_info.IsOwner,
-		user_info.EmailVisibility,
-		user_info.AuthType,
-		user_info.UserStatus,
-		user_info.Avatar,
-		user_info.Locale,
-		user_info.VToken, // write-only (not read back for security)
-		user_info.VTokenExpiresAt,
-	}
+	args := userInsertArgs(user_info)
 
-	row := db.QueryRow(insert_stmt, args...)
 	var new_user_info ApiTypes.UserInfo
-	err := scanUserRecord(row, &new_user_info)
+	err = databaseutil.WithWriteRetry(rc.Context(), db, func(ctx context.Context) error {
+		if db_type == ApiTypes.MysqlName {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if _, err := tx.ExecContext(ctx, insert_stmt, args...); err != nil {
+				return err
+			}
+			// ON DUPLICATE KEY UPDATE also takes a v_token conflict, on the
+			// row of the user that has the token, so check who has it and
+			// roll that update back if it is someone else.
+			if user_info.VToken != "" {
+				var owner string
+				err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT email FROM %s WHERE v_token = ?", table_name),
+					user_info.VToken).Scan(&owner)
+				if err != nil {
+					return err
+				}
+				if !strings.EqualFold(owner, user_info.Email) {
+					return ApiTypes.ErrTokenConflict
+				}
+			}
+			query := fmt.Sprintf("SELECT %s FROM %s WHERE email = ? LIMIT 1",
+				Users_selected_field_names, table_name)
+			if err := scanUserRecord(tx.QueryRowContext(ctx, query, user_info.Email), &new_user_info); err != nil {
+				return err
+			}
+			return tx.Commit()
+		}
+		return scanUserRecord(db.QueryRowContext(ctx, insert_stmt, args...), &new_user_info)
+	})
 	if err != nil {
+		if isVTokenConflict(err) || errors.Is(err, ApiTypes.ErrTokenConflict) {
+			logger.Warn("v_token already in use", "email", user_info.Email)
+			return fmt.Errorf("%w (SHD_USR_212), email:%s", ApiTypes.ErrTokenConflict, user_info.Email)
+		}
 		if errors.Is(err, sql.ErrNoRows) {
 			logger.Error("no user found")
 		} else {
@@ -376,6 +692,12 @@ func UpsertUser(
 	var updateArgs []interface{}
 	var conflicts []string
 	paramIndex := 1
+	placeholder := func(i int) string {
+		if db_type == ApiTypes.MysqlName {
+			return "?"
+		}
+		return fmt.Sprintf("$%d", i)
+	}
 
 	// Helper to check string fields
 	checkStringField := func(fieldName string, dbVal, inputVal string, read_only bool) {
@@ -389,7 +711,7 @@ func UpsertUser(
 
 		if inputVal != "" {
 			if dbVal == "" || !read_only {
-				fieldsToUpdate = append(fieldsToUpdate, fmt.Sprintf("%s = $%d", fieldName, paramIndex))
+				fieldsToUpdate = append(fieldsToUpdate, fmt.Sprintf("%s = %s", fieldName, placeholder(paramIndex)))
 				updateArgs = append(updateArgs, inputVal)
 				paramIndex++
 			} else if dbVal != inputVal {
@@ -403,7 +725,7 @@ func UpsertUser(
 		if dbVal == inputVal {
 			return
 		}
-		fieldsToUpdate = append(fieldsToUpdate, fmt.Sprintf("%s = $%d", fieldName, paramIndex))
+		fieldsToUpdate = append(fieldsToUpdate, fmt.Sprintf("%s = %s", fieldName, placeholder(paramIndex)))
 		updateArgs = append(updateArgs, inputVal)
 		paramIndex++
 	}
@@ -437,13 +759,13 @@ func UpsertUser(
 
 	// Execute update if there are fields to update
 	if len(fieldsToUpdate) > 0 {
-		update_stmt := fmt.Sprintf("UPDATE %s SET %s, updated = CURRENT_TIMESTAMP WHERE id = $%d",
+		update_stmt := fmt.Sprintf("UPDATE %s SET %s, updated = CURRENT_TIMESTAMP WHERE id = %s",
 			table_name,
 			strings.Join(fieldsToUpdate, ", "),
-			paramIndex)
+			placeholder(paramIndex))
 		updateArgs = append(updateArgs, user_info.UserId)
 
-		_, err := db.Exec(update_stmt, updateArgs...)
+		_, err := databaseutil.ExecWithRetry(rc.Context(), db, update_stmt, updateArgs...)
 		if err != nil {
 			logger.Error("failed to update user record",
 				"error", err,
@@ -460,9 +782,12 @@ func UpsertUser(
 	return nil
 }
 
+// MarkUserVerified verifies the user of 'email' and activates it. It
+// reports whether this call verified the user: the update only matches
+// an unverified user, so of concurrent calls one only gets true.
 func MarkUserVerified(
 	rc ApiTypes.RequestContext,
-	user_name string) error {
+	email string) (bool, error) {
 	var db *sql.DB = ApiTypes.SharedDBHandle
 	var stmt string
 	db_type := ApiTypes.DBType
@@ -471,26 +796,33 @@ func MarkUserVerified(
 	logger := rc.GetLogger()
 	switch db_type {
 	case ApiTypes.MysqlName:
-		stmt = fmt.Sprintf("UPDATE %s SET user_status = 'active', verified = true WHERE name = ?", table_name)
+		stmt = fmt.Sprintf("UPDATE %s SET user_status = 'active', verified = true "+
+			"WHERE email = ? AND verified = false", table_name)
 
 	case ApiTypes.PgName:
-		stmt = fmt.Sprintf("UPDATE %s SET user_status = 'active', verified = true WHERE name = $1", table_name)
+		stmt = fmt.Sprintf("UPDATE %s SET user_status = 'active', verified = true "+
+			"WHERE email = $1 AND verified = false", table_name)
 
 	default:
 		err := fmt.Errorf("unsupported database type (SHD_USR_401): %s", db_type)
 		logger.Error("db_type not supported", "db_type", db_type)
-		return err
+		return false, err
 	}
 
-	_, err := db.Exec(stmt, user_name)
+	result, err := databaseutil.ExecWithRetry(rc.Context(), db, stmt, email)
 	if err != nil {
 		error_msg := fmt.Errorf("failed to update table (SHD_USR_404), stmt:%s, err: %w", stmt, err)
 		logger.Error("failed to update user", "error", err, "stmt", stmt)
-		return error_msg
+		return false, error_msg
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to read rows affected (SHD_USR_1043): %w", err)
 	}
 	logger.Info("Mark user verified success",
-		"user_name", user_name)
-	return nil
+		"email", email,
+		"newly_verified", rows > 0)
+	return rows > 0, nil
 }
 
 func UpdatePasswordByEmail(
@@ -504,10 +836,12 @@ func UpdatePasswordByEmail(
 	table_name := "users"
 	switch db_type {
 	case ApiTypes.MysqlName:
-		stmt = fmt.Sprintf("UPDATE %s SET password = ?, user_status = 'active' WHERE email = ?", table_name)
+		stmt = fmt.Sprintf("UPDATE %s SET password = ?, user_status = 'active', password_expires_at = NULL "+
+			"WHERE email = ?", table_name)
 
 	case ApiTypes.PgName:
-		stmt = fmt.Sprintf("UPDATE %s SET password = $1, user_status = 'active' WHERE email = $2", table_name)
+		stmt = fmt.Sprintf("UPDATE %s SET password = $1, user_status = 'active', password_expires_at = NULL "+
+			"WHERE email = $2", table_name)
 
 	default:
 		err := fmt.Errorf("unsupported database type (SHD_USR_565): %s", db_type)
@@ -515,7 +849,7 @@ func UpdatePasswordByEmail(
 		return err
 	}
 
-	_, err := db.Exec(stmt, password, email)
+	_, err := databaseutil.ExecWithRetry(rc.Context(), db, stmt, password, email)
 	if err != nil {
 		error_msg := fmt.Errorf("failed to update password (SHD_USR_572), stmt:%s, err: %w", stmt, err)
 		logger.Error("failed to update password", "error", err, "stmt", stmt)
@@ -525,6 +859,50 @@ func UpdatePasswordByEmail(
 	return nil
 }
 
+// SetTemporaryPasswordByEmail sets the password hash of the user like
+// UpdatePasswordByEmail, but the password stops working at 'expires_at',
+// until a new one is set by UpdatePasswordByEmail. The hash and the
+// expiry are written by one statement, so a password that never expires
+// is never left behind.
+func SetTemporaryPasswordByEmail(
+	rc ApiTypes.RequestContext,
+	email string,
+	password string,
+	expires_at time.Time) error {
+	db_type := ApiTypes.DBType
+	table_name := "users"
+	stmt := fmt.Sprintf("UPDATE %s SET password = %s, user_status = 'active', password_expires_at = %s "+
+		"WHERE email = %s",
+		table_name, placeholder(db_type, 1), placeholder(db_type, 2), placeholder(db_type, 3))
+	_, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt, password, expires_at, email)
+	if err != nil {
+		return fmt.Errorf("failed to set temporary password (SHD_USR_1042): %w", err)
+	}
+	rc.GetLogger().Info("Set temporary password success", "email", email)
+	return nil
+}
+
+// RehashPasswordByEmail replaces the password hash 'old_hash' of the user
+// with 'new_hash', a rehash of the same password. Unlike
+// UpdatePasswordByEmail, the user status is left alone, and a hash
+// changed in between (e.g. by a reset) is not overwritten.
+func RehashPasswordByEmail(
+	rc ApiTypes.RequestContext,
+	email string,
+	old_hash string,
+	new_hash string) error {
+	db_type := ApiTypes.DBType
+	table_name := "users"
+	stmt := fmt.Sprintf("UPDATE %s SET password = %s WHERE email = %s AND password = %s",
+		table_name, placeholder(db_type, 1), placeholder(db_type, 2), placeholder(db_type, 3))
+	_, err := databaseutil.ExecWithRetry(rc.Context(), ApiTypes.SharedDBHandle, stmt, new_hash, email, old_hash)
+	if err != nil {
+		return fmt.Errorf("failed to rehash password (SHD_USR_906): %w", err)
+	}
+	rc.GetLogger().Info("Rehash password success", "email", email)
+	return nil
+}
+
 func UpdateAuthTokenByEmail(
 	rc ApiTypes.RequestContext,
 	email string,
@@ -537,10 +915,10 @@ func UpdateAuthTokenByEmail(
 	table_name := "users"
 	switch db_type {
 	case ApiTypes.MysqlName:
-		stmt = fmt.Sprintf("UPDATE %s SET v_token= ? WHERE email = ?", table_name)
+		stmt = fmt.Sprintf("UPDATE %s SET v_token= ?, v_token_expires_at = NULL WHERE email = ?", table_name)
 
 	case ApiTypes.PgName:
-		stmt = fmt.Sprintf("UPDATE %s SET v_token= $1 WHERE email = $2", table_name)
+		stmt = fmt.Sprintf("UPDATE %s SET v_token= $1, v_token_expires_at = NULL WHERE email = $2", table_name)
 
 	default:
 		err := fmt.Errorf("unsupported database type (SHD_USR_495): %s", db_type)
@@ -548,7 +926,11 @@ func UpdateAuthTokenByEmail(
 		return err
 	}
 
-	result, err := db.Exec(stmt, auth_token, email)
+	result, err := databaseutil.ExecWithRetry(rc.Context(), db, stmt, auth_token, email)
+	if isVTokenConflict(err) {
+		logger.Warn("v_token already in use", "email", email)
+		return fmt.Errorf("%w (SHD_USR_501), email:%s", ApiTypes.ErrTokenConflict, email)
+	}
 	if err != nil {
 		error_msg := fmt.Errorf("failed to update auth token (SHD_USR_502), stmt:%s, err: %w", stmt, err)
 		logger.Error("failed to update auth token", "stmt", stmt, "error", err)
@@ -568,3 +950,200 @@ func UpdateAuthTokenByEmail(
 	logger.Info("Update auth token success", "email", email, "token", ApiUtils.MaskToken(auth_token))
 	return nil
 }
+
+// DeactivateUser soft-deletes a user: it sets user_status to 'disabled',
+// records disabled_at, and deletes all of the user's login sessions in the
+// same transaction, so a disabled user is logged out everywhere.
+// The record itself is kept until PurgeDisabledUsers removes it.
+func DeactivateUser(
+	rc ApiTypes.RequestContext,
+	user_id string) error {
+	logger := rc.GetLogger()
+	user_info, err := GetUserInfoByUserID(rc, user_id)
+	if err != nil {
+		return fmt.Errorf("failed to get user (SHD_USR_612), user_id:%s: %w", user_id, err)
+	}
+
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	db_type := ApiTypes.DBType
+	sessions_table := ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions
+	var update_stmt, delete_stmt string
+	switch db_type {
+	case ApiTypes.MysqlName:
+		update_stmt = fmt.Sprintf("UPDATE %s SET user_status = ?, disabled_at = CURRENT_TIMESTAMP, "+
+			"updated = CURRENT_TIMESTAMP WHERE id = ?", UsersTableName)
+		delete_stmt = fmt.Sprintf("DELETE FROM %s WHERE user_id = ? OR user_email = ?", sessions_table)
+
+	case ApiTypes.PgName:
+		update_stmt = fmt.Sprintf("UPDATE %s SET user_status = $1, disabled_at = CURRENT_TIMESTAMP, "+
+			"updated = CURRENT_TIMESTAMP WHERE id = $2", UsersTableName)
+		delete_stmt = fmt.Sprintf("DELETE FROM %s WHERE user_id = $1 OR user_email = $2", sessions_table)
+
+	default:
+		err := fmt.Errorf("unsupported database type (SHD_USR_631): %s", db_type)
+		logger.Error("db_type not supported", "db_type", db_type)
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction (SHD_USR_638): %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // Rollback if not committed
+	}()
+
+	if _, err := tx.Exec(update_stmt, ApiTypes.UserStatus_Disabled, user_id); err != nil {
+		logger.Error("failed to deactivate user", "error", err, "user_id", user_id)
+		return fmt.Errorf("failed to deactivate user (SHD_USR_646), user_id:%s: %w", user_id, err)
+	}
+
+	result, err := tx.Exec(delete_stmt, user_id, user_info.Email)
+	if err != nil {
+		logger.Error("failed to delete user sessions", "error", err, "user_id", user_id)
+		return fmt.Errorf("failed to delete user sessions (SHD_USR_652), user_id:%s: %w", user_id, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction (SHD_USR_656): %w", err)
+	}
+
+	num_sessions, _ := result.RowsAffected()
+	msg := fmt.Sprintf("user deactivated, user_id:%s, email:%s, sessions_deleted:%d",
+		user_id, user_info.Email, num_sessions)
+	AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: ApiTypes.ActivityType_UserDeactivated,
+		AppName:      ApiTypes.AppName_SysDataStore,
+		ModuleName:   ApiTypes.ModuleName_Users,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_USR_667"})
+
+	logger.Info("User deactivated",
+		"user_id", user_id,
+		"email", user_info.Email,
+		"sessions_deleted", num_sessions)
+	return nil
+}
+
+// ReactivateUser reverses DeactivateUser. It returns an error if the user
+// does not exist or is not disabled. The user has to log in again.
+func ReactivateUser(
+	rc ApiTypes.RequestContext,
+	user_id string) error {
+	logger := rc.GetLogger()
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	db_type := ApiTypes.DBType
+	var stmt string
+	switch db_type {
+	case ApiTypes.MysqlName:
+		stmt = fmt.Sprintf("UPDATE %s SET user_status = ?, disabled_at = NULL, "+
+			"updated = CURRENT_TIMESTAMP WHERE id = ? AND user_status = ?", UsersTableName)
+
+	case ApiTypes.PgName:
+		stmt = fmt.Sprintf("UPDATE %s SET user_status = $1, disabled_at = NULL, "+
+			"updated = CURRENT_TIMESTAMP WHERE id = $2 AND user_status = $3", UsersTableName)
+
+	default:
+		err := fmt.Errorf("unsupported database type (SHD_USR_694): %s", db_type)
+		logger.Error("db_type not supported", "db_type", db_type)
+		return err
+	}
+
+	result, err := databaseutil.ExecWithRetry(rc.Context(), db, stmt, ApiTypes.UserStatus_Active, user_id, ApiTypes.UserStatus_Disabled)
+	if err != nil {
+		logger.Error("failed to reactivate user", "error", err, "user_id", user_id)
+		return fmt.Errorf("failed to reactivate user (SHD_USR_702), user_id:%s: %w", user_id, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected (SHD_USR_707): %w", err)
+	}
+	if rowsAffected == 0 {
+		logger.Warn("no disabled user found", "user_id", user_id)
+		return fmt.Errorf("no disabled user found (SHD_USR_711), user_id:%s", user_id)
+	}
+
+	msg := fmt.Sprintf("user reactivated, user_id:%s", user_id)
+	AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: ApiTypes.ActivityType_UserReactivated,
+		AppName:      ApiTypes.AppName_SysDataStore,
+		ModuleName:   ApiTypes.ModuleName_Users,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_USR_720"})
+
+	logger.Info("User reactivated", "user_id", user_id)
+	return nil
+}
+
+// PurgeDisabledUsers hard-deletes users that have been disabled for longer
+// than 'older_than'. Login sessions are deleted before the user records, in
+// one transaction. It returns the number of users deleted.
+func PurgeDisabledUsers(
+	rc ApiTypes.RequestContext,
+	older_than time.Duration) (int64, error) {
+	logger := rc.GetLogger()
+	var db *sql.DB = ApiTypes.SharedDBHandle
+	db_type := ApiTypes.DBType
+	sessions_table := ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions
+	cutoff := time.Now().Add(-older_than)
+
+	var sessions_stmt, users_stmt string
+	switch db_type {
+	case ApiTypes.MysqlName:
+		disabled_users := fmt.Sprintf("SELECT id, email FROM %s WHERE user_status = ? AND disabled_at < ?",
+			UsersTableName)
+		sessions_stmt = fmt.Sprintf("DELETE s FROM %s s JOIN (%s) u ON s.user_id = u.id OR s.user_email = u.email",
+			sessions_table, disabled_users)
+		users_stmt = fmt.Sprintf("DELETE FROM %s WHERE user_status = ? AND disabled_at < ?", UsersTableName)
+
+	case ApiTypes.PgName:
+		sessions_stmt = fmt.Sprintf("DELETE FROM %s s USING %s u WHERE u.user_status = $1 AND u.disabled_at < $2 "+
+			"AND (s.user_id = u.id OR s.user_email = u.email)", sessions_table, UsersTableName)
+		users_stmt = fmt.Sprintf("DELETE FROM %s WHERE user_status = $1 AND disabled_at < $2", UsersTableName)
+
+	default:
+		err := fmt.Errorf("unsupported database type (SHD_USR_753): %s", db_type)
+		logger.Error("db_type not supported", "db_type", db_type)
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction (SHD_USR_760): %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // Rollback if not committed
+	}()
+
+	if _, err := tx.Exec(sessions_stmt, ApiTypes.UserStatus_Disabled, cutoff); err != nil {
+		logger.Error("failed to delete sessions of disabled users", "error", err)
+		return 0, fmt.Errorf("failed to delete sessions of disabled users (SHD_USR_768): %w", err)
+	}
+
+	result, err := tx.Exec(users_stmt, ApiTypes.UserStatus_Disabled, cutoff)
+	if err != nil {
+		logger.Error("failed to purge disabled users", "error", err)
+		return 0, fmt.Errorf("failed to purge disabled users (SHD_USR_774): %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction (SHD_USR_778): %w", err)
+	}
+
+	num_users, _ := result.RowsAffected()
+	msg := fmt.Sprintf("purged disabled users, num_users:%d, disabled_before:%s",
+		num_users, cutoff.Format(time.RFC3339))
+	AddActivityLog(ApiTypes.ActivityLogDef{
+		ActivityName: ApiTypes.ActivityName_Auth,
+		ActivityType: ApiTypes.ActivityType_UserPurged,
+		AppName:      ApiTypes.AppName_SysDataStore,
+		ModuleName:   ApiTypes.ModuleName_Users,
+		ActivityMsg:  &msg,
+		CallerLoc:    "SHD_USR_789"})
+
+	logger.Info("Purged disabled users", "num_users", num_users, "disabled_before", cutoff)
+	return num_users, nil
+}
@@ -0,0 +1,275 @@
+package sysdatastores
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/loggerutil"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestUsersStatementsMatchFieldLists(t *testing.T) {
+	num_fields := len(strings.Split(Users_insert_field_names, ","))
+	if n := len(userInsertArgs(&ApiTypes.UserInfo{})); n != num_fields {
+		t.Fatalf("insert args: got %d, want %d", n, num_fields)
+	}
+
+	for _, db_type := range []string{ApiTypes.PgName, ApiTypes.MysqlName} {
+		stmt, err := usersUpsertStmt(db_type, UsersTableName)
+		if err != nil {
+			t.Fatalf("%s: %v", db_type, err)
+		}
+
+		num_placeholders := strings.Count(stmt, "?")
+		if db_type == ApiTypes.PgName {
+			num_placeholders = strings.Count(stmt, "$")
+		}
+		if num_placeholders != num_fields {
+			t.Errorf("%s: upsert has %d placeholders, want %d", db_type, num_placeholders, num_fields)
+		}
+
+		create_stmts, err := usersCreateTableStmts(db_type, UsersTableName)
+		if err != nil {
+			t.Fatalf("%s: %v", db_type, err)
+		}
+		columns := make(map[string]bool)
+		for _, token := range strings.Fields(strings.NewReplacer("(", " ", ",", " ").Replace(create_stmts[0])) {
+			columns[token] = true
+		}
+		for _, field := range strings.Split(Users_selected_field_names+","+Users_insert_field_names, ",") {
+			field = strings.TrimSpace(field)
+			if !columns[field] {
+				t.Errorf("%s: column %s missing from CREATE TABLE", db_type, field)
+			}
+		}
+		for _, idx := range create_stmts {
+			if strings.Contains(idx, "created_at") {
+				t.Errorf("%s: statement references non-existent column created_at: %s", db_type, idx)
+			}
+		}
+	}
+}
+
+// testRC is the part of RequestContext the users functions use
+type testRC struct {
+	ApiTypes.RequestContext
+	logger ApiTypes.JimoLogger
+}
+
+func (rc testRC) Context() context.Context       { return context.Background() }
+func (rc testRC) GetLogger() ApiTypes.JimoLogger { return rc.logger }
+func (rc testRC) ReqID() string                  { return "test" }
+
+// TestUsersTableRoundTrip creates the users table in a scratch schema and
+// upserts a user. It runs only if SHARED_TEST_PG_DSN is set.
+func TestUsersTableRoundTrip(t *testing.T) {
+	dsn := os.Getenv("SHARED_TEST_PG_DSN")
+	if dsn == "" {
+		t.Skip("SHARED_TEST_PG_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	schema := fmt.Sprintf("users_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	defer db.Exec("DROP SCHEMA " + schema + " CASCADE")
+	if _, err := db.Exec("SET search_path TO " + schema); err != nil {
+		t.Fatalf("set search_path: %v", err)
+	}
+
+	saved_db, saved_type := ApiTypes.SharedDBHandle, ApiTypes.DBType
+	ApiTypes.SharedDBHandle, ApiTypes.DBType = db, ApiTypes.PgName
+	defer func() { ApiTypes.SharedDBHandle, ApiTypes.DBType = saved_db, saved_type }()
+
+	rc := testRC{logger: loggerutil.CreateDefaultLogger("SHD_USR_T01")}
+	if err := CreateUsersTable(rc.logger, db, ApiTypes.PgName, UsersTableName); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	user_info := &ApiTypes.UserInfo{
+		UserName:   "round-trip",
+		Email:      "Round.Trip@example.com",
+		AuthType:   "email",
+		UserStatus: ApiTypes.UserStatus_Active,
+		VToken:     "token-1",
+		Verified:   true,
+	}
+	if err := UpsertUser(rc, user_info); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	if user_info.UserId == "" {
+		t.Fatal("upsert did not set UserId")
+	}
+
+	got, err := GetUserInfoByEmail(rc, user_info.Email)
+	if err != nil || got == nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if got.UserId != user_info.UserId || got.UserName != user_info.UserName || !got.Verified {
+		t.Fatalf("unexpected user: %+v", got)
+	}
+
+	// Upserting the same email with different case must not add a row
+	again := *user_info
+	again.UserId = ""
+	again.Email = strings.ToLower(user_info.Email)
+	if err := UpsertUser(rc, &again); err != nil {
+		t.Fatalf("second upsert: %v", err)
+	}
+	if again.UserId != user_info.UserId {
+		t.Fatalf("second upsert created a new user: %s != %s", again.UserId, user_info.UserId)
+	}
+
+	// A token names one user; users without one don't conflict
+	other := &ApiTypes.UserInfo{
+		UserName:   "other",
+		Email:      "other@example.com",
+		AuthType:   "email",
+		UserStatus: ApiTypes.UserStatus_Active,
+		VToken:     "token-1",
+	}
+	if err := UpsertUser(rc, other); !errors.Is(err, ApiTypes.ErrTokenConflict) {
+		t.Fatalf("upsert with a used token: err = %v, want ErrTokenConflict", err)
+	}
+	for _, email := range []string{"other@example.com", "third@example.com"} {
+		no_token := *other
+		no_token.Email, no_token.VToken = email, ""
+		if err := UpsertUser(rc, &no_token); err != nil {
+			t.Fatalf("upsert %s without a token: %v", email, err)
+		}
+	}
+}
+
+// On MySQL a used token makes ON DUPLICATE KEY UPDATE update the row of
+// the user that has it; that update must be rolled back, not committed
+func TestUpsertUserMySQLTokenConflictRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	saved_db, saved_type := ApiTypes.SharedDBHandle, ApiTypes.DBType
+	ApiTypes.SharedDBHandle, ApiTypes.DBType = db, ApiTypes.MysqlName
+	defer func() { ApiTypes.SharedDBHandle, ApiTypes.DBType = saved_db, saved_type }()
+
+	insert_stmt, err := usersUpsertStmt(ApiTypes.MysqlName, UsersTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &ApiTypes.UserInfo{Email: "other@example.com", VToken: "token-1"}
+	args := userInsertArgs(other)
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(insert_stmt).WithArgs(values...).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("SELECT email FROM users WHERE v_token = ?").WithArgs("token-1").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("owner@example.com"))
+	mock.ExpectRollback()
+
+	rc := testRC{logger: loggerutil.CreateDefaultLogger("SHD_USR_T02")}
+	if err := UpsertUser(rc, other); !errors.Is(err, ApiTypes.ErrTokenConflict) {
+		t.Fatalf("upsert with a used token: err = %v, want ErrTokenConflict", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// The temporary password and its expiry are one statement, so the
+// password can't be left set without the expiry
+func TestSetTemporaryPasswordByEmail(t *testing.T) {
+	expires_at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		db_type string
+		stmt    string
+	}{
+		{ApiTypes.PgName, "UPDATE users SET password = $1, user_status = 'active', password_expires_at = $2 WHERE email = $3"},
+		{ApiTypes.MysqlName, "UPDATE users SET password = ?, user_status = 'active', password_expires_at = ? WHERE email = ?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.db_type, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			saved_db, saved_type := ApiTypes.SharedDBHandle, ApiTypes.DBType
+			ApiTypes.SharedDBHandle, ApiTypes.DBType = db, tt.db_type
+			defer func() { ApiTypes.SharedDBHandle, ApiTypes.DBType = saved_db, saved_type }()
+
+			mock.ExpectExec(tt.stmt).WithArgs("hash", expires_at, "bob@example.com").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			rc := testRC{logger: loggerutil.CreateDefaultLogger("SHD_USR_T03")}
+			if err := SetTemporaryPasswordByEmail(rc, "bob@example.com", "hash", expires_at); err != nil {
+				t.Fatalf("SetTemporaryPasswordByEmail: %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestIsVTokenConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "pg", err: &pq.Error{Code: "23505", Constraint: usersVTokenIndex}, want: true},
+		{name: "pg wrapped", err: fmt.Errorf("upsert: %w", &pq.Error{Code: "23505", Constraint: usersVTokenIndex}), want: true},
+		{name: "pg email", err: &pq.Error{Code: "23505", Constraint: "users_email_unique_lower"}},
+		{name: "mysql", err: &mysql.MySQLError{Number: 1062,
+			Message: "Duplicate entry 'x' for key 'users.users_v_token_unique'"}, want: true},
+		{name: "mysql email", err: &mysql.MySQLError{Number: 1062,
+			Message: "Duplicate entry 'x' for key 'users.users_email_unique'"}},
+		{name: "other", err: errors.New("duplicate key users_v_token_unique")},
+		{name: "nil"},
+	}
+	for _, tt := range tests {
+		if got := isVTokenConflict(tt.err); got != tt.want {
+			t.Errorf("%s: isVTokenConflict = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestUserInsertArgsMatchColumns guards the pairing of
+// Users_insert_field_names with userInsertArgs: a column added to one
+// must be added to the other at the same position.
+func TestUserInsertArgsMatchColumns(t *testing.T) {
+	columns := strings.Split(Users_insert_field_names, ",")
+	args := userInsertArgs(&ApiTypes.UserInfo{})
+	if len(args) != len(columns) {
+		t.Fatalf("userInsertArgs returns %d values for %d columns", len(args), len(columns))
+	}
+
+	// No token is stored as NULL, which the unique index allows
+	for i, column := range columns {
+		if strings.TrimSpace(column) == "v_token" && args[i] != nil {
+			t.Errorf("v_token without a token = %#v, want nil", args[i])
+		}
+	}
+}
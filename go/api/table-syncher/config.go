@@ -42,6 +42,25 @@ type SyncConfig struct {
 	DataSyncFreq int `mapstructure:"data_sync_freq"` // Frequency in seconds
 	MetricFreq   int `mapstructure:"metric_freq"`    // Frequency in hours
 
+	// Records of a table applied per transaction; larger change files are
+	// committed in several transactions to bound lock and WAL build-up
+	MaxTxRecords int `mapstructure:"max_tx_records"`
+
+	// Archive reconnection: attempts per reconnect and the cap of the
+	// exponential backoff between them
+	ReconnectAttempts   int `mapstructure:"reconnect_attempts"`
+	ReconnectMaxBackoff int `mapstructure:"reconnect_max_backoff"` // Seconds
+
+	// Webhook notified after every sync cycle (disabled when empty), and
+	// the lag of a table that turns the notification into an alert
+	WebhookURL        string `mapstructure:"webhook_url"`
+	WebhookTimeout    int    `mapstructure:"webhook_timeout"`     // Seconds
+	LagAlertThreshold int    `mapstructure:"lag_alert_threshold"` // Seconds, 0 disables lag alerts
+
+	// Source database that pg_dump copies a new table from, when the
+	// archive has no snapshot of it (disabled when empty)
+	SnapshotSourceDSN string `mapstructure:"snapshot_source_dsn"`
+
 	// Derived paths (computed after loading)
 	StateFilePath string // <config_dir>/.syncdata_state.json
 	PIDFilePath   string // <config_dir>/.syncdata.pid
@@ -77,6 +96,11 @@ func LoadConfig() (*SyncConfig, error) {
 	v.SetDefault("pg_user", "admin")
 	v.SetDefault("data_sync_freq", 600)
 	v.SetDefault("metric_freq", 24)
+	v.SetDefault("max_tx_records", 5000)
+	v.SetDefault("reconnect_attempts", 5)
+	v.SetDefault("reconnect_max_backoff", 60)
+	v.SetDefault("webhook_timeout", 5)
+	v.SetDefault("lag_alert_threshold", 3600)
 
 	if err := v.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("failed to read config file %s: %w (%s) (SHD_02070557)", configPath, err, LOC_CFG_LOAD)
@@ -94,6 +118,8 @@ func LoadConfig() (*SyncConfig, error) {
 	v.BindEnv("pg_database", "PG_DB_NAME")
 	v.BindEnv("data_sync_freq", "DATA_SYNC_FREQ")
 	v.BindEnv("metric_freq", "METRIC_FREQ")
+	v.BindEnv("webhook_url", "DATA_SYNC_WEBHOOK_URL")
+	v.BindEnv("snapshot_source_dsn", "DATA_SYNC_SNAPSHOT_DSN")
 
 	config := &SyncConfig{}
 	if err := v.Unmarshal(config); err != nil {
@@ -152,6 +178,24 @@ func (c *SyncConfig) Validate() error {
 	if c.MetricFreq < 1 {
 		return fmt.Errorf("metric_freq must be at least 1 hour (%s) (SHD_02070565)", LOC_CFG_VALID)
 	}
+	if c.MaxTxRecords < 1 {
+		return fmt.Errorf("max_tx_records must be at least 1 (%s) (SHD_02070574)", LOC_CFG_VALID)
+	}
+	if c.ReconnectAttempts < 1 {
+		return fmt.Errorf("reconnect_attempts must be at least 1 (%s) (SHD_02070569)", LOC_CFG_VALID)
+	}
+	if c.ReconnectMaxBackoff < 1 {
+		return fmt.Errorf("reconnect_max_backoff must be at least 1 second (%s) (SHD_02070570)", LOC_CFG_VALID)
+	}
+	if c.WebhookURL != "" && !strings.HasPrefix(c.WebhookURL, "http://") && !strings.HasPrefix(c.WebhookURL, "https://") {
+		return fmt.Errorf("webhook_url must be an http or https URL (%s) (SHD_02070571)", LOC_CFG_VALID)
+	}
+	if c.WebhookURL != "" && c.WebhookTimeout < 1 {
+		return fmt.Errorf("webhook_timeout must be at least 1 second (%s) (SHD_02070572)", LOC_CFG_VALID)
+	}
+	if c.LagAlertThreshold < 0 {
+		return fmt.Errorf("lag_alert_threshold must not be negative (%s) (SHD_02070573)", LOC_CFG_VALID)
+	}
 
 	return nil
 }
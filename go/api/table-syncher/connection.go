@@ -0,0 +1,141 @@
+package tablesyncher
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Location codes for archive connection management
+const (
+	LOC_CONN_CHECK     = "SHD_SYN_065"
+	LOC_CONN_RECONNECT = "SHD_SYN_066"
+)
+
+const (
+	// healthCheckTimeout bounds the health check round trip, so a link that
+	// silently stopped answering is detected
+	healthCheckTimeout = 15 * time.Second
+
+	// reconnectBaseBackoff is the wait before the second connection attempt;
+	// it doubles on each further attempt, up to reconnect_max_backoff
+	reconnectBaseBackoff = time.Second
+)
+
+// Status returns the current connection status.
+func (c *SFTPClient) Status() ConnectionStatus {
+	return c.status
+}
+
+// HealthCheck makes a round trip to the archive machine over the SFTP
+// session. It fails if the client is not connected or the link is down.
+func (c *SFTPClient) HealthCheck(ctx context.Context) error {
+	if c.sftpClient == nil {
+		return fmt.Errorf("SFTP client not connected (%s)", LOC_CONN_CHECK)
+	}
+
+	// The stat may block on a dead link until the connection is closed
+	client := c.sftpClient
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Stat(c.config.ArchiveDir)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("archive health check failed: %w (%s)", err, LOC_CONN_CHECK)
+		}
+		return nil
+	case <-time.After(healthCheckTimeout):
+		return fmt.Errorf("archive health check timed out after %s (%s)", healthCheckTimeout, LOC_CONN_CHECK)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// EnsureConnected checks the connection to the archive machine and
+// (re)connects if it is not connected or the health check fails. Connecting
+// is retried up to reconnect_attempts times with exponential backoff.
+func (c *SFTPClient) EnsureConnected(ctx context.Context) error {
+	if c.sftpClient != nil {
+		err := c.HealthCheck(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.logger.Warn("Archive connection lost, reconnecting", "error", err, "loc", LOC_CONN_RECONNECT)
+		c.recordError(err)
+		c.Close()
+	}
+	return c.reconnect(ctx)
+}
+
+// reconnect connects to the archive machine, retrying with backoff.
+func (c *SFTPClient) reconnect(ctx context.Context) error {
+	wasConnected := c.status.State != ""
+	if wasConnected {
+		c.setState(ConnReconnecting)
+	}
+
+	attempts := max(c.config.ReconnectAttempts, 1)
+	maxBackoff := time.Duration(c.config.ReconnectMaxBackoff) * time.Second
+	if maxBackoff <= 0 {
+		maxBackoff = time.Minute
+	}
+
+	backoff := reconnectBaseBackoff
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			c.logger.Info("Retrying archive connection",
+				"attempt", attempt,
+				"max_attempts", attempts,
+				"backoff", backoff,
+				"loc", LOC_CONN_RECONNECT)
+			select {
+			case <-ctx.Done():
+				c.setState(ConnDisconnected)
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+		}
+
+		if err = c.Connect(ctx); err == nil {
+			if wasConnected {
+				c.status.Reconnects++
+			}
+			c.setState(ConnConnected)
+			return nil
+		}
+		c.recordError(err)
+		c.logger.Warn("Archive connection attempt failed",
+			"attempt", attempt,
+			"error", err,
+			"loc", LOC_CONN_RECONNECT)
+	}
+
+	c.setState(ConnDisconnected)
+	return fmt.Errorf("failed to connect to archive after %d attempts: %w (%s)", attempts, err, LOC_CONN_RECONNECT)
+}
+
+// setState updates the connection state and reports it to OnStatusChange.
+func (c *SFTPClient) setState(state ConnectionState) {
+	if c.status.State == state {
+		return
+	}
+	c.status.State = state
+	c.status.Since = time.Now()
+	if c.OnStatusChange != nil {
+		c.OnStatusChange(c.status)
+	}
+}
+
+func (c *SFTPClient) recordError(err error) {
+	c.status.LastError = err.Error()
+	c.status.LastErrorTime = time.Now()
+}
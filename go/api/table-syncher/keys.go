@@ -0,0 +1,179 @@
+package tablesyncher
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Location codes for table key operations
+const (
+	LOC_KEY_RESOLVE  = "SHD_SYN_130"
+	LOC_KEY_VALIDATE = "SHD_SYN_131"
+)
+
+// A table's key columns identify its rows: an INSERT is an upsert on
+// them (ON CONFLICT (<keys>)) and an UPDATE or DELETE matches the row on
+// them. They are the key_columns set in the whitelist (add-tables --key)
+// or else the table's primary key. A table with neither can't be synced.
+
+// parseKeyColumns splits a comma separated key_columns value
+func parseKeyColumns(s string) []string {
+	var cols []string
+	for _, col := range strings.Split(s, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// ValidateKeyColumns checks that 'cols' are distinct columns of the local
+// table 'tableName' and that a unique index on exactly those columns
+// exists, which the upsert's ON CONFLICT (<keys>) needs.
+func ValidateKeyColumns(ctx context.Context, db *sql.DB, tableName string, cols []string) error {
+	if len(cols) == 0 {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx,
+		`SELECT column_name FROM information_schema.columns
+		 WHERE table_schema = current_schema() AND table_name = $1`,
+		tableName)
+	if err != nil {
+		return fmt.Errorf("failed to read columns of %s: %w (%s)", tableName, err, LOC_KEY_VALIDATE)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to scan column of %s: %w (%s)", tableName, err, LOC_KEY_VALIDATE)
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating columns of %s: %w (%s)", tableName, err, LOC_KEY_VALIDATE)
+	}
+	if len(existing) == 0 {
+		return fmt.Errorf("table %s does not exist (%s)", tableName, LOC_KEY_VALIDATE)
+	}
+
+	seen := make(map[string]bool)
+	for _, col := range cols {
+		if !existing[col] {
+			return fmt.Errorf("table %s has no column %s (%s)", tableName, col, LOC_KEY_VALIDATE)
+		}
+		if seen[col] {
+			return fmt.Errorf("key column %s is repeated (%s)", col, LOC_KEY_VALIDATE)
+		}
+		seen[col] = true
+	}
+	return checkUniqueIndex(ctx, db, tableName, cols)
+}
+
+// checkUniqueIndex checks that 'tableName' has a unique index whose key
+// columns are 'cols', in any order. Only an index ON CONFLICT can infer
+// counts: not partial, not on expressions and not deferrable.
+func checkUniqueIndex(ctx context.Context, db *sql.DB, tableName string, cols []string) error {
+	rows, err := db.QueryContext(ctx,
+		`SELECT array_agg(a.attname)
+		 FROM pg_index i
+		 CROSS JOIN LATERAL unnest(i.indkey) WITH ORDINALITY AS k(attnum, ord)
+		 JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+		 WHERE i.indrelid = to_regclass($1) AND i.indisunique AND i.indimmediate
+		   AND i.indpred IS NULL AND i.indexprs IS NULL AND k.ord <= i.indnkeyatts
+		 GROUP BY i.indexrelid`,
+		quoteIdentifier(tableName))
+	if err != nil {
+		return fmt.Errorf("failed to read unique indexes of %s: %w (%s)", tableName, err, LOC_KEY_VALIDATE)
+	}
+	defer rows.Close()
+
+	want := append([]string(nil), cols...)
+	sort.Strings(want)
+	for rows.Next() {
+		var indexCols []string
+		if err := rows.Scan(pq.Array(&indexCols)); err != nil {
+			return fmt.Errorf("failed to scan unique index of %s: %w (%s)", tableName, err, LOC_KEY_VALIDATE)
+		}
+		sort.Strings(indexCols)
+		if slices.Equal(indexCols, want) {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating unique indexes of %s: %w (%s)", tableName, err, LOC_KEY_VALIDATE)
+	}
+	return fmt.Errorf("table %s has no unique index on (%s), which syncing upserts on; create one first (%s)",
+		tableName, strings.Join(cols, ", "), LOC_KEY_VALIDATE)
+}
+
+// tableKeyColumns returns the key columns of a table: its key_columns in
+// the whitelist, or else its primary key.
+func tableKeyColumns(ctx context.Context, db *sql.DB, tableName string) ([]string, error) {
+	var keyColumns sql.NullString
+	err := db.QueryRowContext(ctx,
+		`SELECT key_columns FROM tables_to_sync WHERE table_name = $1`,
+		tableName).Scan(&keyColumns)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to read key columns of %s: %w (%s)", tableName, err, LOC_KEY_RESOLVE)
+	}
+	if cols := parseKeyColumns(keyColumns.String); len(cols) > 0 {
+		return cols, nil
+	}
+
+	var cols []string
+	err = db.QueryRowContext(ctx,
+		`SELECT array_agg(a.attname ORDER BY k.ord)
+		 FROM pg_index i
+		 CROSS JOIN LATERAL unnest(i.indkey) WITH ORDINALITY AS k(attnum, ord)
+		 JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+		 WHERE i.indrelid = to_regclass($1) AND i.indisprimary`,
+		quoteIdentifier(tableName)).Scan(pq.Array(&cols))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read primary key of %s: %w (%s)", tableName, err, LOC_KEY_RESOLVE)
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("table %s has no primary key; set its key columns with add-tables --key (%s)",
+			tableName, LOC_KEY_RESOLVE)
+	}
+	return cols, nil
+}
+
+// keyWhere returns the WHERE clause matching the row with the 'keys'
+// values of 'keyCols', with placeholders from $'next', and its values.
+func keyWhere(keyCols []string, keys map[string]any, next int) (string, []any, error) {
+	clauses := make([]string, 0, len(keyCols))
+	values := make([]any, 0, len(keyCols))
+	for _, col := range keyCols {
+		val, ok := keys[col]
+		if !ok {
+			return "", nil, fmt.Errorf("old_keys has no key column %s", col)
+		}
+		if val == nil {
+			clauses = append(clauses, fmt.Sprintf("%s IS NULL", quoteIdentifier(col)))
+			continue
+		}
+		clauses = append(clauses, fmt.Sprintf("%s = $%d", quoteIdentifier(col), next))
+		values = append(values, val)
+		next++
+	}
+	return strings.Join(clauses, " AND "), values, nil
+}
+
+// sortedColumns returns the columns of 'data' in order, so that the same
+// changes give the same statements
+func sortedColumns(data map[string]any) []string {
+	cols := make([]string, 0, len(data))
+	for col := range data {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	return cols
+}
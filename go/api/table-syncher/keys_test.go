@@ -0,0 +1,111 @@
+package tablesyncher
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseKeyColumns(t *testing.T) {
+	tests := map[string][]string{
+		"":                 nil,
+		" , ":              nil,
+		"id":               {"id"},
+		"tenant_id, id":    {"tenant_id", "id"},
+		" a ,, b ,c, ":     {"a", "b", "c"},
+		"Mixed,\"quoted\"": {"Mixed", `"quoted"`},
+	}
+	for in, want := range tests {
+		if got := parseKeyColumns(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseKeyColumns(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestKeyWhere(t *testing.T) {
+	where, values, err := keyWhere([]string{"tenant_id", "id", "region"},
+		map[string]any{"tenant_id": 7, "id": "a1", "region": nil, "other": 1}, 3)
+	if err != nil {
+		t.Fatalf("keyWhere: %v", err)
+	}
+	if want := `"tenant_id" = $3 AND "id" = $4 AND "region" IS NULL`; where != want {
+		t.Errorf("where = %s, want %s", where, want)
+	}
+	if want := []any{7, "a1"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+
+	// NULL keys take no placeholder
+	where, values, err = keyWhere([]string{"a", "b"}, map[string]any{"a": nil, "b": 2}, 1)
+	if err != nil || where != `"a" IS NULL AND "b" = $1` || !reflect.DeepEqual(values, []any{2}) {
+		t.Errorf("keyWhere = %s, %v, %v", where, values, err)
+	}
+
+	if _, _, err := keyWhere([]string{"tenant_id", "id"}, map[string]any{"id": 1}, 1); err == nil ||
+		!strings.Contains(err.Error(), "old_keys has no key column tenant_id") {
+		t.Errorf("missing key error = %v", err)
+	}
+}
+
+func TestValidateKeyColumns(t *testing.T) {
+	const columnsSQL = `SELECT column_name FROM information_schema.columns`
+	const indexesSQL = `SELECT array_agg(a.attname) FROM pg_index i`
+	columns := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"column_name"}).AddRow("id").AddRow("tenant_id").AddRow("code")
+	}
+	indexes := func(sets ...string) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"array_agg"})
+		for _, set := range sets {
+			rows.AddRow(set)
+		}
+		return rows
+	}
+
+	tests := []struct {
+		name    string
+		cols    []string
+		indexes *sqlmock.Rows // nil if not read
+		wantErr string
+	}{
+		{"no key columns", nil, nil, ""},
+		{"missing column", []string{"id", "nope"}, nil, "table orders has no column nope"},
+		{"repeated column", []string{"id", "id"}, nil, "key column id is repeated"},
+		{"unique index in another order", []string{"code", "tenant_id"},
+			indexes("{id}", "{tenant_id,code}"), ""},
+		{"index on more columns", []string{"code"}, indexes("{id}", "{tenant_id,code}"),
+			"table orders has no unique index on (code)"},
+		{"no unique index", []string{"tenant_id", "id"}, indexes(),
+			"table orders has no unique index on (tenant_id, id)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			if len(tt.cols) > 0 {
+				mock.ExpectQuery(regexp.QuoteMeta(columnsSQL)).WithArgs("orders").WillReturnRows(columns())
+			}
+			if tt.indexes != nil {
+				mock.ExpectQuery(regexp.QuoteMeta(indexesSQL)).WithArgs(`"orders"`).WillReturnRows(tt.indexes)
+			}
+
+			err = ValidateKeyColumns(context.Background(), db, "orders", tt.cols)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("ValidateKeyColumns: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
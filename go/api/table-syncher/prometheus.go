@@ -0,0 +1,161 @@
+package tablesyncher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Location codes for the Prometheus metrics endpoint
+const (
+	LOC_PROM_SCRAPE = "SHD_SYN_140"
+	LOC_PROM_SERVE  = "SHD_SYN_141"
+)
+
+// The daemon's runtime state is exported in the Prometheus text format:
+//
+//	syncdata_records_applied_total{table,op}  counter, since the daemon started
+//	syncdata_lag_seconds{table}               gauge, age of the newest change applied
+//	syncdata_cycle_duration_seconds           gauge, of the last sync cycle
+//	syncdata_errors_total                     counter, since the daemon started
+//
+// The metrics are read from the runtime state RunLoop records after every
+// cycle, in the daemon (SyncDataService.MetricsHandler) or from the state
+// file by another process (StateFileMetricsHandler). The counters restart
+// from 0 with the daemon, which Prometheus handles as a counter reset.
+
+// WriteMetrics writes the metrics of the runtime state 'rt' to 'w'. A nil
+// 'rt' (the daemon never ran) writes the metrics without samples.
+func WriteMetrics(w io.Writer, rt *DaemonRuntime, now time.Time) error {
+	var buf bytes.Buffer
+	var tables []string
+	if rt != nil {
+		for name := range rt.Tables {
+			tables = append(tables, name)
+		}
+		sort.Strings(tables)
+	}
+
+	writeMetricHeader(&buf, "syncdata_records_applied_total", "counter",
+		"Change records applied to a table since the daemon started.")
+	for _, name := range tables {
+		applied := rt.Tables[name].Applied
+		for _, op := range []struct {
+			name  string
+			count int64
+		}{{"insert", applied.Inserts}, {"update", applied.Updates}, {"delete", applied.Deletes}} {
+			fmt.Fprintf(&buf, "syncdata_records_applied_total{table=\"%s\",op=\"%s\"} %d\n",
+				escapeLabelValue(name), op.name, op.count)
+		}
+	}
+
+	writeMetricHeader(&buf, "syncdata_lag_seconds", "gauge",
+		"Age of the newest change applied to a table, 0 until one is applied.")
+	for _, name := range tables {
+		fmt.Fprintf(&buf, "syncdata_lag_seconds{table=\"%s\"} %g\n",
+			escapeLabelValue(name), rt.Tables[name].Lag(now).Seconds())
+	}
+
+	writeMetricHeader(&buf, "syncdata_cycle_duration_seconds", "gauge",
+		"Duration of the last sync cycle.")
+	if rt != nil {
+		fmt.Fprintf(&buf, "syncdata_cycle_duration_seconds %g\n", rt.CycleDuration.Seconds())
+	}
+
+	writeMetricHeader(&buf, "syncdata_errors_total", "counter",
+		"Failed sync cycles and change files since the daemon started.")
+	if rt != nil {
+		fmt.Fprintf(&buf, "syncdata_errors_total %d\n", rt.ErrorCount)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of a metric
+func writeMetricHeader(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// escapeLabelValue escapes a label value for the Prometheus text format
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// NewMetricsHandler returns an http.Handler serving the metrics of the
+// runtime state returned by 'runtime' on every scrape.
+func NewMetricsHandler(runtime func() (*DaemonRuntime, error), logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt, err := runtime()
+		if err != nil {
+			logger.Error("Failed to read runtime state for metrics", "error", err, "loc", LOC_PROM_SCRAPE)
+			http.Error(w, "failed to read runtime state", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WriteMetrics(w, rt, time.Now()); err != nil {
+			logger.Warn("Failed to write metrics", "error", err, "loc", LOC_PROM_SCRAPE)
+		}
+	})
+}
+
+// MetricsHandler returns an http.Handler serving the metrics of the
+// service's RunLoop, for a listener embedded in the daemon. The runtime
+// state is read under the state manager's lock, so it is safe to serve
+// while the loop runs.
+func (s *SyncDataService) MetricsHandler() http.Handler {
+	return NewMetricsHandler(func() (*DaemonRuntime, error) {
+		return s.state.GetRuntime(), nil
+	}, s.logger)
+}
+
+// StateFileMetricsHandler returns an http.Handler serving the metrics of
+// the runtime state in the state file at 'path', read on every scrape, for
+// a process running alongside the daemon. A missing state file serves the
+// metrics without samples.
+func StateFileMetricsHandler(path string, logger *slog.Logger) http.Handler {
+	return NewMetricsHandler(func() (*DaemonRuntime, error) {
+		state := NewStateManager(path)
+		if err := state.Load(); err != nil {
+			return nil, err
+		}
+		return state.GetRuntime(), nil
+	}, logger)
+}
+
+// ServeMetrics serves 'handler' at /metrics on 'addr' until ctx is
+// cancelled.
+func ServeMetrics(ctx context.Context, addr string, handler http.Handler, logger *slog.Logger) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+	logger.Info("Serving metrics", "addr", addr, "path", "/metrics", "loc", LOC_PROM_SERVE)
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("metrics listener failed: %w (%s)", err, LOC_PROM_SERVE)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("failed to stop metrics listener: %w (%s)", err, LOC_PROM_SERVE)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,105 @@
+package tablesyncher
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const metricsHeaders = "# HELP syncdata_records_applied_total Change records applied to a table since the daemon started.\n" +
+	"# TYPE syncdata_records_applied_total counter\n" +
+	"%s" +
+	"# HELP syncdata_lag_seconds Age of the newest change applied to a table, 0 until one is applied.\n" +
+	"# TYPE syncdata_lag_seconds gauge\n" +
+	"%s" +
+	"# HELP syncdata_cycle_duration_seconds Duration of the last sync cycle.\n" +
+	"# TYPE syncdata_cycle_duration_seconds gauge\n" +
+	"%s" +
+	"# HELP syncdata_errors_total Failed sync cycles and change files since the daemon started.\n" +
+	"# TYPE syncdata_errors_total counter\n" +
+	"%s"
+
+func TestWriteMetrics(t *testing.T) {
+	now := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	rt := &DaemonRuntime{
+		CycleDuration: 1500 * time.Millisecond,
+		ErrorCount:    2,
+		Tables: map[string]*TableRuntime{
+			"orders": {
+				LatestChange: now.Add(-90 * time.Second),
+				Applied:      AppliedCounts{Inserts: 5, Updates: 2, Deletes: 1},
+			},
+			// Label values escape backslashes, quotes and newlines
+			"a\\b\"c\nd": {},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf, rt, now); err != nil {
+		t.Fatalf("WriteMetrics: %v", err)
+	}
+	want := sprintfMetrics(
+		`syncdata_records_applied_total{table="a\\b\"c\nd",op="insert"} 0`+"\n"+
+			`syncdata_records_applied_total{table="a\\b\"c\nd",op="update"} 0`+"\n"+
+			`syncdata_records_applied_total{table="a\\b\"c\nd",op="delete"} 0`+"\n"+
+			`syncdata_records_applied_total{table="orders",op="insert"} 5`+"\n"+
+			`syncdata_records_applied_total{table="orders",op="update"} 2`+"\n"+
+			`syncdata_records_applied_total{table="orders",op="delete"} 1`+"\n",
+		`syncdata_lag_seconds{table="a\\b\"c\nd"} 0`+"\n"+
+			`syncdata_lag_seconds{table="orders"} 90`+"\n",
+		"syncdata_cycle_duration_seconds 1.5\n",
+		"syncdata_errors_total 2\n")
+	if got := buf.String(); got != want {
+		t.Errorf("metrics =\n%s\nwant\n%s", got, want)
+	}
+
+	// The daemon never ran: the metrics without samples
+	buf.Reset()
+	if err := WriteMetrics(&buf, nil, now); err != nil {
+		t.Fatalf("WriteMetrics(nil): %v", err)
+	}
+	if got, want := buf.String(), sprintfMetrics("", "", "", ""); got != want {
+		t.Errorf("metrics without runtime =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	tests := map[string]string{
+		"orders":       "orders",
+		`a\b`:          `a\\b`,
+		`say "hi"`:     `say \"hi\"`,
+		"two\nlines":   `two\nlines`,
+		"\\\"\n":       `\\\"\n`,
+		"tab\tstays\r": "tab\tstays\r",
+	}
+	for in, want := range tests {
+		if got := escapeLabelValue(in); got != want {
+			t.Errorf("escapeLabelValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// A missing state file serves the metrics without samples
+func TestStateFileMetricsHandlerWithoutState(t *testing.T) {
+	handler := StateFileMetricsHandler(filepath.Join(t.TempDir(), "state.json"),
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got, want := rec.Body.String(), sprintfMetrics("", "", "", ""); got != want {
+		t.Errorf("metrics =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func sprintfMetrics(applied, lag, duration, errors string) string {
+	return fmt.Sprintf(metricsHeaders, applied, lag, duration, errors)
+}
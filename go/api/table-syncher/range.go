@@ -0,0 +1,286 @@
+package tablesyncher
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Location codes for range sync operations
+const (
+	LOC_RANGE_PARSE = "SHD_SYN_100"
+	LOC_RANGE_SYNC  = "SHD_SYN_101"
+)
+
+// SyncRange bounds a range sync, inclusive at both ends. Either the
+// timestamps or the LSNs are set, never both.
+type SyncRange struct {
+	FromTS  time.Time
+	ToTS    time.Time
+	FromLSN string
+	ToLSN   string
+
+	fromLSN uint64
+	toLSN   uint64
+}
+
+// IsLSN reports whether the range is bounded by LSNs
+func (r *SyncRange) IsLSN() bool {
+	return r.FromLSN != ""
+}
+
+// String formats the range for logs and data_sync_logs.archive_ref
+func (r *SyncRange) String() string {
+	if r.IsLSN() {
+		return "lsn:" + r.FromLSN + ".." + r.ToLSN
+	}
+	return "ts:" + r.FromTS.Format(time.RFC3339) + ".." + r.ToTS.Format(time.RFC3339)
+}
+
+// Contains reports whether the change record falls in the range
+func (r *SyncRange) Contains(rec ChangeRecord) bool {
+	if r.IsLSN() {
+		lsn, err := ParseLSN(rec.LSN)
+		if err != nil {
+			return false
+		}
+		return lsn >= r.fromLSN && lsn <= r.toLSN
+	}
+	return !rec.TS.Before(r.FromTS) && !rec.TS.After(r.ToTS)
+}
+
+// startsBefore reports whether the range starts before the record
+func (r *SyncRange) startsBefore(rec ChangeRecord) bool {
+	if r.IsLSN() {
+		lsn, err := ParseLSN(rec.LSN)
+		return err == nil && r.fromLSN < lsn
+	}
+	return r.FromTS.Before(rec.TS)
+}
+
+// NewSyncRange parses the --from/--to values of 'syncdata sync-range'.
+// Both must be LSNs ("16/B374D848") or both timestamps (RFC 3339,
+// "2006-01-02 15:04:05" or "2006-01-02"). A date-only 'to' covers the
+// whole day.
+func NewSyncRange(from, to string) (*SyncRange, error) {
+	from = strings.TrimSpace(from)
+	to = strings.TrimSpace(to)
+	if from == "" || to == "" {
+		return nil, fmt.Errorf("both from and to are required (%s)", LOC_RANGE_PARSE)
+	}
+
+	from_is_lsn := strings.Contains(from, "/")
+	if from_is_lsn != strings.Contains(to, "/") {
+		return nil, fmt.Errorf("from and to must both be LSNs or both timestamps (%s)", LOC_RANGE_PARSE)
+	}
+
+	r := &SyncRange{}
+	if from_is_lsn {
+		var err error
+		if r.fromLSN, err = ParseLSN(from); err != nil {
+			return nil, err
+		}
+		if r.toLSN, err = ParseLSN(to); err != nil {
+			return nil, err
+		}
+		if r.fromLSN > r.toLSN {
+			return nil, fmt.Errorf("from LSN %s is after to LSN %s (%s)", from, to, LOC_RANGE_PARSE)
+		}
+		r.FromLSN = from
+		r.ToLSN = to
+		return r, nil
+	}
+
+	var err error
+	if r.FromTS, _, err = parseRangeTime(from); err != nil {
+		return nil, err
+	}
+	var date_only bool
+	if r.ToTS, date_only, err = parseRangeTime(to); err != nil {
+		return nil, err
+	}
+	if date_only {
+		r.ToTS = r.ToTS.Add(24*time.Hour - time.Nanosecond)
+	}
+	if r.FromTS.After(r.ToTS) {
+		return nil, fmt.Errorf("from %s is after to %s (%s)", from, to, LOC_RANGE_PARSE)
+	}
+	return r, nil
+}
+
+// parseRangeTime parses a timestamp; times without a zone are UTC
+func parseRangeTime(s string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", s); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid timestamp %q (%s)", s, LOC_RANGE_PARSE)
+}
+
+// ParseLSN converts a PostgreSQL LSN ("16/B374D848") to a comparable number
+func ParseLSN(lsn string) (uint64, error) {
+	hi, lo, found := strings.Cut(lsn, "/")
+	if !found {
+		return 0, fmt.Errorf("invalid LSN %q (%s)", lsn, LOC_RANGE_PARSE)
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q (%s)", lsn, LOC_RANGE_PARSE)
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q (%s)", lsn, LOC_RANGE_PARSE)
+	}
+	return h<<32 | l, nil
+}
+
+// SyncRangeResult summarizes a range sync.
+type SyncRangeResult struct {
+	SyncResult
+	FilesScanned      int
+	RecordsOutOfRange int64 // Records of the table outside the range
+	// PredatesArchive is set when the range starts before the oldest
+	// record in the archive, so part of it could not be synced.
+	PredatesArchive bool
+	OldestAvailable string // TS or LSN of the oldest archived record, if any
+}
+
+// SyncRange applies the changes of one table that fall in 'rng'. It
+// reads every change file in the archive and leaves the normal sync
+// checkpoint untouched, so it can backfill a period without a resync.
+func (s *SyncDataService) SyncRange(ctx context.Context, tableName string, rng *SyncRange) (*SyncRangeResult, error) {
+	start := time.Now()
+	result := &SyncRangeResult{}
+	s.logger.Info("Syncing table range",
+		"table", tableName,
+		"range", rng.String(),
+		"loc", LOC_RANGE_SYNC)
+
+	inWhitelist, err := IsTableInWhitelist(ctx, s.db, tableName)
+	if err != nil {
+		return nil, err
+	}
+	if !inWhitelist {
+		return nil, fmt.Errorf("table %s is not in sync whitelist (%s)", tableName, LOC_RANGE_SYNC)
+	}
+
+	if err := s.sftpClient.EnsureConnected(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to archive: %w (%s)", err, LOC_RANGE_SYNC)
+	}
+
+	changeFiles, err := s.sftpClient.DiscoverChangeFiles(ctx, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover change files: %w (%s)", err, LOC_RANGE_SYNC)
+	}
+
+	whitelist := map[string]bool{tableName: true}
+	seenOldest := false
+	for _, cf := range changeFiles {
+		select {
+		case <-ctx.Done():
+			result.Duration = time.Since(start)
+			return result, ctx.Err()
+		default:
+		}
+
+		// A file is written after all of its records, so files last
+		// modified before the range cannot contain any of it. The oldest
+		// file is always read to check the range against the archive.
+		if seenOldest && !rng.IsLSN() && cf.ModTime.Before(rng.FromTS) {
+			continue
+		}
+
+		records, err := s.fetchChangeFile(ctx, cf)
+		if err != nil {
+			s.logger.Error("Failed to fetch change file",
+				"file", cf.Name,
+				"error", err,
+				"loc", LOC_RANGE_SYNC)
+			s.stats.ErrorCount++
+			if s.sftpClient.Status().State != ConnConnected {
+				result.Duration = time.Since(start)
+				return result, fmt.Errorf("archive connection lost: %w (%s)", err, LOC_RANGE_SYNC)
+			}
+			continue
+		}
+		result.FilesScanned++
+
+		if !seenOldest && len(records) > 0 {
+			seenOldest = true
+			oldest := records[0]
+			if rng.IsLSN() {
+				result.OldestAvailable = oldest.LSN
+			} else {
+				result.OldestAvailable = oldest.TS.Format(time.RFC3339)
+			}
+			result.PredatesArchive = rng.startsBefore(oldest)
+		}
+
+		var inRange []ChangeRecord
+		for _, rec := range records {
+			if rec.Table != tableName {
+				continue
+			}
+			if !rng.Contains(rec) {
+				result.RecordsOutOfRange++
+				continue
+			}
+			inRange = append(inRange, rec)
+		}
+		if len(inRange) == 0 {
+			continue
+		}
+
+		fileResult, err := ApplyChanges(ctx, s.db, inRange, whitelist,
+			ApplyOptions{MaxTxRecords: s.config.MaxTxRecords}, s.logger)
+		result.RecordsAdded += fileResult.RecordsAdded
+		result.RecordsUpdated += fileResult.RecordsUpdated
+		result.RecordsDeleted += fileResult.RecordsDeleted
+		result.RecordsFailed += fileResult.RecordsFailed
+		if err != nil {
+			s.logger.Error("Failed to apply changes",
+				"file", cf.Name,
+				"error", err,
+				"loc", LOC_RANGE_SYNC)
+			s.stats.ErrorCount++
+			LogSyncEvent(ctx, s.db, tableName, "FAILED", 0, cf.Name+" "+rng.String(), err.Error())
+			continue
+		}
+
+		result.FilesProcessed++
+		result.LastLSN = fileResult.LastLSN
+	}
+
+	if !seenOldest {
+		// Nothing archived at all
+		result.PredatesArchive = true
+	}
+	if result.PredatesArchive {
+		s.logger.Warn("Requested range starts before the oldest archived change; earlier changes cannot be synced",
+			"table", tableName,
+			"range", rng.String(),
+			"oldest_available", result.OldestAvailable,
+			"loc", LOC_RANGE_SYNC)
+	}
+
+	totalSynced := int(result.RecordsAdded + result.RecordsUpdated + result.RecordsDeleted)
+	LogSyncEvent(ctx, s.db, tableName, "SUCCESS", totalSynced, rng.String(), "")
+
+	result.Duration = time.Since(start)
+	s.logger.Info("Range sync complete",
+		"table", tableName,
+		"files_scanned", result.FilesScanned,
+		"added", result.RecordsAdded,
+		"updated", result.RecordsUpdated,
+		"deleted", result.RecordsDeleted,
+		"out_of_range", result.RecordsOutOfRange,
+		"loc", LOC_RANGE_SYNC)
+	return result, nil
+}
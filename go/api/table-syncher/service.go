@@ -3,8 +3,10 @@ package tablesyncher
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"sync/atomic"
 	"time"
 )
@@ -26,6 +28,8 @@ type SyncDataService struct {
 	stats      *RuntimeStats
 	sftpClient *SFTPClient
 	metrics    *MetricsAggregator
+	webhook    *WebhookNotifier
+	runtime    *DaemonRuntime // Saved to the state file by RunLoop
 
 	// Runtime state
 	isRunning atomic.Bool
@@ -34,9 +38,10 @@ type SyncDataService struct {
 // NewService creates a new SyncDataService with a logger.
 func NewService(config *SyncConfig, logger *slog.Logger) *SyncDataService {
 	return &SyncDataService{
-		config: config,
-		logger: logger,
-		state:  NewStateManager(config.StateFilePath),
+		config:  config,
+		logger:  logger,
+		state:   NewStateManager(config.StateFilePath),
+		webhook: NewWebhookNotifier(config, logger),
 		stats: &RuntimeStats{
 			StartTime: time.Now(),
 		},
@@ -117,36 +122,53 @@ func (s *SyncDataService) GetStats() *RuntimeStats {
 	}
 }
 
-// RunOnce performs a single sync cycle.
+// RunOnce performs a single sync cycle of all tables in the whitelist.
 func (s *SyncDataService) RunOnce(ctx context.Context) (*SyncResult, error) {
-	start := time.Now()
-	result := &SyncResult{}
-
-	// Connect to SFTP if not connected
-	if s.sftpClient.sftpClient == nil {
-		if err := s.sftpClient.Connect(ctx); err != nil {
-			return nil, fmt.Errorf("failed to connect to archive: %w (%s)", err, LOC_SVC_SYNC)
-		}
-	}
-
-	// Get whitelist of tables
-	tableNames, err := GetTableNames(ctx, s.db)
+	tables, err := ListTables(ctx, s.db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get table whitelist: %w (%s)", err, LOC_SVC_SYNC)
 	}
+	return s.syncTables(ctx, tables, tables)
+}
 
-	if len(tableNames) == 0 {
+// syncTables performs a sync cycle of the 'due' tables, out of all
+// 'tables' in the whitelist. Each table advances through the change
+// files on its own, so a table that was not due picks up the files it
+// missed the next time it is.
+func (s *SyncDataService) syncTables(ctx context.Context, tables []TableInfo, due []TableInfo) (*SyncResult, error) {
+	start := time.Now()
+	result := &SyncResult{LatestChange: make(map[string]time.Time)}
+
+	if len(due) == 0 {
 		s.logger.Debug("No tables in whitelist, skipping sync")
 		return result, nil
 	}
 
-	whitelist := make(map[string]bool)
-	for _, t := range tableNames {
-		whitelist[t] = true
+	// Connect to SFTP, or reconnect if the connection dropped
+	if err := s.sftpClient.EnsureConnected(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to archive: %w (%s)", err, LOC_SVC_SYNC)
+	}
+
+	// New tables load their snapshot before their changes are applied
+	due, snapshotErr := s.bootstrapTables(ctx, due, result)
+	if len(due) == 0 {
+		result.Duration = time.Since(start)
+		return result, snapshotErr
 	}
 
-	// Discover new change files
-	lastFileTime := s.state.GetLastFileTime()
+	// Discover the change files newer than the least advanced due table
+	tableFileTime := make(map[string]time.Time, len(due))
+	afterLSN := make(map[string]uint64)
+	var lastFileTime time.Time
+	for i, t := range due {
+		tableFileTime[t.TableName] = s.state.GetTableFileTime(t.TableName)
+		if i == 0 || tableFileTime[t.TableName].Before(lastFileTime) {
+			lastFileTime = tableFileTime[t.TableName]
+		}
+		if lsn, err := ParseLSN(s.state.GetSnapshotLSN(t.TableName)); err == nil {
+			afterLSN[t.TableName] = lsn
+		}
+	}
 	changeFiles, err := s.sftpClient.DiscoverChangeFiles(ctx, lastFileTime)
 	if err != nil {
 		return nil, fmt.Errorf("failed to discover change files: %w (%s)", err, LOC_SVC_SYNC)
@@ -157,6 +179,7 @@ func (s *SyncDataService) RunOnce(ctx context.Context) (*SyncResult, error) {
 		"since", lastFileTime)
 
 	// Process each change file
+	var cycleErr error
 	for _, cf := range changeFiles {
 		select {
 		case <-ctx.Done():
@@ -165,40 +188,71 @@ func (s *SyncDataService) RunOnce(ctx context.Context) (*SyncResult, error) {
 		default:
 		}
 
-		records, err := s.sftpClient.FetchChangeFile(ctx, cf)
+		fileTables := tablesForFile(due, tableFileTime, cf.ModTime)
+		if len(fileTables) == 0 {
+			continue
+		}
+		whitelist := make(map[string]bool, len(fileTables))
+		for _, name := range fileTables {
+			whitelist[name] = true
+		}
+
+		records, err := s.fetchChangeFile(ctx, cf)
 		if err != nil {
 			s.logger.Error("Failed to fetch change file",
 				"file", cf.Name,
 				"error", err,
 				"loc", LOC_SVC_SYNC)
 			s.stats.ErrorCount++
+			result.Errors++
+			if s.sftpClient.Status().State != ConnConnected {
+				// The next cycle reconnects and picks up from this file
+				result.Duration = time.Since(start)
+				return result, fmt.Errorf("archive connection lost: %w (%s)", err, LOC_SVC_SYNC)
+			}
 			continue
 		}
 
-		// Apply changes
-		fileResult, err := ApplyChanges(ctx, s.db, records, whitelist, s.logger)
+		// Apply changes. Each table's progress in the file is checkpointed
+		// with its changes, so a file applied again resumes where it stopped.
+		fileResult, err := ApplyChanges(ctx, s.db, records, whitelist,
+			ApplyOptions{ChangeFile: cf.Name, MaxTxRecords: s.config.MaxTxRecords, AfterLSN: afterLSN}, s.logger)
+
+		// Accumulate results
+		result.RecordsAdded += fileResult.RecordsAdded
+		result.RecordsUpdated += fileResult.RecordsUpdated
+		result.RecordsDeleted += fileResult.RecordsDeleted
+		result.RecordsSkipped += fileResult.RecordsSkipped
+		result.RecordsFailed += fileResult.RecordsFailed
+		for table, ts := range fileResult.LatestChange {
+			if ts.After(result.LatestChange[table]) {
+				result.LatestChange[table] = ts
+			}
+		}
+		for table, counts := range fileResult.Applied {
+			result.addApplied(table, counts)
+		}
+
 		if err != nil {
 			s.logger.Error("Failed to apply changes",
 				"file", cf.Name,
 				"error", err,
 				"loc", LOC_SVC_SYNC)
 			s.stats.ErrorCount++
+			result.Errors++
 
 			// Log failure
 			LogSyncEvent(ctx, s.db, "*", "FAILED", 0, cf.Name, err.Error())
-			continue
-		}
 
-		// Accumulate results
+			// Later files must not be applied before this one: stop here,
+			// so the next cycle replays it from the checkpoints
+			cycleErr = fmt.Errorf("changes of %s not fully applied: %w (%s)", cf.Name, err, LOC_SVC_SYNC)
+			break
+		}
 		result.FilesProcessed++
-		result.RecordsAdded += fileResult.RecordsAdded
-		result.RecordsUpdated += fileResult.RecordsUpdated
-		result.RecordsDeleted += fileResult.RecordsDeleted
-		result.RecordsSkipped += fileResult.RecordsSkipped
-		result.RecordsFailed += fileResult.RecordsFailed
 
 		// Update state
-		if err := s.state.SetLastFile(cf.Name, cf.ModTime); err != nil {
+		if err := s.state.SetTableFile(fileTables, cf.Name, cf.ModTime); err != nil {
 			s.logger.Error("Failed to update state",
 				"file", cf.Name,
 				"error", err,
@@ -217,6 +271,15 @@ func (s *SyncDataService) RunOnce(ctx context.Context) (*SyncResult, error) {
 			"skipped", fileResult.RecordsSkipped)
 	}
 
+	// The global last file is the newest file every table has applied
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = t.TableName
+	}
+	if err := s.state.AdvanceLastFile(names); err != nil {
+		s.logger.Error("Failed to update state", "error", err, "loc", LOC_SVC_SYNC)
+	}
+
 	result.Duration = time.Since(start)
 	result.LastLSN = s.state.GetGlobalLSN()
 
@@ -226,19 +289,48 @@ func (s *SyncDataService) RunOnce(ctx context.Context) (*SyncResult, error) {
 	s.stats.LastSyncTime = time.Now()
 	s.stats.LastSyncResult = result
 
-	return result, nil
+	return result, errors.Join(snapshotErr, cycleErr)
 }
 
-// RunLoop starts the polling loop at the configured frequency.
-// Blocks until ctx is cancelled.
+// tablesForFile returns the 'due' tables that have not applied the change
+// file of 'modTime' yet, given the time of the last file each applied
+// (zero for none).
+func tablesForFile(due []TableInfo, tableFileTime map[string]time.Time, modTime time.Time) []string {
+	names := make([]string, 0, len(due))
+	for _, t := range due {
+		if last := tableFileTime[t.TableName]; last.IsZero() || modTime.After(last) {
+			names = append(names, t.TableName)
+		}
+	}
+	return names
+}
+
+// fetchChangeFile fetches a change file. If the fetch failed because the
+// archive connection dropped, it reconnects and retries once.
+func (s *SyncDataService) fetchChangeFile(ctx context.Context, cf ChangeFile) ([]ChangeRecord, error) {
+	records, err := s.sftpClient.FetchChangeFile(ctx, cf)
+	if err == nil || s.sftpClient.HealthCheck(ctx) == nil {
+		return records, err
+	}
+
+	if err := s.sftpClient.EnsureConnected(ctx); err != nil {
+		return nil, err
+	}
+	return s.sftpClient.FetchChangeFile(ctx, cf)
+}
+
+// RunLoop starts the polling loop. Each table is synced at its own
+// frequency (the global data_sync_freq unless set per table); the loop
+// wakes when the next table is due. Blocks until ctx is cancelled.
 func (s *SyncDataService) RunLoop(ctx context.Context) error {
 	if !s.isRunning.CompareAndSwap(false, true) {
 		return fmt.Errorf("service is already running (%s)", LOC_SVC_RUN)
 	}
 	defer s.isRunning.Store(false)
 
-	ticker := time.NewTicker(time.Duration(s.config.DataSyncFreq) * time.Second)
-	defer ticker.Stop()
+	globalFreq := time.Duration(s.config.DataSyncFreq) * time.Second
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
 	// Metrics aggregation ticker (hourly check, but only aggregate at MetricFreq)
 	metricsTicker := time.NewTicker(1 * time.Hour)
@@ -249,38 +341,69 @@ func (s *SyncDataService) RunLoop(ctx context.Context) error {
 		"frequency", s.config.DataSyncFreq,
 		"loc", LOC_SVC_RUN)
 
-	// Run once immediately on startup
-	if result, err := s.RunOnce(ctx); err != nil {
-		s.logger.Error("Initial sync failed", "error", err, "loc", LOC_SVC_RUN)
-	} else if result.FilesProcessed > 0 {
-		s.logger.Info("Initial sync complete",
-			"files", result.FilesProcessed,
-			"added", result.RecordsAdded,
-			"updated", result.RecordsUpdated,
-			"deleted", result.RecordsDeleted,
-			"duration", result.Duration)
+	// The runtime state goes to the state file for `syncdata status`
+	s.runtime = &DaemonRuntime{
+		PID:       os.Getpid(),
+		StartTime: s.stats.StartTime,
+		Tables:    make(map[string]*TableRuntime),
+	}
+	if err := s.state.SetRuntime(*s.runtime); err != nil {
+		s.logger.Error("Failed to save runtime state", "error", err, "loc", LOC_SVC_RUN)
+	}
+
+	// So does the archive connection state. Only the daemon reports it:
+	// a one-off verify or sync-range must not overwrite what the running
+	// daemon reported.
+	s.sftpClient.OnStatusChange = func(cs ConnectionStatus) {
+		if err := s.state.SetConnection(cs); err != nil {
+			s.logger.Error("Failed to save connection state", "error", err, "loc", LOC_SVC_RUN)
+		}
 	}
 
+	// Tables not synced since startup are due, so all run immediately
+	lastSynced := make(map[string]time.Time)
 	for {
 		select {
 		case <-ctx.Done():
 			s.logger.Info("Shutting down sync service", "loc", LOC_SVC_RUN)
 			return nil
 
-		case <-ticker.C:
-			result, err := s.RunOnce(ctx)
+		case <-timer.C:
+			// The whitelist is read every cycle: add-tables applies
+			// without a restart
+			tables, err := ListTables(ctx, s.db)
 			if err != nil {
-				s.logger.Error("Sync cycle failed", "error", err, "loc", LOC_SVC_RUN)
+				s.logger.Error("Failed to get table whitelist", "error", err, "loc", LOC_SVC_RUN)
 				s.stats.ErrorCount++
-			} else if result.FilesProcessed > 0 {
-				s.logger.Info("Sync cycle complete",
-					"files", result.FilesProcessed,
-					"added", result.RecordsAdded,
-					"updated", result.RecordsUpdated,
-					"deleted", result.RecordsDeleted,
-					"duration", result.Duration)
+				s.recordCycle(nil, nil, nil, err, time.Now())
+				timer.Reset(globalFreq)
+				continue
 			}
 
+			now := time.Now()
+			due := dueTables(tables, lastSynced, s.config.DataSyncFreq, now)
+			if len(due) > 0 {
+				result, err := s.syncTables(ctx, tables, due)
+				s.webhook.Notify(ctx, result, err)
+				if err != nil {
+					s.logger.Error("Sync cycle failed", "error", err, "loc", LOC_SVC_RUN)
+					s.stats.ErrorCount++
+				} else if result.FilesProcessed > 0 {
+					s.logger.Info("Sync cycle complete",
+						"tables", len(due),
+						"files", result.FilesProcessed,
+						"added", result.RecordsAdded,
+						"updated", result.RecordsUpdated,
+						"deleted", result.RecordsDeleted,
+						"duration", result.Duration)
+				}
+				for _, t := range due {
+					lastSynced[t.TableName] = now
+				}
+				s.recordCycle(tables, due, result, err, now)
+			}
+			timer.Reset(nextDue(tables, lastSynced, s.config.DataSyncFreq, time.Now()))
+
 		case <-metricsTicker.C:
 			// Check if it's time to aggregate metrics
 			hoursSinceLastRun := time.Since(lastMetricsRun).Hours()
@@ -295,6 +418,97 @@ func (s *SyncDataService) RunLoop(ctx context.Context) error {
 	}
 }
 
+// recordCycle updates the runtime state with a sync cycle of the 'due'
+// tables, out of the whitelisted 'tables', and saves it to the state
+// file. A cycle that could not read the whitelist passes nil tables and
+// keeps the tables of the previous cycle.
+func (s *SyncDataService) recordCycle(tables, due []TableInfo, result *SyncResult, cycleErr error, now time.Time) {
+	rt := s.runtime
+	if rt == nil {
+		return
+	}
+	rt.LastCycle = now
+	rt.ErrorCount = s.stats.ErrorCount
+	rt.RecordsSynced = s.stats.RecordsSynced
+	if cycleErr != nil {
+		rt.LastError = cycleErr.Error()
+		rt.LastErrorTime = now
+	} else if result != nil && result.Errors > 0 {
+		rt.LastError = fmt.Sprintf("%d change files failed", result.Errors)
+		rt.LastErrorTime = now
+	}
+
+	if tables != nil {
+		// Tables removed from the whitelist are dropped
+		current := make(map[string]*TableRuntime, len(tables))
+		for _, t := range tables {
+			tr := rt.Tables[t.TableName]
+			if tr == nil {
+				tr = &TableRuntime{}
+			}
+			tr.SyncFreq = t.SyncFreq
+			current[t.TableName] = tr
+		}
+		rt.Tables = current
+	}
+	if cycleErr == nil {
+		for _, t := range due {
+			if tr := rt.Tables[t.TableName]; tr != nil {
+				tr.LastSynced = now
+			}
+		}
+	}
+	if result != nil {
+		rt.CycleDuration = result.Duration
+		for table, ts := range result.LatestChange {
+			if tr := rt.Tables[table]; tr != nil && ts.After(tr.LatestChange) {
+				tr.LatestChange = ts
+			}
+		}
+		for table, counts := range result.Applied {
+			if tr := rt.Tables[table]; tr != nil {
+				tr.Applied = tr.Applied.Add(counts)
+			}
+		}
+	}
+
+	if err := s.state.SetRuntime(*rt); err != nil {
+		s.logger.Error("Failed to save runtime state", "error", err, "loc", LOC_SVC_RUN)
+	}
+}
+
+// dueTables returns the tables whose frequency has elapsed since they
+// were last synced, including tables not synced yet.
+func dueTables(tables []TableInfo, lastSynced map[string]time.Time, globalFreq int, now time.Time) []TableInfo {
+	var due []TableInfo
+	for _, t := range tables {
+		last, ok := lastSynced[t.TableName]
+		if !ok || !now.Before(last.Add(t.Frequency(globalFreq))) {
+			due = append(due, t)
+		}
+	}
+	return due
+}
+
+// nextDue returns how long until the next table is due, at most the
+// global frequency so that new tables are picked up.
+func nextDue(tables []TableInfo, lastSynced map[string]time.Time, globalFreq int, now time.Time) time.Duration {
+	wait := time.Duration(globalFreq) * time.Second
+	for _, t := range tables {
+		last, ok := lastSynced[t.TableName]
+		if !ok {
+			return 0
+		}
+		if d := last.Add(t.Frequency(globalFreq)).Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
 // Resync drops and reloads a specific table.
 func (s *SyncDataService) Resync(ctx context.Context, tableName string) (*SyncResult, error) {
 	s.logger.Info("Resyncing table", "table", tableName, "loc", LOC_SVC_SYNC)
@@ -357,5 +571,14 @@ func (s *SyncDataService) ListTables(ctx context.Context) ([]TableInfo, error) {
 
 // GetStatus returns the current daemon status.
 func (s *SyncDataService) GetStatus(ctx context.Context) (*DaemonStatus, error) {
-	return GetDaemonStatus(ctx, s.config, s.db)
+	status, err := GetDaemonStatus(ctx, s.config, s.db)
+	if err != nil {
+		return nil, err
+	}
+	// This process's own connection is more current than the state file
+	if s.sftpClient != nil && s.sftpClient.Status().State != "" {
+		cs := s.sftpClient.Status()
+		status.Connection = &cs
+	}
+	return status, nil
 }
@@ -0,0 +1,133 @@
+package tablesyncher
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDueTables(t *testing.T) {
+	now := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	tables := []TableInfo{
+		{TableName: "orders", SyncFreq: 10},
+		{TableName: "countries", SyncFreq: 3600},
+		{TableName: "users"},
+		{TableName: "new"},
+	}
+	lastSynced := map[string]time.Time{
+		"orders":    now.Add(-10 * time.Second),
+		"countries": now.Add(-30 * time.Minute),
+		"users":     now.Add(-5 * time.Minute),
+	}
+
+	var names []string
+	for _, table := range dueTables(tables, lastSynced, 600, now) {
+		names = append(names, table.TableName)
+	}
+	// orders is due on the second, users in 5 minutes, countries in 30
+	if want := []string{"orders", "new"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("due = %v, want %v", names, want)
+	}
+}
+
+func TestNextDue(t *testing.T) {
+	now := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		tables     []TableInfo
+		lastSynced map[string]time.Time
+		want       time.Duration
+	}{
+		{name: "no tables", want: 600 * time.Second},
+		{name: "not synced yet", tables: []TableInfo{{TableName: "orders"}}, want: 0},
+		{name: "soonest table",
+			tables: []TableInfo{{TableName: "orders", SyncFreq: 10}, {TableName: "users"}},
+			lastSynced: map[string]time.Time{
+				"orders": now.Add(-4 * time.Second),
+				"users":  now.Add(-time.Minute),
+			},
+			want: 6 * time.Second},
+		// New tables must be picked up within the global frequency
+		{name: "at most the global frequency",
+			tables:     []TableInfo{{TableName: "countries", SyncFreq: 3600}},
+			lastSynced: map[string]time.Time{"countries": now},
+			want:       600 * time.Second},
+		{name: "overdue", tables: []TableInfo{{TableName: "orders", SyncFreq: 10}},
+			lastSynced: map[string]time.Time{"orders": now.Add(-time.Minute)},
+			want:       0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextDue(tt.tables, tt.lastSynced, 600, now); got != tt.want {
+				t.Errorf("nextDue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// An hourly table applies the files it skipped while the hot table kept
+// up, and the global last file waits for it
+func TestPerTableProgress(t *testing.T) {
+	sm := NewStateManager(filepath.Join(t.TempDir(), "state.json"))
+	start := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	all := []string{"orders", "countries"}
+	hot := []TableInfo{{TableName: "orders", SyncFreq: 10}}
+	both := []TableInfo{{TableName: "orders", SyncFreq: 10}, {TableName: "countries", SyncFreq: 3600}}
+	fileTimes := func(tables []TableInfo) map[string]time.Time {
+		times := make(map[string]time.Time)
+		for _, table := range tables {
+			times[table.TableName] = sm.GetTableFileTime(table.TableName)
+		}
+		return times
+	}
+
+	// Both apply the first file, then only the hot table the next two
+	if err := sm.SetTableFile(all, "f1", start); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"f2", "f3"} {
+		mod := start.Add(time.Duration(i+1) * time.Minute)
+		if got := tablesForFile(hot, fileTimes(hot), mod); !reflect.DeepEqual(got, []string{"orders"}) {
+			t.Fatalf("%s tables = %v, want [orders]", name, got)
+		}
+		if err := sm.SetTableFile([]string{"orders"}, name, mod); err != nil {
+			t.Fatal(err)
+		}
+		if err := sm.AdvanceLastFile(all); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := sm.GetLastFile(); got != "f1" {
+		t.Errorf("last file = %s while countries lags, want f1", got)
+	}
+	if got := sm.GetTableFileTime("countries"); !got.Equal(start) {
+		t.Errorf("countries file time = %v, want %v", got, start)
+	}
+
+	// When both are due, the files the hot table applied go to the hourly
+	// one only; a new file goes to both
+	times := fileTimes(both)
+	for i, name := range []string{"f2", "f3"} {
+		mod := start.Add(time.Duration(i+1) * time.Minute)
+		if got := tablesForFile(both, times, mod); !reflect.DeepEqual(got, []string{"countries"}) {
+			t.Errorf("%s tables = %v, want [countries]", name, got)
+		}
+	}
+	if got := tablesForFile(both, times, start.Add(3*time.Minute)); !reflect.DeepEqual(got, all) {
+		t.Errorf("f4 tables = %v, want %v", got, all)
+	}
+	if got := tablesForFile(both, times, start); len(got) != 0 {
+		t.Errorf("f1 tables = %v, want none", got)
+	}
+
+	if err := sm.SetTableFile([]string{"countries"}, "f3", start.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.AdvanceLastFile(all); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.GetLastFile(); got != "f3" {
+		t.Errorf("last file = %s after countries caught up, want f3", got)
+	}
+}
@@ -0,0 +1,470 @@
+package tablesyncher
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Location codes for snapshot operations
+const (
+	LOC_SNAP_FETCH = "SHD_SYN_120"
+	LOC_SNAP_LOAD  = "SHD_SYN_121"
+	LOC_SNAP_DUMP  = "SHD_SYN_122"
+	LOC_SNAP_STATE = "SHD_SYN_123"
+)
+
+// A table added to the whitelist starts with a full copy of its rows,
+// the snapshot, before its changes are applied. The snapshot comes from
+// <archive_dir>/snapshots/<table>.json, a change file whose first record
+// is a SNAPSHOT record with the LSN the copy is consistent with, followed
+// by an INSERT per row. Without it, the table is copied from
+// snapshot_source_dsn with pg_dump. Changes at or before the snapshot LSN
+// are then skipped: the snapshot already has them. With neither, the
+// table only applies the changes from now on (tail only), with a warning.
+
+// SnapshotStatus is the progress of the initial snapshot of a table.
+type SnapshotStatus string
+
+const (
+	SnapshotPending SnapshotStatus = "pending"
+	SnapshotLoading SnapshotStatus = "loading"
+	SnapshotDone    SnapshotStatus = "done"
+)
+
+// Where a snapshot was loaded from
+const (
+	SnapshotSourceArchive = "archive"
+	SnapshotSourcePgDump  = "pg_dump"
+	SnapshotSourceNone    = "none" // Skipped: the table is tail only
+)
+
+// SnapshotInfo is a row of data_sync_snapshots.
+type SnapshotInfo struct {
+	TableName  string         `json:"table_name"`
+	Status     SnapshotStatus `json:"status"`
+	Source     string         `json:"source,omitempty"`
+	LSN        string         `json:"lsn,omitempty"`
+	RowsLoaded int64          `json:"rows_loaded"`
+	TotalRows  int64          `json:"total_rows"` // 0 if unknown (pg_dump)
+	Error      string         `json:"error,omitempty"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// Progress formats the snapshot progress for `syncdata status`.
+func (si *SnapshotInfo) Progress() string {
+	switch {
+	case si.Status == SnapshotPending:
+		return "snapshot pending"
+	case si.Status == SnapshotLoading && si.Source == SnapshotSourceArchive:
+		return fmt.Sprintf("snapshot loading from archive: %d/%d rows", si.RowsLoaded, si.TotalRows)
+	case si.Status == SnapshotLoading:
+		return "snapshot loading via " + si.Source
+	case si.Status == SnapshotDone && si.Source == SnapshotSourceNone:
+		return "snapshot skipped, tail only"
+	}
+	return "snapshot " + string(si.Status)
+}
+
+// MarkSnapshotPending makes the sync load a snapshot of the table before
+// applying its changes.
+func MarkSnapshotPending(ctx context.Context, db *sql.DB, tableName string) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO data_sync_snapshots (table_name, status, updated_at) VALUES ($1, $2, now())
+		 ON CONFLICT (table_name) DO UPDATE SET
+		   status = EXCLUDED.status, source = NULL, snapshot_lsn = NULL,
+		   rows_loaded = 0, total_rows = 0, error_detail = NULL, updated_at = EXCLUDED.updated_at`,
+		tableName, SnapshotPending)
+	if err != nil {
+		return fmt.Errorf("failed to mark snapshot of %s pending: %w (%s)", tableName, err, LOC_SNAP_STATE)
+	}
+	return nil
+}
+
+// GetSnapshot returns the snapshot of a table, or nil for a table added
+// without one.
+func GetSnapshot(ctx context.Context, db *sql.DB, tableName string) (*SnapshotInfo, error) {
+	snaps, err := querySnapshots(ctx, db, `WHERE table_name = $1`, tableName)
+	if err != nil || len(snaps) == 0 {
+		return nil, err
+	}
+	return &snaps[0], nil
+}
+
+// ListSnapshots returns the snapshots of all tables.
+func ListSnapshots(ctx context.Context, db *sql.DB) ([]SnapshotInfo, error) {
+	return querySnapshots(ctx, db, `ORDER BY table_name`)
+}
+
+func querySnapshots(ctx context.Context, db *sql.DB, clause string, args ...any) ([]SnapshotInfo, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT table_name, status, source, snapshot_lsn, rows_loaded, total_rows, error_detail, updated_at
+		 FROM data_sync_snapshots `+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshots: %w (%s)", err, LOC_SNAP_STATE)
+	}
+	defer rows.Close()
+
+	var snaps []SnapshotInfo
+	for rows.Next() {
+		var si SnapshotInfo
+		var source, lsn, errorDetail sql.NullString
+		if err := rows.Scan(&si.TableName, &si.Status, &source, &lsn, &si.RowsLoaded, &si.TotalRows,
+			&errorDetail, &si.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot row: %w (%s)", err, LOC_SNAP_STATE)
+		}
+		si.Source = source.String
+		si.LSN = lsn.String
+		si.Error = errorDetail.String
+		snaps = append(snaps, si)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating snapshot rows: %w (%s)", err, LOC_SNAP_STATE)
+	}
+	return snaps, nil
+}
+
+// setSnapshotError records why loading the snapshot of a table failed;
+// the next cycle tries again.
+func setSnapshotError(ctx context.Context, db *sql.DB, tableName string, errorDetail string) error {
+	_, err := db.ExecContext(ctx,
+		`UPDATE data_sync_snapshots SET error_detail = $2, updated_at = now() WHERE table_name = $1`,
+		tableName, errorDetail)
+	if err != nil {
+		return fmt.Errorf("failed to record snapshot error: %w (%s)", err, LOC_SNAP_STATE)
+	}
+	return nil
+}
+
+// startSnapshot records that the snapshot of the table is loading, after
+// emptying the table in the same transaction if 'truncate' is set. A
+// pg_dump load empties the table itself, in its own transaction.
+func startSnapshot(ctx context.Context, db *sql.DB, tableName, source, lsn string, totalRows int, truncate bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w (%s)", err, LOC_SNAP_LOAD)
+	}
+	defer tx.Rollback()
+
+	if truncate {
+		if _, err := tx.ExecContext(ctx, `TRUNCATE TABLE `+quoteIdentifier(tableName)); err != nil {
+			return fmt.Errorf("failed to truncate table %s: %w (%s)", tableName, err, LOC_SNAP_LOAD)
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE data_sync_snapshots SET status = $2, source = $3, snapshot_lsn = $4,
+		   rows_loaded = 0, total_rows = $5, error_detail = NULL, updated_at = now()
+		 WHERE table_name = $1`,
+		tableName, SnapshotLoading, source, lsn, totalRows)
+	if err != nil {
+		return fmt.Errorf("failed to start snapshot: %w (%s)", err, LOC_SNAP_STATE)
+	}
+	return tx.Commit()
+}
+
+// skipSnapshot marks the snapshot of a table done without loading one,
+// for a table with no snapshot source. It applies the changes from the
+// last change file applied on, as a table added without a snapshot.
+func skipSnapshot(ctx context.Context, db *sql.DB, tableName, reason string) error {
+	_, err := db.ExecContext(ctx,
+		`UPDATE data_sync_snapshots SET status = $2, source = $3, snapshot_lsn = NULL,
+		   rows_loaded = 0, total_rows = 0, error_detail = $4, updated_at = now()
+		 WHERE table_name = $1`,
+		tableName, SnapshotDone, SnapshotSourceNone, reason)
+	if err != nil {
+		return fmt.Errorf("failed to skip snapshot of %s: %w (%s)", tableName, err, LOC_SNAP_STATE)
+	}
+	return nil
+}
+
+// FetchSnapshotFile downloads the snapshot file of a table from the
+// archive. It returns false if there is none.
+func (c *SFTPClient) FetchSnapshotFile(ctx context.Context, tableName string) ([]ChangeRecord, bool, error) {
+	if c.sftpClient == nil {
+		return nil, false, fmt.Errorf("SFTP client not connected (%s)", LOC_SNAP_FETCH)
+	}
+
+	path := filepath.Join(c.config.ArchiveDir, "snapshots", tableName+".json")
+	if _, err := c.sftpClient.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to stat snapshot file %s: %w (%s)", path, err, LOC_SNAP_FETCH)
+	}
+
+	records, err := c.FetchChangeFile(ctx, ChangeFile{Name: filepath.Base(path), Path: path})
+	if err != nil {
+		return nil, false, err
+	}
+	return records, true, nil
+}
+
+// bootstrapTables loads the snapshot of the due tables that still need
+// one and returns the tables whose changes can be applied. A table whose
+// snapshot fails to load is left out of the cycle; the next cycle tries
+// again.
+func (s *SyncDataService) bootstrapTables(ctx context.Context, due []TableInfo, result *SyncResult) ([]TableInfo, error) {
+	ready := make([]TableInfo, 0, len(due))
+	var errs []error
+	for _, t := range due {
+		snap, err := GetSnapshot(ctx, s.db, t.TableName)
+		if err == nil && snap != nil && snap.Status != SnapshotDone {
+			err = s.loadSnapshot(ctx, t.TableName, snap)
+			if err != nil {
+				setSnapshotError(ctx, s.db, t.TableName, err.Error())
+				LogSyncEvent(ctx, s.db, t.TableName, "FAILED", 0, "snapshot", err.Error())
+			}
+		}
+		if err != nil {
+			s.logger.Error("Failed to load table snapshot",
+				"table", t.TableName,
+				"error", err,
+				"loc", LOC_SNAP_LOAD)
+			s.stats.ErrorCount++
+			result.Errors++
+			errs = append(errs, fmt.Errorf("snapshot of %s: %w", t.TableName, err))
+			continue
+		}
+		ready = append(ready, t)
+	}
+	return ready, errors.Join(errs...)
+}
+
+// loadSnapshot loads the snapshot of a table from the archive, or else
+// with pg_dump from snapshot_source_dsn.
+func (s *SyncDataService) loadSnapshot(ctx context.Context, tableName string, snap *SnapshotInfo) error {
+	records, found, err := s.sftpClient.FetchSnapshotFile(ctx, tableName)
+	if err != nil {
+		return err
+	}
+	return s.loadSnapshotFrom(ctx, tableName, snap, records, found)
+}
+
+// loadSnapshotFrom loads the snapshot of a table from the snapshot file
+// 'records' if 'found', or else with pg_dump. Without snapshot_source_dsn
+// either, the snapshot is skipped: the table would otherwise never sync.
+func (s *SyncDataService) loadSnapshotFrom(ctx context.Context, tableName string, snap *SnapshotInfo, records []ChangeRecord, found bool) error {
+	if found {
+		return s.loadArchiveSnapshot(ctx, tableName, snap, records)
+	}
+	if s.config.SnapshotSourceDSN != "" {
+		return s.loadDumpSnapshot(ctx, tableName)
+	}
+
+	reason := fmt.Sprintf("no snapshots/%s.json in the archive and snapshot_source_dsn is not set; "+
+		"the table only applies changes from now on", tableName)
+	s.logger.Warn("Skipping table snapshot",
+		"table", tableName,
+		"reason", reason,
+		"loc", LOC_SNAP_LOAD)
+	if err := skipSnapshot(ctx, s.db, tableName, reason); err != nil {
+		return err
+	}
+	LogSyncEvent(ctx, s.db, tableName, "SKIPPED", 0, "snapshot", reason)
+	return nil
+}
+
+// loadArchiveSnapshot loads the rows of a snapshot file in transactions
+// of max_tx_records rows, each recording the rows loaded so far. An
+// interrupted load of the same snapshot resumes after them.
+func (s *SyncDataService) loadArchiveSnapshot(ctx context.Context, tableName string, snap *SnapshotInfo, records []ChangeRecord) error {
+	if len(records) == 0 || records[0].Op != OpSnapshot {
+		return fmt.Errorf("snapshot file of %s does not start with a SNAPSHOT record (%s)", tableName, LOC_SNAP_LOAD)
+	}
+	lsn := records[0].LSN
+	if _, err := ParseLSN(lsn); err != nil {
+		return fmt.Errorf("snapshot file of %s: %w", tableName, err)
+	}
+
+	rows := make([]ChangeRecord, 0, len(records)-1)
+	for _, r := range records[1:] {
+		if r.Op != OpInsert || (r.Table != "" && r.Table != tableName) {
+			return fmt.Errorf("snapshot file of %s has a %s record of table %q; only INSERTs of the table are allowed (%s)",
+				tableName, r.Op, r.Table, LOC_SNAP_LOAD)
+		}
+		rows = append(rows, r)
+	}
+
+	loaded := 0
+	if snap.Status == SnapshotLoading && snap.Source == SnapshotSourceArchive && snap.LSN == lsn {
+		loaded = int(min(snap.RowsLoaded, int64(len(rows))))
+		s.logger.Info("Resuming table snapshot",
+			"table", tableName,
+			"lsn", lsn,
+			"loaded", loaded,
+			"total", len(rows),
+			"loc", LOC_SNAP_LOAD)
+	} else {
+		if err := startSnapshot(ctx, s.db, tableName, SnapshotSourceArchive, lsn, len(rows), true); err != nil {
+			return err
+		}
+		s.logger.Info("Loading table snapshot",
+			"table", tableName,
+			"lsn", lsn,
+			"total", len(rows),
+			"loc", LOC_SNAP_LOAD)
+	}
+
+	keyCols, err := tableKeyColumns(ctx, s.db, tableName)
+	if err != nil {
+		return err
+	}
+	for loaded < len(rows) {
+		end := min(loaded+s.config.MaxTxRecords, len(rows))
+		if err := s.loadSnapshotBatch(ctx, tableName, keyCols, rows[loaded:end], end); err != nil {
+			return err
+		}
+		loaded = end
+	}
+
+	return s.finishSnapshot(ctx, tableName, lsn, int64(len(rows)))
+}
+
+// loadSnapshotBatch inserts 'batch' and sets rows_loaded to 'loaded' in
+// one transaction.
+func (s *SyncDataService) loadSnapshotBatch(ctx context.Context, tableName string, keyCols []string, batch []ChangeRecord, loaded int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w (%s)", err, LOC_SNAP_LOAD)
+	}
+	defer tx.Rollback()
+
+	for _, r := range batch {
+		if err := applyInsert(ctx, tx, tableName, keyCols, r, s.logger); err != nil {
+			return fmt.Errorf("failed to load snapshot row %d: %w (%s)", loaded, err, LOC_SNAP_LOAD)
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE data_sync_snapshots SET rows_loaded = $2, updated_at = now() WHERE table_name = $1`,
+		tableName, loaded)
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot progress: %w (%s)", err, LOC_SNAP_STATE)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w (%s)", err, LOC_SNAP_LOAD)
+	}
+	return nil
+}
+
+// loadDumpSnapshot copies a table from snapshot_source_dsn with
+// pg_dump | psql. The LSN is read before the dump starts, so changes
+// made during the dump are applied again afterwards, which is harmless.
+// psql empties the table and loads the dump in one transaction: an
+// interrupted load leaves the table as it was and starts over.
+func (s *SyncDataService) loadDumpSnapshot(ctx context.Context, tableName string) error {
+	out, err := exec.CommandContext(ctx, "psql", "-X", "-A", "-t",
+		"-c", "SELECT pg_current_wal_lsn()", s.config.SnapshotSourceDSN).Output()
+	if err != nil {
+		return fmt.Errorf("failed to read the source LSN: %w (%s)", commandError(err), LOC_SNAP_DUMP)
+	}
+	lsn := strings.TrimSpace(string(out))
+	if _, err := ParseLSN(lsn); err != nil {
+		return err
+	}
+
+	if err := startSnapshot(ctx, s.db, tableName, SnapshotSourcePgDump, lsn, 0, false); err != nil {
+		return err
+	}
+	s.logger.Info("Loading table snapshot with pg_dump",
+		"table", tableName,
+		"lsn", lsn,
+		"loc", LOC_SNAP_DUMP)
+
+	dump := exec.CommandContext(ctx, "pg_dump", "--data-only", "--no-owner", "--no-privileges",
+		"-t", quoteIdentifier(tableName), s.config.SnapshotSourceDSN)
+	load := exec.CommandContext(ctx, "psql", "-X", "-q", "-1", "-v", "ON_ERROR_STOP=1",
+		"-h", s.config.PGHost,
+		"-p", strconv.Itoa(s.config.PGPort),
+		"-U", s.config.PGUser,
+		"-d", s.config.PGDatabase)
+	load.Env = append(os.Environ(), "PGPASSWORD="+s.config.PGPassword)
+
+	var dumpStderr, loadStderr bytes.Buffer
+	dump.Stderr = &dumpStderr
+	load.Stderr = &loadStderr
+	dumpOut, err := dump.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to pipe pg_dump: %w (%s)", err, LOC_SNAP_DUMP)
+	}
+	load.Stdin = io.MultiReader(strings.NewReader(truncateStmt(tableName)), dumpOut)
+	if err := load.Start(); err != nil {
+		return fmt.Errorf("failed to start psql: %w (%s)", err, LOC_SNAP_DUMP)
+	}
+	dumpErr := dump.Run()
+	loadErr := load.Wait()
+	if dumpErr != nil {
+		return fmt.Errorf("pg_dump failed: %v: %s (%s)", dumpErr, strings.TrimSpace(dumpStderr.String()), LOC_SNAP_DUMP)
+	}
+	if loadErr != nil {
+		return fmt.Errorf("psql failed: %v: %s (%s)", loadErr, strings.TrimSpace(loadStderr.String()), LOC_SNAP_DUMP)
+	}
+
+	var rows int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+quoteIdentifier(tableName)).Scan(&rows); err != nil {
+		return fmt.Errorf("failed to count snapshot rows: %w (%s)", err, LOC_SNAP_DUMP)
+	}
+	return s.finishSnapshot(ctx, tableName, lsn, rows)
+}
+
+// truncateStmt is the statement psql runs before the dump of a table, in
+// the transaction of the load
+func truncateStmt(tableName string) string {
+	return "TRUNCATE TABLE " + quoteIdentifier(tableName) + ";\n"
+}
+
+// finishSnapshot makes the table apply the changes after the snapshot
+// LSN, from the first change file on. The state file is updated first:
+// if the daemon dies before the snapshot is marked done, the next cycle
+// finds all rows loaded and only marks it.
+func (s *SyncDataService) finishSnapshot(ctx context.Context, tableName, lsn string, rows int64) error {
+	if err := s.state.SetTableSnapshot(tableName, lsn); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w (%s)", err, LOC_SNAP_STATE)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE data_sync_snapshots SET status = $2, rows_loaded = $3, error_detail = NULL, updated_at = now()
+		 WHERE table_name = $1`,
+		tableName, SnapshotDone, rows)
+	if err != nil {
+		return fmt.Errorf("failed to finish snapshot: %w (%s)", err, LOC_SNAP_STATE)
+	}
+	// Checkpoints in change files applied before the snapshot are stale
+	if _, err := tx.ExecContext(ctx, `DELETE FROM data_sync_checkpoints WHERE table_name = $1`, tableName); err != nil {
+		return fmt.Errorf("failed to clear checkpoint of %s: %w (%s)", tableName, err, LOC_SNAP_STATE)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w (%s)", err, LOC_SNAP_STATE)
+	}
+
+	LogSyncEvent(ctx, s.db, tableName, "SUCCESS", int(rows), "snapshot:"+lsn, "")
+	s.logger.Info("Table snapshot loaded",
+		"table", tableName,
+		"lsn", lsn,
+		"rows", rows,
+		"loc", LOC_SNAP_LOAD)
+	return nil
+}
+
+// commandError adds the stderr of a failed command to its error
+func commandError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
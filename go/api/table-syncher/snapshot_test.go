@@ -0,0 +1,156 @@
+package tablesyncher
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newTestService returns a service on a sqlmock database, with its state
+// file in a temporary directory
+func newTestService(t *testing.T, config *SyncConfig) (*SyncDataService, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	config.StateFilePath = filepath.Join(t.TempDir(), "state.json")
+	s := NewServiceWithDB(config, db, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return s, mock
+}
+
+// snapshotFile is the snapshot file of 'orders' at 0/10 with 'n' rows
+func snapshotFile(n int) []ChangeRecord {
+	records := []ChangeRecord{{Table: "orders", Op: OpSnapshot, LSN: "0/10"}}
+	for i := 1; i <= n; i++ {
+		records = append(records, ChangeRecord{Table: "orders", Op: OpInsert, Data: map[string]any{"id": i}})
+	}
+	return records
+}
+
+// expectSnapshotBatch expects a batch of 'n' rows that brings the rows
+// loaded to 'loaded'
+func expectSnapshotBatch(mock sqlmock.Sqlmock, n int, loaded int) {
+	mock.ExpectBegin()
+	for i := 0; i < n; i++ {
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "orders"`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE data_sync_snapshots SET rows_loaded = $2`)).
+		WithArgs("orders", loaded).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// expectKeyColumns expects the key columns of 'orders' to be read
+func expectKeyColumns(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT key_columns FROM tables_to_sync WHERE table_name = $1`)).
+		WithArgs("orders").
+		WillReturnRows(sqlmock.NewRows([]string{"key_columns"}).AddRow("id"))
+}
+
+// expectSnapshotDone expects the snapshot of 'orders' to be marked done
+// with 'rows' rows
+func expectSnapshotDone(mock sqlmock.Sqlmock, rows int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE data_sync_snapshots SET status = $2, rows_loaded = $3`)).
+		WithArgs("orders", SnapshotDone, rows).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM data_sync_checkpoints WHERE table_name = $1`)).
+		WithArgs("orders").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO data_sync_logs`)).
+		WithArgs("orders", "SUCCESS", int(rows), "snapshot:0/10", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestLoadArchiveSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	// A new snapshot empties the table and loads the rows in batches of
+	// max_tx_records
+	t.Run("bootstrap", func(t *testing.T) {
+		s, mock := newTestService(t, &SyncConfig{MaxTxRecords: 2})
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`TRUNCATE TABLE "orders"`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE data_sync_snapshots SET status = $2, source = $3, snapshot_lsn = $4`)).
+			WithArgs("orders", SnapshotLoading, SnapshotSourceArchive, "0/10", 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectKeyColumns(mock)
+		expectSnapshotBatch(mock, 2, 2)
+		expectSnapshotBatch(mock, 1, 3)
+		expectSnapshotDone(mock, 3)
+
+		snap := &SnapshotInfo{TableName: "orders", Status: SnapshotPending}
+		if err := s.loadSnapshotFrom(ctx, "orders", snap, snapshotFile(3), true); err != nil {
+			t.Fatalf("loadSnapshotFrom: %v", err)
+		}
+		if lsn := s.state.GetSnapshotLSN("orders"); lsn != "0/10" {
+			t.Errorf("snapshot LSN = %q, want 0/10", lsn)
+		}
+	})
+
+	// An interrupted load of the same snapshot goes on after the rows it
+	// loaded, without emptying the table
+	t.Run("resume", func(t *testing.T) {
+		s, mock := newTestService(t, &SyncConfig{MaxTxRecords: 2})
+		expectKeyColumns(mock)
+		expectSnapshotBatch(mock, 1, 3)
+		expectSnapshotDone(mock, 3)
+
+		snap := &SnapshotInfo{TableName: "orders", Status: SnapshotLoading, Source: SnapshotSourceArchive,
+			LSN: "0/10", RowsLoaded: 2, TotalRows: 3}
+		if err := s.loadSnapshotFrom(ctx, "orders", snap, snapshotFile(3), true); err != nil {
+			t.Fatalf("loadSnapshotFrom: %v", err)
+		}
+	})
+
+	// Without a snapshot file or snapshot_source_dsn, the table is tail only
+	t.Run("no source", func(t *testing.T) {
+		s, mock := newTestService(t, &SyncConfig{MaxTxRecords: 2})
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE data_sync_snapshots SET status = $2, source = $3, snapshot_lsn = NULL`)).
+			WithArgs("orders", SnapshotDone, SnapshotSourceNone, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO data_sync_logs`)).
+			WithArgs("orders", "SKIPPED", 0, "snapshot", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		snap := &SnapshotInfo{TableName: "orders", Status: SnapshotPending}
+		if err := s.loadSnapshotFrom(ctx, "orders", snap, nil, false); err != nil {
+			t.Fatalf("loadSnapshotFrom: %v", err)
+		}
+		if lsn := s.state.GetSnapshotLSN("orders"); lsn != "" {
+			t.Errorf("snapshot LSN = %q, want none", lsn)
+		}
+		done := SnapshotInfo{Status: SnapshotDone, Source: SnapshotSourceNone}
+		if got := done.Progress(); got != "snapshot skipped, tail only" {
+			t.Errorf("progress = %q", got)
+		}
+	})
+
+	t.Run("bad snapshot file", func(t *testing.T) {
+		s, _ := newTestService(t, &SyncConfig{MaxTxRecords: 2})
+		records := snapshotFile(1)[1:]
+		if err := s.loadSnapshotFrom(ctx, "orders", &SnapshotInfo{}, records, true); err == nil {
+			t.Error("loaded a snapshot file without a SNAPSHOT record")
+		}
+	})
+}
+
+func TestTruncateStmt(t *testing.T) {
+	if got, want := truncateStmt(`my"table`), "TRUNCATE TABLE \"my\"\"table\";\n"; got != want {
+		t.Errorf("truncateStmt = %q, want %q", got, want)
+	}
+}
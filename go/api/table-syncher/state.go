@@ -21,6 +21,15 @@ type TableState struct {
 	LastLSN      string    `json:"last_lsn"`      // Last processed LSN
 	LastSyncedAt time.Time `json:"last_synced_at"`
 	RecordCount  int64     `json:"record_count"` // Total records synced for this table
+
+	// The last change file applied to this table. Tables with their own
+	// sync frequency advance through the change files independently.
+	LastFile     string    `json:"last_file,omitempty"`
+	LastFileTime time.Time `json:"last_file_time,omitempty"`
+
+	// LSN of the snapshot the table was loaded from. Its changes at or
+	// before it are skipped.
+	SnapshotLSN string `json:"snapshot_lsn,omitempty"`
 }
 
 // StateData is the root structure of the state file.
@@ -32,6 +41,8 @@ type StateData struct {
 	Tables         map[string]*TableState `json:"tables"`
 	TotalSynced    int64                  `json:"total_synced"`    // Total records synced since start
 	LastSyncCycle  time.Time              `json:"last_sync_cycle"` // Time of last sync cycle
+	Connection     *ConnectionStatus      `json:"connection,omitempty"` // Archive connection of the daemon
+	Runtime        *DaemonRuntime         `json:"runtime,omitempty"`    // Runtime state of the daemon
 }
 
 // StateManager handles reading and writing the state file.
@@ -150,6 +161,106 @@ func (sm *StateManager) SetLastFile(filename string, modTime time.Time) error {
 	return sm.saveLocked()
 }
 
+// GetTableFileTime returns the modification time of the last change file
+// applied to the table. A table without its own progress (e.g. state from
+// before per-table frequencies) is as far as the global last file.
+func (sm *StateManager) GetTableFileTime(tableName string) time.Time {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if ts, ok := sm.data.Tables[tableName]; ok {
+		if !ts.LastFileTime.IsZero() {
+			return ts.LastFileTime
+		}
+		// Just loaded from a snapshot: the changes after it may be in
+		// any change file
+		if ts.SnapshotLSN != "" {
+			return time.Time{}
+		}
+	}
+	return sm.data.LastFileTime
+}
+
+// SetTableSnapshot records that the table was loaded from a snapshot at
+// 'lsn' and saves the state. The table applies the change files from the
+// first on, skipping the changes the snapshot has.
+func (sm *StateManager) SetTableSnapshot(tableName, lsn string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	ts := sm.data.Tables[tableName]
+	if ts == nil {
+		ts = &TableState{}
+		sm.data.Tables[tableName] = ts
+	}
+	ts.SnapshotLSN = lsn
+	ts.LastLSN = lsn
+	ts.LastFile = ""
+	ts.LastFileTime = time.Time{}
+	ts.LastSyncedAt = time.Now()
+
+	return sm.saveLocked()
+}
+
+// GetSnapshotLSN returns the LSN of the snapshot the table was loaded
+// from, or "".
+func (sm *StateManager) GetSnapshotLSN(tableName string) string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if ts, ok := sm.data.Tables[tableName]; ok {
+		return ts.SnapshotLSN
+	}
+	return ""
+}
+
+// SetTableFile records that a change file was applied to the given tables
+// and saves the state.
+func (sm *StateManager) SetTableFile(tableNames []string, filename string, modTime time.Time) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	now := time.Now()
+	for _, tableName := range tableNames {
+		if sm.data.Tables[tableName] == nil {
+			sm.data.Tables[tableName] = &TableState{}
+		}
+		sm.data.Tables[tableName].LastFile = filename
+		sm.data.Tables[tableName].LastFileTime = modTime
+		sm.data.Tables[tableName].LastSyncedAt = now
+	}
+	sm.data.LastSyncCycle = now
+
+	return sm.saveLocked()
+}
+
+// AdvanceLastFile moves the global last file up to the least advanced of
+// the given tables, so that it names the newest change file applied to
+// all of them. It never moves the global last file back.
+func (sm *StateManager) AdvanceLastFile(tableNames []string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	var oldest *TableState
+	for _, tableName := range tableNames {
+		ts, ok := sm.data.Tables[tableName]
+		if !ok || ts.LastFileTime.IsZero() {
+			// Not applied anything since the global last file
+			return nil
+		}
+		if oldest == nil || ts.LastFileTime.Before(oldest.LastFileTime) {
+			oldest = ts
+		}
+	}
+	if oldest == nil || !oldest.LastFileTime.After(sm.data.LastFileTime) {
+		return nil
+	}
+
+	sm.data.LastFile = oldest.LastFile
+	sm.data.LastFileTime = oldest.LastFileTime
+	return sm.saveLocked()
+}
+
 // GetGlobalLSN returns the global checkpoint LSN.
 func (sm *StateManager) GetGlobalLSN() string {
 	sm.mu.Lock()
@@ -208,6 +319,57 @@ func (sm *StateManager) GetLastSyncCycle() time.Time {
 	return sm.data.LastSyncCycle
 }
 
+// GetConnection returns the archive connection status last recorded by the
+// daemon, or nil.
+func (sm *StateManager) GetConnection() *ConnectionStatus {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.data.Connection
+}
+
+// SetConnection records the archive connection status and saves the state,
+// so that `syncdata status` can report it.
+func (sm *StateManager) SetConnection(cs ConnectionStatus) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.data.Connection = &cs
+	return sm.saveLocked()
+}
+
+// GetRuntime returns a copy of the daemon runtime state last recorded by
+// the daemon, or nil.
+func (sm *StateManager) GetRuntime() *DaemonRuntime {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.data.Runtime == nil {
+		return nil
+	}
+	return sm.data.Runtime.clone()
+}
+
+// SetRuntime records the daemon runtime state and saves the state, so
+// that `syncdata status` can report it without a DB connection.
+func (sm *StateManager) SetRuntime(rt DaemonRuntime) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.data.Runtime = rt.clone()
+	return sm.saveLocked()
+}
+
+// clone returns a deep copy of the runtime state
+func (rt *DaemonRuntime) clone() *DaemonRuntime {
+	c := *rt
+	c.Tables = make(map[string]*TableRuntime, len(rt.Tables))
+	for name, t := range rt.Tables {
+		tr := *t
+		c.Tables[name] = &tr
+	}
+	return &c
+}
+
 // Reset clears all state (for full resync).
 func (sm *StateManager) Reset() error {
 	sm.mu.Lock()
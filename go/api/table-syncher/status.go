@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -18,31 +19,50 @@ const (
 	LOC_STATUS_PID   = "SHD_SYN_081"
 )
 
-// GetDaemonStatus returns the current daemon status.
+// GetDaemonStatus returns the current daemon status. The state file has
+// the runtime state the daemon records after every sync cycle, so the
+// status is complete without a DB connection ('db' nil); the DB adds the
+// FAILED sync log entries and the snapshots still loading.
 func GetDaemonStatus(ctx context.Context, config *SyncConfig, db *sql.DB) (*DaemonStatus, error) {
 	status := &DaemonStatus{
 		Status:        StatusNotStarted,
 		SyncFrequency: config.DataSyncFreq,
+		DBAvailable:   db != nil,
+	}
+
+	state := NewStateManager(config.StateFilePath)
+	stateLoaded := state.Load() == nil
+	var runtime *DaemonRuntime
+	if stateLoaded {
+		status.LastSyncTime = state.GetLastSyncCycle()
+		status.RecordsSynced = state.GetTotalSynced()
+		runtime = state.GetRuntime()
 	}
 
 	// Check if daemon is running via PID file
 	pid, running := checkDaemonRunning(config.PIDFilePath)
 	if running {
 		status.Status = StatusActive
-
-		// Try to get start time from state file
-		state := NewStateManager(config.StateFilePath)
-		if err := state.Load(); err == nil {
-			// Estimate start time from first sync cycle minus frequency
-			if !state.GetLastSyncCycle().IsZero() {
-				// This is an approximation
-				status.StartTime = state.GetLastSyncCycle()
-			}
-			status.RecordsSynced = state.GetTotalSynced()
+		if stateLoaded {
+			status.Connection = state.GetConnection()
+		}
+		if runtime != nil && runtime.PID == pid {
+			status.StartTime = runtime.StartTime
+		} else {
+			// State of an older daemon: approximate the start time with
+			// the last sync cycle
+			status.StartTime = status.LastSyncTime
 		}
 	}
 
-	// Get error count from database
+	if runtime != nil {
+		status.LastCycle = runtime.LastCycle
+		status.LastError = runtime.LastError
+		status.LastErrorTime = runtime.LastErrorTime
+		status.Errors = runtime.ErrorCount
+	}
+
+	// Get error count and tables from database
 	if db != nil {
 		// Get errors since start time (or last 24 hours if unknown)
 		since := status.StartTime
@@ -50,25 +70,52 @@ func GetDaemonStatus(ctx context.Context, config *SyncConfig, db *sql.DB) (*Daem
 			since = time.Now().Add(-24 * time.Hour)
 		}
 
+		// The daemon counts the cycles that failed before writing a sync
+		// log (e.g. with the DB down), the DB the change files that
+		// failed: report the larger
 		errorCount, err := GetErrorCount(ctx, db, since)
-		if err == nil {
+		if err == nil && errorCount > status.Errors {
 			status.Errors = errorCount
 		}
 
-		// Get tables
+		// Get tables, with the snapshots still loading
 		tables, err := ListTables(ctx, db)
 		if err == nil {
 			status.Tables = tables
+		} else {
+			status.DBAvailable = false
+		}
+		if snaps, err := ListSnapshots(ctx, db); err == nil {
+			for i := range snaps {
+				if snaps[i].Status == SnapshotDone {
+					continue
+				}
+				for j := range status.Tables {
+					if status.Tables[j].TableName == snaps[i].TableName {
+						status.Tables[j].Snapshot = &snaps[i]
+					}
+				}
+			}
 		}
 	}
 
-	// Get last sync time from state
-	state := NewStateManager(config.StateFilePath)
-	if err := state.Load(); err == nil {
-		status.LastSyncTime = state.GetLastSyncCycle()
+	// Without the DB, the tables are those of the daemon's last cycle
+	if runtime != nil {
+		if !status.DBAvailable {
+			names := make([]string, 0, len(runtime.Tables))
+			for name := range runtime.Tables {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				status.Tables = append(status.Tables, TableInfo{TableName: name, SyncFreq: runtime.Tables[name].SyncFreq})
+			}
+		}
+		for i := range status.Tables {
+			status.Tables[i].Runtime = runtime.Tables[status.Tables[i].TableName]
+		}
 	}
 
-	_ = pid // unused but available for future use
 	return status, nil
 }
 
@@ -99,25 +146,61 @@ func checkDaemonRunning(pidPath string) (int, bool) {
 func FormatStatus(status *DaemonStatus) string {
 	var sb strings.Builder
 
+	now := time.Now()
 	sb.WriteString(fmt.Sprintf("status: %s\n", status.Status))
+	if !status.DBAvailable {
+		sb.WriteString("database: unavailable (status from the state file)\n")
+	}
 	sb.WriteString(fmt.Sprintf("sync frequency: %d seconds\n", status.SyncFrequency))
 
-	if status.Status == StatusActive {
-		if !status.StartTime.IsZero() {
-			sb.WriteString(fmt.Sprintf("start time: %s\n", status.StartTime.Format(time.RFC3339)))
+	if status.Status == StatusActive && !status.StartTime.IsZero() {
+		sb.WriteString(fmt.Sprintf("start time: %s\n", status.StartTime.Format(time.RFC3339)))
+	}
+	if !status.LastCycle.IsZero() {
+		sb.WriteString(fmt.Sprintf("last cycle: %s\n", status.LastCycle.Format(time.RFC3339)))
+	}
+	if !status.LastSyncTime.IsZero() {
+		sb.WriteString(fmt.Sprintf("last sync: %s\n", status.LastSyncTime.Format(time.RFC3339)))
+	}
+
+	if status.Connection != nil {
+		cs := status.Connection
+		sb.WriteString(fmt.Sprintf("archive connection: %s since %s", cs.State, cs.Since.Format(time.RFC3339)))
+		if cs.Reconnects > 0 {
+			sb.WriteString(fmt.Sprintf(" (%d reconnects)", cs.Reconnects))
 		}
-		if !status.LastSyncTime.IsZero() {
-			sb.WriteString(fmt.Sprintf("last sync: %s\n", status.LastSyncTime.Format(time.RFC3339)))
+		sb.WriteString("\n")
+		if cs.State != ConnConnected && cs.LastError != "" {
+			sb.WriteString(fmt.Sprintf("last connection error: %s\n", cs.LastError))
 		}
 	}
 
 	sb.WriteString(fmt.Sprintf("records synced: %d\n", status.RecordsSynced))
 	sb.WriteString(fmt.Sprintf("errors: %d\n", status.Errors))
+	if status.LastError != "" {
+		sb.WriteString(fmt.Sprintf("last error: %s (%s)\n", status.LastError, status.LastErrorTime.Format(time.RFC3339)))
+	}
 
 	if len(status.Tables) > 0 {
 		sb.WriteString(fmt.Sprintf("\nsynced tables (%d):\n", len(status.Tables)))
 		for _, t := range status.Tables {
-			sb.WriteString(fmt.Sprintf("  - %s\n", t.TableName))
+			sb.WriteString("  - " + t.TableName)
+			if t.SyncFreq > 0 {
+				sb.WriteString(fmt.Sprintf(" (every %d seconds)", t.SyncFreq))
+			}
+			if t.Snapshot != nil {
+				sb.WriteString(" [" + t.Snapshot.Progress() + "]")
+			}
+			if t.Runtime != nil && !t.Runtime.LastSynced.IsZero() {
+				sb.WriteString(fmt.Sprintf(": last synced %s", t.Runtime.LastSynced.Format(time.RFC3339)))
+				if !t.Runtime.LatestChange.IsZero() {
+					sb.WriteString(fmt.Sprintf(", lag %s", t.Runtime.Lag(now).Round(time.Second)))
+				}
+			}
+			sb.WriteString("\n")
+			if t.Snapshot != nil && t.Snapshot.Error != "" {
+				sb.WriteString(fmt.Sprintf("    snapshot error: %s\n", t.Snapshot.Error))
+			}
 		}
 	}
 
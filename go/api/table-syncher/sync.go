@@ -5,6 +5,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -22,19 +23,25 @@ import (
 
 // Location codes for sync operations
 const (
-	LOC_SYNC_CONNECT  = "SHD_SYN_060"
-	LOC_SYNC_DISCOVER = "SHD_SYN_061"
-	LOC_SYNC_FETCH    = "SHD_SYN_062"
-	LOC_SYNC_PARSE    = "SHD_SYN_063"
-	LOC_SYNC_APPLY    = "SHD_SYN_064"
+	LOC_SYNC_CONNECT    = "SHD_SYN_060"
+	LOC_SYNC_DISCOVER   = "SHD_SYN_061"
+	LOC_SYNC_FETCH      = "SHD_SYN_062"
+	LOC_SYNC_PARSE      = "SHD_SYN_063"
+	LOC_SYNC_APPLY      = "SHD_SYN_064"
+	LOC_SYNC_CHECKPOINT = "SHD_SYN_067"
 )
 
 // SFTPClient wraps SSH/SFTP connections to the remote archive machine.
+// Use EnsureConnected to connect, so a dropped connection is re-established.
 type SFTPClient struct {
 	config     *SyncConfig
 	sshClient  *ssh.Client
 	sftpClient *sftp.Client
 	logger     *slog.Logger
+	status     ConnectionStatus
+
+	// OnStatusChange, if set, is called when the connection state changes
+	OnStatusChange func(ConnectionStatus)
 }
 
 // NewSFTPClient creates a new SFTP client for the archive machine.
@@ -94,6 +101,7 @@ func (c *SFTPClient) Connect(ctx context.Context) error {
 	c.sftpClient, err = sftp.NewClient(c.sshClient)
 	if err != nil {
 		c.sshClient.Close()
+		c.sshClient = nil
 		return fmt.Errorf("failed to create SFTP client: %w (%s)", err, LOC_SYNC_CONNECT)
 	}
 
@@ -105,9 +113,11 @@ func (c *SFTPClient) Connect(ctx context.Context) error {
 func (c *SFTPClient) Close() {
 	if c.sftpClient != nil {
 		c.sftpClient.Close()
+		c.sftpClient = nil
 	}
 	if c.sshClient != nil {
 		c.sshClient.Close()
+		c.sshClient = nil
 	}
 }
 
@@ -209,30 +219,70 @@ func ParseChangeFile(ctx context.Context, r io.Reader, logger *slog.Logger) ([]C
 	return records, nil
 }
 
-// ApplyChanges applies change records to the local database.
-func ApplyChanges(ctx context.Context, db *sql.DB, records []ChangeRecord, whitelist map[string]bool, logger *slog.Logger) (*SyncResult, error) {
-	result := &SyncResult{}
+// ApplyOptions controls how ApplyChanges groups changes into transactions
+type ApplyOptions struct {
+	// ChangeFile, if set, checkpoints each table's progress in the file in
+	// data_sync_checkpoints, committed with the changes, so a file applied
+	// again skips what was already committed
+	ChangeFile string
+
+	// MaxTxRecords bounds the records of a table per transaction; 0 applies
+	// each table's changes in one transaction
+	MaxTxRecords int
+
+	// AfterLSN maps tables loaded from a snapshot to its LSN; their
+	// records at or before it are skipped
+	AfterLSN map[string]uint64
+}
+
+// ApplyChanges applies change records to the local database, each table's
+// changes in its own transactions. A table that fails is rolled back to
+// its last committed transaction and the other tables are still applied;
+// the returned error joins the failures.
+func ApplyChanges(ctx context.Context, db *sql.DB, records []ChangeRecord, whitelist map[string]bool, opts ApplyOptions, logger *slog.Logger) (*SyncResult, error) {
+	result := &SyncResult{LatestChange: make(map[string]time.Time)}
 	start := time.Now()
 
 	// Group records by table for batch processing
 	byTable := make(map[string][]ChangeRecord)
 	for _, r := range records {
+		// Checksums are only read by 'syncdata verify'
+		if r.Op == OpChecksum {
+			continue
+		}
+
 		// Filter by whitelist
 		if !whitelist[r.Table] {
 			result.RecordsSkipped++
 			continue
 		}
+
+		// Already in the table's snapshot
+		if after, ok := opts.AfterLSN[r.Table]; ok {
+			if lsn, err := ParseLSN(r.LSN); err == nil && lsn <= after {
+				result.RecordsSkipped++
+				continue
+			}
+		}
 		byTable[r.Table] = append(byTable[r.Table], r)
 	}
 
-	// Process each table's changes in a transaction
+	// Process each table's changes in transactions
+	var errs []error
 	for tableName, tableRecords := range byTable {
-		if err := applyTableChanges(ctx, db, tableName, tableRecords, result, logger); err != nil {
+		if err := applyTableChanges(ctx, db, tableName, tableRecords, opts, result, logger); err != nil {
 			// Log error but continue with other tables
 			logger.Error("Failed to apply changes to table",
 				"table", tableName,
 				"error", err,
 				"loc", LOC_SYNC_APPLY)
+			errs = append(errs, fmt.Errorf("table %s: %w", tableName, err))
+			continue
+		}
+		for _, r := range tableRecords {
+			if r.TS.After(result.LatestChange[tableName]) {
+				result.LatestChange[tableName] = r.TS
+			}
 		}
 	}
 
@@ -241,48 +291,96 @@ func ApplyChanges(ctx context.Context, db *sql.DB, records []ChangeRecord, white
 		result.LastLSN = records[len(records)-1].LSN
 	}
 
-	return result, nil
+	return result, errors.Join(errs...)
 }
 
-// applyTableChanges applies changes for a single table in a transaction.
-func applyTableChanges(ctx context.Context, db *sql.DB, tableName string, records []ChangeRecord, result *SyncResult, logger *slog.Logger) error {
+// applyTableChanges applies changes for a single table in transactions of
+// at most opts.MaxTxRecords records. With opts.ChangeFile, the records
+// already committed from that file are skipped.
+func applyTableChanges(ctx context.Context, db *sql.DB, tableName string, records []ChangeRecord, opts ApplyOptions, result *SyncResult, logger *slog.Logger) error {
+	applied := 0
+	if opts.ChangeFile != "" {
+		var err error
+		if applied, err = loadCheckpoint(ctx, db, tableName, opts.ChangeFile); err != nil {
+			return err
+		}
+		if applied > 0 {
+			logger.Info("Resuming table from checkpoint",
+				"table", tableName,
+				"file", opts.ChangeFile,
+				"applied", applied,
+				"loc", LOC_SYNC_CHECKPOINT)
+		}
+	}
+
+	keyCols, err := tableKeyColumns(ctx, db, tableName)
+	if err != nil {
+		result.RecordsFailed += int64(len(records) - applied)
+		return err
+	}
+
+	batchSize := opts.MaxTxRecords
+	if batchSize <= 0 {
+		batchSize = len(records)
+	}
+	for applied < len(records) {
+		end := min(applied+batchSize, len(records))
+		if err := applyTableBatch(ctx, db, tableName, keyCols, records[applied:end], end, opts, result, logger); err != nil {
+			result.RecordsFailed += int64(len(records) - applied)
+			return err
+		}
+		applied = end
+	}
+
+	return nil
+}
+
+// applyTableBatch applies 'batch' in one transaction. With opts.ChangeFile
+// the checkpoint is set to 'applied' records in the same transaction, so
+// the changes and the progress are committed, or rolled back, together.
+func applyTableBatch(ctx context.Context, db *sql.DB, tableName string, keyCols []string, batch []ChangeRecord, applied int, opts ApplyOptions, result *SyncResult, logger *slog.Logger) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	for _, r := range records {
+	// Counted into result only once committed
+	var added, updated, deleted, failed int64
+	for _, r := range batch {
 		var applyErr error
 		switch r.Op {
 		case OpInsert:
-			applyErr = applyInsert(ctx, tx, tableName, r, logger)
-			if applyErr == nil {
-				result.RecordsAdded++
-			}
+			applyErr = applyInsert(ctx, tx, tableName, keyCols, r, logger)
+			added++
 		case OpUpdate:
-			applyErr = applyUpdate(ctx, tx, tableName, r, logger)
-			if applyErr == nil {
-				result.RecordsUpdated++
-			}
+			applyErr = applyUpdate(ctx, tx, tableName, keyCols, r, logger)
+			updated++
 		case OpDelete:
-			applyErr = applyDelete(ctx, tx, tableName, r, logger)
-			if applyErr == nil {
-				result.RecordsDeleted++
-			}
+			applyErr = applyDelete(ctx, tx, tableName, keyCols, r, logger)
+			deleted++
 		default:
 			logger.Warn("Unknown operation", "op", r.Op, "table", tableName)
-			result.RecordsFailed++
+			failed++
 			continue
 		}
 
+		// A failed statement aborts the transaction, so the batch is
+		// rolled back and replayed by the next cycle
 		if applyErr != nil {
 			logger.Warn("Failed to apply change",
 				"table", tableName,
 				"op", r.Op,
+				"lsn", r.LSN,
 				"error", applyErr,
 				"loc", LOC_SYNC_APPLY)
-			result.RecordsFailed++
+			return fmt.Errorf("%s at LSN %s failed: %w", r.Op, r.LSN, applyErr)
+		}
+	}
+
+	if opts.ChangeFile != "" {
+		if err := saveCheckpoint(ctx, tx, tableName, opts.ChangeFile, applied, batch[len(batch)-1].LSN); err != nil {
+			return err
 		}
 	}
 
@@ -290,49 +388,100 @@ func applyTableChanges(ctx context.Context, db *sql.DB, tableName string, record
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	result.RecordsAdded += added
+	result.RecordsUpdated += updated
+	result.RecordsDeleted += deleted
+	result.RecordsFailed += failed
+	result.addApplied(tableName, AppliedCounts{Inserts: added, Updates: updated, Deletes: deleted})
+	return nil
+}
+
+// loadCheckpoint returns how many of the table's records in 'changeFile'
+// are already committed; 0 if its checkpoint is for another file.
+func loadCheckpoint(ctx context.Context, db *sql.DB, tableName, changeFile string) (int, error) {
+	var applied int
+	err := db.QueryRowContext(ctx,
+		`SELECT applied FROM data_sync_checkpoints WHERE table_name = $1 AND change_file = $2`,
+		tableName, changeFile).Scan(&applied)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read checkpoint: %w (%s)", err, LOC_SYNC_CHECKPOINT)
+	}
+	return applied, nil
+}
+
+// saveCheckpoint records in 'tx' that the first 'applied' records of the
+// table in 'changeFile' are committed.
+func saveCheckpoint(ctx context.Context, tx *sql.Tx, tableName, changeFile string, applied int, lsn string) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO data_sync_checkpoints (table_name, change_file, applied, last_lsn, updated_at)
+		 VALUES ($1, $2, $3, $4, now())
+		 ON CONFLICT (table_name) DO UPDATE SET
+		   change_file = EXCLUDED.change_file, applied = EXCLUDED.applied,
+		   last_lsn = EXCLUDED.last_lsn, updated_at = EXCLUDED.updated_at`,
+		tableName, changeFile, applied, lsn)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w (%s)", err, LOC_SYNC_CHECKPOINT)
+	}
 	return nil
 }
 
-// applyInsert applies an INSERT operation (with UPSERT semantics).
-func applyInsert(ctx context.Context, tx *sql.Tx, tableName string, r ChangeRecord, _ *slog.Logger) error {
+// applyInsert applies an INSERT operation (with UPSERT semantics on the
+// table's key columns).
+func applyInsert(ctx context.Context, tx *sql.Tx, tableName string, keyCols []string, r ChangeRecord, _ *slog.Logger) error {
 	if len(r.Data) == 0 {
 		return fmt.Errorf("INSERT record has no data")
 	}
 
+	isKey := make(map[string]bool, len(keyCols))
+	quotedKeys := make([]string, 0, len(keyCols))
+	for _, col := range keyCols {
+		if _, ok := r.Data[col]; !ok {
+			return fmt.Errorf("INSERT record has no key column %s", col)
+		}
+		isKey[col] = true
+		quotedKeys = append(quotedKeys, quoteIdentifier(col))
+	}
+
 	// Build INSERT ... ON CONFLICT DO UPDATE statement
 	columns := make([]string, 0, len(r.Data))
 	placeholders := make([]string, 0, len(r.Data))
 	values := make([]any, 0, len(r.Data))
 	updateClauses := make([]string, 0, len(r.Data))
 
-	i := 1
-	for col, val := range r.Data {
+	for i, col := range sortedColumns(r.Data) {
 		columns = append(columns, quoteIdentifier(col))
-		placeholders = append(placeholders, fmt.Sprintf("$%d", i))
-		values = append(values, val)
-		updateClauses = append(updateClauses, fmt.Sprintf("%s = EXCLUDED.%s", quoteIdentifier(col), quoteIdentifier(col)))
-		i++
+		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+		values = append(values, r.Data[col])
+		if !isKey[col] {
+			updateClauses = append(updateClauses, fmt.Sprintf("%s = EXCLUDED.%s", quoteIdentifier(col), quoteIdentifier(col)))
+		}
 	}
 
-	// Assume first column is the primary key for ON CONFLICT
-	// This is a simplification - production code should introspect the schema
-	pkCol := columns[0]
+	// A row of only key columns has nothing to update
+	onConflict := "DO NOTHING"
+	if len(updateClauses) > 0 {
+		onConflict = "DO UPDATE SET " + strings.Join(updateClauses, ", ")
+	}
 
 	query := fmt.Sprintf(
-		`INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s`,
+		`INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s`,
 		quoteIdentifier(tableName),
 		strings.Join(columns, ", "),
 		strings.Join(placeholders, ", "),
-		pkCol,
-		strings.Join(updateClauses, ", "),
+		strings.Join(quotedKeys, ", "),
+		onConflict,
 	)
 
 	_, err := tx.ExecContext(ctx, query, values...)
 	return err
 }
 
-// applyUpdate applies an UPDATE operation.
-func applyUpdate(ctx context.Context, tx *sql.Tx, tableName string, r ChangeRecord, logger *slog.Logger) error {
+// applyUpdate applies an UPDATE operation to the row matching the key
+// columns in r.OldKeys.
+func applyUpdate(ctx context.Context, tx *sql.Tx, tableName string, keyCols []string, r ChangeRecord, logger *slog.Logger) error {
 	if len(r.Data) == 0 {
 		return fmt.Errorf("UPDATE record has no data")
 	}
@@ -342,28 +491,25 @@ func applyUpdate(ctx context.Context, tx *sql.Tx, tableName string, r ChangeReco
 
 	// Build SET clause
 	setClauses := make([]string, 0, len(r.Data))
-	values := make([]any, 0, len(r.Data)+len(r.OldKeys))
+	values := make([]any, 0, len(r.Data)+len(keyCols))
 
-	i := 1
-	for col, val := range r.Data {
-		setClauses = append(setClauses, fmt.Sprintf("%s = $%d", quoteIdentifier(col), i))
-		values = append(values, val)
-		i++
+	for i, col := range sortedColumns(r.Data) {
+		setClauses = append(setClauses, fmt.Sprintf("%s = $%d", quoteIdentifier(col), i+1))
+		values = append(values, r.Data[col])
 	}
 
 	// Build WHERE clause
-	whereClauses := make([]string, 0, len(r.OldKeys))
-	for col, val := range r.OldKeys {
-		whereClauses = append(whereClauses, fmt.Sprintf("%s = $%d", quoteIdentifier(col), i))
-		values = append(values, val)
-		i++
+	where, keyValues, err := keyWhere(keyCols, r.OldKeys, len(values)+1)
+	if err != nil {
+		return err
 	}
+	values = append(values, keyValues...)
 
 	query := fmt.Sprintf(
 		`UPDATE %s SET %s WHERE %s`,
 		quoteIdentifier(tableName),
 		strings.Join(setClauses, ", "),
-		strings.Join(whereClauses, " AND "),
+		where,
 	)
 
 	result, err := tx.ExecContext(ctx, query, values...)
@@ -382,27 +528,23 @@ func applyUpdate(ctx context.Context, tx *sql.Tx, tableName string, r ChangeReco
 	return nil
 }
 
-// applyDelete applies a DELETE operation.
-func applyDelete(ctx context.Context, tx *sql.Tx, tableName string, r ChangeRecord, logger *slog.Logger) error {
+// applyDelete applies a DELETE operation to the row matching the key
+// columns in r.OldKeys.
+func applyDelete(ctx context.Context, tx *sql.Tx, tableName string, keyCols []string, r ChangeRecord, logger *slog.Logger) error {
 	if len(r.OldKeys) == 0 {
 		return fmt.Errorf("DELETE record has no old_keys")
 	}
 
 	// Build WHERE clause
-	whereClauses := make([]string, 0, len(r.OldKeys))
-	values := make([]any, 0, len(r.OldKeys))
-
-	i := 1
-	for col, val := range r.OldKeys {
-		whereClauses = append(whereClauses, fmt.Sprintf("%s = $%d", quoteIdentifier(col), i))
-		values = append(values, val)
-		i++
+	where, values, err := keyWhere(keyCols, r.OldKeys, 1)
+	if err != nil {
+		return err
 	}
 
 	query := fmt.Sprintf(
 		`DELETE FROM %s WHERE %s`,
 		quoteIdentifier(tableName),
-		strings.Join(whereClauses, " AND "),
+		where,
 	)
 
 	result, err := tx.ExecContext(ctx, query, values...)
@@ -16,6 +16,7 @@ const (
 	LOC_TBL_REMOVE = "SHD_SYN_052"
 	LOC_TBL_LIST   = "SHD_SYN_053"
 	LOC_TBL_CLEAR  = "SHD_SYN_054"
+	LOC_TBL_FREQ   = "SHD_SYN_055"
 )
 
 // SQL statements for creating the sync tables
@@ -54,9 +55,36 @@ CREATE TABLE IF NOT EXISTS tables_to_sync (
     id SERIAL PRIMARY KEY,
     table_name TEXT NOT NULL,
     creator TEXT DEFAULT NULL,
+    sync_freq INT DEFAULT NULL,
+    key_columns TEXT DEFAULT NULL,
     created_at TIMESTAMPTZ DEFAULT now(),
     UNIQUE(table_name)
 );
+ALTER TABLE tables_to_sync ADD COLUMN IF NOT EXISTS sync_freq INT DEFAULT NULL;
+ALTER TABLE tables_to_sync ADD COLUMN IF NOT EXISTS key_columns TEXT DEFAULT NULL;
+`
+
+	createSyncCheckpointsTable = `
+CREATE TABLE IF NOT EXISTS data_sync_checkpoints (
+    table_name TEXT PRIMARY KEY,
+    change_file TEXT NOT NULL,
+    applied INT NOT NULL DEFAULT 0,
+    last_lsn TEXT,
+    updated_at TIMESTAMPTZ DEFAULT now()
+);
+`
+
+	createSyncSnapshotsTable = `
+CREATE TABLE IF NOT EXISTS data_sync_snapshots (
+    table_name TEXT PRIMARY KEY,
+    status TEXT NOT NULL,
+    source TEXT,
+    snapshot_lsn TEXT,
+    rows_loaded BIGINT NOT NULL DEFAULT 0,
+    total_rows BIGINT NOT NULL DEFAULT 0,
+    error_detail TEXT,
+    updated_at TIMESTAMPTZ DEFAULT now()
+);
 `
 )
 
@@ -69,6 +97,8 @@ func EnsureTables(ctx context.Context, db *sql.DB, logger *slog.Logger) error {
 		{"data_sync_logs", createSyncLogsTable},
 		{"data_sync_metrics", createSyncMetricsTable},
 		{"tables_to_sync", createTablesToSyncTable},
+		{"data_sync_checkpoints", createSyncCheckpointsTable},
+		{"data_sync_snapshots", createSyncSnapshotsTable},
 	}
 
 	for _, t := range tables {
@@ -82,11 +112,67 @@ func EnsureTables(ctx context.Context, db *sql.DB, logger *slog.Logger) error {
 	return nil
 }
 
+// MinTableSyncFreq is the shortest per-table sync frequency, in seconds
+const MinTableSyncFreq = 5
+
 // AddTables adds one or more tables to the sync whitelist.
 func AddTables(ctx context.Context, db *sql.DB, tableNames []string, creator string, logger *slog.Logger) ([]string, error) {
+	return AddTablesWithFreq(ctx, db, tableNames, creator, 0, logger)
+}
+
+// AddTablesWithFreq adds one or more tables to the sync whitelist, synced
+// every 'syncFreq' seconds instead of the global data_sync_freq. Tables
+// already in the whitelist get the new frequency. A syncFreq of 0 adds
+// the tables with the global frequency and leaves existing ones as is;
+// ResetTablesFreq puts them back on the global frequency.
+func AddTablesWithFreq(ctx context.Context, db *sql.DB, tableNames []string, creator string, syncFreq int, logger *slog.Logger) ([]string, error) {
+	return AddTablesWithOptions(ctx, db, tableNames, creator, AddTablesOptions{SyncFreq: syncFreq, Snapshot: true}, logger)
+}
+
+// AddTablesOptions controls how AddTablesWithOptions adds tables
+type AddTablesOptions struct {
+	// SyncFreq as in AddTablesWithFreq
+	SyncFreq int
+
+	// Snapshot makes tables not yet in the whitelist load a snapshot of
+	// their rows before their changes are applied (see snapshot.go)
+	Snapshot bool
+
+	// KeyColumns are the columns that identify a row, for tables whose
+	// primary key is not the key of the source rows or that have none (see
+	// keys.go). They must exist in each table and need a unique index for
+	// the upsert. Tables already in the whitelist get the new key; empty
+	// leaves it as is.
+	KeyColumns []string
+}
+
+// AddTablesWithOptions adds one or more tables to the sync whitelist.
+func AddTablesWithOptions(ctx context.Context, db *sql.DB, tableNames []string, creator string, opts AddTablesOptions, logger *slog.Logger) ([]string, error) {
+	syncFreq := opts.SyncFreq
 	if len(tableNames) == 0 {
 		return nil, nil
 	}
+	if syncFreq != 0 && syncFreq < MinTableSyncFreq {
+		return nil, fmt.Errorf("sync frequency must be at least %d seconds (%s)", MinTableSyncFreq, LOC_TBL_ADD)
+	}
+
+	var updates []string
+	var freq sql.NullInt64
+	if syncFreq > 0 {
+		freq = sql.NullInt64{Int64: int64(syncFreq), Valid: true}
+		updates = append(updates, "sync_freq = EXCLUDED.sync_freq")
+	}
+	var keyColumns sql.NullString
+	if len(opts.KeyColumns) > 0 {
+		keyColumns = sql.NullString{String: strings.Join(opts.KeyColumns, ","), Valid: true}
+		updates = append(updates, "key_columns = EXCLUDED.key_columns")
+	}
+	stmt := `INSERT INTO tables_to_sync (table_name, creator, sync_freq, key_columns) VALUES ($1, $2, $3, $4)
+			 ON CONFLICT (table_name) DO NOTHING`
+	if len(updates) > 0 {
+		stmt = `INSERT INTO tables_to_sync (table_name, creator, sync_freq, key_columns) VALUES ($1, $2, $3, $4)
+			 ON CONFLICT (table_name) DO UPDATE SET ` + strings.Join(updates, ", ")
+	}
 
 	added := make([]string, 0, len(tableNames))
 
@@ -96,10 +182,16 @@ func AddTables(ctx context.Context, db *sql.DB, tableNames []string, creator str
 			continue
 		}
 
-		_, err := db.ExecContext(ctx,
-			`INSERT INTO tables_to_sync (table_name, creator) VALUES ($1, $2)
-			 ON CONFLICT (table_name) DO NOTHING`,
-			name, creator)
+		if err := ValidateKeyColumns(ctx, db, name, opts.KeyColumns); err != nil {
+			return added, err
+		}
+
+		inWhitelist, err := IsTableInWhitelist(ctx, db, name)
+		if err != nil {
+			return added, err
+		}
+
+		_, err = db.ExecContext(ctx, stmt, name, creator, freq, keyColumns)
 		if err != nil {
 			logger.Error("Failed to add table to sync list",
 				"table", name,
@@ -108,13 +200,56 @@ func AddTables(ctx context.Context, db *sql.DB, tableNames []string, creator str
 			return added, fmt.Errorf("failed to add table %s: %w (%s)", name, err, LOC_TBL_ADD)
 		}
 
+		if opts.Snapshot && !inWhitelist {
+			if err := MarkSnapshotPending(ctx, db, name); err != nil {
+				return added, err
+			}
+		}
+
 		added = append(added, name)
-		logger.Info("Added table to sync list", "table", name, "loc", LOC_TBL_ADD)
+		logger.Info("Added table to sync list",
+			"table", name,
+			"sync_freq", syncFreq,
+			"key_columns", opts.KeyColumns,
+			"loc", LOC_TBL_ADD)
 	}
 
 	return added, nil
 }
 
+// ResetTablesFreq clears the frequency AddTablesWithFreq set on tables of
+// the whitelist, so that they are synced every data_sync_freq again. It
+// returns the tables that had one.
+func ResetTablesFreq(ctx context.Context, db *sql.DB, tableNames []string, logger *slog.Logger) ([]string, error) {
+	reset := make([]string, 0, len(tableNames))
+
+	for _, name := range tableNames {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		result, err := db.ExecContext(ctx,
+			`UPDATE tables_to_sync SET sync_freq = NULL WHERE table_name = $1 AND sync_freq IS NOT NULL`,
+			name)
+		if err != nil {
+			logger.Error("Failed to reset table sync frequency",
+				"table", name,
+				"error", err,
+				"loc", LOC_TBL_FREQ)
+			return reset, fmt.Errorf("failed to reset sync frequency of %s: %w (%s)", name, err, LOC_TBL_FREQ)
+		}
+
+		rowsAffected, _ := result.RowsAffected()
+		if rowsAffected > 0 {
+			reset = append(reset, name)
+			logger.Info("Reset table sync frequency", "table", name, "loc", LOC_TBL_FREQ)
+		}
+	}
+
+	return reset, nil
+}
+
 // RemoveTables removes one or more tables from the sync whitelist.
 func RemoveTables(ctx context.Context, db *sql.DB, tableNames []string, logger *slog.Logger) ([]string, error) {
 	if len(tableNames) == 0 {
@@ -140,6 +275,11 @@ func RemoveTables(ctx context.Context, db *sql.DB, tableNames []string, logger *
 			return removed, fmt.Errorf("failed to remove table %s: %w (%s)", name, err, LOC_TBL_REMOVE)
 		}
 
+		// Added again, the table loads a new snapshot
+		if _, err := db.ExecContext(ctx, `DELETE FROM data_sync_snapshots WHERE table_name = $1`, name); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot of %s: %w (%s)", name, err, LOC_TBL_REMOVE)
+		}
+
 		rowsAffected, _ := result.RowsAffected()
 		if rowsAffected > 0 {
 			removed = append(removed, name)
@@ -153,7 +293,7 @@ func RemoveTables(ctx context.Context, db *sql.DB, tableNames []string, logger *
 // ListTables returns all tables in the sync whitelist.
 func ListTables(ctx context.Context, db *sql.DB) ([]TableInfo, error) {
 	rows, err := db.QueryContext(ctx,
-		`SELECT id, table_name, creator, created_at FROM tables_to_sync ORDER BY table_name`)
+		`SELECT id, table_name, creator, sync_freq, key_columns, created_at FROM tables_to_sync ORDER BY table_name`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list tables: %w (%s)", err, LOC_TBL_LIST)
 	}
@@ -163,12 +303,18 @@ func ListTables(ctx context.Context, db *sql.DB) ([]TableInfo, error) {
 	for rows.Next() {
 		var t TableInfo
 		var creator sql.NullString
-		if err := rows.Scan(&t.ID, &t.TableName, &creator, &t.CreatedAt); err != nil {
+		var syncFreq sql.NullInt64
+		var keyColumns sql.NullString
+		if err := rows.Scan(&t.ID, &t.TableName, &creator, &syncFreq, &keyColumns, &t.CreatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan table row: %w (%s)", err, LOC_TBL_LIST)
 		}
 		if creator.Valid {
 			t.Creator = creator.String
 		}
+		if syncFreq.Valid {
+			t.SyncFreq = int(syncFreq.Int64)
+		}
+		t.KeyColumns = parseKeyColumns(keyColumns.String)
 		tables = append(tables, t)
 	}
 
@@ -222,6 +368,12 @@ func ClearTable(ctx context.Context, db *sql.DB, tableName string, logger *slog.
 		return fmt.Errorf("failed to truncate table %s: %w (%s)", tableName, err, LOC_TBL_CLEAR)
 	}
 
+	// A replayed change file is applied to the emptied table in full
+	_, err = db.ExecContext(ctx, `DELETE FROM data_sync_checkpoints WHERE table_name = $1`, tableName)
+	if err != nil {
+		return fmt.Errorf("failed to clear checkpoint of %s: %w (%s)", tableName, err, LOC_TBL_CLEAR)
+	}
+
 	logger.Info("Cleared table", "table", tableName, "loc", LOC_TBL_CLEAR)
 	return nil
 }
@@ -0,0 +1,36 @@
+package tablesyncher
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestResetTablesFreq(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	const stmt = `UPDATE tables_to_sync SET sync_freq = NULL WHERE table_name = $1 AND sync_freq IS NOT NULL`
+	mock.ExpectExec(regexp.QuoteMeta(stmt)).WithArgs("orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(stmt)).WithArgs("users").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	reset, err := ResetTablesFreq(context.Background(), db, []string{"orders", " ", "users"},
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("ResetTablesFreq: %v", err)
+	}
+	if want := []string{"orders"}; !reflect.DeepEqual(reset, want) {
+		t.Errorf("reset = %v, want %v", reset, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -13,6 +13,14 @@ const (
 	OpInsert ChangeOperation = "INSERT"
 	OpUpdate ChangeOperation = "UPDATE"
 	OpDelete ChangeOperation = "DELETE"
+
+	// OpChecksum records the row count and checksum of a table on the
+	// source, as of the changes archived up to and including it
+	OpChecksum ChangeOperation = "CHECKSUM"
+
+	// OpSnapshot heads a snapshot file; its LSN is the position in the
+	// changes the snapshot is consistent with
+	OpSnapshot ChangeOperation = "SNAPSHOT"
 )
 
 // ChangeRecord represents a single change from the logical decoding output.
@@ -24,6 +32,14 @@ type ChangeRecord struct {
 	OldKeys map[string]any         `json:"old_keys,omitempty"` // For UPDATE/DELETE: primary key values
 	LSN     string                 `json:"lsn"`               // Log Sequence Number
 	TS      time.Time              `json:"ts"`                // Timestamp of change
+	Checksum *TableChecksum        `json:"checksum,omitempty"` // For CHECKSUM
+}
+
+// TableChecksum is the row count and aggregate checksum of a table (see
+// ChecksumQuery).
+type TableChecksum struct {
+	RowCount int64  `json:"row_count"`
+	MD5      string `json:"md5"`
 }
 
 // SyncStatus represents the current daemon status.
@@ -34,6 +50,24 @@ const (
 	StatusNotStarted SyncStatus = "not-started"
 )
 
+// ConnectionState is the state of the connection to the archive machine.
+type ConnectionState string
+
+const (
+	ConnConnected    ConnectionState = "connected"
+	ConnReconnecting ConnectionState = "reconnecting"
+	ConnDisconnected ConnectionState = "disconnected"
+)
+
+// ConnectionStatus describes the daemon's connection to the archive machine.
+type ConnectionStatus struct {
+	State         ConnectionState `json:"state"`
+	Since         time.Time       `json:"since"`      // When State was entered
+	Reconnects    int             `json:"reconnects"` // Successful reconnects since startup
+	LastError     string          `json:"last_error,omitempty"`
+	LastErrorTime time.Time       `json:"last_error_time,omitempty"`
+}
+
 // SyncResult summarizes a single sync cycle.
 type SyncResult struct {
 	FilesProcessed int
@@ -44,6 +78,33 @@ type SyncResult struct {
 	RecordsFailed  int64 // Failed to apply
 	Duration       time.Duration
 	LastLSN        string
+	Errors         int                      // Change files that failed to fetch or apply
+	LatestChange   map[string]time.Time     // Timestamp of the newest applied change per table
+	Applied        map[string]AppliedCounts // Records applied per table, by operation
+}
+
+// AppliedCounts counts the change records applied to a table, by operation.
+type AppliedCounts struct {
+	Inserts int64 `json:"inserts"`
+	Updates int64 `json:"updates"`
+	Deletes int64 `json:"deletes"`
+}
+
+// Add returns the sum of 'c' and 'o'.
+func (c AppliedCounts) Add(o AppliedCounts) AppliedCounts {
+	return AppliedCounts{
+		Inserts: c.Inserts + o.Inserts,
+		Updates: c.Updates + o.Updates,
+		Deletes: c.Deletes + o.Deletes,
+	}
+}
+
+// addApplied adds 'counts' to the records applied to 'table'.
+func (r *SyncResult) addApplied(table string, counts AppliedCounts) {
+	if r.Applied == nil {
+		r.Applied = make(map[string]AppliedCounts)
+	}
+	r.Applied[table] = r.Applied[table].Add(counts)
 }
 
 // TableInfo represents a table in the sync whitelist.
@@ -51,7 +112,27 @@ type TableInfo struct {
 	ID        int       `json:"id"`
 	TableName string    `json:"table_name"`
 	Creator   string    `json:"creator,omitempty"`
+	SyncFreq  int       `json:"sync_freq,omitempty"` // Seconds; 0 uses the global data_sync_freq
 	CreatedAt time.Time `json:"created_at"`
+
+	// KeyColumns identify the table's rows; empty uses its primary key
+	KeyColumns []string `json:"key_columns,omitempty"`
+
+	// Snapshot is set by GetDaemonStatus while the initial snapshot of
+	// the table is not loaded
+	Snapshot *SnapshotInfo `json:"snapshot,omitempty"`
+
+	// Runtime is set by GetDaemonStatus from the daemon's runtime state
+	Runtime *TableRuntime `json:"runtime,omitempty"`
+}
+
+// Frequency returns how often the table is synced, given the global
+// data_sync_freq in seconds.
+func (t TableInfo) Frequency(globalFreq int) time.Duration {
+	if t.SyncFreq > 0 {
+		return time.Duration(t.SyncFreq) * time.Second
+	}
+	return time.Duration(globalFreq) * time.Second
 }
 
 // SyncLogEntry represents an entry in the data_sync_logs table.
@@ -86,15 +167,57 @@ type RuntimeStats struct {
 	LastSyncResult    *SyncResult
 }
 
+// DaemonRuntime is the runtime state of the daemon. RunLoop saves it in
+// the state file after every sync cycle, so that status can report it
+// when the database is down.
+type DaemonRuntime struct {
+	PID           int                      `json:"pid"`
+	StartTime     time.Time                `json:"start_time"`
+	LastCycle     time.Time                `json:"last_cycle"`     // Last sync cycle, successful or not
+	CycleDuration time.Duration            `json:"cycle_duration"` // Of the last sync cycle
+	ErrorCount    int64                    `json:"error_count"`    // Since StartTime
+	RecordsSynced int64                    `json:"records_synced"` // Since StartTime
+	LastError     string                   `json:"last_error,omitempty"`
+	LastErrorTime time.Time                `json:"last_error_time,omitempty"`
+	Tables        map[string]*TableRuntime `json:"tables,omitempty"`
+}
+
+// TableRuntime is the runtime state of a table in the whitelist.
+type TableRuntime struct {
+	SyncFreq     int           `json:"sync_freq,omitempty"` // Seconds; 0 uses the global data_sync_freq
+	LastSynced   time.Time     `json:"last_synced,omitempty"`
+	LatestChange time.Time     `json:"latest_change,omitempty"` // Newest change applied to the table
+	Applied      AppliedCounts `json:"applied"`                 // Records applied since the daemon started
+}
+
+// Lag returns the age of the newest change applied to the table (as in
+// the webhook report), or 0 if no change was applied yet.
+func (t *TableRuntime) Lag(now time.Time) time.Duration {
+	if t.LatestChange.IsZero() {
+		return 0
+	}
+	return now.Sub(t.LatestChange)
+}
+
 // DaemonStatus represents the full status output for the CLI.
 type DaemonStatus struct {
-	Status        SyncStatus    `json:"status"`
-	SyncFrequency int           `json:"sync_frequency"` // seconds
-	StartTime     time.Time     `json:"start_time,omitempty"`
-	RecordsSynced int64         `json:"records_synced"`
-	Errors        int64         `json:"errors"`
-	LastSyncTime  time.Time     `json:"last_sync_time,omitempty"`
-	Tables        []TableInfo   `json:"tables,omitempty"`
+	Status        SyncStatus        `json:"status"`
+	SyncFrequency int               `json:"sync_frequency"` // seconds
+	StartTime     time.Time         `json:"start_time,omitempty"`
+	RecordsSynced int64             `json:"records_synced"`
+	Errors        int64             `json:"errors"`
+	LastSyncTime  time.Time         `json:"last_sync_time,omitempty"`
+	Tables        []TableInfo       `json:"tables,omitempty"`
+	Connection    *ConnectionStatus `json:"connection,omitempty"` // Archive connection, while active
+
+	// From the daemon's runtime state in the state file
+	LastCycle     time.Time `json:"last_cycle,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+
+	// DBAvailable is false if status was built without a DB connection,
+	// from the state file only
+	DBAvailable bool `json:"db_available"`
 }
 
 // ChangeFile represents a discovered change file from the archive.
@@ -0,0 +1,147 @@
+package tablesyncher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Location codes for webhook notifications
+const (
+	LOC_WEBHOOK_SEND = "SHD_SYN_100"
+)
+
+// Webhook event types
+const (
+	WebhookEventSummary = "summary" // Sent after every sync cycle
+	WebhookEventAlert   = "alert"   // Sent instead when a cycle errors or a table lags
+)
+
+// CycleReport is the JSON payload posted to the webhook after a sync cycle.
+type CycleReport struct {
+	Event          string    `json:"event"` // summary or alert
+	Time           time.Time `json:"time"`
+	FilesProcessed int       `json:"files_processed"`
+	TablesSynced   int       `json:"tables_synced"`
+	RecordsApplied int64     `json:"records_applied"` // Added + updated + deleted
+	RecordsFailed  int64     `json:"records_failed"`
+	Errors         int       `json:"errors"`
+	Error          string    `json:"error,omitempty"` // Error that aborted the cycle
+	MaxLagSeconds  float64   `json:"max_lag_seconds"`
+	MaxLagTable    string    `json:"max_lag_table,omitempty"`
+	LaggingTables  []string  `json:"lagging_tables,omitempty"` // Tables over lag_alert_threshold
+	Reasons        []string  `json:"reasons,omitempty"`        // Why the report is an alert
+}
+
+// WebhookNotifier posts cycle reports to the configured webhook. Delivery is
+// best-effort: failures are logged and never returned to the sync loop.
+type WebhookNotifier struct {
+	url          string
+	lagThreshold time.Duration
+	client       *http.Client
+	logger       *slog.Logger
+}
+
+// NewWebhookNotifier returns a notifier for config, or nil when no
+// webhook_url is configured.
+func NewWebhookNotifier(config *SyncConfig, logger *slog.Logger) *WebhookNotifier {
+	if config.WebhookURL == "" {
+		return nil
+	}
+	return &WebhookNotifier{
+		url:          config.WebhookURL,
+		lagThreshold: time.Duration(config.LagAlertThreshold) * time.Second,
+		client:       &http.Client{Timeout: time.Duration(config.WebhookTimeout) * time.Second},
+		logger:       logger,
+	}
+}
+
+// BuildReport summarizes a sync cycle. The lag of a table is the age of the
+// newest change applied to it in the cycle.
+func (n *WebhookNotifier) BuildReport(result *SyncResult, cycleErr error, now time.Time) *CycleReport {
+	report := &CycleReport{
+		Event: WebhookEventSummary,
+		Time:  now,
+	}
+	if result != nil {
+		report.FilesProcessed = result.FilesProcessed
+		report.TablesSynced = len(result.LatestChange)
+		report.RecordsApplied = result.RecordsAdded + result.RecordsUpdated + result.RecordsDeleted
+		report.RecordsFailed = result.RecordsFailed
+		report.Errors = result.Errors
+
+		for table, ts := range result.LatestChange {
+			lag := now.Sub(ts)
+			if lag.Seconds() > report.MaxLagSeconds {
+				report.MaxLagSeconds = lag.Seconds()
+				report.MaxLagTable = table
+			}
+			if n.lagThreshold > 0 && lag > n.lagThreshold {
+				report.LaggingTables = append(report.LaggingTables, table)
+			}
+		}
+		sort.Strings(report.LaggingTables)
+	}
+
+	if cycleErr != nil {
+		report.Error = cycleErr.Error()
+		report.Reasons = append(report.Reasons, "sync cycle failed")
+	}
+	if report.Errors > 0 {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("%d change files failed", report.Errors))
+	}
+	if len(report.LaggingTables) > 0 {
+		report.Reasons = append(report.Reasons,
+			fmt.Sprintf("%d tables behind by more than %s", len(report.LaggingTables), n.lagThreshold))
+	}
+	if len(report.Reasons) > 0 {
+		report.Event = WebhookEventAlert
+	}
+	return report
+}
+
+// Notify posts the report of a sync cycle to the webhook. It is a no-op on
+// a nil notifier and once ctx is cancelled (the daemon is shutting down).
+func (n *WebhookNotifier) Notify(ctx context.Context, result *SyncResult, cycleErr error) {
+	if n == nil || ctx.Err() != nil {
+		return
+	}
+
+	report := n.BuildReport(result, cycleErr, time.Now())
+	body, err := json.Marshal(report)
+	if err != nil {
+		n.logger.Warn("Failed to encode webhook report", "error", err, "loc", LOC_WEBHOOK_SEND)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		n.logger.Warn("Failed to create webhook request", "error", err, "loc", LOC_WEBHOOK_SEND)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		n.logger.Warn("Webhook notification failed",
+			"event", report.Event,
+			"error", err,
+			"loc", LOC_WEBHOOK_SEND)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		n.logger.Warn("Webhook rejected notification",
+			"event", report.Event,
+			"status", resp.StatusCode,
+			"loc", LOC_WEBHOOK_SEND)
+		return
+	}
+	n.logger.Debug("Webhook notified", "event", report.Event)
+}
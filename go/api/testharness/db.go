@@ -0,0 +1,150 @@
+// Package testharness is test scaffolding for the packages that reach
+// into the ApiTypes database globals (RequestHandlers, sysdatastores).
+// It provides:
+//
+//   - TestDB: a database for one test, either a real PostgreSQL or sqlmock
+//   - Fixture / LoadFixtures: tables and seed rows from Go literals
+//   - FakeRequestContext: an ApiTypes.RequestContext with programmable
+//     auth and captured responses
+//
+// The database is selected by SHARED_TEST_DB:
+//
+//   - "sqlmock" (default): no database, statements are matched exactly
+//   - "postgres": the server at SHARED_TEST_PG_DSN, in a scratch schema
+//   - "testcontainers": a throwaway postgres container; the tests must be
+//     built with -tags testcontainers and docker must be available
+package testharness
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	_ "github.com/lib/pq"
+)
+
+const (
+	EnvTestDB    = "SHARED_TEST_DB"
+	EnvTestPgDSN = "SHARED_TEST_PG_DSN"
+
+	ModeSqlmock        = "sqlmock"
+	ModePostgres       = "postgres"
+	ModeTestcontainers = "testcontainers"
+)
+
+// TestDB is the database of one test. It is closed (and its scratch
+// schema dropped) when the test ends.
+type TestDB struct {
+	DB     *sql.DB
+	DBType string
+	Mode   string
+
+	// Mock is set in sqlmock mode only. Expectations that were not met
+	// fail the test when it ends.
+	Mock sqlmock.Sqlmock
+
+	// Schema is the scratch schema in the postgres modes
+	Schema string
+}
+
+// Mode returns the database mode selected by SHARED_TEST_DB
+func Mode() string {
+	mode := os.Getenv(EnvTestDB)
+	if mode == "" {
+		return ModeSqlmock
+	}
+	return mode
+}
+
+// NewTestDB returns the database selected by SHARED_TEST_DB. It skips the
+// test if the selected database is not available.
+func NewTestDB(t testing.TB) *TestDB {
+	t.Helper()
+	switch mode := Mode(); mode {
+	case ModeSqlmock:
+		return NewMockDB(t)
+
+	case ModePostgres:
+		dsn := os.Getenv(EnvTestPgDSN)
+		if dsn == "" {
+			t.Skipf("%s=%s but %s is not set", EnvTestDB, mode, EnvTestPgDSN)
+		}
+		return newPostgresDB(t, mode, dsn)
+
+	case ModeTestcontainers:
+		return newPostgresDB(t, mode, startPostgresContainer(t))
+
+	default:
+		t.Fatalf("unknown %s: %q (want %s, %s or %s)",
+			EnvTestDB, mode, ModeSqlmock, ModePostgres, ModeTestcontainers)
+		return nil
+	}
+}
+
+// NewMockDB returns a sqlmock database regardless of SHARED_TEST_DB, for
+// tests that only make sense against scripted results (e.g. DB errors).
+func NewMockDB(t testing.TB) *TestDB {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("sqlmock: %v", err)
+		}
+		db.Close()
+	})
+	return &TestDB{DB: db, DBType: ApiTypes.PgName, Mode: ModeSqlmock, Mock: mock}
+}
+
+// newPostgresDB opens 'dsn' and moves the connection into a new scratch
+// schema. The pool is limited to one connection so the search_path holds.
+func newPostgresDB(t testing.TB, mode string, dsn string) *TestDB {
+	t.Helper()
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	schema := fmt.Sprintf("harness_%d", time.Now().UnixNano())
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		db.Close()
+		t.Fatalf("create schema: %v", err)
+	}
+	if _, err := db.Exec("SET search_path TO " + schema); err != nil {
+		db.Close()
+		t.Fatalf("set search_path: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		db.Close()
+	})
+	return &TestDB{DB: db, DBType: ApiTypes.PgName, Mode: mode, Schema: schema}
+}
+
+// IsMock reports whether statements go to sqlmock
+func (tdb *TestDB) IsMock() bool {
+	return tdb.Mock != nil
+}
+
+// Install makes tdb the project database (ApiTypes.ProjectDBHandle and
+// ApiTypes.DBType) until the test ends.
+func (tdb *TestDB) Install(t testing.TB) {
+	saved_db, saved_type := ApiTypes.ProjectDBHandle, ApiTypes.DBType
+	ApiTypes.ProjectDBHandle, ApiTypes.DBType = tdb.DB, tdb.DBType
+	t.Cleanup(func() { ApiTypes.ProjectDBHandle, ApiTypes.DBType = saved_db, saved_type })
+}
+
+// InstallShared makes tdb the shared database (ApiTypes.SharedDBHandle
+// and ApiTypes.DBType) until the test ends.
+func (tdb *TestDB) InstallShared(t testing.TB) {
+	saved_db, saved_type := ApiTypes.SharedDBHandle, ApiTypes.DBType
+	ApiTypes.SharedDBHandle, ApiTypes.DBType = tdb.DB, tdb.DBType
+	t.Cleanup(func() { ApiTypes.SharedDBHandle, ApiTypes.DBType = saved_db, saved_type })
+}
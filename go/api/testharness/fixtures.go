@@ -0,0 +1,149 @@
+package testharness
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/lib/pq"
+)
+
+// Fixture is a table and its seed rows, written as Go literals. The
+// first field is the primary key. Rows are inserted in order, and the
+// columns of MockRows follow FieldDefs, so results are deterministic.
+type Fixture struct {
+	Table     string
+	FieldDefs []ApiTypes.FieldDef
+	Rows      []map[string]interface{}
+}
+
+// sqlType maps a Jimo field data type to a PostgreSQL column type
+func sqlType(data_type string) (string, error) {
+	switch data_type {
+	case "string", "varchar", "text", "char", "_creator", "_updater":
+		return "TEXT", nil
+	case "int", "integer", "int4":
+		return "INTEGER", nil
+	case "bigint", "int8":
+		return "BIGINT", nil
+	case "smallint", "int2":
+		return "SMALLINT", nil
+	case "_auto_inc":
+		return "BIGSERIAL", nil
+	case "float", "double", "double precision", "float8", "real", "float4", "numeric", "decimal":
+		return "DOUBLE PRECISION", nil
+	case "bool", "boolean":
+		return "BOOLEAN", nil
+	case "date":
+		return "DATE", nil
+	case "datetime", "timestamp", "timestamptz":
+		return "TIMESTAMPTZ", nil
+	case "json", "jsonb":
+		return "JSONB", nil
+	case "array", "text[]", "varchar[]", "string[]":
+		return "TEXT[]", nil
+	case "integer[]", "int[]", "int4[]":
+		return "INTEGER[]", nil
+	}
+	return "", fmt.Errorf("no column type for data type %q", data_type)
+}
+
+// CreateTableStmt returns the CREATE TABLE statement of the fixture
+func (f Fixture) CreateTableStmt() (string, error) {
+	columns := []string{}
+	for i, fd := range f.FieldDefs {
+		if fd.DataType == "_ignore" {
+			continue
+		}
+		col_type, err := sqlType(fd.DataType)
+		if err != nil {
+			return "", fmt.Errorf("table %s, field %s: %w", f.Table, fd.FieldName, err)
+		}
+		column := fd.FieldName + " " + col_type
+		if i == 0 {
+			column += " PRIMARY KEY"
+		}
+		columns = append(columns, column)
+	}
+	return "CREATE TABLE " + f.Table + " (" + strings.Join(columns, ", ") + ")", nil
+}
+
+// columns returns the stored columns in FieldDefs order
+func (f Fixture) columns() []string {
+	columns := []string{}
+	for _, fd := range f.FieldDefs {
+		if fd.DataType != "_ignore" {
+			columns = append(columns, fd.FieldName)
+		}
+	}
+	return columns
+}
+
+// LoadFixtures creates the fixture tables and inserts their rows. In
+// sqlmock mode there is nothing to create: tests script the results with
+// Fixture.MockRows instead.
+func LoadFixtures(t testing.TB, tdb *TestDB, fixtures ...Fixture) {
+	t.Helper()
+	if tdb.IsMock() {
+		return
+	}
+
+	for _, f := range fixtures {
+		stmt, err := f.CreateTableStmt()
+		if err != nil {
+			t.Fatalf("fixture: %v", err)
+		}
+		if _, err := tdb.DB.Exec(stmt); err != nil {
+			t.Fatalf("fixture %s: %v, stmt:%s", f.Table, err, stmt)
+		}
+
+		columns := f.columns()
+		placeholders := make([]string, len(columns))
+		for i := range columns {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+		insert := "INSERT INTO " + f.Table + " (" + strings.Join(columns, ", ") +
+			") VALUES (" + strings.Join(placeholders, ", ") + ")"
+
+		for n, row := range f.Rows {
+			args := make([]interface{}, len(columns))
+			for i, col := range columns {
+				args[i] = dbValue(row[col])
+			}
+			if _, err := tdb.DB.Exec(insert, args...); err != nil {
+				t.Fatalf("fixture %s, row %d: %v", f.Table, n, err)
+			}
+		}
+	}
+}
+
+// dbValue adapts a fixture value to a driver argument
+func dbValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []string:
+		return pq.Array(v)
+	case []int:
+		return pq.Array(v)
+	}
+	return value
+}
+
+// MockRows returns the rows of the fixture that 'match' accepts (all if
+// nil) as sqlmock rows with 'columns', in fixture order.
+func (f Fixture) MockRows(columns []string, match func(row map[string]interface{}) bool) *sqlmock.Rows {
+	rows := sqlmock.NewRows(columns)
+	for _, row := range f.Rows {
+		if match != nil && !match(row) {
+			continue
+		}
+		values := make([]driver.Value, len(columns))
+		for i, col := range columns {
+			values[i] = row[col]
+		}
+		rows.AddRow(values...)
+	}
+	return rows
+}
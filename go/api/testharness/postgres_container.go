@@ -0,0 +1,45 @@
+//go:build testcontainers
+
+package testharness
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// PostgresImage is the image started in testcontainers mode
+var PostgresImage = "postgres:16-alpine"
+
+// startPostgresContainer starts a postgres container for the test and
+// returns its DSN. The container is terminated when the test ends.
+func startPostgresContainer(t testing.TB) string {
+	t.Helper()
+	ctx := context.Background()
+	container, err := postgres.Run(ctx, PostgresImage,
+		postgres.WithDatabase("harness"),
+		postgres.WithUsername("harness"),
+		postgres.WithPassword("harness"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).
+				WithStartupTimeout(60*time.Second)))
+	if err != nil {
+		t.Skipf("testcontainers: cannot start %s: %v", PostgresImage, err)
+	}
+	t.Cleanup(func() {
+		if err := testcontainers.TerminateContainer(container); err != nil {
+			t.Logf("testcontainers: terminate: %v", err)
+		}
+	})
+
+	dsn, err := container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("testcontainers: connection string: %v", err)
+	}
+	return dsn
+}
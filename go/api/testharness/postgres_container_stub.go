@@ -0,0 +1,13 @@
+//go:build !testcontainers
+
+package testharness
+
+import "testing"
+
+// startPostgresContainer needs the testcontainers build tag, which keeps
+// the docker client out of ordinary builds.
+func startPostgresContainer(t testing.TB) string {
+	t.Helper()
+	t.Skipf("%s=%s requires -tags testcontainers", EnvTestDB, ModeTestcontainers)
+	return ""
+}
@@ -0,0 +1,393 @@
+package testharness
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/loggerutil"
+)
+
+// CapturedResponse is one response written through a FakeRequestContext.
+// Only the fields of the matching kind are set.
+type CapturedResponse struct {
+	StatusCode  int
+	JSON        map[string]interface{}
+	HTML        string
+	RedirectURL string
+}
+
+// FakeRequestContext implements ApiTypes.RequestContext without a web
+// framework. Auth results are programmed through User / AuthFunc, user
+// lookups through Users, and every response is appended to Responses.
+type FakeRequestContext struct {
+	Ctx    context.Context
+	Logger ApiTypes.JimoLogger
+	ID     string
+
+	// User is returned by IsAuthenticated; nil means not logged in.
+	// AuthFunc, when set, takes precedence over User.
+	User     *ApiTypes.UserInfo
+	AuthFunc func() *ApiTypes.UserInfo
+
+	// Users backs the GetUserInfoBy* lookups, keyed by email
+	Users map[string]*ApiTypes.UserInfo
+
+	// Passwords backs VerifyUserPassword / UpdatePassword, keyed by email
+	Passwords map[string]string
+
+	Query   map[string]string
+	Params  map[string]string
+	Form    map[string]string
+	Cookies map[string]string
+	Body    []byte
+	Request *http.Request
+
+	Responses []CapturedResponse
+
+	// Sessions records every SaveSession call
+	Sessions []SavedSession
+
+	call_flow []string
+}
+
+// SavedSession holds the arguments of one SaveSession call
+type SavedSession struct {
+	LoginMethod    string
+	SessionID      string
+	AuthToken      string
+	UserName       string
+	UserNameType   string
+	UserRegID      string
+	UserEmail      string
+	Expiry         time.Time
+	NeedUpdateUser bool
+}
+
+// NewFakeRequestContext returns a context authenticated as user (nil for
+// an anonymous request). Its Go context carries the request ID and call
+// flow values that the handlers read.
+func NewFakeRequestContext(t testing.TB, user *ApiTypes.UserInfo) *FakeRequestContext {
+	t.Helper()
+	req_id := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	ctx := context.WithValue(context.Background(), ApiTypes.RequestIDKey, req_id)
+	ctx = context.WithValue(ctx, ApiTypes.CallFlowKey, "SHD_THN_001")
+
+	rc := &FakeRequestContext{
+		Ctx:       ctx,
+		Logger:    loggerutil.CreateDefaultLogger("SHD_THN_002"),
+		ID:        req_id,
+		User:      user,
+		Users:     map[string]*ApiTypes.UserInfo{},
+		Passwords: map[string]string{},
+		Query:     map[string]string{},
+		Form:      map[string]string{},
+		Cookies:   map[string]string{},
+	}
+	if user != nil && user.Email != "" {
+		rc.Users[user.Email] = user
+	}
+	return rc
+}
+
+// SetJSONBody sets the request body to the JSON encoding of v
+func (r *FakeRequestContext) SetJSONBody(t testing.TB, v interface{}) {
+	t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal request body: %v", err)
+	}
+	r.Body = body
+}
+
+// LastResponse returns the most recent captured response. It fails the
+// test when nothing was written.
+func (r *FakeRequestContext) LastResponse(t testing.TB) CapturedResponse {
+	t.Helper()
+	if len(r.Responses) == 0 {
+		t.Fatalf("no response was written")
+	}
+	return r.Responses[len(r.Responses)-1]
+}
+
+func (r *FakeRequestContext) Context() context.Context {
+	return r.Ctx
+}
+
+func (r *FakeRequestContext) GetLogger() ApiTypes.JimoLogger {
+	return r.Logger
+}
+
+func (r *FakeRequestContext) ReqID() string {
+	return r.ID
+}
+
+func (r *FakeRequestContext) Close() {}
+
+func (r *FakeRequestContext) SetReqID(reqID string) {
+	if reqID == "" {
+		return
+	}
+	r.ID = reqID
+	r.Ctx = context.WithValue(r.Ctx, ApiTypes.RequestIDKey, reqID)
+}
+
+func (r *FakeRequestContext) GetCookie(name string) string {
+	return r.Cookies[name]
+}
+
+func (r *FakeRequestContext) SetCookie(session_id string) {
+	r.Cookies["session_id"] = session_id
+}
+
+func (r *FakeRequestContext) DeleteCookie(name string) {
+	delete(r.Cookies, name)
+}
+
+func (r *FakeRequestContext) GetUserID() string {
+	if user_info := r.IsAuthenticated(); user_info != nil {
+		return user_info.UserId
+	}
+	return ""
+}
+
+func (r *FakeRequestContext) IsAuthenticated() *ApiTypes.UserInfo {
+	if r.AuthFunc != nil {
+		return r.AuthFunc()
+	}
+	return r.User
+}
+
+func (r *FakeRequestContext) FormValue(name string) string {
+	return r.Form[name]
+}
+
+func (r *FakeRequestContext) GetBody() io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(r.Body))
+}
+
+func (r *FakeRequestContext) GetRequest() *http.Request {
+	if r.Request == nil {
+		req, _ := http.NewRequestWithContext(r.Ctx, http.MethodPost, "/", bytes.NewReader(r.Body))
+		r.Request = req
+	}
+	return r.Request
+}
+
+func (r *FakeRequestContext) Bind(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+func (r *FakeRequestContext) QueryParam(key string) string {
+	return r.Query[key]
+}
+
+func (r *FakeRequestContext) PathParam(name string) string {
+	return r.Params[name]
+}
+
+func (r *FakeRequestContext) GetUserInfoByEmail(email string) (*ApiTypes.UserInfo, bool) {
+	user_info, ok := r.Users[email]
+	return user_info, ok
+}
+
+func (r *FakeRequestContext) GetUserInfoByToken(token string) (*ApiTypes.UserInfo, bool) {
+	for _, user_info := range r.Users {
+		if user_info.VToken != "" && user_info.VToken == token {
+			return user_info, true
+		}
+	}
+	return nil, false
+}
+
+func (r *FakeRequestContext) GetUserInfoByAppToken(token_name string, token string) (*ApiTypes.UserInfo, bool) {
+	return r.GetUserInfoByToken(token)
+}
+
+func (r *FakeRequestContext) GetUserInfoByUserID(user_id string) (*ApiTypes.UserInfo, bool) {
+	for _, user_info := range r.Users {
+		if user_info.UserId == user_id {
+			return user_info, true
+		}
+	}
+	return nil, false
+}
+
+func (r *FakeRequestContext) GetUserInfoByUserName(user_name string) (*ApiTypes.UserInfo, bool) {
+	var found *ApiTypes.UserInfo
+	for _, user_info := range r.Users {
+		if user_info.UserName == user_name {
+			if found != nil {
+				return nil, false
+			}
+			found = user_info
+		}
+	}
+	return found, found != nil
+}
+
+func (r *FakeRequestContext) MarkUserVerified(email string) (bool, error) {
+	user_info, ok := r.Users[email]
+	if !ok {
+		return false, fmt.Errorf("user not found:%s (SHD_THN_010)", email)
+	}
+	newly_verified := !user_info.Verified
+	user_info.Verified = true
+	return newly_verified, nil
+}
+
+// tokenTaken reports whether 'token' is the VToken of a user other than
+// 'email', which the unique index on v_token rejects
+func (r *FakeRequestContext) tokenTaken(email string, token string) bool {
+	other, ok := r.GetUserInfoByToken(token)
+	return ok && other.Email != email
+}
+
+func (r *FakeRequestContext) UpdateTokenByEmail(email string, token string) error {
+	user_info, ok := r.Users[email]
+	if !ok {
+		return fmt.Errorf("user not found:%s (SHD_THN_011)", email)
+	}
+	if r.tokenTaken(email, token) {
+		return fmt.Errorf("%w (SHD_THN_015), email:%s", ApiTypes.ErrTokenConflict, email)
+	}
+	user_info.VToken = token
+	return nil
+}
+
+func (r *FakeRequestContext) UpdateAppTokenByEmail(email string, token_name string, token string) error {
+	return r.UpdateTokenByEmail(email, token)
+}
+
+func (r *FakeRequestContext) VerifyUserPassword(
+	userInfo *ApiTypes.UserInfo,
+	plaintextPassword string) (bool, int, string) {
+	if userInfo == nil {
+		return false, ApiTypes.CustomHttpStatus_BadRequest, "missing user info"
+	}
+	if password, ok := r.Passwords[userInfo.Email]; ok && password == plaintextPassword {
+		return true, ApiTypes.CustomHttpStatus_Success, ""
+	}
+	return false, ApiTypes.CustomHttpStatus_BadRequest, "invalid password"
+}
+
+func (r *FakeRequestContext) UpdatePassword(email string, plaintextPassword string) (bool, int, string) {
+	r.Passwords[email] = plaintextPassword
+	return true, ApiTypes.CustomHttpStatus_Success, ""
+}
+
+func (r *FakeRequestContext) SetTemporaryPassword(
+	email string,
+	plaintextPassword string,
+	expires_at time.Time) (bool, int, string) {
+	user_info, ok := r.Users[email]
+	if !ok {
+		return false, http.StatusNotFound, fmt.Sprintf("user not found:%s (SHD_THN_017)", email)
+	}
+	r.Passwords[email] = plaintextPassword
+	user_info.PasswordExpiresAt = &expires_at
+	return true, ApiTypes.CustomHttpStatus_Success, ""
+}
+
+func (r *FakeRequestContext) SendHTMLResp(html_str string) error {
+	r.Responses = append(r.Responses, CapturedResponse{StatusCode: http.StatusOK, HTML: html_str})
+	return nil
+}
+
+func (r *FakeRequestContext) SendJSONResp(status_code int, json_resp map[string]interface{}) error {
+	r.Responses = append(r.Responses, CapturedResponse{StatusCode: status_code, JSON: json_resp})
+	return nil
+}
+
+func (r *FakeRequestContext) JSON(status_code int, json_resp map[string]interface{}) error {
+	return r.SendJSONResp(status_code, json_resp)
+}
+
+func (r *FakeRequestContext) GenerateAuthToken(email string) (string, error) {
+	return fmt.Sprintf("test-token-%s", email), nil
+}
+
+func (r *FakeRequestContext) Redirect(redirect_url string, status_code int) error {
+	r.Responses = append(r.Responses, CapturedResponse{StatusCode: status_code, RedirectURL: redirect_url})
+	return nil
+}
+
+func (r *FakeRequestContext) IsAuthed() bool {
+	return r.IsAuthenticated() != nil
+}
+
+func (r *FakeRequestContext) GetCallFlow() string {
+	return strings.Join(r.call_flow, "->")
+}
+
+func (r *FakeRequestContext) PushCallFlow(loc string) string {
+	r.call_flow = append(r.call_flow, loc)
+	return strings.Join(r.call_flow, "->")
+}
+
+func (r *FakeRequestContext) PopCallFlow() string {
+	if len(r.call_flow) <= 0 {
+		return ""
+	}
+	r.call_flow = r.call_flow[:len(r.call_flow)-1]
+	return strings.Join(r.call_flow, "->")
+}
+
+func (r *FakeRequestContext) UpsertUser(
+	user_info *ApiTypes.UserInfo,
+	plain_password string,
+	verified bool,
+	admin bool,
+	is_owner bool,
+	email_visibility bool,
+	is_update bool) (*ApiTypes.UserInfo, error) {
+	if user_info == nil || user_info.Email == "" {
+		return nil, fmt.Errorf("missing user email (SHD_THN_012)")
+	}
+	if user_info.VToken != "" && r.tokenTaken(user_info.Email, user_info.VToken) {
+		return nil, fmt.Errorf("%w (SHD_THN_016), email:%s", ApiTypes.ErrTokenConflict, user_info.Email)
+	}
+	user_info.Verified = verified
+	user_info.Admin = admin
+	user_info.IsOwner = is_owner
+	user_info.EmailVisibility = email_visibility
+	r.Users[user_info.Email] = user_info
+	if plain_password != "" {
+		r.Passwords[user_info.Email] = plain_password
+	}
+	return user_info, nil
+}
+
+func (r *FakeRequestContext) SaveSession(
+	login_method string,
+	session_id string,
+	auth_token string,
+	user_name string,
+	user_name_type string,
+	user_reg_id string,
+	user_email string,
+	expiry time.Time,
+	need_update_user bool) error {
+	r.Sessions = append(r.Sessions, SavedSession{
+		LoginMethod:    login_method,
+		SessionID:      session_id,
+		AuthToken:      auth_token,
+		UserName:       user_name,
+		UserNameType:   user_name_type,
+		UserRegID:      user_reg_id,
+		UserEmail:      user_email,
+		Expiry:         expiry,
+		NeedUpdateUser: need_update_user,
+	})
+	r.Cookies["session_id"] = session_id
+	return nil
+}
+
+var _ ApiTypes.RequestContext = (*FakeRequestContext)(nil)
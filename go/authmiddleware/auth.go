@@ -151,28 +151,31 @@ func isStaticAsset(path string) bool {
 }
 
 // IsAuthenticated checks if the request is from an authenticated user.
-// Validates the session via Kratos.
+// Validates the session via Kratos, or, when Kratos is not used, the
+// session_id cookie of an email login (see isSessionAuthenticated).
 // Returns:
 //   - (user_info, nil) on success
 //   - (nil, error) when auth fails or no valid session exists
 func IsAuthenticated(rc ApiTypes.RequestContext) (*ApiTypes.UserInfo, error) {
 	// logger := rc.GetLogger()
 
+	if KratosAuthenticator == nil {
+		return isSessionAuthenticated(rc)
+	}
+
 	// Clean up any stale legacy session_id cookies from before Kratos migration
 	if cookie := rc.GetCookie("session_id"); cookie != "" {
 		rc.DeleteCookie("session_id")
 	}
 
 	// Validate session via Kratos
-	if KratosAuthenticator != nil {
-		user_info, err := KratosAuthenticator(rc)
-		if err != nil {
-			// logger.Warn("Kratos auth failed", "error", err)
-			return nil, fmt.Errorf("kratos auth error: %w", err)
-		}
-		if user_info != nil {
-			return user_info, nil
-		}
+	user_info, err := KratosAuthenticator(rc)
+	if err != nil {
+		// logger.Warn("Kratos auth failed", "error", err)
+		return nil, fmt.Errorf("kratos auth error: %w", err)
+	}
+	if user_info != nil {
+		return user_info, nil
 	}
 
 	return nil, fmt.Errorf("no valid session found")
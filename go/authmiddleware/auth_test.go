@@ -0,0 +1,180 @@
+package authmiddleware
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/loggerutil"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+type testRC struct {
+	ApiTypes.RequestContext
+	logger  ApiTypes.JimoLogger
+	cookies map[string]string
+	users   map[string]*ApiTypes.UserInfo
+}
+
+func (rc testRC) Context() context.Context       { return context.Background() }
+func (rc testRC) GetLogger() ApiTypes.JimoLogger { return rc.logger }
+func (rc testRC) ReqID() string                  { return "test" }
+func (rc testRC) GetCookie(name string) string   { return rc.cookies[name] }
+
+func (rc testRC) GetUserInfoByEmail(email string) (*ApiTypes.UserInfo, bool) {
+	user_info, found := rc.users[email]
+	return user_info, found
+}
+
+// nearNow matches a time argument within a minute of now+offset
+type nearNow struct{ offset time.Duration }
+
+func (n nearNow) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Sub(time.Now().Add(n.offset)).Abs() < time.Minute
+}
+
+const (
+	validSessionSQL = "SELECT COUNT(*) FROM login_sessions WHERE session_id = $1 AND status = $2 AND expires_at > $3"
+	getSessionSQL   = "SELECT session_id, login_method, status, user_name, user_name_type, user_reg_id, " +
+		"user_email, expires_at FROM login_sessions WHERE session_id = $1"
+	touchSessionSQL = "UPDATE login_sessions SET last_activity_at = $1 WHERE session_id = $2 AND status = $3 " +
+		"AND (last_activity_at IS NULL OR last_activity_at < $4)"
+)
+
+// installSessions points the login sessions table at a sqlmock database
+// for the test, without Kratos
+func installSessions(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+
+	saved_db, saved_type := ApiTypes.SharedDBHandle, ApiTypes.DBType
+	saved_table := ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions
+	saved_idle := ApiTypes.LibConfig.SessionIdleTimeoutMinutes
+	saved_kratos := KratosAuthenticator
+	ApiTypes.SharedDBHandle, ApiTypes.DBType = db, ApiTypes.PgName
+	ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions = "login_sessions"
+	ApiTypes.LibConfig.SessionIdleTimeoutMinutes = 0
+	KratosAuthenticator = nil
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+		ApiTypes.SharedDBHandle, ApiTypes.DBType = saved_db, saved_type
+		ApiTypes.LibConfig.SystemTableNames.TableNameLoginSessions = saved_table
+		ApiTypes.LibConfig.SessionIdleTimeoutMinutes = saved_idle
+		KratosAuthenticator = saved_kratos
+	})
+	return mock
+}
+
+func sessionRC(session_id string) testRC {
+	return testRC{
+		logger:  loggerutil.CreateDefaultLogger("SHD_ATH_T01"),
+		cookies: map[string]string{"session_id": session_id},
+		users: map[string]*ApiTypes.UserInfo{
+			"alice@example.com": {UserName: "alice", Email: "alice@example.com", Verified: true},
+		},
+	}
+}
+
+// expectSession expects the session 'session_id' of 'user_email' to be
+// looked up after it was found valid
+func expectSession(mock sqlmock.Sqlmock, session_id string, user_email string) {
+	mock.ExpectQuery(validSessionSQL).
+		WithArgs(session_id, sysdatastores.SessionStatusActive, nearNow{}).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(getSessionSQL).
+		WithArgs(session_id).
+		WillReturnRows(sqlmock.NewRows([]string{"session_id", "login_method", "status", "user_name",
+			"user_name_type", "user_reg_id", "user_email", "expires_at"}).
+			AddRow(session_id, "email", sysdatastores.SessionStatusActive, "alice", "email", "",
+				user_email, time.Now().Add(time.Hour)))
+}
+
+func TestIsAuthenticatedBySession(t *testing.T) {
+	t.Run("valid session", func(t *testing.T) {
+		mock := installSessions(t)
+		expectSession(mock, "sess-1", "alice@example.com")
+		mock.ExpectExec(touchSessionSQL).
+			WithArgs(nearNow{}, "sess-1", sysdatastores.SessionStatusActive, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		user_info, err := IsAuthenticated(sessionRC("sess-1"))
+		if err != nil || user_info == nil || user_info.UserName != "alice" {
+			t.Fatalf("IsAuthenticated = %v, %v; want alice", user_info, err)
+		}
+	})
+
+	// The check counts only active sessions expiring after now: a session
+	// ended by logout or expired is not counted
+	for _, name := range []string{"ended session", "expired session"} {
+		t.Run(name, func(t *testing.T) {
+			mock := installSessions(t)
+			mock.ExpectQuery(validSessionSQL).
+				WithArgs("sess-1", sysdatastores.SessionStatusActive, nearNow{}).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+			user_info, err := IsAuthenticated(sessionRC("sess-1"))
+			if err == nil || user_info != nil || !strings.Contains(err.Error(), "no valid session") {
+				t.Errorf("IsAuthenticated = %v, %v; want no valid session", user_info, err)
+			}
+		})
+	}
+
+	// With an idle timeout, the check also requires a request within it
+	t.Run("idle session", func(t *testing.T) {
+		mock := installSessions(t)
+		ApiTypes.LibConfig.SessionIdleTimeoutMinutes = 30
+		mock.ExpectQuery(validSessionSQL+" AND (last_activity_at IS NULL OR last_activity_at > $4)").
+			WithArgs("sess-1", sysdatastores.SessionStatusActive, nearNow{}, nearNow{-30 * time.Minute}).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		user_info, err := IsAuthenticated(sessionRC("sess-1"))
+		if err == nil || user_info != nil || !strings.Contains(err.Error(), "no valid session") {
+			t.Errorf("IsAuthenticated = %v, %v; want no valid session", user_info, err)
+		}
+	})
+
+	// Failing to record the activity doesn't fail the request
+	t.Run("touch fails", func(t *testing.T) {
+		mock := installSessions(t)
+		expectSession(mock, "sess-1", "alice@example.com")
+		mock.ExpectExec(touchSessionSQL).
+			WithArgs(nearNow{}, "sess-1", sysdatastores.SessionStatusActive, sqlmock.AnyArg()).
+			WillReturnError(errors.New("read-only transaction"))
+
+		user_info, err := IsAuthenticated(sessionRC("sess-1"))
+		if err != nil || user_info == nil {
+			t.Errorf("IsAuthenticated = %v, %v; want alice", user_info, err)
+		}
+	})
+
+	t.Run("missing user", func(t *testing.T) {
+		mock := installSessions(t)
+		expectSession(mock, "sess-1", "gone@example.com")
+
+		user_info, err := IsAuthenticated(sessionRC("sess-1"))
+		if err == nil || user_info != nil || !strings.Contains(err.Error(), "user of session not found") {
+			t.Errorf("IsAuthenticated = %v, %v; want user not found", user_info, err)
+		}
+	})
+
+	t.Run("no cookie", func(t *testing.T) {
+		installSessions(t)
+
+		user_info, err := IsAuthenticated(sessionRC(""))
+		if err == nil || user_info != nil {
+			t.Errorf("IsAuthenticated = %v, %v; want an error", user_info, err)
+		}
+	})
+}
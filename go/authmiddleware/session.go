@@ -0,0 +1,40 @@
+package authmiddleware
+
+import (
+	"fmt"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+)
+
+// isSessionAuthenticated authenticates a request without Kratos by its
+// session_id cookie, which an email login sets. The session must be valid
+// in the login sessions table (see sysdatastores.IsValidSession): a
+// session ended by logout, or expired, is rejected even if the browser
+// still sends its cookie. The request is recorded as activity of the
+// session.
+func isSessionAuthenticated(rc ApiTypes.RequestContext) (*ApiTypes.UserInfo, error) {
+	session_id := rc.GetCookie("session_id")
+	valid, err := sysdatastores.IsValidSession(rc, session_id)
+	if err != nil {
+		return nil, fmt.Errorf("session auth error: %w", err)
+	}
+	if !valid {
+		return nil, fmt.Errorf("no valid session found")
+	}
+
+	session, err := sysdatastores.GetLoginSession(rc, session_id)
+	if err != nil {
+		return nil, fmt.Errorf("session auth error: %w", err)
+	}
+	user_info, found := rc.GetUserInfoByEmail(session.UserEmail)
+	if !found || user_info == nil {
+		return nil, fmt.Errorf("user of session not found, email:%s", session.UserEmail)
+	}
+
+	// Failing to record the activity doesn't fail the request
+	if err := sysdatastores.TouchSession(rc, session_id); err != nil {
+		rc.GetLogger().Warn("failed to touch session", "error", err)
+	}
+	return user_info, nil
+}
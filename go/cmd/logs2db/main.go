@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/chendingplano/shared/go/api/logs2db"
@@ -29,7 +30,8 @@ func createLogger() *slog.Logger {
 		Level: level,
 	}
 
-	return slog.New(slog.NewTextHandler(os.Stdout, opts))
+	// Logs go to stderr: stdout carries the entries of a stdout sink
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
 }
 
 var rootCmd = &cobra.Command{
@@ -39,7 +41,22 @@ var rootCmd = &cobra.Command{
 loads new entries into a PostgreSQL table.
 
 Configuration via TOML file specified by LOG2DB_CONFIG environment variable.
-Database connection via: PG_USER_NAME, PG_PASSWORD, PG_DB_NAME, PG_HOST, PG_PORT`,
+Database connection via: PG_USER_NAME, PG_PASSWORD, PG_DB_NAME, PG_HOST, PG_PORT
+
+Entries can also be forwarded to other tables or to stdout (as JSON lines)
+with [[sinks]] entries in the config; the first sink is the primary. The
+service logs to stderr, so stdout only has the entries.
+
+New lines are found by scanning all files every sync_freq_in_secon seconds.
+With watch_mode = "fsnotify", files are scanned when written to instead
+(events debounced by watch_debounce_ms, default 200), falling back to
+polling if the file watcher is unavailable.
+
+Ingestion can be throttled so a backlog does not starve the database:
+max_insert_rate caps the rows written per second and max_lines_per_file
+the new lines taken from one file per cycle, so the other files get their
+turn. The limits apply to start and reload alike; reload refuses to run
+while the service is running.`,
 }
 
 var startCmd = &cobra.Command{
@@ -93,6 +110,7 @@ The service writes a PID file for stop/status commands.`,
 		logger.Info("log2db service started",
 			"log_dir", config.LogFileDir,
 			"table", config.DBTableName,
+			"watch_mode", config.WatchMode,
 			"poll_interval_sec", config.SyncFreqSec)
 
 		return service.RunLoop(ctx)
@@ -187,10 +205,11 @@ var statusCmd = &cobra.Command{
 var reloadCmd = &cobra.Command{
 	Use:   "reload",
 	Short: "Clear table and reload all log files from scratch",
-	Long: `Truncates the database table, resets the state file, and reloads
-all log files from the configured directory.
+	Long: `Truncates the database tables of all pg_table sinks, resets the
+state file, and reloads all log files from the configured directory.
+The service must be stopped first.
 
-WARNING: This deletes all existing log entries from the table.`,
+WARNING: This deletes all existing log entries from the tables.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := createLogger()
 
@@ -199,9 +218,19 @@ WARNING: This deletes all existing log entries from the table.`,
 			return err
 		}
 
+		// The running service would write next to the reload, past
+		// max_insert_rate, and into the tables being truncated
+		if pid, err := logs2db.ReadPIDFile(config.PIDFilePath); err == nil {
+			if logs2db.IsRunning(pid) {
+				return fmt.Errorf("log2db is running (PID %d), stop it before reloading", pid)
+			}
+			// Stale PID file, clean up
+			logs2db.RemovePIDFile(config.PIDFilePath)
+		}
+
 		// Interactive confirmation
-		fmt.Printf("WARNING: This will DELETE ALL rows from table '%s' and reload all log files.\n",
-			config.DBTableName)
+		fmt.Printf("WARNING: This will DELETE ALL rows from table(s) %s and reload all log files.\n",
+			strings.Join(config.SinkTableNames(), ", "))
 		fmt.Print("Type 'yes' to confirm: ")
 		var confirm string
 		fmt.Scanln(&confirm)
@@ -216,6 +245,12 @@ WARNING: This deletes all existing log entries from the table.`,
 		}
 		defer service.Close()
 
+		// The PID file keeps start from running during the reload
+		if err := logs2db.WritePIDFile(config.PIDFilePath); err != nil {
+			return fmt.Errorf("failed to write PID file: %w", err)
+		}
+		defer logs2db.RemovePIDFile(config.PIDFilePath)
+
 		result, err := service.Reload(context.Background())
 		if err != nil {
 			return err
@@ -226,6 +261,9 @@ WARNING: This deletes all existing log entries from the table.`,
 		fmt.Printf("  Lines inserted: %d\n", result.LinesInserted)
 		fmt.Printf("  Lines failed:   %d\n", result.LinesFailed)
 		fmt.Printf("  Duration:       %v\n", result.Duration)
+		if stats := service.GetStats(); stats.ThrottledWrites > 0 {
+			fmt.Printf("  Throttled:      %d writes\n", stats.ThrottledWrites)
+		}
 		return nil
 	},
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/chendingplano/shared/go/api/pgbackup"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of pgbackup, so that cron jobs and wrapper scripts can tell
+// why a command failed (retry, alert or ignore). They are documented in
+// Documents/pgbackup.md; don't renumber them.
+const (
+	ExitOK           = 0
+	ExitGeneric      = 1 // Any other error
+	ExitConfig       = 2 // Missing or invalid configuration, flags or arguments
+	ExitConnection   = 3 // PostgreSQL unreachable
+	ExitDisk         = 4 // Not enough disk space, or the disk is full
+	ExitVerification = 5 // A backup failed verification or a restore test
+	ExitLocked       = 6 // Another pgbackup operation holds the lock
+)
+
+// exitError is an error of a command with the exit code of its category
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExitCode sets the exit code of a command failing with 'err'. A nil
+// 'err' stays nil.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// configError, connectionError, diskError and verificationError set the
+// exit code of 'err' to that of their category
+func configError(err error) error       { return withExitCode(ExitConfig, err) }
+func connectionError(err error) error   { return withExitCode(ExitConnection, err) }
+func diskError(err error) error         { return withExitCode(ExitDisk, err) }
+func verificationError(err error) error { return withExitCode(ExitVerification, err) }
+
+// configArgs makes the positional argument validator 'args' fail with a
+// configuration error, like an invalid flag
+func configArgs(args cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, arguments []string) error {
+		return configError(args(cmd, arguments))
+	}
+}
+
+// exitCode returns the exit code of a command that failed with 'err'. A
+// held lock and a full disk are recognized wherever they happen; other
+// errors are categorized by the commands.
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if errors.Is(err, pgbackup.ErrLocked) {
+		return ExitLocked
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		return ExitDisk
+	}
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return ExitGeneric
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/chendingplano/shared/go/api/pgbackup"
+	"github.com/spf13/cobra"
+)
+
+func TestExitCode(t *testing.T) {
+	failed := errors.New("pg_basebackup failed")
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, ExitOK},
+		{"generic", failed, ExitGeneric},
+		{"config", configError(failed), ExitConfig},
+		{"connection", connectionError(failed), ExitConnection},
+		{"disk", diskError(failed), ExitDisk},
+		{"verification", verificationError(failed), ExitVerification},
+		{"wrapped category", fmt.Errorf("backup: %w", connectionError(failed)), ExitConnection},
+		{"lock", fmt.Errorf("backup: %w", pgbackup.ErrLocked), ExitLocked},
+		// A held lock and a full disk win over the category of the command
+		{"lock in a config error", configError(pgbackup.ErrLocked), ExitLocked},
+		{"disk full", connectionError(&os.PathError{Op: "write", Path: "/backups/x", Err: syscall.ENOSPC}), ExitDisk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+
+	if err := configError(nil); err != nil {
+		t.Errorf("configError(nil) = %v, want nil", err)
+	}
+}
+
+// A wrong number of arguments is a configuration error, like an invalid flag
+func TestConfigArgs(t *testing.T) {
+	args := configArgs(cobra.ExactArgs(1))
+	if err := args(&cobra.Command{}, []string{"base_20260102"}); err != nil {
+		t.Fatalf("one argument: %v", err)
+	}
+	if code := exitCode(args(&cobra.Command{}, nil)); code != ExitConfig {
+		t.Errorf("no argument: exit code %d, want %d", code, ExitConfig)
+	}
+}
@@ -5,10 +5,15 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/chendingplano/shared/go/api/ApiUtils"
 	"github.com/chendingplano/shared/go/api/pgbackup"
 	_ "github.com/lib/pq"
 	"github.com/spf13/cobra"
@@ -16,11 +21,18 @@ import (
 
 var (
 	// Flags
-	verbose bool
+	verbose    bool
+	configPath string
 )
 
 // createLogger creates a slog logger for CLI output
 func createLogger() *slog.Logger {
+	return newLogger(os.Stdout)
+}
+
+// newLogger creates a logger writing to 'w'. Commands that write data to
+// stdout log to stderr instead.
+func newLogger(w io.Writer) *slog.Logger {
 	level := slog.LevelInfo
 	if verbose {
 		level = slog.LevelDebug
@@ -30,17 +42,14 @@ func createLogger() *slog.Logger {
 		Level: level,
 	}
 
-	return slog.New(slog.NewTextHandler(os.Stdout, opts))
+	return slog.New(slog.NewTextHandler(w, opts))
 }
 
 // connectDB creates a database connection for PostgreSQL operations
 func connectDB(config *pgbackup.BackupConfig) (*sql.DB, error) {
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		config.PGHost, config.PGPort, config.PGUser, config.PGPassword, config.PGDatabase)
-
-	db, err := sql.Open("postgres", connStr)
+	db, err := openDB(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
 
 	// Test connection
@@ -55,13 +64,81 @@ func connectDB(config *pgbackup.BackupConfig) (*sql.DB, error) {
 	return db, nil
 }
 
+// openDB opens a database handle without connecting; connections are made
+// when it is used
+func openDB(config *pgbackup.BackupConfig) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		config.PGHost, config.PGPort, config.PGUser, config.PGPassword, config.PGDatabase)
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
+// printValidation prints the outcome of restore --validate
+func printValidation(v *pgbackup.ValidateResult) {
+	fmt.Println()
+	if v.Success {
+		fmt.Println("Validation: PASSED")
+	} else {
+		fmt.Println("Validation: FAILED")
+	}
+	fmt.Printf("  Port:              %d\n", v.Port)
+	if v.RecoveryDuration > 0 {
+		fmt.Printf("  Recovery Duration: %s\n", v.RecoveryDuration.Round(time.Second))
+	}
+	fmt.Printf("  Query:             %s\n", v.Query)
+	if v.QueryResult != "" {
+		fmt.Printf("  Result:            %s\n", v.QueryResult)
+	}
+	if v.ErrorMsg != "" {
+		fmt.Printf("  Error:             %s\n", v.ErrorMsg)
+	}
+}
+
+// printTableDumps prints the table dumps for list --tables
+func printTableDumps(service *pgbackup.BackupService) error {
+	dumps, err := service.ListTableDumps()
+	if err != nil {
+		return err
+	}
+	if len(dumps) == 0 {
+		fmt.Println("No table dumps found.")
+		return nil
+	}
+
+	fmt.Println()
+	fmt.Println("Table Dumps:")
+	fmt.Println()
+	fmt.Printf("%-40s %-30s %-25s %12s\n", "DUMP ID", "TABLE", "TIMESTAMP", "SIZE")
+	fmt.Printf("%-40s %-30s %-25s %12s\n", "-------", "-----", "---------", "----")
+	for _, d := range dumps {
+		fmt.Printf("%-40s %-30s %-25s %10.2f MB\n",
+			d.DumpID,
+			d.QualifiedName(),
+			d.StartTime.Format("2006-01-02 15:04:05 MST"),
+			float64(d.SizeBytes)/(1024*1024))
+	}
+	fmt.Println()
+	return nil
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "pgbackup",
 	Short: "PostgreSQL WAL archiving and PITR backup tool",
 	Long: `pgbackup provides PostgreSQL backup management with WAL archiving
 and Point-in-Time Recovery (PITR) capabilities.
 
+Settings come from environment variables and, optionally, a TOML or
+YAML config file (--config or PGBACKUP_CONFIG). Environment variables
+win over the file. File keys are the variable names in lower case,
+without the PG_BACKUP_ prefix (e.g. retain_days), except pg_user,
+pg_database and pgdata.
+
 Environment variables:
+  PGBACKUP_CONFIG           Config file (optional)
   PG_USER_NAME              PostgreSQL username
   PG_PASSWORD               PostgreSQL password
   PG_DB_NAME                PostgreSQL database name
@@ -69,8 +146,27 @@ Environment variables:
   PG_PORT                   PostgreSQL port (default: 5432)
   PG_BACKUP_DIR             Base directory for backups (required)
   PGDATA                    PostgreSQL data directory (for restore)
+  PG_BACKUP_PG_VERSION      PostgreSQL version of backups without PG_VERSION (e.g. 11)
   PG_BACKUP_RETAIN_DAYS     Days to keep backups (default: 7)
   PG_BACKUP_RETAIN_COUNT    Minimum backups to keep (default: 3)
+  PG_BACKUP_RETAIN_LABELED  Never delete labeled backups (default: false)
+  PG_BACKUP_DISK_SPACE_FACTOR  Free space needed per byte of database (default: 1.5)
+  PG_BACKUP_MIN_FREE_MB     Free space needed if the DB is unreachable (default: 1024)
+  PG_BACKUP_VALIDATE_PORT   Port for restore --validate (default: 54329)
+  PG_BACKUP_VALIDATE_QUERY  Query for restore --validate
+  PG_BACKUP_ARCHIVE_TEST_TIMEOUT  Seconds init waits for WAL archiving (default: 60, 0 skips)
+  PG_BACKUP_SCHEDULE        Backup schedule of 'daemon' (default: "0 2 * * *")
+  PG_BACKUP_CLEANUP_SCHEDULE  Cleanup schedule of 'daemon' (default: "0 3 * * 0")
+  PG_BACKUP_SYNC_SCHEDULE   Sync schedule of 'daemon' (default: "0 * * * *")
+
+Exit codes:
+  0  Success
+  1  Generic error
+  2  Configuration error (environment, config file, flags or arguments)
+  3  PostgreSQL unreachable
+  4  Not enough disk space, or the disk is full
+  5  Verification or restore test failed
+  6  Another pgbackup operation is running
 `,
 }
 
@@ -80,6 +176,12 @@ var initCmd = &cobra.Command{
 	Long: `Creates backup directories, installs the WAL archive script,
 and verifies PostgreSQL configuration for WAL archiving.
 
+If PostgreSQL is reachable and configured for archiving, init also tests
+archiving end to end: it switches to a new WAL segment with pg_switch_wal()
+and fails unless the segment shows up in the archive directory within
+PG_BACKUP_ARCHIVE_TEST_TIMEOUT seconds. Re-run init after configuring
+PostgreSQL to run the test.
+
 This command should be run once before starting backups.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := createLogger()
@@ -87,7 +189,7 @@ This command should be run once before starting backups.`,
 
 		config, err := pgbackup.LoadConfig()
 		if err != nil {
-			return err
+			return configError(err)
 		}
 
 		// Try to connect to verify PG config
@@ -131,25 +233,72 @@ var backupCmd = &cobra.Command{
 	Long: `Creates a full base backup using pg_basebackup.
 
 The backup includes all database files compressed with gzip.
-WAL files are streamed during the backup to ensure consistency.`,
+WAL files are streamed during the backup to ensure consistency.
+
+Use --label (repeatable) to tag a backup, e.g. before a migration, so
+it can be found with 'list --label' and kept by cleanup when
+PG_BACKUP_RETAIN_LABELED is set.
+
+With --stdout, the gzipped tar is written to stdout (logs go to stderr)
+to pipe it elsewhere, e.g. into 'restore --stdin' on another host. The
+WAL is fetched into the tar at the end of the backup. Nothing is written
+to PG_BACKUP_DIR: a streamed backup has no backup ID or labels, and
+list, verify, cleanup and remote sync don't know about it.
+
+Examples:
+  pgbackup backup
+  pgbackup backup --label pre-migration-042
+  pgbackup backup --stdout | ssh standby pgbackup restore --stdin --target-dir /data/copy`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		logger := createLogger()
 		ctx := context.Background()
 
+		labels, _ := cmd.Flags().GetStringSlice("label")
+		toStdout, _ := cmd.Flags().GetBool("stdout")
+		if toStdout {
+			return streamBackup(ctx, labels)
+		}
+
+		logger := createLogger()
+		if err := pgbackup.ValidateLabels(labels); err != nil {
+			return configError(err)
+		}
+
 		config, err := pgbackup.LoadConfig()
+		if err != nil {
+			return configError(err)
+		}
+
+		lock, err := pgbackup.AcquireLock(config.LockFilePath)
 		if err != nil {
 			return err
 		}
+		defer lock.Release()
 
-		service := pgbackup.NewBackupService(config)
+		// The database size gives the free space the backup needs
+		db, err := connectDB(config)
+		if err != nil {
+			logger.Warn("Could not connect to PostgreSQL - checking disk space against PG_BACKUP_MIN_FREE_MB",
+				"error", err)
+		}
+		defer func() {
+			if db != nil {
+				db.Close()
+			}
+		}()
+
+		service := pgbackup.NewBackupServiceWithDB(config, db)
 
 		// Check disk space first
 		if err := service.CheckDiskSpace(ctx, logger); err != nil {
-			return fmt.Errorf("disk space check failed: %w", err)
+			return diskError(fmt.Errorf("disk space check failed: %w", err))
 		}
 
-		result, err := service.PerformBaseBackup(ctx, logger)
+		result, err := service.PerformBaseBackup(ctx, logger, labels)
 		if err != nil {
+			if db == nil {
+				// pg_basebackup fails as well when PostgreSQL is unreachable
+				return connectionError(err)
+			}
 			return err
 		}
 
@@ -159,12 +308,34 @@ WAL files are streamed during the backup to ensure consistency.`,
 		fmt.Printf("  Path:        %s\n", result.BackupPath)
 		fmt.Printf("  Size:        %.2f MB\n", float64(result.SizeBytes)/(1024*1024))
 		fmt.Printf("  Duration:    %s\n", result.EndTime.Sub(result.StartTime).Round(time.Second))
+		if len(result.Labels) > 0 {
+			fmt.Printf("  Labels:      %s\n", strings.Join(result.Labels, ", "))
+		}
 		fmt.Println()
 
 		return nil
 	},
 }
 
+// streamBackup runs 'backup --stdout'
+func streamBackup(ctx context.Context, labels []string) error {
+	if len(labels) > 0 {
+		return configError(fmt.Errorf("--label can't be used with --stdout: a streamed backup is not kept in the backup catalog"))
+	}
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return fmt.Errorf("refusing to write a backup to a terminal - redirect or pipe stdout")
+	}
+
+	logger := newLogger(os.Stderr)
+	config, err := pgbackup.LoadConfig()
+	if err != nil {
+		return configError(err)
+	}
+
+	service := pgbackup.NewBackupService(config)
+	return service.StreamBaseBackup(ctx, logger, os.Stdout)
+}
+
 var restoreCmd = &cobra.Command{
 	Use:   "restore [backup-id]",
 	Short: "Restore from a backup",
@@ -174,45 +345,105 @@ IMPORTANT: PostgreSQL must be STOPPED before running restore.
 
 The restore process:
 1. Extracts the base backup to the target directory
-2. Configures recovery parameters (recovery.signal, postgresql.auto.conf)
+2. Configures recovery parameters (recovery.signal and postgresql.auto.conf,
+   or recovery.conf before PostgreSQL 12)
 3. When PostgreSQL starts, it automatically replays WAL files to the target time
 
+With --validate, a throwaway PostgreSQL is started on the restored directory
+(on --validate-port, localhost only, archiving off), recovery is awaited, the
+validation query is run and the instance is stopped again. This needs pg_ctl
+and psql (PATH or --pg-bin-dir) and a free port. Recovery happens in place, so
+afterwards the restored directory is already recovered.
+
+With --stdin, the backup is read from stdin as written by 'backup --stdout'
+instead of from the backup store, and no backup ID is given. No recovery
+configuration is written: PostgreSQL replays the WAL included in the backup
+and starts as a copy of the source, so --target-time can't be used.
+
 Examples:
   pgbackup restore 20260202_100000
   pgbackup restore 20260202_100000 --target-time "2026-02-02 12:00:00"
+  pgbackup restore 20260202_100000 --target-time 2026-02-02T12:00:00+08:00
   pgbackup restore 20260202_100000 --dry-run
-  pgbackup restore 20260202_100000 --target-dir /path/to/new/data`,
-	Args: cobra.ExactArgs(1),
+  pgbackup restore 20260202_100000 --target-dir /path/to/new/data
+  pgbackup restore 20260202_100000 --target-dir /tmp/check --validate --validate-query "SELECT count(*) FROM users"
+  pgbackup restore --stdin --target-dir /data/copy < backup.tar.gz`,
+	Args: configArgs(cobra.MaximumNArgs(1)),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := createLogger()
 		ctx := context.Background()
 
-		config, err := pgbackup.LoadConfig()
-		if err != nil {
-			return err
-		}
-
 		targetTimeStr, _ := cmd.Flags().GetString("target-time")
 		dryRun, _ := cmd.Flags().GetBool("dry-run")
 		targetDir, _ := cmd.Flags().GetString("target-dir")
+		validate, _ := cmd.Flags().GetBool("validate")
+		fromStdin, _ := cmd.Flags().GetBool("stdin")
+
+		if fromStdin {
+			if len(args) > 0 {
+				return configError(fmt.Errorf("--stdin reads the backup from stdin and takes no backup ID"))
+			}
+			if targetTimeStr != "" {
+				return configError(fmt.Errorf("--target-time can't be used with --stdin: a streamed backup is recovered to its end"))
+			}
+		} else if len(args) != 1 {
+			return configError(fmt.Errorf("restore needs a backup ID, or --stdin"))
+		}
+
+		config, err := pgbackup.LoadConfig()
+		if err != nil {
+			return configError(err)
+		}
 
 		opts := pgbackup.RestoreOptions{
-			BackupID:        args[0],
 			TargetDirectory: targetDir,
 			DryRun:          dryRun,
 		}
+		if !fromStdin {
+			opts.BackupID = args[0]
+		}
+
+		if validate && !dryRun {
+			validatePort, _ := cmd.Flags().GetInt("validate-port")
+			validateQuery, _ := cmd.Flags().GetString("validate-query")
+			validateTimeout, _ := cmd.Flags().GetDuration("validate-timeout")
+			binDir, _ := cmd.Flags().GetString("pg-bin-dir")
+			opts.Validate = &pgbackup.ValidateOptions{
+				Port:    validatePort,
+				Query:   validateQuery,
+				Timeout: validateTimeout,
+				BinDir:  binDir,
+			}
+		}
 
 		if targetTimeStr != "" {
-			t, err := time.ParseInLocation("2006-01-02 15:04:05", targetTimeStr, time.Local)
+			// A target time without an offset is in --time-zone
+			zone, _ := cmd.Flags().GetString("time-zone")
+			loc, err := time.LoadLocation(zone)
 			if err != nil {
-				return fmt.Errorf("invalid target-time format (use: 2006-01-02 15:04:05): %w", err)
+				return configError(fmt.Errorf("invalid time-zone %s: %w", zone, err))
+			}
+			t, err := ApiUtils.ParseTimestampInZone(targetTimeStr, loc)
+			if err != nil {
+				return configError(fmt.Errorf("invalid target-time (use RFC3339 or 2006-01-02 15:04:05): %w", err))
 			}
 			opts.TargetTime = &t
 		}
 
 		service := pgbackup.NewBackupService(config)
-		result, err := service.Restore(ctx, logger, opts)
+		var result *pgbackup.RestoreResult
+		if fromStdin {
+			result, err = service.RestoreFromStream(ctx, logger, os.Stdin, opts)
+		} else {
+			result, err = service.Restore(ctx, logger, opts)
+		}
 		if err != nil {
+			if result != nil && result.Validation != nil {
+				printValidation(result.Validation)
+				if !result.Validation.Success {
+					return verificationError(err)
+				}
+			}
 			return err
 		}
 
@@ -228,11 +459,24 @@ Examples:
 			if opts.TargetTime != nil {
 				fmt.Printf("  Target Time: %s\n", opts.TargetTime.Format(time.RFC3339))
 			}
+			if result.Validation != nil {
+				printValidation(result.Validation)
+				fmt.Println()
+				fmt.Println("Recovery is complete; start PostgreSQL to use the restored data.")
+				fmt.Println()
+				return nil
+			}
 			fmt.Println()
 			fmt.Println("Next steps:")
-			fmt.Println("1. Start PostgreSQL")
-			fmt.Println("2. Recovery will happen automatically")
-			fmt.Println("3. PostgreSQL will promote to normal operation when recovery is complete")
+			if fromStdin {
+				fmt.Println("1. Check archive_command of the copy so it doesn't archive into the source's WAL archive")
+				fmt.Println("2. Start PostgreSQL")
+				fmt.Println("3. PostgreSQL replays the WAL included in the backup and starts normally")
+			} else {
+				fmt.Println("1. Start PostgreSQL")
+				fmt.Println("2. Recovery will happen automatically")
+				fmt.Println("3. PostgreSQL will promote to normal operation when recovery is complete")
+			}
 		}
 		fmt.Println()
 
@@ -240,6 +484,304 @@ Examples:
 	},
 }
 
+var dumpTableCmd = &cobra.Command{
+	Use:   "dump-table <schema.table>",
+	Short: "Dump a single table",
+	Long: `Dumps one table of PG_DB_NAME with pg_dump -t (custom format) into
+$PG_BACKUP_DIR/tables, to recover that table alone later with
+'restore-table', e.g. after it was truncated by accident. A name without
+a schema is in schema public.
+
+Table dumps are listed with 'list --tables'. They are kept apart from the
+base backups and are not removed by cleanup.
+
+Examples:
+  pgbackup dump-table public.orders
+  pgbackup dump-table billing.invoices --pg-bin-dir /usr/lib/postgresql/16/bin`,
+	Args: configArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := createLogger()
+		ctx := context.Background()
+
+		config, err := pgbackup.LoadConfig()
+		if err != nil {
+			return configError(err)
+		}
+
+		lock, err := pgbackup.AcquireLock(config.LockFilePath)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+
+		binDir, _ := cmd.Flags().GetString("pg-bin-dir")
+		service := pgbackup.NewBackupService(config)
+		result, err := service.DumpTable(ctx, logger, args[0], binDir)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println()
+		fmt.Println("Table dump completed successfully!")
+		fmt.Printf("  Dump ID:     %s\n", result.DumpID)
+		fmt.Printf("  Table:       %s\n", result.QualifiedName())
+		fmt.Printf("  Path:        %s\n", result.DumpPath)
+		fmt.Printf("  Size:        %.2f MB\n", float64(result.SizeBytes)/(1024*1024))
+		fmt.Println()
+
+		return nil
+	},
+}
+
+var restoreTableCmd = &cobra.Command{
+	Use:   "restore-table <dump-id>",
+	Short: "Restore a single table from a table dump",
+	Long: `Loads a dump made by 'dump-table' into PG_DB_NAME with pg_restore, in a
+single transaction, so a failed restore leaves the table as it was.
+
+By default only the rows are loaded, into the existing table; empty it
+first (e.g. it was truncated), or rows already there may conflict. With
+--clean the table is dropped and recreated from the dump; objects that
+depend on it (foreign keys, views) can make that fail.
+
+With --dry-run the dump is only checked to be loadable (pg_restore reads
+all of it, writing to /dev/null) and nothing is changed. PostgreSQL stays
+running.
+
+Examples:
+  pgbackup restore-table 20260202_100000_public.orders --dry-run
+  pgbackup restore-table 20260202_100000_public.orders
+  pgbackup restore-table 20260202_100000_public.orders --clean`,
+	Args: configArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := createLogger()
+		ctx := context.Background()
+
+		config, err := pgbackup.LoadConfig()
+		if err != nil {
+			return configError(err)
+		}
+
+		lock, err := pgbackup.AcquireLock(config.LockFilePath)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		clean, _ := cmd.Flags().GetBool("clean")
+		binDir, _ := cmd.Flags().GetString("pg-bin-dir")
+
+		service := pgbackup.NewBackupService(config)
+		result, err := service.RestoreTable(ctx, logger, pgbackup.TableRestoreOptions{
+			DumpID: args[0],
+			DryRun: dryRun,
+			Clean:  clean,
+			BinDir: binDir,
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Println()
+		if dryRun {
+			fmt.Println("Dry run completed - dump is loadable")
+			fmt.Printf("  Dump ID:     %s\n", result.DumpID)
+			fmt.Printf("  Table:       %s\n", result.Table)
+			fmt.Println("  Contents:")
+			for _, entry := range result.Entries {
+				fmt.Printf("    %s\n", entry)
+			}
+		} else {
+			fmt.Println("Table restore completed!")
+			fmt.Printf("  Dump ID:     %s\n", result.DumpID)
+			fmt.Printf("  Table:       %s\n", result.Table)
+		}
+		fmt.Println()
+
+		return nil
+	},
+}
+
+var restoreToDBCmd = &cobra.Command{
+	Use:   "restore-to-db <backup-id>",
+	Short: "Restore a database of a backup into a new database",
+	Long: `Loads one database of a base backup into a new database on the running
+server, so data can be looked at or copied back without stopping
+PostgreSQL. The new database (--dbname) must not exist yet.
+
+The backup is extracted into a temporary directory (--work-dir) and
+recovered by a throwaway PostgreSQL (on --port, localhost only, archiving
+off), as 'restore --validate' does. The source database (--source-db,
+default PG_DB_NAME) is then copied with pg_dump and pg_restore. By default
+recovery stops at the end of the backup; with --target-time archived WAL
+is replayed up to that time.
+
+Unlike 'restore', this is a logical copy of one database:
+- roles, tablespaces and other databases are not restored
+- objects are owned by PG_DB_USER; grants are not restored
+- it needs free disk space for the whole cluster plus the dump, and
+  PostgreSQL binaries of the backup's version (PATH or --pg-bin-dir)
+
+If loading fails, the new database is dropped again.
+
+Examples:
+  pgbackup restore-to-db 20260202_100000 --dbname app_20260202
+  pgbackup restore-to-db 20260202_100000 --dbname app_noon --target-time "2026-02-02 12:00:00"
+  pgbackup restore-to-db 20260202_100000 --dbname billing_copy --source-db billing --work-dir /srv/scratch`,
+	Args: configArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := createLogger()
+		ctx := context.Background()
+
+		config, err := pgbackup.LoadConfig()
+		if err != nil {
+			return configError(err)
+		}
+
+		dbName, _ := cmd.Flags().GetString("dbname")
+		if dbName == "" {
+			return configError(fmt.Errorf("--dbname is required"))
+		}
+		sourceDB, _ := cmd.Flags().GetString("source-db")
+		workDir, _ := cmd.Flags().GetString("work-dir")
+		port, _ := cmd.Flags().GetInt("port")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		binDir, _ := cmd.Flags().GetString("pg-bin-dir")
+
+		opts := pgbackup.DBRestoreOptions{
+			BackupID: args[0],
+			DBName:   dbName,
+			SourceDB: sourceDB,
+			WorkDir:  workDir,
+			Port:     port,
+			Timeout:  timeout,
+			BinDir:   binDir,
+		}
+
+		targetTimeStr, _ := cmd.Flags().GetString("target-time")
+		if targetTimeStr != "" {
+			// A target time without an offset is in --time-zone
+			zone, _ := cmd.Flags().GetString("time-zone")
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				return configError(fmt.Errorf("invalid time-zone %s: %w", zone, err))
+			}
+			t, err := ApiUtils.ParseTimestampInZone(targetTimeStr, loc)
+			if err != nil {
+				return configError(fmt.Errorf("invalid target-time (use RFC3339 or 2006-01-02 15:04:05): %w", err))
+			}
+			opts.TargetTime = &t
+		}
+
+		service := pgbackup.NewBackupService(config)
+		result, err := service.RestoreToDB(ctx, logger, opts)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println()
+		fmt.Println("Restore to database completed!")
+		fmt.Printf("  Backup:      %s\n", result.BackupUsed)
+		fmt.Printf("  Source DB:   %s\n", result.SourceDB)
+		fmt.Printf("  New DB:      %s\n", result.DBName)
+		if opts.TargetTime != nil {
+			fmt.Printf("  Target Time: %s\n", opts.TargetTime.Format(time.RFC3339))
+		}
+		fmt.Printf("  Recovery:    %s\n", result.RecoveryDuration.Round(time.Second))
+		fmt.Println()
+
+		return nil
+	},
+}
+
+var testRestoreCmd = &cobra.Command{
+	Use:   "test-restore <backup-id>",
+	Short: "Check that a backup restores, on a disposable PostgreSQL",
+	Long: `Restores a backup end-to-end on a disposable PostgreSQL and runs a smoke
+query, to check that the backup is usable. Nothing is restored into the
+running server or PGDATA.
+
+The backup is extracted into a temporary directory (--work-dir) and
+recovered to the end of the backup, replaying archived WAL. The disposable
+PostgreSQL is started with the local binaries (PATH or --pg-bin-dir, on
+--port, localhost only, archiving off), or with --image in a container
+(--runtime docker or podman, no network). Once recovery has finished the
+smoke query (--query, default PG_BACKUP_VALIDATE_QUERY) is run; with
+--expect its output must match exactly. The instance or container and
+the temporary directory are removed afterwards.
+
+The command exits non-zero if any step fails, so CI can gate on it.
+
+Examples:
+  pgbackup test-restore 20260202_020000
+  pgbackup test-restore 20260202_020000 --query "SELECT count(*) > 0 FROM users" --expect t
+  pgbackup test-restore 20260202_020000 --image postgres:16 --runtime podman`,
+	Args: configArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := createLogger()
+		ctx := context.Background()
+
+		config, err := pgbackup.LoadConfig()
+		if err != nil {
+			return configError(err)
+		}
+
+		query, _ := cmd.Flags().GetString("query")
+		expect, _ := cmd.Flags().GetString("expect")
+		workDir, _ := cmd.Flags().GetString("work-dir")
+		port, _ := cmd.Flags().GetInt("port")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		binDir, _ := cmd.Flags().GetString("pg-bin-dir")
+		image, _ := cmd.Flags().GetString("image")
+		runtime, _ := cmd.Flags().GetString("runtime")
+		if image != "" && binDir != "" {
+			return configError(fmt.Errorf("--image and --pg-bin-dir cannot be combined"))
+		}
+
+		service := pgbackup.NewBackupService(config)
+		result, err := service.TestRestore(ctx, logger, pgbackup.TestRestoreOptions{
+			BackupID: args[0],
+			Query:    query,
+			Expect:   expect,
+			WorkDir:  workDir,
+			Port:     port,
+			Timeout:  timeout,
+			BinDir:   binDir,
+			Image:    image,
+			Runtime:  runtime,
+		})
+
+		fmt.Println()
+		if result.Success {
+			fmt.Println("Restore test: PASSED")
+		} else {
+			fmt.Println("Restore test: FAILED")
+		}
+		fmt.Printf("  Backup:            %s\n", result.BackupUsed)
+		if result.PGVersion != "" {
+			fmt.Printf("  PostgreSQL:        %s\n", result.PGVersion)
+		}
+		if result.Image != "" {
+			fmt.Printf("  Image:             %s\n", result.Image)
+		}
+		if result.RecoveryDuration > 0 {
+			fmt.Printf("  Recovery Duration: %s\n", result.RecoveryDuration.Round(time.Second))
+		}
+		fmt.Printf("  Query:             %s\n", result.Query)
+		if result.QueryResult != "" {
+			fmt.Printf("  Result:            %s\n", result.QueryResult)
+		}
+		if result.ErrorMsg != "" {
+			fmt.Printf("  Error:             %s\n", result.ErrorMsg)
+		}
+		fmt.Printf("  Duration:          %s\n", result.Duration.Round(time.Second))
+		fmt.Println()
+
+		return verificationError(err)
+	},
+}
+
 var verifyCmd = &cobra.Command{
 	Use:   "verify [backup-id]",
 	Short: "Verify backup integrity",
@@ -250,15 +792,21 @@ Checks:
 - Presence of required files (base.tar.gz)
 - WAL archive status
 
-If no backup-id is specified, verifies the latest backup.`,
-	Args: cobra.MaximumNArgs(1),
+If no backup-id is specified, verifies the latest backup. With --label,
+verifies the latest backup with that label, or with --all every backup
+with that label.
+
+The result is recorded with the backup and shown by 'pgbackup list'. With
+--all --only-unverified, only backups that are not VERIFIED (never
+verified, failed or stale) are checked.`,
+	Args: configArgs(cobra.MaximumNArgs(1)),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := createLogger()
 		ctx := context.Background()
 
 		config, err := pgbackup.LoadConfig()
 		if err != nil {
-			return err
+			return configError(err)
 		}
 
 		service := pgbackup.NewBackupService(config)
@@ -269,12 +817,32 @@ If no backup-id is specified, verifies the latest backup.`,
 		}
 
 		all, _ := cmd.Flags().GetBool("all")
+		label, _ := cmd.Flags().GetString("label")
+		onlyUnverified, _ := cmd.Flags().GetBool("only-unverified")
+		if label != "" && backupID != "" {
+			return configError(fmt.Errorf("--label cannot be used with a backup-id"))
+		}
+		if onlyUnverified && !all {
+			return configError(fmt.Errorf("--only-unverified requires --all"))
+		}
 
 		if all {
-			results, err := service.VerifyAll(ctx, logger)
+			results, err := service.VerifyAllWithOptions(ctx, logger, pgbackup.VerifyOptions{
+				Label:          label,
+				OnlyUnverified: onlyUnverified,
+			})
 			if err != nil {
 				return err
 			}
+			if len(results) == 0 && onlyUnverified {
+				fmt.Println()
+				fmt.Println("All backups are verified.")
+				fmt.Println()
+				return nil
+			}
+			if len(results) == 0 && label != "" {
+				return fmt.Errorf("no backups found with label %q", label)
+			}
 
 			fmt.Println()
 			fmt.Println("Verification Results:")
@@ -292,9 +860,17 @@ If no backup-id is specified, verifies the latest backup.`,
 			}
 
 			if !allOK {
-				return fmt.Errorf("some backups failed verification")
+				return verificationError(fmt.Errorf("some backups failed verification"))
 			}
 		} else {
+			if label != "" {
+				latest, err := service.LatestBackup(label)
+				if err != nil {
+					return err
+				}
+				backupID = latest.BackupID
+			}
+
 			result, err := service.Verify(ctx, logger, backupID)
 			if err != nil {
 				return err
@@ -308,7 +884,7 @@ If no backup-id is specified, verifies the latest backup.`,
 				for _, issue := range result.Issues {
 					fmt.Printf("  - %s\n", issue)
 				}
-				return fmt.Errorf("backup verification failed")
+				return verificationError(fmt.Errorf("backup verification failed"))
 			}
 		}
 
@@ -325,38 +901,69 @@ var cleanupCmd = &cobra.Command{
 Retention rules:
 - Keep at least PG_BACKUP_RETAIN_COUNT backups (default: 3)
 - Delete backups older than PG_BACKUP_RETAIN_DAYS (default: 7 days)
-- Clean WAL files no longer needed for recovery`,
+- Keep labeled backups if PG_BACKUP_RETAIN_LABELED is true
+- Delete WAL files older than the start WAL of the oldest retained backup
+  and older than PG_BACKUP_RETAIN_WAL_DAYS (as pg_archivecleanup would)
+
+With --dry-run nothing is deleted; the backups and WAL files that would be
+are listed.
+
+Examples:
+  pgbackup cleanup
+  pgbackup cleanup --dry-run`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := createLogger()
 		ctx := context.Background()
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
 
 		config, err := pgbackup.LoadConfig()
+		if err != nil {
+			return configError(err)
+		}
+
+		lock, err := pgbackup.AcquireLock(config.LockFilePath)
 		if err != nil {
 			return err
 		}
+		defer lock.Release()
 
 		service := pgbackup.NewBackupService(config)
-		result, err := service.ApplyRetention(ctx, logger)
+		result, err := service.ApplyRetentionWithOptions(ctx, logger, pgbackup.RetentionOptions{DryRun: dryRun})
 		if err != nil {
 			return err
 		}
 
+		verb := "Deleted"
 		fmt.Println()
-		fmt.Println("Cleanup completed!")
-		fmt.Printf("  Deleted backups:    %d\n", len(result.DeletedBackups))
+		if dryRun {
+			verb = "Would delete"
+			fmt.Println("Cleanup dry run (nothing deleted)")
+		} else {
+			fmt.Println("Cleanup completed!")
+		}
+		fmt.Printf("  %s backups:    %d\n", verb, len(result.DeletedBackups))
 		fmt.Printf("  Retained backups:   %d\n", len(result.RetainedBackups))
-		fmt.Printf("  Deleted WAL files:  %d\n", result.DeletedWALFiles)
+		fmt.Printf("  Pinned backups:     %d\n", len(result.PinnedBackups))
+		fmt.Printf("  %s WAL files:  %d\n", verb, result.DeletedWALFiles)
 		fmt.Printf("  Freed space:        %.2f MB\n", float64(result.FreedSpaceBytes)/(1024*1024))
 		fmt.Println()
 
 		if len(result.DeletedBackups) > 0 {
-			fmt.Println("Deleted backups:")
+			fmt.Printf("%s backups:\n", verb)
 			for _, id := range result.DeletedBackups {
 				fmt.Printf("  - %s\n", id)
 			}
 			fmt.Println()
 		}
 
+		if dryRun && len(result.WALFiles) > 0 {
+			fmt.Printf("%s WAL files:\n", verb)
+			for _, name := range result.WALFiles {
+				fmt.Printf("  - %s\n", name)
+			}
+			fmt.Println()
+		}
+
 		return nil
 	},
 }
@@ -371,7 +978,7 @@ var statusCmd = &cobra.Command{
 
 		config, err := pgbackup.LoadConfig()
 		if err != nil {
-			return err
+			return configError(err)
 		}
 
 		// Try to connect to get PG config info
@@ -393,21 +1000,42 @@ var statusCmd = &cobra.Command{
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all available backups",
-	Long:  `Lists all available backups with their IDs, timestamps, and sizes.`,
+	Long: `Lists all available backups with their IDs, timestamps, sizes and labels.
+
+The VERIFIED column shows the result of the last 'pgbackup verify' of each
+backup: VERIFIED, FAILED, STALE (verified more than
+PG_BACKUP_VERIFY_MAX_AGE_DAYS ago, default: 7) or UNVERIFIED.
+
+Use --label to list only the backups with that label, or --tables to list
+the table dumps made by 'dump-table' instead.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := createLogger()
+		label, _ := cmd.Flags().GetString("label")
+		tables, _ := cmd.Flags().GetBool("tables")
 
 		config, err := pgbackup.LoadConfig()
 		if err != nil {
-			return err
+			return configError(err)
 		}
 
 		service := pgbackup.NewBackupService(config)
+		if tables {
+			if label != "" {
+				return configError(fmt.Errorf("--label cannot be used with --tables"))
+			}
+			return printTableDumps(service)
+		}
+
 		backups, err := service.ListBackups()
 		if err != nil {
 			return err
 		}
+		backups = pgbackup.FilterByLabel(backups, label)
 
+		if len(backups) == 0 && label != "" {
+			fmt.Printf("No backups found with label %q.\n", label)
+			return nil
+		}
 		if len(backups) == 0 {
 			fmt.Println("No backups found.")
 			fmt.Println()
@@ -418,19 +1046,22 @@ var listCmd = &cobra.Command{
 		fmt.Println()
 		fmt.Println("Available Backups:")
 		fmt.Println()
-		fmt.Printf("%-20s %-25s %12s  %s\n", "BACKUP ID", "TIMESTAMP", "SIZE", "STATUS")
-		fmt.Printf("%-20s %-25s %12s  %s\n", "---------", "---------", "----", "------")
+		fmt.Printf("%-20s %-25s %12s  %-7s %-10s %s\n", "BACKUP ID", "TIMESTAMP", "SIZE", "STATUS", "VERIFIED", "LABELS")
+		fmt.Printf("%-20s %-25s %12s  %-7s %-10s %s\n", "---------", "---------", "----", "------", "--------", "------")
 
+		now := time.Now()
 		for _, b := range backups {
 			status := "OK"
 			if !b.Success {
 				status = "FAILED"
 			}
-			fmt.Printf("%-20s %-25s %10.2f MB  %s\n",
+			fmt.Printf("%-20s %-25s %10.2f MB  %-7s %-10s %s\n",
 				b.BackupID,
 				b.StartTime.Format("2006-01-02 15:04:05 MST"),
 				float64(b.SizeBytes)/(1024*1024),
-				status)
+				status,
+				service.VerificationStatus(b, now),
+				strings.Join(b.Labels, ","))
 		}
 
 		fmt.Println()
@@ -440,6 +1071,72 @@ var listCmd = &cobra.Command{
 	},
 }
 
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show base backup duration and size trends",
+	Long: `Shows the duration and size of the last base backups from the backup
+history ($PG_BACKUP_DIR/backup_history.jsonl), which is kept when
+retention deletes the backups.
+
+The VS MEDIAN column compares each backup with the median duration of the
+10 backups before it. A backup taking more than
+PG_BACKUP_DURATION_ALERT_FACTOR (default: 2) times that median is logged
+as a warning, and posted to PG_BACKUP_ALERT_WEBHOOK_URL if it is set. The
+trend compares the median of the last 10 backups with the 10 before them.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		last, _ := cmd.Flags().GetInt("last")
+
+		config, err := pgbackup.LoadConfig()
+		if err != nil {
+			return configError(err)
+		}
+
+		runs, err := pgbackup.NewBackupService(config).ReadBackupHistory()
+		if err != nil {
+			return err
+		}
+		if len(runs) == 0 {
+			fmt.Println("No backup history yet.")
+			fmt.Println()
+			fmt.Println("Each successful 'pgbackup backup' is added to the history.")
+			return nil
+		}
+
+		stats := pgbackup.ComputeBackupStats(runs, last)
+		fmt.Println()
+		fmt.Println("Base Backup History:")
+		fmt.Println()
+		fmt.Printf("%-20s %-25s %10s %12s  %s\n", "BACKUP ID", "TIMESTAMP", "DURATION", "SIZE", "VS MEDIAN")
+		fmt.Printf("%-20s %-25s %10s %12s  %s\n", "---------", "---------", "--------", "----", "---------")
+		for i, run := range stats.Runs {
+			vs := "-"
+			if factor := stats.DurationFactor[i]; factor > 0 {
+				vs = fmt.Sprintf("%.1fx", factor)
+				if config.DurationAlertFactor > 0 && factor > config.DurationAlertFactor {
+					vs += " SLOW"
+				}
+			}
+			fmt.Printf("%-20s %-25s %10s %9.2f MB  %s\n",
+				run.BackupID,
+				run.StartTime.Format("2006-01-02 15:04:05 MST"),
+				run.Duration().Round(time.Second),
+				float64(run.SizeBytes)/(1024*1024),
+				vs)
+		}
+
+		fmt.Println()
+		fmt.Printf("Backups in history: %d\n", stats.TotalRuns)
+		fmt.Printf("Median duration:    %s\n", stats.MedianDuration.Round(time.Second))
+		fmt.Printf("Median size:        %.2f MB\n", float64(stats.MedianSize)/(1024*1024))
+		if stats.DurationTrend > 0 {
+			fmt.Printf("Duration trend:     %+.0f%%\n", (stats.DurationTrend-1)*100)
+			fmt.Printf("Size trend:         %+.0f%%\n", (stats.SizeTrend-1)*100)
+		}
+		fmt.Println()
+		return nil
+	},
+}
+
 var syncCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "Sync all backups to remote host",
@@ -457,13 +1154,19 @@ Requires SSH key-based authentication to the remote host.`,
 
 		config, err := pgbackup.LoadConfig()
 		if err != nil {
-			return err
+			return configError(err)
 		}
 
 		if !config.RemoteEnabled() {
-			return fmt.Errorf("remote sync not configured: set PG_BACKUP_REMOTE_HOST environment variable")
+			return configError(fmt.Errorf("remote sync not configured: set PG_BACKUP_REMOTE_HOST environment variable"))
 		}
 
+		lock, err := pgbackup.AcquireLock(config.LockFilePath)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+
 		service := pgbackup.NewBackupService(config)
 		result, err := service.SyncAll(ctx, logger)
 		if err != nil {
@@ -488,27 +1191,159 @@ Requires SSH key-based authentication to the remote host.`,
 	},
 }
 
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run backups, cleanups and syncs on a schedule",
+	Long: `Runs in the foreground and performs backups, cleanups and remote syncs
+on their cron schedules, for hosts without cron or launchd jobs. Use
+nohup, tmux or systemd to run it in the background.
+
+Schedules (minute hour day month weekday, or @hourly/@daily/@weekly/@monthly;
+"off" disables a job):
+  PG_BACKUP_SCHEDULE          Backups (default: "0 2 * * *")
+  PG_BACKUP_CLEANUP_SCHEDULE  Cleanups (default: "0 3 * * 0")
+  PG_BACKUP_SYNC_SCHEDULE     Remote syncs (default: "0 * * * *", needs PG_BACKUP_REMOTE_HOST)
+
+Schedules are in the local time zone. Jobs run one at a time and take the
+lock file in PG_BACKUP_DIR, as do the backup, cleanup and sync commands, so
+a job due while another runs is deferred until it finishes. The result of
+each run is logged.
+
+The daemon writes $PG_BACKUP_DIR/.pgbackup.pid. On SIGTERM or SIGINT it
+lets a running job finish and exits.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := createLogger()
+
+		config, err := pgbackup.LoadConfig()
+		if err != nil {
+			return configError(err)
+		}
+
+		// Check if already running
+		if pid, err := pgbackup.ReadPIDFile(config.PIDFilePath); err == nil {
+			if pgbackup.IsRunning(pid) {
+				return withExitCode(ExitLocked, fmt.Errorf("pgbackup daemon is already running (PID %d)", pid))
+			}
+			// Stale PID file, clean up
+			pgbackup.RemovePIDFile(config.PIDFilePath)
+		}
+
+		// Used to size the disk space check of backups. It connects when
+		// used, so the database needn't be up when the daemon starts.
+		db, err := openDB(config)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		daemon, err := pgbackup.NewDaemon(pgbackup.NewBackupServiceWithDB(config, db), logger)
+		if err != nil {
+			// An invalid schedule
+			return configError(err)
+		}
+
+		if err := pgbackup.WritePIDFile(config.PIDFilePath); err != nil {
+			return err
+		}
+		defer pgbackup.RemovePIDFile(config.PIDFilePath)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+		go func() {
+			sig := <-sigCh
+			logger.Info("Received signal, shutting down", "signal", sig)
+			cancel()
+		}()
+
+		logger.Info("pgbackup daemon started", "pid", os.Getpid())
+		return daemon.Run(ctx)
+	},
+}
+
 func init() {
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return configError(err)
+	})
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "TOML or YAML config file (default: PGBACKUP_CONFIG)")
+	// LoadConfig reads the file from PGBACKUP_CONFIG, which --config
+	// overrides; the daemon's child processes inherit it
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if configPath != "" {
+			os.Setenv("PGBACKUP_CONFIG", configPath)
+		}
+	}
 
-	restoreCmd.Flags().String("target-time", "", "Point-in-time recovery target (format: 2006-01-02 15:04:05)")
+	restoreCmd.Flags().String("target-time", "", "Point-in-time recovery target (RFC3339, or 2006-01-02 15:04:05 in --time-zone)")
+	restoreCmd.Flags().String("time-zone", "Local", "Zone of a --target-time without an offset")
 	restoreCmd.Flags().String("target-dir", "", "Target directory for restore (defaults to PGDATA)")
 	restoreCmd.Flags().Bool("dry-run", false, "Validate restore without executing")
+	restoreCmd.Flags().Bool("validate", false, "Start a throwaway PostgreSQL on the restored directory and run a validation query")
+	restoreCmd.Flags().Int("validate-port", 0, "Port for the validation instance (default: PG_BACKUP_VALIDATE_PORT or 54329)")
+	restoreCmd.Flags().String("validate-query", "", "Validation query (default: PG_BACKUP_VALIDATE_QUERY)")
+	restoreCmd.Flags().Duration("validate-timeout", 30*time.Minute, "How long to wait for recovery to finish")
+	restoreCmd.Flags().String("pg-bin-dir", "", "Directory of pg_ctl and psql (default: PATH)")
+	restoreCmd.Flags().Bool("stdin", false, "Read a backup written by 'backup --stdout' from stdin")
+
+	backupCmd.Flags().StringSlice("label", nil, "Label to store with the backup (repeatable)")
+	backupCmd.Flags().Bool("stdout", false, "Write the backup as a gzipped tar to stdout instead of PG_BACKUP_DIR")
 
 	verifyCmd.Flags().Bool("all", false, "Verify all backups")
+	verifyCmd.Flags().String("label", "", "Only verify backups with this label")
+	verifyCmd.Flags().Bool("only-unverified", false, "With --all, skip backups that are already VERIFIED")
+
+	listCmd.Flags().String("label", "", "Only list backups with this label")
+	listCmd.Flags().Bool("tables", false, "List table dumps instead of base backups")
+
+	statsCmd.Flags().Int("last", 20, "Number of recent backups to list (0: all)")
+
+	dumpTableCmd.Flags().String("pg-bin-dir", "", "Directory of pg_dump and pg_restore (default: PATH)")
+
+	restoreTableCmd.Flags().Bool("dry-run", false, "Only check that the dump is loadable")
+	restoreTableCmd.Flags().Bool("clean", false, "Drop and recreate the table instead of loading rows into it")
+	restoreTableCmd.Flags().String("pg-bin-dir", "", "Directory of pg_dump and pg_restore (default: PATH)")
+
+	cleanupCmd.Flags().Bool("dry-run", false, "Only list the backups and WAL files that would be deleted")
+
+	restoreToDBCmd.Flags().String("dbname", "", "New database to restore into (must not exist)")
+	restoreToDBCmd.Flags().String("source-db", "", "Database of the backup to restore (default: PG_DB_NAME)")
+	restoreToDBCmd.Flags().String("target-time", "", "Replay archived WAL up to this time (RFC3339, or 2006-01-02 15:04:05 in --time-zone)")
+	restoreToDBCmd.Flags().String("time-zone", "Local", "Zone of a --target-time without an offset")
+	restoreToDBCmd.Flags().String("work-dir", "", "Directory to extract the backup in (default: system temp directory)")
+	restoreToDBCmd.Flags().Int("port", 0, "Port for the throwaway instance (default: PG_BACKUP_VALIDATE_PORT or 54329)")
+	restoreToDBCmd.Flags().Duration("timeout", 30*time.Minute, "How long to wait for recovery to finish")
+	restoreToDBCmd.Flags().String("pg-bin-dir", "", "Directory of pg_ctl, psql, pg_dump and pg_restore (default: PATH)")
+
+	testRestoreCmd.Flags().String("query", "", "Smoke query (default: PG_BACKUP_VALIDATE_QUERY)")
+	testRestoreCmd.Flags().String("expect", "", "Output the smoke query must return (default: any)")
+	testRestoreCmd.Flags().String("work-dir", "", "Directory to extract the backup in (default: system temp directory)")
+	testRestoreCmd.Flags().Int("port", 0, "Port for the throwaway instance (default: PG_BACKUP_VALIDATE_PORT or 54329)")
+	testRestoreCmd.Flags().Duration("timeout", 30*time.Minute, "How long to wait for recovery to finish")
+	testRestoreCmd.Flags().String("pg-bin-dir", "", "Directory of pg_ctl and psql (default: PATH)")
+	testRestoreCmd.Flags().String("image", "", "Run PostgreSQL in a container of this image (e.g. postgres:16) instead of the local binaries")
+	testRestoreCmd.Flags().String("runtime", "docker", "Container runtime for --image (docker or podman)")
 
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(backupCmd)
 	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(dumpTableCmd)
+	rootCmd.AddCommand(restoreTableCmd)
+	rootCmd.AddCommand(restoreToDBCmd)
+	rootCmd.AddCommand(testRestoreCmd)
 	rootCmd.AddCommand(verifyCmd)
 	rootCmd.AddCommand(cleanupCmd)
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(statsCmd)
 	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(daemonCmd)
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,222 @@
+// shared-admin is a CLI tool for administering the shared system tables.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chendingplano/shared/go/api/ApiTypes"
+	"github.com/chendingplano/shared/go/api/ApiUtils"
+	"github.com/chendingplano/shared/go/api/EchoFactory"
+	"github.com/chendingplano/shared/go/api/sysdatastores"
+	_ "github.com/lib/pq"
+	"github.com/spf13/cobra"
+)
+
+// connectDB connects to the shared PostgreSQL database and makes it the
+// database the sysdatastores functions use.
+func connectDB() (*sql.DB, error) {
+	host := os.Getenv("PG_HOST")
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	port := os.Getenv("PG_PORT")
+	if port == "" {
+		port = "5432"
+	}
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		host, port, os.Getenv("PG_USER_NAME"), os.Getenv("PG_PASSWORD"), os.Getenv("PG_DB_NAME"))
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	ApiUtils.LoadLibConfig("SHD_ADM_048")
+	ApiTypes.SharedDBHandle = db
+	ApiTypes.DBType = ApiTypes.PgName
+	return db, nil
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "shared-admin",
+	Short: "Administer the shared system tables",
+	Long: `shared-admin exports and imports the shared system tables
+(users, resources, icons, login sessions) as portable JSON bundles, and
+checks them for timestamp columns without a time zone.
+
+Environment variables:
+  PG_USER_NAME              PostgreSQL username
+  PG_PASSWORD               PostgreSQL password
+  PG_DB_NAME                PostgreSQL database name
+  PG_HOST                   PostgreSQL host (default: 127.0.0.1)
+  PG_PORT                   PostgreSQL port (default: 5432)
+  SHARED_LIB_CONFIG_DIR     Path of libconfig.toml (system table names)
+`,
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export system tables to a JSON bundle",
+	Long: `Writes the records of the system tables to a JSON bundle.
+Password and token columns, and the login sessions table, are left out
+unless --include-secrets is given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tables, _ := cmd.Flags().GetStringSlice("tables")
+		out, _ := cmd.Flags().GetString("out")
+		include_secrets, _ := cmd.Flags().GetBool("include-secrets")
+
+		db, err := connectDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		w := os.Stdout
+		if out != "" && out != "-" {
+			f, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", out, err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		rc := EchoFactory.NewRCAsAdmin("SHD_ADM_093")
+		defer rc.Close()
+		return sysdatastores.ExportSystemData(rc, tables, w,
+			sysdatastores.SystemDataExportOptions{IncludeSecrets: include_secrets})
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <bundle.json>",
+	Short: "Import a JSON bundle into the system tables",
+	Long: `Imports a bundle written by 'shared-admin export'.
+
+  --mode merge     insert new records and update existing ones, matched
+                   by natural key (users: email; resources: name and opr;
+                   icons: category and name; login sessions: session id)
+  --mode replace   empty each table in the bundle, then insert its records
+
+All tables are imported in one transaction. The bundle must come from a
+server at the same system schema version.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mode, _ := cmd.Flags().GetString("mode")
+		include_secrets, _ := cmd.Flags().GetBool("include-secrets")
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", args[0], err)
+		}
+		defer f.Close()
+
+		db, err := connectDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		rc := EchoFactory.NewRCAsAdmin("SHD_ADM_127")
+		defer rc.Close()
+		counts, err := sysdatastores.ImportSystemData(rc, f, sysdatastores.SystemDataImportOptions{
+			Mode:           mode,
+			IncludeSecrets: include_secrets,
+		})
+		if err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %-16s %d records\n", name, counts[name])
+		}
+		return nil
+	},
+}
+
+var checkTimestampsCmd = &cobra.Command{
+	Use:   "check-timestamps",
+	Short: "List timestamp columns without a time zone",
+	Long: `Lists the TIMESTAMP WITHOUT TIME ZONE columns of the system tables
+(or of --tables). The Jimo handlers read such columns as UTC wall clocks,
+but CURRENT_TIMESTAMP fills them with the wall clock of the server's
+session zone. For each column the statement that converts it to
+TIMESTAMP WITH TIME ZONE is printed, reading the stored values in --zone.
+Nothing is changed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tables, _ := cmd.Flags().GetStringSlice("tables")
+		zone, _ := cmd.Flags().GetString("zone")
+		if _, err := time.LoadLocation(zone); err != nil {
+			return fmt.Errorf("invalid zone %s: %w", zone, err)
+		}
+
+		db, err := connectDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		columns, err := sysdatastores.FindNaiveTimestampColumns(ctx, db, ApiTypes.DBType, tables)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			fmt.Println("No timestamp columns without a time zone")
+			return nil
+		}
+
+		for _, c := range columns {
+			fmt.Printf("  %-32s %s\n", c.TableName+"."+c.ColumnName, c.DataType)
+		}
+		fmt.Println()
+		for _, c := range columns {
+			if stmt := c.MigrationSQL(ApiTypes.DBType, zone); stmt != "" {
+				fmt.Println(stmt)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringSlice("tables", nil,
+		"Tables to export (default: "+strings.Join(sysdatastores.SystemDataTables, ",")+")")
+	exportCmd.Flags().StringP("out", "o", "", "Output file (default: stdout)")
+	exportCmd.Flags().Bool("include-secrets", false, "Export password and token columns and login sessions")
+
+	importCmd.Flags().String("mode", sysdatastores.ImportModeMerge, "Import mode: merge or replace")
+	importCmd.Flags().Bool("include-secrets", false, "Import password and token columns and login sessions")
+
+	checkTimestampsCmd.Flags().StringSlice("tables", nil, "Tables to check (default: the system tables)")
+	checkTimestampsCmd.Flags().String("zone", "UTC", "Zone the stored wall clocks are in (the server's TimeZone)")
+
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(checkTimestampsCmd)
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -70,6 +70,10 @@ Environment variables:
 `,
 }
 
+var (
+	startMetricsAddr string
+)
+
 var startCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Start the sync daemon",
@@ -80,7 +84,10 @@ The daemon will:
 2. Connect to the remote archive via SFTP
 3. Poll for new change files at the configured frequency
 4. Apply changes to whitelisted tables
-5. Log results to data_sync_logs table`,
+5. Log results to data_sync_logs table
+
+With --metrics-addr the daemon also serves Prometheus metrics at /metrics
+on that address (see serve-metrics).`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := createLogger()
 		ctx, cancel := context.WithCancel(context.Background())
@@ -124,8 +131,20 @@ The daemon will:
 		fmt.Printf("  PID file: %s\n", config.PIDFilePath)
 		fmt.Printf("  Archive: %s\n", config.SSHAddress())
 		fmt.Printf("  Frequency: %d seconds\n", config.DataSyncFreq)
+		if startMetricsAddr != "" {
+			fmt.Printf("  Metrics: http://%s/metrics\n", startMetricsAddr)
+		}
 		fmt.Println()
 
+		// The metrics listener is best-effort: if it fails, syncing goes on
+		if startMetricsAddr != "" {
+			go func() {
+				if err := tablesyncher.ServeMetrics(ctx, startMetricsAddr, service.MetricsHandler(), logger); err != nil {
+					logger.Error("Metrics listener stopped", "error", err)
+				}
+			}()
+		}
+
 		// Run the sync loop
 		return service.RunLoop(ctx)
 	},
@@ -171,7 +190,10 @@ var stopCmd = &cobra.Command{
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show daemon status",
-	Long:  `Shows the current status of the sync daemon including uptime, sync stats, and errors.`,
+	Long: `Shows the current status of the sync daemon including uptime, sync stats, and errors.
+
+Works without a database connection: the daemon saves its runtime state
+(last cycle, errors, per-table lag) in the state file after every cycle.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := context.Background()
 
@@ -183,7 +205,7 @@ var statusCmd = &cobra.Command{
 		// Try to connect to database for additional info
 		db, dbErr := connectDB(config)
 		if dbErr != nil {
-			// Continue without DB - we can still show basic status
+			// Continue without DB - the state file has the daemon's runtime state
 			db = nil
 		}
 		defer func() {
@@ -202,6 +224,40 @@ var statusCmd = &cobra.Command{
 	},
 }
 
+var (
+	metricsAddr string
+)
+
+var serveMetricsCmd = &cobra.Command{
+	Use:   "serve-metrics",
+	Short: "Serve the daemon's metrics to Prometheus",
+	Long: `Serves Prometheus metrics at /metrics, read from the runtime state the
+daemon saves in the state file after every cycle, so it runs alongside the
+daemon (or 'start --metrics-addr' serves them from the daemon itself):
+
+  syncdata_records_applied_total{table,op}  records applied since the daemon started
+  syncdata_lag_seconds{table}               age of the newest change applied
+  syncdata_cycle_duration_seconds           duration of the last sync cycle
+  syncdata_errors_total                     errors since the daemon started
+
+Does not need a database connection.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := createLogger()
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		defer cancel()
+
+		config, err := tablesyncher.LoadConfig()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Serving metrics at http://%s/metrics\n", metricsAddr)
+		fmt.Printf("  State file: %s\n", config.StateFilePath)
+		return tablesyncher.ServeMetrics(ctx, metricsAddr,
+			tablesyncher.StateFileMetricsHandler(config.StateFilePath, logger), logger)
+	},
+}
+
 var clearCmd = &cobra.Command{
 	Use:   "clear",
 	Short: "Clear all synced tables",
@@ -295,12 +351,166 @@ This will:
 	},
 }
 
+var (
+	rangeFrom string
+	rangeTo   string
+)
+
+var syncRangeCmd = &cobra.Command{
+	Use:   "sync-range <table_name> --from <ts|lsn> --to <ts|lsn>",
+	Short: "Sync a bounded time or LSN range of a table",
+	Long: `Applies only the archived changes of a table that fall in the given range,
+e.g. to backfill a single day. The table is not truncated and the normal
+sync checkpoint is left untouched.
+
+--from and --to are both timestamps (RFC 3339, "2006-01-02 15:04:05" or
+"2006-01-02", UTC unless a zone is given) or both LSNs ("16/B374D848").
+Both ends are inclusive; a date-only --to covers the whole day.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := createLogger()
+		ctx := context.Background()
+
+		tableName := args[0]
+		rng, err := tablesyncher.NewSyncRange(rangeFrom, rangeTo)
+		if err != nil {
+			return err
+		}
+
+		config, err := tablesyncher.LoadConfig()
+		if err != nil {
+			return err
+		}
+
+		db, err := connectDB(config)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		service := tablesyncher.NewServiceWithDB(config, db, logger)
+		if err := service.Initialize(ctx); err != nil {
+			return err
+		}
+
+		fmt.Printf("Syncing table %s, range %s\n", tableName, rng.String())
+
+		result, err := service.SyncRange(ctx, tableName, rng)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println()
+		fmt.Println("Range sync complete!")
+		fmt.Printf("  Files scanned: %d\n", result.FilesScanned)
+		fmt.Printf("  Files applied: %d\n", result.FilesProcessed)
+		fmt.Printf("  Added: %d\n", result.RecordsAdded)
+		fmt.Printf("  Updated: %d\n", result.RecordsUpdated)
+		fmt.Printf("  Deleted: %d\n", result.RecordsDeleted)
+		fmt.Printf("  Failed: %d\n", result.RecordsFailed)
+		fmt.Printf("  Out of range: %d\n", result.RecordsOutOfRange)
+		if result.PredatesArchive {
+			fmt.Println()
+			if result.OldestAvailable == "" {
+				fmt.Println("WARNING: the archive has no change files")
+			} else {
+				fmt.Printf("WARNING: the range starts before the oldest archived change (%s);\n", result.OldestAvailable)
+				fmt.Println("         earlier changes are not available and were not synced")
+			}
+		}
+		fmt.Println()
+
+		return nil
+	},
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <table_name>",
+	Short: "Compare a synced table against the source checksum",
+	Long: `Computes the row count and checksum of a local table and compares them with
+the latest checksum of the source table recorded in the change files already
+applied (see archive_changes.sh --checksum-tables). Exits with status 2 on a
+mismatch.
+
+Changes applied after that checksum make the comparison inexact; they are
+reported, and a mismatch should then be checked again after the next checksum.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := createLogger()
+		ctx := context.Background()
+
+		config, err := tablesyncher.LoadConfig()
+		if err != nil {
+			return err
+		}
+
+		db, err := connectDB(config)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		service := tablesyncher.NewServiceWithDB(config, db, logger)
+		if err := service.Initialize(ctx); err != nil {
+			return err
+		}
+
+		result, err := service.VerifyTable(ctx, args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Table %s\n", result.Table)
+		fmt.Printf("  Checksum from: %s (lsn %s, %s)\n",
+			result.ArchiveFile, result.ArchiveLSN, result.ArchiveTS.Format(time.RFC3339))
+		fmt.Printf("  Source: %d rows, md5 %s\n", result.Source.RowCount, result.Source.MD5)
+		fmt.Printf("  Local:  %d rows, md5 %s\n", result.Local.RowCount, result.Local.MD5)
+		if result.ChangesSince > 0 {
+			fmt.Printf("  Changes applied since the checksum: %d\n", result.ChangesSince)
+		}
+		fmt.Println()
+		if result.Match {
+			fmt.Println("MATCH")
+			return nil
+		}
+
+		fmt.Printf("MISMATCH (row count delta: %+d)\n", result.RowDelta)
+		if result.ChangesSince > 0 {
+			fmt.Println("The table changed after the checksum; verify again after the next one.")
+		}
+		os.Exit(2)
+		return nil
+	},
+}
+
+var (
+	addTablesFreq       time.Duration
+	addTablesNoSnapshot bool
+	addTablesKey        []string
+)
+
 var addTablesCmd = &cobra.Command{
 	Use:   "add-tables <name1> [name2] ...",
 	Short: "Add tables to sync whitelist",
 	Long: `Adds one or more tables to the synchronization whitelist.
 
-Only tables in the whitelist will be synced from the archive.`,
+Only tables in the whitelist will be synced from the archive.
+
+With --freq, the tables are synced at that interval instead of
+data_sync_freq (e.g. --freq 10s for a hot table, --freq 1h for reference
+tables). Tables already in the whitelist get the new interval. The
+running daemon picks it up at its next cycle. 'reset-freq' puts tables
+back on data_sync_freq.
+
+A table new to the whitelist is first loaded in full, from
+<archive_dir>/snapshots/<table>.json or else with pg_dump from
+snapshot_source_dsn, and its changes are applied from there; with neither,
+only its changes from now on are applied, with a warning. Pass
+--no-snapshot to only apply the changes from now on.
+
+Rows are matched on the table's primary key. For a table without one, or
+whose rows are identified by other columns, pass them with --key (e.g.
+--key tenant_id,order_no); they need a unique index for the upsert.`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := createLogger()
@@ -321,7 +531,14 @@ Only tables in the whitelist will be synced from the archive.`,
 			return err
 		}
 
-		added, err := tablesyncher.AddTables(ctx, db, args, "", logger)
+		if addTablesFreq%time.Second != 0 {
+			return fmt.Errorf("--freq must be a whole number of seconds: %s", addTablesFreq)
+		}
+		added, err := tablesyncher.AddTablesWithOptions(ctx, db, args, "", tablesyncher.AddTablesOptions{
+			SyncFreq:   int(addTablesFreq / time.Second),
+			Snapshot:   !addTablesNoSnapshot,
+			KeyColumns: addTablesKey,
+		}, logger)
 		if err != nil {
 			return err
 		}
@@ -379,6 +596,46 @@ Removed tables will no longer be synced. Their local data is NOT deleted.`,
 	},
 }
 
+var resetFreqCmd = &cobra.Command{
+	Use:   "reset-freq <name1> [name2] ...",
+	Short: "Sync tables at data_sync_freq again",
+	Long: `Clears the interval 'add-tables --freq' set on tables of the whitelist,
+so that they are synced every data_sync_freq again. The running daemon
+picks it up at its next cycle.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := createLogger()
+		ctx := context.Background()
+
+		config, err := tablesyncher.LoadConfig()
+		if err != nil {
+			return err
+		}
+
+		db, err := connectDB(config)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		reset, err := tablesyncher.ResetTablesFreq(ctx, db, args, logger)
+		if err != nil {
+			return err
+		}
+
+		if len(reset) == 0 {
+			fmt.Println("No tables reset (not in whitelist or already at data_sync_freq)")
+		} else {
+			fmt.Println("Tables synced every data_sync_freq again:")
+			for _, t := range reset {
+				fmt.Printf("  - %s\n", t)
+			}
+		}
+
+		return nil
+	},
+}
+
 var listTablesCmd = &cobra.Command{
 	Use:   "list-tables",
 	Short: "List tables in sync whitelist",
@@ -414,15 +671,25 @@ var listTablesCmd = &cobra.Command{
 		} else {
 			fmt.Printf("Tables in sync whitelist (%d):\n", len(tables))
 			fmt.Println()
-			fmt.Printf("%-30s %-20s %s\n", "TABLE NAME", "CREATOR", "CREATED AT")
-			fmt.Printf("%-30s %-20s %s\n", "----------", "-------", "----------")
+			fmt.Printf("%-30s %-20s %-12s %-20s %s\n", "TABLE NAME", "CREATOR", "FREQUENCY", "KEY", "CREATED AT")
+			fmt.Printf("%-30s %-20s %-12s %-20s %s\n", "----------", "-------", "---------", "---", "----------")
 			for _, t := range tables {
 				creator := t.Creator
 				if creator == "" {
 					creator = "-"
 				}
-				fmt.Printf("%-30s %-20s %s\n", t.TableName, creator, t.CreatedAt.Format("2006-01-02 15:04"))
+				freq := t.Frequency(config.DataSyncFreq).String()
+				if t.SyncFreq == 0 {
+					freq += " *"
+				}
+				key := strings.Join(t.KeyColumns, ",")
+				if key == "" {
+					key = "(primary key)"
+				}
+				fmt.Printf("%-30s %-20s %-12s %-20s %s\n", t.TableName, creator, freq, key, t.CreatedAt.Format("2006-01-02 15:04"))
 			}
+			fmt.Println()
+			fmt.Println("* global data_sync_freq")
 		}
 		fmt.Println()
 
@@ -431,15 +698,29 @@ var listTablesCmd = &cobra.Command{
 }
 
 func init() {
+	startCmd.Flags().StringVar(&startMetricsAddr, "metrics-addr", "", "Also serve Prometheus metrics on this address (e.g. :9188)")
+	serveMetricsCmd.Flags().StringVar(&metricsAddr, "addr", ":9188", "Address to serve the metrics on")
+	syncRangeCmd.Flags().StringVar(&rangeFrom, "from", "", "Start of the range (timestamp or LSN)")
+	syncRangeCmd.Flags().StringVar(&rangeTo, "to", "", "End of the range (timestamp or LSN)")
+	syncRangeCmd.MarkFlagRequired("from")
+	syncRangeCmd.MarkFlagRequired("to")
+	addTablesCmd.Flags().DurationVar(&addTablesFreq, "freq", 0, "Sync interval for these tables (default: data_sync_freq)")
+	addTablesCmd.Flags().BoolVar(&addTablesNoSnapshot, "no-snapshot", false, "Don't load a snapshot of new tables")
+	addTablesCmd.Flags().StringSliceVar(&addTablesKey, "key", nil, "Columns that identify a row (default: primary key)")
+
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output")
 
 	rootCmd.AddCommand(startCmd)
 	rootCmd.AddCommand(stopCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(serveMetricsCmd)
 	rootCmd.AddCommand(clearCmd)
 	rootCmd.AddCommand(resyncCmd)
+	rootCmd.AddCommand(syncRangeCmd)
+	rootCmd.AddCommand(verifyCmd)
 	rootCmd.AddCommand(addTablesCmd)
 	rootCmd.AddCommand(removeTablesCmd)
+	rootCmd.AddCommand(resetFreqCmd)
 	rootCmd.AddCommand(listTablesCmd)
 }
 
@@ -14,11 +14,18 @@
 #
 # Usage:
 #   ./archive_changes.sh [--once] [--tables table1,table2,...]
+#                        [--checksum-tables table1,... [--checksum-every N]]
 #
 # The script outputs JSON change files to $PG_BACKUP_DIR/changes/
 # Each file contains one JSON record per line:
 #   {"table": "users", "op": "INSERT", "data": {...}, "lsn": "0/16B3D40", "ts": "..."}
 #
+# With --checksum-tables, every N-th cycle ends the file with the row count
+# and checksum of each listed table, for 'syncdata verify':
+#   {"table": "users", "op": "CHECKSUM", "checksum": {"row_count": 42, "md5": "..."}, "lsn": "...", "ts": "..."}
+# The checksums are taken in one snapshot and the file holds exactly the
+# changes up to the snapshot's LSN.
+#
 
 set -e
 
@@ -27,6 +34,9 @@ POLL_INTERVAL="${DATA_SYNC_FREQ:-300}"  # Default 5 minutes
 SLOT_NAME="${SYNCDATA_SLOT:-syncdata_slot}"
 RUN_ONCE=false
 TABLE_FILTER=""
+CHECKSUM_TABLES=""
+CHECKSUM_EVERY=12   # Hourly at the default interval
+CYCLE=0
 
 # Parse arguments
 while [[ $# -gt 0 ]]; do
@@ -43,6 +53,14 @@ while [[ $# -gt 0 ]]; do
             SLOT_NAME="$2"
             shift 2
             ;;
+        --checksum-tables)
+            CHECKSUM_TABLES="$2"
+            shift 2
+            ;;
+        --checksum-every)
+            CHECKSUM_EVERY="$2"
+            shift 2
+            ;;
         --interval)
             POLL_INTERVAL="$2"
             shift 2
@@ -55,6 +73,10 @@ while [[ $# -gt 0 ]]; do
             echo "  --tables LIST    Comma-separated list of tables to include"
             echo "  --slot NAME      Replication slot name (default: syncdata_slot)"
             echo "  --interval SEC   Poll interval in seconds (default: 300)"
+            echo "  --checksum-tables LIST"
+            echo "                   Comma-separated list of tables to record checksums of"
+            echo "  --checksum-every N"
+            echo "                   Record checksums every N cycles (default: 12)"
             echo "  -h, --help       Show this help"
             exit 0
             ;;
@@ -104,12 +126,61 @@ log "  Output: $CHANGES_DIR"
 if [[ -n "$TABLE_FILTER" ]]; then
     log "  Tables: $TABLE_FILTER"
 fi
+if [[ -n "$CHECKSUM_TABLES" ]]; then
+    if [[ ! "$CHECKSUM_TABLES" =~ ^[A-Za-z0-9_]+(,[A-Za-z0-9_]+)*$ ]]; then
+        log "Error: --checksum-tables must be a comma-separated list of table names"
+        exit 1
+    fi
+    if [[ ! "$CHECKSUM_EVERY" =~ ^[1-9][0-9]*$ ]]; then
+        log "Error: --checksum-every must be a positive number"
+        exit 1
+    fi
+    log "  Checksums: $CHECKSUM_TABLES (every $CHECKSUM_EVERY cycles)"
+fi
+
+# Writes one CHECKSUM record per table in CHECKSUM_TABLES to $1, all from
+# one snapshot and with its LSN. The checksum must stay identical to
+# tablesyncher.ChecksumQuery.
+write_checksums() {
+    local out_file="$1"
+    local query="WITH l AS (SELECT pg_current_wal_lsn()::text AS lsn, now() AS ts)"
+    local tables
+    IFS=',' read -ra tables <<< "$CHECKSUM_TABLES"
+    local i
+    for i in "${!tables[@]}"; do
+        local t="${tables[$i]}"
+        [[ $i -gt 0 ]] && query="$query UNION ALL"
+        query="$query SELECT json_build_object('table', '$t', 'op', 'CHECKSUM', 'lsn', l.lsn, 'ts', l.ts,"
+        query="$query 'checksum', json_build_object('row_count', c.n, 'md5', c.h)) FROM l,"
+        query="$query (SELECT count(*) AS n, md5(coalesce(string_agg(h, '' ORDER BY h), '')) AS h"
+        query="$query FROM (SELECT md5(to_jsonb(t)::text) AS h FROM \"$t\" t) s) c"
+    done
+
+    psql -h "$PG_HOST" -p "$PG_PORT" -U "$PG_USER_NAME" -d "$PG_DB_NAME" \
+        -t -A -v ON_ERROR_STOP=1 -c "$query" > "$out_file"
+}
 
 # Function to fetch and archive changes
 archive_changes() {
     local timestamp=$(date '+%Y%m%d_%H%M%S')
     local output_file="$CHANGES_DIR/changes_${timestamp}.json"
     local temp_file="$CHANGES_DIR/.changes_${timestamp}.tmp"
+    local checksum_file="$CHANGES_DIR/.checksums_${timestamp}.tmp"
+
+    # Checksums first: the changes are then fetched up to their LSN, so
+    # the file ends in the state the checksums describe
+    local upto_lsn="NULL"
+    rm -f "$checksum_file"
+    if [[ -n "$CHECKSUM_TABLES" && $((CYCLE % CHECKSUM_EVERY)) -eq 0 ]]; then
+        if write_checksums "$checksum_file" && [[ -s "$checksum_file" ]]; then
+            local lsn=$(head -1 "$checksum_file" | python3 -c 'import json,sys; print(json.loads(sys.stdin.readline())["lsn"])')
+            upto_lsn="'$lsn'::pg_lsn"
+        else
+            log "Warning: failed to compute checksums of $CHECKSUM_TABLES"
+            rm -f "$checksum_file"
+        fi
+    fi
+    CYCLE=$((CYCLE + 1))
 
     # Build wal2json options
     local options="'include-timestamp', 'true', 'include-lsn', 'true', 'format-version', '2'"
@@ -122,7 +193,7 @@ archive_changes() {
 
     # Fetch changes using pg_logical_slot_get_changes
     # This consumes the changes (they won't be returned again)
-    local query="SELECT data FROM pg_logical_slot_get_changes('$SLOT_NAME', NULL, NULL, $options);"
+    local query="SELECT data FROM pg_logical_slot_get_changes('$SLOT_NAME', $upto_lsn, NULL, $options);"
 
     # Execute query and transform output to our JSON format
     psql -h "$PG_HOST" -p "$PG_PORT" -U "$PG_USER_NAME" -d "$PG_DB_NAME" \
@@ -174,6 +245,11 @@ for line in sys.stdin:
 
     done
 
+    if [[ -s "$checksum_file" ]]; then
+        cat "$checksum_file" >> "$temp_file"
+    fi
+    rm -f "$checksum_file"
+
     # Check if we got any changes
     if [[ -f "$temp_file" && -s "$temp_file" ]]; then
         mv "$temp_file" "$output_file"
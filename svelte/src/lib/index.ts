@@ -55,3 +55,6 @@ export type {
 	IconListResponse
 } from './types/IconTypes';
 export { AllowedMimeTypes, isAllowedMimeType } from './types/IconTypes';
+
+// Attachment types
+export type { AttachmentDef, AttachmentSweepResult } from './types/AttachmentTypes';
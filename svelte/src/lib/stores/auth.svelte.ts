@@ -505,8 +505,10 @@ function createAuthStore(): AuthStore {
 		first_name: string;
 		last_name: string;
 		is_admin: boolean;
+		// Token from the CAPTCHA widget, required when the server enables it
+		captcha_token?: string;
 	}): Promise<void> {
-		const { email, password, first_name, last_name } = userData;
+		const { email, password, first_name, last_name, captcha_token } = userData;
 
 		// Validate passwords match
 		if (userData.password !== userData.passwordConfirm) {
@@ -533,7 +535,8 @@ function createAuthStore(): AuthStore {
 						email,
 						name: { first: first_name, last: last_name }
 					},
-					password
+					password,
+					captcha_token
 				})
 			});
 
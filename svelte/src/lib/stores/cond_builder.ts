@@ -57,7 +57,7 @@
 // Created: 2025/12/14 by Chen Ding (Qwen generated)
 /////////////////////////////////////////////////
 
-import type { CondDef, NullCondition } from '$lib/types/CommonTypes';
+import type { CondDef, NotCondition, NullCondition } from '$lib/types/CommonTypes';
 
 // Base class for building conditions
 class ConditionBuilder {
@@ -169,6 +169,55 @@ class ConditionBuilder {
 		return this;
 	}
 
+	// Add an atomic ends with condition
+	condSuffix(field_name: string, value: unknown, data_type: string = 'string'): this {
+		this.conditions.push({
+			type: 'atomic',
+			field_name,
+			opr: 'suffix',
+			value,
+			data_type
+		});
+		return this;
+	}
+
+	// Add an atomic case-insensitive contains condition
+	condIContains(field_name: string, value: unknown, data_type: string = 'string'): this {
+		this.conditions.push({
+			type: 'atomic',
+			field_name,
+			opr: 'icontain',
+			value,
+			data_type
+		});
+		return this;
+	}
+
+	// Add an atomic case-insensitive starts with condition
+	condIPrefix(field_name: string, value: unknown, data_type: string = 'string'): this {
+		this.conditions.push({
+			type: 'atomic',
+			field_name,
+			opr: 'iprefix',
+			value,
+			data_type
+		});
+		return this;
+	}
+
+	// Add a case-insensitive contains condition matching any of the fields,
+	// e.g. a search box over name and description
+	condMultiContains(field_names: string[], value: string): this {
+		this.conditions.push({
+			type: 'atomic',
+			field_names,
+			opr: 'multi_contain',
+			value,
+			data_type: 'string'
+		});
+		return this;
+	}
+
 	// Build the final condition object
 	build(): CondDef {
 		if (this.conditions.length === 1) {
@@ -232,6 +281,14 @@ class ConditionConstructor {
 		return new AndCondition();
 	}
 
+	// Negate one condition, e.g. not(or().condEq('status', 'x').condEq('status', 'y'))
+	not(condition: ConditionBuilder | CondDef): NotCondition {
+		return {
+			type: 'not',
+			conditions: [condition instanceof ConditionBuilder ? condition.build() : condition]
+		};
+	}
+
 	null(): NullCondition {
 		return {
 			type: 'null'
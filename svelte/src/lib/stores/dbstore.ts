@@ -211,14 +211,20 @@ class DBStore {
 									if (result.success) {
 										if (embed_schema) {
 											const rr = record as Record<string, unknown>;
-											if (typeof rr[embed_name] !== 'object') {
+											if (rr[embed_name] === null) {
+												// A LEFT JOIN without a match: nothing to validate
+												valid_records.push(rr);
+											} else if (typeof rr[embed_name] !== 'object') {
 												console.warn(
 													`Missing/incorrect embedded object (SHD_DBS_280):${embed_name}, type:${typeof rr[embed_name]}`
 												);
 											} else {
 												const e_schema = embed_schema as z.ZodType;
 												console.log(`Embed object (SHD_DBS_283):${rr[embed_name]}`);
-												const result1 = e_schema.safeParse(rr[embed_name] as unknown);
+												// An array embed validates each of its objects
+												const result1 = Array.isArray(rr[embed_name])
+													? e_schema.array().safeParse(rr[embed_name])
+													: e_schema.safeParse(rr[embed_name] as unknown);
 												if (result1.success) {
 													valid_records.push(record as Record<string, unknown>);
 												} else {
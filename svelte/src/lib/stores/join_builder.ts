@@ -124,6 +124,16 @@ class JoinBuilder {
 		return this;
 	}
 
+	// Embed the joined rows as an array, one result per parent row. The
+	// groupBy fields (qualified, e.g. 'users.id') identify a parent row
+	// and must be selected by the query.
+	embedAsArray(embedName: string, ...groupBy: string[]): this {
+		this.joinDef.embed_name = embedName;
+		this.joinDef.embed_as_array = true;
+		this.joinDef.group_by = groupBy;
+		return this;
+	}
+
 	// Build the final join definition
 	build(): JoinDef {
 		return this.joinDef;
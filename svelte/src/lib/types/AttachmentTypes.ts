@@ -0,0 +1,22 @@
+import type { IsoAutoDateString } from './DatabaseTypes';
+
+// Make sure it syncs with shared/go/api/ApiTypes/attachments_types.go::AttachmentDef
+export interface AttachmentDef {
+	id: string;
+	owner_table: string;
+	owner_id: string;
+	file_name: string;
+	mime_type: string;
+	size: number;
+	storage_backend: string;
+	sha256: string;
+	creator: string;
+	created_at: IsoAutoDateString;
+}
+
+// Make sure it syncs with shared/go/api/ApiTypes/attachments_types.go::AttachmentSweepResult
+export interface AttachmentSweepResult {
+	objects_deleted: number;
+	rows_deleted: number;
+	dry_run: boolean;
+}
@@ -15,7 +15,7 @@ export enum RequestType {
 	Query = 'query'
 }
 
-type CondOperator = '=' | '<>' | '>' | '>=' | '<' | '<=' | 'contain' | 'prefix';
+type CondOperator = '=' | '<>' | '>' | '>=' | '<' | '<=' | 'contain' | 'prefix' | 'suffix' | 'icontain' | 'iprefix';
 
 // Make sure it syncs with go/api/ApiTypes/ApiTypes.go::FieldDef
 export type FieldDef = {
@@ -25,6 +25,9 @@ export type FieldDef = {
 	read_only?: boolean;
 	element_type?: string;
 	desc?: string;
+	timestamp_kind?: 'tz' | 'naive';
+	// Query result format: 'rfc3339' (default), 'unix', 'unix_ms' or a Go layout
+	timestamp_format?: string;
 };
 
 // Make sure it syncs with go/api/ApiTypes/ApiTypes.go::UpdateDef
@@ -50,8 +53,13 @@ export interface JoinDef {
 	joined_field_defs: FieldDef[];
 	on_clause: OnClauseDef[];
 	join_type: string;
+	// ['*'] selects all joined_field_defs
 	selected_fields: string[];
 	embed_name?: string;
+	// Returns the joined rows as an array under embed_name, one result
+	// per parent row; group_by names the selected parent key fields
+	embed_as_array?: boolean;
+	group_by?: string[];
 }
 
 // Make sure it syncs with go/api/ApiTypes/ApiTypes.go::OrderbyDef
@@ -70,6 +78,15 @@ export interface AtomicCondition {
 	data_type: string;
 }
 
+// Case-insensitive search of 'value' in any of 'field_names'
+export interface MultiContainCondition {
+	type: 'atomic';
+	field_names: string[];
+	opr: 'multi_contain';
+	value: string;
+	data_type: 'string';
+}
+
 export interface NullCondition {
 	type: 'null';
 }
@@ -79,7 +96,34 @@ export interface GroupCondition {
 	conditions: CondDef[];
 }
 
-export type CondDef = AtomicCondition | GroupCondition | NullCondition;
+export interface NotCondition {
+	type: 'not';
+	conditions: [CondDef];
+}
+
+// Make sure it syncs with go/api/ApiTypes/ApiTypes.go::SubQueryDef
+// source_field_name in on_clause is a field of the queried table,
+// joined_field_name a field of table_name
+export interface SubQueryDef {
+	table_name: string;
+	on_clause: OnClauseDef[];
+	field_defs: FieldDef[];
+	// Cannot have exists conditions itself
+	condition?: CondDef;
+}
+
+export interface ExistsCondition {
+	type: 'exists' | 'not_exists';
+	sub_query: SubQueryDef;
+}
+
+export type CondDef =
+	| AtomicCondition
+	| MultiContainCondition
+	| GroupCondition
+	| NotCondition
+	| ExistsCondition
+	| NullCondition;
 
 export type UpdateWithCondDef = {
 	condition: CondDef[];
@@ -104,33 +148,100 @@ export type DeleteRequest = {
 	table_name: string;
 	condition: CondDef;
 	field_defs?: Record<string, unknown>[];
+	cascade?: CascadeDef[];
 	loc: string;
+	// Refuse the delete, returning matched_rows, if it matches more rows
+	expected_max_rows?: number;
+	// Delete all the rows; takes no condition
+	confirm_delete_all?: boolean;
+};
+
+// Children deleted before the parent rows; see DeleteRequest.cascade
+// Make sure it syncs with go/api/ApiTypes/ApiTypes.go::CascadeDef
+export type CascadeDef = {
+	table: string;
+	fk_field: string;
+	parent_field: string;
+	field_defs: Record<string, unknown>[];
 };
 
 // Make sure it syncs with go/api/ApiTypes/ApiTypes.go::QueryRequest
 export type QueryRequest = {
 	request_type: string;
+	resource_name?: string;
 	db_name: string;
 	table_name: string;
 	condition: CondDef;
 	join_def: JoinDef[];
 	field_defs: Record<string, unknown>[];
+	// Qualified names (table.field[:alias]), or ['*'] for all field_defs
 	field_names: string[];
 	orderby_def: OrderbyDef[];
 	start: number;
 	page_size: number;
+	// Random sample of this many rows; excludes start and orderby_def
+	sample?: number;
+	// Also return the total number of matching rows; excludes sample
+	with_total?: boolean;
+	// Isolation level of the with_total transaction: repeatable_read
+	// (the default) or serializable
+	isolation_level?: IsolationLevel;
+	// IANA zone for timestamp results and offset-less timestamp values
+	time_zone?: string;
 	loc: string;
+	// An embed whose fields are all null (e.g. a LEFT JOIN without a
+	// match) is null, or {} if set
+	empty_embed_as_object?: boolean;
+	// Per time bucket aggregates instead of rows; results are TimeBucket[]
+	time_bucket?: TimeBucketDef;
+	// Admins only: the query's plan (EXPLAIN in JSON) is returned in
+	// results instead of its rows; excludes time_bucket
+	explain?: boolean;
+};
+
+// Make sure it syncs with go/api/ApiTypes/ApiTypes.go::TimeBucketDef
+export type TimeBucketDef = {
+	// A timestamp field
+	field_name: string;
+	interval: 'minute' | 'hour' | 'day' | 'week' | 'month';
+	// Default 'count'
+	agg?: 'count' | 'sum';
+	// The numeric field summed
+	value_field?: string;
+	// Add empty buckets between the first and the last
+	fill_gaps?: boolean;
+};
+
+// A result row of a time bucket query: the bucket start (RFC 3339, in
+// the request's time zone) and its count or sum
+export type TimeBucket = {
+	bucket: string;
+	value: number;
+};
+
+// A QueryRequest whose results are exported as CSV (jimo_export_csv).
+// Make sure it syncs with go/api/ApiTypes/ApiTypes.go::ExportCSVRequest
+export type ExportCSVRequest = QueryRequest & {
+	// One character, default ','
+	delimiter?: string;
+	// Omit the header row
+	no_header?: boolean;
 };
 
 // Make sure it syncs with go/api/ApiTypes/ApiTypes.go::InsertRequest
 export type InsertRequest = {
 	request_type: string;
+	resource_name?: string;
 	db_name: string;
 	table_name: string;
 	records: Record<string, unknown>[];
 	field_defs: Record<string, unknown>[];
 	on_conflict_cols: string[];
 	on_conflict_update_cols: string[];
+	strict_fields?: boolean;
+	time_zone?: string;
+	idempotency_key?: string;
+	isolation_level?: IsolationLevel;
 	loc: string;
 };
 
@@ -141,27 +252,71 @@ export type UpdateRequest = {
 	table_name: string;
 	condition: CondDef;
 	record: Record<string, unknown>;
+	// Was read as 'update_def' by the server, which dropped what this
+	// client sent. Unknown fields are now rejected, so a caller still
+	// sending 'update_def' gets a 400 and must rename it.
 	update_entries: UpdateDef[];
 	field_defs?: Record<string, unknown>[];
 	on_conflict_cols: string[];
 	on_conflict_update_cols: string[];
 	need_record: boolean;
+	strict_fields?: boolean;
+	time_zone?: string;
 	loc: string;
 };
 
+// Make sure it syncs with go/api/ApiTypes/ApiTypes.go::FieldProblem
+export type FieldProblem = {
+	record_index: number; // -1 if unknown (a constraint violation of a batch)
+	field: string;
+	problem: string;
+};
+
+// Make sure it syncs with go/api/ApiTypes/ApiTypes.go::QuotaBreach
+export type QuotaBreach = {
+	error_code: 'quota_exceeded';
+	scope_type: 'user' | 'table';
+	scope_name: string;
+	metric: 'queries' | 'rows' | 'writes';
+	limit: number;
+	used: number;
+	retry_after_sec: number;
+};
+
+// Make sure it syncs with go/api/ApiTypes/ApiTypes.go::QuotaUsage
+export type QuotaUsage = {
+	scope_type: 'user' | 'table';
+	scope_name: string;
+	metric: 'queries' | 'rows' | 'writes';
+	window: string;
+	used: number;
+	limit: number;
+};
+
 export type JsonObjectOrArray = { [key: string]: unknown } | unknown[];
 
 // Make sure it syncs with go/api/ApiTypes/ApiTypes.go::JimoResponse
 export type JimoResponse = {
 	status: boolean;
 	error_msg: string;
+	// Set on failures; stable, unlike error_msg
+	error_kind?: ErrorKind;
 	req_id?: string;
 	result_type: string;
 	error_code: number;
 	table_name: string;
 	base_url: string;
 	num_records: number;
+	// Set when the request has with_total
+	total_records?: number;
+	// Set for offset-paginated queries; page is from 1. total_pages is
+	// set when the request has with_total
+	page?: number;
+	page_size?: number;
+	total_pages?: number;
+	has_more?: boolean;
 	results: JsonObjectOrArray | string;
+	meta?: Record<string, unknown>;
 	loc: string;
 };
 
@@ -174,9 +329,38 @@ export const CustomHttpStatus = {
 	InternalError: 554,
 	ServerException: 555,
 	KeyNotUnique: 556,
-	NotLoggedIn: 557
+	NotLoggedIn: 557,
+	PasswordNotSet: 558,
+	TwoFactorRequired: 559,
+	EmailNotVerified: 560,
+	AccountDisabled: 561
+} as const;
+
+// Make sure sync the changes to Shared/go/api/ApiTypes/enums.go
+export const ErrorKind = {
+	InvalidRequest: 'invalid_request',
+	NotAuthenticated: 'not_authenticated',
+	NotFound: 'not_found',
+	ValidationFailed: 'validation_failed',
+	QuotaExceeded: 'quota_exceeded',
+	DBError: 'db_error',
+	Timeout: 'timeout',
+	Conflict: 'conflict',
+	InternalError: 'internal_error'
 } as const;
 
+export type ErrorKind = (typeof ErrorKind)[keyof typeof ErrorKind];
+
+// Make sure sync the changes to Shared/go/api/ApiTypes/enums.go
+export const IsolationLevel = {
+	ReadUncommitted: 'read_uncommitted', // MySQL only
+	ReadCommitted: 'read_committed',
+	RepeatableRead: 'repeatable_read',
+	Serializable: 'serializable'
+} as const;
+
+export type IsolationLevel = (typeof IsolationLevel)[keyof typeof IsolationLevel];
+
 export type ResourceDef = {
 	resource_name: string;
 	resource_type: string;
@@ -209,11 +393,8 @@ export type UserInfo = {
 	user_status?: string;
 	avatar?: FileNameString;
 	locale?: string;
-	// outlook_refresh_token - EXCLUDED: Never sent to client (json:"-" in Go)
-	// outlook_access_token - EXCLUDED: Never sent to client (json:"-" in Go)
-	outlook_token_expires_at?: IsoDateString;
-	outlook_sub_id?: string;
-	outlook_sub_expires_at?: IsoDateString;
+	// outlook_* - EXCLUDED: The Outlook tokens and subscription are kept by
+	// the outlook package on the server (sysdatastores.GetUserOutlook)
 	// v_token - EXCLUDED: Never sent to client (json:"-" in Go)
 	v_token_expires_at?: IsoDateString;
 	created?: IsoAutoDateString;